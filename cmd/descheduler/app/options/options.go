@@ -18,6 +18,8 @@
 package options
 
 import (
+	"time"
+
 	clientset "k8s.io/client-go/kubernetes"
 
 	// install the componentconfig api so we get its defaulting and conversion functions
@@ -26,12 +28,39 @@ import (
 	deschedulerscheme "sigs.k8s.io/descheduler/pkg/descheduler/scheme"
 
 	"github.com/spf13/pflag"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/strategies"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
 // DeschedulerServer configuration
 type DeschedulerServer struct {
 	componentconfig.DeschedulerConfiguration
 	Client clientset.Interface
+	// UseMetricsUsage makes utilization strategies evaluate nodes on usage of lower clusters reported by their
+	// metrics-server and published by virtual nodes
+	UseMetricsUsage bool
+	// DynamicConfig is the name of the TensileConfig whose descheduler config is applied live over the flags
+	DynamicConfig string
+	// MaintenanceWindows restrict when eviction strategies may run, any time if empty
+	MaintenanceWindows []string
+	// MaintenanceTimeZone is the time zone the maintenance windows are evaluated in, local if empty
+	MaintenanceTimeZone string
+	// Rebalance is the args of strategy HotClusterRebalance
+	Rebalance strategies.RebalanceArgs
+	// Pending is the args of strategy PendingTimeout
+	Pending strategies.PendingArgs
+	// LeaderElection makes only the leader of the replicas evict pods
+	LeaderElection util.LeaderElection
+	// UseEvictionAPI evicts pods by the eviction subresource respecting their disruption budgets instead of
+	// deleting them
+	UseEvictionAPI bool
+	// MaxNoOfPodsToEvictPerNamespace limits the pods evicted per namespace in each descheduling, unlimited if 0
+	MaxNoOfPodsToEvictPerNamespace int
+	// StrategyMaxPodsPerNode and StrategyMaxPodsPerNamespace limit the pods evicted by each strategy per node and
+	// per namespace in each descheduling
+	StrategyMaxPodsPerNode      map[string]int
+	StrategyMaxPodsPerNamespace map[string]int
 }
 
 // NewDeschedulerServer creates a new DeschedulerServer with default parameters
@@ -42,6 +71,16 @@ func NewDeschedulerServer() *DeschedulerServer {
 	deschedulerscheme.Scheme.Convert(versioned, &cfg, nil)
 	s := DeschedulerServer{
 		DeschedulerConfiguration: cfg,
+		Rebalance: strategies.RebalanceArgs{
+			EvictPercentage:        10,
+			UnschedulableThreshold: 10,
+			ExcludedNamespaces:     []string{"kube-system"},
+		},
+		Pending: strategies.PendingArgs{Timeout: 15 * time.Minute},
+		LeaderElection: util.LeaderElection{
+			Namespace: util.DefaultLeaderElectionNamespace,
+			Name:      "tensile-descheduler",
+		},
 	}
 	return &s
 }
@@ -58,4 +97,27 @@ func (rs *DeschedulerServer) AddFlags(fs *pflag.FlagSet) {
 	fs.IntVar(&rs.MaxNoOfPodsToEvictPerNode, "max-pods-to-evict-per-node", rs.MaxNoOfPodsToEvictPerNode, "Limits the maximum number of pods to be evicted per node by descheduler")
 	// evict-local-storage-pods allows eviction of pods that are using local storage. This is false by default.
 	fs.BoolVar(&rs.EvictLocalStoragePods, "evict-local-storage-pods", rs.EvictLocalStoragePods, "Enables evicting pods using local storage by descheduler")
+	// use-metrics-usage makes LowNodeUtilization use the actual usage of lower clusters rather than requests of pods.
+	fs.BoolVar(&rs.UseMetricsUsage, "use-metrics-usage", rs.UseMetricsUsage, "Evaluate virtual node utilization on the actual usage of lower clusters reported by their metrics-server and published by virtual nodes, instead of pod requests")
+	// dynamic-config replaces the strategies and max-pods-to-evict-per-node live by the TensileConfig of the name.
+	fs.StringVar(&rs.DynamicConfig, "dynamic-config", rs.DynamicConfig, "Name of the TensileConfig whose descheduler config, the strategies and max pods to evict per node, is applied live over the flags")
+	// maintenance-windows restricts descheduling to the windows, a cron expression and a duration each, e.g. "0 2 * * 1-5 3h".
+	fs.StringArrayVar(&rs.MaintenanceWindows, "maintenance-windows", rs.MaintenanceWindows, "Windows eviction strategies may run in, each is a cron expression of 5 fields followed by a duration, e.g. \"0 2 * * 1-5 3h\" for 2:00-5:00 on weekdays, repeat the flag for more windows, any time if not set")
+	fs.StringVar(&rs.MaintenanceTimeZone, "maintenance-time-zone", rs.MaintenanceTimeZone, "Time zone the maintenance windows are evaluated in, e.g. Asia/Shanghai, local time zone if not set")
+	// rebalance-* configure the HotClusterRebalance strategy evicting pods from virtual nodes of hot lower clusters.
+	fs.IntVar(&rs.Rebalance.EvictPercentage, "rebalance-evict-percentage", rs.Rebalance.EvictPercentage, "Percentage of evictable pods HotClusterRebalance evicts from a virtual node whose lower cluster is hot in each descheduling")
+	fs.IntVar(&rs.Rebalance.UnschedulableThreshold, "rebalance-unschedulable-threshold", rs.Rebalance.UnschedulableThreshold, "Pods unschedulable in a lower cluster making it hot for HotClusterRebalance besides pressure conditions of the virtual node, 0 means only pressure conditions are taken into account")
+	fs.StringSliceVar(&rs.Rebalance.ExcludedNamespaces, "rebalance-excluded-namespaces", rs.Rebalance.ExcludedNamespaces, "Namespaces whose pods are never evicted by HotClusterRebalance")
+	fs.BoolVar(&rs.Rebalance.DryRun, "rebalance-dry-run", rs.Rebalance.DryRun, "Only log the pods HotClusterRebalance would evict")
+	// pending-timeout configures the PendingTimeout strategy evicting pods never started by lower clusters.
+	fs.DurationVar(&rs.Pending.Timeout, "pending-timeout", rs.Pending.Timeout, "How long pods could stay Pending on virtual nodes after bound before PendingTimeout evicts them, pods annotated with "+util.EvictPending+"=false are never evicted")
+	// leader-elect-* make the replicas of descheduler elect the one evicting pods by a lease.
+	fs.BoolVar(&rs.LeaderElection.LeaderElect, "leader-elect", rs.LeaderElection.LeaderElect, "Start a leader election client and gain leadership before descheduling, enable it when running replicated descheduler for high availability")
+	fs.StringVar(&rs.LeaderElection.Namespace, "leader-elect-resource-namespace", rs.LeaderElection.Namespace, "Namespace of the lease locked during leader election")
+	fs.StringVar(&rs.LeaderElection.Name, "leader-elect-resource-name", rs.LeaderElection.Name, "Name of the lease locked during leader election")
+	// use-eviction-api and the limits of evictions make descheduling respect the availability of workloads.
+	fs.BoolVar(&rs.UseEvictionAPI, "use-eviction-api", rs.UseEvictionAPI, "Evict pods by the eviction subresource so that their PodDisruptionBudgets are honored, pods refused are kept, instead of deleting them")
+	fs.IntVar(&rs.MaxNoOfPodsToEvictPerNamespace, "max-pods-to-evict-per-namespace", rs.MaxNoOfPodsToEvictPerNamespace, "Limits the maximum number of pods to be evicted per namespace in each descheduling, unlimited if 0")
+	fs.StringToIntVar(&rs.StrategyMaxPodsPerNode, "strategy-max-pods-to-evict-per-node", rs.StrategyMaxPodsPerNode, "Limits the maximum number of pods evicted by a strategy per node in each descheduling, in <strategy>=<number>, e.g. HotClusterRebalance=2")
+	fs.StringToIntVar(&rs.StrategyMaxPodsPerNamespace, "strategy-max-pods-to-evict-per-namespace", rs.StrategyMaxPodsPerNamespace, "Limits the maximum number of pods evicted by a strategy per namespace in each descheduling, in <strategy>=<number>")
 }
@@ -23,6 +23,7 @@ import (
 
 	"github.com/virtual-kubelet/tensile-kube/cmd/descheduler/app/options"
 	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler"
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/strategies"
 
 	"github.com/spf13/cobra"
 
@@ -31,8 +32,19 @@ import (
 	"k8s.io/klog"
 )
 
-// NewDeschedulerCommand creates a *cobra.Command object with default parameters
-func NewDeschedulerCommand(out io.Writer) *cobra.Command {
+// Option configures the registry of strategies
+type Option func(strategies.Registry) error
+
+// WithStrategy registers a strategy built out of tree, it could be enabled in the policy by the name
+func WithStrategy(name string, factory strategies.StrategyFactory) Option {
+	return func(registry strategies.Registry) error {
+		return registry.Register(name, factory)
+	}
+}
+
+// NewDeschedulerCommand creates a *cobra.Command object with default parameters and the strategies
+// registered by registryOptions
+func NewDeschedulerCommand(out io.Writer, registryOptions ...Option) *cobra.Command {
 	s := options.NewDeschedulerServer()
 	cmd := &cobra.Command{
 		Use:   "descheduler",
@@ -41,7 +53,7 @@ func NewDeschedulerCommand(out io.Writer) *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			logs.InitLogs()
 			defer logs.FlushLogs()
-			err := Run(s)
+			err := Run(s, registryOptions...)
 			if err != nil {
 				klog.Errorf("%v", err)
 			}
@@ -57,6 +69,12 @@ func NewDeschedulerCommand(out io.Writer) *cobra.Command {
 }
 
 // Run starts run the descheduler
-func Run(rs *options.DeschedulerServer) error {
-	return descheduler.Run(rs)
+func Run(rs *options.DeschedulerServer, registryOptions ...Option) error {
+	outOfTree := strategies.Registry{}
+	for _, option := range registryOptions {
+		if err := option(outOfTree); err != nil {
+			return err
+		}
+	}
+	return descheduler.Run(rs, outOfTree)
 }
@@ -0,0 +1,662 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package app runs the virtual node of a client cluster, and the virtual nodes of members
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	cli "github.com/virtual-kubelet/node-cli"
+	logruscli "github.com/virtual-kubelet/node-cli/logrus"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	logruslogger "github.com/virtual-kubelet/virtual-kubelet/log/logrus"
+	"golang.org/x/time/rate"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
+	"github.com/virtual-kubelet/tensile-kube/pkg/controllers"
+	"github.com/virtual-kubelet/tensile-kube/pkg/manager"
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+	k8sprovider "github.com/virtual-kubelet/tensile-kube/pkg/provider"
+	"github.com/virtual-kubelet/tensile-kube/pkg/translation"
+)
+
+var (
+	buildVersion         = "N/A"
+	buildTime            = "N/A"
+	k8sVersion           = "v1.14.3"
+	numberOfWorkers      = 50
+	ignoreLabels         = ""
+	enableControllers    = ""
+	enableServiceAccount = true
+	providerName         = "k8s"
+	completedPodTTL      time.Duration
+	imagePrePullTTL      time.Duration
+	orphanGCPeriod       time.Duration
+	orphanGCDryRun       bool
+	membersConfig        = ""
+	managerListenAddress = ""
+	metricsListenAddress = ""
+	daemonPort           int32
+	dynamicConfig        = ""
+	translationHooks     []string
+	translationTimeout   time.Duration
+	clientKubeConfigs    []string
+	placement            = k8sprovider.FirstFit
+	leaderElection       = util.LeaderElection{Namespace: util.DefaultLeaderElectionNamespace}
+	drain                bool
+)
+
+const (
+	// drainInterval is the interval to check the drain annotation and to retry evictions while draining
+	drainInterval = 10 * time.Second
+	// membersReloadPeriod is the period to reload --members-config for members added, changed or removed
+	membersReloadPeriod = 30 * time.Second
+)
+
+// NewProviderCommand returns the command running the virtual node, the flags are parsed by node-cli
+func NewProviderCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                "provider",
+		Short:              "Run the virtual node of a client cluster",
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// node-cli falls back to the args of the process if nil
+			if args == nil {
+				args = []string{}
+			}
+			return Run(cli.ContextWithCancelOnSignal(context.Background()), args...)
+		},
+	}
+}
+
+// Run parses the args and runs the virtual node until ctx done
+func Run(ctx context.Context, args ...string) error {
+	// the virtual node exits once drained
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var cc k8sprovider.ClientConfig
+	flags := pflag.NewFlagSet("client", pflag.ContinueOnError)
+	flags.IntVar(&cc.KubeClientBurst, "client-burst", 1000, "qpi burst for client cluster.")
+	flags.IntVar(&cc.KubeClientQPS, "client-qps", 500, "qpi qps for client cluster.")
+	flags.StringVar(&cc.ClientKubeConfigPath, "client-kubeconfig", "",
+		"kube config for client cluster, required unless --pull-mode is set.")
+	flags.BoolVar(&cc.PullMode, "pull-mode", false,
+		"run in the client cluster and pull pods from the upper cluster, in-cluster config is used for client cluster.")
+	flags.StringVar(&cc.SnapshotPath, "snapshot-path", "",
+		"file to persist snapshot of pods in client cluster for fast restarts, disabled if not set.")
+	flags.BoolVar(&cc.EnableImpersonation, "enable-impersonation", false,
+		"operate pods in client cluster impersonating the user recorded in annotation "+
+			util.ImpersonateUser+" of the namespace, only pods are written impersonating.")
+	flags.StringSliceVar(&cc.ImpersonationUsers, "impersonation-users", nil,
+		"users allowed to impersonate, required with --enable-impersonation, system users are always rejected.")
+	flags.StringSliceVar(&cc.ImpersonationGroups, "impersonation-groups", nil,
+		"groups allowed to impersonate, system groups are always rejected.")
+	flags.StringVar(&cc.TunnelListenAddress, "tunnel-listen-address", "",
+		"address to accept tunnel agents of client cluster, client cluster is connected by reverse tunnel if set.")
+	flags.StringVar(&cc.TunnelToken, "tunnel-token", os.Getenv("TUNNEL_TOKEN"), "token to authenticate tunnel agents.")
+	flags.StringVar(&cc.TunnelCertFile, "tunnel-cert", "", "tls cert of the tunnel server, required with --tunnel-listen-address.")
+	flags.StringVar(&cc.TunnelKeyFile, "tunnel-key", "", "tls key of the tunnel server, required with --tunnel-listen-address.")
+	flags.StringSliceVar(&cc.ClientEndpoints, "client-endpoints", nil,
+		"apiserver endpoints of the same client cluster to fail over between, server in client kubeconfig is kept "+
+			"as the last candidate. Multiple kubeconfig contexts are not supported.")
+	flags.Float64Var(&cc.CPUOvercommitRatio, "cpu-overcommit-ratio", 1,
+		"ratio applied to cpu capacity of client cluster nodes when computing virtual node allocatable, must be larger than 0.")
+	flags.Float64Var(&cc.MemoryOvercommitRatio, "memory-overcommit-ratio", 1,
+		"ratio applied to memory capacity of client cluster nodes when computing virtual node allocatable, must be larger than 0.")
+	flags.StringVar(&cc.ClusterName, "cluster-name", "",
+		"name of client cluster exposed to pods by annotation "+util.ClusterName+" and labeled on the virtual node, "+
+			"virtual node name is used if not set.")
+	flags.StringVar(&cc.ClusterRegion, "cluster-region", "",
+		"region of client cluster exposed to pods by annotation "+util.ClusterRegion+".")
+	flags.StringVar(&cc.NetworkZone, "network-zone", "",
+		"network zone of client cluster labeled on the virtual node by "+util.NetworkZone+", the scheduler prefers "+
+			"the clusters close to the pods a pod depends on.")
+	flags.IntVar(&cc.MaxVersionSkew, "max-version-skew", 3,
+		"max minor versions client cluster and master cluster could differ, larger skews are reported by condition "+
+			"VersionSkew of the virtual node.")
+	flags.BoolVar(&cc.MirrorEvents, "mirror-events", false,
+		"mirror events of pods in client cluster to the upper pods, repeated events are aggregated.")
+	flags.StringVar(&cc.AddressTranslation, "address-translation", "",
+		"address pods in client cluster are reachable at from master cluster, published in --address-target, "+
+			"HostIP publishes the node ip and host ports, NodePort and LoadBalancer expose each pod by a service of "+
+			"the type and publish the node ip and node ports or the ingress and ports, Gateway publishes "+
+			"--gateway-address and container ports, disabled if not set.")
+	flags.StringVar(&cc.AddressTarget, "address-target", k8sprovider.AddressTargetAnnotation,
+		"where addresses translated by --address-translation are published, Annotation publishes them in "+
+			"annotation "+util.ReachableAddress+" of pods, Status replaces pod ip and host ip of pods besides.")
+	flags.StringVar(&cc.GatewayAddress, "gateway-address", "",
+		"address of the gateway of client cluster published by --address-translation Gateway.")
+	flags.IntVar(&cc.PodOperationConcurrency, "pod-operation-concurrency", 0,
+		"pods created, updated and deleted in client cluster at the same time, the others wait, unlimited if 0.")
+	flags.Float32Var(&cc.PodOperationQPS, "pod-operation-qps", 0,
+		"rate pods are created, updated and deleted in client cluster at, unlimited if 0.")
+	flags.IntVar(&cc.PodOperationBurst, "pod-operation-burst", 100,
+		"pod operations started in client cluster in a burst above --pod-operation-qps.")
+	flags.Int64Var(&cc.PodDeletionGracePeriod, "pod-deletion-grace-period", -1,
+		"grace period in seconds of pods deleted in client cluster, the one of the upper pod is propagated if negative.")
+	flags.BoolVar(&cc.ForceDeletePods, "force-delete-pods", false,
+		"delete pods in client cluster immediately and remove finalizer "+util.PodFinalizer+" of upper pods at once, "+
+			"instead of holding upper pods until pods in client cluster are gone.")
+	flags.IntVar(&cc.MirrorEventBurst, "mirror-event-burst", 25,
+		"events mirrored of each pod in a burst, further events are dropped until refilled.")
+	flags.DurationVar(&cc.MirrorEventInterval, "mirror-event-interval", 5*time.Minute,
+		"interval to refill one event mirrored of each pod.")
+	flags.StringVar(&cc.UpperClusterName, "upper-cluster-name", "",
+		"name of upper cluster labeled on pods in client cluster by "+util.OriginCluster+", omitted if not set.")
+	flags.StringVar(&cc.AlertWebhookURL, "alert-webhook-url", "",
+		"url to post alerts of sync failures lasting longer than --alert-threshold to in json, disabled if not set.")
+	flags.BoolVar(&cc.AlertEvents, "alert-events", false,
+		"record alerts of sync failures lasting longer than --alert-threshold as warning events on upper pods.")
+	flags.DurationVar(&cc.AlertThreshold, "alert-threshold", 10*time.Minute,
+		"sync failures lasting longer than it are alerted, e.g. pods failing to be created in client cluster.")
+	flags.StringVar(&cc.ConflictResolution, "conflict-resolution", string(conflict.Force),
+		"resolution of objects in client cluster reverted by another writer, Force keeps restoring, BackOff restores at "+
+			"most once a doubling period up to --conflict-window, Alert stops restoring and alerts until the upper object changes.")
+	flags.IntVar(&cc.ConflictThreshold, "conflict-threshold", 5,
+		"reverts by another writer within --conflict-window making an object in client cluster conflicting.")
+	flags.DurationVar(&cc.ConflictWindow, "conflict-window", 10*time.Minute,
+		"window counting the reverts by another writer.")
+	flags.StringSliceVar(&cc.LowerOwnedFields, "lower-owned-fields", nil,
+		"fields of pods in client cluster owned by it, e.g. set by its admission webhooks, never rewritten by the "+
+			"upper pods, each in labels:<key>, annotations:<key>, images:<container> or tolerations, a key or "+
+			"container ending with * matches the prefix.")
+	flags.StringVar(&cc.CapacityCalculator, "capacity-calculator", common.Sum,
+		"calculator of the resources advertised by the virtual node from client cluster nodes, Sum sums the free "+
+			"resources, SumMinusReserved keeps back --capacity-reserved from the sum, MaxSinglePod advertises the "+
+			"largest free resources of one node, PercentileOfFree the --capacity-percentile of free resources of "+
+			"nodes times the number of nodes.")
+	flags.StringToStringVar(&cc.CapacityReserved, "capacity-reserved", nil,
+		"resources kept back from the virtual node by --capacity-calculator SumMinusReserved, e.g. cpu=4,memory=16Gi.")
+	flags.Float64Var(&cc.CapacityPercentile, "capacity-percentile", 50,
+		"percentile of free resources of nodes used by --capacity-calculator PercentileOfFree, in (0, 100].")
+	flags.StringVar(&cc.ReservedPodsExclude, "reserved-pods-exclude", "",
+		"label selector of pods created in client cluster directly whose requests are not kept back from the "+
+			"virtual node, e.g. tier=best-effort, requests of all of them are kept back if not set.")
+	flags.DurationVar(&cc.RescheduleUnschedulableAfter, "reschedule-unschedulable-after", 0,
+		"pods unschedulable in client cluster longer than it are failed, so their controllers create replacements "+
+			"scheduled elsewhere, disabled if 0.")
+	flags.DurationVar(&cc.UnfitDuration, "unfit-duration", 10*time.Minute,
+		"duration the virtual node is unfit for pods of the same controller as a pod rescheduled by "+
+			"--reschedule-unschedulable-after.")
+	flags.StringToStringVar(&cc.PriorityClassMapping, "priority-class-mapping", nil,
+		"priority classes of pods renamed in client cluster, e.g. high=lower-high,low= drops the class low.")
+	flags.StringVar(&cc.NamespacePrefix, "namespace-prefix", "",
+		"prefix of the namespaces in client cluster the namespaces of the upper cluster are translated into, "+
+			"namespaces are kept if not set.")
+	flags.StringToStringVar(&cc.NamespaceMapping, "namespace-mapping", nil,
+		"namespaces of the upper cluster translated into the namespaces of client cluster, e.g. prod=team-a-prod, "+
+			"takes precedence over --namespace-prefix.")
+	flags.BoolVar(&cc.SyncPriorityClasses, "sync-priority-classes", false,
+		"create the priority classes of pods not in --priority-class-mapping in client cluster if absent.")
+	flags.BoolVar(&cc.UpperServiceAccountTokens, "upper-service-account-tokens", false,
+		"inject service account tokens requested from the upper cluster into pods in client cluster instead of the "+
+			"ones minted by client cluster, the tokens are rotated before they expire.")
+	flags.StringVar(&ignoreLabels, "ignore-labels", util.BatchPodLabel,
+		fmt.Sprintf("ignore-labels are the labels we would like to ignore when build pod for client clusters, "+
+			"usually these labels will infulence schedule, default %v, multi labels should be seperated by comma(,"+
+			")", util.BatchPodLabel))
+	flags.StringVar(&enableControllers, "enable-controllers", "PVControllers,ServiceControllers",
+		"support PVControllers,ServiceControllers,HPAControllers,PDBControllers,EndpointSliceControllers,"+
+			"ImagePrePullControllers, default, PVControllers and ServiceControllers")
+
+	flags.BoolVar(&enableServiceAccount, "enable-serviceaccount", true,
+		"enable service account for pods, like spark driver, mpi launcher")
+	flags.DurationVar(&completedPodTTL, "completed-pod-ttl", 0,
+		"ttl of Succeeded/Failed pods kept in client cluster after their status synced to master, "+
+			"0 means never clean them up")
+	flags.DurationVar(&imagePrePullTTL, "image-pre-pull-ttl", 10*time.Minute,
+		"ttl of the DaemonSets pre-pulling images of pods annotated with "+util.PrePullImages+"=true in client "+
+			"cluster by ImagePrePullControllers, they are deleted once the images are pulled on all nodes or expired.")
+	flags.DurationVar(&orphanGCPeriod, "orphan-gc-period", 0,
+		"period to delete the objects synced into client cluster whose upper objects are gone, objects created "+
+			"within a period are kept, disabled if 0.")
+	flags.BoolVar(&orphanGCDryRun, "orphan-gc-dry-run", false,
+		"only log and count the orphans found by --orphan-gc-period instead of deleting them.")
+	flags.StringVar(&membersConfig, "members-config", "",
+		"json file of more client clusters hosted by their own virtual nodes in this process, sharing the master "+
+			"client and informers, disabled if not set.")
+	flags.StringSliceVar(&translationHooks, "translation-hooks", nil,
+		"executables rewriting pods before created in client cluster, run in order, each reads the upper pod and "+
+			"the pod to create in json from stdin and prints the rewritten pod.")
+	flags.DurationVar(&translationTimeout, "translation-hook-timeout", 10*time.Second,
+		"timeout of each translation hook, creating the pod fails once exceeded.")
+	flags.DurationVar(&cc.HealthProbePeriod, "health-probe-period", 10*time.Second,
+		"period to probe /healthz of client cluster, the virtual node turns NotReady with condition "+
+			"LowerClusterUnreachable once it is unreachable while the lease is still renewed, Ping checks client "+
+			"cluster instead if 0.")
+	flags.DurationVar(&cc.HealthProbeTimeout, "health-probe-timeout", 5*time.Second,
+		"timeout of each probe of client cluster.")
+	flags.IntVar(&cc.HealthFailureThreshold, "health-failure-threshold", 3,
+		"probes failed in a row making client cluster unreachable.")
+	flags.DurationVar(&cc.InformerStaleThreshold, "informer-stale-threshold", time.Minute,
+		"how long pods of client cluster could fail to be listed and watched before it is unreachable, "+
+			"disabled if 0.")
+	flags.StringSliceVar(&cc.CustomDependencies, "custom-dependencies", nil,
+		"custom resources whose objects pods depend on, listed in annotation "+util.CustomDependencies+" of the pods, "+
+			"mirrored into client cluster before the pods, each in <resource>.<version>.<group>[=<field path>;...], "+
+			"e.g. certificates.v1.cert-manager.io=spec, only spec is mirrored if no field path declared.")
+	flags.StringVar(&dynamicConfig, "dynamic-config", "",
+		"name of the TensileConfig in master cluster whose provider config is applied live over the flags, "+
+			"disabled if not set.")
+	flags.StringSliceVar(&clientKubeConfigs, "client-kubeconfigs", nil,
+		"kube configs of more client clusters aggregated into the virtual node, directories of them are accepted, "+
+			"e.g. mounted secrets, each pod runs in one of the clusters chosen by --placement.")
+	flags.StringVar(&placement, "placement", placement,
+		"placement of pods among the aggregated client clusters, FirstFit places pods to the first cluster fitting "+
+			"them, LeastAllocated to the fitting one with the most free cpu.")
+	flags.StringVar(&managerListenAddress, "manager-listen-address", "",
+		"address to serve status of members in --members-config at /members, disabled if not set.")
+	flags.Int32Var(&daemonPort, "daemon-port", 0,
+		"port advertised as the kubelet endpoint of the virtual node, serving logs, exec, attach and port forward "+
+			"of pods, instead of the listen port of virtual kubelet only serving logs and exec, disabled if 0.")
+	flags.BoolVar(&leaderElection.LeaderElect, "leader-elect", false,
+		"run the controllers syncing objects between clusters only in the replica holding the lease of the "+
+			"client cluster in master cluster, enable it when running replicated virtual nodes for high availability.")
+	flags.StringVar(&leaderElection.Namespace, "leader-elect-resource-namespace", leaderElection.Namespace,
+		"namespace of the leases locked by --leader-elect in master cluster.")
+	flags.BoolVar(&drain, "drain", false,
+		"drain the virtual node once started, i.e. cordon it, evict its pods respecting disruption budgets in master "+
+			"cluster, wait for the pods cleaned up in client cluster, then delete the node and exit. The node is "+
+			"drained as well once annotated with "+util.Drain+"=true.")
+	flags.StringVar(&metricsListenAddress, "prometheus-listen-address", "",
+		"address to serve prometheus metrics of pods synced and resource aggregation at /metrics, disabled if not "+
+			"set. Unlike --metrics-addr serving the stats summary of pods.")
+	flags.StringVar(&cc.PodMappingNamespace, "pod-mapping-namespace", "",
+		"namespace of master cluster to persist the upper pods and the pods created for them in client cluster in "+
+			"configMaps tensile-kube-pod-mapping-<cluster>-<shard>, rebuilt on startup, disabled if empty.")
+	flags.StringVar(&cc.WorkNamespace, "work-namespace", "",
+		"namespace of master cluster whose Works are applied to client cluster, the objects removed from their "+
+			"manifests or of Works deleted are deleted from client cluster, disabled if empty.")
+	flags.DurationVar(&cc.OffloadedPodMetricsPeriod, "offloaded-pod-metrics-period", 0,
+		"period to record the cpu and memory usage of pods reported by metrics-server of client cluster as metrics "+
+			"at --prometheus-listen-address, for custom metrics of HPAs served by prometheus-adapter, disabled if 0.")
+
+	logger := logrus.StandardLogger()
+
+	log.L = logruslogger.FromLogrus(logrus.NewEntry(logger))
+	logConfig := &logruscli.Config{LogLevel: "info"}
+
+	o, err := opts.FromEnv()
+	if err != nil {
+		return err
+	}
+	o.Provider = providerName
+	o.PodSyncWorkers = numberOfWorkers
+	o.Version = strings.Join([]string{k8sVersion, providerName, buildVersion}, "-")
+	o.SyncPodsFromKubernetesRateLimiter = rateLimiter()
+	o.DeletePodsFromKubernetesRateLimiter = rateLimiter()
+	o.SyncPodStatusFromProviderRateLimiter = rateLimiter()
+	node, err := cli.New(ctx,
+		cli.WithBaseOpts(o),
+		cli.WithProvider(providerName, func(cfg provider.InitConfig) (provider.Provider, error) {
+			cfg.ConfigPath = o.KubeConfigPath
+			if daemonPort != 0 {
+				cfg.DaemonPort = daemonPort
+			}
+			if len(metricsListenAddress) != 0 {
+				go runMetricsServer(metricsListenAddress)
+			}
+			for _, path := range translationHooks {
+				cc.TranslationHooks = append(cc.TranslationHooks, translation.NewExecHook(path, translationTimeout))
+			}
+			provider, err := k8sprovider.NewVirtualK8S(ctx, cfg, &cc, ignoreLabels, enableServiceAccount, o)
+			if err != nil {
+				return nil, err
+			}
+			go RunController(ctx, provider, cfg.NodeName, numberOfWorkers, ctx.Done())
+			go drainNode(ctx, cancel, provider)
+			if err = watchConfig(ctx.Done(), provider, cc, cfg.ConfigPath); err != nil {
+				return nil, err
+			}
+			if len(membersConfig) != 0 {
+				if err = runMembers(ctx, provider, cfg, cc, o); err != nil {
+					return nil, err
+				}
+			}
+			if len(clientKubeConfigs) != 0 {
+				aggregate, err := runAggregate(ctx, provider, cfg, cc, o)
+				if err != nil {
+					return nil, err
+				}
+				go runDaemonServer(ctx, aggregate, o)
+				return aggregate, nil
+			}
+			go runDaemonServer(ctx, provider, o)
+			return provider, nil
+		}),
+		cli.WithCLIVersion(buildVersion, buildTime),
+		cli.WithKubernetesNodeVersion(k8sVersion),
+		// Adds flags and parsing for using logrus as the configured logger
+		cli.WithPersistentFlags(logConfig.FlagSet()),
+		cli.WithPersistentFlags(flags),
+		cli.WithPersistentPreRunCallback(func() error {
+			return logruscli.Configure(logConfig, logger)
+		}),
+	)
+
+	if err != nil {
+		return err
+	}
+	return node.Run(ctx, args...)
+}
+
+// runMetricsServer serves the prometheus metrics at /metrics
+func runMetricsServer(address string) {
+	metrics.Register()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if err := http.ListenAndServe(address, mux); err != nil {
+		klog.Fatalf("Metrics server exited: %v", err)
+	}
+}
+
+// runDaemonServer serves the kubelet apis of pods on --daemon-port until ctx done, the same certificates as
+// virtual kubelet are used
+func runDaemonServer(ctx context.Context, p k8sprovider.DaemonProvider, o *opts.Opts) {
+	if daemonPort == 0 {
+		return
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", daemonPort),
+		Handler: k8sprovider.NewDaemonHandler(p, o.StreamIdleTimeout, o.StreamCreationTimeout),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServeTLS(os.Getenv("APISERVER_CERT_LOCATION"),
+		os.Getenv("APISERVER_KEY_LOCATION")); err != http.ErrServerClosed {
+		klog.Fatalf("Daemon server exited: %v", err)
+	}
+}
+
+// drainNode drains the virtual node once started with --drain, or once it is annotated to be drained, and
+// stops the virtual node by cancel once drained, otherwise the node would be registered again
+func drainNode(ctx context.Context, cancel context.CancelFunc, p *k8sprovider.VirtualK8S) {
+	if !drain {
+		if err := p.WaitForDrain(ctx, drainInterval); err != nil {
+			return
+		}
+	}
+	if err := p.Drain(ctx, drainInterval); err != nil {
+		klog.Errorf("Drain virtual node failed: %v", err)
+		return
+	}
+	klog.Info("Virtual node drained, exiting")
+	cancel()
+}
+
+// runMembers starts the virtual nodes of the client clusters in --members-config, they share the master
+// client and informers with the virtual node of the process and inherit its flags
+func runMembers(ctx context.Context, p *k8sprovider.VirtualK8S, cfg provider.InitConfig,
+	cc k8sprovider.ClientConfig, o *opts.Opts) error {
+	if cc.PullMode {
+		return fmt.Errorf("--members-config is not supported in pull mode")
+	}
+	members, err := manager.LoadMembers(membersConfig)
+	if err != nil {
+		return err
+	}
+	check := func(members []manager.Member) error {
+		for _, member := range members {
+			if member.NodeName == cfg.NodeName {
+				return fmt.Errorf("member %v is the virtual node of the process", member.NodeName)
+			}
+			if member.DaemonPort == cfg.DaemonPort || member.DaemonPort == o.ListenPort {
+				return fmt.Errorf("daemon port %v of member %v is used by the process", member.DaemonPort,
+					member.NodeName)
+			}
+		}
+		return nil
+	}
+	if err = check(members); err != nil {
+		return err
+	}
+	// the tunnel, failover endpoints, snapshot and Works belong to the client cluster of the process
+	cc.TunnelListenAddress = ""
+	cc.ClientEndpoints = nil
+	cc.SnapshotPath = ""
+	cc.WorkNamespace = ""
+	cc.MasterClient = p.GetMaster()
+	cc.MasterInformer = p.GetMasterInformer()
+	m := manager.NewManager(p.GetMaster(), p.GetMasterInformer(), o,
+		func(memberCtx context.Context, cfg provider.InitConfig,
+			member manager.Member) (*k8sprovider.VirtualK8S, error) {
+			memberConfig := cc
+			memberConfig.ClientKubeConfigPath = member.ClientKubeConfig
+			memberConfig.ClusterName = member.ClusterName
+			memberConfig.ClusterRegion = member.ClusterRegion
+			memberConfig.NetworkZone = member.NetworkZone
+			provider, err := k8sprovider.NewVirtualK8S(memberCtx, cfg, &memberConfig, ignoreLabels,
+				enableServiceAccount, o)
+			if err != nil {
+				return nil, err
+			}
+			go RunController(memberCtx, provider, cfg.NodeName, numberOfWorkers, ctx.Done())
+			if err = watchConfig(memberCtx.Done(), provider, memberConfig, cfg.ConfigPath); err != nil {
+				return nil, err
+			}
+			return provider, nil
+		})
+	m.Run(ctx, members)
+	m.WatchMembers(ctx, membersConfig, membersReloadPeriod, check)
+	if len(managerListenAddress) != 0 {
+		go func() {
+			if err := http.ListenAndServe(managerListenAddress, m); err != nil {
+				klog.Fatalf("Manager server exited: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// runAggregate starts the providers of the client clusters in --client-kubeconfigs, they are aggregated with
+// the client cluster of the process into the virtual node, sharing the master client and informers
+func runAggregate(ctx context.Context, p *k8sprovider.VirtualK8S, cfg provider.InitConfig,
+	cc k8sprovider.ClientConfig, o *opts.Opts) (*k8sprovider.Aggregate, error) {
+	if cc.PullMode {
+		return nil, fmt.Errorf("--client-kubeconfigs is not supported in pull mode")
+	}
+	policy, err := k8sprovider.NewPlacement(placement)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := util.ListConfigFiles(clientKubeConfigs)
+	if err != nil {
+		return nil, err
+	}
+	// the tunnel, failover endpoints, snapshot and Works belong to the client cluster of the process
+	cc.TunnelListenAddress = ""
+	cc.ClientEndpoints = nil
+	cc.SnapshotPath = ""
+	cc.WorkNamespace = ""
+	cc.MasterClient = p.GetMaster()
+	cc.MasterInformer = p.GetMasterInformer()
+	var others []*k8sprovider.VirtualK8S
+	for _, path := range paths {
+		clusterConfig := cc
+		clusterConfig.ClientKubeConfigPath = path
+		clusterConfig.ClusterName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		other, err := k8sprovider.NewVirtualK8S(ctx, cfg, &clusterConfig, ignoreLabels, enableServiceAccount, o)
+		if err != nil {
+			return nil, fmt.Errorf("client cluster %v: %v", path, err)
+		}
+		go RunController(ctx, other, cfg.NodeName, numberOfWorkers, ctx.Done())
+		if err = watchConfig(ctx.Done(), other, clusterConfig, cfg.ConfigPath); err != nil {
+			return nil, err
+		}
+		others = append(others, other)
+	}
+	return k8sprovider.NewAggregate(policy, p, others...), nil
+}
+
+// watchConfig applies the provider config of --dynamic-config live, the flags in cc take effect for the
+// fields not set, the transformations, resource rules, cluster taints, node propagation and namespaces excluded
+// are removed if not set
+func watchConfig(stopCh <-chan struct{}, p *k8sprovider.VirtualK8S, cc k8sprovider.ClientConfig,
+	configPath string) error {
+	if len(dynamicConfig) == 0 {
+		return nil
+	}
+	client, err := util.NewDynamicClient(configPath)
+	if err != nil {
+		return err
+	}
+	config.Watch(client, dynamicConfig, func(spec *config.TensileConfigSpec) {
+		p.SetOvercommitRatios(spec.Provider.OvercommitRatios(cc.CPUOvercommitRatio, cc.MemoryOvercommitRatio))
+		p.SetTransformations(spec.Provider)
+		p.SetResourceRules(spec.Provider)
+		p.SetClusterTaints(spec.Provider)
+		p.SetNodePropagation(spec.Provider)
+		p.SetSyncFilters(spec.Provider.SyncFilters(strings.Split(ignoreLabels, ",")))
+	}, stopCh)
+	return nil
+}
+
+// RunController starts controllers for objects needed to be synced until ctx done, the master informers
+// shared with other virtual nodes of the process are kept running until masterStopCh closed. With --leader-elect
+// the controllers only run while the lease of the client cluster is held
+func RunController(ctx context.Context, p *k8sprovider.VirtualK8S, hostIP string,
+	workers int, masterStopCh <-chan struct{}) *controllers.ServiceController {
+	master := p.GetMaster()
+	client := p.GetClient()
+	// share the informer factories with provider to avoid watching the same objects twice
+	masterInformer := p.GetMasterInformer()
+	clientInformer := p.GetClientInformer()
+
+	namespaces := p.GetNamespaceMapping()
+
+	runningControllers := []controllers.Controller{buildCommonControllers(client, masterInformer, clientInformer,
+		p.GetConflictDetector(), namespaces),
+		controllers.NewDependencyController(client, masterInformer, clientInformer, p.GetConflictDetector(), hostIP,
+			namespaces)}
+	if completedPodTTL > 0 {
+		runningControllers = append(runningControllers,
+			controllers.NewPodCleanupController(client, masterInformer, clientInformer, completedPodTTL, namespaces))
+	}
+	if orphanGCPeriod > 0 {
+		runningControllers = append(runningControllers, controllers.NewOrphanGCController(client, masterInformer,
+			clientInformer, p.GetClusterName(), p.GetUpperClusterName(), orphanGCPeriod, orphanGCDryRun, namespaces))
+	}
+	if works := p.GetWorkAgent(); works != nil {
+		runningControllers = append(runningControllers, works)
+	}
+
+	controllerSlice := strings.Split(enableControllers, ",")
+	for _, c := range controllerSlice {
+		if len(c) == 0 {
+			continue
+		}
+		switch c {
+		case "HPAControllers", "PDBControllers", "EndpointSliceControllers":
+			if namespaces != nil {
+				klog.Warningf("Skip %v: namespaces of client cluster are translated", c)
+				continue
+			}
+		}
+		switch c {
+		case "PVControllers":
+			pvCtrl := controllers.NewPVController(master, client, masterInformer, clientInformer, hostIP, namespaces)
+			runningControllers = append(runningControllers, pvCtrl)
+		case "ServiceControllers":
+			serviceCtrl := controllers.NewServiceController(master, client, masterInformer, clientInformer,
+				p.GetNameSpaceLister(), namespaces)
+			runningControllers = append(runningControllers, serviceCtrl)
+		case "HPAControllers":
+			hpaCtrl := controllers.NewHPAController(master, client, masterInformer, clientInformer,
+				p.GetNameSpaceLister(), hostIP)
+			runningControllers = append(runningControllers, hpaCtrl)
+		case "PDBControllers":
+			if !p.SupportsFeature(k8sprovider.FeaturePodDisruptionBudgetV1beta1) {
+				klog.Warningf("Skip %v: policy/v1beta1 PodDisruptionBudgets are not served by client cluster", c)
+				continue
+			}
+			pdbCtrl := controllers.NewPDBController(master, client, masterInformer, clientInformer,
+				p.GetNameSpaceLister(), hostIP)
+			runningControllers = append(runningControllers, pdbCtrl)
+		case "EndpointSliceControllers":
+			if !p.SupportsFeature(k8sprovider.FeatureEndpointSliceV1beta1) {
+				klog.Warningf("Skip %v: discovery.k8s.io/v1beta1 EndpointSlices are not served by client cluster", c)
+				continue
+			}
+			sliceCtrl := controllers.NewEndpointSliceController(master, client, masterInformer, clientInformer,
+				p.GetNameSpaceLister(), hostIP)
+			runningControllers = append(runningControllers, sliceCtrl)
+		case "ImagePrePullControllers":
+			prePullCtrl := controllers.NewImagePrePullController(master, client, masterInformer, clientInformer,
+				hostIP, imagePrePullTTL)
+			runningControllers = append(runningControllers, prePullCtrl)
+		default:
+			klog.Warningf("Skip: %v", c)
+		}
+	}
+	masterInformer.Start(masterStopCh)
+	p.StartClientInformer()
+	// controllers of the same client cluster in other replicas sync the same objects
+	election := leaderElection
+	election.Name = "tensile-kube-controllers-" + hostIP
+	if cluster := p.GetClusterName(); cluster != hostIP {
+		election.Name += "-" + cluster
+	}
+	if err := util.RunOrElect(ctx, master, election, func(ctx context.Context) error {
+		for _, ctrl := range runningControllers {
+			go ctrl.Run(workers, ctx.Done())
+		}
+		<-ctx.Done()
+		return nil
+	}); err != nil && ctx.Err() == nil {
+		klog.Errorf("Run controllers failed: %v", err)
+	}
+	return nil
+}
+
+func buildCommonControllers(client kubernetes.Interface, masterInformer,
+	clientInformer kubeinformers.SharedInformerFactory, conflicts *conflict.Detector,
+	namespaces *util.NamespaceMapping) controllers.Controller {
+
+	configMapRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	secretRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+
+	return controllers.NewCommonController(client, masterInformer, clientInformer, configMapRateLimiter, secretRateLimiter,
+		conflicts, namespaces)
+}
+
+func rateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(5*time.Millisecond, 10*time.Second),
+		// 100 qps, 1000 bucket size.  This is only for retry speed and its only the overall factor (not per item)
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(100), 1000)},
+	)
+}
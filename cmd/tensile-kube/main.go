@@ -0,0 +1,91 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command tensile-kube runs any component of tensile-kube by subcommand, so that one binary and one image are
+// shipped for all of them
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/component-base/logs"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+
+	deschedulerapp "github.com/virtual-kubelet/tensile-kube/cmd/descheduler/app"
+	providerapp "github.com/virtual-kubelet/tensile-kube/cmd/provider/app"
+	webhookapp "github.com/virtual-kubelet/tensile-kube/cmd/webhook/app"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/extender"
+)
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+	logs.InitLogs()
+	defer logs.FlushLogs()
+
+	if err := newCommand().Execute(); err != nil {
+		fmt.Println(err)
+		logs.FlushLogs()
+		os.Exit(1)
+	}
+}
+
+// newCommand returns the root command with a subcommand of each component, their flags are the same as the
+// binaries of the components
+func newCommand() *cobra.Command {
+	var metricsAddress string
+	cmd := &cobra.Command{
+		Use:   "tensile-kube",
+		Short: "Run a component of tensile-kube",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if len(metricsAddress) != 0 {
+				go serveMetrics(metricsAddress)
+			}
+		},
+		SilenceUsage: true,
+	}
+	cmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "",
+		"address to serve /metrics and /debug/pprof of webhook and descheduler, disabled if not set. "+
+			"provider and scheduler serve their own by --metrics-addr and --secure-port.")
+
+	descheduler := deschedulerapp.NewDeschedulerCommand(os.Stdout)
+	descheduler.AddCommand(deschedulerapp.NewVersionCommand())
+	schedulerCmd := scheduler.NewSchedulerCommand()
+	schedulerCmd.Use = "scheduler"
+	cmd.AddCommand(providerapp.NewProviderCommand(), schedulerCmd, extender.NewExtenderCommand(),
+		webhookapp.NewWebhookCommand(), descheduler)
+	return cmd
+}
+
+// serveMetrics serves the metrics registered in the legacy registry and the profiles
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	klog.Infof("Serving metrics at %v", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		klog.Fatalf("Metrics server exited: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// options are the flags shared by the subcommands
+type options struct {
+	// kubeconfig of the upper cluster
+	kubeconfig string
+	// kubeconfigs of the client clusters by their names
+	lowerKubeconfigs map[string]string
+	namespace        string
+	timeout          time.Duration
+}
+
+// NewCommand returns the root command of the plugin writing to out
+func NewCommand(out io.Writer) *cobra.Command {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:          "kubectl-tensile",
+		Short:        "Introspect pods on virtual nodes and their pods in client clusters",
+		SilenceUsage: true,
+	}
+	kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
+	if len(kubeconfig) == 0 {
+		kubeconfig = clientcmd.RecommendedHomeFile
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.kubeconfig, "kubeconfig", kubeconfig, "kubeconfig of the upper cluster.")
+	flags.StringToStringVar(&o.lowerKubeconfigs, "lower-kubeconfig", nil,
+		"kubeconfigs of client clusters in <cluster>=<path>, the cluster is the "+util.ClusterName+
+			" label of virtual nodes or their names, repeated for each client cluster.")
+	flags.StringVarP(&o.namespace, "namespace", "n", metav1.NamespaceDefault, "namespace of the upper pods.")
+	flags.DurationVar(&o.timeout, "request-timeout", 30*time.Second, "timeout of requests except following logs.")
+
+	cmd.AddCommand(newGetCommand(o, out), newDescribeCommand(o, out), newLogsCommand(o, out),
+		newStatusCommand(o, out), newResyncCommand(o, out))
+	return cmd
+}
+
+func newGetCommand(o *options, out io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get POD",
+		Short: "Show the pod in client cluster of the upper pod",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+			defer cancel()
+			c, err := newClusters(o)
+			if err != nil {
+				return err
+			}
+			upper, lower, err := c.resolve(ctx, o.namespace, args[0])
+			if err != nil {
+				return err
+			}
+			printPair(out, upper, lower)
+			return nil
+		},
+	}
+}
+
+func newDescribeCommand(o *options, out io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe POD",
+		Short: "Describe the upper pod and its pod in client cluster with the events of both",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+			defer cancel()
+			c, err := newClusters(o)
+			if err != nil {
+				return err
+			}
+			upper, lower, err := c.resolve(ctx, o.namespace, args[0])
+			if err != nil {
+				return err
+			}
+			upperEvents, err := podEvents(ctx, c.upper, upper.Namespace, upper.UID)
+			if err != nil {
+				return err
+			}
+			lowerEvents, err := podEvents(ctx, lower.client, lower.pod.Namespace, lower.pod.UID)
+			if err != nil {
+				return err
+			}
+			describePod(out, "Upper pod", upper, upperEvents)
+			fmt.Fprintln(out)
+			describePod(out, fmt.Sprintf("Lower pod in cluster %v", lower.cluster), lower.pod, lowerEvents)
+			return nil
+		},
+	}
+}
+
+func newLogsCommand(o *options, out io.Writer) *cobra.Command {
+	logOptions := &corev1.PodLogOptions{}
+	var tail int64
+	cmd := &cobra.Command{
+		Use:   "logs POD",
+		Short: "Print the logs of the pod in client cluster of the upper pod",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+			defer cancel()
+			c, err := newClusters(o)
+			if err != nil {
+				return err
+			}
+			_, lower, err := c.resolve(ctx, o.namespace, args[0])
+			if err != nil {
+				return err
+			}
+			if tail >= 0 {
+				logOptions.TailLines = &tail
+			}
+			// the logs followed are not bounded by the timeout
+			stream, err := lower.client.CoreV1().Pods(lower.pod.Namespace).GetLogs(lower.pod.Name, logOptions).
+				Stream(context.Background())
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			_, err = io.Copy(out, stream)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&logOptions.Container, "container", "c", "", "container of the pod.")
+	cmd.Flags().BoolVarP(&logOptions.Follow, "follow", "f", false, "follow the logs.")
+	cmd.Flags().BoolVarP(&logOptions.Previous, "previous", "p", false, "logs of the previous container instance.")
+	cmd.Flags().Int64Var(&tail, "tail", -1, "lines of recent logs, all if negative.")
+	return cmd
+}
+
+func newStatusCommand(o *options, out io.Writer) *cobra.Command {
+	var node string
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "List the sync status of upper pods on virtual nodes in the namespace",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+			defer cancel()
+			c, err := newClusters(o)
+			if err != nil {
+				return err
+			}
+			statuses, err := c.syncStatuses(ctx, o.namespace, node)
+			if err != nil {
+				return err
+			}
+			printStatuses(out, statuses)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&node, "node", "", "virtual node of the pods, all virtual nodes if empty.")
+	return cmd
+}
+
+func newResyncCommand(o *options, out io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resync POD",
+		Short: "Make the virtual node sync the upper pod to client cluster again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+			defer cancel()
+			c, err := newClusters(o)
+			if err != nil {
+				return err
+			}
+			patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, util.ResyncRequested,
+				time.Now().UTC().Format(time.RFC3339Nano))
+			if _, err = c.upper.CoreV1().Pods(o.namespace).Patch(ctx, args[0], types.MergePatchType, []byte(patch),
+				metav1.PatchOptions{}); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "pod/%v resync requested\n", args[0])
+			return nil
+		},
+	}
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// statusSynced, statusOutOfSync, statusMissing and statusUnknown are the sync statuses of upper pods, Unknown
+	// if the kubeconfig of the client cluster is not given
+	statusSynced    = "Synced"
+	statusOutOfSync = "OutOfSync"
+	statusMissing   = "Missing"
+	statusUnknown   = "Unknown"
+)
+
+// clusters are the clients of the upper cluster and the client clusters by their names
+type clusters struct {
+	upper  kubernetes.Interface
+	lowers map[string]kubernetes.Interface
+}
+
+// lowerPod is the pod in the client cluster of an upper pod
+type lowerPod struct {
+	cluster string
+	client  kubernetes.Interface
+	pod     *corev1.Pod
+}
+
+// podStatus is the sync status of an upper pod
+type podStatus struct {
+	upper   *corev1.Pod
+	cluster string
+	lower   *corev1.Pod
+	status  string
+}
+
+// newClusters returns the clients of the kubeconfigs of the options
+func newClusters(o *options) (*clusters, error) {
+	upper, err := util.NewClient(o.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	c := &clusters{upper: upper, lowers: make(map[string]kubernetes.Interface, len(o.lowerKubeconfigs))}
+	for cluster, path := range o.lowerKubeconfigs {
+		if c.lowers[cluster], err = util.NewClient(path); err != nil {
+			return nil, fmt.Errorf("could not create client of cluster %v: %v", cluster, err)
+		}
+	}
+	return c, nil
+}
+
+// clusterOf returns the name of the client cluster of the virtual node
+func clusterOf(node *corev1.Node) string {
+	if cluster := node.Labels[util.ClusterName]; len(cluster) != 0 {
+		return cluster
+	}
+	return node.Name
+}
+
+// candidates returns the client clusters the pods of the virtual node may run in, all the clusters given if the
+// one of the node is not, e.g. the node aggregates several clusters
+func (c *clusters) candidates(ctx context.Context, nodeName string) ([]string, error) {
+	node, err := c.upper.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !util.IsVirtualNode(node) {
+		return nil, fmt.Errorf("node %v is not a virtual node", nodeName)
+	}
+	if _, ok := c.lowers[clusterOf(node)]; ok {
+		return []string{clusterOf(node)}, nil
+	}
+	var candidates []string
+	for cluster := range c.lowers {
+		candidates = append(candidates, cluster)
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
+
+// resolve returns the upper pod and its pod in the client clusters
+func (c *clusters) resolve(ctx context.Context, namespace, name string) (*corev1.Pod, *lowerPod, error) {
+	upper, err := c.upper.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(upper.Spec.NodeName) == 0 {
+		return nil, nil, fmt.Errorf("pod %v/%v is not scheduled", namespace, name)
+	}
+	candidates, err := c.candidates(ctx, upper.Spec.NodeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cluster := range candidates {
+		pods, err := c.lowers[cluster].CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(labels.Set{util.OriginPodUID: string(upper.UID)}).String(),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not list pods of cluster %v: %v", cluster, err)
+		}
+		if len(pods.Items) != 0 {
+			return upper, &lowerPod{cluster: cluster, client: c.lowers[cluster], pod: &pods.Items[0]}, nil
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("no kubeconfig given of the cluster of node %v", upper.Spec.NodeName)
+	}
+	return nil, nil, fmt.Errorf("pod %v/%v not found in clusters %v", namespace, name, strings.Join(candidates, ","))
+}
+
+// syncStatuses returns the sync statuses of the upper pods in the namespace on the virtual node, or all virtual
+// nodes if node is empty
+func (c *clusters) syncStatuses(ctx context.Context, namespace, node string) ([]podStatus, error) {
+	options := metav1.ListOptions{}
+	if len(node) != 0 {
+		options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", node).String()
+	}
+	pods, err := c.upper.CoreV1().Pods(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := c.upper.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{util.NodeType: util.VirtualKubeletLabel}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	clusterOfNode := make(map[string]string, len(nodes.Items))
+	for i := range nodes.Items {
+		clusterOfNode[nodes.Items[i].Name] = clusterOf(&nodes.Items[i])
+	}
+	// the lower pods of the namespace are listed once per client cluster and matched by the uids of upper pods
+	lowers := make(map[types.UID]*lowerPod)
+	for cluster, client := range c.lowers {
+		lowerPods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(labels.Set{util.OriginNamespace: namespace}).String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list pods of cluster %v: %v", cluster, err)
+		}
+		for i := range lowerPods.Items {
+			pod := &lowerPods.Items[i]
+			if uid := pod.Labels[util.OriginPodUID]; len(uid) != 0 {
+				lowers[types.UID(uid)] = &lowerPod{cluster: cluster, pod: pod}
+			}
+		}
+	}
+	var statuses []podStatus
+	for i := range pods.Items {
+		upper := &pods.Items[i]
+		cluster, ok := clusterOfNode[upper.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		status := podStatus{upper: upper, cluster: cluster}
+		if lower, ok := lowers[upper.UID]; ok {
+			status.cluster, status.lower = lower.cluster, lower.pod
+			status.status = syncStatus(upper, lower.pod)
+		} else if _, ok := c.lowers[cluster]; ok {
+			status.status = statusMissing
+		} else {
+			status.status = statusUnknown
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// syncStatus returns if the status of the lower pod is reported to the upper pod
+func syncStatus(upper, lower *corev1.Pod) string {
+	if upper.Status.Phase != lower.Status.Phase || isReady(upper) != isReady(lower) {
+		return statusOutOfSync
+	}
+	return statusSynced
+}
+
+// isReady returns if the pod is ready
+func isReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podEvents returns the events of the pod of the uid sorted by time, nil if the cluster does not allow to list
+// them
+func podEvents(ctx context.Context, client kubernetes.Interface, namespace string,
+	uid types.UID) ([]corev1.Event, error) {
+	events, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(uid)).String(),
+	})
+	if errors.IsForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events.Items, func(i, j int) bool {
+		return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+	})
+	return events.Items, nil
+}
+
+// printPair prints where the upper pod runs in the client cluster
+func printPair(out io.Writer, upper *corev1.Pod, lower *lowerPod) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tNODE\tCLUSTER\tLOWER POD\tLOWER NODE\tSTATUS")
+	fmt.Fprintf(w, "%v/%v\t%v\t%v\t%v/%v\t%v\t%v\n", upper.Namespace, upper.Name, upper.Spec.NodeName,
+		lower.cluster, lower.pod.Namespace, lower.pod.Name, lower.pod.Spec.NodeName, syncStatus(upper, lower.pod))
+	w.Flush()
+}
+
+// printStatuses prints the sync statuses of the upper pods
+func printStatuses(out io.Writer, statuses []podStatus) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNODE\tCLUSTER\tPHASE\tLOWER PHASE\tSTATUS")
+	for _, s := range statuses {
+		lowerPhase := "<none>"
+		if s.lower != nil {
+			lowerPhase = string(s.lower.Status.Phase)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", s.upper.Name, s.upper.Spec.NodeName, s.cluster,
+			s.upper.Status.Phase, lowerPhase, s.status)
+	}
+	w.Flush()
+}
+
+// describePod prints the status, the containers and the events of the pod under the title
+func describePod(out io.Writer, title string, pod *corev1.Pod, events []corev1.Event) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%v:\n", title)
+	fmt.Fprintf(w, "  Name:\t%v/%v\n", pod.Namespace, pod.Name)
+	fmt.Fprintf(w, "  UID:\t%v\n", pod.UID)
+	fmt.Fprintf(w, "  Node:\t%v\n", pod.Spec.NodeName)
+	fmt.Fprintf(w, "  Phase:\t%v\n", pod.Status.Phase)
+	fmt.Fprintf(w, "  IP:\t%v\n", pod.Status.PodIP)
+	if pod.DeletionTimestamp != nil {
+		fmt.Fprintf(w, "  Terminating since:\t%v\n", pod.DeletionTimestamp.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w, "  Conditions:")
+	for _, condition := range pod.Status.Conditions {
+		fmt.Fprintf(w, "    %v\t%v\t%v\n", condition.Type, condition.Status, condition.Reason)
+	}
+	fmt.Fprintln(w, "  Containers:")
+	for _, status := range pod.Status.ContainerStatuses {
+		state := "Waiting"
+		switch {
+		case status.State.Running != nil:
+			state = "Running"
+		case status.State.Terminated != nil:
+			state = "Terminated: " + status.State.Terminated.Reason
+		case status.State.Waiting != nil:
+			state = "Waiting: " + status.State.Waiting.Reason
+		}
+		fmt.Fprintf(w, "    %v\t%v\tready=%v\trestarts=%v\n", status.Name, state, status.Ready, status.RestartCount)
+	}
+	fmt.Fprintln(w, "  Events:")
+	for _, event := range events {
+		fmt.Fprintf(w, "    %v\t%v\t%v\tx%v\t%v\n", event.LastTimestamp.Format(time.RFC3339), event.Type, event.Reason,
+			event.Count, event.Message)
+	}
+	w.Flush()
+}
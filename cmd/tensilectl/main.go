@@ -0,0 +1,33 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command kubectl-tensile is the kubectl plugin introspecting pods across the upper cluster and the client clusters,
+// installed in PATH it runs as `kubectl tensile`
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/virtual-kubelet/tensile-kube/cmd/tensilectl/app"
+)
+
+func main() {
+	if err := app.NewCommand(os.Stdout).Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
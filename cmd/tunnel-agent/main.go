@@ -0,0 +1,65 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/tunnel"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func main() {
+	var (
+		config   tunnel.AgentConfig
+		caFile   string
+		insecure bool
+	)
+	flags := pflag.NewFlagSet("tunnel-agent", pflag.ExitOnError)
+	flags.StringVar(&config.ServerAddress, "server-address", "", "address of the tunnel server in upper cluster.")
+	flags.StringVar(&config.Token, "token", os.Getenv("TUNNEL_TOKEN"), "token to authenticate with the tunnel server.")
+	flags.StringVar(&config.Target, "target", net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"),
+		os.Getenv("KUBERNETES_SERVICE_PORT")), "address of the apiserver of this cluster.")
+	flags.IntVar(&config.PoolSize, "pool-size", 10, "number of idle connections kept to the tunnel server.")
+	flags.StringVar(&caFile, "ca-file", "", "ca to verify the tunnel server, the system roots are used if not set.")
+	flags.BoolVar(&insecure, "insecure", false, "skip verifying the tunnel server, not recommended.")
+	flags.Parse(os.Args[1:])
+
+	if len(config.ServerAddress) == 0 {
+		klog.Fatal("server-address can not be empty")
+	}
+	config.TLSConfig = &tls.Config{InsecureSkipVerify: insecure}
+	if len(caFile) != 0 {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			klog.Fatalf("no certificate found in %v", caFile)
+		}
+		config.TLSConfig.RootCAs = pool
+	}
+	tunnel.NewAgent(config).Run(util.SetupSignalHandler())
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+// NewWebhookCommand returns the command running the webhook server, it takes the same flags as the webhook
+// binary
+func NewWebhookCommand() *cobra.Command {
+	s := &ServerRunOptions{}
+	cmd := &cobra.Command{
+		Use:   "webhook",
+		Short: "Run the webhook mutating virtual pods",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if s.ShowVersion {
+				fmt.Println(cmd.CommandPath(), Version)
+				return nil
+			}
+			klog.Infof("starting webhook server.")
+			if err := s.Validate(); err != nil {
+				return err
+			}
+			return Run(s)
+		},
+	}
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	cmd.Flags().AddGoFlagSet(klogFlags)
+	s.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -19,9 +19,13 @@ package app
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/cache"
+
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/webhook"
 )
 
 var (
@@ -50,30 +54,96 @@ type ServerRunOptions struct {
 	// ignoreSelectorKeys represents those nodeSelector keys should not be converted
 	// and it would affect the scheduling in then upper cluster
 	IgnoreSelectorKeys string
+	// CheckReferences rejects pods referencing configMaps or secrets not existing
+	CheckReferences bool
+	// CheckCSIDrivers rejects pods with inline CSI volumes whose drivers are installed in no lower cluster
+	CheckCSIDrivers bool
+	// FeatureCheck is the mode of checking virtual pods using features unsupported by virtual nodes at /validate,
+	// Reject or Warn, disabled if empty
+	FeatureCheck string
+	// AllowedFeatures are the unsupported features not checked
+	AllowedFeatures []string
+	// InjectClusterIdentity injects envs exposing the name and region of the lower cluster pods run in
+	InjectClusterIdentity bool
+	// SelectorTranslation is the mode of moving the node selection to lower pods, Strip or Translate
+	SelectorTranslation string
+	// SelectorRulesConfigMap is the namespace/name of the configMap of selector rules used to translate
+	SelectorRulesConfigMap string
+	// ClusterSelectionConfigMap is the namespace/name of the configMap of the cluster selection policy preferring
+	// virtual nodes for virtual pods, disabled if empty
+	ClusterSelectionConfigMap string
+	// OffloadPolicy rejects virtual pods not eligible by ClusterOffloadPolicies and tolerates virtual nodes for
+	// the eligible ones
+	OffloadPolicy bool
+	// VirtualNodeTaintKey is the key of the taint of virtual nodes tolerated for eligible pods
+	VirtualNodeTaintKey string
+	// SchedulerName is the scheduler set to virtual pods, kept as is if empty
+	SchedulerName string
+	// MutateWorkloads mutates the pod templates of Deployments, StatefulSets, Jobs and CronJobs as well
+	MutateWorkloads bool
+	// DynamicConfig is the name of the TensileConfig whose webhook config is applied live over the flags
+	DynamicConfig string
 	// ShowVersion is used for version
 	ShowVersion bool
 }
 
-// NewServerRunOptions returns the run options
+// NewServerRunOptions returns the run options, the flags are registered in the command line flag set
 func NewServerRunOptions() *ServerRunOptions {
 	options := &ServerRunOptions{}
-	options.addFlags()
+	options.AddFlags(pflag.CommandLine)
 	return options
 }
 
-func (s *ServerRunOptions) addFlags() {
-	pflag.StringVar(&s.Address, "address", "0.0.0.0", "The address of scheduler manager.")
-	pflag.IntVar(&s.Port, "port", 8080, "The port of scheduler manager.")
-	pflag.StringVar(&s.TLSCert, "tlscert", "", "Path to TLS certificate file")
-	pflag.StringVar(&s.TLSKey, "tlskey", "", "Path to TLS key file")
-	pflag.StringVar(&s.TLSCA, "CA", "", "Path to certificate file")
-	pflag.StringVar(&s.Kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file.")
-	pflag.StringVar(&s.MasterURL, "master", "", "Master url.")
-	pflag.BoolVar(&s.InCluster, "incluster", false, "If this extender running in the cluster.")
-	pflag.StringVar(&s.IgnoreSelectorKeys, "ignore-selector-keys", util.ClusterID,
+// AddFlags registers the flags of options in the flag set
+func (s *ServerRunOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.Address, "address", "0.0.0.0", "The address of scheduler manager.")
+	fs.IntVar(&s.Port, "port", 8080, "The port of scheduler manager.")
+	fs.StringVar(&s.TLSCert, "tlscert", "", "Path to TLS certificate file")
+	fs.StringVar(&s.TLSKey, "tlskey", "", "Path to TLS key file")
+	fs.StringVar(&s.TLSCA, "CA", "", "Path to certificate file")
+	fs.StringVar(&s.Kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file.")
+	fs.StringVar(&s.MasterURL, "master", "", "Master url.")
+	fs.BoolVar(&s.InCluster, "incluster", false, "If this extender running in the cluster.")
+	fs.StringVar(&s.IgnoreSelectorKeys, "ignore-selector-keys", util.ClusterID,
 		"IgnoreSelectorKeys represents those nodeSelector keys should not be converted, "+
 			"it would affect the scheduling in then upper cluster, multi values should split by comma(,)")
-	pflag.BoolVar(&s.ShowVersion, "version", false, "Show version.")
+	fs.BoolVar(&s.CheckReferences, "check-references", false,
+		"Reject virtual pods referencing configMaps or secrets which do not exist, "+
+			"instead of letting them hang in CreateContainerConfigError in the lower cluster.")
+	fs.BoolVar(&s.CheckCSIDrivers, "check-csi-drivers", false,
+		"Reject virtual pods with inline CSI volumes whose drivers are installed in none of the lower clusters.")
+	fs.StringVar(&s.FeatureCheck, "feature-check", "",
+		"Mode of checking virtual pods at /validate using features unsupported by virtual nodes, hostNetwork, "+
+			"hostPID, hostIPC, hostPath and localVolume, Reject rejects them, Warn admits them with the features "+
+			"logged and audited, disabled if not set.")
+	fs.StringSliceVar(&s.AllowedFeatures, "allowed-features", nil,
+		"Unsupported features allowed by --feature-check, e.g. hostPath if the paths exist in all the lower clusters.")
+	fs.BoolVar(&s.InjectClusterIdentity, "inject-cluster-identity", false,
+		"Inject envs "+util.ClusterNameEnv+" and "+util.ClusterRegionEnv+" into containers of virtual pods, "+
+			"exposing the name and region of the lower cluster they run in.")
+	fs.StringVar(&s.SelectorTranslation, "selector-translation", webhook.SelectorStrip,
+		"Mode of moving the nodeSelector and required node affinity not ignored to the lower pods, Strip moves them "+
+			"as they are, Translate maps the label keys and values by the rules in --selector-rules-configmap.")
+	fs.StringVar(&s.SelectorRulesConfigMap, "selector-rules-configmap", "",
+		"Namespace/name of the configMap of selector rules in json of key "+webhook.SelectorRulesKey+
+			", required with --selector-translation Translate, changes are applied live.")
+	fs.StringVar(&s.ClusterSelectionConfigMap, "cluster-selection-configmap", "",
+		"Namespace/name of the configMap of the cluster selection policy in json of key "+webhook.ClusterSelectionKey+
+			", virtual pods prefer the virtual nodes scored highest by it, changes are applied live, disabled if not set.")
+	fs.BoolVar(&s.OffloadPolicy, "offload-policy", false,
+		"Reject virtual pods eligible by no ClusterOffloadPolicy, and add the toleration of "+
+			"--virtual-node-taint-key to the eligible ones, all pods are eligible if there is no policy.")
+	fs.StringVar(&s.VirtualNodeTaintKey, "virtual-node-taint-key", util.VirtualNodeTaintKey,
+		"Key of the taint of virtual nodes tolerated for the pods eligible by ClusterOffloadPolicies.")
+	fs.StringVar(&s.SchedulerName, "scheduler-name", "",
+		"Scheduler set to virtual pods using the default scheduler, e.g. the multi-scheduler, kept as is if not set.")
+	fs.BoolVar(&s.MutateWorkloads, "mutate-workloads", false,
+		"Also mutate the pod templates of Deployments, StatefulSets, Jobs and CronJobs of virtual pods, so "+
+			"--scheduler-name, the toleration of virtual nodes and the identity envs are set on the workloads once.")
+	fs.StringVar(&s.DynamicConfig, "dynamic-config", "",
+		"Name of the TensileConfig whose webhook config, the mutation rules, is applied live over the flags, "+
+			"disabled if not set.")
+	fs.BoolVar(&s.ShowVersion, "version", false, "Show version.")
 }
 
 // Validate is used for validate address
@@ -82,5 +152,26 @@ func (s *ServerRunOptions) Validate() error {
 	if address.To4() == nil {
 		return fmt.Errorf("%v is not a valid IP address", s.Address)
 	}
+	switch s.SelectorTranslation {
+	case webhook.SelectorStrip:
+	case webhook.SelectorTranslate:
+		if _, _, err := cache.SplitMetaNamespaceKey(s.SelectorRulesConfigMap); err != nil ||
+			!strings.Contains(s.SelectorRulesConfigMap, "/") {
+			return fmt.Errorf("--selector-rules-configmap %q is not a namespace/name", s.SelectorRulesConfigMap)
+		}
+	default:
+		return fmt.Errorf("unknown selector translation %v", s.SelectorTranslation)
+	}
+	if len(s.ClusterSelectionConfigMap) != 0 {
+		if _, _, err := cache.SplitMetaNamespaceKey(s.ClusterSelectionConfigMap); err != nil ||
+			!strings.Contains(s.ClusterSelectionConfigMap, "/") {
+			return fmt.Errorf("--cluster-selection-configmap %q is not a namespace/name", s.ClusterSelectionConfigMap)
+		}
+	}
+	if len(s.FeatureCheck) != 0 {
+		if err := webhook.ValidateFeatureCheck(s.FeatureCheck, s.AllowedFeatures); err != nil {
+			return err
+		}
+	}
 	return nil
 }
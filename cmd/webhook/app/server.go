@@ -29,9 +29,15 @@ import (
 	"strings"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+	"github.com/virtual-kubelet/tensile-kube/pkg/offload"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 	"github.com/virtual-kubelet/tensile-kube/pkg/webhook"
 	kubeinformers "k8s.io/client-go/informers"
@@ -52,18 +58,91 @@ func Run(s *ServerRunOptions) error {
 	}
 	pvcInformer := kubeInformer.Core().V1().PersistentVolumeClaims()
 	pvcLister := pvcInformer.Lister()
+	synced := []cache.InformerSynced{pvcInformer.Informer().HasSynced}
+	cmInformer := kubeInformer.Core().V1().ConfigMaps()
+	secretInformer := kubeInformer.Core().V1().Secrets()
+	if s.CheckReferences {
+		synced = append(synced, cmInformer.Informer().HasSynced, secretInformer.Informer().HasSynced)
+	}
+	nodeInformer := kubeInformer.Core().V1().Nodes()
+	if s.CheckCSIDrivers || len(s.ClusterSelectionConfigMap) != 0 {
+		synced = append(synced, nodeInformer.Informer().HasSynced)
+	}
+	pvInformer := kubeInformer.Core().V1().PersistentVolumes()
+	if len(s.FeatureCheck) != 0 {
+		synced = append(synced, pvInformer.Informer().HasSynced)
+	}
+
+	var policies *offload.Controller
+	if s.OffloadPolicy {
+		dynamicClient, err := util.NewDynamicClient(s.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		nsInformer := kubeInformer.Core().V1().Namespaces()
+		policies = offload.NewController(dynamicClient, nsInformer.Lister())
+		synced = append(synced, nsInformer.Informer().HasSynced, policies.HasSynced)
+		go policies.Run(stopCh)
+	}
 
 	kubeInformer.Start(stopCh)
 
-	if !cache.WaitForCacheSync(stopCh, pvcInformer.Informer().HasSynced) {
+	if !cache.WaitForCacheSync(stopCh, synced...) {
 		panic("wait for cache sync failed")
 	}
 	seletorKeys := strings.Split(s.IgnoreSelectorKeys, ",")
-	webHook := webhook.NewWebhookServer(pvcLister, seletorKeys)
+	var webHook webhook.HookServer
+	if s.CheckReferences {
+		webHook = webhook.NewWebhookServerWithReferenceCheck(client, pvcLister, cmInformer.Lister(),
+			secretInformer.Lister(), seletorKeys)
+	} else {
+		webHook = webhook.NewWebhookServer(pvcLister, seletorKeys)
+	}
+	if s.CheckCSIDrivers {
+		webHook = webhook.WithCSIDriverCheck(webHook, nodeInformer.Lister())
+	}
+	if len(s.FeatureCheck) != 0 {
+		webHook = webhook.WithFeatureCheck(webHook, s.FeatureCheck, s.AllowedFeatures, pvInformer.Lister())
+	}
+	if s.OffloadPolicy {
+		webHook = webhook.WithOffloadPolicy(webHook, policies, s.VirtualNodeTaintKey)
+	}
+	if s.InjectClusterIdentity {
+		webHook = webhook.WithClusterIdentityInjection(webHook)
+	}
+	if len(s.SchedulerName) != 0 {
+		webHook = webhook.WithSchedulerName(webHook, s.SchedulerName)
+	}
+	if s.MutateWorkloads {
+		webHook = webhook.WithWorkloadMutation(webHook)
+	}
+	if s.SelectorTranslation == webhook.SelectorTranslate {
+		webHook = webhook.WithSelectorTranslation(webHook)
+		if err := watchSelectorRules(client, webHook, s.SelectorRulesConfigMap, stopCh); err != nil {
+			return err
+		}
+	}
+	if len(s.ClusterSelectionConfigMap) != 0 {
+		webHook = webhook.WithClusterSelection(webHook, nodeInformer.Lister())
+		if err := watchClusterSelection(client, webHook, s.ClusterSelectionConfigMap, stopCh); err != nil {
+			return err
+		}
+	}
+	if len(s.DynamicConfig) != 0 {
+		dynamicClient, err := util.NewDynamicClient(s.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		config.Watch(dynamicClient, s.DynamicConfig, func(spec *config.TensileConfigSpec) {
+			webhook.UpdateMutationRules(webHook, spec.Webhook.MutationRules(seletorKeys, s.InjectClusterIdentity))
+			webhook.UpdateInjectionRules(webHook, spec.Webhook.InjectionRules())
+		}, stopCh)
+	}
 
 	// Start debug monitor.
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", webHook.Serve)
+	mux.HandleFunc("/validate", webHook.Validate)
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
@@ -108,6 +187,75 @@ func Run(s *ServerRunOptions) error {
 	return nil
 }
 
+// watchSelectorRules applies the selector rules of the configMap live, no rule applies once it is deleted and
+// the rules failing to be decoded are skipped
+func watchSelectorRules(client kubernetes.Interface, hook webhook.HookServer, key string,
+	stopCh <-chan struct{}) error {
+	return watchConfigMap(client, key, func(cm *corev1.ConfigMap) {
+		rules, err := webhook.ParseSelectorRules(cm)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+		webhook.UpdateSelectorRules(hook, rules)
+		klog.Infof("Applied %v selector rules of configmap %v", len(rules), key)
+	}, func() {
+		webhook.UpdateSelectorRules(hook, nil)
+		klog.Warningf("Selector rules of configmap %v deleted", key)
+	}, stopCh)
+}
+
+// watchClusterSelection applies the cluster selection policy of the configMap live, no node is preferred once it
+// is deleted and the policy failing to be decoded is skipped
+func watchClusterSelection(client kubernetes.Interface, hook webhook.HookServer, key string,
+	stopCh <-chan struct{}) error {
+	return watchConfigMap(client, key, func(cm *corev1.ConfigMap) {
+		policy, err := webhook.ParseClusterSelectionPolicy(cm)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+		webhook.UpdateClusterSelection(hook, policy)
+		klog.Infof("Applied cluster selection policy of configmap %v", key)
+	}, func() {
+		webhook.UpdateClusterSelection(hook, nil)
+		klog.Warningf("Cluster selection policy of configmap %v deleted", key)
+	}, stopCh)
+}
+
+// watchConfigMap calls apply with the configMap of the key once added or updated, and deleted once it is deleted
+func watchConfigMap(client kubernetes.Interface, key string, apply func(*corev1.ConfigMap), deleted func(),
+	stopCh <-chan struct{}) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	informer := kubeinformers.NewSharedInformerFactoryWithOptions(client, 0, kubeinformers.WithNamespace(namespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		})).Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if cm, ok := obj.(*corev1.ConfigMap); ok {
+				apply(cm)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if cm, ok := new.(*corev1.ConfigMap); ok {
+				apply(cm)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			deleted()
+		},
+	})
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		return fmt.Errorf("wait for cache sync of configmap %v failed", key)
+	}
+	return nil
+}
+
 func getTLSConfig(s *ServerRunOptions) (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		NextProtos: []string{"http/1.1"},
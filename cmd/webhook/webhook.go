@@ -17,33 +17,14 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"os"
 
-	"github.com/spf13/pflag"
-	"k8s.io/klog"
-
 	"github.com/virtual-kubelet/tensile-kube/cmd/webhook/app"
 )
 
 func main() {
-	klog.InitFlags(nil)
-	options := app.NewServerRunOptions()
-	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
-	pflag.Parse()
-	if options.ShowVersion {
-		fmt.Println(os.Args[0], app.Version)
-		return
-	}
-
-	klog.Infof("starting webhook server.")
-	if err := options.Validate(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-
-	if err := app.Run(options); err != nil {
+	if err := app.NewWebhookCommand().Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
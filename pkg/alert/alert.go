@@ -0,0 +1,99 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Alert is a structured notification of a sync failure lasting longer than the threshold
+type Alert struct {
+	// Cluster is the name of the lower cluster
+	Cluster string `json:"cluster"`
+	// Object is the upper object failing to sync
+	Object corev1.ObjectReference `json:"object"`
+	// Reason is a short machine readable reason, e.g. CreatePodFailed
+	Reason string `json:"reason"`
+	// Message is the latest error
+	Message string `json:"message"`
+	// Since is the time of the first failure
+	Since time.Time `json:"since"`
+}
+
+// Sink receives alerts
+type Sink interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+// webhookSink posts alerts in json to an url
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting alerts in json to the url
+func NewWebhookSink(url string) Sink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Send posts the alert
+func (s *webhookSink) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %v", resp.Status)
+	}
+	return nil
+}
+
+// eventSink records alerts as warning events on the upper objects
+type eventSink struct {
+	recorder record.EventRecorder
+}
+
+// NewEventSink returns a sink recording alerts as warning events on the upper objects
+func NewEventSink(recorder record.EventRecorder) Sink {
+	return &eventSink{recorder: recorder}
+}
+
+// Send records the alert
+func (s *eventSink) Send(ctx context.Context, alert Alert) error {
+	s.recorder.Eventf(&alert.Object, corev1.EventTypeWarning, alert.Reason,
+		"sync to lower cluster %v failing since %v: %v", alert.Cluster, alert.Since.Format(time.RFC3339),
+		alert.Message)
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// checkPeriod is the period checking failures exceeding the threshold
+const checkPeriod = 30 * time.Second
+
+// failure is a sync failure not resolved yet
+type failure struct {
+	alert Alert
+	fired bool
+}
+
+// Tracker tracks sync failures and sends an alert to the sinks once a failure lasts longer than the
+// threshold, each failure is alerted once until it is resolved
+type Tracker struct {
+	cluster   string
+	threshold time.Duration
+	sinks     []Sink
+	now       func() time.Time
+
+	lock     sync.Mutex
+	failures map[string]*failure
+}
+
+// NewTracker returns a tracker of sync failures to the cluster, nil is returned if there is no sink,
+// methods of a nil tracker do nothing
+func NewTracker(cluster string, threshold time.Duration, sinks ...Sink) *Tracker {
+	if len(sinks) == 0 {
+		return nil
+	}
+	return &Tracker{
+		cluster:   cluster,
+		threshold: threshold,
+		sinks:     sinks,
+		now:       time.Now,
+		failures:  map[string]*failure{},
+	}
+}
+
+// Failed records a failure of the key, the first failure time is kept until the key is resolved
+func (t *Tracker) Failed(key string, object corev1.ObjectReference, reason string, err error) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	f, ok := t.failures[key]
+	if !ok {
+		f = &failure{alert: Alert{Cluster: t.cluster, Since: t.now()}}
+		t.failures[key] = f
+	}
+	f.alert.Object = object
+	f.alert.Reason = reason
+	f.alert.Message = err.Error()
+}
+
+// Resolved forgets the failure of the key
+func (t *Tracker) Resolved(key string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.failures, key)
+}
+
+// Run checks the failures periodically until stopCh closed
+func (t *Tracker) Run(stopCh <-chan struct{}) {
+	if t == nil {
+		return
+	}
+	wait.Until(t.check, checkPeriod, stopCh)
+}
+
+// check sends the failures lasting longer than the threshold not alerted yet
+func (t *Tracker) check() {
+	var alerts []Alert
+	t.lock.Lock()
+	for _, f := range t.failures {
+		if f.fired || t.now().Sub(f.alert.Since) < t.threshold {
+			continue
+		}
+		f.fired = true
+		alerts = append(alerts, f.alert)
+	}
+	t.lock.Unlock()
+	for _, alert := range alerts {
+		for _, sink := range t.sinks {
+			if err := sink.Send(context.TODO(), alert); err != nil {
+				klog.Errorf("Send alert of %v %v/%v failed: %v", alert.Object.Kind, alert.Object.Namespace,
+					alert.Object.Name, err)
+			}
+		}
+	}
+}
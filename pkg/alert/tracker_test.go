@@ -0,0 +1,74 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package alert
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type fakeSink struct {
+	alerts []Alert
+}
+
+func (s *fakeSink) Send(ctx context.Context, alert Alert) error {
+	s.alerts = append(s.alerts, alert)
+	return nil
+}
+
+func TestTracker(t *testing.T) {
+	sink := &fakeSink{}
+	now := time.Now()
+	tracker := NewTracker("vk", time.Minute, sink)
+	tracker.now = func() time.Time { return now }
+	object := corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "test"}
+
+	tracker.Failed("pod/default/test", object, "CreatePodFailed", fmt.Errorf("first"))
+	tracker.check()
+	if len(sink.alerts) != 0 {
+		t.Fatalf("desire no alert before threshold, real %v", sink.alerts)
+	}
+
+	now = now.Add(2 * time.Minute)
+	tracker.Failed("pod/default/test", object, "CreatePodFailed", fmt.Errorf("second"))
+	tracker.check()
+	tracker.check()
+	if len(sink.alerts) != 1 || sink.alerts[0].Message != "second" || sink.alerts[0].Cluster != "vk" {
+		t.Fatalf("desire one alert of the latest error, real %v", sink.alerts)
+	}
+
+	tracker.Resolved("pod/default/test")
+	tracker.Failed("pod/default/test", object, "CreatePodFailed", fmt.Errorf("third"))
+	now = now.Add(2 * time.Minute)
+	tracker.check()
+	if len(sink.alerts) != 2 {
+		t.Fatalf("desire alerting again after resolved, real %v", sink.alerts)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	tracker := NewTracker("vk", time.Minute)
+	if tracker != nil {
+		t.Fatalf("desire nil tracker without sinks")
+	}
+	tracker.Failed("key", corev1.ObjectReference{}, "reason", fmt.Errorf("error"))
+	tracker.Resolved("key")
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// HostAntiAffinityTerms returns the required pod anti-affinity terms of the pod keyed on hostname, which
+// keep the pod off the nodes running the pods selected
+func HostAntiAffinityTerms(pod *corev1.Pod) []corev1.PodAffinityTerm {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAntiAffinity == nil {
+		return nil
+	}
+	return hostTerms(pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+}
+
+// HostAffinityTerms returns the required pod affinity terms of the pod keyed on hostname
+func HostAffinityTerms(pod *corev1.Pod) []corev1.PodAffinityTerm {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAffinity == nil {
+		return nil
+	}
+	return hostTerms(pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+}
+
+// HostAntiAffinityConflicts tells whether the pods can not run on the same node by the required pod anti-affinity
+// terms keyed on hostname of either one
+func HostAntiAffinityConflicts(pod, other *corev1.Pod) bool {
+	for _, term := range HostAntiAffinityTerms(pod) {
+		if MatchesAffinityTerm(pod, &term, other) {
+			return true
+		}
+	}
+	for _, term := range HostAntiAffinityTerms(other) {
+		if MatchesAffinityTerm(other, &term, pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesAffinityTerm tells whether the target pod is selected by the affinity term of the pod, the term selects
+// pods in the namespace of the pod if no namespace is set, invalid selectors select nothing
+func MatchesAffinityTerm(pod *corev1.Pod, term *corev1.PodAffinityTerm, target *corev1.Pod) bool {
+	namespaces := sets.NewString(term.Namespaces...)
+	if len(namespaces) == 0 {
+		namespaces.Insert(pod.Namespace)
+	}
+	if !namespaces.Has(target.Namespace) {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(target.Labels))
+}
+
+// hostTerms returns the terms keyed on hostname
+func hostTerms(terms []corev1.PodAffinityTerm) []corev1.PodAffinityTerm {
+	var host []corev1.PodAffinityTerm
+	for _, term := range terms {
+		if term.TopologyKey == corev1.LabelHostname {
+			host = append(host, term)
+		}
+	}
+	return host
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// Sum advertises the free resources of all nodes summed up
+	Sum = "Sum"
+	// SumMinusReserved advertises the summed free resources with a reserved amount kept back
+	SumMinusReserved = "SumMinusReserved"
+	// MaxSinglePod advertises the largest free resources of a single node, the largest pod could be scheduled
+	MaxSinglePod = "MaxSinglePod"
+	// PercentileOfFree advertises the percentile of the free resources of nodes times the number of nodes
+	PercentileOfFree = "PercentileOfFree"
+)
+
+// NodeCapacity is the capacity of a ready and schedulable node in lower cluster and the requests of pods on it
+type NodeCapacity struct {
+	Capacity  *Resource
+	Requested *Resource
+}
+
+// free returns the capacity not requested
+func (n NodeCapacity) free() *Resource {
+	free := NewResource()
+	free.Add(n.Capacity)
+	free.Sub(n.Requested)
+	return free
+}
+
+// CapacityCalculator computes the resources advertised by the virtual node from the nodes of lower cluster
+type CapacityCalculator interface {
+	// Calculate returns the resources of virtual node
+	Calculate(nodes []NodeCapacity) *Resource
+	// Additive returns if the result changes by the same amount as the capacity or requests of any node,
+	// then the resources of virtual node are maintained incrementally, otherwise they are recalculated
+	Additive() bool
+}
+
+// NewCapacityCalculator returns the calculator of the name, reserved is only used by SumMinusReserved and
+// percentile, in (0, 100], only by PercentileOfFree
+func NewCapacityCalculator(name string, reserved corev1.ResourceList, percentile float64) (CapacityCalculator,
+	error) {
+	switch name {
+	case Sum, "":
+		return sum{}, nil
+	case SumMinusReserved:
+		return sumMinusReserved{reserved: ConvertResource(reserved)}, nil
+	case MaxSinglePod:
+		return maxSinglePod{}, nil
+	case PercentileOfFree:
+		if percentile <= 0 || percentile > 100 {
+			return nil, fmt.Errorf("percentile %v of free out of range (0, 100]", percentile)
+		}
+		return percentileOfFree{percentile: percentile}, nil
+	}
+	return nil, fmt.Errorf("unknown capacity calculator %q, expected one of %v, %v, %v and %v", name, Sum,
+		SumMinusReserved, MaxSinglePod, PercentileOfFree)
+}
+
+// ParseResourceList parses the quantities of resources, e.g. cpu=4,memory=16Gi given by flags
+func ParseResourceList(quantities map[string]string) (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	for name, value := range quantities {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %q of %v: %v", value, name, err)
+		}
+		list[corev1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
+
+type sum struct{}
+
+func (sum) Calculate(nodes []NodeCapacity) *Resource {
+	total := NewResource()
+	for _, n := range nodes {
+		total.Add(n.Capacity)
+		total.Sub(n.Requested)
+	}
+	return total
+}
+
+func (sum) Additive() bool {
+	return true
+}
+
+type sumMinusReserved struct {
+	reserved *Resource
+}
+
+func (s sumMinusReserved) Calculate(nodes []NodeCapacity) *Resource {
+	total := sum{}.Calculate(nodes)
+	total.Sub(s.reserved)
+	return total
+}
+
+func (sumMinusReserved) Additive() bool {
+	return true
+}
+
+type maxSinglePod struct{}
+
+// Calculate returns the maxima of each resource among the free resources of nodes, so a pod fitting one
+// node is never rejected by the upper scheduler
+func (maxSinglePod) Calculate(nodes []NodeCapacity) *Resource {
+	maxima := corev1.ResourceList{}
+	for _, n := range nodes {
+		for name, quantity := range resourceList(n.free()) {
+			if current, ok := maxima[name]; !ok || quantity.Cmp(current) > 0 {
+				maxima[name] = quantity
+			}
+		}
+	}
+	return ConvertResource(maxima)
+}
+
+func (maxSinglePod) Additive() bool {
+	return false
+}
+
+type percentileOfFree struct {
+	percentile float64
+}
+
+// Calculate returns the percentile of each resource among the free resources of nodes times the number of
+// nodes, so a few nodes with plenty of free resources do not make the cluster look roomy
+func (p percentileOfFree) Calculate(nodes []NodeCapacity) *Resource {
+	values := map[corev1.ResourceName][]resource.Quantity{}
+	for _, n := range nodes {
+		for name, quantity := range resourceList(n.free()) {
+			values[name] = append(values[name], quantity)
+		}
+	}
+	result := corev1.ResourceList{}
+	for name, quantities := range values {
+		// nodes without the resource have none of it free
+		for len(quantities) < len(nodes) {
+			quantities = append(quantities, resource.Quantity{})
+		}
+		sort.Slice(quantities, func(i, j int) bool {
+			return quantities[i].Cmp(quantities[j]) < 0
+		})
+		// nearest rank
+		rank := int(math.Ceil(p.percentile/100*float64(len(quantities)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		total := quantities[rank].DeepCopy()
+		for i := 1; i < len(nodes); i++ {
+			total.Add(quantities[rank])
+		}
+		result[name] = total
+	}
+	return ConvertResource(result)
+}
+
+func (percentileOfFree) Additive() bool {
+	return false
+}
+
+// resourceList returns the non-negative quantities of the resource by name
+func resourceList(r *Resource) corev1.ResourceList {
+	list := corev1.ResourceList{
+		corev1.ResourceCPU:              r.CPU,
+		corev1.ResourceMemory:           r.Memory,
+		corev1.ResourcePods:             r.Pods,
+		corev1.ResourceEphemeralStorage: r.EphemeralStorage,
+	}
+	for name, quantity := range r.Custom {
+		list[name] = quantity
+	}
+	for name, quantity := range list {
+		if quantity.Sign() < 0 {
+			list[name] = resource.Quantity{Format: quantity.Format}
+		}
+	}
+	return list
+}
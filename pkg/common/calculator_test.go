@@ -0,0 +1,94 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func nodeCapacityForTest(cpu, memory, requestedCPU, requestedMemory string) NodeCapacity {
+	return NodeCapacity{
+		Capacity: &Resource{CPU: resource.MustParse(cpu), Memory: resource.MustParse(memory),
+			Pods: resource.MustParse("110")},
+		Requested: &Resource{CPU: resource.MustParse(requestedCPU), Memory: resource.MustParse(requestedMemory),
+			Pods: resource.MustParse("10")},
+	}
+}
+
+func TestCapacityCalculators(t *testing.T) {
+	nodes := []NodeCapacity{
+		nodeCapacityForTest("8", "32Gi", "2", "8Gi"),
+		nodeCapacityForTest("16", "64Gi", "15", "16Gi"),
+		nodeCapacityForTest("4", "16Gi", "6", "4Gi"),
+	}
+	cases := []struct {
+		name       string
+		reserved   corev1.ResourceList
+		percentile float64
+		cpu        string
+		memory     string
+		pods       string
+		additive   bool
+	}{
+		{Sum, nil, 0, "5", "84Gi", "300", true},
+		{SumMinusReserved, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}, 0, "4", "84Gi",
+			"300", true},
+		{MaxSinglePod, nil, 0, "6", "48Gi", "100", false},
+		{PercentileOfFree, nil, 50, "3", "72Gi", "300", false},
+		{PercentileOfFree, nil, 100, "18", "144Gi", "300", false},
+	}
+	for _, c := range cases {
+		calculator, err := NewCapacityCalculator(c.name, c.reserved, c.percentile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := calculator.Calculate(nodes)
+		if !r.CPU.Equal(resource.MustParse(c.cpu)) || !r.Memory.Equal(resource.MustParse(c.memory)) ||
+			!r.Pods.Equal(resource.MustParse(c.pods)) {
+			t.Fatalf("case %v %v: desire cpu %v memory %v pods %v, real %v", c.name, c.percentile, c.cpu,
+				c.memory, c.pods, r)
+		}
+		if calculator.Additive() != c.additive {
+			t.Fatalf("case %v: desire additive %v, real %v", c.name, c.additive, calculator.Additive())
+		}
+	}
+}
+
+func TestNewCapacityCalculatorInvalid(t *testing.T) {
+	if _, err := NewCapacityCalculator("Average", nil, 0); err == nil {
+		t.Fatal("desire error of unknown calculator, real nil")
+	}
+	if _, err := NewCapacityCalculator(PercentileOfFree, nil, 0); err == nil {
+		t.Fatal("desire error of percentile out of range, real nil")
+	}
+}
+
+func TestParseResourceList(t *testing.T) {
+	list, err := ParseResourceList(map[string]string{"cpu": "4", "memory": "16Gi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Cpu().Equal(resource.MustParse("4")) || !list.Memory().Equal(resource.MustParse("16Gi")) {
+		t.Fatalf("desire cpu 4 memory 16Gi, real %v", list)
+	}
+	if _, err = ParseResourceList(map[string]string{"cpu": "four"}); err == nil {
+		t.Fatal("desire error of invalid quantity, real nil")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+// StorageSegment is the storage capacity of a storage class in a topology segment of a lower cluster
+type StorageSegment struct {
+	// Topology is the labels of nodes in the segment, empty means all nodes of the cluster
+	Topology map[string]string `json:"topology,omitempty"`
+	// Capacity is the bytes available in the segment, 0 means not reported
+	Capacity int64 `json:"capacity,omitempty"`
+	// MaximumVolumeSize is the largest volume in bytes can be provisioned in the segment, 0 means not reported
+	MaximumVolumeSize int64 `json:"maximumVolumeSize,omitempty"`
+}
+
+// fits returns if all of the volumes can be provisioned in the segment together
+func (s StorageSegment) fits(sizes []int64) bool {
+	var total int64
+	for _, size := range sizes {
+		if s.MaximumVolumeSize > 0 && size > s.MaximumVolumeSize {
+			return false
+		}
+		total += size
+	}
+	return s.Capacity == 0 || total <= s.Capacity
+}
+
+// StorageCapacity is the storage capacity of each storage class in the topology segments of a lower cluster
+type StorageCapacity map[string][]StorageSegment
+
+// Add records the capacity of a topology segment of the storage class
+func (s StorageCapacity) Add(class string, segment StorageSegment) {
+	s[class] = append(s[class], segment)
+}
+
+// Fits returns if the volumes of the storage class with the sizes can be provisioned together in any
+// topology segment accepted by matches, true would be returned if no capacity reported for the storage class
+func (s StorageCapacity) Fits(class string, sizes []int64, matches func(topology map[string]string) bool) bool {
+	segments, ok := s[class]
+	if !ok {
+		return true
+	}
+	for _, segment := range segments {
+		if matches != nil && !matches(segment.Topology) {
+			continue
+		}
+		if segment.fits(sizes) {
+			return true
+		}
+	}
+	return false
+}
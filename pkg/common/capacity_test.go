@@ -0,0 +1,51 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"testing"
+)
+
+func TestStorageCapacityFits(t *testing.T) {
+	capacity := StorageCapacity{}
+	capacity.Add("fast", StorageSegment{Topology: map[string]string{"zone": "a"}, Capacity: 10, MaximumVolumeSize: 6})
+	capacity.Add("fast", StorageSegment{Topology: map[string]string{"zone": "b"}, Capacity: 4})
+	inZoneB := func(topology map[string]string) bool {
+		return topology["zone"] == "b"
+	}
+	cases := []struct {
+		name    string
+		class   string
+		sizes   []int64
+		matches func(map[string]string) bool
+		fits    bool
+	}{
+		{name: "class not reported", class: "slow", sizes: []int64{100}, fits: true},
+		{name: "fits one segment", class: "fast", sizes: []int64{5, 5}, fits: true},
+		{name: "exceeds capacity together", class: "fast", sizes: []int64{5, 6}},
+		{name: "exceeds maximum volume size", class: "fast", sizes: []int64{7}},
+		{name: "fits the matched segment", class: "fast", sizes: []int64{4}, matches: inZoneB, fits: true},
+		{name: "exceeds the matched segment", class: "fast", sizes: []int64{5}, matches: inZoneB},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if fits := capacity.Fits(c.class, c.sizes, c.matches); fits != c.fits {
+				t.Errorf("Desired %v, get %v", c.fits, fits)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+// CSIDriver is the capability of a CSI driver installed in a lower cluster
+type CSIDriver struct {
+	Name           string   `json:"name"`
+	AttachRequired bool     `json:"attachRequired,omitempty"`
+	PodInfoOnMount bool     `json:"podInfoOnMount,omitempty"`
+	Modes          []string `json:"modes,omitempty"`
+}
+
+// CSIDrivers is the CSI drivers installed in a lower cluster
+type CSIDrivers []CSIDriver
+
+// Get returns the driver with the name, nil would be returned if not installed
+func (d CSIDrivers) Get(name string) *CSIDriver {
+	for i := range d {
+		if d[i].Name == name {
+			return &d[i]
+		}
+	}
+	return nil
+}
+
+// SupportsMode returns if the driver supports the volume lifecycle mode, Persistent is supported
+// by default if no mode set
+func (d *CSIDriver) SupportsMode(mode string) bool {
+	if len(d.Modes) == 0 {
+		return mode == "Persistent"
+	}
+	for _, m := range d.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
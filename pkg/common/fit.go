@@ -0,0 +1,151 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeFree is the free resources of a node in lower cluster
+type NodeFree struct {
+	MilliCPU int64 `json:"cpu"`
+	Memory   int64 `json:"memory"`
+	Pods     int64 `json:"pods"`
+	// Extended is the free extended resources, e.g. nvidia.com/gpu, omitted if the node has none
+	Extended map[corev1.ResourceName]int64 `json:"extended,omitempty"`
+}
+
+// covers returns if the free resources of n are not less than other in all dimensions
+func (n NodeFree) covers(other NodeFree) bool {
+	if n.MilliCPU < other.MilliCPU || n.Memory < other.Memory || n.Pods < other.Pods {
+		return false
+	}
+	for name, quantity := range other.Extended {
+		if quantity > 0 && n.Extended[name] < quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// FitSummary summarizes the free resources of nodes in a lower cluster, it only keeps the nodes
+// not covered by any other one, so whether a pod fits one node of the cluster can be answered
+// from a few entries instead of all of the nodes
+type FitSummary []NodeFree
+
+// NewFitSummary builds the summary from the free resources of nodes. If max is positive and the
+// nodes not covered are more than max, the last entry is the per-resource maxima of the rest, so
+// the summary may give false positives but never false negatives
+func NewFitSummary(frees []NodeFree, max int) FitSummary {
+	sorted := make([]NodeFree, len(frees))
+	copy(sorted, frees)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].MilliCPU != sorted[j].MilliCPU {
+			return sorted[i].MilliCPU > sorted[j].MilliCPU
+		}
+		if sorted[i].Memory != sorted[j].Memory {
+			return sorted[i].Memory > sorted[j].Memory
+		}
+		return sorted[i].Pods > sorted[j].Pods
+	})
+	summary := FitSummary{}
+	for _, free := range sorted {
+		if free.MilliCPU <= 0 || free.Memory <= 0 || free.Pods <= 0 {
+			continue
+		}
+		if summary.Fits(free) {
+			continue
+		}
+		summary = append(summary, free)
+	}
+	if max <= 0 || len(summary) <= max {
+		return summary
+	}
+	rest := summary[max-1]
+	// the map is shared with the free resources given
+	extended := rest.Extended
+	rest.Extended = nil
+	for name, quantity := range extended {
+		if rest.Extended == nil {
+			rest.Extended = map[corev1.ResourceName]int64{}
+		}
+		rest.Extended[name] = quantity
+	}
+	for _, free := range summary[max:] {
+		if free.MilliCPU > rest.MilliCPU {
+			rest.MilliCPU = free.MilliCPU
+		}
+		if free.Memory > rest.Memory {
+			rest.Memory = free.Memory
+		}
+		if free.Pods > rest.Pods {
+			rest.Pods = free.Pods
+		}
+		for name, quantity := range free.Extended {
+			if rest.Extended == nil {
+				rest.Extended = map[corev1.ResourceName]int64{}
+			}
+			if quantity > rest.Extended[name] {
+				rest.Extended[name] = quantity
+			}
+		}
+	}
+	return append(summary[:max-1], rest)
+}
+
+// Fits returns if the request fits one of the nodes in summary
+func (s FitSummary) Fits(request NodeFree) bool {
+	for _, free := range s {
+		if free.covers(request) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxCopies returns the most copies of the request one of the nodes in summary could host, 0 means none fits
+func (s FitSummary) MaxCopies(request NodeFree) int64 {
+	var max int64
+	for _, free := range s {
+		if copies := free.copies(request); copies > max {
+			max = copies
+		}
+	}
+	return max
+}
+
+// copies returns how many copies of the request the free resources could host, the pods of the request is
+// always taken as 1
+func (n NodeFree) copies(request NodeFree) int64 {
+	copies := n.Pods
+	dimension := func(free, requested int64) {
+		if requested > 0 && free/requested < copies {
+			copies = free / requested
+		}
+	}
+	dimension(n.MilliCPU, request.MilliCPU)
+	dimension(n.Memory, request.Memory)
+	for name, quantity := range request.Extended {
+		dimension(n.Extended[name], quantity)
+	}
+	if copies < 0 {
+		return 0
+	}
+	return copies
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNewFitSummary(t *testing.T) {
+	frees := []NodeFree{
+		{MilliCPU: 1000, Memory: 4, Pods: 10},
+		{MilliCPU: 4000, Memory: 1, Pods: 10},
+		{MilliCPU: 500, Memory: 2, Pods: 10},
+		{MilliCPU: 2000, Memory: 2, Pods: 10},
+		{MilliCPU: 8000, Memory: 8, Pods: 0},
+	}
+	summary := NewFitSummary(frees, 0)
+	desired := FitSummary{
+		{MilliCPU: 4000, Memory: 1, Pods: 10},
+		{MilliCPU: 2000, Memory: 2, Pods: 10},
+		{MilliCPU: 1000, Memory: 4, Pods: 10},
+	}
+	if !reflect.DeepEqual(summary, desired) {
+		t.Fatalf("Desired %v, get %v", desired, summary)
+	}
+	if !summary.Fits(NodeFree{MilliCPU: 1500, Memory: 2, Pods: 1}) {
+		t.Error("Desired fit")
+	}
+	if summary.Fits(NodeFree{MilliCPU: 3000, Memory: 2, Pods: 1}) {
+		t.Error("Desired not fit")
+	}
+	limited := NewFitSummary(frees, 2)
+	if len(limited) != 2 {
+		t.Fatal("Desired summary limited to 2 entries")
+	}
+	// requests fitting any node should still fit the limited summary
+	for _, free := range desired {
+		if !limited.Fits(free) {
+			t.Errorf("Desired %v fits the limited summary %v", free, limited)
+		}
+	}
+}
+
+func TestFitSummaryExtended(t *testing.T) {
+	gpu := corev1.ResourceName("nvidia.com/gpu")
+	frees := []NodeFree{
+		{MilliCPU: 8000, Memory: 8, Pods: 10},
+		{MilliCPU: 2000, Memory: 2, Pods: 10, Extended: map[corev1.ResourceName]int64{gpu: 4}},
+		{MilliCPU: 1000, Memory: 1, Pods: 10, Extended: map[corev1.ResourceName]int64{gpu: 8}},
+	}
+	summary := NewFitSummary(frees, 0)
+	if len(summary) != 3 {
+		t.Fatalf("desire nodes with more gpus kept, real %v", summary)
+	}
+	if !summary.Fits(NodeFree{MilliCPU: 1000, Memory: 1, Pods: 1, Extended: map[corev1.ResourceName]int64{gpu: 6}}) {
+		t.Error("desire 6 gpus fit")
+	}
+	if summary.Fits(NodeFree{MilliCPU: 4000, Memory: 1, Pods: 1, Extended: map[corev1.ResourceName]int64{gpu: 1}}) {
+		t.Error("desire 4 cpus with a gpu not fit")
+	}
+	limited := NewFitSummary(frees, 2)
+	if !limited.Fits(NodeFree{MilliCPU: 1000, Memory: 1, Pods: 1, Extended: map[corev1.ResourceName]int64{gpu: 8}}) {
+		t.Errorf("desire 8 gpus fit the limited summary %v", limited)
+	}
+	if frees[1].Extended[gpu] != 4 {
+		t.Fatalf("desire free resources given not changed, real %v", frees[1].Extended)
+	}
+}
+
+func TestFitSummaryMaxCopies(t *testing.T) {
+	summary := FitSummary{
+		{MilliCPU: 4000, Memory: 1, Pods: 10},
+		{MilliCPU: 2000, Memory: 8, Pods: 3},
+	}
+	cases := []struct {
+		request NodeFree
+		copies  int64
+	}{
+		{NodeFree{MilliCPU: 1000, Pods: 1}, 4},
+		{NodeFree{MilliCPU: 500, Memory: 2, Pods: 1}, 3},
+		{NodeFree{Pods: 1}, 10},
+		{NodeFree{MilliCPU: 8000, Pods: 1}, 0},
+		{NodeFree{Pods: 1, Extended: map[corev1.ResourceName]int64{"nvidia.com/gpu": 1}}, 0},
+	}
+	for _, c := range cases {
+		if copies := summary.MaxCopies(c.request); copies != c.copies {
+			t.Errorf("desire %v copies of %v, real %v", c.copies, c.request, copies)
+		}
+	}
+}
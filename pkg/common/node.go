@@ -57,6 +57,17 @@ func (n *ProviderNode) SubResource(resource *Resource) error {
 	return nil
 }
 
+// SetResource replaces the resource of the node
+func (n *ProviderNode) SetResource(resource *Resource) error {
+	if n.Node == nil {
+		return fmt.Errorf("ProviderNode node has not init")
+	}
+	n.Lock()
+	defer n.Unlock()
+	resource.SetCapacityToNode(n.Node)
+	return nil
+}
+
 // DeepCopy deepcopy node with lock, to avoid concurrent read-write
 func (n *ProviderNode) DeepCopy() *corev1.Node {
 	n.Lock()
@@ -64,3 +75,25 @@ func (n *ProviderNode) DeepCopy() *corev1.Node {
 	n.Unlock()
 	return node
 }
+
+// SetCondition replaces the condition of the same type, or appends it if absent, the transition time is kept
+// if the status is not changed
+func (n *ProviderNode) SetCondition(condition corev1.NodeCondition) error {
+	if n.Node == nil {
+		return fmt.Errorf("ProviderNode node has not init")
+	}
+	n.Lock()
+	defer n.Unlock()
+	for i, c := range n.Status.Conditions {
+		if c.Type != condition.Type {
+			continue
+		}
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		n.Status.Conditions[i] = condition
+		return nil
+	}
+	n.Status.Conditions = append(n.Status.Conditions, condition)
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// QuotaLeft is the hard limits of a resource quota in a lower cluster minus its usage, with the scopes selecting
+// the pods it tracks
+type QuotaLeft struct {
+	Left          corev1.ResourceList         `json:"left"`
+	Scopes        []corev1.ResourceQuotaScope `json:"scopes,omitempty"`
+	ScopeSelector *corev1.ScopeSelector       `json:"scopeSelector,omitempty"`
+}
+
+// QuotaHeadroom is the quotas left in each namespace of a lower cluster, pods created there are rejected once
+// exceeding any quota matching them
+type QuotaHeadroom map[string][]QuotaLeft
+
+// NewQuotaHeadroom computes the headroom from the status of quotas, quotas not yet counted by the quota
+// controller of the lower cluster are skipped
+func NewQuotaHeadroom(quotas []corev1.ResourceQuota) QuotaHeadroom {
+	headroom := QuotaHeadroom{}
+	for _, quota := range quotas {
+		if len(quota.Status.Hard) == 0 {
+			continue
+		}
+		left := corev1.ResourceList{}
+		for name, hard := range quota.Status.Hard {
+			remaining := hard.DeepCopy()
+			if used, ok := quota.Status.Used[name]; ok {
+				remaining.Sub(used)
+			}
+			if remaining.Sign() < 0 {
+				remaining.Set(0)
+			}
+			left[name] = remaining
+		}
+		headroom[quota.Namespace] = append(headroom[quota.Namespace], QuotaLeft{
+			Left:          left,
+			Scopes:        quota.Spec.Scopes,
+			ScopeSelector: quota.Spec.ScopeSelector,
+		})
+	}
+	return headroom
+}
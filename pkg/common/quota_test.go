@@ -0,0 +1,56 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewQuotaHeadroom(t *testing.T) {
+	quotas := []corev1.ResourceQuota{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{
+					corev1.ResourceRequestsCPU: resource.MustParse("4"),
+					corev1.ResourcePods:        resource.MustParse("10"),
+				},
+				Used: corev1.ResourceList{
+					corev1.ResourceRequestsCPU: resource.MustParse("1500m"),
+					corev1.ResourcePods:        resource.MustParse("12"),
+				},
+			},
+		},
+		// not counted by the quota controller yet
+		{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "other"}},
+	}
+	headroom := NewQuotaHeadroom(quotas)
+	if len(headroom) != 1 || len(headroom["default"]) != 1 {
+		t.Fatalf("Desire 1 quota in namespace default, get %v", headroom)
+	}
+	left := headroom["default"][0].Left
+	if cpu := left[corev1.ResourceRequestsCPU]; !cpu.Equal(resource.MustParse("2500m")) {
+		t.Fatalf("Desire 2500m cpu left, get %v", cpu.String())
+	}
+	if pods := left[corev1.ResourcePods]; pods.Sign() != 0 {
+		t.Fatalf("Desire no pods left, get %v", pods.String())
+	}
+}
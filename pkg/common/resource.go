@@ -20,6 +20,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
 )
 
 // CustomResources is a key-value map for defining custom resources
@@ -79,6 +80,22 @@ func (r *Resource) Equal(other *Resource) bool {
 		EphemeralStorage.Equal(other.EphemeralStorage) && r.Custom.Equal(other.Custom)
 }
 
+// Extended returns the extended resources of the current one, e.g. nvidia.com/gpu and rdma/hca, which are
+// counted in integers, nil would be returned if there is none
+func (r *Resource) Extended() map[corev1.ResourceName]int64 {
+	var extended map[corev1.ResourceName]int64
+	for name, quota := range r.Custom {
+		if !v1helper.IsExtendedResourceName(name) {
+			continue
+		}
+		if extended == nil {
+			extended = map[corev1.ResourceName]int64{}
+		}
+		extended[name] = quota.Value()
+	}
+	return extended
+}
+
 // Add adds resource to the current one
 func (r *Resource) Add(nc *Resource) {
 	r.CPU.Add(nc.CPU)
@@ -117,6 +134,17 @@ func (r *Resource) Sub(nc *Resource) {
 	}
 }
 
+// Overcommit scales CPU and memory of the current one by the ratios, ratio not larger than 0 is ignored,
+// the ratios configured are validated by config.ValidateOvercommitRatios
+func (r *Resource) Overcommit(cpuRatio, memoryRatio float64) {
+	if cpuRatio > 0 && cpuRatio != 1 {
+		r.CPU = *resource.NewMilliQuantity(int64(float64(r.CPU.MilliValue())*cpuRatio), r.CPU.Format)
+	}
+	if memoryRatio > 0 && memoryRatio != 1 {
+		r.Memory = *resource.NewQuantity(int64(float64(r.Memory.Value())*memoryRatio), r.Memory.Format)
+	}
+}
+
 // SetCapacityToNode set the resource the virtual-kubelet node
 func (r *Resource) SetCapacityToNode(node *corev1.Node) {
 	var CPU, mem, Pods, empStorage resource.Quantity
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/testbase"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
@@ -47,3 +48,37 @@ func TestResource(t *testing.T) {
 		t.Fatalf("nodeRemoveCapacity unexpected %v", node1.Status.Capacity)
 	}
 }
+
+func TestResourceOvercommit(t *testing.T) {
+	r := &Resource{
+		CPU:    resource.MustParse("10"),
+		Memory: resource.MustParse("10Gi"),
+		Pods:   resource.MustParse("110"),
+	}
+	r.Overcommit(1.5, 0)
+	if !r.CPU.Equal(resource.MustParse("15")) || !r.Memory.Equal(resource.MustParse("10Gi")) ||
+		!r.Pods.Equal(resource.MustParse("110")) {
+		t.Fatalf("overcommit unexpected %v", r)
+	}
+	r.Overcommit(1, 0.5)
+	if !r.CPU.Equal(resource.MustParse("15")) || !r.Memory.Equal(resource.MustParse("5Gi")) {
+		t.Fatalf("overcommit unexpected %v", r)
+	}
+}
+
+func TestResourceExtended(t *testing.T) {
+	r := ConvertResource(corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("8"),
+		"nvidia.com/gpu":      resource.MustParse("4"),
+		"rdma/hca":            resource.MustParse("1"),
+		"hugepages-2Mi":       resource.MustParse("1Gi"),
+		corev1.ResourceMemory: resource.MustParse("32Gi"),
+	})
+	extended := r.Extended()
+	if len(extended) != 2 || extended["nvidia.com/gpu"] != 4 || extended["rdma/hca"] != 1 {
+		t.Fatalf("desire gpu 4 and hca 1, real %v", extended)
+	}
+	if extended = NewResource().Extended(); extended != nil {
+		t.Fatalf("desire no extended resources, real %v", extended)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// StorageClass is a storage class of a lower cluster and the topologies its volumes may be provisioned in
+type StorageClass struct {
+	Name              string                        `json:"name"`
+	Provisioner       string                        `json:"provisioner,omitempty"`
+	VolumeBindingMode storagev1.VolumeBindingMode   `json:"volumeBindingMode,omitempty"`
+	Default           bool                          `json:"default,omitempty"`
+	AllowedTopologies []corev1.TopologySelectorTerm `json:"allowedTopologies,omitempty"`
+}
+
+// StorageClasses is the storage classes of a lower cluster
+type StorageClasses []StorageClass
+
+// Get returns the storage class with the name, nil would be returned if not existing
+func (s StorageClasses) Get(name string) *StorageClass {
+	for i := range s {
+		if s[i].Name == name {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// Default returns the default storage class, nil would be returned if there is none
+func (s StorageClasses) Default() *StorageClass {
+	for i := range s {
+		if s[i].Default {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// WaitForFirstConsumer returns if the volumes of the class are provisioned once their pods are scheduled in the
+// lower cluster, so the topology of the volumes depends on the pods
+func (c *StorageClass) WaitForFirstConsumer() bool {
+	return c.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeTaints is the distinct sets of taints repelling pods, NoSchedule and NoExecute ones, of the nodes in a lower
+// cluster, a pod fits the cluster by taints only if it tolerates every taint of any set
+type NodeTaints [][]corev1.Taint
+
+// NewNodeTaints collects the distinct sets of taints repelling pods of nodes, nil would be returned if there are
+// more than max sets, so that the cluster is not constrained by an incomplete list
+func NewNodeTaints(nodes []*corev1.Node, max int) NodeTaints {
+	sets := NodeTaints{}
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		var taints []corev1.Taint
+		for _, taint := range node.Spec.Taints {
+			if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+				continue
+			}
+			taints = append(taints, corev1.Taint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect})
+		}
+		sort.Slice(taints, func(i, j int) bool {
+			if taints[i].Key != taints[j].Key {
+				return taints[i].Key < taints[j].Key
+			}
+			return taints[i].Effect < taints[j].Effect
+		})
+		key := ""
+		for _, taint := range taints {
+			key += taint.ToString() + ";"
+		}
+		if seen[key] {
+			continue
+		}
+		if len(sets) == max {
+			return nil
+		}
+		seen[key] = true
+		sets = append(sets, taints)
+	}
+	return sets
+}
+
+// ToleratedBy returns if the tolerations tolerate every taint of any set, a cluster without ready nodes is left
+// to the fit summary
+func (t NodeTaints) ToleratedBy(tolerations []corev1.Toleration) bool {
+	if len(t) == 0 {
+		return true
+	}
+	for _, taints := range t {
+		if toleratesAll(tolerations, taints) {
+			return true
+		}
+	}
+	return false
+}
+
+// toleratesAll returns if each of taints is tolerated by any of the tolerations
+func toleratesAll(tolerations []corev1.Toleration, taints []corev1.Taint) bool {
+	for i := range taints {
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(&taints[i]) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDecode(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tensile-kube.io/v1alpha1",
+		"kind":       "TensileConfig",
+		"metadata":   map[string]interface{}{"name": "default"},
+		"spec": map[string]interface{}{
+			"provider": map[string]interface{}{"cpuOvercommitRatio": int64(2)},
+			"webhook":  map[string]interface{}{"ignoreSelectorKeys": []interface{}{"zone"}},
+			"descheduler": map[string]interface{}{
+				"strategies": map[string]interface{}{
+					"PodLifeTime": map[string]interface{}{"enabled": true},
+				},
+			},
+		},
+	}}
+	config, err := decode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu, mem := config.Spec.Provider.OvercommitRatios(1, 1.5)
+	if cpu != 2 || mem != 1.5 {
+		t.Fatalf("desire ratios 2 and 1.5, real %v and %v", cpu, mem)
+	}
+	keys, inject := config.Spec.Webhook.MutationRules([]string{"clusterID"}, true)
+	if !reflect.DeepEqual(keys, []string{"zone"}) || !inject {
+		t.Fatalf("desire keys [zone] and injecting, real %v and %v", keys, inject)
+	}
+	if !config.Spec.Descheduler.Strategies["PodLifeTime"].Enabled {
+		t.Fatalf("desire PodLifeTime enabled, real %+v", config.Spec.Descheduler.Strategies)
+	}
+}
+
+func TestDecodeInvalidRatios(t *testing.T) {
+	for _, ratio := range []interface{}{int64(0), -1.5} {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "default"},
+			"spec": map[string]interface{}{
+				"provider": map[string]interface{}{"memoryOvercommitRatio": ratio},
+			},
+		}}
+		if _, err := decode(obj); err == nil {
+			t.Fatalf("desire memory overcommit ratio %v rejected", ratio)
+		}
+	}
+}
+
+func TestFallBackToFlags(t *testing.T) {
+	spec := &TensileConfigSpec{}
+	cpu, mem := spec.Provider.OvercommitRatios(1.2, 1.5)
+	if cpu != 1.2 || mem != 1.5 {
+		t.Fatalf("desire ratios of flags, real %v and %v", cpu, mem)
+	}
+	keys, inject := spec.Webhook.MutationRules([]string{"clusterID"}, false)
+	if !reflect.DeepEqual(keys, []string{"clusterID"}) || inject {
+		t.Fatalf("desire rules of flags, real %v and %v", keys, inject)
+	}
+}
+
+func TestTaintsOf(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"provider": map[string]interface{}{
+				"taints": []interface{}{
+					map[string]interface{}{"key": "all", "effect": "NoSchedule"},
+					map[string]interface{}{"key": "a", "value": "true", "effect": "NoExecute",
+						"clusters": []interface{}{"cluster-a"}},
+				},
+			},
+		},
+	}}
+	config, err := decode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taints := config.Spec.Provider.TaintsOf("cluster-a")
+	if len(taints) != 2 || taints[0].Key != "all" || taints[1].Key != "a" || taints[1].Value != "true" {
+		t.Fatalf("desire taints all and a, real %+v", taints)
+	}
+	if taints = config.Spec.Provider.TaintsOf("cluster-b"); len(taints) != 1 || taints[0].Key != "all" {
+		t.Fatalf("desire taint all, real %+v", taints)
+	}
+	var spec *TensileConfigSpec
+	if taints = spec.Provider.TaintsOf("cluster-a"); taints != nil {
+		t.Fatalf("desire no taints, real %+v", taints)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"provider": map[string]interface{}{
+				"propagation": map[string]interface{}{
+					"labels": map[string]interface{}{"include": []interface{}{"topology.kubernetes.io/*"},
+						"exclude": []interface{}{"topology.kubernetes.io/region"}},
+					"taints": map[string]interface{}{"aggregation": "Any"},
+				},
+			},
+		},
+	}}
+	if _, err := decode(obj); err == nil {
+		t.Fatal("desire unknown aggregation rejected")
+	}
+	unstructured.RemoveNestedField(obj.Object, "spec", "provider", "propagation", "taints")
+	config, err := decode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := config.Spec.Provider.PropagationOf().Labels
+	if !rule.Selects("topology.kubernetes.io/zone") || rule.Selects("topology.kubernetes.io/region") ||
+		rule.Selects("kubernetes.io/hostname") || rule.Union() {
+		t.Fatalf("desire common zone label selected only, real %+v", rule)
+	}
+	var spec *TensileConfigSpec
+	if spec.Provider.PropagationOf() != nil {
+		t.Fatal("desire no propagation")
+	}
+}
+
+func TestSyncFiltersAndInjectionRules(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"provider": map[string]interface{}{
+				"ignoreLabels":       []interface{}{"zone"},
+				"excludedNamespaces": []interface{}{"batch"},
+			},
+			"webhook": map[string]interface{}{
+				"tolerations": []interface{}{
+					map[string]interface{}{"key": "virtual-kubelet.io/provider", "operator": "Exists"},
+				},
+				"skippedNamespaces": []interface{}{"monitoring"},
+			},
+		},
+	}}
+	config, err := decode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, namespaces := config.Spec.Provider.SyncFilters([]string{"batch"})
+	if !reflect.DeepEqual(labels, []string{"zone"}) || !reflect.DeepEqual(namespaces, []string{"batch"}) {
+		t.Fatalf("desire labels [zone] and namespaces [batch], real %v and %v", labels, namespaces)
+	}
+	tolerations, skipped := config.Spec.Webhook.InjectionRules()
+	if len(tolerations) != 1 || tolerations[0].Key != "virtual-kubelet.io/provider" ||
+		!reflect.DeepEqual(skipped, []string{"monitoring"}) {
+		t.Fatalf("desire a toleration and namespaces [monitoring], real %+v and %v", tolerations, skipped)
+	}
+	var spec *TensileConfigSpec
+	if labels, namespaces = spec.Provider.SyncFilters([]string{"batch"}); !reflect.DeepEqual(labels,
+		[]string{"batch"}) || namespaces != nil {
+		t.Fatalf("desire filters of flags, real %v and %v", labels, namespaces)
+	}
+}
+
+func TestDecodeInvalidFilters(t *testing.T) {
+	for _, spec := range []map[string]interface{}{
+		{"provider": map[string]interface{}{"ignoreLabels": []interface{}{"not a label"}}},
+		{"provider": map[string]interface{}{"excludedNamespaces": []interface{}{"Batch"}}},
+		{"webhook": map[string]interface{}{"skippedNamespaces": []interface{}{""}}},
+		{"webhook": map[string]interface{}{"tolerations": []interface{}{map[string]interface{}{"operator": "Equal"}}}},
+		{"webhook": map[string]interface{}{"tolerations": []interface{}{
+			map[string]interface{}{"key": "a", "operator": "Exists", "value": "b"}}}},
+		{"webhook": map[string]interface{}{"tolerations": []interface{}{
+			map[string]interface{}{"key": "a", "effect": "NoSchedule", "tolerationSeconds": int64(10)}}}},
+	} {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		if _, err := decode(obj); err == nil {
+			t.Fatalf("desire %v rejected", spec)
+		}
+	}
+}
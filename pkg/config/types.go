@@ -0,0 +1,362 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/descheduler/pkg/api/v1alpha1"
+)
+
+// Resource is the resource of the cluster scoped TensileConfig, see manifeasts/tensile-config-crd.yaml
+var Resource = schema.GroupVersionResource{Group: "tensile-kube.io", Version: "v1alpha1", Resource: "tensileconfigs"}
+
+// TensileConfig is the configuration of the components applied live, fields not set fall back to the flags
+type TensileConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              TensileConfigSpec `json:"spec"`
+}
+
+// TensileConfigSpec is the configuration of each component
+type TensileConfigSpec struct {
+	Provider    *ProviderConfig    `json:"provider,omitempty"`
+	Webhook     *WebhookConfig     `json:"webhook,omitempty"`
+	Descheduler *DeschedulerConfig `json:"descheduler,omitempty"`
+}
+
+// ProviderConfig is the capacity policy of virtual nodes and the transformations of objects they create
+type ProviderConfig struct {
+	// CPUOvercommitRatio and MemoryOvercommitRatio override --cpu-overcommit-ratio and --memory-overcommit-ratio
+	CPUOvercommitRatio    *float64 `json:"cpuOvercommitRatio,omitempty"`
+	MemoryOvercommitRatio *float64 `json:"memoryOvercommitRatio,omitempty"`
+	// Transformations patch objects before they are created in lower clusters, in order
+	Transformations []Transformation `json:"transformations,omitempty"`
+	// ResourceRules rewrite the resources of containers of pods created in lower clusters, in order
+	ResourceRules []ResourceRule `json:"resourceRules,omitempty"`
+	// Taints are put on the virtual nodes of the selected lower clusters
+	Taints []ClusterTaint `json:"taints,omitempty"`
+	// Propagation selects the labels, annotations and taints of the ready nodes of lower clusters put on their
+	// virtual nodes, nothing is propagated if not set
+	Propagation *NodePropagation `json:"propagation,omitempty"`
+	// IgnoreLabels overrides --ignore-labels, the labels moved out of lower pods into their node selection
+	IgnoreLabels []string `json:"ignoreLabels,omitempty"`
+	// ExcludedNamespaces are the upper namespaces whose new pods are not created in lower clusters, the pods
+	// created before are still updated and deleted
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+}
+
+const (
+	// AggregateCommon propagates the entries all nodes have in common
+	AggregateCommon = "Common"
+	// AggregateUnion propagates the entries any node has, differing values of a label are dropped and those
+	// of an annotation are joined by ","
+	AggregateUnion = "Union"
+)
+
+// NodePropagation is the rules of the labels, annotations and taints of lower nodes put on the virtual node
+type NodePropagation struct {
+	Labels      PropagationRule `json:"labels,omitempty"`
+	Annotations PropagationRule `json:"annotations,omitempty"`
+	Taints      PropagationRule `json:"taints,omitempty"`
+}
+
+// PropagationRule selects the keys propagated and how the entries of nodes are aggregated
+type PropagationRule struct {
+	// Include are the keys propagated, a key ending with "*" matches the prefix, nothing is propagated if empty
+	Include []string `json:"include,omitempty"`
+	// Exclude are the keys never propagated even if included, in the same form as Include
+	Exclude []string `json:"exclude,omitempty"`
+	// Aggregation is one of Common and Union, Common if empty
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+// ClusterTaint is a taint of the virtual nodes of the selected lower clusters
+type ClusterTaint struct {
+	corev1.Taint
+	// Clusters are the names of the lower clusters tainted, all clusters if empty
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// Transformation patches the objects of a kind created in the selected lower clusters, the json patch is
+// applied before the strategic merge patch if both are set
+type Transformation struct {
+	// Clusters are the names of the lower clusters the transformation applies to, all clusters if empty
+	Clusters []string `json:"clusters,omitempty"`
+	// Kind of the objects, one of Pod, ConfigMap, Secret and PersistentVolumeClaim
+	Kind string `json:"kind"`
+	// JSONPatch is a RFC 6902 json patch
+	JSONPatch json.RawMessage `json:"jsonPatch,omitempty"`
+	// StrategicMergePatch is a strategic merge patch
+	StrategicMergePatch json.RawMessage `json:"strategicMergePatch,omitempty"`
+}
+
+// ResourceRule rewrites a resource in the requests and limits of containers of pods created in the selected
+// lower clusters, the quantity is scaled, renamed and then capped
+type ResourceRule struct {
+	// Clusters are the names of the lower clusters the rule applies to, all clusters if empty
+	Clusters []string `json:"clusters,omitempty"`
+	// Resource is the name of the resource rewritten, e.g. memory
+	Resource corev1.ResourceName `json:"resource"`
+	// Scale multiplies the quantity, rounded up to a milli cpu or an integer of other resources
+	Scale *float64 `json:"scale,omitempty"`
+	// RenameTo translates the resource to another one, e.g. hugepages-2Mi to hugepages-1Gi, the quantity of
+	// hugepages is rounded up to a multiple of the page size
+	RenameTo corev1.ResourceName `json:"renameTo,omitempty"`
+	// Max caps the quantity
+	Max *resource.Quantity `json:"max,omitempty"`
+	// CapAtNodeSize caps the quantity at the largest allocatable of the ready lower nodes
+	CapAtNodeSize bool `json:"capAtNodeSize,omitempty"`
+}
+
+// WebhookConfig is the mutation rules of the webhook
+type WebhookConfig struct {
+	// IgnoreSelectorKeys overrides --ignore-selector-keys
+	IgnoreSelectorKeys []string `json:"ignoreSelectorKeys,omitempty"`
+	// InjectClusterIdentity overrides --inject-cluster-identity
+	InjectClusterIdentity *bool `json:"injectClusterIdentity,omitempty"`
+	// Tolerations are added to the virtual pods created, e.g. to tolerate the taints of virtual nodes
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// SkippedNamespaces are the namespaces whose pods are not mutated, besides kube-system
+	SkippedNamespaces []string `json:"skippedNamespaces,omitempty"`
+}
+
+// DeschedulerConfig is the strategies of the descheduler
+type DeschedulerConfig struct {
+	// Strategies replaces the strategies in --policy-config-file
+	Strategies v1alpha1.StrategyList `json:"strategies,omitempty"`
+	// MaxNoOfPodsToEvictPerNode overrides --max-pods-to-evict-per-node
+	MaxNoOfPodsToEvictPerNode *int `json:"maxNoOfPodsToEvictPerNode,omitempty"`
+	// MaintenanceWindows overrides --maintenance-windows, an empty list allows descheduling at any time
+	MaintenanceWindows []string `json:"maintenanceWindows,omitempty"`
+}
+
+// ValidateOvercommitRatios checks the cpu and memory overcommit ratios are larger than 0
+func ValidateOvercommitRatios(cpu, memory float64) error {
+	if cpu <= 0 {
+		return fmt.Errorf("cpu overcommit ratio %v must be larger than 0", cpu)
+	}
+	if memory <= 0 {
+		return fmt.Errorf("memory overcommit ratio %v must be larger than 0", memory)
+	}
+	return nil
+}
+
+// validate checks the overcommit ratios set are larger than 0, the aggregations of propagation are known and
+// the labels and namespaces of sync filters are valid names
+func (c *ProviderConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, key := range c.IgnoreLabels {
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return fmt.Errorf("invalid ignored label %q: %v", key, strings.Join(errs, ", "))
+		}
+	}
+	if err := validateNamespaces(c.ExcludedNamespaces); err != nil {
+		return err
+	}
+	if p := c.Propagation; p != nil {
+		for _, rule := range []PropagationRule{p.Labels, p.Annotations, p.Taints} {
+			switch rule.Aggregation {
+			case "", AggregateCommon, AggregateUnion:
+			default:
+				return fmt.Errorf("unknown aggregation %q of propagation", rule.Aggregation)
+			}
+		}
+	}
+	// the ratios not set fall back to the flags validated when started
+	return ValidateOvercommitRatios(c.OvercommitRatios(1, 1))
+}
+
+// validate checks the tolerations are well formed and the namespaces skipped are valid names
+func (c *WebhookConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, t := range c.Tolerations {
+		switch t.Operator {
+		case corev1.TolerationOpEqual, "":
+			if len(t.Key) == 0 {
+				return fmt.Errorf("toleration of empty key must use operator %v", corev1.TolerationOpExists)
+			}
+		case corev1.TolerationOpExists:
+			if len(t.Value) != 0 {
+				return fmt.Errorf("toleration %v of operator %v must not have a value", t.Key, t.Operator)
+			}
+		default:
+			return fmt.Errorf("unknown operator %q of toleration %v", t.Operator, t.Key)
+		}
+		switch t.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("unknown effect %q of toleration %v", t.Effect, t.Key)
+		}
+		if t.TolerationSeconds != nil && t.Effect != corev1.TaintEffectNoExecute {
+			return fmt.Errorf("toleration %v must have effect %v to set seconds", t.Key,
+				corev1.TaintEffectNoExecute)
+		}
+	}
+	return validateNamespaces(c.SkippedNamespaces)
+}
+
+func validateNamespaces(namespaces []string) error {
+	for _, namespace := range namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+			return fmt.Errorf("invalid namespace %q: %v", namespace, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// OvercommitRatios returns the overcommit ratios set, or the ones of flags if not set
+func (c *ProviderConfig) OvercommitRatios(cpu, memory float64) (float64, float64) {
+	if c == nil {
+		return cpu, memory
+	}
+	if c.CPUOvercommitRatio != nil {
+		cpu = *c.CPUOvercommitRatio
+	}
+	if c.MemoryOvercommitRatio != nil {
+		memory = *c.MemoryOvercommitRatio
+	}
+	return cpu, memory
+}
+
+// TransformationsOf returns the transformations applying to the lower cluster of the name
+func (c *ProviderConfig) TransformationsOf(cluster string) []Transformation {
+	if c == nil {
+		return nil
+	}
+	var transformations []Transformation
+	for _, t := range c.Transformations {
+		if selects(t.Clusters, cluster) {
+			transformations = append(transformations, t)
+		}
+	}
+	return transformations
+}
+
+// ResourceRulesOf returns the resource rules applying to the lower cluster of the name
+func (c *ProviderConfig) ResourceRulesOf(cluster string) []ResourceRule {
+	if c == nil {
+		return nil
+	}
+	var rules []ResourceRule
+	for _, r := range c.ResourceRules {
+		if selects(r.Clusters, cluster) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// TaintsOf returns the taints of the lower cluster of the name
+func (c *ProviderConfig) TaintsOf(cluster string) []corev1.Taint {
+	if c == nil {
+		return nil
+	}
+	var taints []corev1.Taint
+	for _, t := range c.Taints {
+		if selects(t.Clusters, cluster) {
+			taints = append(taints, t.Taint)
+		}
+	}
+	return taints
+}
+
+// SyncFilters returns the labels ignored set, or the ones of flags if not set, and the namespaces excluded
+func (c *ProviderConfig) SyncFilters(ignoreLabels []string) ([]string, []string) {
+	if c == nil {
+		return ignoreLabels, nil
+	}
+	if c.IgnoreLabels != nil {
+		ignoreLabels = c.IgnoreLabels
+	}
+	return ignoreLabels, c.ExcludedNamespaces
+}
+
+// PropagationOf returns the propagation of lower nodes, nil if not set
+func (c *ProviderConfig) PropagationOf() *NodePropagation {
+	if c == nil {
+		return nil
+	}
+	return c.Propagation
+}
+
+// Selects tells whether the key is included and not excluded by the rule
+func (r PropagationRule) Selects(key string) bool {
+	return matchesKey(r.Include, key) && !matchesKey(r.Exclude, key)
+}
+
+// Union tells whether the entries of any node are propagated
+func (r PropagationRule) Union() bool {
+	return r.Aggregation == AggregateUnion
+}
+
+// matchesKey tells whether the key is one of patterns, or has the prefix of a pattern ending with "*"
+func matchesKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if pattern == key ||
+			strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects tells whether the cluster is in clusters, empty clusters select all
+func selects(clusters []string, cluster string) bool {
+	if len(clusters) == 0 {
+		return true
+	}
+	for _, name := range clusters {
+		if name == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// MutationRules returns the mutation rules set, or the ones of flags if not set
+func (c *WebhookConfig) MutationRules(ignoreSelectorKeys []string, injectClusterIdentity bool) ([]string, bool) {
+	if c == nil {
+		return ignoreSelectorKeys, injectClusterIdentity
+	}
+	if c.IgnoreSelectorKeys != nil {
+		ignoreSelectorKeys = c.IgnoreSelectorKeys
+	}
+	if c.InjectClusterIdentity != nil {
+		injectClusterIdentity = *c.InjectClusterIdentity
+	}
+	return ignoreSelectorKeys, injectClusterIdentity
+}
+
+// InjectionRules returns the tolerations added to virtual pods and the namespaces whose pods are not mutated
+func (c *WebhookConfig) InjectionRules() ([]corev1.Toleration, []string) {
+	if c == nil {
+		return nil, nil
+	}
+	return c.Tolerations, c.SkippedNamespaces
+}
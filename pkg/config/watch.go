@@ -0,0 +1,83 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// Watch watches the TensileConfig of the name until stopCh closed, apply is called with its spec once it is
+// added or updated, and with an empty spec once it is deleted, so that the flags take effect again
+func Watch(client dynamic.Interface, name string, apply func(spec *TensileConfigSpec), stopCh <-chan struct{}) {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, metav1.NamespaceAll,
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		})
+	applyObject := func(obj interface{}) {
+		config, err := decode(obj)
+		if err != nil {
+			klog.Errorf("Skip TensileConfig %v: %v", name, err)
+			return
+		}
+		klog.Infof("Apply TensileConfig %v of resource version %v", name, config.ResourceVersion)
+		apply(&config.Spec)
+	}
+	factory.ForResource(Resource).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: applyObject,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			applyObject(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.Infof("TensileConfig %v deleted, fall back to flags", name)
+			apply(&TensileConfigSpec{})
+		},
+	})
+	factory.Start(stopCh)
+}
+
+// decode converts the unstructured object to TensileConfig by json, so that integers in it are
+// accepted as floats
+func decode(obj interface{}) (*TensileConfig, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, err
+	}
+	config := &TensileConfig{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if err = config.Spec.Provider.validate(); err != nil {
+		return nil, err
+	}
+	if err = config.Spec.Webhook.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
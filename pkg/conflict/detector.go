@@ -0,0 +1,293 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package conflict detects other writers in the lower clusters fighting with the provider over the fields it
+// manages, and resolves the fights instead of rewriting the objects in a loop
+package conflict
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/alert"
+)
+
+// Resolution is how the provider deals with objects conflicting with another writer
+type Resolution string
+
+const (
+	// Force keeps rewriting the conflicting objects, the conflicts are only reported
+	Force Resolution = "Force"
+	// BackOff rewrites a conflicting object at most once a period, which is doubled after each rewrite
+	BackOff Resolution = "BackOff"
+	// Alert stops rewriting a conflicting object and alerts it, until the desired state of the object changes
+	Alert Resolution = "Alert"
+
+	// Reason is the reason of alerts of conflicting objects
+	Reason = "ConflictingWriter"
+
+	initialBackOff = time.Minute
+)
+
+// ParseResolution returns the resolution of the name
+func ParseResolution(name string) (Resolution, error) {
+	switch r := Resolution(name); r {
+	case Force, BackOff, Alert:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown conflict resolution %q, expected one of %v, %v and %v", name, Force, BackOff,
+		Alert)
+}
+
+// object is the writes of the provider to an object
+type object struct {
+	kind string
+	// written is the hash of the desired state last written
+	written string
+	// states are the hashes of the object written, as sent and as stored after admission
+	states []string
+	// reverted is the state of the object last seen reverted, it is counted once until written again
+	reverted string
+	// reverts are the times the object is seen reverted within the window
+	reverts     []time.Time
+	conflicting bool
+	backOff     time.Duration
+	until       time.Time
+}
+
+// Detector tracks the writes of the provider to the objects of a lower cluster, and the objects seen in the
+// watch events of the lower cluster. An object changed from the state written means another writer reverted
+// it, and the object is conflicting once it is reverted threshold times within the window
+type Detector struct {
+	cluster    string
+	threshold  int
+	window     time.Duration
+	resolution Resolution
+	alerts     *alert.Tracker
+	now        func() time.Time
+
+	lock    sync.Mutex
+	objects map[string]*object
+}
+
+// NewDetector returns a detector of conflicts in the cluster, conflicting objects are alerted by the tracker
+// with the Alert resolution, methods of a nil detector do nothing
+func NewDetector(cluster string, threshold int, window time.Duration, resolution Resolution,
+	alerts *alert.Tracker) *Detector {
+	registerMetrics()
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Detector{
+		cluster:    cluster,
+		threshold:  threshold,
+		window:     window,
+		resolution: resolution,
+		alerts:     alerts,
+		now:        time.Now,
+		objects:    map[string]*object{},
+	}
+}
+
+// Hash returns the hash of the desired state made of the fields
+func Hash(fields ...interface{}) string {
+	h := fnv.New64a()
+	for _, field := range fields {
+		data, err := json.Marshal(field)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%#v", field))
+		}
+		h.Write(data)
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// Allow returns whether the desired state could be written to the object of the key now
+func (d *Detector) Allow(key, desired string) bool {
+	if d == nil {
+		return true
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	o, ok := d.objects[key]
+	if !ok || o.written != desired {
+		return true
+	}
+	return d.allow(o)
+}
+
+// Writing records the desired state and the state of the object about to be written, it is recorded before
+// the write, so that the watch event of the write is not taken as a revert
+func (d *Detector) Writing(key string, ref corev1.ObjectReference, desired, state string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	o, ok := d.objects[key]
+	if !ok {
+		o = &object{kind: ref.Kind}
+		d.objects[key] = o
+	}
+	o.states, o.reverted = []string{state}, ""
+	if o.written != desired {
+		// the desired state changed, the write is not a fight
+		d.resolve(key, o)
+		o.written, o.reverts = desired, nil
+		return
+	}
+	if o.conflicting && d.resolution == BackOff {
+		o.backOff *= 2
+		if o.backOff < initialBackOff {
+			o.backOff = initialBackOff
+		}
+		if o.backOff > d.window && d.window > initialBackOff {
+			o.backOff = d.window
+		}
+		o.until = d.now().Add(o.backOff)
+	}
+}
+
+// Written records the state of the object stored by the lower cluster, which may be changed by admission
+func (d *Detector) Written(key, state string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if o, ok := d.objects[key]; ok && !o.isWritten(state) {
+		o.states = append(o.states, state)
+	}
+}
+
+// Observed checks the state of the object seen in a watch event of the lower cluster, a state other than
+// the written ones means another writer reverted the object. It returns whether the written state should
+// be restored now, objects not written by the provider are ignored
+func (d *Detector) Observed(key string, ref corev1.ObjectReference, state string) bool {
+	if d == nil {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	o, ok := d.objects[key]
+	if !ok || len(o.states) == 0 || o.isWritten(state) {
+		return false
+	}
+	if o.reverted == state {
+		// the same revert seen again, e.g. by a status update
+		return d.allow(o)
+	}
+	now := d.now()
+	o.reverted = state
+	rewrites.WithLabelValues(d.cluster, o.kind).Inc()
+	o.reverts = append(pruneBefore(o.reverts, now.Add(-d.window)), now)
+	if !o.conflicting && len(o.reverts) >= d.threshold {
+		o.conflicting = true
+		conflictingObjects.WithLabelValues(d.cluster, o.kind).Inc()
+		klog.Warningf("%v %v/%v is reverted %d times in %v by another writer in cluster %v, resolve it by %v",
+			ref.Kind, ref.Namespace, ref.Name, len(o.reverts), d.window, d.cluster, d.resolution)
+		if d.resolution == BackOff {
+			o.backOff = initialBackOff
+			o.until = now.Add(o.backOff)
+		}
+	}
+	if o.conflicting && d.resolution == Alert {
+		d.alerts.Failed(key, ref, Reason, fmt.Errorf("%v %v/%v is reverted %d times in %v by another writer",
+			ref.Kind, ref.Namespace, ref.Name, len(o.reverts), d.window))
+	}
+	return d.allow(o)
+}
+
+// allow returns whether the object could be written now by the resolution
+func (d *Detector) allow(o *object) bool {
+	if !o.conflicting {
+		return true
+	}
+	switch d.resolution {
+	case BackOff:
+		return !d.now().Before(o.until)
+	case Alert:
+		return false
+	}
+	return true
+}
+
+// isWritten returns whether the state is written by the provider
+func (o *object) isWritten(state string) bool {
+	for _, s := range o.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Forget drops the object of the key once it is deleted
+func (d *Detector) Forget(key string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if o, ok := d.objects[key]; ok {
+		d.resolve(key, o)
+		delete(d.objects, key)
+	}
+}
+
+// Conflicting returns the keys of the conflicting objects in order
+func (d *Detector) Conflicting() []string {
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var keys []string
+	for key, o := range d.objects {
+		if o.conflicting {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolve clears the conflict of the object
+func (d *Detector) resolve(key string, o *object) {
+	if !o.conflicting {
+		return
+	}
+	o.conflicting, o.backOff, o.until = false, 0, time.Time{}
+	conflictingObjects.WithLabelValues(d.cluster, o.kind).Dec()
+	d.alerts.Resolved(key)
+}
+
+// pruneBefore drops the times before the deadline, the times are in order
+func pruneBefore(times []time.Time, deadline time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(deadline) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conflict
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var object = corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "test"}
+
+func TestParseResolution(t *testing.T) {
+	if r, err := ParseResolution("BackOff"); err != nil || r != BackOff {
+		t.Fatalf("desire BackOff, real %v, %v", r, err)
+	}
+	if _, err := ParseResolution("Ignore"); err == nil {
+		t.Fatal("desire error of unknown resolution, real nil")
+	}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	now := time.Now()
+	d := NewDetector("vk", 3, 10*time.Minute, Alert, nil)
+	d.now = func() time.Time { return now }
+	key := "pod/default/test"
+	desired := Hash(map[string]string{"app": "web"})
+	written, reverted := Hash("written"), Hash("reverted")
+
+	if d.Observed(key, object, reverted) {
+		t.Fatal("desire objects not written by the provider ignored")
+	}
+	for i := 0; i < 2; i++ {
+		if !d.Allow(key, desired) {
+			t.Fatalf("desire writes allowed before conflicting, real denied at write %d", i)
+		}
+		d.Writing(key, object, desired, written)
+		// the event of the write itself
+		if d.Observed(key, object, written) {
+			t.Fatal("desire the write of the provider not taken as a revert")
+		}
+		// another writer reverts it, seen twice by the following status updates
+		if !d.Observed(key, object, reverted) || !d.Observed(key, object, reverted) {
+			t.Fatalf("desire the revert restored before conflicting, real denied at revert %d", i)
+		}
+		now = now.Add(time.Minute)
+	}
+	if keys := d.Conflicting(); len(keys) != 0 {
+		t.Fatalf("desire no conflict after 2 reverts, real %v", keys)
+	}
+	d.Writing(key, object, desired, written)
+	if d.Observed(key, object, reverted) {
+		t.Fatal("desire restoring denied by Alert")
+	}
+	if keys := d.Conflicting(); len(keys) != 1 || keys[0] != key {
+		t.Fatalf("desire pod conflicting after 3 reverts, real %v", keys)
+	}
+	if d.Allow(key, desired) {
+		t.Fatal("desire rewriting denied by Alert")
+	}
+
+	// a new desired state is written and clears the conflict
+	changed := Hash(map[string]string{"app": "api"})
+	if !d.Allow(key, changed) {
+		t.Fatal("desire writing changed state allowed")
+	}
+	d.Writing(key, object, changed, Hash("changed"))
+	if keys := d.Conflicting(); len(keys) != 0 {
+		t.Fatalf("desire conflict resolved, real %v", keys)
+	}
+}
+
+func TestStateChangedByAdmission(t *testing.T) {
+	d := NewDetector("vk", 1, 10*time.Minute, Force, nil)
+	d.Writing("pod/default/test", object, "a", "sent")
+	d.Written("pod/default/test", "stored")
+	if d.Observed("pod/default/test", object, "sent") || d.Observed("pod/default/test", object, "stored") {
+		t.Fatal("desire the states written not taken as reverts")
+	}
+	if keys := d.Conflicting(); len(keys) != 0 {
+		t.Fatalf("desire no conflict, real %v", keys)
+	}
+}
+
+func TestRevertsOutOfWindow(t *testing.T) {
+	now := time.Now()
+	d := NewDetector("vk", 2, 10*time.Minute, Force, nil)
+	d.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		d.Writing("pod/default/test", object, "a", "written")
+		if !d.Observed("pod/default/test", object, "reverted") {
+			t.Fatal("desire restoring allowed by Force")
+		}
+		now = now.Add(11 * time.Minute)
+	}
+	if keys := d.Conflicting(); len(keys) != 0 {
+		t.Fatalf("desire no conflict of sparse reverts, real %v", keys)
+	}
+}
+
+func TestBackOff(t *testing.T) {
+	now := time.Now()
+	d := NewDetector("vk", 1, 10*time.Minute, BackOff, nil)
+	d.now = func() time.Time { return now }
+	d.Writing("pod/default/test", object, "a", "written")
+	if d.Observed("pod/default/test", object, "reverted") {
+		t.Fatal("desire restoring denied in back-off")
+	}
+	if d.Allow("pod/default/test", "a") {
+		t.Fatal("desire rewriting denied in back-off")
+	}
+	now = now.Add(initialBackOff)
+	if !d.Observed("pod/default/test", object, "reverted") || !d.Allow("pod/default/test", "a") {
+		t.Fatal("desire restoring allowed after back-off")
+	}
+	d.Writing("pod/default/test", object, "a", "written")
+	d.Observed("pod/default/test", object, "reverted")
+	now = now.Add(initialBackOff)
+	if d.Allow("pod/default/test", "a") {
+		t.Fatal("desire back-off doubled")
+	}
+
+	d.Forget("pod/default/test")
+	if !d.Allow("pod/default/test", "a") || len(d.Conflicting()) != 0 {
+		t.Fatal("desire object forgotten")
+	}
+}
+
+func TestNilDetector(t *testing.T) {
+	var d *Detector
+	if !d.Allow("key", "a") {
+		t.Fatal("desire nil detector allowing writes")
+	}
+	d.Writing("key", object, "a", "b")
+	d.Written("key", "b")
+	if d.Observed("key", object, "c") {
+		t.Fatal("desire nil detector ignoring reverts")
+	}
+	d.Forget("key")
+	if keys := d.Conflicting(); keys != nil {
+		t.Fatalf("desire no conflicts, real %v", keys)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conflict
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const subsystem = "tensile_kube"
+
+var (
+	rewrites = metrics.NewCounterVec(&metrics.CounterOpts{
+		Subsystem:      subsystem,
+		Name:           "conflicting_rewrites_total",
+		Help:           "Number of writes to objects in lower clusters reverted by another writer",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "kind"})
+	conflictingObjects = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Subsystem:      subsystem,
+		Name:           "conflicting_objects",
+		Help:           "Number of objects in lower clusters conflicting with another writer",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "kind"})
+
+	registerOnce sync.Once
+)
+
+// registerMetrics registers the metrics to the legacy registry served at /metrics
+func registerMetrics() {
+	registerOnce.Do(func() {
+		legacyregistry.MustRegister(rewrites, conflictingObjects)
+	})
+}
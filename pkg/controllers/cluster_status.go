@@ -0,0 +1,96 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mergetypes "k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// RemoveClusterStatus removes the status of the client cluster hosted by the virtual node from the pdbs and
+// hpas of master cluster once the virtual node is gone, the status of the rest client clusters is aggregated
+// again. Resources not served by master cluster are skipped
+func RemoveClusterStatus(ctx context.Context, master kubernetes.Interface, nodeName string) error {
+	var errs []error
+	pdbs, err := master.PolicyV1beta1().PodDisruptionBudgets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		errs = append(errs, err)
+	} else if err == nil {
+		for i := range pdbs.Items {
+			if err = removePDBStatus(master, &pdbs.Items[i], nodeName); err != nil && !apierrs.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	hpas, err := master.AutoscalingV1().HorizontalPodAutoscalers(metav1.NamespaceAll).List(ctx,
+		metav1.ListOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		errs = append(errs, err)
+	} else if err == nil {
+		for i := range hpas.Items {
+			if err = removeHPAStatus(ctx, master, &hpas.Items[i], nodeName); err != nil && !apierrs.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// removeHPAStatus removes the status of the client cluster hosted by the virtual node from the hpa of master
+// cluster, and records the status aggregated from the rest client clusters
+func removeHPAStatus(ctx context.Context, master kubernetes.Interface, hpa *autoscalingv1.HorizontalPodAutoscaler,
+	nodeName string) error {
+	key := util.HPAStatusPrefix + nodeName
+	if _, ok := hpa.Annotations[key]; !ok {
+		return nil
+	}
+	hpaCopy := hpa.DeepCopy()
+	delete(hpaCopy.Annotations, key)
+	annotations := map[string]interface{}{key: nil, util.HPAAggregatedStatus: nil}
+	for k := range hpaCopy.Annotations {
+		if !strings.HasPrefix(k, util.HPAStatusPrefix) {
+			continue
+		}
+		aggregated, err := json.Marshal(aggregateHPAStatus(hpaCopy))
+		if err != nil {
+			return err
+		}
+		annotations[util.HPAAggregatedStatus] = string(aggregated)
+		break
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = master.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Patch(ctx, hpa.Name,
+		mergetypes.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
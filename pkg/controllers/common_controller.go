@@ -37,6 +37,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
@@ -44,6 +45,10 @@ import (
 type CommonController struct {
 	client        kubernetes.Interface
 	eventRecorder record.EventRecorder
+	// conflicts detects other writers in client cluster reverting the configMaps and secrets synced
+	conflicts *conflict.Detector
+	// namespaces translates the namespaces of master cluster into the ones of client cluster
+	namespaces *util.NamespaceMapping
 
 	configMapQueue workqueue.RateLimitingInterface
 	secretQueue    workqueue.RateLimitingInterface
@@ -62,7 +67,8 @@ type CommonController struct {
 // NewCommonController returns a new *CommonController
 func NewCommonController(client kubernetes.Interface,
 	masterInformer, clientInformer informers.SharedInformerFactory,
-	configMapRateLimiter, secretRateLimiter workqueue.RateLimiter) Controller {
+	configMapRateLimiter, secretRateLimiter workqueue.RateLimiter, conflicts *conflict.Detector,
+	namespaces *util.NamespaceMapping) Controller {
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
 	var eventRecorder record.EventRecorder
@@ -75,6 +81,8 @@ func NewCommonController(client kubernetes.Interface,
 	ctrl := &CommonController{
 		client:        client,
 		eventRecorder: eventRecorder,
+		conflicts:     conflicts,
+		namespaces:    namespaces,
 
 		configMapQueue: workqueue.NewNamedRateLimitingQueue(configMapRateLimiter, "vk configMap controller"),
 		secretQueue:    workqueue.NewNamedRateLimitingQueue(secretRateLimiter, "vk secret controller"),
@@ -105,6 +113,12 @@ func NewCommonController(client kubernetes.Interface,
 	ctrl.masterSecretLister = secretInformer.Lister()
 	ctrl.masterSecretListerSynced = secretInformer.Informer().HasSynced
 
+	clientConfigMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: ctrl.clientConfigMapUpdated,
+	})
+	clientSecretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: ctrl.clientSecretUpdated,
+	})
 	return ctrl
 }
 
@@ -177,6 +191,22 @@ func (ctrl *CommonController) configMapDeleted(obj interface{}) {
 	}
 }
 
+// clientConfigMapUpdated enqueues the configMap reverted by another writer in client cluster to restore it
+func (ctrl *CommonController) clientConfigMapUpdated(old, new interface{}) {
+	configMap := new.(*v1.ConfigMap)
+	if !ctrl.conflicts.Observed(conflictKey("configmap", configMap.Namespace, configMap.Name),
+		configMapReference(configMap), configMapState(configMap)) {
+		return
+	}
+	namespace, ok := ctrl.namespaces.ToUpper(configMap.Namespace)
+	if !ok {
+		return
+	}
+	key := namespace + "/" + configMap.Name
+	ctrl.configMapQueue.Add(key)
+	klog.V(4).Infof("ConfigMap %v reverted in client cluster, restore it", key)
+}
+
 // secretAdd reacts to a Secret add
 func (ctrl *CommonController) secretAdd(obj interface{}) {
 	secret := obj.(*v1.Secret)
@@ -227,6 +257,22 @@ func (ctrl *CommonController) secretDeleted(obj interface{}) {
 	}
 }
 
+// clientSecretUpdated enqueues the secret reverted by another writer in client cluster to restore it
+func (ctrl *CommonController) clientSecretUpdated(old, new interface{}) {
+	secret := new.(*v1.Secret)
+	if !ctrl.conflicts.Observed(conflictKey("secret", secret.Namespace, secret.Name), secretReference(secret),
+		secretState(secret)) {
+		return
+	}
+	namespace, ok := ctrl.namespaces.ToUpper(secret.Namespace)
+	if !ok {
+		return
+	}
+	key := namespace + "/" + secret.Name
+	ctrl.secretQueue.Add(key)
+	klog.V(4).Infof("Secret %v reverted in client cluster, restore it", key)
+}
+
 // syncConfigMap deals with one key off the queue.  It returns false when it's time to quit.
 func (ctrl *CommonController) syncConfigMap() {
 	ctx := context.TODO()
@@ -242,6 +288,7 @@ func (ctrl *CommonController) syncConfigMap() {
 		return
 	}
 	klog.V(4).Infof("Started configMap processing %q", configMapName)
+	lowerNamespace := ctrl.namespaces.ToLower(namespace)
 
 	defer func() {
 		if err != nil {
@@ -257,7 +304,7 @@ func (ctrl *CommonController) syncConfigMap() {
 		if !apierrs.IsNotFound(err) {
 			return
 		}
-		_, err = ctrl.clientConfigMapLister.ConfigMaps(namespace).Get(configMapName)
+		_, err = ctrl.clientConfigMapLister.ConfigMaps(lowerNamespace).Get(configMapName)
 		if err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Get configMap from master cluster failed, error: %v", err)
@@ -272,7 +319,7 @@ func (ctrl *CommonController) syncConfigMap() {
 	}
 
 	if deleteConfigMapInClient || configMap.DeletionTimestamp != nil {
-		if err = ctrl.client.CoreV1().ConfigMaps(namespace).Delete(ctx, configMapName,
+		if err = ctrl.client.CoreV1().ConfigMaps(lowerNamespace).Delete(ctx, configMapName,
 			metav1.DeleteOptions{}); err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Delete configMap from client cluster failed, error: %v", err)
@@ -280,13 +327,14 @@ func (ctrl *CommonController) syncConfigMap() {
 			}
 			err = nil
 		}
+		ctrl.conflicts.Forget(conflictKey("configmap", lowerNamespace, configMapName))
 		klog.V(3).Infof("ConfigMap %q deleted", configMapName)
 		return
 	}
 
 	// data updated
 	var configmapInClient *v1.ConfigMap
-	configmapInClient, err = ctrl.clientConfigMapLister.ConfigMaps(namespace).Get(configMapName)
+	configmapInClient, err = ctrl.clientConfigMapLister.ConfigMaps(lowerNamespace).Get(configMapName)
 	if err != nil {
 		if apierrs.IsNotFound(err) {
 			err = nil
@@ -295,16 +343,26 @@ func (ctrl *CommonController) syncConfigMap() {
 		klog.Errorf("Get configMap from client cluster failed, error: %v", err)
 		return
 	}
-	util.UpdateConfigMap(configmapInClient, configMap)
-	if IsObjectGlobal(&configmapInClient.ObjectMeta) {
+	// only the configMaps created by vk are synced, the one in cache must not be changed
+	if !IsObjectGlobal(&configmapInClient.ObjectMeta) {
+		return
+	}
+	configMapCopy := configmapInClient.DeepCopy()
+	util.UpdateConfigMap(configMapCopy, configMap)
+	objKey, desired := conflictKey("configmap", lowerNamespace, configMapName), configMapState(configMap)
+	if !ctrl.conflicts.Allow(objKey, desired) {
+		klog.V(4).Infof("Skip rewriting configMap %v conflicting with another writer", key)
 		return
 	}
-	_, err = ctrl.client.CoreV1().ConfigMaps(configMap.Namespace).Update(ctx,
-		configmapInClient, metav1.UpdateOptions{})
+	ctrl.conflicts.Writing(objKey, configMapReference(configMap), desired, configMapState(configMapCopy))
+	var updated *v1.ConfigMap
+	updated, err = ctrl.client.CoreV1().ConfigMaps(lowerNamespace).Update(ctx,
+		configMapCopy, metav1.UpdateOptions{})
 	if err != nil {
-		klog.Errorf("Get configMap from client cluster failed, error: %v", err)
+		klog.Errorf("Update configMap in client cluster failed, error: %v", err)
 		return
 	}
+	ctrl.conflicts.Written(objKey, configMapState(updated))
 }
 
 // syncSecret deals with one key off the queue.  It returns false when it's time to quit.
@@ -322,6 +380,7 @@ func (ctrl *CommonController) syncSecret() {
 		return
 	}
 	klog.V(4).Infof("Started secret processing %q", secretName)
+	lowerNamespace := ctrl.namespaces.ToLower(namespace)
 
 	defer func() {
 		if err != nil {
@@ -338,7 +397,7 @@ func (ctrl *CommonController) syncSecret() {
 		if !apierrs.IsNotFound(err) {
 			return
 		}
-		_, err = ctrl.clientSecretLister.Secrets(namespace).Get(secretName)
+		_, err = ctrl.clientSecretLister.Secrets(lowerNamespace).Get(secretName)
 		if err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Get secret from master cluster failed, error: %v", err)
@@ -353,7 +412,7 @@ func (ctrl *CommonController) syncSecret() {
 	}
 
 	if deleteSecretInClient || secret.DeletionTimestamp != nil {
-		if err = ctrl.client.CoreV1().Secrets(namespace).Delete(ctx, secretName,
+		if err = ctrl.client.CoreV1().Secrets(lowerNamespace).Delete(ctx, secretName,
 			metav1.DeleteOptions{}); err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Delete secret from client cluster failed, error: %v", err)
@@ -361,13 +420,14 @@ func (ctrl *CommonController) syncSecret() {
 			}
 			err = nil
 		}
+		ctrl.conflicts.Forget(conflictKey("secret", lowerNamespace, secretName))
 		klog.V(3).Infof("Secret %q deleted", secretName)
 		return
 	}
 
 	// data updated
 	var old *v1.Secret
-	old, err = ctrl.clientSecretLister.Secrets(namespace).Get(secretName)
+	old, err = ctrl.clientSecretLister.Secrets(lowerNamespace).Get(secretName)
 	if err != nil {
 		if apierrs.IsNotFound(err) {
 			err = nil
@@ -376,15 +436,25 @@ func (ctrl *CommonController) syncSecret() {
 		klog.Errorf("Get secret from client cluster failed, error: %v", err)
 		return
 	}
-	util.UpdateSecret(old, secret)
-	if IsObjectGlobal(&old.ObjectMeta) {
+	// only the secrets created by vk are synced, the one in cache must not be changed
+	if !IsObjectGlobal(&old.ObjectMeta) {
+		return
+	}
+	secretCopy := old.DeepCopy()
+	util.UpdateSecret(secretCopy, secret)
+	objKey, desired := conflictKey("secret", lowerNamespace, secretName), secretState(secret)
+	if !ctrl.conflicts.Allow(objKey, desired) {
+		klog.V(4).Infof("Skip rewriting secret %v conflicting with another writer", key)
 		return
 	}
-	_, err = ctrl.client.CoreV1().Secrets(secret.Namespace).Update(ctx, old, metav1.UpdateOptions{})
+	ctrl.conflicts.Writing(objKey, secretReference(secret), desired, secretState(secretCopy))
+	var updated *v1.Secret
+	updated, err = ctrl.client.CoreV1().Secrets(lowerNamespace).Update(ctx, secretCopy, metav1.UpdateOptions{})
 	if err != nil {
-		klog.Errorf("Get secret from client cluster failed, error: %v", err)
+		klog.Errorf("Update secret in client cluster failed, error: %v", err)
 		return
 	}
+	ctrl.conflicts.Written(objKey, secretState(updated))
 }
 
 func (ctrl *CommonController) shouldEnqueue(obj *metav1.ObjectMeta) bool {
@@ -455,7 +525,11 @@ func (ctrl *CommonController) gcConfigMap(ctx context.Context) {
 		if !IsObjectGlobal(&configMap.ObjectMeta) {
 			continue
 		}
-		_, err = ctrl.masterConfigMapLister.ConfigMaps(configMap.Namespace).Get(configMap.Name)
+		namespace, ok := ctrl.namespaces.ToUpper(configMap.Namespace)
+		if !ok {
+			continue
+		}
+		_, err = ctrl.masterConfigMapLister.ConfigMaps(namespace).Get(configMap.Name)
 		if err != nil && apierrs.IsNotFound(err) {
 			err := ctrl.client.CoreV1().ConfigMaps(configMap.Namespace).Delete(ctx,
 				configMap.Name, metav1.DeleteOptions{})
@@ -480,7 +554,11 @@ func (ctrl *CommonController) gcSecret(ctx context.Context) {
 		if !IsObjectGlobal(&secret.ObjectMeta) {
 			continue
 		}
-		_, err = ctrl.masterSecretLister.Secrets(secret.Namespace).Get(secret.Name)
+		namespace, ok := ctrl.namespaces.ToUpper(secret.Namespace)
+		if !ok {
+			continue
+		}
+		_, err = ctrl.masterSecretLister.Secrets(namespace).Get(secret.Name)
 		if err != nil && apierrs.IsNotFound(err) {
 			err := ctrl.client.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
 			if err != nil && !apierrs.IsNotFound(err) {
@@ -30,6 +30,8 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
 )
 
 type commonTestBase struct {
@@ -148,6 +150,49 @@ func TestCommonController_RunUpdateSecret(t *testing.T) {
 	}
 }
 
+func TestCommonController_RestoreRevertedConfigMap(t *testing.T) {
+	ctx := context.TODO()
+	b := newCommonController()
+	stopCh := make(chan struct{})
+	go test(b.c, 1, stopCh)
+	b.clientInformer.Start(stopCh)
+	b.masterInformer.Start(stopCh)
+
+	configMap := newConfigMap()
+	configMap.Data = map[string]string{"test": "test1"}
+	if _, err := b.master.CoreV1().ConfigMaps(configMap.Namespace).Update(ctx, configMap,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitData := func(value string) error {
+		return wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			cm, err := b.c.clientConfigMapLister.ConfigMaps(configMap.Namespace).Get(configMap.Name)
+			if err != nil {
+				return false, nil
+			}
+			return cm.Data["test"] == value, nil
+		})
+	}
+	if err := waitData("test1"); err != nil {
+		t.Fatal("configMap not synced to client cluster")
+	}
+
+	// another writer in client cluster reverts the data
+	if _, err := b.client.CoreV1().ConfigMaps(configMap.Namespace).Update(ctx, newConfigMap(),
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		keys := b.c.conflicts.Conflicting()
+		return len(keys) == 1 && keys[0] == "conflict/configmap/default/test", nil
+	}); err != nil {
+		t.Fatalf("Desire configMap conflicting, get %v", b.c.conflicts.Conflicting())
+	}
+	if err := waitData("test1"); err != nil {
+		t.Fatal("reverted configMap not restored")
+	}
+}
+
 func TestCommonController_RunDeleteConfigMap(t *testing.T) {
 	ctx := context.TODO()
 	cm := newConfigMap()
@@ -260,7 +305,8 @@ func newCommonController() *commonTestBase {
 
 	configMapRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
 	secretRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
-	controller := NewCommonController(client, masterInformer, clientInformer, configMapRateLimiter, secretRateLimiter)
+	controller := NewCommonController(client, masterInformer, clientInformer, configMapRateLimiter, secretRateLimiter,
+		conflict.NewDetector("vk", 1, time.Minute, conflict.Force, nil), nil)
 	c := controller.(*CommonController)
 	return &commonTestBase{
 		c:              c,
@@ -0,0 +1,296 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	configMapDependency = "configmap"
+	secretDependency    = "secret"
+)
+
+// DependencyController tracks the references of pods to the configMaps and secrets synced into client cluster,
+// and deletes the ones no longer referenced by any pod, neither the pods in client cluster nor the pods of
+// master cluster bound to the virtual node, which are going to be created in client cluster.
+// The updates of the objects in master cluster are synced by CommonController
+type DependencyController struct {
+	client    kubernetes.Interface
+	nodeName  string
+	conflicts *conflict.Detector
+	queue     workqueue.RateLimitingInterface
+	// namespaces translates the namespaces of master cluster into the ones of client cluster
+	namespaces *util.NamespaceMapping
+
+	podLister                   corelisters.PodLister
+	podListerSynced             cache.InformerSynced
+	clientPodLister             corelisters.PodLister
+	clientPodListerSynced       cache.InformerSynced
+	clientConfigMapLister       corelisters.ConfigMapLister
+	clientConfigMapListerSynced cache.InformerSynced
+	clientSecretLister          corelisters.SecretLister
+	clientSecretListerSynced    cache.InformerSynced
+}
+
+// NewDependencyController returns a new *DependencyController
+func NewDependencyController(client kubernetes.Interface,
+	masterInformer, clientInformer informers.SharedInformerFactory, conflicts *conflict.Detector,
+	nodeName string, namespaces *util.NamespaceMapping) Controller {
+	podInformer := masterInformer.Core().V1().Pods()
+	clientPodInformer := clientInformer.Core().V1().Pods()
+	clientConfigMapInformer := clientInformer.Core().V1().ConfigMaps()
+	clientSecretInformer := clientInformer.Core().V1().Secrets()
+	dependencyRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	ctrl := &DependencyController{
+		client:    client,
+		nodeName:  nodeName,
+		conflicts: conflicts,
+		queue:     workqueue.NewNamedRateLimitingQueue(dependencyRateLimiter, "vk dependency controller"),
+
+		namespaces: namespaces,
+
+		podLister:                   podInformer.Lister(),
+		podListerSynced:             podInformer.Informer().HasSynced,
+		clientPodLister:             clientPodInformer.Lister(),
+		clientPodListerSynced:       clientPodInformer.Informer().HasSynced,
+		clientConfigMapLister:       clientConfigMapInformer.Lister(),
+		clientConfigMapListerSynced: clientConfigMapInformer.Informer().HasSynced,
+		clientSecretLister:          clientSecretInformer.Lister(),
+		clientSecretListerSynced:    clientSecretInformer.Informer().HasSynced,
+	}
+	handler := func(deleted func(obj interface{})) cache.ResourceEventHandler {
+		return cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				oldPod, newPod := old.(*v1.Pod), new.(*v1.Pod)
+				if reflect.DeepEqual(oldPod.Spec, newPod.Spec) {
+					return
+				}
+				deleted(old)
+			},
+			DeleteFunc: deleted,
+		}
+	}
+	podInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			pod := podFromObject(obj)
+			return pod != nil && pod.Spec.NodeName == nodeName
+		},
+		Handler: handler(ctrl.upperPodDeleted),
+	})
+	clientPodInformer.Informer().AddEventHandler(handler(ctrl.podDeleted))
+	return ctrl
+}
+
+// Run starts and listens on channel events
+func (ctrl *DependencyController) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.podListerSynced, ctrl.clientPodListerSynced,
+		ctrl.clientConfigMapListerSynced, ctrl.clientSecretListerSynced) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	ctrl.enqueueSynced()
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.syncDependency, 0, stopCh)
+	}
+	<-stopCh
+}
+
+// podDeleted enqueues the configMaps and secrets referenced by the pod of client cluster deleted or changed
+func (ctrl *DependencyController) podDeleted(obj interface{}) {
+	if pod := podFromObject(obj); pod != nil {
+		ctrl.enqueueReferences(pod, pod.Namespace)
+	}
+}
+
+// upperPodDeleted enqueues the configMaps and secrets referenced by the pod of master cluster deleted or
+// changed, which are synced into the translated namespace of client cluster
+func (ctrl *DependencyController) upperPodDeleted(obj interface{}) {
+	if pod := podFromObject(obj); pod != nil {
+		ctrl.enqueueReferences(pod, ctrl.namespaces.ToLower(pod.Namespace))
+	}
+}
+
+// enqueueReferences enqueues the configMaps and secrets referenced by the pod in namespace of client cluster
+func (ctrl *DependencyController) enqueueReferences(pod *v1.Pod, namespace string) {
+	refs := util.GetPodReferences(pod)
+	for name := range refs.ConfigMaps {
+		ctrl.queue.Add(dependencyKey(configMapDependency, namespace, name))
+	}
+	for name := range refs.Secrets {
+		ctrl.queue.Add(dependencyKey(secretDependency, namespace, name))
+	}
+}
+
+// enqueueSynced enqueues all of the configMaps and secrets synced into client cluster, so that the ones
+// orphaned while vk is down are deleted
+func (ctrl *DependencyController) enqueueSynced() {
+	configMaps, err := ctrl.clientConfigMapLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	for _, configMap := range configMaps {
+		if IsObjectGlobal(&configMap.ObjectMeta) {
+			ctrl.queue.Add(dependencyKey(configMapDependency, configMap.Namespace, configMap.Name))
+		}
+	}
+	secrets, err := ctrl.clientSecretLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	for _, secret := range secrets {
+		if IsObjectGlobal(&secret.ObjectMeta) {
+			ctrl.queue.Add(dependencyKey(secretDependency, secret.Namespace, secret.Name))
+		}
+	}
+}
+
+// syncDependency deals with one key off the queue.
+func (ctrl *DependencyController) syncDependency() {
+	keyObj, quit := ctrl.queue.Get()
+	if quit {
+		return
+	}
+	defer ctrl.queue.Done(keyObj)
+	key := keyObj.(string)
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		ctrl.queue.Forget(key)
+		return
+	}
+	kind, namespace, name := parts[0], parts[1], parts[2]
+	klog.V(4).Infof("Started dependency processing %q", key)
+
+	var err error
+	defer func() {
+		if err != nil {
+			klog.Error(err)
+			ctrl.queue.AddRateLimited(key)
+			return
+		}
+		ctrl.queue.Forget(key)
+	}()
+
+	var meta *metav1.ObjectMeta
+	switch kind {
+	case configMapDependency:
+		var configMap *v1.ConfigMap
+		if configMap, err = ctrl.clientConfigMapLister.ConfigMaps(namespace).Get(name); err == nil {
+			meta = &configMap.ObjectMeta
+		}
+	case secretDependency:
+		var secret *v1.Secret
+		if secret, err = ctrl.clientSecretLister.Secrets(namespace).Get(name); err == nil {
+			meta = &secret.ObjectMeta
+		}
+	}
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	if meta == nil || !IsObjectGlobal(meta) {
+		return
+	}
+	var referenced bool
+	if referenced, err = ctrl.referenced(kind, namespace, name); err != nil || referenced {
+		return
+	}
+	err = ctrl.deleteOrphan(kind, meta)
+}
+
+// referenced returns if the configMap or secret is referenced by any pod in client cluster or bound to the
+// virtual node in master cluster
+func (ctrl *DependencyController) referenced(kind, namespace, name string) (bool, error) {
+	pods, err := ctrl.clientPodLister.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+	if upperNamespace, ok := ctrl.namespaces.ToUpper(namespace); ok {
+		upperPods, err := ctrl.podLister.Pods(upperNamespace).List(labels.Everything())
+		if err != nil {
+			return false, err
+		}
+		for _, pod := range upperPods {
+			if pod.Spec.NodeName == ctrl.nodeName {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	for _, pod := range pods {
+		refs := util.GetPodReferences(pod)
+		if _, ok := refs.ConfigMaps[name]; ok && kind == configMapDependency {
+			return true, nil
+		}
+		if _, ok := refs.Secrets[name]; ok && kind == secretDependency {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteOrphan deletes the configMap or secret no longer referenced from client cluster, the version checked
+// is required so that the one updated in the meantime is kept for the next check
+func (ctrl *DependencyController) deleteOrphan(kind string, meta *metav1.ObjectMeta) error {
+	ctx := context.TODO()
+	opts := metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &meta.UID, ResourceVersion: &meta.ResourceVersion},
+	}
+	var err error
+	switch kind {
+	case configMapDependency:
+		err = ctrl.client.CoreV1().ConfigMaps(meta.Namespace).Delete(ctx, meta.Name, opts)
+	case secretDependency:
+		err = ctrl.client.CoreV1().Secrets(meta.Namespace).Delete(ctx, meta.Name, opts)
+	}
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	ctrl.conflicts.Forget(conflictKey(kind, meta.Namespace, meta.Name))
+	klog.V(3).Infof("Orphan %v %v/%v deleted from client cluster", kind, meta.Namespace, meta.Name)
+	return nil
+}
+
+// dependencyKey returns the key of the configMap or secret in the queue
+func dependencyKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
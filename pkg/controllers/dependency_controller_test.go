@@ -0,0 +1,118 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+)
+
+func TestDependencyController_Run(t *testing.T) {
+	ctx := context.TODO()
+	cases := []struct {
+		name         string
+		upperPod     *v1.Pod
+		lowerPod     *v1.Pod
+		shouldDelete bool
+	}{
+		{
+			name:         "should delete orphan",
+			shouldDelete: true,
+		},
+		{
+			name:         "should keep configmap referenced by pod in client cluster",
+			lowerPod:     newPodReferencing(""),
+			shouldDelete: false,
+		},
+		{
+			name:         "should keep configmap referenced by pod bound to the node",
+			upperPod:     newPodReferencing("vk-1"),
+			shouldDelete: false,
+		},
+		{
+			name:         "should delete configmap referenced by pod bound to another node",
+			upperPod:     newPodReferencing("vk-2"),
+			shouldDelete: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			master := fake.NewSimpleClientset()
+			client := fake.NewSimpleClientset(newConfigMap())
+			if c.upperPod != nil {
+				master = fake.NewSimpleClientset(c.upperPod)
+			}
+			if c.lowerPod != nil {
+				client = fake.NewSimpleClientset(newConfigMap(), c.lowerPod)
+			}
+			masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+			clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+			ctrl := NewDependencyController(client, masterInformer, clientInformer, nil, "vk-1", nil)
+
+			stopCh := make(chan struct{})
+			masterInformer.Start(stopCh)
+			clientInformer.Start(stopCh)
+			go test(ctrl, 1, stopCh)
+
+			err := wait.Poll(50*time.Millisecond, 3*time.Second, func() (bool, error) {
+				_, err := client.CoreV1().ConfigMaps("default").Get(ctx, "test", metav1.GetOptions{})
+				return errors.IsNotFound(err), nil
+			})
+			if (err == nil) != c.shouldDelete {
+				t.Fatalf("Desired configmap deleted %v", c.shouldDelete)
+			}
+			if c.lowerPod == nil {
+				return
+			}
+			// the configmap is deleted once the pod referencing it deleted
+			if err = client.CoreV1().Pods("default").Delete(ctx, c.lowerPod.Name,
+				metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			err = wait.Poll(50*time.Millisecond, 3*time.Second, func() (bool, error) {
+				_, err := client.CoreV1().ConfigMaps("default").Get(ctx, "test", metav1.GetOptions{})
+				return errors.IsNotFound(err), nil
+			})
+			if err != nil {
+				t.Fatal("Desired configmap deleted after the pod deleted")
+			}
+		})
+	}
+}
+
+func newPodReferencing(nodeName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Name: "test",
+				EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: "test"}}}},
+			}},
+		},
+	}
+}
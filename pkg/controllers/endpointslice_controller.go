@@ -0,0 +1,377 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// endpointSliceControllerName is the managed-by label value of EndpointSlices created by kube-controller-manager
+const endpointSliceControllerName = "endpointslice-controller.k8s.io"
+
+// EndpointSliceController is a controller mirrors the EndpointSlices of services in master cluster into the
+// services synced to client cluster, and imports the EndpointSlices of services in client cluster back into
+// master cluster for the services annotated with tensile-kube.io/import-endpoints, so that the pods in client
+// cluster not known by master cluster are reachable by the services of master cluster
+type EndpointSliceController struct {
+	master   kubernetes.Interface
+	client   kubernetes.Interface
+	nodeName string
+	queue    workqueue.RateLimitingInterface
+
+	serviceLister             corelisters.ServiceLister
+	serviceListerSynced       cache.InformerSynced
+	clientServiceLister       corelisters.ServiceLister
+	clientServiceListerSynced cache.InformerSynced
+	sliceLister               discoverylisters.EndpointSliceLister
+	sliceListerSynced         cache.InformerSynced
+	clientSliceLister         discoverylisters.EndpointSliceLister
+	clientSliceListerSynced   cache.InformerSynced
+	nsLister                  corelisters.NamespaceLister
+}
+
+// NewEndpointSliceController returns a new *EndpointSliceController
+func NewEndpointSliceController(master kubernetes.Interface, client kubernetes.Interface,
+	masterInformer, clientInformer informers.SharedInformerFactory, nsLister corelisters.NamespaceLister,
+	nodeName string) Controller {
+	serviceInformer := masterInformer.Core().V1().Services()
+	clientServiceInformer := clientInformer.Core().V1().Services()
+	sliceInformer := masterInformer.Discovery().V1beta1().EndpointSlices()
+	clientSliceInformer := clientInformer.Discovery().V1beta1().EndpointSlices()
+	sliceRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	ctrl := &EndpointSliceController{
+		master:   master,
+		client:   client,
+		nodeName: nodeName,
+		queue:    workqueue.NewNamedRateLimitingQueue(sliceRateLimiter, "vk endpointslice controller"),
+
+		serviceLister:             serviceInformer.Lister(),
+		serviceListerSynced:       serviceInformer.Informer().HasSynced,
+		clientServiceLister:       clientServiceInformer.Lister(),
+		clientServiceListerSynced: clientServiceInformer.Informer().HasSynced,
+		sliceLister:               sliceInformer.Lister(),
+		sliceListerSynced:         sliceInformer.Informer().HasSynced,
+		clientSliceLister:         clientSliceInformer.Lister(),
+		clientSliceListerSynced:   clientSliceInformer.Informer().HasSynced,
+		nsLister:                  nsLister,
+	}
+	serviceHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: ctrl.serviceAdded,
+		UpdateFunc: func(old, new interface{}) {
+			ctrl.serviceAdded(new)
+		},
+		DeleteFunc: ctrl.serviceAdded,
+	}
+	serviceInformer.Informer().AddEventHandler(serviceHandler)
+	clientServiceInformer.Informer().AddEventHandler(serviceHandler)
+	sliceHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: ctrl.sliceAdded,
+		UpdateFunc: func(old, new interface{}) {
+			ctrl.sliceAdded(new)
+		},
+		DeleteFunc: ctrl.sliceAdded,
+	}
+	sliceInformer.Informer().AddEventHandler(sliceHandler)
+	clientSliceInformer.Informer().AddEventHandler(sliceHandler)
+	return ctrl
+}
+
+// Run starts and listens on channel events
+func (ctrl *EndpointSliceController) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.serviceListerSynced, ctrl.clientServiceListerSynced,
+		ctrl.sliceListerSynced, ctrl.clientSliceListerSynced) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.syncEndpointSlices, 0, stopCh)
+	}
+	<-stopCh
+}
+
+// serviceAdded reacts to a service add, update or delete in master or client cluster
+func (ctrl *EndpointSliceController) serviceAdded(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	ctrl.queue.Add(key)
+}
+
+// sliceAdded enqueues the service of the EndpointSlice added, updated or deleted in master or client cluster
+func (ctrl *EndpointSliceController) sliceAdded(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1beta1.EndpointSlice)
+	if !ok {
+		return
+	}
+	service := slice.Labels[discoveryv1beta1.LabelServiceName]
+	if len(service) == 0 {
+		return
+	}
+	ctrl.queue.Add(slice.Namespace + "/" + service)
+}
+
+// syncEndpointSlices deals with one key of service off the queue.
+func (ctrl *EndpointSliceController) syncEndpointSlices() {
+	keyObj, quit := ctrl.queue.Get()
+	if quit {
+		return
+	}
+	defer ctrl.queue.Done(keyObj)
+	key := keyObj.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ctrl.queue.Forget(key)
+		return
+	}
+	if namespace == metav1.NamespaceSystem || name == "kubernetes" {
+		ctrl.queue.Forget(key)
+		return
+	}
+	klog.V(4).Infof("Started endpointslices processing %q", key)
+
+	defer func() {
+		if err != nil {
+			klog.Error(err)
+			ctrl.queue.AddRateLimited(key)
+			return
+		}
+		ctrl.queue.Forget(key)
+	}()
+
+	var service *v1.Service
+	service, err = ctrl.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		service, err = nil, nil
+	}
+	if service != nil && service.DeletionTimestamp != nil {
+		service = nil
+	}
+	if err = ctrl.exportSlices(namespace, name, service); err != nil {
+		return
+	}
+	err = ctrl.importSlices(namespace, name, service)
+}
+
+// exportSlices mirrors the EndpointSlices of the service in master cluster into client cluster, once the
+// service is synced there by ServiceControllers, the ones mirrored before and no longer desired are deleted
+func (ctrl *EndpointSliceController) exportSlices(namespace, name string, service *v1.Service) error {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1beta1.LabelServiceName: name})
+	desired := map[string]*discoveryv1beta1.EndpointSlice{}
+	if service != nil && ctrl.clientServiceSynced(namespace, name) {
+		slices, err := ctrl.sliceLister.EndpointSlices(namespace).List(selector)
+		if err != nil {
+			return err
+		}
+		for _, slice := range slices {
+			// the imported ones are not mirrored back
+			if slice.Labels[discoveryv1beta1.LabelManagedBy] == util.EndpointSliceManager {
+				continue
+			}
+			desired[slice.Name] = exportedSlice(slice)
+		}
+	}
+	if len(desired) > 0 {
+		if err := ensureNamespace(namespace, ctrl.client, ctrl.nsLister); err != nil {
+			return err
+		}
+	}
+	return syncSlices(ctrl.client, ctrl.clientSliceLister, namespace, selector, desired,
+		func(slice *discoveryv1beta1.EndpointSlice) bool {
+			return slice.Labels[discoveryv1beta1.LabelManagedBy] == util.EndpointSliceManager
+		})
+}
+
+// importSlices imports the EndpointSlices of the service in client cluster managed by kube-controller-manager
+// into master cluster if the service of master cluster is annotated with tensile-kube.io/import-endpoints,
+// the ones imported before and no longer desired are deleted
+func (ctrl *EndpointSliceController) importSlices(namespace, name string, service *v1.Service) error {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1beta1.LabelServiceName: name})
+	desired := map[string]*discoveryv1beta1.EndpointSlice{}
+	if service != nil && service.Annotations[util.ImportEndpoints] == "true" {
+		slices, err := ctrl.clientSliceLister.EndpointSlices(namespace).List(selector)
+		if err != nil {
+			return err
+		}
+		for _, slice := range slices {
+			// only the endpoints of the pods selected in client cluster are imported, the mirrored ones
+			// are known by master cluster already
+			if slice.Labels[discoveryv1beta1.LabelManagedBy] != endpointSliceControllerName {
+				continue
+			}
+			imported := importedSlice(slice, ctrl.nodeName)
+			desired[imported.Name] = imported
+		}
+	}
+	return syncSlices(ctrl.master, ctrl.sliceLister, namespace, selector, desired,
+		func(slice *discoveryv1beta1.EndpointSlice) bool {
+			return slice.Labels[discoveryv1beta1.LabelManagedBy] == util.EndpointSliceManager &&
+				slice.Annotations[util.ImportedFrom] == ctrl.nodeName
+		})
+}
+
+// clientServiceSynced returns if the service exists in client cluster and is synced by vk, the services
+// owned by client cluster are not touched
+func (ctrl *EndpointSliceController) clientServiceSynced(namespace, name string) bool {
+	service, err := ctrl.clientServiceLister.Services(namespace).Get(name)
+	if err != nil {
+		return false
+	}
+	return IsObjectGlobal(&service.ObjectMeta)
+}
+
+// syncSlices creates or updates the desired EndpointSlices with client, and deletes the ones selected and
+// owned but not desired
+func syncSlices(client kubernetes.Interface, lister discoverylisters.EndpointSliceLister, namespace string,
+	selector labels.Selector, desired map[string]*discoveryv1beta1.EndpointSlice,
+	owned func(*discoveryv1beta1.EndpointSlice) bool) error {
+	ctx := context.TODO()
+	current, err := lister.EndpointSlices(namespace).List(selector)
+	if err != nil {
+		return err
+	}
+	for _, slice := range current {
+		if _, ok := desired[slice.Name]; ok || !owned(slice) {
+			continue
+		}
+		err = client.DiscoveryV1beta1().EndpointSlices(namespace).Delete(ctx, slice.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+		klog.V(3).Infof("EndpointSlice %v/%v deleted", namespace, slice.Name)
+	}
+	for name, slice := range desired {
+		old, err := lister.EndpointSlices(namespace).Get(name)
+		if err != nil {
+			if !apierrs.IsNotFound(err) {
+				return err
+			}
+			if _, err = client.DiscoveryV1beta1().EndpointSlices(namespace).Create(ctx, slice,
+				metav1.CreateOptions{}); err != nil && !apierrs.IsAlreadyExists(err) {
+				return fmt.Errorf("create endpointslice %v/%v failed, error: %v", namespace, name, err)
+			}
+			klog.Infof("Create endpointslice %v/%v success", namespace, name)
+			continue
+		}
+		if !owned(old) {
+			klog.V(4).Infof("EndpointSlice %v/%v not created by vk, ignore", namespace, name)
+			continue
+		}
+		if old.AddressType != slice.AddressType {
+			// address type of EndpointSlice is immutable, recreate it
+			err = client.DiscoveryV1beta1().EndpointSlices(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			if err != nil && !apierrs.IsNotFound(err) {
+				return err
+			}
+			return fmt.Errorf("endpointslice %v/%v outdated, recreate it", namespace, name)
+		}
+		if reflect.DeepEqual(old.Labels, slice.Labels) && reflect.DeepEqual(old.Endpoints, slice.Endpoints) &&
+			reflect.DeepEqual(old.Ports, slice.Ports) {
+			continue
+		}
+		update := old.DeepCopy()
+		update.Labels = slice.Labels
+		update.Endpoints = slice.Endpoints
+		update.Ports = slice.Ports
+		if _, err = client.DiscoveryV1beta1().EndpointSlices(namespace).Update(ctx, update,
+			metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		klog.V(4).Infof("Update endpointslice %v/%v success", namespace, name)
+	}
+	return nil
+}
+
+// exportedSlice returns the EndpointSlice mirrored into client cluster, it is managed by vk so that
+// it is kept by the endpointslice controller of client cluster
+func exportedSlice(slice *discoveryv1beta1.EndpointSlice) *discoveryv1beta1.EndpointSlice {
+	mirror := &discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      slice.Name,
+			Namespace: slice.Namespace,
+			Labels:    managedSliceLabels(slice.Labels),
+		},
+		AddressType: slice.AddressType,
+		Endpoints:   slice.DeepCopy().Endpoints,
+		Ports:       slice.DeepCopy().Ports,
+	}
+	SetObjectGlobal(&mirror.ObjectMeta)
+	return mirror
+}
+
+// importedSlice returns the EndpointSlice of client cluster imported into master cluster, the endpoints
+// are regarded as running on the virtual node since the pods and nodes referred are unknown to master cluster
+func importedSlice(slice *discoveryv1beta1.EndpointSlice, nodeName string) *discoveryv1beta1.EndpointSlice {
+	imported := &discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        nodeName + "-" + slice.Name,
+			Namespace:   slice.Namespace,
+			Labels:      managedSliceLabels(slice.Labels),
+			Annotations: map[string]string{util.ImportedFrom: nodeName},
+		},
+		AddressType: slice.AddressType,
+		Ports:       slice.DeepCopy().Ports,
+	}
+	for _, endpoint := range slice.Endpoints {
+		endpoint = *endpoint.DeepCopy()
+		endpoint.TargetRef = nil
+		endpoint.Topology = map[string]string{util.HostNameKey: nodeName}
+		imported.Endpoints = append(imported.Endpoints, endpoint)
+	}
+	return imported
+}
+
+// managedSliceLabels returns the labels of EndpointSlice synced between clusters
+func managedSliceLabels(origin map[string]string) map[string]string {
+	synced := make(map[string]string, len(origin))
+	for k, v := range origin {
+		synced[k] = v
+	}
+	synced[discoveryv1beta1.LabelManagedBy] = util.EndpointSliceManager
+	return synced
+}
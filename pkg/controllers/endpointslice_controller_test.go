@@ -0,0 +1,171 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestImportedSlice(t *testing.T) {
+	slice := newEndpointSlice("test-abc", "10.0.0.1")
+	slice.Endpoints[0].TargetRef = &v1.ObjectReference{Kind: "Pod", Name: "test"}
+	imported := importedSlice(slice, "vk-1")
+	if imported.Name != "vk-1-test-abc" {
+		t.Fatalf("Desired vk-1-test-abc, get %v", imported.Name)
+	}
+	if imported.Labels[discoveryv1beta1.LabelManagedBy] != util.EndpointSliceManager ||
+		imported.Labels[discoveryv1beta1.LabelServiceName] != "test" {
+		t.Fatalf("Unexpected labels %v", imported.Labels)
+	}
+	if imported.Annotations[util.ImportedFrom] != "vk-1" {
+		t.Fatalf("Desired imported from vk-1, get %v", imported.Annotations)
+	}
+	endpoint := imported.Endpoints[0]
+	if endpoint.TargetRef != nil || endpoint.Topology[util.HostNameKey] != "vk-1" {
+		t.Fatalf("Unexpected endpoint %+v", endpoint)
+	}
+	if slice.Endpoints[0].TargetRef == nil ||
+		slice.Labels[discoveryv1beta1.LabelManagedBy] != endpointSliceControllerName {
+		t.Fatal("Origin slice changed")
+	}
+}
+
+func TestEndpointSliceController_Run(t *testing.T) {
+	ctx := context.TODO()
+	cases := []struct {
+		name          string
+		clientService *v1.Service
+		importing     bool
+		shouldExport  bool
+		shouldImport  bool
+	}{
+		{
+			name:          "should export to service synced and import if annotated",
+			clientService: newService(),
+			importing:     true,
+			shouldExport:  true,
+			shouldImport:  true,
+		},
+		{
+			name:          "should not export to service owned by client cluster",
+			clientService: newClientOwnedService(),
+			importing:     true,
+			shouldExport:  false,
+			shouldImport:  true,
+		},
+		{
+			name:          "should not import if not annotated",
+			clientService: newService(),
+			shouldExport:  true,
+			shouldImport:  false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service := newService()
+			if c.importing {
+				service.Annotations[util.ImportEndpoints] = "true"
+			}
+			master := fake.NewSimpleClientset(service, newEndpointSlice("test-upper", "10.0.0.1"))
+			client := fake.NewSimpleClientset(c.clientService, newEndpointSlice("test-lower", "10.1.0.1"))
+			masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+			clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+			nsLister := clientInformer.Core().V1().Namespaces().Lister()
+			ctrl := NewEndpointSliceController(master, client, masterInformer, clientInformer, nsLister, "vk-1")
+
+			stopCh := make(chan struct{})
+			masterInformer.Start(stopCh)
+			clientInformer.Start(stopCh)
+			go test(ctrl, 1, stopCh)
+
+			checkSlice(t, func() (*discoveryv1beta1.EndpointSlice, error) {
+				return client.DiscoveryV1beta1().EndpointSlices("default").Get(ctx, "test-upper", metav1.GetOptions{})
+			}, c.shouldExport, "10.0.0.1")
+			checkSlice(t, func() (*discoveryv1beta1.EndpointSlice, error) {
+				return master.DiscoveryV1beta1().EndpointSlices("default").Get(ctx, "vk-1-test-lower",
+					metav1.GetOptions{})
+			}, c.shouldImport, "10.1.0.1")
+			if !c.shouldImport {
+				return
+			}
+
+			// the imported slice is removed once the annotation is removed
+			delete(service.Annotations, util.ImportEndpoints)
+			if _, err := master.CoreV1().Services("default").Update(ctx, service, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkSlice(t, func() (*discoveryv1beta1.EndpointSlice, error) {
+				return master.DiscoveryV1beta1().EndpointSlices("default").Get(ctx, "vk-1-test-lower",
+					metav1.GetOptions{})
+			}, false, "")
+		})
+	}
+}
+
+func checkSlice(t *testing.T, get func() (*discoveryv1beta1.EndpointSlice, error), exists bool,
+	address string) {
+	err := wait.Poll(50*time.Millisecond, 3*time.Second, func() (bool, error) {
+		slice, err := get()
+		if err != nil {
+			return errors.IsNotFound(err) && !exists, nil
+		}
+		if !exists {
+			return false, nil
+		}
+		return slice.Labels[discoveryv1beta1.LabelManagedBy] == util.EndpointSliceManager &&
+			len(slice.Endpoints) == 1 && slice.Endpoints[0].Addresses[0] == address, nil
+	})
+	if err != nil {
+		t.Errorf("Desired endpointslice existing %v", exists)
+	}
+}
+
+func newClientOwnedService() *v1.Service {
+	service := newService()
+	service.Annotations = nil
+	return service
+}
+
+func newEndpointSlice(name, address string) *discoveryv1beta1.EndpointSlice {
+	port := int32(80)
+	return &discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels: map[string]string{
+				discoveryv1beta1.LabelServiceName: "test",
+				discoveryv1beta1.LabelManagedBy:   endpointSliceControllerName,
+			},
+		},
+		AddressType: discoveryv1beta1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1beta1.Endpoint{{Addresses: []string{address}}},
+		Ports:       []discoveryv1beta1.EndpointPort{{Port: &port}},
+	}
+}
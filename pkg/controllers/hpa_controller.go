@@ -0,0 +1,344 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mergetypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// HPAController is a controller mirrors the delegated HPAs of master cluster into client cluster,
+// and aggregates the status of HPAs in all client clusters into the annotations of master HPA.
+// The status of master HPA is owned by the HPA controller of master cluster, so it is never written,
+// and the master HPA should be kept from scaling, e.g. minReplicas equals to maxReplicas
+type HPAController struct {
+	master   kubernetes.Interface
+	client   kubernetes.Interface
+	nodeName string
+	queue    workqueue.RateLimitingInterface
+
+	hpaLister             autoscalinglisters.HorizontalPodAutoscalerLister
+	hpaListerSynced       cache.InformerSynced
+	clientHPALister       autoscalinglisters.HorizontalPodAutoscalerLister
+	clientHPAListerSynced cache.InformerSynced
+	nsLister              corelisters.NamespaceLister
+}
+
+// clusterHPAStatus is the status of the HPA in a client cluster recorded on the HPA of master cluster
+type clusterHPAStatus struct {
+	CurrentReplicas                 int32        `json:"currentReplicas"`
+	DesiredReplicas                 int32        `json:"desiredReplicas"`
+	CurrentCPUUtilizationPercentage *int32       `json:"currentCPUUtilizationPercentage,omitempty"`
+	LastScaleTime                   *metav1.Time `json:"lastScaleTime,omitempty"`
+}
+
+// hpaScaleTargetRetryPeriod is the period to check again if the scale target exists in client cluster
+const hpaScaleTargetRetryPeriod = 30 * time.Second
+
+// NewHPAController returns a new *HPAController
+func NewHPAController(master kubernetes.Interface, client kubernetes.Interface,
+	masterInformer, clientInformer informers.SharedInformerFactory, nsLister corelisters.NamespaceLister,
+	nodeName string) Controller {
+	hpaInformer := masterInformer.Autoscaling().V1().HorizontalPodAutoscalers()
+	clientHPAInformer := clientInformer.Autoscaling().V1().HorizontalPodAutoscalers()
+	hpaRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	ctrl := &HPAController{
+		master:   master,
+		client:   client,
+		nodeName: nodeName,
+		queue:    workqueue.NewNamedRateLimitingQueue(hpaRateLimiter, "vk hpa controller"),
+
+		hpaLister:             hpaInformer.Lister(),
+		hpaListerSynced:       hpaInformer.Informer().HasSynced,
+		clientHPALister:       clientHPAInformer.Lister(),
+		clientHPAListerSynced: clientHPAInformer.Informer().HasSynced,
+		nsLister:              nsLister,
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: ctrl.hpaAdded,
+		UpdateFunc: func(old, new interface{}) {
+			ctrl.hpaAdded(new)
+		},
+		DeleteFunc: ctrl.hpaAdded,
+	}
+	hpaInformer.Informer().AddEventHandler(handler)
+	clientHPAInformer.Informer().AddEventHandler(handler)
+	return ctrl
+}
+
+// Run starts and listens on channel events
+func (ctrl *HPAController) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.hpaListerSynced, ctrl.clientHPAListerSynced) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.syncHPA, 0, stopCh)
+	}
+	<-stopCh
+}
+
+// hpaAdded reacts to a hpa add, update or delete in master or client cluster
+func (ctrl *HPAController) hpaAdded(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	ctrl.queue.Add(key)
+}
+
+// syncHPA deals with one key off the queue.
+func (ctrl *HPAController) syncHPA() {
+	keyObj, quit := ctrl.queue.Get()
+	if quit {
+		return
+	}
+	defer ctrl.queue.Done(keyObj)
+	key := keyObj.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ctrl.queue.Forget(key)
+		return
+	}
+	klog.V(4).Infof("Started hpa processing %q", key)
+
+	defer func() {
+		if err != nil {
+			klog.Error(err)
+			ctrl.queue.AddRateLimited(key)
+			return
+		}
+		ctrl.queue.Forget(key)
+	}()
+
+	var hpa *autoscalingv1.HorizontalPodAutoscaler
+	hpa, err = ctrl.hpaLister.HorizontalPodAutoscalers(namespace).Get(name)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		err = ctrl.deleteClientHPA(namespace, name)
+		return
+	}
+	if !isHPADelegated(hpa) || hpa.DeletionTimestamp != nil {
+		err = ctrl.deleteClientHPA(namespace, name)
+		return
+	}
+	if err = ensureNamespace(namespace, ctrl.client, ctrl.nsLister); err != nil {
+		return
+	}
+
+	var exists bool
+	if exists, err = ctrl.scaleTargetExists(namespace, hpa.Spec.ScaleTargetRef); err != nil {
+		return
+	}
+	if !exists {
+		// the workload is not delegated yet, the hpa in client cluster would fail to scale it
+		klog.V(4).Infof("Scale target of hpa %v not found in client cluster, retry later", key)
+		ctrl.queue.AddAfter(key, hpaScaleTargetRetryPeriod)
+		return
+	}
+
+	var hpaInSub *autoscalingv1.HorizontalPodAutoscaler
+	hpaInSub, err = ctrl.clientHPALister.HorizontalPodAutoscalers(namespace).Get(name)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		hpaInSub = hpa.DeepCopy()
+		filterCommon(&hpaInSub.ObjectMeta)
+		hpaInSub.Status = autoscalingv1.HorizontalPodAutoscalerStatus{}
+		if _, err = ctrl.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Create(context.TODO(),
+			hpaInSub, metav1.CreateOptions{}); err != nil {
+			err = fmt.Errorf("create hpa %v in client cluster failed, error: %v", key, err)
+			return
+		}
+		klog.Infof("Create hpa %v in client cluster success", key)
+		return
+	}
+	if !IsObjectGlobal(&hpaInSub.ObjectMeta) {
+		klog.V(4).Infof("Hpa %v in client cluster not created by vk, ignore", key)
+		return
+	}
+	if !reflect.DeepEqual(hpa.Spec, hpaInSub.Spec) {
+		hpaCopy := hpaInSub.DeepCopy()
+		hpaCopy.Spec = hpa.Spec
+		var patch []byte
+		if patch, err = util.CreateMergePatch(hpaInSub, hpaCopy); err != nil {
+			return
+		}
+		if _, err = ctrl.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Patch(context.TODO(), name,
+			mergetypes.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return
+		}
+		klog.V(4).Infof("Update hpa %v in client cluster success", key)
+	}
+	err = ctrl.syncStatus(hpa, hpaInSub)
+}
+
+// syncStatus records the status of client cluster on the hpa of master cluster, together with the
+// status aggregated from all of the client clusters
+func (ctrl *HPAController) syncStatus(hpa, hpaInSub *autoscalingv1.HorizontalPodAutoscaler) error {
+	status := clusterHPAStatus{
+		CurrentReplicas:                 hpaInSub.Status.CurrentReplicas,
+		DesiredReplicas:                 hpaInSub.Status.DesiredReplicas,
+		CurrentCPUUtilizationPercentage: hpaInSub.Status.CurrentCPUUtilizationPercentage,
+		LastScaleTime:                   hpaInSub.Status.LastScaleTime,
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	key := util.HPAStatusPrefix + ctrl.nodeName
+	hpaCopy := hpa.DeepCopy()
+	if hpaCopy.Annotations == nil {
+		hpaCopy.Annotations = map[string]string{}
+	}
+	hpaCopy.Annotations[key] = string(data)
+	aggregated, err := json.Marshal(aggregateHPAStatus(hpaCopy))
+	if err != nil {
+		return err
+	}
+	if hpa.Annotations[key] == string(data) && hpa.Annotations[util.HPAAggregatedStatus] == string(aggregated) {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				key:                      string(data),
+				util.HPAAggregatedStatus: string(aggregated),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ctrl.master.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Patch(context.TODO(),
+		hpa.Name, mergetypes.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// scaleTargetExists returns if the workload scaled by the hpa exists in client cluster,
+// kinds unknown are treated as existing
+func (ctrl *HPAController) scaleTargetExists(namespace string, ref autoscalingv1.CrossVersionObjectReference) (bool,
+	error) {
+	var err error
+	switch ref.Kind {
+	case "Deployment":
+		_, err = ctrl.client.AppsV1().Deployments(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case "StatefulSet":
+		_, err = ctrl.client.AppsV1().StatefulSets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case "ReplicaSet":
+		_, err = ctrl.client.AppsV1().ReplicaSets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	case "ReplicationController":
+		_, err = ctrl.client.CoreV1().ReplicationControllers(namespace).Get(context.TODO(), ref.Name,
+			metav1.GetOptions{})
+	default:
+		return true, nil
+	}
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteClientHPA deletes the hpa in client cluster created by vk
+func (ctrl *HPAController) deleteClientHPA(namespace, name string) error {
+	hpaInSub, err := ctrl.clientHPALister.HorizontalPodAutoscalers(namespace).Get(name)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !IsObjectGlobal(&hpaInSub.ObjectMeta) {
+		return nil
+	}
+	err = ctrl.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Delete(context.TODO(), name,
+		metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	klog.V(3).Infof("Hpa %v/%v deleted from client cluster", namespace, name)
+	return nil
+}
+
+// isHPADelegated returns if the workload scaled by the hpa is entirely delegated to client clusters
+func isHPADelegated(hpa *autoscalingv1.HorizontalPodAutoscaler) bool {
+	return hpa.Annotations != nil && hpa.Annotations[util.DelegateHPA] == "true"
+}
+
+// aggregateHPAStatus aggregates the status of client clusters recorded on the hpa, replicas are summed
+// and the cpu utilization is averaged weighted by current replicas
+func aggregateHPAStatus(hpa *autoscalingv1.HorizontalPodAutoscaler) autoscalingv1.HorizontalPodAutoscalerStatus {
+	observedGeneration := hpa.Generation
+	aggregated := autoscalingv1.HorizontalPodAutoscalerStatus{ObservedGeneration: &observedGeneration}
+	var utilization, weight int64
+	for k, v := range hpa.Annotations {
+		if !strings.HasPrefix(k, util.HPAStatusPrefix) {
+			continue
+		}
+		status := clusterHPAStatus{}
+		if err := json.Unmarshal([]byte(v), &status); err != nil {
+			klog.Warningf("Invalid hpa status %v of %v/%v: %v", k, hpa.Namespace, hpa.Name, err)
+			continue
+		}
+		aggregated.CurrentReplicas += status.CurrentReplicas
+		aggregated.DesiredReplicas += status.DesiredReplicas
+		if status.LastScaleTime != nil && (aggregated.LastScaleTime == nil ||
+			aggregated.LastScaleTime.Before(status.LastScaleTime)) {
+			aggregated.LastScaleTime = status.LastScaleTime
+		}
+		if status.CurrentCPUUtilizationPercentage != nil && status.CurrentReplicas > 0 {
+			utilization += int64(*status.CurrentCPUUtilizationPercentage) * int64(status.CurrentReplicas)
+			weight += int64(status.CurrentReplicas)
+		}
+	}
+	if weight > 0 {
+		average := int32(utilization / weight)
+		aggregated.CurrentCPUUtilizationPercentage = &average
+	}
+	return aggregated
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestAggregateHPAStatus(t *testing.T) {
+	hpa := newHPA(true)
+	hpa.Generation = 2
+	hpa.Annotations[util.HPAStatusPrefix+"vk-1"] = `{"currentReplicas":3,"desiredReplicas":4,` +
+		`"currentCPUUtilizationPercentage":80,"lastScaleTime":"2020-01-01T00:00:00Z"}`
+	hpa.Annotations[util.HPAStatusPrefix+"vk-2"] = `{"currentReplicas":1,"desiredReplicas":1,` +
+		`"currentCPUUtilizationPercentage":40,"lastScaleTime":"2020-01-02T00:00:00Z"}`
+	hpa.Annotations[util.HPAStatusPrefix+"vk-3"] = `invalid`
+
+	status := aggregateHPAStatus(hpa)
+	if status.CurrentReplicas != 4 || status.DesiredReplicas != 5 {
+		t.Fatalf("unexpected replicas %v/%v", status.CurrentReplicas, status.DesiredReplicas)
+	}
+	if status.CurrentCPUUtilizationPercentage == nil || *status.CurrentCPUUtilizationPercentage != 70 {
+		t.Fatalf("unexpected cpu utilization %v", status.CurrentCPUUtilizationPercentage)
+	}
+	if status.LastScaleTime == nil || status.LastScaleTime.Day() != 2 {
+		t.Fatalf("unexpected last scale time %v", status.LastScaleTime)
+	}
+	if status.ObservedGeneration == nil || *status.ObservedGeneration != 2 {
+		t.Fatalf("unexpected observed generation %v", status.ObservedGeneration)
+	}
+}
+
+func TestHPAController_RunAddHPA(t *testing.T) {
+	ctx := context.TODO()
+	cases := []struct {
+		name         string
+		delegated    bool
+		targetExists bool
+		shouldAdded  bool
+	}{
+		{
+			name:         "should add delegated hpa",
+			delegated:    true,
+			targetExists: true,
+			shouldAdded:  true,
+		},
+		{
+			name:         "should not add hpa not delegated",
+			delegated:    false,
+			targetExists: true,
+			shouldAdded:  false,
+		},
+		{
+			name:         "should not add hpa before scale target delegated",
+			delegated:    true,
+			targetExists: false,
+			shouldAdded:  false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hpa := newHPA(c.delegated)
+			master := fake.NewSimpleClientset(hpa)
+			client := fake.NewSimpleClientset()
+			if c.targetExists {
+				client = fake.NewSimpleClientset(&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				})
+			}
+			masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+			clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+			nsLister := clientInformer.Core().V1().Namespaces().Lister()
+			ctrl := NewHPAController(master, client, masterInformer, clientInformer, nsLister, "vk-1")
+
+			stopCh := make(chan struct{})
+			masterInformer.Start(stopCh)
+			clientInformer.Start(stopCh)
+			go test(ctrl, 1, stopCh)
+
+			err := wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+				_, err := client.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Get(ctx, hpa.Name,
+					metav1.GetOptions{})
+				if err != nil {
+					if errors.IsNotFound(err) && !c.shouldAdded {
+						return true, nil
+					}
+					return false, nil
+				}
+				return c.shouldAdded, nil
+			})
+			if err != nil {
+				t.Error("hpa add failed")
+			}
+			if !c.shouldAdded {
+				return
+			}
+			err = wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+				newHPA, err := master.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Get(ctx, hpa.Name,
+					metav1.GetOptions{})
+				if err != nil {
+					return false, nil
+				}
+				_, ok := newHPA.Annotations[util.HPAStatusPrefix+"vk-1"]
+				_, aggregated := newHPA.Annotations[util.HPAAggregatedStatus]
+				return ok && aggregated, nil
+			})
+			if err != nil {
+				t.Error("hpa status not recorded")
+			}
+		})
+	}
+}
+
+func newHPA(delegated bool) *autoscalingv1.HorizontalPodAutoscaler {
+	minReplicas := int32(1)
+	hpa := &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "test",
+				APIVersion: "apps/v1",
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: 10,
+		},
+	}
+	if delegated {
+		hpa.Annotations[util.DelegateHPA] = "true"
+	}
+	return hpa
+}
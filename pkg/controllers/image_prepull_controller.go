@@ -0,0 +1,384 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// imagePrePullPeriod is the period to check the progress of pulling images
+	imagePrePullPeriod = 5 * time.Second
+
+	// ImagePrePullingReason is the reason of the events of upper pods reporting the nodes pulled the images
+	ImagePrePullingReason = "ImagePrePulling"
+	// ImagePrePulledReason is the reason of the event of upper pods once all the nodes pulled the images
+	ImagePrePulledReason = "ImagePrePulled"
+	// ImagePrePullFailedReason is the reason of the events of upper pods once the images failed to be pulled
+	ImagePrePullFailedReason = "ImagePrePullFailed"
+)
+
+// imagePullFailures are the waiting reasons of containers failing to pull their images
+var imagePullFailures = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// ImagePrePullController pre-pulls the images of the pods bound to the virtual node and annotated with
+// util.PrePullImages in the lower cluster, by a short-lived DaemonSet running the images on every lower node,
+// so that the pods and the ones scaled out after them start fast on whichever node. The progress is reported by
+// the events of the upper pods, and the DaemonSet is deleted once the images are pulled by all the nodes or ttl
+// expired
+type ImagePrePullController struct {
+	client        kubernetes.Interface
+	nodeName      string
+	ttl           time.Duration
+	eventRecorder record.EventRecorder
+	queue         workqueue.RateLimitingInterface
+	// progress records the progress reported last of each upper pod
+	progress     map[string]string
+	progressLock sync.Mutex
+
+	podLister             corelisters.PodLister
+	podListerSynced       cache.InformerSynced
+	clientDSLister        appslisters.DaemonSetLister
+	clientDSListerSynced  cache.InformerSynced
+	clientPodLister       corelisters.PodLister
+	clientPodListerSynced cache.InformerSynced
+}
+
+// NewImagePrePullController returns a new *ImagePrePullController
+func NewImagePrePullController(master kubernetes.Interface, client kubernetes.Interface,
+	masterInformer, clientInformer informers.SharedInformerFactory, nodeName string, ttl time.Duration) Controller {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: master.CoreV1().Events(v1.NamespaceAll)})
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "virtual-kubelet"})
+	podInformer := masterInformer.Core().V1().Pods()
+	clientDSInformer := clientInformer.Apps().V1().DaemonSets()
+	clientPodInformer := clientInformer.Core().V1().Pods()
+	prePullRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	ctrl := &ImagePrePullController{
+		client:        client,
+		nodeName:      nodeName,
+		ttl:           ttl,
+		eventRecorder: eventRecorder,
+		queue:         workqueue.NewNamedRateLimitingQueue(prePullRateLimiter, "vk image pre-pull controller"),
+		progress:      map[string]string{},
+
+		podLister:             podInformer.Lister(),
+		podListerSynced:       podInformer.Informer().HasSynced,
+		clientDSLister:        clientDSInformer.Lister(),
+		clientDSListerSynced:  clientDSInformer.Informer().HasSynced,
+		clientPodLister:       clientPodInformer.Lister(),
+		clientPodListerSynced: clientPodInformer.Informer().HasSynced,
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: ctrl.podUpdated,
+		UpdateFunc: func(old, new interface{}) {
+			ctrl.podUpdated(new)
+		},
+		DeleteFunc: ctrl.podDeleted,
+	})
+	return ctrl
+}
+
+// Run starts and listens on channel events
+func (ctrl *ImagePrePullController) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.podListerSynced, ctrl.clientDSListerSynced,
+		ctrl.clientPodListerSynced) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.syncPod, 0, stopCh)
+	}
+	// DaemonSets of the pods deleted or started before the images pulled are cleaned up once ttl expired
+	go wait.Until(ctrl.cleanupExpired, ctrl.ttl/2+imagePrePullPeriod, stopCh)
+	<-stopCh
+}
+
+// podUpdated enqueues the pending pods bound to the virtual node asking for pre-pulling their images
+func (ctrl *ImagePrePullController) podUpdated(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || !ctrl.shouldPrePull(pod) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	ctrl.queue.Add(key)
+}
+
+// podDeleted forgets the progress reported of the pod
+func (ctrl *ImagePrePullController) podDeleted(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ctrl.progressLock.Lock()
+	delete(ctrl.progress, key)
+	ctrl.progressLock.Unlock()
+}
+
+func (ctrl *ImagePrePullController) shouldPrePull(pod *v1.Pod) bool {
+	return pod.Spec.NodeName == ctrl.nodeName && pod.Annotations[util.PrePullImages] == "true" &&
+		pod.Status.Phase == v1.PodPending && pod.DeletionTimestamp == nil
+}
+
+// syncPod deals with one key off the queue.
+func (ctrl *ImagePrePullController) syncPod() {
+	keyObj, quit := ctrl.queue.Get()
+	if quit {
+		return
+	}
+	defer ctrl.queue.Done(keyObj)
+	key := keyObj.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ctrl.queue.Forget(key)
+		return
+	}
+	pod, err := ctrl.podLister.Pods(namespace).Get(name)
+	if err != nil || !ctrl.shouldPrePull(pod) {
+		ctrl.queue.Forget(key)
+		return
+	}
+	done, err := ctrl.prePull(key, pod)
+	if err != nil {
+		klog.Errorf("Pre-pull images of pod %v failed: %v", key, err)
+		ctrl.queue.AddRateLimited(key)
+		return
+	}
+	ctrl.queue.Forget(key)
+	if !done {
+		ctrl.queue.AddAfter(key, imagePrePullPeriod)
+	}
+}
+
+// prePull creates the DaemonSet pulling the images of the pod if absent, reports the progress and returns if
+// the images are pulled by all the nodes or the DaemonSet expired
+func (ctrl *ImagePrePullController) prePull(key string, pod *v1.Pod) (bool, error) {
+	desired := newImagePullerDaemonSet(pod)
+	ds, err := ctrl.clientDSLister.DaemonSets(desired.Namespace).Get(desired.Name)
+	if apierrs.IsNotFound(err) {
+		if ds, err = ctrl.client.AppsV1().DaemonSets(desired.Namespace).Create(context.TODO(), desired,
+			metav1.CreateOptions{}); err != nil && !apierrs.IsAlreadyExists(err) {
+			return false, err
+		}
+		klog.V(3).Infof("Create image puller %v/%v of pod %v", desired.Namespace, desired.Name, key)
+		ctrl.report(key, pod, v1.EventTypeNormal, ImagePrePullingReason,
+			fmt.Sprintf("Pre-pulling images %v in client cluster", imagesOf(pod)))
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	pods, err := ctrl.clientPodLister.Pods(ds.Namespace).List(labels.SelectorFromSet(labels.Set{
+		util.ImagePrePull: ds.Name}))
+	if err != nil {
+		return false, err
+	}
+	pulled, failures := 0, map[string]bool{}
+	for _, p := range pods {
+		ok, failure := imagesPulled(p)
+		if ok {
+			pulled++
+		} else if len(failure) != 0 {
+			failures[failure] = true
+		}
+	}
+	nodes := int(ds.Status.DesiredNumberScheduled)
+	if len(failures) != 0 {
+		ctrl.report(key, pod, v1.EventTypeWarning, ImagePrePullFailedReason,
+			fmt.Sprintf("Pre-pulling images failed: %v", sortedKeys(failures)))
+	}
+	if nodes > 0 && pulled >= nodes {
+		ctrl.report(key, pod, v1.EventTypeNormal, ImagePrePulledReason,
+			fmt.Sprintf("Images pulled on all %d nodes of client cluster", nodes))
+		return true, ctrl.deleteDaemonSet(ds)
+	}
+	if time.Since(ds.CreationTimestamp.Time) > ctrl.ttl {
+		return true, ctrl.deleteDaemonSet(ds)
+	}
+	ctrl.report(key, pod, v1.EventTypeNormal, ImagePrePullingReason,
+		fmt.Sprintf("Images pulled on %d/%d nodes of client cluster", pulled, nodes))
+	return false, nil
+}
+
+// report records the event on the upper pod if the message differs from the one reported last
+func (ctrl *ImagePrePullController) report(key string, pod *v1.Pod, eventType, reason, message string) {
+	ctrl.progressLock.Lock()
+	defer ctrl.progressLock.Unlock()
+	if ctrl.progress[key] == message {
+		return
+	}
+	ctrl.progress[key] = message
+	ctrl.eventRecorder.Event(pod, eventType, reason, message)
+}
+
+// cleanupExpired deletes the DaemonSets pulling images longer than ttl
+func (ctrl *ImagePrePullController) cleanupExpired() {
+	selector, err := labels.Parse(util.ImagePrePull)
+	if err != nil {
+		return
+	}
+	dss, err := ctrl.clientDSLister.List(selector)
+	if err != nil {
+		klog.Errorf("List image pullers failed: %v", err)
+		return
+	}
+	for _, ds := range dss {
+		if time.Since(ds.CreationTimestamp.Time) <= ctrl.ttl {
+			continue
+		}
+		if err = ctrl.deleteDaemonSet(ds); err != nil {
+			klog.Errorf("Delete expired image puller %v/%v failed: %v", ds.Namespace, ds.Name, err)
+		}
+	}
+}
+
+func (ctrl *ImagePrePullController) deleteDaemonSet(ds *appsv1.DaemonSet) error {
+	err := ctrl.client.AppsV1().DaemonSets(ds.Namespace).Delete(context.TODO(), ds.Name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(ds.UID)),
+	})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	klog.V(3).Infof("Delete image puller %v/%v", ds.Namespace, ds.Name)
+	return nil
+}
+
+// imagesOf returns the images of the containers of the pod, deduplicated and sorted
+func imagesOf(pod *v1.Pod) []string {
+	images := map[string]bool{}
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			images[container.Image] = true
+		}
+	}
+	return sortedKeys(images)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newImagePullerDaemonSet returns the DaemonSet pulling the images of the pod on every node, named by the hash of
+// the images and pull secrets so that pods sharing them share the DaemonSet. The containers just sleep, images
+// without a shell fail to start after pulled, which still counts
+func newImagePullerDaemonSet(pod *v1.Pod) *appsv1.DaemonSet {
+	images := imagesOf(pod)
+	name := "image-puller-" + conflict.Hash(images, pod.Spec.ImagePullSecrets)
+	podLabels := map[string]string{util.ImagePrePull: name}
+	var containers []v1.Container
+	for i, image := range images {
+		containers = append(containers, v1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: v1.PullIfNotPresent,
+			Command:         []string{"/bin/sh", "-c", "sleep 3600"},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("1m"),
+					v1.ResourceMemory: resource.MustParse("4Mi"),
+				},
+				Limits: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("10m"),
+					v1.ResourceMemory: resource.MustParse("16Mi"),
+				},
+			},
+		})
+	}
+	var gracePeriod int64
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pod.Namespace,
+			Labels:    podLabels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: v1.PodSpec{
+					Containers:                    containers,
+					ImagePullSecrets:              pod.Spec.ImagePullSecrets,
+					Tolerations:                   []v1.Toleration{{Operator: v1.TolerationOpExists}},
+					TerminationGracePeriodSeconds: &gracePeriod,
+					AutomountServiceAccountToken:  new(bool),
+				},
+			},
+		},
+	}
+}
+
+// imagesPulled returns if the images of all the containers of the puller are pulled, or the failure of pulling
+func imagesPulled(pod *v1.Pod) (bool, string) {
+	if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false, ""
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if len(status.ImageID) != 0 || status.State.Running != nil || status.State.Terminated != nil ||
+			status.LastTerminationState.Terminated != nil {
+			continue
+		}
+		if waiting := status.State.Waiting; waiting != nil && imagePullFailures[waiting.Reason] {
+			return false, fmt.Sprintf("%v %v", waiting.Reason, status.Image)
+		}
+		return false, ""
+	}
+	return true, ""
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func newPrePullPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{util.PrePullImages: "true"},
+		},
+		Spec: v1.PodSpec{
+			NodeName:       "vk",
+			InitContainers: []v1.Container{{Name: "init", Image: "busybox"}},
+			Containers:     []v1.Container{{Name: "web", Image: "nginx"}, {Name: "sidecar", Image: "busybox"}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending},
+	}
+}
+
+func TestImagePrePullController_Run(t *testing.T) {
+	pod := newPrePullPod()
+	desired := newImagePullerDaemonSet(pod)
+	master := fake.NewSimpleClientset(pod)
+	client := fake.NewSimpleClientset()
+	masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+	clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+	c := NewImagePrePullController(master, client, masterInformer, clientInformer, "vk", time.Minute)
+	stopCh := make(chan struct{})
+	masterInformer.Start(stopCh)
+	clientInformer.Start(stopCh)
+	go test(c, 1, stopCh)
+
+	var ds *appsv1.DaemonSet
+	err := wait.Poll(100*time.Millisecond, 5*time.Second, func() (bool, error) {
+		var err error
+		ds, err = client.AppsV1().DaemonSets("default").Get(context.TODO(), desired.Name, metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Desire image puller created, get %v", err)
+	}
+	if len(ds.Spec.Template.Spec.Containers) != 2 {
+		t.Fatalf("Desire 2 images pulled, get %+v", ds.Spec.Template.Spec.Containers)
+	}
+
+	ds.Status.DesiredNumberScheduled = 1
+	if _, err = client.AppsV1().DaemonSets("default").Update(context.TODO(), ds, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	puller := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "puller", Namespace: "default", Labels: ds.Spec.Template.Labels},
+		Spec:       ds.Spec.Template.Spec,
+		Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+			{Name: "image-0", ImageID: "docker-pullable://busybox@sha256:1"},
+			{Name: "image-1", ImageID: "docker-pullable://nginx@sha256:2"},
+		}},
+	}
+	if _, err = client.CoreV1().Pods("default").Create(context.TODO(), puller, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err = wait.Poll(100*time.Millisecond, 8*time.Second, func() (bool, error) {
+		_, err := client.AppsV1().DaemonSets("default").Get(context.TODO(), desired.Name, metav1.GetOptions{})
+		return errors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Fatal("Desire image puller deleted once images pulled on all nodes")
+	}
+}
+
+func TestImagesPulled(t *testing.T) {
+	puller := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "a"}, {Name: "b"}}}}
+	if pulled, _ := imagesPulled(puller); pulled {
+		t.Fatal("Desire images not pulled without statuses")
+	}
+	puller.Status.ContainerStatuses = []v1.ContainerStatus{
+		{Name: "a", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+		{Name: "b", Image: "private", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+			Reason: "ImagePullBackOff"}}},
+	}
+	if pulled, failure := imagesPulled(puller); pulled || failure != "ImagePullBackOff private" {
+		t.Fatalf("Desire pull failure, get %v %v", pulled, failure)
+	}
+	puller.Status.ContainerStatuses[1].State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+		Reason: "CrashLoopBackOff"}}
+	puller.Status.ContainerStatuses[1].LastTerminationState = v1.ContainerState{
+		Terminated: &v1.ContainerStateTerminated{ExitCode: 127}}
+	if pulled, _ := imagesPulled(puller); !pulled {
+		t.Fatal("Desire images failing to start counted pulled")
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	podOrphan     = "pod"
+	serviceOrphan = "service"
+	pvcOrphan     = "persistentvolumeclaim"
+)
+
+// orphan is an object of client cluster whose upper object is gone
+type orphan struct {
+	kind string
+	meta *metav1.ObjectMeta
+}
+
+// OrphanGCController is a controller periodically deleting the objects synced into client cluster whose
+// upper objects are gone, e.g. leaked when the virtual node crashed while deleting them. Virtual pods are
+// orphans if the upper pod of the name and uid is gone, the global configMaps, secrets, services and pvcs if
+// the upper object of the name is gone and no pod in client cluster references them
+type OrphanGCController struct {
+	client  kubernetes.Interface
+	cluster string
+	// upperCluster skips the pods created from other upper clusters sharing the client cluster if set
+	upperCluster string
+	period       time.Duration
+	dryRun       bool
+	// namespaces translates the namespaces of master cluster into the ones of client cluster, objects in the
+	// namespaces not translated from master cluster are skipped
+	namespaces *util.NamespaceMapping
+
+	masterPodLister       corelisters.PodLister
+	masterConfigMapLister corelisters.ConfigMapLister
+	masterSecretLister    corelisters.SecretLister
+	masterServiceLister   corelisters.ServiceLister
+	masterPVCLister       corelisters.PersistentVolumeClaimLister
+	clientPodLister       corelisters.PodLister
+	clientConfigMapLister corelisters.ConfigMapLister
+	clientSecretLister    corelisters.SecretLister
+	clientServiceLister   corelisters.ServiceLister
+	clientPVCLister       corelisters.PersistentVolumeClaimLister
+	listersSynced         []cache.InformerSynced
+}
+
+// NewOrphanGCController returns a new *OrphanGCController collecting orphans every period, objects created
+// within a period are never collected. Orphans are only logged and counted with dryRun
+func NewOrphanGCController(client kubernetes.Interface, masterInformer, clientInformer informers.SharedInformerFactory,
+	cluster, upperCluster string, period time.Duration, dryRun bool, namespaces *util.NamespaceMapping) Controller {
+	master := masterInformer.Core().V1()
+	lower := clientInformer.Core().V1()
+	ctrl := &OrphanGCController{
+		client:       client,
+		cluster:      cluster,
+		upperCluster: upperCluster,
+		period:       period,
+		dryRun:       dryRun,
+		namespaces:   namespaces,
+
+		masterPodLister:       master.Pods().Lister(),
+		masterConfigMapLister: master.ConfigMaps().Lister(),
+		masterSecretLister:    master.Secrets().Lister(),
+		masterServiceLister:   master.Services().Lister(),
+		masterPVCLister:       master.PersistentVolumeClaims().Lister(),
+		clientPodLister:       lower.Pods().Lister(),
+		clientConfigMapLister: lower.ConfigMaps().Lister(),
+		clientSecretLister:    lower.Secrets().Lister(),
+		clientServiceLister:   lower.Services().Lister(),
+		clientPVCLister:       lower.PersistentVolumeClaims().Lister(),
+	}
+	for _, informer := range []cache.SharedIndexInformer{master.Pods().Informer(), master.ConfigMaps().Informer(),
+		master.Secrets().Informer(), master.Services().Informer(), master.PersistentVolumeClaims().Informer(),
+		lower.Pods().Informer(), lower.ConfigMaps().Informer(), lower.Secrets().Informer(),
+		lower.Services().Informer(), lower.PersistentVolumeClaims().Informer()} {
+		ctrl.listersSynced = append(ctrl.listersSynced, informer.HasSynced)
+	}
+	return ctrl
+}
+
+// Run starts collecting orphans every period until stopCh closed, workers is ignored since orphans are
+// collected in one pass
+func (ctrl *OrphanGCController) Run(workers int, stopCh <-chan struct{}) {
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.listersSynced...) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	wait.Until(ctrl.collect, ctrl.period, stopCh)
+}
+
+// collect deletes the orphans found in client cluster, or only logs them in dry run
+func (ctrl *OrphanGCController) collect() {
+	orphans, err := ctrl.findOrphans(time.Now())
+	if err != nil {
+		klog.Errorf("Find orphans in client cluster failed: %v", err)
+		return
+	}
+	for _, o := range orphans {
+		if ctrl.dryRun {
+			klog.Infof("Orphan %v %v/%v found in client cluster, kept in dry run", o.kind, o.meta.Namespace,
+				o.meta.Name)
+			metrics.OrphansCollected.WithLabelValues(ctrl.cluster, o.kind, metrics.CollectDryRun).Inc()
+			continue
+		}
+		if err = ctrl.deleteOrphan(o); err != nil {
+			if !apierrs.IsNotFound(err) && !apierrs.IsConflict(err) {
+				klog.Errorf("Delete orphan %v %v/%v failed: %v", o.kind, o.meta.Namespace, o.meta.Name, err)
+			}
+			continue
+		}
+		klog.V(3).Infof("Orphan %v %v/%v deleted from client cluster", o.kind, o.meta.Namespace, o.meta.Name)
+		metrics.OrphansCollected.WithLabelValues(ctrl.cluster, o.kind, metrics.CollectDeleted).Inc()
+	}
+}
+
+// findOrphans returns the orphans in client cluster created before a period ago
+func (ctrl *OrphanGCController) findOrphans(now time.Time) ([]orphan, error) {
+	var orphans []orphan
+	pods, err := ctrl.clientPodLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if !util.IsVirtualPod(pod) || !ctrl.collectable(&pod.ObjectMeta, now) {
+			continue
+		}
+		if origin := pod.Labels[util.OriginCluster]; len(ctrl.upperCluster) != 0 && len(origin) != 0 &&
+			origin != ctrl.upperCluster {
+			continue
+		}
+		namespace, ok := ctrl.namespaces.ToUpper(pod.Namespace)
+		if !ok {
+			continue
+		}
+		upper, err := ctrl.masterPodLister.Pods(namespace).Get(pod.Name)
+		if err != nil && !apierrs.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			uid := pod.Annotations[util.UpperPodUID]
+			if len(uid) == 0 || uid == string(upper.UID) {
+				continue
+			}
+		}
+		orphans = append(orphans, orphan{kind: podOrphan, meta: &pod.ObjectMeta})
+	}
+
+	var candidates []orphan
+	configMaps, err := ctrl.clientConfigMapLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, configMap := range configMaps {
+		candidates = append(candidates, orphan{kind: configMapDependency, meta: &configMap.ObjectMeta})
+	}
+	secrets, err := ctrl.clientSecretLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		candidates = append(candidates, orphan{kind: secretDependency, meta: &secret.ObjectMeta})
+	}
+	services, err := ctrl.clientServiceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		candidates = append(candidates, orphan{kind: serviceOrphan, meta: &service.ObjectMeta})
+	}
+	pvcs, err := ctrl.clientPVCLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range pvcs {
+		candidates = append(candidates, orphan{kind: pvcOrphan, meta: &pvc.ObjectMeta})
+	}
+	for _, c := range candidates {
+		if !IsObjectGlobal(c.meta) || !ctrl.collectable(c.meta, now) {
+			continue
+		}
+		gone, err := ctrl.upperGone(c)
+		if err != nil {
+			return nil, err
+		}
+		if !gone {
+			continue
+		}
+		referenced, err := ctrl.referenced(c)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced {
+			orphans = append(orphans, c)
+		}
+	}
+	return orphans, nil
+}
+
+// collectable tells whether the object could be collected, the ones being deleted, owned by other objects
+// and collected by the garbage collector of client cluster, or created within a period are skipped
+func (ctrl *OrphanGCController) collectable(meta *metav1.ObjectMeta, now time.Time) bool {
+	return meta.DeletionTimestamp == nil && len(meta.OwnerReferences) == 0 &&
+		meta.CreationTimestamp.Add(ctrl.period).Before(now)
+}
+
+// upperGone tells whether the upper object of the name is gone, the objects in namespaces not translated from
+// master cluster are never gone
+func (ctrl *OrphanGCController) upperGone(o orphan) (bool, error) {
+	namespace, ok := ctrl.namespaces.ToUpper(o.meta.Namespace)
+	if !ok {
+		return false, nil
+	}
+	var err error
+	switch o.kind {
+	case configMapDependency:
+		_, err = ctrl.masterConfigMapLister.ConfigMaps(namespace).Get(o.meta.Name)
+	case secretDependency:
+		_, err = ctrl.masterSecretLister.Secrets(namespace).Get(o.meta.Name)
+	case serviceOrphan:
+		_, err = ctrl.masterServiceLister.Services(namespace).Get(o.meta.Name)
+	case pvcOrphan:
+		_, err = ctrl.masterPVCLister.PersistentVolumeClaims(namespace).Get(o.meta.Name)
+	}
+	if apierrs.IsNotFound(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// referenced tells whether the configMap, secret or pvc is referenced by any pod in client cluster
+func (ctrl *OrphanGCController) referenced(o orphan) (bool, error) {
+	if o.kind == serviceOrphan {
+		return false, nil
+	}
+	pods, err := ctrl.clientPodLister.Pods(o.meta.Namespace).List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods {
+		refs := util.GetPodReferences(pod)
+		switch o.kind {
+		case configMapDependency:
+			if _, ok := refs.ConfigMaps[o.meta.Name]; ok {
+				return true, nil
+			}
+		case secretDependency:
+			if _, ok := refs.Secrets[o.meta.Name]; ok {
+				return true, nil
+			}
+		case pvcOrphan:
+			for _, volume := range pod.Spec.Volumes {
+				if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == o.meta.Name {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// deleteOrphan deletes the orphan from client cluster, the version checked is required so that the one
+// updated since found is kept for the next pass
+func (ctrl *OrphanGCController) deleteOrphan(o orphan) error {
+	ctx := context.TODO()
+	opts := metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &o.meta.UID, ResourceVersion: &o.meta.ResourceVersion},
+	}
+	core := ctrl.client.CoreV1()
+	switch o.kind {
+	case podOrphan:
+		return core.Pods(o.meta.Namespace).Delete(ctx, o.meta.Name, opts)
+	case configMapDependency:
+		return core.ConfigMaps(o.meta.Namespace).Delete(ctx, o.meta.Name, opts)
+	case secretDependency:
+		return core.Secrets(o.meta.Namespace).Delete(ctx, o.meta.Name, opts)
+	case serviceOrphan:
+		return core.Services(o.meta.Namespace).Delete(ctx, o.meta.Name, opts)
+	case pvcOrphan:
+		return core.PersistentVolumeClaims(o.meta.Namespace).Delete(ctx, o.meta.Name, opts)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestOrphanGCController_FindOrphans(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	newMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name),
+			CreationTimestamp: created, Annotations: map[string]string{util.GlobalLabel: "true"}}
+	}
+	newPod := func(name, upperUID string) *v1.Pod {
+		meta := newMeta(name)
+		meta.Annotations = map[string]string{util.UpperPodUID: upperUID}
+		meta.Labels = map[string]string{util.VirtualPodLabel: "true"}
+		return &v1.Pod{ObjectMeta: meta}
+	}
+
+	upperPod := newPod("kept", "")
+	upperPod.UID = "upper"
+	recreated := upperPod.DeepCopy()
+	recreated.Name = "recreated"
+	recreated.UID = "new"
+	upperConfigMap := &v1.ConfigMap{ObjectMeta: newMeta("kept")}
+
+	lowerPod := newPod("kept", "upper")
+	stalePod := newPod("recreated", "old")
+	goneUpper := newPod("gone", "upper")
+	otherCluster := newPod("other", "upper")
+	otherCluster.Labels[util.OriginCluster] = "other"
+	young := newPod("young", "upper")
+	young.CreationTimestamp = metav1.Now()
+	referencing := newPod("referencing", "")
+	referencing.Labels = nil
+	referencing.Spec.Volumes = []v1.Volume{{Name: "config",
+		VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+			LocalObjectReference: v1.LocalObjectReference{Name: "referenced"}}}}}
+	keptConfigMap := &v1.ConfigMap{ObjectMeta: newMeta("kept")}
+	referenced := &v1.ConfigMap{ObjectMeta: newMeta("referenced")}
+	orphanConfigMap := &v1.ConfigMap{ObjectMeta: newMeta("orphan")}
+	notGlobal := &v1.Secret{ObjectMeta: newMeta("not-global")}
+	notGlobal.Annotations = nil
+	orphanService := &v1.Service{ObjectMeta: newMeta("orphan")}
+
+	master := fake.NewSimpleClientset()
+	masterObjects := []runtime.Object{upperPod, recreated, upperConfigMap}
+	lowerObjects := []runtime.Object{lowerPod, stalePod, goneUpper, otherCluster, young, referencing,
+		keptConfigMap, referenced, orphanConfigMap, notGlobal, orphanService}
+	client := fake.NewSimpleClientset(lowerObjects...)
+	masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+	clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+	ctrl := NewOrphanGCController(client, masterInformer, clientInformer, "cluster", "upper", time.Minute,
+		false, nil).(*OrphanGCController)
+	add := func(factory informers.SharedInformerFactory, objects []runtime.Object) {
+		core := factory.Core().V1()
+		for _, obj := range objects {
+			var err error
+			switch o := obj.(type) {
+			case *v1.Pod:
+				err = core.Pods().Informer().GetStore().Add(o)
+			case *v1.ConfigMap:
+				err = core.ConfigMaps().Informer().GetStore().Add(o)
+			case *v1.Secret:
+				err = core.Secrets().Informer().GetStore().Add(o)
+			case *v1.Service:
+				err = core.Services().Informer().GetStore().Add(o)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add(masterInformer, masterObjects)
+	add(clientInformer, lowerObjects)
+
+	orphans, err := ctrl.findOrphans(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, o := range orphans {
+		found[o.kind+"/"+o.meta.Name] = true
+	}
+	expected := []string{"pod/recreated", "pod/gone", "configmap/orphan", "service/orphan"}
+	if len(found) != len(expected) {
+		t.Fatalf("Desire orphans %v, get %v", expected, found)
+	}
+	for _, key := range expected {
+		if !found[key] {
+			t.Fatalf("Desire orphans %v, get %v", expected, found)
+		}
+	}
+
+	ctrl.dryRun = true
+	ctrl.collect()
+	if _, err = client.CoreV1().Pods("default").Get(context.TODO(), "gone", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Desire orphans kept in dry run, get %v", err)
+	}
+	ctrl.dryRun = false
+	ctrl.collect()
+	if _, err = client.CoreV1().Pods("default").Get(context.TODO(), "gone",
+		metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire orphan pod deleted, get %v", err)
+	}
+	if _, err = client.CoreV1().ConfigMaps("default").Get(context.TODO(), "referenced",
+		metav1.GetOptions{}); err != nil {
+		t.Fatalf("Desire referenced configMap kept, get %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	mergetypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// PDBController is a controller mirrors the PDBs of master cluster covering pods on the virtual node
+// into client cluster, and aggregates the disruptions allowed in all client clusters back to master cluster
+type PDBController struct {
+	master   kubernetes.Interface
+	client   kubernetes.Interface
+	nodeName string
+	queue    workqueue.RateLimitingInterface
+
+	pdbLister             policylisters.PodDisruptionBudgetLister
+	pdbListerSynced       cache.InformerSynced
+	clientPDBLister       policylisters.PodDisruptionBudgetLister
+	clientPDBListerSynced cache.InformerSynced
+	podLister             corelisters.PodLister
+	podListerSynced       cache.InformerSynced
+	nsLister              corelisters.NamespaceLister
+}
+
+// clusterPDBStatus is the status of the PDB in a client cluster recorded on the PDB of master cluster
+type clusterPDBStatus struct {
+	DisruptionsAllowed int32 `json:"disruptionsAllowed"`
+	CurrentHealthy     int32 `json:"currentHealthy"`
+	DesiredHealthy     int32 `json:"desiredHealthy"`
+	ExpectedPods       int32 `json:"expectedPods"`
+}
+
+// NewPDBController returns a new *PDBController
+func NewPDBController(master kubernetes.Interface, client kubernetes.Interface,
+	masterInformer, clientInformer informers.SharedInformerFactory, nsLister corelisters.NamespaceLister,
+	nodeName string) Controller {
+	pdbInformer := masterInformer.Policy().V1beta1().PodDisruptionBudgets()
+	clientPDBInformer := clientInformer.Policy().V1beta1().PodDisruptionBudgets()
+	podInformer := masterInformer.Core().V1().Pods()
+	pdbRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	ctrl := &PDBController{
+		master:   master,
+		client:   client,
+		nodeName: nodeName,
+		queue:    workqueue.NewNamedRateLimitingQueue(pdbRateLimiter, "vk pdb controller"),
+
+		pdbLister:             pdbInformer.Lister(),
+		pdbListerSynced:       pdbInformer.Informer().HasSynced,
+		clientPDBLister:       clientPDBInformer.Lister(),
+		clientPDBListerSynced: clientPDBInformer.Informer().HasSynced,
+		podLister:             podInformer.Lister(),
+		podListerSynced:       podInformer.Informer().HasSynced,
+		nsLister:              nsLister,
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: ctrl.pdbAdded,
+		UpdateFunc: func(old, new interface{}) {
+			ctrl.pdbAdded(new)
+		},
+		DeleteFunc: ctrl.pdbAdded,
+	}
+	pdbInformer.Informer().AddEventHandler(handler)
+	clientPDBInformer.Informer().AddEventHandler(handler)
+	podInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			pod := podFromObject(obj)
+			return pod != nil && pod.Spec.NodeName == nodeName
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: ctrl.podAdded,
+			UpdateFunc: func(old, new interface{}) {
+				oldPod, newPod := old.(*v1.Pod), new.(*v1.Pod)
+				if reflect.DeepEqual(oldPod.Labels, newPod.Labels) {
+					return
+				}
+				ctrl.podAdded(old)
+				ctrl.podAdded(new)
+			},
+			DeleteFunc: ctrl.podAdded,
+		},
+	})
+	return ctrl
+}
+
+// Run starts and listens on channel events
+func (ctrl *PDBController) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.pdbListerSynced, ctrl.clientPDBListerSynced, ctrl.podListerSynced) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.syncPDB, 0, stopCh)
+	}
+	<-stopCh
+}
+
+// pdbAdded reacts to a pdb add, update or delete in master or client cluster
+func (ctrl *PDBController) pdbAdded(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	ctrl.queue.Add(key)
+}
+
+// podAdded enqueues the pdbs in the namespace of the pod bound to or removed from the virtual node
+func (ctrl *PDBController) podAdded(obj interface{}) {
+	pod := podFromObject(obj)
+	if pod == nil {
+		return
+	}
+	pdbs, err := ctrl.pdbLister.PodDisruptionBudgets(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, pdb := range pdbs {
+		ctrl.pdbAdded(pdb)
+	}
+}
+
+// podFromObject returns the pod of obj, which may be a tombstone, nil would be returned if it is not a pod
+func podFromObject(obj interface{}) *v1.Pod {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, _ := obj.(*v1.Pod)
+	return pod
+}
+
+// syncPDB deals with one key off the queue.
+func (ctrl *PDBController) syncPDB() {
+	keyObj, quit := ctrl.queue.Get()
+	if quit {
+		return
+	}
+	defer ctrl.queue.Done(keyObj)
+	key := keyObj.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ctrl.queue.Forget(key)
+		return
+	}
+	klog.V(4).Infof("Started pdb processing %q", key)
+
+	defer func() {
+		if err != nil {
+			klog.Error(err)
+			ctrl.queue.AddRateLimited(key)
+			return
+		}
+		ctrl.queue.Forget(key)
+	}()
+
+	var pdb *policyv1beta1.PodDisruptionBudget
+	pdb, err = ctrl.pdbLister.PodDisruptionBudgets(namespace).Get(name)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		err = ctrl.deleteClientPDB(namespace, name)
+		return
+	}
+	var local, total int32
+	local, total, err = ctrl.countCoveredPods(pdb)
+	if err != nil {
+		return
+	}
+	if local == 0 || pdb.DeletionTimestamp != nil {
+		if err = ctrl.deleteClientPDB(namespace, name); err != nil {
+			return
+		}
+		err = ctrl.removeStatus(pdb)
+		return
+	}
+	if err = ensureNamespace(namespace, ctrl.client, ctrl.nsLister); err != nil {
+		return
+	}
+
+	var pdbInSub *policyv1beta1.PodDisruptionBudget
+	pdbInSub, err = ctrl.clientPDBLister.PodDisruptionBudgets(namespace).Get(name)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		pdbInSub = pdb.DeepCopy()
+		filterCommon(&pdbInSub.ObjectMeta)
+		pdbInSub.Spec = scalePDBSpec(pdb.Spec, local, total)
+		pdbInSub.Status = policyv1beta1.PodDisruptionBudgetStatus{}
+		if _, err = ctrl.client.PolicyV1beta1().PodDisruptionBudgets(namespace).Create(context.TODO(),
+			pdbInSub, metav1.CreateOptions{}); err != nil {
+			err = fmt.Errorf("create pdb %v in client cluster failed, error: %v", key, err)
+			return
+		}
+		klog.Infof("Create pdb %v in client cluster success", key)
+		return
+	}
+	if !IsObjectGlobal(&pdbInSub.ObjectMeta) {
+		klog.V(4).Infof("Pdb %v in client cluster not created by vk, ignore", key)
+		return
+	}
+	if !reflect.DeepEqual(scalePDBSpec(pdb.Spec, local, total), pdbInSub.Spec) {
+		// spec of pdb is immutable before 1.15, recreate it
+		if err = ctrl.deleteClientPDB(namespace, name); err != nil {
+			return
+		}
+		err = fmt.Errorf("pdb %v in client cluster outdated, recreate it", key)
+		return
+	}
+	err = ctrl.syncStatus(pdb, pdbInSub)
+}
+
+// countCoveredPods returns the number of pods selected by the pdb on the virtual node and in total
+func (ctrl *PDBController) countCoveredPods(pdb *policyv1beta1.PodDisruptionBudget) (int32, int32, error) {
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		return 0, 0, nil
+	}
+	if selector.Empty() {
+		return 0, 0, nil
+	}
+	pods, err := ctrl.podLister.Pods(pdb.Namespace).List(selector)
+	if err != nil {
+		return 0, 0, err
+	}
+	var local, total int32
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		total++
+		if pod.Spec.NodeName == ctrl.nodeName {
+			local++
+		}
+	}
+	return local, total, nil
+}
+
+// scalePDBSpec scales the absolute minAvailable and maxUnavailable of the pdb by the share of pods
+// on the virtual node, rounded conservatively, percentages are kept since they apply to each cluster
+func scalePDBSpec(spec policyv1beta1.PodDisruptionBudgetSpec,
+	local, total int32) policyv1beta1.PodDisruptionBudgetSpec {
+	scaled := *spec.DeepCopy()
+	if total == 0 || local >= total {
+		return scaled
+	}
+	if scaled.MinAvailable != nil && scaled.MinAvailable.Type == intstr.Int {
+		// rounded up, so that the pods kept available in all clusters are not less than desired
+		minAvailable := (scaled.MinAvailable.IntVal*local + total - 1) / total
+		if minAvailable > local {
+			minAvailable = local
+		}
+		value := intstr.FromInt(int(minAvailable))
+		scaled.MinAvailable = &value
+	}
+	if scaled.MaxUnavailable != nil && scaled.MaxUnavailable.Type == intstr.Int {
+		// rounded down, so that the pods disrupted in all clusters are not more than desired
+		value := intstr.FromInt(int(scaled.MaxUnavailable.IntVal * local / total))
+		scaled.MaxUnavailable = &value
+	}
+	return scaled
+}
+
+// syncStatus records the status of client cluster on the pdb of master cluster, then records the
+// disruptions allowed aggregated from all of the client clusters. The status of pdb in master cluster
+// is owned by the disruption controller, so the aggregated value is kept in annotation
+func (ctrl *PDBController) syncStatus(pdb, pdbInSub *policyv1beta1.PodDisruptionBudget) error {
+	status := clusterPDBStatus{
+		DisruptionsAllowed: pdbInSub.Status.DisruptionsAllowed,
+		CurrentHealthy:     pdbInSub.Status.CurrentHealthy,
+		DesiredHealthy:     pdbInSub.Status.DesiredHealthy,
+		ExpectedPods:       pdbInSub.Status.ExpectedPods,
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	key := util.PDBStatusPrefix + ctrl.nodeName
+	annotations := map[string]string{key: string(data)}
+	for k, v := range pdb.Annotations {
+		if strings.HasPrefix(k, util.PDBStatusPrefix) && k != key {
+			annotations[k] = v
+		}
+	}
+	allowed := strconv.Itoa(int(aggregateDisruptionsAllowed(annotations)))
+	if pdb.Annotations[key] == string(data) && pdb.Annotations[util.DisruptionsAllowed] == allowed {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: string(data), util.DisruptionsAllowed: allowed},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ctrl.master.PolicyV1beta1().PodDisruptionBudgets(pdb.Namespace).Patch(context.TODO(),
+		pdb.Name, mergetypes.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// removeStatus removes the status of client cluster from the pdb of master cluster once the pdb no longer
+// covers the virtual node, and records the disruptions allowed aggregated from the rest client clusters
+func (ctrl *PDBController) removeStatus(pdb *policyv1beta1.PodDisruptionBudget) error {
+	return removePDBStatus(ctrl.master, pdb, ctrl.nodeName)
+}
+
+// removePDBStatus removes the status of the client cluster hosted by the virtual node from the pdb of
+// master cluster, and records the disruptions allowed aggregated from the rest client clusters
+func removePDBStatus(master kubernetes.Interface, pdb *policyv1beta1.PodDisruptionBudget, nodeName string) error {
+	key := util.PDBStatusPrefix + nodeName
+	if _, ok := pdb.Annotations[key]; !ok {
+		return nil
+	}
+	annotations := map[string]interface{}{key: nil}
+	rest := map[string]string{}
+	for k, v := range pdb.Annotations {
+		if strings.HasPrefix(k, util.PDBStatusPrefix) && k != key {
+			rest[k] = v
+		}
+	}
+	annotations[util.DisruptionsAllowed] = nil
+	if allowed := aggregateDisruptionsAllowed(rest); allowed >= 0 {
+		annotations[util.DisruptionsAllowed] = strconv.Itoa(int(allowed))
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = master.PolicyV1beta1().PodDisruptionBudgets(pdb.Namespace).Patch(context.TODO(),
+		pdb.Name, mergetypes.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// deleteClientPDB deletes the pdb in client cluster created by vk
+func (ctrl *PDBController) deleteClientPDB(namespace, name string) error {
+	pdbInSub, err := ctrl.clientPDBLister.PodDisruptionBudgets(namespace).Get(name)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !IsObjectGlobal(&pdbInSub.ObjectMeta) {
+		return nil
+	}
+	err = ctrl.client.PolicyV1beta1().PodDisruptionBudgets(namespace).Delete(context.TODO(), name,
+		metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	klog.V(3).Infof("Pdb %v/%v deleted from client cluster", namespace, name)
+	return nil
+}
+
+// aggregateDisruptionsAllowed returns the least disruptions allowed of client clusters, it is conservative
+// since the cluster a disrupted pod runs in is unknown to the caller, -1 would be returned if no status found
+func aggregateDisruptionsAllowed(annotations map[string]string) int32 {
+	allowed := int32(-1)
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, util.PDBStatusPrefix) {
+			continue
+		}
+		status := clusterPDBStatus{}
+		if err := json.Unmarshal([]byte(v), &status); err != nil {
+			klog.Warningf("Invalid pdb status %v: %v", k, err)
+			continue
+		}
+		if allowed < 0 || status.DisruptionsAllowed < allowed {
+			allowed = status.DisruptionsAllowed
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestScalePDBSpec(t *testing.T) {
+	minAvailable := intstr.FromInt(3)
+	maxUnavailable := intstr.FromInt(3)
+	percent := intstr.FromString("50%")
+	cases := []struct {
+		name         string
+		spec         policyv1beta1.PodDisruptionBudgetSpec
+		local, total int32
+		desired      intstr.IntOrString
+	}{
+		{
+			name:    "min available rounded up",
+			spec:    policyv1beta1.PodDisruptionBudgetSpec{MinAvailable: &minAvailable},
+			local:   2,
+			total:   4,
+			desired: intstr.FromInt(2),
+		},
+		{
+			name:    "max unavailable rounded down",
+			spec:    policyv1beta1.PodDisruptionBudgetSpec{MaxUnavailable: &maxUnavailable},
+			local:   2,
+			total:   4,
+			desired: intstr.FromInt(1),
+		},
+		{
+			name:    "percentage kept",
+			spec:    policyv1beta1.PodDisruptionBudgetSpec{MinAvailable: &percent},
+			local:   1,
+			total:   4,
+			desired: percent,
+		},
+		{
+			name:    "all pods on the node",
+			spec:    policyv1beta1.PodDisruptionBudgetSpec{MinAvailable: &minAvailable},
+			local:   4,
+			total:   4,
+			desired: minAvailable,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scaled := scalePDBSpec(c.spec, c.local, c.total)
+			value := scaled.MinAvailable
+			if value == nil {
+				value = scaled.MaxUnavailable
+			}
+			if *value != c.desired {
+				t.Fatalf("Desired %v, get %v", c.desired.String(), value.String())
+			}
+		})
+	}
+}
+
+func TestAggregateDisruptionsAllowed(t *testing.T) {
+	annotations := map[string]string{
+		util.PDBStatusPrefix + "vk-1": `{"disruptionsAllowed":3}`,
+		util.PDBStatusPrefix + "vk-2": `{"disruptionsAllowed":1}`,
+		util.PDBStatusPrefix + "vk-3": `invalid`,
+	}
+	if allowed := aggregateDisruptionsAllowed(annotations); allowed != 1 {
+		t.Fatalf("Desired 1, get %v", allowed)
+	}
+	if allowed := aggregateDisruptionsAllowed(nil); allowed != -1 {
+		t.Fatalf("Desired -1, get %v", allowed)
+	}
+}
+
+func TestPDBController_RunAddPDB(t *testing.T) {
+	ctx := context.TODO()
+	cases := []struct {
+		name        string
+		nodeName    string
+		shouldAdded bool
+	}{
+		{
+			name:        "should add pdb covering pods on the node",
+			nodeName:    "vk-1",
+			shouldAdded: true,
+		},
+		{
+			name:        "should not add pdb not covering pods on the node",
+			nodeName:    "vk-2",
+			shouldAdded: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pdb := newPDB()
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Labels: map[string]string{"app": "test"}},
+				Spec:       v1.PodSpec{NodeName: "vk-1"},
+			}
+			master := fake.NewSimpleClientset(pdb, pod)
+			client := fake.NewSimpleClientset()
+			masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+			clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+			nsLister := clientInformer.Core().V1().Namespaces().Lister()
+			ctrl := NewPDBController(master, client, masterInformer, clientInformer, nsLister, c.nodeName)
+
+			stopCh := make(chan struct{})
+			masterInformer.Start(stopCh)
+			clientInformer.Start(stopCh)
+			go test(ctrl, 1, stopCh)
+
+			err := wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+				_, err := client.PolicyV1beta1().PodDisruptionBudgets(pdb.Namespace).Get(ctx, pdb.Name,
+					metav1.GetOptions{})
+				if err != nil {
+					if errors.IsNotFound(err) && !c.shouldAdded {
+						return true, nil
+					}
+					return false, nil
+				}
+				return c.shouldAdded, nil
+			})
+			if err != nil {
+				t.Error("pdb add failed")
+			}
+			if !c.shouldAdded {
+				return
+			}
+			err = wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+				newPDB, err := master.PolicyV1beta1().PodDisruptionBudgets(pdb.Namespace).Get(ctx, pdb.Name,
+					metav1.GetOptions{})
+				if err != nil {
+					return false, nil
+				}
+				_, ok := newPDB.Annotations[util.PDBStatusPrefix+c.nodeName]
+				return ok && newPDB.Annotations[util.DisruptionsAllowed] == "0", nil
+			})
+			if err != nil {
+				t.Error("pdb status not recorded")
+			}
+		})
+	}
+}
+
+func newPDB() *policyv1beta1.PodDisruptionBudget {
+	minAvailable := intstr.FromInt(1)
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+		},
+	}
+}
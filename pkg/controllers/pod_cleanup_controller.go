@@ -0,0 +1,224 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// statusSyncRetryPeriod is the period we wait for status of a completed pod synced to master
+const statusSyncRetryPeriod = 10 * time.Second
+
+// PodCleanupController is a controller deletes Succeeded/Failed pods from client cluster
+// after their status has been synced to master cluster and the ttl expired
+type PodCleanupController struct {
+	client kubernetes.Interface
+	queue  workqueue.RateLimitingInterface
+	ttl    time.Duration
+	// namespaces translates the namespaces of master cluster into the ones of client cluster
+	namespaces *util.NamespaceMapping
+
+	masterPodLister       corelisters.PodLister
+	masterPodListerSynced cache.InformerSynced
+	clientPodLister       corelisters.PodLister
+	clientPodListerSynced cache.InformerSynced
+}
+
+// NewPodCleanupController returns a new *PodCleanupController
+func NewPodCleanupController(client kubernetes.Interface,
+	masterInformer, clientInformer informers.SharedInformerFactory, ttl time.Duration,
+	namespaces *util.NamespaceMapping) Controller {
+	podInformer := masterInformer.Core().V1().Pods()
+	clientPodInformer := clientInformer.Core().V1().Pods()
+	podRateLimiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second)
+	ctrl := &PodCleanupController{
+		client: client,
+		queue:  workqueue.NewNamedRateLimitingQueue(podRateLimiter, "vk pod cleanup controller"),
+		ttl:    ttl,
+
+		namespaces: namespaces,
+
+		masterPodLister:       podInformer.Lister(),
+		masterPodListerSynced: podInformer.Informer().HasSynced,
+		clientPodLister:       clientPodInformer.Lister(),
+		clientPodListerSynced: clientPodInformer.Informer().HasSynced,
+	}
+	clientPodInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: ctrl.podAdded,
+		UpdateFunc: func(old, new interface{}) {
+			ctrl.podAdded(new)
+		},
+	})
+	return ctrl
+}
+
+// Run starts and listens on channel events
+func (ctrl *PodCleanupController) Run(workers int, stopCh <-chan struct{}) {
+	defer ctrl.queue.ShutDown()
+	klog.Infof("Starting controller")
+	defer klog.Infof("Shutting controller")
+	if !cache.WaitForCacheSync(stopCh, ctrl.masterPodListerSynced, ctrl.clientPodListerSynced) {
+		klog.Errorf("Cannot sync caches from master and client")
+		return
+	}
+	klog.Infof("Sync caches successfully")
+	for i := 0; i < workers; i++ {
+		go wait.Until(ctrl.syncPod, 0, stopCh)
+	}
+	<-stopCh
+}
+
+// podAdded reacts to a pod add or update in client cluster
+func (ctrl *PodCleanupController) podAdded(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	if !util.IsVirtualPod(pod) || !podCompleted(pod) || pod.DeletionTimestamp != nil {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	klog.V(6).Infof("Enqueue completed pod %v", key)
+	ctrl.queue.AddAfter(key, ctrl.expireAfter(pod))
+}
+
+// syncPod deals with one key off the queue.
+func (ctrl *PodCleanupController) syncPod() {
+	keyObj, quit := ctrl.queue.Get()
+	if quit {
+		return
+	}
+	defer ctrl.queue.Done(keyObj)
+	key := keyObj.(string)
+	namespace, podName, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		ctrl.queue.Forget(key)
+		return
+	}
+	klog.V(4).Infof("Started completed pod processing %q", key)
+
+	defer func() {
+		if err != nil {
+			klog.Error(err)
+			ctrl.queue.AddRateLimited(key)
+			return
+		}
+		ctrl.queue.Forget(key)
+	}()
+
+	var pod *v1.Pod
+	pod, err = ctrl.clientPodLister.Pods(namespace).Get(podName)
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			err = nil
+		}
+		return
+	}
+	if !podCompleted(pod) || pod.DeletionTimestamp != nil {
+		return
+	}
+	if remain := ctrl.expireAfter(pod); remain > 0 {
+		ctrl.queue.AddAfter(key, remain)
+		return
+	}
+
+	upperNamespace, ok := ctrl.namespaces.ToUpper(namespace)
+	if !ok {
+		// created from another master cluster sharing the client cluster
+		return
+	}
+	var podInMaster *v1.Pod
+	podInMaster, err = ctrl.masterPodLister.Pods(upperNamespace).Get(podName)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		err = nil
+	} else if uid := pod.Annotations[util.UpperPodUID]; len(uid) != 0 && types.UID(uid) != podInMaster.UID {
+		// the upper pod of the name is a new one, e.g. a StatefulSet pod re-created, the status of the pod
+		// created for the previous one is never synced to it
+		klog.V(4).Infof("Pod %v is created for previous upper pod %v, not %v", key, uid, podInMaster.UID)
+	} else if podInMaster.Status.Phase != pod.Status.Phase {
+		klog.V(4).Infof("Status of pod %v has not been synced to master, phase %v", key,
+			podInMaster.Status.Phase)
+		ctrl.queue.AddAfter(key, statusSyncRetryPeriod)
+		return
+	}
+
+	if err = ctrl.client.CoreV1().Pods(namespace).Delete(context.TODO(), podName,
+		metav1.DeleteOptions{GracePeriodSeconds: new(int64)}); err != nil {
+		if !apierrs.IsNotFound(err) {
+			return
+		}
+		err = nil
+	}
+	klog.V(3).Infof("Completed pod %q deleted from client cluster", key)
+}
+
+// expireAfter returns the duration remained before the completed pod can be cleaned
+func (ctrl *PodCleanupController) expireAfter(pod *v1.Pod) time.Duration {
+	return podFinishedTime(pod).Add(ctrl.ttl).Sub(time.Now())
+}
+
+func podCompleted(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// podFinishedTime returns the time the last container terminated, if no container status found,
+// transition time of the Ready condition or the creation time would be used
+func podFinishedTime(pod *v1.Pod) time.Time {
+	var finishedAt time.Time
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Terminated == nil {
+				continue
+			}
+			if status.State.Terminated.FinishedAt.After(finishedAt) {
+				finishedAt = status.State.Terminated.FinishedAt.Time
+			}
+		}
+	}
+	if !finishedAt.IsZero() {
+		return finishedAt
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return pod.CreationTimestamp.Time
+}
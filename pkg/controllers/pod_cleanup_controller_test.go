@@ -0,0 +1,165 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestPodCleanupController_Run(t *testing.T) {
+	finished := metav1.NewTime(time.Now().Add(-time.Hour))
+	completed := newCompletedPod(v1.PodSucceeded, finished)
+	running := completed.DeepCopy()
+	running.Status.Phase = v1.PodRunning
+	justFinished := newCompletedPod(v1.PodFailed, metav1.Now())
+	previous := completed.DeepCopy()
+	previous.Annotations = map[string]string{util.UpperPodUID: "previous"}
+	recreated := running.DeepCopy()
+	recreated.UID = "current"
+
+	cases := []struct {
+		name    string
+		master  *v1.Pod
+		client  *v1.Pod
+		deleted bool
+	}{
+		{
+			name:    "status synced and ttl expired",
+			master:  completed,
+			client:  completed,
+			deleted: true,
+		},
+		{
+			name:    "status not synced",
+			master:  running,
+			client:  completed,
+			deleted: false,
+		},
+		{
+			name:    "ttl not expired",
+			master:  justFinished,
+			client:  justFinished,
+			deleted: false,
+		},
+		{
+			name:    "pod deleted from master",
+			client:  completed,
+			deleted: true,
+		},
+		{
+			name:    "upper pod re-created",
+			master:  recreated,
+			client:  previous,
+			deleted: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			master := fake.NewSimpleClientset()
+			if c.master != nil {
+				master = fake.NewSimpleClientset(c.master)
+			}
+			client := fake.NewSimpleClientset(c.client)
+			masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+			clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+			ctrl := NewPodCleanupController(client, masterInformer, clientInformer, 10*time.Minute, nil)
+			stopCh := make(chan struct{})
+			masterInformer.Start(stopCh)
+			clientInformer.Start(stopCh)
+			go test(ctrl, 1, stopCh)
+
+			err := wait.Poll(10*time.Millisecond, 3*time.Second, func() (bool, error) {
+				_, err := client.CoreV1().Pods(c.client.Namespace).Get(context.TODO(),
+					c.client.Name, metav1.GetOptions{})
+				return errors.IsNotFound(err), nil
+			})
+			if (err == nil) != c.deleted {
+				t.Errorf("Desire deleted: %v, get: %v", c.deleted, err == nil)
+			}
+		})
+	}
+}
+
+func TestPodFinishedTime(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	ready := metav1.NewTime(time.Now().Add(-time.Hour))
+	finished := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	pod := newCompletedPod(v1.PodSucceeded, finished)
+	pod.CreationTimestamp = created
+	noContainerStatus := pod.DeepCopy()
+	noContainerStatus.Status.ContainerStatuses = nil
+	noContainerStatus.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, LastTransitionTime: ready}}
+	noStatus := noContainerStatus.DeepCopy()
+	noStatus.Status.Conditions = nil
+
+	cases := []struct {
+		pod    *v1.Pod
+		desire time.Time
+	}{
+		{
+			pod:    pod,
+			desire: finished.Time,
+		},
+		{
+			pod:    noContainerStatus,
+			desire: ready.Time,
+		},
+		{
+			pod:    noStatus,
+			desire: created.Time,
+		},
+	}
+	for i, c := range cases {
+		if get := podFinishedTime(c.pod); !get.Equal(c.desire) {
+			t.Errorf("case %v desire %v, get %v", i, c.desire, get)
+		}
+	}
+}
+
+func newCompletedPod(phase v1.PodPhase, finishedAt metav1.Time) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			Labels:    map[string]string{util.VirtualPodLabel: "true"},
+		},
+		Status: v1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name: "test",
+					State: v1.ContainerState{
+						Terminated: &v1.ContainerStateTerminated{FinishedAt: finishedAt},
+					},
+				},
+			},
+		},
+	}
+}
@@ -64,11 +64,14 @@ type PVController struct {
 	clientPVListerSynced  cache.InformerSynced
 
 	hostIP string
+	// namespaces translates the namespaces of master cluster into the ones of client cluster
+	namespaces *util.NamespaceMapping
 }
 
 // NewPVController returns a new *PVController
 func NewPVController(master kubernetes.Interface, client kubernetes.Interface,
-	masterInformer, clientInformer informers.SharedInformerFactory, hostIP string) Controller {
+	masterInformer, clientInformer informers.SharedInformerFactory, hostIP string,
+	namespaces *util.NamespaceMapping) Controller {
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: master.CoreV1().Events(v1.NamespaceAll)})
 	var eventRecorder record.EventRecorder
@@ -90,6 +93,7 @@ func NewPVController(master kubernetes.Interface, client kubernetes.Interface,
 		pvcMasterQueue: workqueue.NewNamedRateLimitingQueue(pvcRateLimiter, "vk pvc controller"),
 		pvMasterQueue:  workqueue.NewNamedRateLimitingQueue(pvRateLimiter, "vk pv controller"),
 		hostIP:         hostIP,
+		namespaces:     namespaces,
 	}
 	pvcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		UpdateFunc: ctrl.pvcInMasterUpdated,
@@ -104,7 +108,8 @@ func NewPVController(master kubernetes.Interface, client kubernetes.Interface,
 	ctrl.masterPVListerSynced = pvInformer.Informer().HasSynced
 
 	clientPVCInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		// AddFunc:    ctrl.pvcAdded,
+		// claims bound while the controller is down are only seen as added
+		AddFunc:    ctrl.pvcInClientAdded,
 		UpdateFunc: ctrl.pvcInClientUpdated,
 		// DeleteFunc: ctrl.pvcAdded,
 	})
@@ -165,6 +170,15 @@ func (ctrl *PVController) pvcAdded(obj interface{}) {
 	}
 }
 
+// pvcInClientAdded reacts to a PVC creation in client cluster, the status of bound ones is synced to master
+func (ctrl *PVController) pvcInClientAdded(obj interface{}) {
+	pvc := obj.(*v1.PersistentVolumeClaim)
+	if !IsObjectGlobal(&pvc.ObjectMeta) || pvc.Status.Phase != v1.ClaimBound {
+		return
+	}
+	ctrl.pvcAdded(obj)
+}
+
 //pvcInClientUpdated reacts to a PVC update in client cluster
 func (ctrl *PVController) pvcInClientUpdated(old, new interface{}) {
 	newPVC := new.(*v1.PersistentVolumeClaim)
@@ -344,12 +358,13 @@ func (ctrl *PVController) syncPVCFromMaster() {
 	}()
 	var pvc *v1.PersistentVolumeClaim
 	deletePVCInClient := false
+	lowerNamespace := ctrl.namespaces.ToLower(namespace)
 	pvc, err = ctrl.masterPVCLister.PersistentVolumeClaims(namespace).Get(pvcName)
 	if err != nil {
 		if !apierrs.IsNotFound(err) {
 			return
 		}
-		_, err = ctrl.clientPVCLister.PersistentVolumeClaims(namespace).Get(pvcName)
+		_, err = ctrl.clientPVCLister.PersistentVolumeClaims(lowerNamespace).Get(pvcName)
 		if err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Get pvc from master cluster failed, error: %v", err)
@@ -364,7 +379,7 @@ func (ctrl *PVController) syncPVCFromMaster() {
 	}
 
 	if deletePVCInClient || pvc.DeletionTimestamp != nil {
-		if err = ctrl.client.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvcName,
+		if err = ctrl.client.CoreV1().PersistentVolumeClaims(lowerNamespace).Delete(context.TODO(), pvcName,
 			metav1.DeleteOptions{}); err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Delete pvc from client cluster failed, error: %v", err)
@@ -378,7 +393,7 @@ func (ctrl *PVController) syncPVCFromMaster() {
 
 	// capacity updated
 	var old *v1.PersistentVolumeClaim
-	old, err = ctrl.clientPVCLister.PersistentVolumeClaims(namespace).Get(pvcName)
+	old, err = ctrl.clientPVCLister.PersistentVolumeClaims(lowerNamespace).Get(pvcName)
 	if err != nil {
 		klog.Errorf("Get pvc from client cluster failed, error: %v", err)
 		return
@@ -498,8 +513,12 @@ func (ctrl *PVController) syncPVCStatusHandler(pvc *v1.PersistentVolumeClaim) {
 			return
 		}
 	}()
+	namespace, ok := ctrl.namespaces.ToUpper(pvc.Namespace)
+	if !ok {
+		return
+	}
 	var pvcInMaster *v1.PersistentVolumeClaim
-	pvcInMaster, err = ctrl.masterPVCLister.PersistentVolumeClaims(pvc.Namespace).Get(pvc.Name)
+	pvcInMaster, err = ctrl.masterPVCLister.PersistentVolumeClaims(namespace).Get(pvc.Name)
 	if err != nil {
 		if !apierrs.IsNotFound(err) {
 			return
@@ -513,6 +532,7 @@ func (ctrl *PVController) syncPVCStatusHandler(pvc *v1.PersistentVolumeClaim) {
 	if err = filterPVC(pvcCopy, ctrl.hostIP); err != nil {
 		return
 	}
+	pvcCopy.Namespace = namespace
 	pvcCopy.ResourceVersion = pvcInMaster.ResourceVersion
 	klog.V(5).Infof("Old pvc %+v\n, new %+v", pvcInMaster, pvcCopy)
 	if _, err = ctrl.patchPVC(pvcInMaster, pvcCopy, ctrl.master, true); err != nil {
@@ -547,10 +567,12 @@ func (ctrl *PVController) syncPVStatusHandler(pv *v1.PersistentVolume) {
 		if pvCopy.Spec.ClaimRef != nil || pvInMaster.Spec.ClaimRef == nil {
 			claim := pvCopy.Spec.ClaimRef
 			var newPVC *v1.PersistentVolumeClaim
-			newPVC, err = ctrl.masterPVCLister.PersistentVolumeClaims(claim.Namespace).Get(claim.Name)
+			newPVC, err = ctrl.masterPVCLister.PersistentVolumeClaims(ctrl.upperNamespace(claim.Namespace)).
+				Get(claim.Name)
 			if err != nil {
 				return
 			}
+			pvInMaster.Spec.ClaimRef.Namespace = newPVC.Namespace
 			pvInMaster.Spec.ClaimRef.UID = newPVC.UID
 			pvInMaster.Spec.ClaimRef.ResourceVersion = newPVC.ResourceVersion
 		}
@@ -571,10 +593,12 @@ func (ctrl *PVController) syncPVStatusHandler(pv *v1.PersistentVolume) {
 	if pvCopy.Spec.ClaimRef != nil || pvInMaster.Spec.ClaimRef == nil {
 		claim := pvCopy.Spec.ClaimRef
 		var newPVC *v1.PersistentVolumeClaim
-		newPVC, err = ctrl.masterPVCLister.PersistentVolumeClaims(claim.Namespace).Get(claim.Name)
+		newPVC, err = ctrl.masterPVCLister.PersistentVolumeClaims(ctrl.upperNamespace(claim.Namespace)).
+			Get(claim.Name)
 		if err != nil {
 			return
 		}
+		pvCopy.Spec.ClaimRef.Namespace = newPVC.Namespace
 		pvCopy.Spec.ClaimRef.UID = newPVC.UID
 		pvCopy.Spec.ClaimRef.ResourceVersion = newPVC.ResourceVersion
 	}
@@ -704,7 +728,11 @@ func (ctrl *PVController) gc() {
 		if !IsObjectGlobal(&pvc.ObjectMeta) {
 			continue
 		}
-		_, err = ctrl.masterPVCLister.PersistentVolumeClaims(pvc.Namespace).Get(pvc.Name)
+		namespace, ok := ctrl.namespaces.ToUpper(pvc.Namespace)
+		if !ok {
+			continue
+		}
+		_, err = ctrl.masterPVCLister.PersistentVolumeClaims(namespace).Get(pvc.Name)
 		if err != nil && apierrs.IsNotFound(err) {
 			err := ctrl.client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx,
 				pvc.Name, metav1.DeleteOptions{})
@@ -716,6 +744,13 @@ func (ctrl *PVController) gc() {
 	}
 }
 
+// upperNamespace returns the namespace of master cluster the namespace of client cluster is translated from, the
+// empty one if not translated from master cluster, in which no claim is found
+func (ctrl *PVController) upperNamespace(namespace string) string {
+	upper, _ := ctrl.namespaces.ToUpper(namespace)
+	return upper
+}
+
 func (ctrl *PVController) runGC(stopCh <-chan struct{}) {
 	wait.Until(ctrl.gc, 3*time.Minute, stopCh)
 }
@@ -59,12 +59,14 @@ type ServiceController struct {
 	clientEndpointsListerSynced cache.InformerSynced
 
 	nsLister corelisters.NamespaceLister
+	// namespaces translates the namespaces of master cluster into the ones of client cluster
+	namespaces *util.NamespaceMapping
 }
 
 // NewServiceController returns a new *ServiceController
 func NewServiceController(master kubernetes.Interface, client kubernetes.Interface,
 	masterInformer, clientInformer informers.SharedInformerFactory,
-	nsLister corelisters.NamespaceLister) Controller {
+	nsLister corelisters.NamespaceLister, namespaces *util.NamespaceMapping) Controller {
 	broadcaster := record.NewBroadcaster()
 	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: master.CoreV1().Events(v1.NamespaceAll)})
 	var eventRecorder record.EventRecorder
@@ -82,6 +84,7 @@ func NewServiceController(master kubernetes.Interface, client kubernetes.Interfa
 		client:         client,
 		eventRecorder:  eventRecorder,
 		nsLister:       nsLister,
+		namespaces:     namespaces,
 		serviceQueue:   workqueue.NewNamedRateLimitingQueue(serviceRateLimiter, "vk service controller"),
 		endpointsQueue: workqueue.NewNamedRateLimitingQueue(endpointsRateLimiter, "vk endpoints controller"),
 	}
@@ -184,7 +187,8 @@ func (ctrl *ServiceController) endpointsAdded(obj interface{}) {
 func (ctrl *ServiceController) endpointsUpdated(old, new interface{}) {
 
 	newEndpoints := new.(*v1.Endpoints)
-	if ctrl.shouldEnqueue(&newEndpoints.ObjectMeta) && IsObjectGlobal(&newEndpoints.ObjectMeta) {
+	if ctrl.shouldEnqueue(&newEndpoints.ObjectMeta) &&
+		(IsObjectGlobal(&newEndpoints.ObjectMeta) || ctrl.syncedToClient(newEndpoints)) {
 		key, err := cache.MetaNamespaceKeyFunc(new)
 		if err != nil {
 			runtime.HandleError(err)
@@ -212,7 +216,8 @@ func (ctrl *ServiceController) syncService() {
 	}
 	klog.V(4).Infof("Started service processing %q", serviceName)
 
-	if err = ensureNamespace(namespace, ctrl.client, ctrl.nsLister); err != nil {
+	lowerNamespace := ctrl.namespaces.ToLower(namespace)
+	if err = ensureNamespace(lowerNamespace, ctrl.client, ctrl.nsLister); err != nil {
 		ctrl.serviceQueue.AddRateLimited(key)
 		klog.Errorf("Create role in client cluster failed, error: %v", err)
 		return
@@ -239,7 +244,7 @@ func (ctrl *ServiceController) syncService() {
 				err = fmt.Errorf("get service from master cluster failed, error: %v", err)
 				return
 			}
-			if err = ctrl.client.CoreV1().Services(namespace).Delete(ctx, serviceName,
+			if err = ctrl.client.CoreV1().Services(lowerNamespace).Delete(ctx, serviceName,
 				metav1.DeleteOptions{}); err != nil {
 				if !apierrs.IsNotFound(err) {
 					klog.Errorf("Delete service in client cluster failed, error: %v", err)
@@ -253,7 +258,7 @@ func (ctrl *ServiceController) syncService() {
 	}
 
 	if service.DeletionTimestamp != nil {
-		if err = ctrl.client.CoreV1().Services(namespace).Delete(ctx, serviceName,
+		if err = ctrl.client.CoreV1().Services(lowerNamespace).Delete(ctx, serviceName,
 			metav1.DeleteOptions{}); err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Delete service in client cluster failed, error: %v", err)
@@ -284,7 +289,8 @@ func (ctrl *ServiceController) syncEndpoints() {
 	}
 	klog.V(4).Infof("Started endpoints processing %q/%q", namespace, endpointsName)
 
-	if err = ensureNamespace(namespace, ctrl.client, ctrl.nsLister); err != nil {
+	lowerNamespace := ctrl.namespaces.ToLower(namespace)
+	if err = ensureNamespace(lowerNamespace, ctrl.client, ctrl.nsLister); err != nil {
 		ctrl.endpointsQueue.AddRateLimited(key)
 		klog.Errorf("Create role in client cluster failed, error: %v", err)
 		return
@@ -311,7 +317,7 @@ func (ctrl *ServiceController) syncEndpoints() {
 			if !apierrs.IsNotFound(err) {
 				return
 			}
-			if err = ctrl.client.CoreV1().Endpoints(namespace).Delete(ctx, endpointsName,
+			if err = ctrl.client.CoreV1().Endpoints(lowerNamespace).Delete(ctx, endpointsName,
 				metav1.DeleteOptions{}); err != nil {
 				if !apierrs.IsNotFound(err) {
 					klog.Errorf("Delete endpoint in client cluster failed, error: %v", err)
@@ -326,7 +332,7 @@ func (ctrl *ServiceController) syncEndpoints() {
 	}
 
 	if endpoints.DeletionTimestamp != nil {
-		if err = ctrl.client.CoreV1().Endpoints(namespace).Delete(ctx, endpointsName,
+		if err = ctrl.client.CoreV1().Endpoints(lowerNamespace).Delete(ctx, endpointsName,
 			metav1.DeleteOptions{}); err != nil {
 			if !apierrs.IsNotFound(err) {
 				klog.Errorf("Delete service in client cluster failed, error: %v", err)
@@ -354,8 +360,9 @@ func (ctrl *ServiceController) syncServiceHandler(service *v1.Service) {
 			return
 		}
 	}()
+	lowerNamespace := ctrl.namespaces.ToLower(service.Namespace)
 	var serviceInSub *v1.Service
-	serviceInSub, err = ctrl.clientServiceLister.Services(service.Namespace).Get(service.Name)
+	serviceInSub, err = ctrl.clientServiceLister.Services(lowerNamespace).Get(service.Name)
 	if err != nil {
 		if !apierrs.IsNotFound(err) {
 			return
@@ -364,7 +371,8 @@ func (ctrl *ServiceController) syncServiceHandler(service *v1.Service) {
 		if err = filterService(serviceInSub); err != nil {
 			return
 		}
-		serviceInSub, err = ctrl.client.CoreV1().Services(service.Namespace).Create(context.TODO(),
+		serviceInSub.Namespace = lowerNamespace
+		serviceInSub, err = ctrl.client.CoreV1().Services(lowerNamespace).Create(context.TODO(),
 			serviceInSub, metav1.CreateOptions{})
 		if err != nil || serviceInSub == nil {
 			err = fmt.Errorf("Create service %v in client cluster failed, error: %v", key, err)
@@ -379,6 +387,7 @@ func (ctrl *ServiceController) syncServiceHandler(service *v1.Service) {
 	if err = filterService(serviceCopy); err != nil {
 		return
 	}
+	serviceCopy.Namespace = lowerNamespace
 	serviceCopy.ResourceVersion = serviceInSub.ResourceVersion
 	serviceCopy.Spec.ClusterIP = serviceInSub.Spec.ClusterIP
 	klog.V(5).Infof("Old service %+v\n, new %+v", serviceInSub, serviceCopy)
@@ -401,15 +410,17 @@ func (ctrl *ServiceController) syncEndpointsHandler(endpoints *v1.Endpoints) {
 		}
 	}()
 
+	lowerNamespace := ctrl.namespaces.ToLower(endpoints.Namespace)
 	var endpointsInSub *v1.Endpoints
-	endpointsInSub, err = ctrl.clientEndpointsLister.Endpoints(endpoints.Namespace).Get(endpoints.Name)
+	endpointsInSub, err = ctrl.clientEndpointsLister.Endpoints(lowerNamespace).Get(endpoints.Name)
 	if err != nil {
 		if !apierrs.IsNotFound(err) {
 			return
 		}
 		endpointsInSub = endpoints.DeepCopy()
 		filterCommon(&endpointsInSub.ObjectMeta)
-		endpointsInSub, err = ctrl.client.CoreV1().Endpoints(endpoints.Namespace).Create(context.TODO(),
+		endpointsInSub.Namespace = lowerNamespace
+		endpointsInSub, err = ctrl.client.CoreV1().Endpoints(lowerNamespace).Create(context.TODO(),
 			endpointsInSub, metav1.CreateOptions{})
 		if err != nil || endpointsInSub == nil {
 			err = fmt.Errorf("Create endpoints in client cluster failed, error: %v", err)
@@ -422,6 +433,7 @@ func (ctrl *ServiceController) syncEndpointsHandler(endpoints *v1.Endpoints) {
 
 	endpointsCopy := endpoints.DeepCopy()
 	filterCommon(&endpointsCopy.ObjectMeta)
+	endpointsCopy.Namespace = lowerNamespace
 	endpointsCopy.ResourceVersion = endpointsInSub.ResourceVersion
 	klog.V(5).Infof("Old endpoints %+v\n, new %+v", endpointsInSub, endpointsCopy)
 	if _, err = ctrl.patchEndpoints(endpointsInSub, endpointsCopy); err != nil {
@@ -469,6 +481,18 @@ func (ctrl *ServiceController) patchEndpoints(endpoints, clone *v1.Endpoints) (*
 	return newEndpoints, nil
 }
 
+// syncedToClient returns whether the endpoints of a headless service have been synced to client cluster, e.g. the
+// governing services of StatefulSet pods, so that the hostnames of replicas added later resolve there as well
+func (ctrl *ServiceController) syncedToClient(endpoints *v1.Endpoints) bool {
+	service, err := ctrl.serviceLister.Services(endpoints.Namespace).Get(endpoints.Name)
+	if err != nil || service.Spec.ClusterIP != v1.ClusterIPNone {
+		return false
+	}
+	endpointsInSub, err := ctrl.clientEndpointsLister.Endpoints(ctrl.namespaces.ToLower(endpoints.Namespace)).
+		Get(endpoints.Name)
+	return err == nil && IsObjectGlobal(&endpointsInSub.ObjectMeta)
+}
+
 func (ctrl *ServiceController) shouldEnqueue(obj *metav1.ObjectMeta) bool {
 	if obj.Namespace == metav1.NamespaceSystem {
 		return false
@@ -496,7 +520,11 @@ func (ctrl *ServiceController) gc() {
 		if !IsObjectGlobal(&service.ObjectMeta) {
 			continue
 		}
-		_, err = ctrl.serviceLister.Services(service.Namespace).Get(service.Name)
+		namespace, ok := ctrl.namespaces.ToUpper(service.Namespace)
+		if !ok {
+			continue
+		}
+		_, err = ctrl.serviceLister.Services(namespace).Get(service.Name)
 		if err != nil && apierrs.IsNotFound(err) {
 			err := ctrl.client.CoreV1().Services(service.Namespace).Delete(ctx,
 				service.Name, metav1.DeleteOptions{})
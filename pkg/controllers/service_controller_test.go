@@ -325,6 +325,44 @@ func TestServiceController_RunUpdateEndPoints(t *testing.T) {
 	}
 }
 
+func TestServiceController_RunUpdateHeadlessEndpoints(t *testing.T) {
+	ctx := context.TODO()
+	service := newService()
+	service.Annotations = nil
+	service.Spec.ClusterIP = v1.ClusterIPNone
+	endpoints := newEndPoints()
+	endpoints.Annotations = nil
+	client := fake.NewSimpleClientset(newEndPoints())
+	master := fake.NewSimpleClientset(service, endpoints)
+	clientInformer := informers.NewSharedInformerFactory(client, controller.NoResyncPeriodFunc())
+	masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
+	c := NewServiceController(master, client, masterInformer, clientInformer,
+		masterInformer.Core().V1().Namespaces().Lister(), nil).(*ServiceController)
+	stopCh := make(chan struct{})
+	go test(c, 1, stopCh)
+	clientInformer.Start(stopCh)
+	masterInformer.Start(stopCh)
+	clientInformer.WaitForCacheSync(stopCh)
+	masterInformer.WaitForCacheSync(stopCh)
+
+	updated := endpoints.DeepCopy()
+	updated.Subsets = []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "192.168.1.11", Hostname: "web-1"}}}}
+	if _, err := master.CoreV1().Endpoints(updated.Namespace).Update(ctx, updated,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err := wait.Poll(50*time.Millisecond, 10*time.Second, func() (bool, error) {
+		newEP, err := c.clientEndpointsLister.Endpoints(updated.Namespace).Get(updated.Name)
+		if err != nil {
+			return false, nil
+		}
+		return reflect.DeepEqual(newEP.Subsets, updated.Subsets), nil
+	})
+	if err != nil {
+		t.Error("Desire endpoints of headless service synced to client cluster kept up")
+	}
+}
+
 func TestServiceController_RunDeleteService(t *testing.T) {
 	ctx := context.TODO()
 	service := newService()
@@ -433,7 +471,7 @@ func newServiceController() *svcTestBase {
 	masterInformer := informers.NewSharedInformerFactory(master, controller.NoResyncPeriodFunc())
 
 	nsLister := masterInformer.Core().V1().Namespaces().Lister()
-	controller := NewServiceController(master, client, masterInformer, clientInformer, nsLister)
+	controller := NewServiceController(master, client, masterInformer, clientInformer, nsLister, nil)
 	c := controller.(*ServiceController)
 	return &svcTestBase{
 		c:              c,
@@ -26,9 +26,79 @@ import (
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
+// PrepareLowerPVC turns the pvc of master cluster into the one created in client cluster, the binding of
+// master cluster is dropped so that the claim is bound or provisioned by client cluster, the volume is only
+// kept if keepVolume, i.e. it exists in client cluster
+func PrepareLowerPVC(pvc *v1.PersistentVolumeClaim, keepVolume bool) {
+	util.TrimObjectMeta(&pvc.ObjectMeta)
+	SetObjectGlobal(&pvc.ObjectMeta)
+	for _, key := range []string{util.BindCompletedKey, util.BoundByControllerKey, util.StorageProvisionerKey,
+		util.SelectedNodeKey} {
+		delete(pvc.Annotations, key)
+	}
+	if !keepVolume {
+		pvc.Spec.VolumeName = ""
+	}
+	pvc.Status = v1.PersistentVolumeClaimStatus{}
+}
+
+// PrepareLowerService turns the service of master cluster into the one created in client cluster, the selector
+// is moved to an annotation so that the endpoints are synced from master cluster instead of selected in client
+// cluster, which keeps the hostnames of pods in other clusters resolvable by headless services
+func PrepareLowerService(service *v1.Service) error {
+	return filterService(service)
+}
+
+// PrepareLowerEndpoints turns the endpoints of master cluster into the ones created in client cluster
+func PrepareLowerEndpoints(endpoints *v1.Endpoints) {
+	filterCommon(&endpoints.ObjectMeta)
+}
+
+// RecordCreatedConfigMap records the configMap created in client cluster by the detector, so that it is
+// restored once reverted by another writer
+func RecordCreatedConfigMap(conflicts *conflict.Detector, configMap, created *v1.ConfigMap) {
+	key := conflictKey("configmap", configMap.Namespace, configMap.Name)
+	conflicts.Writing(key, configMapReference(configMap), configMapState(configMap), configMapState(configMap))
+	conflicts.Written(key, configMapState(created))
+}
+
+// RecordCreatedSecret records the secret created in client cluster by the detector, so that it is restored
+// once reverted by another writer
+func RecordCreatedSecret(conflicts *conflict.Detector, secret, created *v1.Secret) {
+	key := conflictKey("secret", secret.Namespace, secret.Name)
+	conflicts.Writing(key, secretReference(secret), secretState(secret), secretState(secret))
+	conflicts.Written(key, secretState(created))
+}
+
+// conflictKey returns the key of the object tracked by the conflict detector
+func conflictKey(kind, namespace, name string) string {
+	return "conflict/" + kind + "/" + namespace + "/" + name
+}
+
+// configMapState returns the hash of the fields of configMap synced from master cluster
+func configMapState(configMap *v1.ConfigMap) string {
+	return conflict.Hash(configMap.Labels, configMap.Data, configMap.BinaryData)
+}
+
+// secretState returns the hash of the fields of secret synced from master cluster
+func secretState(secret *v1.Secret) string {
+	return conflict.Hash(secret.Labels, secret.Data, secret.StringData, secret.Type)
+}
+
+func configMapReference(configMap *v1.ConfigMap) v1.ObjectReference {
+	return v1.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Namespace: configMap.Namespace,
+		Name: configMap.Name, UID: configMap.UID}
+}
+
+func secretReference(secret *v1.Secret) v1.ObjectReference {
+	return v1.ObjectReference{Kind: "Secret", APIVersion: "v1", Namespace: secret.Namespace, Name: secret.Name,
+		UID: secret.UID}
+}
+
 func ensureNamespace(ns string, client kubernetes.Interface, nsLister corelisters.NamespaceLister) error {
 	_, err := nsLister.Get(ns)
 	if err == nil {
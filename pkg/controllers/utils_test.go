@@ -19,6 +19,7 @@ package controllers
 import (
 	"testing"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
@@ -155,3 +156,39 @@ func TestSetObjectGlobal(t *testing.T) {
 		})
 	}
 }
+
+func TestPrepareLowerPVC(t *testing.T) {
+	upper := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "data",
+			Namespace:       "default",
+			UID:             "uid",
+			ResourceVersion: "1",
+			Annotations: map[string]string{
+				util.BindCompletedKey:      "yes",
+				util.BoundByControllerKey:  "yes",
+				util.StorageProvisionerKey: "csi.example.com",
+				util.SelectedNodeKey:       "vk",
+				"owner":                    "team-a",
+			},
+		},
+		Spec:   v1.PersistentVolumeClaimSpec{VolumeName: "pv-upper"},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	pvc := upper.DeepCopy()
+	PrepareLowerPVC(pvc, false)
+	if len(pvc.UID) != 0 || len(pvc.ResourceVersion) != 0 || !IsObjectGlobal(&pvc.ObjectMeta) {
+		t.Fatalf("desire meta trimmed and global, real %+v", pvc.ObjectMeta)
+	}
+	if len(pvc.Annotations) != 2 || pvc.Annotations["owner"] != "team-a" {
+		t.Fatalf("desire binding annotations dropped, real %v", pvc.Annotations)
+	}
+	if len(pvc.Spec.VolumeName) != 0 || len(pvc.Status.Phase) != 0 {
+		t.Fatalf("desire binding dropped, real volume %v phase %v", pvc.Spec.VolumeName, pvc.Status.Phase)
+	}
+	pvc = upper.DeepCopy()
+	PrepareLowerPVC(pvc, true)
+	if pvc.Spec.VolumeName != "pv-upper" {
+		t.Fatalf("desire volume existing in client cluster kept, real %v", pvc.Spec.VolumeName)
+	}
+}
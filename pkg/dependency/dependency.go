@@ -0,0 +1,278 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dependency mirrors the custom resource objects pods depend on, e.g. Istio Sidecars or cert-manager
+// Certificates, into lower clusters before the pods are created there
+package dependency
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// Dependency is an object of a custom resource a pod depends on, in the namespace of the pod
+type Dependency struct {
+	Resource schema.GroupVersionResource
+	Name     string
+}
+
+func (d Dependency) String() string {
+	return fmt.Sprintf("%v/%v", resourceString(d.Resource), d.Name)
+}
+
+// Resolver resolves the dependencies of pods, e.g. from their annotations or specs. Resolvers are plugged in by
+// ClientConfig of the provider, AnnotationResolver is always used
+type Resolver interface {
+	// Resolve returns the dependencies of the pod, which should not be modified
+	Resolve(pod *corev1.Pod) ([]Dependency, error)
+}
+
+// ResolverFunc adapts a function to a Resolver
+type ResolverFunc func(pod *corev1.Pod) ([]Dependency, error)
+
+// Resolve calls f(pod)
+func (f ResolverFunc) Resolve(pod *corev1.Pod) ([]Dependency, error) {
+	return f(pod)
+}
+
+// AnnotationResolver resolves the dependencies listed in annotation util.CustomDependencies of pods
+var AnnotationResolver = ResolverFunc(func(pod *corev1.Pod) ([]Dependency, error) {
+	value := pod.Annotations[util.CustomDependencies]
+	if len(value) == 0 {
+		return nil, nil
+	}
+	var dependencies []Dependency
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		parts := strings.SplitN(item, "/", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid dependency %q, should be <resource>.<version>.<group>/<name>", item)
+		}
+		resource, err := parseResource(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		dependencies = append(dependencies, Dependency{Resource: resource, Name: parts[1]})
+	}
+	return dependencies, nil
+})
+
+// Rule declares a custom resource whose objects could be mirrored, and the field paths of the objects mirrored
+type Rule struct {
+	Resource   schema.GroupVersionResource
+	FieldPaths [][]string
+}
+
+// ParseRule parses a rule in <resource>.<version>.<group>[=<field path>;<field path>...], field paths are
+// separated by dot, e.g. certificates.v1.cert-manager.io=spec, only spec is mirrored if no field path declared.
+// Core resources are not accepted, they are synced by the controllers
+func ParseRule(rule string) (Rule, error) {
+	parts := strings.SplitN(rule, "=", 2)
+	resource, err := parseResource(parts[0])
+	if err != nil {
+		return Rule{}, err
+	}
+	r := Rule{Resource: resource}
+	if len(parts) == 1 {
+		r.FieldPaths = [][]string{{"spec"}}
+		return r, nil
+	}
+	for _, path := range strings.Split(parts[1], ";") {
+		fields := strings.Split(strings.TrimSpace(path), ".")
+		for _, field := range fields {
+			if len(field) == 0 {
+				return Rule{}, fmt.Errorf("invalid field path %q of rule %q", path, rule)
+			}
+		}
+		if fields[0] == "metadata" || fields[0] == "status" {
+			return Rule{}, fmt.Errorf("field path %q of rule %q could not be mirrored", path, rule)
+		}
+		r.FieldPaths = append(r.FieldPaths, fields)
+	}
+	return r, nil
+}
+
+func parseResource(s string) (schema.GroupVersionResource, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ".", 3)
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return schema.GroupVersionResource{}, fmt.Errorf("invalid resource %q, should be "+
+			"<resource>.<version>.<group>", s)
+	}
+	return schema.GroupVersionResource{Group: parts[2], Version: parts[1], Resource: parts[0]}, nil
+}
+
+func resourceString(resource schema.GroupVersionResource) string {
+	return strings.Join([]string{resource.Resource, resource.Version, resource.Group}, ".")
+}
+
+// Syncer mirrors the dependencies of pods from the upper cluster into the lower cluster, only the dependencies
+// of resources declared by the rules are mirrored, the others fail the sync
+type Syncer struct {
+	upper     dynamic.Interface
+	lower     dynamic.Interface
+	rules     map[schema.GroupVersionResource]Rule
+	resolvers []Resolver
+	// namespaces translates the namespaces of the dependencies into the lower cluster
+	namespaces *util.NamespaceMapping
+}
+
+// NewSyncer returns a Syncer of the rules, the dependencies are resolved by AnnotationResolver and the resolvers
+func NewSyncer(upper, lower dynamic.Interface, rules []Rule, resolvers ...Resolver) *Syncer {
+	s := &Syncer{
+		upper:     upper,
+		lower:     lower,
+		rules:     make(map[schema.GroupVersionResource]Rule, len(rules)),
+		resolvers: append([]Resolver{AnnotationResolver}, resolvers...),
+	}
+	for _, rule := range rules {
+		s.rules[rule.Resource] = rule
+	}
+	return s
+}
+
+// WithNamespaces mirrors the dependencies into the namespaces of the lower cluster translated by m, it returns s
+func (s *Syncer) WithNamespaces(m *util.NamespaceMapping) *Syncer {
+	s.namespaces = m
+	return s
+}
+
+// Sync mirrors the dependencies of the pod, the objects created in the lower cluster are updated once the
+// mirrored fields differ, the ones not created by the syncer are left alone. It does nothing if s is nil
+func (s *Syncer) Sync(ctx context.Context, pod *corev1.Pod) error {
+	if s == nil {
+		return nil
+	}
+	for _, resolver := range s.resolvers {
+		dependencies, err := resolver.Resolve(pod)
+		if err != nil {
+			return fmt.Errorf("could not resolve dependencies of pod %v/%v: %v", pod.Namespace, pod.Name, err)
+		}
+		for _, dependency := range dependencies {
+			if err = s.sync(ctx, pod.Namespace, dependency); err != nil {
+				return fmt.Errorf("could not sync dependency %v of pod %v/%v: %v", dependency, pod.Namespace,
+					pod.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Syncer) sync(ctx context.Context, namespace string, dependency Dependency) error {
+	rule, ok := s.rules[dependency.Resource]
+	if !ok {
+		return fmt.Errorf("resource %v is not allowed to be mirrored", resourceString(dependency.Resource))
+	}
+	upper, err := s.upper.Resource(rule.Resource).Namespace(namespace).Get(ctx, dependency.Name,
+		metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	desired, err := mirror(upper, rule.FieldPaths)
+	if err != nil {
+		return err
+	}
+	namespace = s.namespaces.ToLower(namespace)
+	desired.SetNamespace(namespace)
+	client := s.lower.Resource(rule.Resource).Namespace(namespace)
+	current, err := client.Get(ctx, dependency.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if _, err = client.Create(ctx, desired, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		klog.Infof("Create %v in %v success", dependency, namespace)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.GetAnnotations()[util.GlobalLabel] != "true" {
+		klog.Warningf("Dependency %v in %v is not created by tensile-kube, skip updating it", dependency, namespace)
+		return nil
+	}
+	updated := current.DeepCopy()
+	changed := false
+	for _, path := range rule.FieldPaths {
+		value, found, _ := unstructured.NestedFieldNoCopy(desired.Object, path...)
+		old, oldFound, _ := unstructured.NestedFieldNoCopy(current.Object, path...)
+		if found == oldFound && equality.Semantic.DeepEqual(value, old) {
+			continue
+		}
+		changed = true
+		if !found {
+			unstructured.RemoveNestedField(updated.Object, path...)
+			continue
+		}
+		if err = unstructured.SetNestedField(updated.Object, value, path...); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, err = client.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("Update %v in %v success", dependency, namespace)
+	return nil
+}
+
+// mirror returns the object to create in the lower cluster, with the labels, annotations and the fields of the
+// paths of the upper object, annotated as global so that it is known created by tensile-kube
+func mirror(upper *unstructured.Unstructured, fieldPaths [][]string) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion(upper.GetAPIVersion())
+	obj.SetKind(upper.GetKind())
+	obj.SetName(upper.GetName())
+	obj.SetNamespace(upper.GetNamespace())
+	obj.SetLabels(upper.GetLabels())
+	annotations := map[string]string{}
+	for k, v := range upper.GetAnnotations() {
+		if k == corev1.LastAppliedConfigAnnotation {
+			continue
+		}
+		annotations[k] = v
+	}
+	annotations[util.GlobalLabel] = "true"
+	obj.SetAnnotations(annotations)
+	for _, path := range fieldPaths {
+		value, found, err := unstructured.NestedFieldNoCopy(upper.Object, path...)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if err = unstructured.SetNestedField(obj.Object, value, path...); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dependency
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+var sidecars = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "sidecars"}
+
+func newSidecar(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.istio.io/v1beta1",
+		"kind":       "Sidecar",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+		},
+		"spec":   spec,
+		"status": map[string]interface{}{"observed": "upper"},
+	}}
+}
+
+func TestParseRule(t *testing.T) {
+	cases := []struct {
+		rule    string
+		desired Rule
+		invalid bool
+	}{
+		{
+			rule:    "sidecars.v1beta1.networking.istio.io",
+			desired: Rule{Resource: sidecars, FieldPaths: [][]string{{"spec"}}},
+		},
+		{
+			rule:    "sidecars.v1beta1.networking.istio.io=spec.egress;data",
+			desired: Rule{Resource: sidecars, FieldPaths: [][]string{{"spec", "egress"}, {"data"}}},
+		},
+		{rule: "sidecars.v1beta1", invalid: true},
+		{rule: "sidecars.v1beta1.networking.istio.io=spec..egress", invalid: true},
+		{rule: "sidecars.v1beta1.networking.istio.io=metadata.labels", invalid: true},
+	}
+	for _, c := range cases {
+		rule, err := ParseRule(c.rule)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("Desire rule %v invalid, get %+v", c.rule, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse rule %v failed: %v", c.rule, err)
+			continue
+		}
+		if !reflect.DeepEqual(rule, c.desired) {
+			t.Errorf("Desire %+v, get %+v", c.desired, rule)
+		}
+	}
+}
+
+func TestAnnotationResolver(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		util.CustomDependencies: "sidecars.v1beta1.networking.istio.io/default, sidecars.v1beta1.networking.istio.io/web",
+	}}}
+	dependencies, err := AnnotationResolver.Resolve(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := []Dependency{{Resource: sidecars, Name: "default"}, {Resource: sidecars, Name: "web"}}
+	if !reflect.DeepEqual(dependencies, desired) {
+		t.Errorf("Desire %+v, get %+v", desired, dependencies)
+	}
+	pod.Annotations[util.CustomDependencies] = "sidecars.v1beta1.networking.istio.io"
+	if _, err = AnnotationResolver.Resolve(pod); err == nil {
+		t.Error("Desire dependency without name invalid")
+	}
+}
+
+func TestSyncerSync(t *testing.T) {
+	ctx := context.Background()
+	upper := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newSidecar("web", map[string]interface{}{"egress": "upper"}),
+		newSidecar("foreign", map[string]interface{}{"egress": "upper"}))
+	lower := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newSidecar("foreign", map[string]interface{}{"egress": "lower"}))
+	syncer := NewSyncer(upper, lower, []Rule{{Resource: sidecars, FieldPaths: [][]string{{"spec"}}}})
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{
+		util.CustomDependencies: "sidecars.v1beta1.networking.istio.io/web,sidecars.v1beta1.networking.istio.io/foreign",
+	}}}
+	if err := syncer.Sync(ctx, pod); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	mirrored, err := lower.Resource(sidecars).Namespace("default").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get mirrored sidecar failed: %v", err)
+	}
+	if egress, _, _ := unstructured.NestedString(mirrored.Object, "spec", "egress"); egress != "upper" {
+		t.Errorf("Desire spec mirrored, get %v", egress)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(mirrored.Object, "status"); found {
+		t.Error("Desire status not mirrored")
+	}
+	if mirrored.GetAnnotations()[util.GlobalLabel] != "true" {
+		t.Error("Desire mirrored sidecar annotated global")
+	}
+	foreign, err := lower.Resource(sidecars).Namespace("default").Get(ctx, "foreign", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get foreign sidecar failed: %v", err)
+	}
+	if egress, _, _ := unstructured.NestedString(foreign.Object, "spec", "egress"); egress != "lower" {
+		t.Errorf("Desire sidecar not created by tensile-kube untouched, get %v", egress)
+	}
+
+	updated := newSidecar("web", map[string]interface{}{"egress": "changed"})
+	if _, err = upper.Resource(sidecars).Namespace("default").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = syncer.Sync(ctx, pod); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	mirrored, err = lower.Resource(sidecars).Namespace("default").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get mirrored sidecar failed: %v", err)
+	}
+	if egress, _, _ := unstructured.NestedString(mirrored.Object, "spec", "egress"); egress != "changed" {
+		t.Errorf("Desire spec updated, get %v", egress)
+	}
+
+	pod.Annotations[util.CustomDependencies] = "certificates.v1.cert-manager.io/web"
+	if err = syncer.Sync(ctx, pod); err == nil {
+		t.Error("Desire resource without rule rejected")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package descheduler
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/descheduler/pkg/api"
+	"sigs.k8s.io/descheduler/pkg/api/v1alpha1"
+	deschedulerscheme "sigs.k8s.io/descheduler/pkg/descheduler/scheme"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+// dynamicPolicy is the policy, the max pods to evict per node and the maintenance windows of the descheduler,
+// which are replaced live by the descheduler config of TensileConfig, the ones of flags are used for those not set
+type dynamicPolicy struct {
+	lock           sync.RWMutex
+	policy         *api.DeschedulerPolicy
+	maxPodsPerNode int
+	windows        *maintenanceWindows
+
+	defaultPolicy         *api.DeschedulerPolicy
+	defaultMaxPodsPerNode int
+	defaultWindows        *maintenanceWindows
+}
+
+func newDynamicPolicy(policy *api.DeschedulerPolicy, maxPodsPerNode int,
+	windows *maintenanceWindows) *dynamicPolicy {
+	return &dynamicPolicy{
+		policy:                policy,
+		maxPodsPerNode:        maxPodsPerNode,
+		windows:               windows,
+		defaultPolicy:         policy,
+		defaultMaxPodsPerNode: maxPodsPerNode,
+		defaultWindows:        windows,
+	}
+}
+
+// get returns the policy and the max pods to evict per node for the next descheduling
+func (p *dynamicPolicy) get() (*api.DeschedulerPolicy, int) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.policy, p.maxPodsPerNode
+}
+
+// inWindows returns whether eviction strategies may run at the time
+func (p *dynamicPolicy) inWindows(t time.Time) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.windows.contains(t)
+}
+
+// apply replaces the policy by the config, the current policy is kept if the strategies or windows are invalid
+func (p *dynamicPolicy) apply(cfg *config.DeschedulerConfig) {
+	policy, maxPodsPerNode, windows := p.defaultPolicy, p.defaultMaxPodsPerNode, p.defaultWindows
+	if cfg != nil && cfg.Strategies != nil {
+		policy = &api.DeschedulerPolicy{}
+		if err := deschedulerscheme.Scheme.Convert(&v1alpha1.DeschedulerPolicy{Strategies: cfg.Strategies},
+			policy, nil); err != nil {
+			klog.Errorf("Skip invalid strategies %+v: %v", cfg.Strategies, err)
+			return
+		}
+	}
+	if cfg != nil && cfg.MaxNoOfPodsToEvictPerNode != nil {
+		maxPodsPerNode = *cfg.MaxNoOfPodsToEvictPerNode
+	}
+	if cfg != nil && cfg.MaintenanceWindows != nil {
+		var err error
+		if windows, err = parseMaintenanceWindows(cfg.MaintenanceWindows, p.defaultWindows.location.String()); err != nil {
+			klog.Errorf("Skip invalid maintenance windows %v: %v", cfg.MaintenanceWindows, err)
+			return
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.policy, p.maxPodsPerNode, p.windows = policy, maxPodsPerNode, windows
+}
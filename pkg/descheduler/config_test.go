@@ -0,0 +1,68 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package descheduler
+
+import (
+	"testing"
+	"time"
+
+	"sigs.k8s.io/descheduler/pkg/api"
+	"sigs.k8s.io/descheduler/pkg/api/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+func TestDynamicPolicy(t *testing.T) {
+	flagPolicy := &api.DeschedulerPolicy{Strategies: api.StrategyList{
+		"PodLifeTime": api.DeschedulerStrategy{Enabled: true},
+	}}
+	flagWindows, _ := parseMaintenanceWindows(nil, "UTC")
+	p := newDynamicPolicy(flagPolicy, 5, flagWindows)
+
+	maxPods := 1
+	p.apply(&config.DeschedulerConfig{
+		Strategies: v1alpha1.StrategyList{
+			"LowNodeUtilization": v1alpha1.DeschedulerStrategy{Enabled: true},
+		},
+		MaxNoOfPodsToEvictPerNode: &maxPods,
+		MaintenanceWindows:        []string{"0 2 * * * 1h"},
+	})
+	policy, maxPodsPerNode := p.get()
+	if policy.Strategies["PodLifeTime"].Enabled || !policy.Strategies["LowNodeUtilization"].Enabled ||
+		maxPodsPerNode != 1 {
+		t.Fatalf("desire only LowNodeUtilization enabled and 1 pod per node, real %+v %v", policy, maxPodsPerNode)
+	}
+	if p.inWindows(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("desire out of maintenance windows at 12:00")
+	}
+
+	// invalid windows keep the current policy
+	p.apply(&config.DeschedulerConfig{MaintenanceWindows: []string{"0 2 * *"}})
+	if policy, _ = p.get(); !policy.Strategies["LowNodeUtilization"].Enabled {
+		t.Fatalf("desire policy kept, real %+v", policy)
+	}
+
+	// deleted config falls back to flags
+	p.apply(nil)
+	policy, maxPodsPerNode = p.get()
+	if policy != flagPolicy || maxPodsPerNode != 5 {
+		t.Fatalf("desire policy of flags and 5 pods per node, real %+v %v", policy, maxPodsPerNode)
+	}
+	if !p.inWindows(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("desire descheduling at any time without maintenance windows")
+	}
+}
@@ -19,26 +19,33 @@ package descheduler
 import (
 	"context"
 	"fmt"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
 	"sigs.k8s.io/descheduler/pkg/api"
 	"sigs.k8s.io/descheduler/pkg/descheduler"
 	"sigs.k8s.io/descheduler/pkg/descheduler/evictions/utils"
 	nodeutil "sigs.k8s.io/descheduler/pkg/descheduler/node"
 
 	"github.com/virtual-kubelet/tensile-kube/cmd/descheduler/app/options"
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
 	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	podutil "github.com/virtual-kubelet/tensile-kube/pkg/descheduler/pod"
 	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/strategies"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util/clustercache"
 )
 
-// Run start a descheduler server
-func Run(rs *options.DeschedulerServer) error {
+// Run start a descheduler server, the strategies of outOfTree are registered besides the ones built in
+func Run(rs *options.DeschedulerServer, outOfTree strategies.Registry) error {
 	ctx := context.Background()
 	rsclient, err := util.NewClient(rs.KubeconfigFile, func(c *rest.Config) {
 		c.QPS = 100
@@ -62,29 +69,75 @@ func Run(rs *options.DeschedulerServer) error {
 		return err
 	}
 
-	stopChannel := make(chan struct{})
-	return RunDeschedulerStrategies(ctx, rs, deschedulerPolicy, evictionPolicyGroupVersion, stopChannel)
-}
+	var metricsClient versioned.Interface
+	if rs.UseMetricsUsage {
+		metricsClient, err = util.NewMetricClient(rs.KubeconfigFile)
+		if err != nil {
+			return err
+		}
+	}
 
-type strategyFunction func(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy, nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor)
+	registry := strategies.NewInTreeRegistry()
+	if err = registry.Merge(outOfTree); err != nil {
+		return err
+	}
+	strategyFuncs, err := registry.Build(strategies.Handle{Client: rs.Client, MetricsClient: metricsClient,
+		Rebalance: rs.Rebalance, Pending: rs.Pending})
+	if err != nil {
+		return err
+	}
+
+	return util.RunOrElect(ctx, rs.Client, rs.LeaderElection, func(ctx context.Context) error {
+		stopChannel := make(chan struct{})
+		return RunDeschedulerStrategies(ctx, rs, deschedulerPolicy, evictionPolicyGroupVersion, strategyFuncs,
+			stopChannel)
+	})
+}
 
 // RunDeschedulerStrategies runs the strategies
-func RunDeschedulerStrategies(ctx context.Context, rs *options.DeschedulerServer, deschedulerPolicy *api.DeschedulerPolicy, evictionPolicyGroupVersion string, stopChannel chan struct{}) error {
+func RunDeschedulerStrategies(ctx context.Context, rs *options.DeschedulerServer, deschedulerPolicy *api.DeschedulerPolicy, evictionPolicyGroupVersion string, strategyFuncs map[string]strategies.StrategyFunc, stopChannel chan struct{}) error {
+	windows, err := parseMaintenanceWindows(rs.MaintenanceWindows, rs.MaintenanceTimeZone)
+	if err != nil {
+		return err
+	}
 	sharedInformerFactory := informers.NewSharedInformerFactory(rs.Client, 0)
 	nodeInformer := sharedInformerFactory.Core().V1().Nodes()
 	// just trigger sharedInformerFactory add node informers
 	nodeInformer.Informer()
+	// pods on nodes are listed by the cluster index instead of the apiserver
+	podInformer := sharedInformerFactory.InformerFor(&v1.Pod{}, func(client clientset.Interface,
+		resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewPodInformer(client, v1.NamespaceAll, resync, clustercache.Indexers())
+	})
+	podutil.UsePodIndexer(podInformer.GetIndexer())
 
 	sharedInformerFactory.Start(stopChannel)
 	sharedInformerFactory.WaitForCacheSync(stopChannel)
 
-	strategyFuncs := map[string]strategyFunction{
-		"PodLifeTime": strategies.PodLifeTime,
+	checkStrategies(deschedulerPolicy, strategyFuncs)
+	dynamic := newDynamicPolicy(deschedulerPolicy, rs.MaxNoOfPodsToEvictPerNode, windows)
+	if len(rs.DynamicConfig) != 0 {
+		dynamicClient, err := util.NewDynamicClient(rs.KubeconfigFile)
+		if err != nil {
+			return err
+		}
+		config.Watch(dynamicClient, rs.DynamicConfig, func(spec *config.TensileConfigSpec) {
+			dynamic.apply(spec.Descheduler)
+			policy, _ := dynamic.get()
+			checkStrategies(policy, strategyFuncs)
+		}, stopChannel)
 	}
 
 	unschedulableCache := util.NewUnschedulableCache()
 	count := 0
 	wait.Until(func() {
+		if !dynamic.inWindows(time.Now()) {
+			klog.V(2).Infof("Out of maintenance windows, skip descheduling")
+			if rs.DeschedulingInterval.Seconds() == 0 {
+				close(stopChannel)
+			}
+			return
+		}
 		count++
 		nodes, err := nodeutil.ReadyNodes(ctx, rs.Client, nodeInformer, rs.NodeSelector, stopChannel)
 		if err != nil {
@@ -98,19 +151,20 @@ func RunDeschedulerStrategies(ctx context.Context, rs *options.DeschedulerServer
 			close(stopChannel)
 			return
 		}
+		deschedulerPolicy, maxPodsPerNode := dynamic.get()
 		podEvictor := evictions.NewPodEvictor(
 			rs.Client,
 			evictionPolicyGroupVersion,
-			rs.MaxNoOfPodsToEvictPerNode,
+			maxPodsPerNode,
 			nodes, unschedulableCache,
-		)
+		).WithEvictionAPI(rs.UseEvictionAPI).WithLimits(rs.MaxNoOfPodsToEvictPerNamespace, strategyLimits(rs))
 		if count%10 == 0 {
 			count = count % 10
 			podEvictor.CheckUnschedulablePods = true
 		}
 		for name, f := range strategyFuncs {
 			if strategy := deschedulerPolicy.Strategies[api.StrategyName(name)]; strategy.Enabled {
-				f(ctx, rs.Client, strategy, nodes, rs.EvictLocalStoragePods, podEvictor)
+				f(ctx, rs.Client, strategy, nodes, rs.EvictLocalStoragePods, podEvictor.ForStrategy(name))
 			}
 		}
 
@@ -122,3 +176,28 @@ func RunDeschedulerStrategies(ctx context.Context, rs *options.DeschedulerServer
 
 	return nil
 }
+
+// strategyLimits returns the limits of evictions of each strategy by the flags
+func strategyLimits(rs *options.DeschedulerServer) map[string]evictions.Limits {
+	limits := make(map[string]evictions.Limits)
+	for name, perNode := range rs.StrategyMaxPodsPerNode {
+		limit := limits[name]
+		limit.PerNode = perNode
+		limits[name] = limit
+	}
+	for name, perNamespace := range rs.StrategyMaxPodsPerNamespace {
+		limit := limits[name]
+		limit.PerNamespace = perNamespace
+		limits[name] = limit
+	}
+	return limits
+}
+
+// checkStrategies warns the strategies enabled in the policy but not registered, they are skipped
+func checkStrategies(policy *api.DeschedulerPolicy, strategyFuncs map[string]strategies.StrategyFunc) {
+	for name, strategy := range policy.Strategies {
+		if _, ok := strategyFuncs[string(name)]; strategy.Enabled && !ok {
+			klog.Warningf("Strategy %v is enabled but not registered, skip it", name)
+		}
+	}
+}
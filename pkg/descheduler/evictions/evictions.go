@@ -46,20 +46,44 @@ const (
 // nodePodEvictedCount keeps count of pods evicted on node
 type nodePodEvictedCount map[*v1.Node]int
 
+// Limits bound the pods a strategy evicts per node and per namespace in one descheduling, unlimited if 0
+type Limits struct {
+	PerNode      int
+	PerNamespace int
+}
+
+// evictionCounts keeps count of pods evicted in one descheduling, shared by the evictors of all strategies
+type evictionCounts struct {
+	sync.RWMutex
+	nodepodCount nodePodEvictedCount
+	namespace    map[string]int
+	// strategyNode and strategyNamespace are keyed by the strategy and the node or namespace
+	strategyNode      map[[2]string]int
+	strategyNamespace map[[2]string]int
+}
+
 // PodEvictor is used for evicting pods
 type PodEvictor struct {
 	client             clientset.Interface
 	policyGroupVersion string
 	dryRun             bool
 	maxPodsToEvict     int
-	nodepodCount       nodePodEvictedCount
-	freezeDuration     time.Duration
-	record             record.EventRecorder
-	base               evictions.PodEvictor
-	nodeNum            int
+	// maxPodsPerNamespace bounds the pods evicted per namespace by all strategies, unlimited if 0
+	maxPodsPerNamespace int
+	// strategyLimits bound the pods evicted by each strategy
+	strategyLimits map[string]Limits
+	// strategy is the name of the strategy evicting pods by the evictor, empty for the one shared
+	strategy string
+	// useEvictionAPI evicts pods by the eviction subresource, so the disruption budgets are respected, instead
+	// of deleting them
+	useEvictionAPI bool
+	counts         *evictionCounts
+	freezeDuration time.Duration
+	record         record.EventRecorder
+	base           evictions.PodEvictor
+	nodeNum        int
 	*util.UnschedulableCache
 	CheckUnschedulablePods bool
-	sync.RWMutex
 }
 
 // NewPodEvictor init a new evictor
@@ -91,7 +115,12 @@ func NewPodEvictor(
 		policyGroupVersion: policyGroupVersion,
 		dryRun:             false,
 		maxPodsToEvict:     maxPodsToEvict,
-		nodepodCount:       nodePodCount,
+		counts: &evictionCounts{
+			nodepodCount:      nodePodCount,
+			namespace:         make(map[string]int),
+			strategyNode:      make(map[[2]string]int),
+			strategyNamespace: make(map[[2]string]int),
+		},
 		nodeNum:            virtualCount,
 		freezeDuration:     5 * time.Minute,
 		record:             r,
@@ -99,30 +128,95 @@ func NewPodEvictor(
 	}
 }
 
+// WithEvictionAPI makes the evictor evict pods by the eviction subresource if enabled, the pods protected by their
+// disruption budgets are kept instead of deleted
+func (pe *PodEvictor) WithEvictionAPI(enabled bool) *PodEvictor {
+	pe.useEvictionAPI = enabled
+	return pe
+}
+
+// WithLimits bounds the pods evicted per namespace by all strategies and the pods evicted by each strategy
+func (pe *PodEvictor) WithLimits(perNamespace int, strategies map[string]Limits) *PodEvictor {
+	pe.maxPodsPerNamespace = perNamespace
+	pe.strategyLimits = strategies
+	return pe
+}
+
+// ForStrategy returns the evictor of the strategy sharing the counts of evicted pods, the limits of the strategy
+// apply to the pods it evicts and the events of them are attributed to it
+func (pe *PodEvictor) ForStrategy(name string) *PodEvictor {
+	evictor := *pe
+	evictor.strategy = name
+	return &evictor
+}
+
 // NodeEvicted gives a number of pods evicted for node
 func (pe *PodEvictor) NodeEvicted(node *v1.Node) int {
-	return pe.nodepodCount[node]
+	pe.counts.RLock()
+	defer pe.counts.RUnlock()
+	return pe.counts.nodepodCount[node]
 }
 
 // TotalEvicted gives a number of pods evicted through all nodes
 func (pe *PodEvictor) TotalEvicted() int {
+	pe.counts.RLock()
+	defer pe.counts.RUnlock()
 	var total int
-	for _, count := range pe.nodepodCount {
+	for _, count := range pe.counts.nodepodCount {
 		total += count
 	}
 	return total
 }
 
+// checkLimits returns an error if evicting the pod on the node exceeds any limit
+func (pe *PodEvictor) checkLimits(pod *v1.Pod, node *v1.Node) error {
+	pe.counts.RLock()
+	defer pe.counts.RUnlock()
+	if pe.maxPodsToEvict > 0 && pe.counts.nodepodCount[node]+1 > pe.maxPodsToEvict {
+		return fmt.Errorf("Maximum number %v of evicted pods per %q node reached", pe.maxPodsToEvict, node.Name)
+	}
+	if pe.maxPodsPerNamespace > 0 && pe.counts.namespace[pod.Namespace]+1 > pe.maxPodsPerNamespace {
+		return fmt.Errorf("Maximum number %v of evicted pods per %q namespace reached", pe.maxPodsPerNamespace,
+			pod.Namespace)
+	}
+	limits := pe.strategyLimits[pe.strategy]
+	if limits.PerNode > 0 && pe.counts.strategyNode[[2]string{pe.strategy, node.Name}]+1 > limits.PerNode {
+		return fmt.Errorf("Maximum number %v of pods evicted by %v per %q node reached", limits.PerNode,
+			pe.strategy, node.Name)
+	}
+	if limits.PerNamespace > 0 &&
+		pe.counts.strategyNamespace[[2]string{pe.strategy, pod.Namespace}]+1 > limits.PerNamespace {
+		return fmt.Errorf("Maximum number %v of pods evicted by %v per %q namespace reached", limits.PerNamespace,
+			pe.strategy, pod.Namespace)
+	}
+	return nil
+}
+
+// evicted counts the pod evicted on the node
+func (pe *PodEvictor) evicted(pod *v1.Pod, node *v1.Node) {
+	pe.counts.Lock()
+	defer pe.counts.Unlock()
+	pe.counts.nodepodCount[node]++
+	pe.counts.namespace[pod.Namespace]++
+	pe.counts.strategyNode[[2]string{pe.strategy, node.Name}]++
+	pe.counts.strategyNamespace[[2]string{pe.strategy, pod.Namespace}]++
+}
+
+// strategyName returns the name of the strategy for events
+func (pe *PodEvictor) strategyName() string {
+	if len(pe.strategy) == 0 {
+		return "unknown"
+	}
+	return pe.strategy
+}
+
 // EvictPod returns non-nil error only when evicting a pod on a node is not
-// possible (due to maxPodsToEvict constraint). Success is true when the pod
-// is evicted on the server side.
+// possible (due to the limits or the disruption budgets of the pod). Success is
+// true when the pod is evicted on the server side.
 func (pe *PodEvictor) EvictPod(ctx context.Context, pod *v1.Pod, node *v1.Node) (bool, error) {
-	pe.RLock()
-	if pe.maxPodsToEvict > 0 && pe.nodepodCount[node]+1 > pe.maxPodsToEvict {
-		pe.RUnlock()
-		return false, fmt.Errorf("Maximum number %v of evicted pods per %q node reached", pe.maxPodsToEvict, node.Name)
+	if err := pe.checkLimits(pod, node); err != nil {
+		return false, err
 	}
-	pe.RUnlock()
 
 	nodeName := pod.Spec.NodeName
 	podCopy := pod.DeepCopy()
@@ -133,6 +227,8 @@ func (pe *PodEvictor) EvictPod(ctx context.Context, pod *v1.Pod, node *v1.Node)
 		podCopy.Labels = map[string]string{}
 	}
 	podCopy.Labels[util.CreatedbyDescheduler] = "true"
+	// the re-created pod should not be evicted again for the reclamation of the old node
+	delete(podCopy.Annotations, util.Reclaiming)
 	podCopy.Status = v1.PodStatus{}
 
 	ownerID := pod.Name
@@ -166,12 +262,22 @@ func (pe *PodEvictor) EvictPod(ctx context.Context, pod *v1.Pod, node *v1.Node)
 		return false, err
 	}
 
-	err = pe.client.CoreV1().Pods(podCopy.Namespace).Delete(ctx, podCopy.Name, *deleteOptions)
-	if err != nil && !apierrors.IsNotFound(err) {
-		// err is used only for logging purposes
-		klog.Errorf("Error evicting pod: %#v in namespace %#v (%#v)", pod.Name, pod.Namespace, err)
-		return false, err
-
+	if pe.useEvictionAPI {
+		err = evictPod(ctx, pe.client, pod, pe.policyGroupVersion, pe.dryRun)
+		if err != nil {
+			// e.g. refused by the disruption budgets of the pod, it is kept and not re-created
+			klog.Errorf("Error evicting pod: %#v in namespace %#v (%#v)", pod.Name, pod.Namespace, err)
+			pe.record.Eventf(pod, v1.EventTypeWarning, "EvictionFailed", "strategy %v of descheduler could not "+
+				"evict the pod: %v", pe.strategyName(), err)
+			return false, err
+		}
+	} else {
+		err = pe.client.CoreV1().Pods(podCopy.Namespace).Delete(ctx, podCopy.Name, *deleteOptions)
+		if err != nil && !apierrors.IsNotFound(err) {
+			// err is used only for logging purposes
+			klog.Errorf("Error evicting pod: %#v in namespace %#v (%#v)", pod.Name, pod.Namespace, err)
+			return false, err
+		}
 	}
 	addDescheduleCount(podCopy)
 
@@ -183,11 +289,10 @@ func (pe *PodEvictor) EvictPod(ctx context.Context, pod *v1.Pod, node *v1.Node)
 		klog.Errorf("Error re-create pod: %#v in namespace %#v (%#v)", pod.Name, pod.Namespace, err)
 		return false, nil
 	}
-	pe.record.Event(pod, v1.EventTypeNormal, "Rescheduled", "pod re-create by sigs.k8s.io/descheduler")
+	pe.record.Eventf(pod, v1.EventTypeNormal, "Rescheduled", "pod re-create by strategy %v of "+
+		"sigs.k8s.io/descheduler", pe.strategyName())
 	klog.Infof("Re-create pod: %#v in namespace %#v success", pod.Name, pod.Namespace)
-	pe.Lock()
-	pe.nodepodCount[node]++
-	pe.Unlock()
+	pe.evicted(pod, node)
 	if pe.dryRun {
 		klog.V(1).Infof("Evicted pod in dry run mode: %#v in namespace %#v", pod.Name, pod.Namespace)
 	} else {
@@ -18,12 +18,17 @@ package evictions
 
 import (
 	"context"
+	"testing"
+
 	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"sigs.k8s.io/descheduler/test"
-	"testing"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
 func TestEvictPod(t *testing.T) {
@@ -64,3 +69,71 @@ func TestEvictPod(t *testing.T) {
 		}
 	}
 }
+
+func TestEvictPodLimits(t *testing.T) {
+	ctx := context.Background()
+	node := test.BuildTestNode("node1", 1000, 2000, 9, nil)
+	var pods []runtime.Object
+	for _, name := range []string{"p1", "p2", "p3"} {
+		pods = append(pods, test.BuildTestPod(name, 100, 0, "node1", nil))
+	}
+	client := fake.NewSimpleClientset(pods...)
+	evictor := NewPodEvictor(client, "v1beta1", 0, []*v1.Node{node}, util.NewUnschedulableCache()).
+		WithLimits(2, map[string]Limits{"A": {PerNamespace: 1}})
+	a, b := evictor.ForStrategy("A"), evictor.ForStrategy("B")
+	steps := []struct {
+		evictor *PodEvictor
+		pod     string
+		success bool
+	}{
+		{evictor: a, pod: "p1", success: true},
+		// the limit of strategy A is reached
+		{evictor: a, pod: "p2"},
+		{evictor: b, pod: "p2", success: true},
+		// the limit of the namespace is reached by both strategies
+		{evictor: b, pod: "p3"},
+	}
+	for i, step := range steps {
+		success, err := step.evictor.EvictPod(ctx, test.BuildTestPod(step.pod, 100, 0, "node1", nil), node)
+		if success != step.success || (err != nil) == step.success {
+			t.Fatalf("Step %v: desire success %v, get %v, %v", i, step.success, success, err)
+		}
+	}
+	if evictor.TotalEvicted() != 2 || a.NodeEvicted(node) != 2 {
+		t.Fatalf("Desire 2 pods evicted, get %v", evictor.TotalEvicted())
+	}
+}
+
+func TestEvictPodByEvictionAPI(t *testing.T) {
+	ctx := context.Background()
+	node := test.BuildTestNode("node1", 1000, 2000, 9, nil)
+	protected := test.BuildTestPod("protected", 100, 0, "node1", nil)
+	free := test.BuildTestPod("free", 100, 0, "node1", nil)
+	client := fake.NewSimpleClientset(protected, free)
+	client.PrependReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		if action.(core.CreateAction).GetObject().(*policy.Eviction).Name == "protected" {
+			return true, nil, apierrors.NewTooManyRequests("disruption budget", 0)
+		}
+		return true, nil, nil
+	})
+	evictor := NewPodEvictor(client, "v1beta1", 0, []*v1.Node{node}, util.NewUnschedulableCache()).
+		WithEvictionAPI(true).ForStrategy("A")
+
+	if success, err := evictor.EvictPod(ctx, protected, node); success || err == nil {
+		t.Fatalf("Desire the protected pod kept, get %v, %v", success, err)
+	}
+	if success, err := evictor.EvictPod(ctx, free, node); !success || err != nil {
+		t.Fatalf("Desire the free pod evicted, get %v, %v", success, err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Fatalf("Desire pods evicted instead of deleted, get %v", action)
+		}
+	}
+	if evictor.TotalEvicted() != 1 {
+		t.Fatalf("Desire 1 pod evicted, get %v", evictor.TotalEvicted())
+	}
+}
@@ -22,9 +22,22 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	base "sigs.k8s.io/descheduler/pkg/descheduler/pod"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util/clustercache"
 )
 
+// podIndexer caches the pods of the cluster, pods on a node are listed from the apiserver if it is nil
+var podIndexer cache.Indexer
+
+// UsePodIndexer lists pods on a node from the indexer of a pod informer instead of the apiserver, so that
+// each strategy does not list the pods of every node in each descheduling
+func UsePodIndexer(indexer cache.Indexer) {
+	podIndexer = indexer
+}
+
 // IsEvictable checks if a pod is evictable or not.
 func IsEvictable(pod *v1.Pod, evictLocalStoragePods bool) bool {
 	if !base.IsEvictable(pod, evictLocalStoragePods) {
@@ -33,6 +46,10 @@ func IsEvictable(pod *v1.Pod, evictLocalStoragePods bool) bool {
 	if pod.Status.Phase != v1.PodPending {
 		return false
 	}
+	// gated pods are held on purpose, they are not re-created until the gates removed
+	if len(util.GetSchedulingGates(pod)) != 0 {
+		return false
+	}
 	return true
 }
 
@@ -53,9 +70,32 @@ func ListEvictablePodsOnNode(client clientset.Interface, node *v1.Node, evictLoc
 	return evictablePods, nil
 }
 
+// ListEvictableRunningPodsOnNode returns the list of evictable running pods on node.
+func ListEvictableRunningPodsOnNode(client clientset.Interface, node *v1.Node,
+	evictLocalStoragePods bool) ([]*v1.Pod, error) {
+	pods, err := listPodsOnANode(client, node, v1.PodRunning)
+	if err != nil {
+		return []*v1.Pod{}, err
+	}
+	evictablePods := make([]*v1.Pod, 0)
+	for _, pod := range pods {
+		if base.IsEvictable(pod, evictLocalStoragePods) {
+			evictablePods = append(evictablePods, pod)
+		}
+	}
+	return evictablePods, nil
+}
+
 // ListPodsOnANode lists pod on some node
 func ListPodsOnANode(client clientset.Interface, node *v1.Node) ([]*v1.Pod, error) {
-	fieldSelector, err := fields.ParseSelector("spec.nodeName=" + node.Name + ",status.phase=" + string(v1.PodPending))
+	return listPodsOnANode(client, node, v1.PodPending)
+}
+
+func listPodsOnANode(client clientset.Interface, node *v1.Node, phase v1.PodPhase) ([]*v1.Pod, error) {
+	if podIndexer != nil {
+		return listCachedPodsOnANode(podIndexer, node, phase)
+	}
+	fieldSelector, err := fields.ParseSelector("spec.nodeName=" + node.Name + ",status.phase=" + string(phase))
 	if err != nil {
 		return []*v1.Pod{}, err
 	}
@@ -72,3 +112,18 @@ func ListPodsOnANode(client clientset.Interface, node *v1.Node) ([]*v1.Pod, erro
 	}
 	return pods, nil
 }
+
+// listCachedPodsOnANode lists the pods on node in phase from the indexer
+func listCachedPodsOnANode(indexer cache.Indexer, node *v1.Node, phase v1.PodPhase) ([]*v1.Pod, error) {
+	cached, err := clustercache.Pods(indexer, clustercache.ClusterIndex, node.Name)
+	if err != nil {
+		return []*v1.Pod{}, err
+	}
+	pods := make([]*v1.Pod, 0, len(cached))
+	for _, pod := range cached {
+		if pod.Status.Phase == phase {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
@@ -21,8 +21,12 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/descheduler/pkg/utils"
 	"sigs.k8s.io/descheduler/test"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util/clustercache"
 )
 
 func TestIsEvictable(t *testing.T) {
@@ -166,6 +170,14 @@ func TestIsEvictable(t *testing.T) {
 			},
 			evictLocalStoragePods: false,
 			result:                true,
+		}, {
+			pod: test.BuildTestPod("p14", 400, 0, "", nil),
+			runBefore: func(pod *v1.Pod) {
+				pod.ObjectMeta.OwnerReferences = test.GetNormalPodOwnerRefList()
+				pod.Annotations = map[string]string{util.SchedulingGates: "quota"}
+			},
+			evictLocalStoragePods: false,
+			result:                false,
 		},
 	}
 
@@ -179,3 +191,24 @@ func TestIsEvictable(t *testing.T) {
 
 	}
 }
+
+func TestListCachedPodsOnANode(t *testing.T) {
+	n1 := test.BuildTestNode("node1", 1000, 2000, 13, nil)
+	pending := test.BuildTestPod("p1", 100, 0, n1.Name, nil)
+	pending.Status.Phase = v1.PodPending
+	running := test.BuildTestPod("p2", 100, 0, n1.Name, nil)
+	running.Status.Phase = v1.PodRunning
+	other := test.BuildTestPod("p3", 100, 0, "node2", nil)
+	other.Status.Phase = v1.PodPending
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, clustercache.Indexers())
+	for _, pod := range []*v1.Pod{pending, running, other} {
+		indexer.Add(pod)
+	}
+	pods, err := listCachedPodsOnANode(indexer, n1, v1.PodPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != pending.Name {
+		t.Fatalf("desire [%v], real %v", pending.Name, pods)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	podutil "github.com/virtual-kubelet/tensile-kube/pkg/descheduler/pod"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// RebalanceArgs is the args of HotClusterRebalance, the params of descheduler policy have no room for them
+type RebalanceArgs struct {
+	// EvictPercentage is the percentage of pods evicted from a hot virtual node in each descheduling
+	EvictPercentage int
+	// UnschedulableThreshold is the number of pods unschedulable in the lower cluster making its virtual node
+	// hot, 0 means unschedulable pods are not taken into account
+	UnschedulableThreshold int
+	// ExcludedNamespaces are the namespaces whose pods are never evicted
+	ExcludedNamespaces []string
+	// DryRun only logs the pods would be evicted
+	DryRun bool
+}
+
+// pressureConditions are the conditions of virtual node reporting the lower cluster under pressure
+var pressureConditions = []v1.NodeConditionType{v1.NodeMemoryPressure, v1.NodeDiskPressure, v1.NodePIDPressure}
+
+// NewHotClusterRebalance returns the HotClusterRebalance strategy. It evicts args.EvictPercentage percent of
+// pods from the virtual nodes whose lower clusters are running hot, i.e. reporting pressure conditions or
+// having args.UnschedulableThreshold pods unschedulable there, so that the upper scheduler re-places them on
+// other virtual nodes. The pods unschedulable in the lower cluster are evicted first, then running pods of
+// lower priority.
+func NewHotClusterRebalance(args RebalanceArgs) StrategyFunc {
+	excluded := make(map[string]bool, len(args.ExcludedNamespaces))
+	for _, ns := range args.ExcludedNamespaces {
+		excluded[ns] = true
+	}
+	return func(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy,
+		nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor) {
+		if args.EvictPercentage <= 0 {
+			klog.V(1).Infof("Evict percentage of HotClusterRebalance not set")
+			return
+		}
+		for _, node := range nodes {
+			if !util.IsVirtualNode(node) {
+				continue
+			}
+			pods, unschedulable := listRebalancePodsOnNode(client, node, evictLocalStoragePods, excluded)
+			reason := hotReason(node, unschedulable, args.UnschedulableThreshold)
+			if len(reason) == 0 {
+				continue
+			}
+			count := (len(pods)*args.EvictPercentage + 99) / 100
+			klog.V(1).Infof("Node %v is hot for %v, evicting %v of %v pods", node.Name, reason, count, len(pods))
+			for _, pod := range pods[:count] {
+				if args.DryRun {
+					klog.Infof("Would evict pod %v/%v from hot node %v in dry run mode", pod.Namespace, pod.Name,
+						node.Name)
+					continue
+				}
+				success, err := podEvictor.EvictPod(ctx, pod, node)
+				if err != nil {
+					klog.Errorf("Error evicting pod: (%#v)", err)
+					break
+				}
+				if success {
+					klog.V(1).Infof("Evicted pod: %#v because node %v is hot for %v", pod.Name, node.Name, reason)
+				}
+			}
+		}
+	}
+}
+
+// hotReason returns why the lower cluster of the virtual node is hot, empty if it is not
+func hotReason(node *v1.Node, unschedulable, threshold int) string {
+	for _, condition := range node.Status.Conditions {
+		for _, pressure := range pressureConditions {
+			if condition.Type == pressure && condition.Status == v1.ConditionTrue {
+				return string(pressure)
+			}
+		}
+	}
+	if threshold > 0 && unschedulable >= threshold {
+		return "UnschedulablePods"
+	}
+	return ""
+}
+
+// listRebalancePodsOnNode returns the evictable pods on node out of the excluded namespaces in the order to
+// evict, and the number of evictable pods unschedulable in the lower cluster
+func listRebalancePodsOnNode(client clientset.Interface, node *v1.Node, evictLocalStoragePods bool,
+	excluded map[string]bool) ([]*v1.Pod, int) {
+	pendingPods, err := podutil.ListEvictablePodsOnNode(client, node, evictLocalStoragePods)
+	if err != nil {
+		return nil, 0
+	}
+	runningPods, err := podutil.ListEvictableRunningPodsOnNode(client, node, evictLocalStoragePods)
+	if err != nil {
+		return nil, 0
+	}
+	var pods []*v1.Pod
+	unschedulable := 0
+	for _, pod := range append(pendingPods, runningPods...) {
+		// pods of the excluded namespaces still tell the lower cluster is hot
+		if lowerUnschedulable(pod) {
+			unschedulable++
+		}
+		if excluded[pod.Namespace] || pod.DeletionTimestamp != nil {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		ui, uj := lowerUnschedulable(pods[i]), lowerUnschedulable(pods[j])
+		if ui != uj {
+			return ui
+		}
+		return podPriority(pods[i]) < podPriority(pods[j])
+	})
+	return pods, unschedulable
+}
+
+// lowerUnschedulable returns if the pod could not be scheduled in the lower cluster, the status is synced
+// from the lower pod
+func lowerUnschedulable(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodPending {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse &&
+			condition.Reason == v1.PodReasonUnschedulable {
+			return true
+		}
+	}
+	return false
+}
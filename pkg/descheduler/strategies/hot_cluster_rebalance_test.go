@@ -0,0 +1,110 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/descheduler/pkg/api"
+	"sigs.k8s.io/descheduler/test"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	podutil "github.com/virtual-kubelet/tensile-kube/pkg/descheduler/pod"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util/clustercache"
+)
+
+func TestHotClusterRebalance(t *testing.T) {
+	ctx := context.Background()
+	virtualNode := func(name string, pressure bool) *v1.Node {
+		return test.BuildTestNode(name, 1000, 2000, 10, func(node *v1.Node) {
+			node.Labels = map[string]string{util.NodeType: util.VirtualKubeletLabel}
+			if pressure {
+				node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeMemoryPressure,
+					Status: v1.ConditionTrue}}
+			}
+		})
+	}
+	hot, cold, pressured := virtualNode("hot", false), virtualNode("cold", false), virtualNode("pressured", true)
+	newPod := func(name, ns string, node *v1.Node, unschedulable bool) *v1.Pod {
+		return test.BuildTestPod(name, 100, 0, node.Name, func(pod *v1.Pod) {
+			pod.Namespace = ns
+			pod.OwnerReferences = []metav1.OwnerReference{
+				{Kind: "ReplicaSet", APIVersion: "apps/v1", Name: "rs", UID: "rs-uid"},
+			}
+			pod.Status.Phase = v1.PodRunning
+			if unschedulable {
+				pod.Status.Phase = v1.PodPending
+				pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse,
+					Reason: v1.PodReasonUnschedulable}}
+			}
+		})
+	}
+	pods := []*v1.Pod{
+		newPod("hot-pending-1", "default", hot, true),
+		newPod("hot-pending-2", "system", hot, true),
+		newPod("hot-running-1", "default", hot, false),
+		newPod("hot-running-2", "default", hot, false),
+		newPod("cold-pending", "default", cold, true),
+		newPod("pressured-running", "default", pressured, false),
+	}
+	objects := []runtime.Object{hot, cold, pressured}
+	// the fake client ignores the field selector of node name
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, clustercache.Indexers())
+	for _, pod := range pods {
+		objects = append(objects, pod)
+		indexer.Add(pod)
+	}
+	podutil.UsePodIndexer(indexer)
+	defer podutil.UsePodIndexer(nil)
+	nodes := []*v1.Node{hot, cold, pressured}
+	args := RebalanceArgs{EvictPercentage: 50, UnschedulableThreshold: 2, ExcludedNamespaces: []string{"system"}}
+
+	dryRunArgs := args
+	dryRunArgs.DryRun = true
+	client := fake.NewSimpleClientset(objects...)
+	evictor := evictions.NewPodEvictor(client, "v1", 0, nodes, util.NewUnschedulableCache())
+	NewHotClusterRebalance(dryRunArgs)(ctx, client, api.DeschedulerStrategy{}, nodes, false, evictor)
+	for _, pod := range pods {
+		if evicted(ctx, t, client, pod) {
+			t.Fatalf("desire pod %v not evicted in dry run mode", pod.Name)
+		}
+	}
+
+	NewHotClusterRebalance(args)(ctx, client, api.DeschedulerStrategy{}, nodes, false, evictor)
+	expected := map[string]bool{"hot-pending-1": true, "hot-running-1": true, "pressured-running": true}
+	for _, pod := range pods {
+		if evicted(ctx, t, client, pod) != expected[pod.Name] {
+			t.Errorf("desire pod %v evicted %v", pod.Name, expected[pod.Name])
+		}
+	}
+}
+
+// evicted returns if the pod is re-created by descheduler
+func evicted(ctx context.Context, t *testing.T, client *fake.Clientset, pod *v1.Pod) bool {
+	current, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get pod %v failed: %v", pod.Name, err)
+	}
+	return current.Labels[util.CreatedbyDescheduler] == "true"
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	podutil "github.com/virtual-kubelet/tensile-kube/pkg/descheduler/pod"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// nodeUsage is the usage of a virtual node, that is also the usage of the lower cluster
+type nodeUsage struct {
+	node        *v1.Node
+	pods        []*v1.Pod
+	usage       v1.ResourceList
+	allocatable v1.ResourceList
+}
+
+// NewLowNodeUtilization returns the LowNodeUtilization strategy. It evicts pods from the virtual nodes
+// whose utilization is above strategy.Params.NodeResourceUtilizationThresholds.TargetThresholds, so
+// that they can be re-created in the lower clusters below the Thresholds.
+// If metricsClient is not nil, the utilization is computed from the actual usage of the lower clusters
+// published by the virtual nodes, and pods are evicted in the order of their usage reported by
+// metrics-server of the upper cluster, which virtual kubelets serve from the lower clusters.
+// Otherwise both come from requests of pods.
+func NewLowNodeUtilization(metricsClient versioned.Interface) func(ctx context.Context,
+	client clientset.Interface, strategy api.DeschedulerStrategy, nodes []*v1.Node,
+	evictLocalStoragePods bool, podEvictor *evictions.PodEvictor) {
+	return func(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy,
+		nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor) {
+		lowNodeUtilization(ctx, client, metricsClient, strategy, nodes, evictLocalStoragePods, podEvictor)
+	}
+}
+
+func lowNodeUtilization(ctx context.Context, client clientset.Interface, metricsClient versioned.Interface,
+	strategy api.DeschedulerStrategy, nodes []*v1.Node, evictLocalStoragePods bool,
+	podEvictor *evictions.PodEvictor) {
+	if strategy.Params.NodeResourceUtilizationThresholds == nil {
+		klog.V(1).Infof("NodeResourceUtilizationThresholds not set")
+		return
+	}
+	thresholds := strategy.Params.NodeResourceUtilizationThresholds.Thresholds
+	targetThresholds := strategy.Params.NodeResourceUtilizationThresholds.TargetThresholds
+	if len(thresholds) == 0 || len(targetThresholds) == 0 {
+		klog.V(1).Infof("Thresholds or TargetThresholds not set")
+		return
+	}
+
+	var lowNodes, targetNodes []*nodeUsage
+	for _, node := range nodes {
+		if !util.IsVirtualNode(node) {
+			continue
+		}
+		nu, err := getNodeUsage(client, metricsClient, node, evictLocalStoragePods)
+		if err != nil {
+			klog.Errorf("Get usage of node %v failed: %v", node.Name, err)
+			continue
+		}
+		if !node.Spec.Unschedulable && nu.below(thresholds) {
+			lowNodes = append(lowNodes, nu)
+		} else if nu.above(targetThresholds) {
+			targetNodes = append(targetNodes, nu)
+		}
+	}
+	if len(lowNodes) == 0 || len(lowNodes) < strategy.Params.NodeResourceUtilizationThresholds.NumberOfNodes {
+		klog.V(1).Infof("Number of underutilized nodes %v is not enough", len(lowNodes))
+		return
+	}
+	if len(targetNodes) == 0 {
+		klog.V(1).Infof("No node is above target utilization")
+		return
+	}
+
+	// available is the resources could be moved to the underutilized nodes
+	available := v1.ResourceList{}
+	for _, nu := range lowNodes {
+		for name := range targetThresholds {
+			quantity := nu.available(name, targetThresholds[name])
+			if q, ok := available[name]; ok {
+				quantity.Add(q)
+			}
+			available[name] = quantity
+		}
+	}
+
+	for _, nu := range targetNodes {
+		klog.V(1).Infof("Evicting pods from node %#v with usage %v", nu.node.Name, nu.usage)
+		evictPodsFromTargetNode(ctx, metricsClient, nu, targetThresholds, available, podEvictor)
+	}
+}
+
+func evictPodsFromTargetNode(ctx context.Context, metricsClient versioned.Interface, nu *nodeUsage,
+	targetThresholds api.ResourceThresholds, available v1.ResourceList, podEvictor *evictions.PodEvictor) {
+	pods := nu.pods
+	podUsages := make(map[*v1.Pod]v1.ResourceList, len(pods))
+	for _, pod := range pods {
+		podUsages[pod] = getPodUsage(ctx, metricsClient, pod)
+	}
+	// evict pods with lower priority and larger usage first
+	sort.SliceStable(pods, func(i, j int) bool {
+		pi, pj := podPriority(pods[i]), podPriority(pods[j])
+		if pi != pj {
+			return pi < pj
+		}
+		ci, cj := podUsages[pods[i]][v1.ResourceCPU], podUsages[pods[j]][v1.ResourceCPU]
+		return ci.Cmp(cj) > 0
+	})
+
+	for _, pod := range pods {
+		if !nu.above(targetThresholds) || !hasAvailable(available) {
+			return
+		}
+		success, err := podEvictor.EvictPod(ctx, pod, nu.node)
+		if err != nil {
+			klog.Errorf("Error evicting pod: (%#v)", err)
+			return
+		}
+		if !success {
+			continue
+		}
+		klog.V(1).Infof("Evicted pod: %#v because node %v is overutilized", pod.Name, nu.node.Name)
+		for name, quantity := range podUsages[pod] {
+			if q, ok := nu.usage[name]; ok {
+				q.Sub(quantity)
+				nu.usage[name] = q
+			}
+			if q, ok := available[name]; ok {
+				q.Sub(quantity)
+				available[name] = q
+			}
+		}
+		if q, ok := nu.usage[v1.ResourcePods]; ok {
+			q.Sub(*resource.NewQuantity(1, resource.DecimalSI))
+			nu.usage[v1.ResourcePods] = q
+		}
+	}
+}
+
+// getNodeUsage computes usage of the node. If metricsClient is set, usage of cpu and memory comes from the
+// actual usage of the lower cluster published by the virtual node, in proportion to the physical capacity
+// of the cluster, otherwise from the requests of pods running on it
+func getNodeUsage(client clientset.Interface, metricsClient versioned.Interface, node *v1.Node,
+	evictLocalStoragePods bool) (*nodeUsage, error) {
+	pods, err := podutil.ListEvictableRunningPodsOnNode(client, node, evictLocalStoragePods)
+	if err != nil {
+		return nil, err
+	}
+	usage := v1.ResourceList{}
+	allocatable := node.Status.Allocatable.DeepCopy()
+	if metricsClient != nil {
+		capacity, err := getResourceList(node, util.PhysicalCapacity)
+		if err != nil {
+			return nil, err
+		}
+		physicalUsage, err := getResourceList(node, util.PhysicalUsage)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			allocatable[name] = capacity[name]
+			usage[name] = physicalUsage[name]
+		}
+	} else {
+		for _, pod := range pods {
+			addResourceList(usage, podRequests(pod))
+		}
+	}
+	usage[v1.ResourcePods] = *resource.NewQuantity(int64(len(pods)), resource.DecimalSI)
+	return &nodeUsage{
+		node:        node,
+		pods:        pods,
+		usage:       usage,
+		allocatable: allocatable,
+	}, nil
+}
+
+// getResourceList returns the resources published by the virtual node in the annotation
+func getResourceList(node *v1.Node, key string) (v1.ResourceList, error) {
+	data, ok := node.Annotations[key]
+	if !ok {
+		return nil, fmt.Errorf("annotation %v not published", key)
+	}
+	resources := v1.ResourceList{}
+	if err := json.Unmarshal([]byte(data), &resources); err != nil {
+		return nil, fmt.Errorf("invalid annotation %v: %v", key, err)
+	}
+	return resources, nil
+}
+
+// getPodUsage returns usage of the pod from metrics-server, requests would be used if metricsClient
+// not set or metrics of the pod not found
+func getPodUsage(ctx context.Context, metricsClient versioned.Interface, pod *v1.Pod) v1.ResourceList {
+	if metricsClient != nil {
+		metrics, err := metricsClient.MetricsV1beta1().PodMetricses(pod.Namespace).Get(ctx, pod.Name,
+			metav1.GetOptions{})
+		if err == nil {
+			usage := v1.ResourceList{}
+			for _, container := range metrics.Containers {
+				addResourceList(usage, container.Usage)
+			}
+			return usage
+		}
+		klog.V(4).Infof("Get metrics of pod %v failed: %v, use requests instead", pod.Name, err)
+	}
+	return podRequests(pod)
+}
+
+// percentage returns the utilization of the resource in percent
+func (nu *nodeUsage) percentage(name v1.ResourceName) float64 {
+	allocatable, ok := nu.allocatable[name]
+	if !ok || allocatable.IsZero() {
+		return 0
+	}
+	used := nu.usage[name]
+	if name == v1.ResourceCPU {
+		return float64(used.MilliValue()) * 100 / float64(allocatable.MilliValue())
+	}
+	return float64(used.Value()) * 100 / float64(allocatable.Value())
+}
+
+// below checks if all resources of the node are below the thresholds
+func (nu *nodeUsage) below(thresholds api.ResourceThresholds) bool {
+	for name, threshold := range thresholds {
+		if nu.percentage(name) > float64(threshold) {
+			return false
+		}
+	}
+	return true
+}
+
+// above checks if any resource of the node is above the thresholds
+func (nu *nodeUsage) above(thresholds api.ResourceThresholds) bool {
+	for name, threshold := range thresholds {
+		if nu.percentage(name) > float64(threshold) {
+			return true
+		}
+	}
+	return false
+}
+
+// available returns the quantity of resource can be used before the node reaches the threshold
+func (nu *nodeUsage) available(name v1.ResourceName, threshold api.Percentage) resource.Quantity {
+	allocatable := nu.allocatable[name]
+	used := nu.usage[name]
+	var quantity *resource.Quantity
+	if name == v1.ResourceCPU {
+		quantity = resource.NewMilliQuantity(int64(float64(allocatable.MilliValue())*float64(threshold)/100)-
+			used.MilliValue(), resource.DecimalSI)
+	} else {
+		quantity = resource.NewQuantity(int64(float64(allocatable.Value())*float64(threshold)/100)-
+			used.Value(), resource.BinarySI)
+	}
+	if quantity.Sign() < 0 {
+		return resource.Quantity{}
+	}
+	return *quantity
+}
+
+func hasAvailable(available v1.ResourceList) bool {
+	for _, quantity := range available {
+		if quantity.Sign() <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func addResourceList(list, add v1.ResourceList) {
+	for name, quantity := range add {
+		if q, ok := list[name]; ok {
+			q.Add(quantity)
+			list[name] = q
+			continue
+		}
+		list[name] = quantity.DeepCopy()
+	}
+}
+
+func podRequests(pod *v1.Pod) v1.ResourceList {
+	reqs, _ := resourcehelper.PodRequestsAndLimits(pod)
+	return reqs
+}
+
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
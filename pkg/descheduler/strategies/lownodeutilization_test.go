@@ -0,0 +1,123 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestNodeUsage(t *testing.T) {
+	nu := &nodeUsage{
+		usage: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("3"),
+			v1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("4"),
+			v1.ResourceMemory: resource.MustParse("8Gi"),
+		},
+	}
+	cases := []struct {
+		name       string
+		thresholds api.ResourceThresholds
+		below      bool
+		above      bool
+	}{
+		{
+			name:       "cpu above",
+			thresholds: api.ResourceThresholds{v1.ResourceCPU: 50, v1.ResourceMemory: 50},
+			below:      false,
+			above:      true,
+		},
+		{
+			name:       "all below",
+			thresholds: api.ResourceThresholds{v1.ResourceCPU: 80, v1.ResourceMemory: 20},
+			below:      true,
+			above:      false,
+		},
+		{
+			name:       "memory only",
+			thresholds: api.ResourceThresholds{v1.ResourceMemory: 10},
+			below:      false,
+			above:      true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if get := nu.below(c.thresholds); get != c.below {
+				t.Errorf("Desire below %v, get %v", c.below, get)
+			}
+			if get := nu.above(c.thresholds); get != c.above {
+				t.Errorf("Desire above %v, get %v", c.above, get)
+			}
+		})
+	}
+
+	cpu := nu.available(v1.ResourceCPU, 100)
+	if cpu.MilliValue() != 1000 {
+		t.Errorf("Desire available cpu 1000m, get %v", cpu.String())
+	}
+	cpu = nu.available(v1.ResourceCPU, 50)
+	if !cpu.IsZero() {
+		t.Errorf("Desire available cpu 0, get %v", cpu.String())
+	}
+	memory := nu.available(v1.ResourceMemory, 50)
+	if memory.Value() != 3*1024*1024*1024 {
+		t.Errorf("Desire available memory 3Gi, get %v", memory.String())
+	}
+}
+
+func TestGetNodeUsageOfLowerCluster(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "vk", Annotations: map[string]string{
+			util.PhysicalCapacity: `{"cpu":"10","memory":"10Gi"}`,
+			util.PhysicalUsage:    `{"cpu":"9","memory":"1Gi"}`,
+		}},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("20"),
+			v1.ResourceMemory: resource.MustParse("20Gi"),
+			v1.ResourcePods:   resource.MustParse("110"),
+		}},
+	}
+	nu, err := getNodeUsage(fake.NewSimpleClientset(), metricsfake.NewSimpleClientset(), node, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get := nu.percentage(v1.ResourceCPU); get != 90 {
+		t.Errorf("Desire cpu utilization 90 of the lower cluster, get %v", get)
+	}
+	if get := nu.percentage(v1.ResourceMemory); get != 10 {
+		t.Errorf("Desire memory utilization 10 of the lower cluster, get %v", get)
+	}
+	if _, ok := node.Status.Allocatable[v1.ResourceCPU]; !ok || node.Status.Allocatable.Cpu().Value() != 20 {
+		t.Errorf("Desire allocatable of node unchanged, get %v", node.Status.Allocatable)
+	}
+
+	delete(node.Annotations, util.PhysicalUsage)
+	if _, err = getNodeUsage(fake.NewSimpleClientset(), metricsfake.NewSimpleClientset(), node, false); err == nil {
+		t.Error("Desire error if the usage of lower cluster not published")
+	}
+}
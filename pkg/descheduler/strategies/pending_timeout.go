@@ -0,0 +1,99 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	podutil "github.com/virtual-kubelet/tensile-kube/pkg/descheduler/pod"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// PendingArgs is the args of PendingTimeout
+type PendingArgs struct {
+	// Timeout is how long pods could stay Pending on virtual nodes after bound
+	Timeout time.Duration
+}
+
+// NewPendingTimeout returns the PendingTimeout strategy. It evicts the pods bound to virtual nodes but still
+// Pending longer than args.Timeout, i.e. the lower cluster never started them, so that their controllers re-create
+// them and the scheduler tries another node. Pods annotated with util.EvictPending "false" are never evicted.
+func NewPendingTimeout(args PendingArgs) StrategyFunc {
+	return func(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy,
+		nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor) {
+		if args.Timeout <= 0 {
+			klog.V(1).Infof("Timeout of PendingTimeout not set")
+			return
+		}
+		for _, node := range nodes {
+			if !util.IsVirtualNode(node) {
+				continue
+			}
+			klog.V(1).Infof("Processing node: %#v", node.Name)
+			pods := listTimedOutPendingPodsOnNode(client, node, evictLocalStoragePods, args.Timeout, time.Now())
+			f := func(idx int) {
+				success, err := podEvictor.EvictPod(ctx, pods[idx], node)
+				if success {
+					klog.V(1).Infof("Evicted pod: %#v because it is pending on the virtual node longer than %v",
+						pods[idx].Name, args.Timeout)
+				}
+				if err != nil {
+					klog.Errorf("Error evicting pod: (%#v)", err)
+					return
+				}
+			}
+			workqueue.ParallelizeUntil(ctx, 16, len(pods), f)
+		}
+	}
+}
+
+func listTimedOutPendingPodsOnNode(client clientset.Interface, node *v1.Node, evictLocalStoragePods bool,
+	timeout time.Duration, now time.Time) []*v1.Pod {
+	pods, err := podutil.ListEvictablePodsOnNode(client, node, evictLocalStoragePods)
+	if err != nil {
+		return nil
+	}
+	var timedOut []*v1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Annotations[util.EvictPending] == "false" {
+			continue
+		}
+		if now.Sub(boundTime(pod)) > timeout {
+			timedOut = append(timedOut, pod)
+		}
+	}
+	return timedOut
+}
+
+// boundTime returns when the pod is bound to its node, the creation time if not recorded
+func boundTime(pod *v1.Pod) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionTrue &&
+			!condition.LastTransitionTime.IsZero() {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return pod.CreationTimestamp.Time
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/descheduler/pkg/api"
+	"sigs.k8s.io/descheduler/test"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestPendingTimeout(t *testing.T) {
+	ctx := context.Background()
+	node := test.BuildTestNode("vk1", 1000, 2000, 10, func(node *v1.Node) {
+		node.Labels = map[string]string{util.NodeType: util.VirtualKubeletLabel}
+	})
+	newPod := func(name string, phase v1.PodPhase, bound time.Duration, evict string) *v1.Pod {
+		return test.BuildTestPod(name, 100, 0, node.Name, func(pod *v1.Pod) {
+			pod.Namespace = "default"
+			pod.OwnerReferences = []metav1.OwnerReference{
+				{Kind: "ReplicaSet", APIVersion: "apps/v1", Name: "rs", UID: "rs-uid"},
+			}
+			pod.Status.Phase = phase
+			pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-bound))}}
+			if evict != "" {
+				pod.Annotations = map[string]string{util.EvictPending: evict}
+			}
+		})
+	}
+	stuck := newPod("stuck", v1.PodPending, time.Hour, "")
+	fresh := newPod("fresh", v1.PodPending, time.Minute, "")
+	optedOut := newPod("opted-out", v1.PodPending, time.Hour, "false")
+	running := newPod("running", v1.PodRunning, time.Hour, "")
+	client := fake.NewSimpleClientset(node, stuck, fresh, optedOut, running)
+
+	nodes := []*v1.Node{node}
+	evictor := evictions.NewPodEvictor(client, "v1", 0, nodes, util.NewUnschedulableCache())
+	NewPendingTimeout(PendingArgs{Timeout: 10 * time.Minute})(ctx, client, api.DeschedulerStrategy{}, nodes,
+		false, evictor)
+
+	pod, err := client.CoreV1().Pods("default").Get(ctx, stuck.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get pod %v failed: %v", stuck.Name, err)
+	}
+	if pod.Labels[util.CreatedbyDescheduler] != "true" {
+		t.Errorf("pod %v should be re-created by descheduler", stuck.Name)
+	}
+	if !excludesNode(pod, node.Name) {
+		t.Errorf("pod %v should not be scheduled to node %v again, affinity: %+v", stuck.Name, node.Name,
+			pod.Spec.Affinity)
+	}
+	for _, name := range []string{fresh.Name, optedOut.Name, running.Name} {
+		pod, err := client.CoreV1().Pods("default").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get pod %v failed: %v", name, err)
+		}
+		if pod.Labels[util.CreatedbyDescheduler] == "true" || pod.Spec.NodeName != node.Name {
+			t.Errorf("pod %v should not be evicted", name)
+		}
+	}
+}
+
+func TestBoundTime(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-time.Hour))
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created}}
+	if got := boundTime(pod); !got.Equal(created.Time) {
+		t.Errorf("Desire %v, get %v", created.Time, got)
+	}
+	scheduled := metav1.NewTime(time.Now().Add(-time.Minute))
+	pod.Status.Conditions = []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: scheduled},
+	}
+	if got := boundTime(pod); !got.Equal(scheduled.Time) {
+		t.Errorf("Desire %v, get %v", scheduled.Time, got)
+	}
+}
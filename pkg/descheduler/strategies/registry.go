@@ -0,0 +1,104 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+)
+
+// StrategyFunc runs a strategy once in each descheduling, pods should be evicted by podEvictor so that
+// the limits of evictions are respected
+type StrategyFunc func(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy,
+	nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor)
+
+// Handle provides the clients to strategies when they are built
+type Handle struct {
+	Client clientset.Interface
+	// MetricsClient is nil unless --use-metrics-usage is set
+	MetricsClient versioned.Interface
+	// Rebalance is the args of HotClusterRebalance
+	Rebalance RebalanceArgs
+	// Pending is the args of PendingTimeout
+	Pending PendingArgs
+}
+
+// StrategyFactory builds a strategy, it is called once when the descheduler starts
+type StrategyFactory func(handle Handle) (StrategyFunc, error)
+
+// Registry is the factories of strategies by the names enabled in the policy
+type Registry map[string]StrategyFactory
+
+// Register adds a factory of the strategy, an error is returned if the name is registered
+func (r Registry) Register(name string, factory StrategyFactory) error {
+	if _, ok := r[name]; ok {
+		return fmt.Errorf("a strategy named %v already exists", name)
+	}
+	r[name] = factory
+	return nil
+}
+
+// Merge registers the strategies of in, an error is returned if any name is registered
+func (r Registry) Merge(in Registry) error {
+	for name, factory := range in {
+		if err := r.Register(name, factory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build returns the strategies built by the factories
+func (r Registry) Build(handle Handle) (map[string]StrategyFunc, error) {
+	funcs := make(map[string]StrategyFunc, len(r))
+	for name, factory := range r {
+		f, err := factory(handle)
+		if err != nil {
+			return nil, fmt.Errorf("could not build strategy %v: %v", name, err)
+		}
+		funcs[name] = f
+	}
+	return funcs, nil
+}
+
+// NewInTreeRegistry returns the strategies built in the descheduler
+func NewInTreeRegistry() Registry {
+	return Registry{
+		"PodLifeTime": func(Handle) (StrategyFunc, error) {
+			return PodLifeTime, nil
+		},
+		"LowNodeUtilization": func(handle Handle) (StrategyFunc, error) {
+			return NewLowNodeUtilization(handle.MetricsClient), nil
+		},
+		"SpotReclamation": func(Handle) (StrategyFunc, error) {
+			return SpotReclamation, nil
+		},
+		"HotClusterRebalance": func(handle Handle) (StrategyFunc, error) {
+			return NewHotClusterRebalance(handle.Rebalance), nil
+		},
+		"PendingTimeout": func(handle Handle) (StrategyFunc, error) {
+			return NewPendingTimeout(handle.Pending), nil
+		},
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+)
+
+func TestRegistry(t *testing.T) {
+	var built clientset.Interface
+	businessHours := func(handle Handle) (StrategyFunc, error) {
+		built = handle.Client
+		return func(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy,
+			nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor) {
+		}, nil
+	}
+	registry := NewInTreeRegistry()
+	if err := registry.Merge(Registry{"BusinessHours": businessHours}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("PodLifeTime", businessHours); err == nil {
+		t.Fatal("desire error registering a strategy built in again")
+	}
+	client := fake.NewSimpleClientset()
+	funcs, err := registry.Build(Handle{Client: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"PodLifeTime", "LowNodeUtilization", "SpotReclamation", "HotClusterRebalance",
+		"BusinessHours"} {
+		if funcs[name] == nil {
+			t.Fatalf("desire strategy %v built, real %v", name, funcs)
+		}
+	}
+	if built != client {
+		t.Fatal("desire client passed to factory")
+	}
+
+	registry = Registry{"Broken": func(Handle) (StrategyFunc, error) {
+		return nil, fmt.Errorf("missing config")
+	}}
+	if _, err = registry.Build(Handle{}); err == nil {
+		t.Fatal("desire error building broken strategy")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	"sigs.k8s.io/descheduler/pkg/api"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	podutil "github.com/virtual-kubelet/tensile-kube/pkg/descheduler/pod"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// SpotReclamation evicts pods on virtual nodes whose lower nodes are going to be reclaimed, e.g. spot
+// instances receiving interruption notices, the pods are marked by the provider and re-created to run
+// in other clusters before the capacity disappears. EvictPod keeps the re-created pods away from the
+// virtual node by a hostname NotIn node affinity.
+func SpotReclamation(ctx context.Context, client clientset.Interface, strategy api.DeschedulerStrategy,
+	nodes []*v1.Node, evictLocalStoragePods bool, podEvictor *evictions.PodEvictor) {
+	for _, node := range nodes {
+		if !util.IsVirtualNode(node) {
+			continue
+		}
+		klog.V(1).Infof("Processing node: %#v", node.Name)
+		pods := listReclaimingPodsOnNode(client, node, evictLocalStoragePods)
+		f := func(idx int) {
+			pod := pods[idx]
+			taint := pod.Annotations[util.Reclaiming]
+			success, err := podEvictor.EvictPod(ctx, pod, node)
+			if success {
+				klog.V(1).Infof("Evicted pod: %#v because its node in lower cluster is reclaimed by %v",
+					pod.Name, taint)
+			}
+			if err != nil {
+				klog.Errorf("Error evicting pod: (%#v)", err)
+				return
+			}
+		}
+		workqueue.ParallelizeUntil(ctx, 16, len(pods), f)
+	}
+}
+
+func listReclaimingPodsOnNode(client clientset.Interface, node *v1.Node, evictLocalStoragePods bool) []*v1.Pod {
+	pendingPods, err := podutil.ListEvictablePodsOnNode(client, node, evictLocalStoragePods)
+	if err != nil {
+		return nil
+	}
+	runningPods, err := podutil.ListEvictableRunningPodsOnNode(client, node, evictLocalStoragePods)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	var reclaimingPods []*v1.Pod
+	for _, pod := range append(pendingPods, runningPods...) {
+		if _, ok := pod.Annotations[util.Reclaiming]; !ok || pod.DeletionTimestamp != nil {
+			continue
+		}
+		key := pod.Namespace + "/" + pod.Name
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		reclaimingPods = append(reclaimingPods, pod)
+	}
+	return reclaimingPods
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package strategies
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/descheduler/pkg/api"
+	"sigs.k8s.io/descheduler/test"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/descheduler/evictions"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestSpotReclamation(t *testing.T) {
+	ctx := context.Background()
+	node := test.BuildTestNode("vk1", 1000, 2000, 10, func(node *v1.Node) {
+		node.Labels = map[string]string{util.NodeType: util.VirtualKubeletLabel}
+	})
+	newPod := func(name string, phase v1.PodPhase, reclaiming bool) *v1.Pod {
+		return test.BuildTestPod(name, 100, 0, node.Name, func(pod *v1.Pod) {
+			pod.Namespace = "default"
+			pod.OwnerReferences = []metav1.OwnerReference{
+				{Kind: "ReplicaSet", APIVersion: "apps/v1", Name: "rs", UID: "rs-uid"},
+			}
+			pod.Status.Phase = phase
+			if reclaiming {
+				pod.Annotations = map[string]string{util.Reclaiming: util.ReclamationTaint}
+			}
+		})
+	}
+	running := newPod("running", v1.PodRunning, true)
+	pending := newPod("pending", v1.PodPending, true)
+	untouched := newPod("untouched", v1.PodRunning, false)
+	client := fake.NewSimpleClientset(node, running, pending, untouched)
+
+	nodes := []*v1.Node{node}
+	evictor := evictions.NewPodEvictor(client, "v1", 0, nodes, util.NewUnschedulableCache())
+	SpotReclamation(ctx, client, api.DeschedulerStrategy{}, nodes, false, evictor)
+
+	for _, name := range []string{running.Name, pending.Name} {
+		pod, err := client.CoreV1().Pods("default").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get pod %v failed: %v", name, err)
+		}
+		if _, ok := pod.Annotations[util.Reclaiming]; ok {
+			t.Errorf("pod %v should not be marked reclaiming after re-created", name)
+		}
+		if pod.Labels[util.CreatedbyDescheduler] != "true" {
+			t.Errorf("pod %v should be re-created by descheduler", name)
+		}
+		if !excludesNode(pod, node.Name) {
+			t.Errorf("pod %v should not be scheduled to node %v again, affinity: %+v", name, node.Name,
+				pod.Spec.Affinity)
+		}
+	}
+	pod, err := client.CoreV1().Pods("default").Get(ctx, untouched.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get pod %v failed: %v", untouched.Name, err)
+	}
+	if pod.Labels[util.CreatedbyDescheduler] == "true" || pod.Spec.NodeName != node.Name {
+		t.Errorf("pod %v should not be evicted", untouched.Name)
+	}
+}
+
+func excludesNode(pod *v1.Pod, nodeName string) bool {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, req := range term.MatchExpressions {
+			if req.Key != "kubernetes.io/hostname" || req.Operator != v1.NodeSelectorOpNotIn {
+				continue
+			}
+			for _, value := range req.Values {
+				if value == nodeName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
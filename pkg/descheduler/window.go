@@ -0,0 +1,164 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package descheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values matched by a field of a cron expression, bit i is set if value i matches
+type cronField uint64
+
+// cronBounds are the min and max values of the minute, hour, day of month, month and day of week fields
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// maintenanceWindow starts at the minutes matched by a cron expression and lasts for the duration
+type maintenanceWindow struct {
+	fields [5]cronField
+	// whether the day of month and the day of week are restricted, days matching either of them match if both are
+	anyDom, anyDow bool
+	duration       time.Duration
+}
+
+// maintenanceWindows are the windows eviction strategies may run in, evaluated in the location
+type maintenanceWindows struct {
+	windows  []maintenanceWindow
+	location *time.Location
+}
+
+// parseMaintenanceWindows parses the windows in the form of "<minute> <hour> <day of month> <month> <day of week>
+// <duration>", e.g. "0 2 * * 1-5 3h" opens at 2:00 from Monday to Friday for 3 hours, the time zone is local if empty
+func parseMaintenanceWindows(specs []string, timeZone string) (*maintenanceWindows, error) {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", timeZone, err)
+	}
+	windows := &maintenanceWindows{location: location}
+	for _, spec := range specs {
+		window, err := parseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %v", spec, err)
+		}
+		windows.windows = append(windows.windows, window)
+	}
+	return windows, nil
+}
+
+func parseMaintenanceWindow(spec string) (maintenanceWindow, error) {
+	window := maintenanceWindow{}
+	parts := strings.Fields(spec)
+	if len(parts) != 6 {
+		return window, fmt.Errorf("expected 5 cron fields and a duration, got %v fields", len(parts))
+	}
+	for i := range window.fields {
+		field, err := parseCronField(parts[i], cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return window, err
+		}
+		window.fields[i] = field
+	}
+	window.anyDom, window.anyDow = parts[2] == "*", parts[4] == "*"
+	duration, err := time.ParseDuration(parts[5])
+	if err != nil {
+		return window, err
+	}
+	if duration < time.Minute {
+		return window, fmt.Errorf("duration %v is shorter than a minute", duration)
+	}
+	window.duration = duration
+	return window, nil
+}
+
+// parseCronField parses a comma separated list of "*", values or ranges, each optionally followed by "/step"
+func parseCronField(field string, min, max int) (cronField, error) {
+	var result cronField
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			item, step = item[:i], s
+		}
+		low, high := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", item)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", item)
+				}
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q out of range [%v, %v]", item, min, max)
+		}
+		for v := low; v <= high; v += step {
+			result |= 1 << uint(v)
+		}
+	}
+	return result, nil
+}
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// starts returns whether the window opens at the minute
+func (w *maintenanceWindow) starts(t time.Time) bool {
+	if !w.fields[0].has(t.Minute()) || !w.fields[1].has(t.Hour()) || !w.fields[3].has(int(t.Month())) {
+		return false
+	}
+	dom, dow := w.fields[2].has(t.Day()), w.fields[4].has(int(t.Weekday()))
+	if !w.anyDom && !w.anyDow {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// contains returns whether the window is open at the time
+func (w *maintenanceWindow) contains(t time.Time) bool {
+	end := t.Truncate(time.Minute)
+	for start := t.Add(-w.duration).Truncate(time.Minute); !start.After(end); start = start.Add(time.Minute) {
+		if t.Sub(start) < w.duration && w.starts(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether any of the windows is open at the time, always true if no window is set
+func (m *maintenanceWindows) contains(t time.Time) bool {
+	if m == nil || len(m.windows) == 0 {
+		return true
+	}
+	t = t.In(m.location)
+	for i := range m.windows {
+		if m.windows[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
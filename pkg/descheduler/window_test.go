@@ -0,0 +1,68 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package descheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	for _, spec := range []string{"0 2 * * *", "60 2 * * * 1h", "0 2 * * 1-5 1s", "0 5-2 * * * 1h", "0 2 * * */0 1h"} {
+		if _, err := parseMaintenanceWindows([]string{spec}, "UTC"); err == nil {
+			t.Fatalf("desire error of window %q, real nil", spec)
+		}
+	}
+	if _, err := parseMaintenanceWindows(nil, "Nowhere/Nothing"); err == nil {
+		t.Fatal("desire error of unknown time zone, real nil")
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	windows, err := parseMaintenanceWindows([]string{"0 2 * * 1-5 3h", "*/30 12 1,15 * 0 10m"}, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		time     time.Time
+		expected bool
+	}{
+		{name: "opening", time: time.Date(2020, 1, 6, 2, 0, 0, 0, time.UTC), expected: true},
+		{name: "in window", time: time.Date(2020, 1, 6, 4, 59, 59, 0, time.UTC), expected: true},
+		{name: "closed", time: time.Date(2020, 1, 6, 5, 0, 0, 0, time.UTC), expected: false},
+		{name: "before window", time: time.Date(2020, 1, 6, 1, 59, 0, 0, time.UTC), expected: false},
+		{name: "weekend", time: time.Date(2020, 1, 4, 3, 0, 0, 0, time.UTC), expected: false},
+		{name: "from friday", time: time.Date(2020, 1, 10, 4, 0, 0, 0, time.UTC), expected: true},
+		{name: "day of month", time: time.Date(2020, 1, 15, 12, 35, 0, 0, time.UTC), expected: true},
+		{name: "day of week", time: time.Date(2020, 1, 5, 12, 5, 0, 0, time.UTC), expected: true},
+		{name: "neither day", time: time.Date(2020, 1, 7, 12, 5, 0, 0, time.UTC), expected: false},
+		{name: "other time zone", time: time.Date(2020, 1, 6, 10, 0, 0, 0, time.FixedZone("UTC+8", 8*3600)),
+			expected: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if in := windows.contains(c.time); in != c.expected {
+				t.Fatalf("desire %v, real %v", c.expected, in)
+			}
+		})
+	}
+	var empty *maintenanceWindows
+	if !empty.contains(time.Now()) {
+		t.Fatal("desire descheduling at any time without windows")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/controllers"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// collectStaleNodes deletes the virtual nodes hosted by the process before but no longer members, e.g. the
+// member is removed from --members-config, otherwise they are left NotReady forever. Each of them is
+// cordoned and drained, then the status of its cluster recorded on pdbs and hpas, its lease and itself
+// are deleted
+func (m *Manager) collectStaleNodes(ctx context.Context, members []Member) error {
+	if len(m.opts.NodeName) == 0 {
+		return nil
+	}
+	nodes, err := m.master.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{util.ManagedBy: m.opts.NodeName}).String(),
+	})
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(members))
+	for _, member := range members {
+		names[member.NodeName] = true
+	}
+	for _, node := range nodes.Items {
+		if names[node.Name] {
+			continue
+		}
+		klog.Infof("Virtual node %v is no longer a member, collecting it", node.Name)
+		if err = m.collectNode(ctx, &node); err != nil {
+			return fmt.Errorf("could not collect stale virtual node %v: %v", node.Name, err)
+		}
+		klog.Infof("Stale virtual node %v collected", node.Name)
+	}
+	return nil
+}
+
+// collectNode drains and deletes the virtual node, pods on it are deleted at once since no one would
+// terminate them in the lower cluster any more
+func (m *Manager) collectNode(ctx context.Context, node *corev1.Node) error {
+	if !node.Spec.Unschedulable {
+		patch, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"unschedulable": true}})
+		if err != nil {
+			return err
+		}
+		_, err = m.master.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	pods, err := m.master.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != node.Name {
+			continue
+		}
+		err = m.master.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name,
+			metav1.DeleteOptions{GracePeriodSeconds: new(int64)})
+		if err != nil && !apierrs.IsNotFound(err) {
+			return err
+		}
+	}
+	if err = controllers.RemoveClusterStatus(ctx, m.master, node.Name); err != nil {
+		return err
+	}
+	err = m.master.CoordinationV1().Leases(corev1.NamespaceNodeLease).Delete(ctx, node.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	err = m.master.CoreV1().Nodes().Delete(ctx, node.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestCollectStaleNodes(t *testing.T) {
+	node := func(name, managedBy string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{util.ManagedBy: managedBy}}}
+	}
+	pod := func(name, nodeName string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.PodSpec{NodeName: nodeName}}
+	}
+	pdb := &policyv1beta1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: "pdb", Namespace: "default",
+		Annotations: map[string]string{
+			util.PDBStatusPrefix + "vk-1": `{"disruptionsAllowed":2}`,
+			util.PDBStatusPrefix + "vk-2": `{"disruptionsAllowed":0}`,
+			util.DisruptionsAllowed:       "0",
+		}}}
+	master := fake.NewSimpleClientset(node("vk-1", "vk"), node("vk-2", "vk"), node("vk-3", "other"),
+		pod("p1", "vk-1"), pod("p2", "vk-2"), pdb)
+	m := NewManager(master, nil, &opts.Opts{NodeName: "vk"}, nil)
+	ctx := context.Background()
+	if err := m.collectStaleNodes(ctx, []Member{{NodeName: "vk-1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, stale := range map[string]bool{"vk-1": false, "vk-2": true, "vk-3": false} {
+		_, err := master.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if deleted := apierrs.IsNotFound(err); deleted != stale {
+			t.Fatalf("desire node %v deleted %v, real %v", name, stale, deleted)
+		}
+	}
+	for name, stale := range map[string]bool{"p1": false, "p2": true} {
+		_, err := master.CoreV1().Pods("default").Get(ctx, name, metav1.GetOptions{})
+		if deleted := apierrs.IsNotFound(err); deleted != stale {
+			t.Fatalf("desire pod %v deleted %v, real %v", name, stale, deleted)
+		}
+	}
+	pdb, err := master.PolicyV1beta1().PodDisruptionBudgets("default").Get(ctx, "pdb", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pdb.Annotations[util.PDBStatusPrefix+"vk-2"]; ok || pdb.Annotations[util.DisruptionsAllowed] != "2" {
+		t.Fatalf("desire status of vk-2 removed and disruptions allowed 2, real %v", pdb.Annotations)
+	}
+}
@@ -0,0 +1,441 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	vkmanager "github.com/virtual-kubelet/node-cli/manager"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+
+	k8sprovider "github.com/virtual-kubelet/tensile-kube/pkg/provider"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// restartPeriod is the period to restart members failed to start, e.g. the lower cluster is unreachable
+	restartPeriod = 30 * time.Second
+
+	// PhaseStarting means the virtual node of the member is starting
+	PhaseStarting = "Starting"
+	// PhaseRunning means the virtual node of the member is running
+	PhaseRunning = "Running"
+	// PhaseFailed means the virtual node of the member failed, it would be restarted later
+	PhaseFailed = "Failed"
+)
+
+// ProviderFunc builds the provider of a member, the controllers of the member should be started by it as well
+// and stopped once ctx done
+type ProviderFunc func(ctx context.Context, cfg provider.InitConfig, member Member) (*k8sprovider.VirtualK8S, error)
+
+// MemberStatus is the status of a member reported by the management api
+type MemberStatus struct {
+	Member
+	Phase              string      `json:"phase"`
+	Message            string      `json:"message,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// Manager hosts the virtual nodes of many lower clusters in one process, the client and the informers of
+// configMaps, secrets and services of the upper cluster are shared by all of them
+type Manager struct {
+	master      kubernetes.Interface
+	informer    kubeinformers.SharedInformerFactory
+	opts        *opts.Opts
+	newProvider ProviderFunc
+
+	lock    sync.RWMutex
+	members map[string]*MemberStatus
+
+	// running are the members started by node name, changed by Update one at a time, and collect triggers
+	// the collection of stale virtual nodes
+	updateLock sync.Mutex
+	running    map[string]*runningMember
+	collect    chan struct{}
+}
+
+// runningMember is a member running until cancelled, done is closed once it stopped
+type runningMember struct {
+	member Member
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager returns a manager running virtual nodes with the options of the process
+func NewManager(master kubernetes.Interface, informer kubeinformers.SharedInformerFactory, o *opts.Opts,
+	newProvider ProviderFunc) *Manager {
+	return &Manager{
+		master:      master,
+		informer:    informer,
+		opts:        o,
+		newProvider: newProvider,
+		members:     map[string]*MemberStatus{},
+		running:     map[string]*runningMember{},
+		collect:     make(chan struct{}, 1),
+	}
+}
+
+// Run starts the virtual nodes of the members, members failed are restarted until ctx done. Virtual nodes
+// hosted before but no longer members are collected in the background, retried until succeeded
+func (m *Manager) Run(ctx context.Context, members []Member) {
+	go m.runCollector(ctx)
+	m.Update(ctx, members)
+}
+
+// Update changes the members at runtime, the virtual nodes of members removed or changed are stopped and waited
+// for before the changed ones are started again, then the stale virtual nodes are collected
+func (m *Manager) Update(ctx context.Context, members []Member) {
+	m.updateLock.Lock()
+	defer m.updateLock.Unlock()
+	desired := make(map[string]Member, len(members))
+	for _, member := range members {
+		desired[member.NodeName] = member
+	}
+	for name, r := range m.running {
+		if member, ok := desired[name]; ok && member == r.member {
+			continue
+		}
+		klog.Infof("Stopping virtual node %v of member removed or changed", name)
+		r.cancel()
+		<-r.done
+		delete(m.running, name)
+		m.lock.Lock()
+		delete(m.members, name)
+		m.lock.Unlock()
+	}
+	for _, member := range members {
+		if _, ok := m.running[member.NodeName]; ok {
+			continue
+		}
+		m.running[member.NodeName] = m.startMember(ctx, member)
+	}
+	select {
+	case m.collect <- struct{}{}:
+	default:
+	}
+}
+
+// startMember runs the virtual node of the member until cancelled, restarted every restartPeriod once failed
+func (m *Manager) startMember(ctx context.Context, member Member) *runningMember {
+	memberCtx, cancel := context.WithCancel(ctx)
+	r := &runningMember{member: member, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		wait.Until(func() {
+			m.setPhase(member, PhaseStarting, "")
+			if err := m.runMember(memberCtx, member, ctx.Done()); err != nil {
+				klog.Errorf("Virtual node %v failed: %v", member.NodeName, err)
+				m.setPhase(member, PhaseFailed, err.Error())
+			}
+		}, restartPeriod, memberCtx.Done())
+	}()
+	return r
+}
+
+// runningMembers returns the members currently started
+func (m *Manager) runningMembers() []Member {
+	m.updateLock.Lock()
+	defer m.updateLock.Unlock()
+	members := make([]Member, 0, len(m.running))
+	for _, r := range m.running {
+		members = append(members, r.member)
+	}
+	return members
+}
+
+// runCollector collects the stale virtual nodes once the members changed until ctx done, failed collections
+// are retried every restartPeriod against the members at the time
+func (m *Manager) runCollector(ctx context.Context) {
+	for {
+		select {
+		case <-m.collect:
+		case <-ctx.Done():
+			return
+		}
+		wait.PollImmediateUntil(restartPeriod, func() (bool, error) {
+			if err := m.collectStaleNodes(ctx, m.runningMembers()); err != nil {
+				klog.Errorf("Collect stale virtual nodes failed: %v", err)
+				return false, nil
+			}
+			return true, nil
+		}, ctx.Done())
+	}
+}
+
+// WatchMembers reloads the members from the file every period until ctx done and updates them once changed,
+// check validates the members against the process, invalid members are logged and ignored
+func (m *Manager) WatchMembers(ctx context.Context, path string, period time.Duration,
+	check func([]Member) error) {
+	go wait.Until(func() {
+		members, err := LoadMembers(path)
+		if err == nil {
+			err = check(members)
+		}
+		if err != nil {
+			klog.Errorf("Reload members failed: %v", err)
+			return
+		}
+		if !m.changed(members) {
+			return
+		}
+		klog.Infof("Members in %v changed, updating", path)
+		m.Update(ctx, members)
+	}, period, ctx.Done())
+}
+
+// changed tells whether the members differ from the ones running
+func (m *Manager) changed(members []Member) bool {
+	running := m.runningMembers()
+	if len(running) != len(members) {
+		return true
+	}
+	current := make(map[string]Member, len(running))
+	for _, member := range running {
+		current[member.NodeName] = member
+	}
+	for _, member := range members {
+		if current[member.NodeName] != member {
+			return true
+		}
+	}
+	return false
+}
+
+// runMember runs the virtual node of the member until ctx done or anything of it fails, mirroring
+// what node-cli does for the virtual node of the process. The shared informers run until informerStopCh closed
+func (m *Manager) runMember(ctx context.Context, member Member, informerStopCh <-chan struct{}) error {
+	memberCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(m.master, m.opts.InformerResyncPeriod,
+		kubeinformers.WithNamespace(m.opts.KubeNamespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", member.NodeName).String()
+		}))
+	podInformer := podInformerFactory.Core().V1().Pods()
+	secretInformer := m.informer.Core().V1().Secrets()
+	configMapInformer := m.informer.Core().V1().ConfigMaps()
+	serviceInformer := m.informer.Core().V1().Services()
+	rm, err := vkmanager.NewResourceManager(podInformer.Lister(), secretInformer.Lister(),
+		configMapInformer.Lister(), serviceInformer.Lister())
+	if err != nil {
+		return fmt.Errorf("could not create resource manager: %v", err)
+	}
+
+	p, err := m.newProvider(memberCtx, provider.InitConfig{
+		ConfigPath:        m.opts.KubeConfigPath,
+		NodeName:          member.NodeName,
+		OperatingSystem:   m.opts.OperatingSystem,
+		ResourceManager:   rm,
+		DaemonPort:        member.DaemonPort,
+		InternalIP:        os.Getenv("VKUBELET_POD_IP"),
+		KubeClusterDomain: m.opts.KubeClusterDomain,
+	}, member)
+	if err != nil {
+		return fmt.Errorf("could not create provider: %v", err)
+	}
+
+	virtualNode := m.newNode(member)
+	p.ConfigureNode(memberCtx, virtualNode)
+	nodeController, err := node.NewNodeController(p, virtualNode, m.master.CoreV1().Nodes())
+	if err != nil {
+		return fmt.Errorf("could not create node controller: %v", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: m.master.CoreV1().Events(m.opts.KubeNamespace)})
+	defer broadcaster.Shutdown()
+	podController, err := node.NewPodController(node.PodControllerConfig{
+		PodClient:   m.master.CoreV1(),
+		PodInformer: podInformer,
+		EventRecorder: broadcaster.NewRecorder(scheme.Scheme,
+			corev1.EventSource{Component: path.Join(member.NodeName, "pod-controller")}),
+		Provider:          p,
+		SecretInformer:    secretInformer,
+		ConfigMapInformer: configMapInformer,
+		ServiceInformer:   serviceInformer,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create pod controller: %v", err)
+	}
+
+	podInformerFactory.Start(memberCtx.Done())
+	// the shared informers keep running after the member stops
+	m.informer.Start(informerStopCh)
+	if !cache.WaitForCacheSync(memberCtx.Done(), podInformer.Informer().HasSynced, secretInformer.Informer().HasSynced,
+		configMapInformer.Informer().HasSynced, serviceInformer.Informer().HasSynced) {
+		return fmt.Errorf("could not sync caches of upper cluster")
+	}
+
+	errCh := make(chan error, 3)
+	if member.DaemonPort != 0 {
+		server := m.newDaemonServer(p, member.DaemonPort)
+		defer server.Close()
+		go func() {
+			if err := server.ListenAndServeTLS(os.Getenv("APISERVER_CERT_LOCATION"),
+				os.Getenv("APISERVER_KEY_LOCATION")); err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("daemon server exited: %v", err)
+			}
+		}()
+	}
+	go func() {
+		errCh <- fmt.Errorf("pod controller exited: %v", podController.Run(memberCtx, m.opts.PodSyncWorkers))
+	}()
+	select {
+	case <-podController.Ready():
+	case err = <-errCh:
+		return err
+	case <-memberCtx.Done():
+		return nil
+	}
+	go func() {
+		errCh <- fmt.Errorf("node controller exited: %v", nodeController.Run(memberCtx))
+	}()
+	m.setPhase(member, PhaseRunning, "")
+	klog.Infof("Virtual node %v started", member.NodeName)
+
+	select {
+	case err = <-errCh:
+		if memberCtx.Err() == nil {
+			return err
+		}
+	case <-memberCtx.Done():
+	}
+	return nil
+}
+
+// newNode returns the virtual node of the member before configured by the provider
+func (m *Manager) newNode(member Member) *corev1.Node {
+	virtualNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: member.NodeName,
+			Labels: map[string]string{
+				util.NodeType:        util.VirtualKubeletLabel,
+				"kubernetes.io/role": "agent",
+				util.HostNameKey:     member.NodeName,
+				util.BetaHostNameKey: member.NodeName,
+				"alpha.service-controller.kubernetes.io/exclude-balancer": "true",
+				util.ManagedBy: m.opts.NodeName,
+			},
+		},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{
+				OperatingSystem: m.opts.OperatingSystem,
+				Architecture:    "amd64",
+				KubeletVersion:  m.opts.Version,
+			},
+		},
+	}
+	if !m.opts.DisableTaint {
+		virtualNode.Spec.Taints = []corev1.Taint{{
+			Key:    m.opts.TaintKey,
+			Value:  m.opts.Provider,
+			Effect: corev1.TaintEffect(m.opts.TaintEffect),
+		}}
+	}
+	return virtualNode
+}
+
+// newDaemonServer returns the server of logs, exec, attach and port forward of pods on the virtual node
+func (m *Manager) newDaemonServer(p *k8sprovider.VirtualK8S, port int32) *http.Server {
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: k8sprovider.NewDaemonHandler(p, m.opts.StreamIdleTimeout, m.opts.StreamCreationTimeout),
+	}
+}
+
+func (m *Manager) setPhase(member Member, phase, message string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	status, ok := m.members[member.NodeName]
+	if ok && status.Phase == phase && status.Message == message {
+		return
+	}
+	m.members[member.NodeName] = &MemberStatus{
+		Member:             member,
+		Phase:              phase,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+}
+
+// Members returns the status of members sorted by node name
+func (m *Manager) Members() []MemberStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	members := make([]MemberStatus, 0, len(m.members))
+	for _, status := range m.members {
+		members = append(members, *status)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].NodeName < members[j].NodeName
+	})
+	return members
+}
+
+// ServeHTTP serves the management api, GET /members lists the status of all members and
+// GET /members/<node name> returns the status of one member
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	var result interface{}
+	switch name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/members"), "/"); {
+	case r.URL.Path != "/members" && !strings.HasPrefix(r.URL.Path, "/members/"):
+		http.NotFound(w, r)
+		return
+	case len(name) == 0:
+		result = m.Members()
+	default:
+		m.lock.RLock()
+		status, ok := m.members[name]
+		m.lock.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("member %v not found", name), http.StatusNotFound)
+			return
+		}
+		result = status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.Errorf("Write members failed: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	k8sprovider "github.com/virtual-kubelet/tensile-kube/pkg/provider"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestValidateMembers(t *testing.T) {
+	cases := []struct {
+		name    string
+		members []Member
+		valid   bool
+	}{
+		{
+			name: "valid",
+			members: []Member{
+				{NodeName: "vk-1", ClientKubeConfig: "/etc/vk-1", DaemonPort: 10251},
+				{NodeName: "vk-2", ClientKubeConfig: "/etc/vk-2"},
+				{NodeName: "vk-3", ClientKubeConfig: "/etc/vk-3"},
+			},
+			valid: true,
+		},
+		{
+			name:    "invalid node name",
+			members: []Member{{NodeName: "VK_1", ClientKubeConfig: "/etc/vk-1"}},
+		},
+		{
+			name: "duplicated node name",
+			members: []Member{
+				{NodeName: "vk-1", ClientKubeConfig: "/etc/vk-1"},
+				{NodeName: "vk-1", ClientKubeConfig: "/etc/vk-2"},
+			},
+		},
+		{
+			name:    "empty kubeconfig",
+			members: []Member{{NodeName: "vk-1"}},
+		},
+		{
+			name: "duplicated daemon port",
+			members: []Member{
+				{NodeName: "vk-1", ClientKubeConfig: "/etc/vk-1", DaemonPort: 10251},
+				{NodeName: "vk-2", ClientKubeConfig: "/etc/vk-2", DaemonPort: 10251},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateMembers(c.members)
+			if c.valid != (err == nil) {
+				t.Fatalf("desire valid %v, real error %v", c.valid, err)
+			}
+		})
+	}
+}
+
+func TestServeMembers(t *testing.T) {
+	m := NewManager(nil, nil, nil, nil)
+	m.setPhase(Member{NodeName: "vk-2", ClientKubeConfig: "/etc/vk-2"}, PhaseFailed, "unreachable")
+	m.setPhase(Member{NodeName: "vk-1", ClientKubeConfig: "/etc/vk-1"}, PhaseRunning, "")
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/members", nil))
+	var members []MemberStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].NodeName != "vk-1" || members[1].Phase != PhaseFailed {
+		t.Fatalf("desire vk-1 and failed vk-2, real %+v", members)
+	}
+
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/members/vk-2", nil))
+	var member MemberStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &member); err != nil {
+		t.Fatal(err)
+	}
+	if member.NodeName != "vk-2" || member.Message != "unreachable" {
+		t.Fatalf("desire failed vk-2, real %+v", member)
+	}
+
+	for _, path := range []string{"/members/vk-3", "/nodes"} {
+		recorder = httptest.NewRecorder()
+		m.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("desire not found of %v, real %v", path, recorder.Code)
+		}
+	}
+}
+
+func TestUpdateMembers(t *testing.T) {
+	master := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk-2",
+		Labels: map[string]string{util.ManagedBy: "vk"}}})
+	var lock sync.Mutex
+	starts := map[string]int{}
+	m := NewManager(master, kubeinformers.NewSharedInformerFactory(master, 0), &opts.Opts{NodeName: "vk"},
+		func(ctx context.Context, cfg provider.InitConfig, member Member) (*k8sprovider.VirtualK8S, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			starts[member.NodeName]++
+			return nil, fmt.Errorf("unreachable")
+		})
+	started := func(name string) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return starts[name]
+	}
+	waitFor := func(desc string, condition func() bool) {
+		if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return condition(), nil
+		}); err != nil {
+			t.Fatalf("desire %v", desc)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vk1 := Member{NodeName: "vk-1", ClientKubeConfig: "/etc/vk-1"}
+	vk2 := Member{NodeName: "vk-2", ClientKubeConfig: "/etc/vk-2"}
+	m.Run(ctx, []Member{vk1, vk2})
+	waitFor("both members started", func() bool {
+		return started("vk-1") == 1 && started("vk-2") == 1 && len(m.Members()) == 2
+	})
+	if m.changed([]Member{vk1, vk2}) {
+		t.Fatal("desire members unchanged")
+	}
+
+	m.Update(ctx, []Member{vk1})
+	if members := m.Members(); len(members) != 1 || members[0].NodeName != "vk-1" {
+		t.Fatalf("desire only vk-1 left, real %+v", members)
+	}
+	waitFor("virtual node of vk-2 collected", func() bool {
+		_, err := master.CoreV1().Nodes().Get(ctx, "vk-2", metav1.GetOptions{})
+		return apierrs.IsNotFound(err)
+	})
+
+	vk1.ClusterName = "cluster-1"
+	if !m.changed([]Member{vk1}) {
+		t.Fatal("desire members changed")
+	}
+	m.Update(ctx, []Member{vk1})
+	waitFor("changed vk-1 restarted", func() bool {
+		return started("vk-1") == 2
+	})
+	if started("vk-2") != 1 {
+		t.Fatalf("desire vk-2 stopped, real started %v times", started("vk-2"))
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Member is a lower cluster hosted by its own virtual node in the process
+type Member struct {
+	// NodeName is the name of the virtual node in the upper cluster
+	NodeName string `json:"nodeName"`
+	// ClientKubeConfig is the kube config of the lower cluster
+	ClientKubeConfig string `json:"clientKubeConfig"`
+	// ClusterName and ClusterRegion of the lower cluster exposed to pods, the name defaults to the node name
+	ClusterName   string `json:"clusterName,omitempty"`
+	ClusterRegion string `json:"clusterRegion,omitempty"`
+	// NetworkZone of the lower cluster labeled on the virtual node
+	NetworkZone string `json:"networkZone,omitempty"`
+	// DaemonPort serves logs, exec, attach and port forward of pods on the virtual node, disabled if 0
+	DaemonPort int32 `json:"daemonPort,omitempty"`
+}
+
+// LoadMembers reads the members from a json file of a list of members
+func LoadMembers(path string) ([]Member, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var members []Member
+	if err = json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("could not parse members in %v: %v", path, err)
+	}
+	if err = validateMembers(members); err != nil {
+		return nil, fmt.Errorf("invalid members in %v: %v", path, err)
+	}
+	return members, nil
+}
+
+// validateMembers checks node names and daemon ports are unique, ports used by the process itself
+// should be checked by the caller
+func validateMembers(members []Member) error {
+	names := map[string]bool{}
+	ports := map[int32]bool{}
+	for _, member := range members {
+		if errs := validation.IsDNS1123Subdomain(member.NodeName); len(errs) != 0 {
+			return fmt.Errorf("node name %q: %v", member.NodeName, errs)
+		}
+		if names[member.NodeName] {
+			return fmt.Errorf("duplicated node name %v", member.NodeName)
+		}
+		names[member.NodeName] = true
+		if len(member.ClientKubeConfig) == 0 {
+			return fmt.Errorf("client kubeconfig of %v can not be empty", member.NodeName)
+		}
+		if member.DaemonPort == 0 {
+			continue
+		}
+		if ports[member.DaemonPort] {
+			return fmt.Errorf("duplicated daemon port %v of %v", member.DaemonPort, member.NodeName)
+		}
+		ports[member.DaemonPort] = true
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	stderrors "errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	namespace = "tensile_kube"
+
+	// OperationCreate, OperationUpdate and OperationDelete are the operations of pods synced to lower clusters
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+
+	// DecisionScheduled, DecisionUnschedulable and DecisionError are the results of pods evaluated in shadow mode
+	DecisionScheduled     = "scheduled"
+	DecisionUnschedulable = "unschedulable"
+	DecisionError         = "error"
+
+	// CollectDeleted and CollectDryRun are the actions taken on orphans found in lower clusters
+	CollectDeleted = "deleted"
+	CollectDryRun  = "dry_run"
+)
+
+var (
+	// PodSyncDuration is the latency of pods synced to lower clusters by cluster and operation
+	PodSyncDuration = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Namespace:      namespace,
+		Name:           "pod_sync_duration_seconds",
+		Help:           "Latency of pods created, updated and deleted in lower clusters.",
+		Buckets:        metrics.ExponentialBuckets(0.005, 2, 14),
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "operation"})
+	// PodSyncErrors is the number of pods failed to be synced to lower clusters by cluster, operation and reason
+	PodSyncErrors = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "pod_sync_errors_total",
+		Help:           "Number of pods failed to be created, updated and deleted in lower clusters by reason.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "operation", "reason"})
+	// ResourceAggregationDuration is the latency of computing the resource of virtual node from a lower cluster
+	ResourceAggregationDuration = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Namespace:      namespace,
+		Name:           "resource_aggregation_duration_seconds",
+		Help:           "Latency of computing the resource of virtual node from the nodes and pods of lower clusters.",
+		Buckets:        metrics.ExponentialBuckets(0.001, 2, 14),
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster"})
+	// OrphanPodsCleaned is the number of lower pods of upper pods gone deleted by cluster
+	OrphanPodsCleaned = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "orphan_pods_cleaned_total",
+		Help:           "Number of pods in lower clusters deleted since their upper pods are gone.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster"})
+	// OrphansCollected is the number of objects in lower clusters found orphaned by cluster, kind and action
+	OrphansCollected = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "orphans_collected_total",
+		Help:           "Number of objects in lower clusters whose upper objects are gone, deleted or kept in dry run.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "kind", "action"})
+	// PodOperationsInFlight is the number of pod operations in flight in lower clusters by cluster
+	PodOperationsInFlight = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "pod_operations_in_flight",
+		Help:           "Number of pods being created, updated and deleted in lower clusters.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster"})
+	// PodOperationsWaiting is the number of pod operations throttled by cluster
+	PodOperationsWaiting = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "pod_operations_waiting",
+		Help:           "Number of pod operations waiting for the concurrency or rate limit of lower clusters.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster"})
+	// PodOperationWaitDuration is the time pod operations are throttled by cluster and operation
+	PodOperationWaitDuration = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Namespace:      namespace,
+		Name:           "pod_operation_wait_duration_seconds",
+		Help:           "Time pod operations wait for the concurrency or rate limit of lower clusters.",
+		Buckets:        metrics.ExponentialBuckets(0.001, 2, 16),
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "operation"})
+	// ShadowDecisions is the number of pods evaluated by the scheduler in shadow mode by result
+	ShadowDecisions = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "shadow_decisions_total",
+		Help:           "Number of pods evaluated by the scheduler in shadow mode by result.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"result"})
+	// ShadowBindings is the number of pods bound by the default scheduler by whether the node matches the one
+	// decided in shadow mode
+	ShadowBindings = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "shadow_bindings_total",
+		Help:           "Number of pods bound by the default scheduler by whether the node matches the shadow decision.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"match"})
+	// OffloadedPodCPUUsage is the cpu usage of upper pods reported by metrics-server of lower clusters, by the
+	// namespace and name of upper pods, for the custom metrics of HPAs in upper clusters
+	OffloadedPodCPUUsage = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "offloaded_pod_cpu_usage",
+		Help:           "Cpu usage in cores of upper pods running in lower clusters.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "node", "namespace", "pod"})
+	// OffloadedPodMemoryUsage is the memory working set of upper pods reported by metrics-server of lower clusters
+	OffloadedPodMemoryUsage = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "offloaded_pod_memory_usage_bytes",
+		Help:           "Memory working set in bytes of upper pods running in lower clusters.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "node", "namespace", "pod"})
+	// PodMappingFlushErrors is the number of shards of the pod mapping failed to be persisted by cluster and reason
+	PodMappingFlushErrors = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "pod_mapping_flush_errors_total",
+		Help:           "Number of shards of the pod mapping failed to be persisted to the upper cluster by reason.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"cluster", "reason"})
+
+	registerOnce sync.Once
+)
+
+// Register registers the metrics to the legacy registry served by Handler, the metrics are not recorded until
+// registered
+func Register() {
+	registerOnce.Do(func() {
+		legacyregistry.MustRegister(PodSyncDuration, PodSyncErrors, ResourceAggregationDuration, OrphanPodsCleaned,
+			OrphansCollected, PodOperationsInFlight, PodOperationsWaiting, PodOperationWaitDuration, ShadowDecisions,
+			ShadowBindings, OffloadedPodCPUUsage, OffloadedPodMemoryUsage, PodMappingFlushErrors)
+	})
+}
+
+// Handler returns the handler serving the metrics registered
+func Handler() http.Handler {
+	return legacyregistry.Handler()
+}
+
+// ObservePodSync records the latency of the pod operation since start, and the error by its reason if failed
+func ObservePodSync(cluster, operation string, start time.Time, err error) {
+	PodSyncDuration.WithLabelValues(cluster, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		PodSyncErrors.WithLabelValues(cluster, operation, errorReason(err)).Inc()
+	}
+}
+
+// SetOffloadedPodUsage records the usage of the upper pod running in the lower cluster
+func SetOffloadedPodUsage(cluster, node, namespace, pod string, cpu, memory float64) {
+	OffloadedPodCPUUsage.WithLabelValues(cluster, node, namespace, pod).Set(cpu)
+	OffloadedPodMemoryUsage.WithLabelValues(cluster, node, namespace, pod).Set(memory)
+}
+
+// DeleteOffloadedPodUsage drops the usage of the upper pod gone from the lower cluster
+func DeleteOffloadedPodUsage(cluster, node, namespace, pod string) {
+	labels := map[string]string{"cluster": cluster, "node": node, "namespace": namespace, "pod": pod}
+	OffloadedPodCPUUsage.Delete(labels)
+	OffloadedPodMemoryUsage.Delete(labels)
+}
+
+// ObservePodMappingFlushError records a shard of the pod mapping failed to be persisted by the reason of err
+func ObservePodMappingFlushError(cluster string, err error) {
+	PodMappingFlushErrors.WithLabelValues(cluster, errorReason(err)).Inc()
+}
+
+// errorReason returns the reason of the api error wrapped, Unknown if the error does not come from the apiserver
+func errorReason(err error) string {
+	var status errors.APIStatus
+	if stderrors.As(err, &status) && len(status.Status().Reason) != 0 {
+		return string(status.Status().Reason)
+	}
+	return "Unknown"
+}
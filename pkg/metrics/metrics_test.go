@@ -0,0 +1,53 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestObservePodSync(t *testing.T) {
+	Register()
+	notFound := errors.NewNotFound(schema.GroupResource{Resource: "pods"}, "test")
+	ObservePodSync("c1", OperationCreate, time.Now(), fmt.Errorf("could not create pod: %w", notFound))
+	ObservePodSync("c1", OperationUpdate, time.Now(), fmt.Errorf("stale pod"))
+	ObservePodSync("c1", OperationDelete, time.Now(), nil)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`tensile_kube_pod_sync_errors_total{cluster="c1",operation="create",reason="NotFound"} 1`,
+		`tensile_kube_pod_sync_errors_total{cluster="c1",operation="update",reason="Unknown"} 1`,
+		`tensile_kube_pod_sync_duration_seconds_count{cluster="c1",operation="delete"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Desire %v in metrics", line)
+		}
+	}
+	if strings.Contains(body, `operation="delete",reason=`) {
+		t.Error("Desire no error of delete")
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package offload
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// Controller keeps the ClusterOffloadPolicies parsed, so that the webhook and the scheduler tell whether pods
+// are eligible to run on virtual nodes without parsing the policies for every pod
+type Controller struct {
+	informer cache.SharedIndexInformer
+	nsLister listersv1.NamespaceLister
+
+	lock sync.RWMutex
+	// selectors are the policies parsed by name, invalid policies are skipped
+	selectors map[string]*selector
+}
+
+// NewController returns the controller watching ClusterOffloadPolicies by the client, namespaces are got
+// from nsLister to match the namespace selectors
+func NewController(client dynamic.Interface, nsLister listersv1.NamespaceLister) *Controller {
+	c := &Controller{
+		informer:  dynamicinformer.NewDynamicSharedInformerFactory(client, 0).ForResource(Resource).Informer(),
+		nsLister:  nsLister,
+		selectors: map[string]*selector{},
+	}
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.update,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.update(newObj)
+		},
+		DeleteFunc: c.delete,
+	})
+	return c
+}
+
+// Run watches the policies until stopCh closed
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	klog.Info("Starting offload policy controller")
+	c.informer.Run(stopCh)
+	klog.Info("Stopped offload policy controller")
+}
+
+// HasSynced tells whether the policies are synced
+func (c *Controller) HasSynced() bool {
+	return c.informer.HasSynced()
+}
+
+// Eligible tells whether the pod is eligible to run on virtual nodes, an error is returned if the policies
+// are not synced yet
+func (c *Controller) Eligible(pod *corev1.Pod) (bool, error) {
+	if !c.HasSynced() {
+		return false, fmt.Errorf("offload policies not synced")
+	}
+	return c.eligible(pod)
+}
+
+// eligible tells whether the pod is selected by any policy, or there is no policy
+func (c *Controller) eligible(pod *corev1.Pod) (bool, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if len(c.selectors) == 0 {
+		return true, nil
+	}
+	var namespaceLabels labels.Set
+	ns, err := c.nsLister.Get(pod.Namespace)
+	switch {
+	case err == nil:
+		namespaceLabels = ns.Labels
+	case errors.IsNotFound(err):
+		// the namespace not cached yet only fails the namespace selectors
+	default:
+		return false, err
+	}
+	for _, s := range c.selectors {
+		if s.matches(pod, namespaceLabels) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Controller) update(obj interface{}) {
+	policy, err := decode(obj)
+	if err != nil {
+		klog.Errorf("Skip ClusterOffloadPolicy: %v", err)
+		return
+	}
+	s, err := newSelector(&policy.Spec)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err != nil {
+		// an invalid policy selects nothing instead of the pods of its former version
+		klog.Errorf("Skip invalid ClusterOffloadPolicy %v: %v", policy.Name, err)
+		delete(c.selectors, policy.Name)
+		return
+	}
+	klog.V(4).Infof("Apply ClusterOffloadPolicy %v of resource version %v", policy.Name, policy.ResourceVersion)
+	c.selectors[policy.Name] = s
+}
+
+func (c *Controller) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.selectors, u.GetName())
+}
+
+// decode converts the unstructured object to ClusterOffloadPolicy by json
+func decode(obj interface{}) (*ClusterOffloadPolicy, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, err
+	}
+	policy := &ClusterOffloadPolicy{}
+	if err = json.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
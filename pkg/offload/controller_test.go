@@ -0,0 +1,104 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package offload
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEligible(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch",
+		Labels: map[string]string{"offload": "true"}}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	c := &Controller{nsLister: listersv1.NewNamespaceLister(indexer), selectors: map[string]*selector{}}
+	newPod := func(namespace, priorityClass string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: namespace, Labels: labels},
+			Spec: corev1.PodSpec{PriorityClassName: priorityClass}}
+	}
+
+	// all pods are eligible without policies
+	if eligible, err := c.eligible(newPod("default", "", nil)); err != nil || !eligible {
+		t.Fatalf("Desire eligible without policies, get %v, %v", eligible, err)
+	}
+	c.update(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "batch"},
+		"spec": map[string]interface{}{
+			"namespaceSelector":  map[string]interface{}{"matchLabels": map[string]interface{}{"offload": "true"}},
+			"priorityClassNames": []interface{}{"low"},
+		},
+	}})
+	c.update(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web"},
+		"spec": map[string]interface{}{
+			"namespaces":  []interface{}{"default"},
+			"podSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}},
+		},
+	}})
+	cases := []struct {
+		name     string
+		pod      *corev1.Pod
+		eligible bool
+	}{
+		{
+			name:     "selected by namespace labels and priority class",
+			pod:      newPod("batch", "low", nil),
+			eligible: true,
+		},
+		{
+			name: "priority class not selected",
+			pod:  newPod("batch", "high", nil),
+		},
+		{
+			name:     "selected by namespace and pod labels",
+			pod:      newPod("default", "", map[string]string{"app": "web"}),
+			eligible: true,
+		},
+		{
+			name: "pod labels not selected",
+			pod:  newPod("default", "low", map[string]string{"app": "db"}),
+		},
+		{
+			name: "namespace not cached",
+			pod:  newPod("unknown", "low", nil),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			eligible, err := c.eligible(tc.pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if eligible != tc.eligible {
+				t.Fatalf("Desire eligible %v, get %v", tc.eligible, eligible)
+			}
+		})
+	}
+
+	c.delete(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web"},
+	}})
+	if eligible, _ := c.eligible(newPod("default", "", map[string]string{"app": "web"})); eligible {
+		t.Fatal("Desire not eligible once the policy deleted")
+	}
+}
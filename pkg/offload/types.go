@@ -0,0 +1,106 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package offload
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resource is the resource of the cluster scoped ClusterOffloadPolicy, see manifeasts/offload-policy-crd.yaml
+var Resource = schema.GroupVersionResource{Group: "tensile-kube.io", Version: "v1alpha1",
+	Resource: "clusteroffloadpolicies"}
+
+// ClusterOffloadPolicy declares the pods eligible to run on virtual nodes, a pod is eligible if it is selected
+// by any policy, and all pods are eligible if there is no policy
+type ClusterOffloadPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ClusterOffloadPolicySpec `json:"spec"`
+}
+
+// ClusterOffloadPolicySpec selects the pods by all of the fields set, a spec without any field selects all pods
+type ClusterOffloadPolicySpec struct {
+	// Namespaces are the names of namespaces of the pods
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces of the pods by labels
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector selects the pods by labels
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// PriorityClassNames are the names of priority classes of the pods
+	PriorityClassNames []string `json:"priorityClassNames,omitempty"`
+}
+
+// selector is the spec of a policy with the label selectors parsed
+type selector struct {
+	namespaces         map[string]struct{}
+	namespaceSelector  labels.Selector
+	podSelector        labels.Selector
+	priorityClassNames map[string]struct{}
+}
+
+// newSelector parses the label selectors of the spec
+func newSelector(spec *ClusterOffloadPolicySpec) (*selector, error) {
+	s := &selector{namespaces: toSet(spec.Namespaces), priorityClassNames: toSet(spec.PriorityClassNames)}
+	var err error
+	if spec.NamespaceSelector != nil {
+		if s.namespaceSelector, err = metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+			return nil, err
+		}
+	}
+	if spec.PodSelector != nil {
+		if s.podSelector, err = metav1.LabelSelectorAsSelector(spec.PodSelector); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// matches tells whether the pod in the namespace of the labels is selected
+func (s *selector) matches(pod *corev1.Pod, namespaceLabels labels.Set) bool {
+	if s.namespaces != nil {
+		if _, ok := s.namespaces[pod.Namespace]; !ok {
+			return false
+		}
+	}
+	if s.priorityClassNames != nil {
+		if _, ok := s.priorityClassNames[pod.Spec.PriorityClassName]; !ok {
+			return false
+		}
+	}
+	if s.namespaceSelector != nil && !s.namespaceSelector.Matches(namespaceLabels) {
+		return false
+	}
+	if s.podSelector != nil && !s.podSelector.Matches(labels.Set(pod.Labels)) {
+		return false
+	}
+	return true
+}
+
+// toSet returns the set of names, nil if there is no name
+func toSet(names []string) map[string]struct{} {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
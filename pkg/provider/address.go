@@ -0,0 +1,327 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// AddressHostIP publishes the ip of the lower node and the host ports of pods
+	AddressHostIP = "HostIP"
+	// AddressNodePort exposes pods by NodePort services in the lower cluster, and publishes the ip of the lower
+	// node and the node ports
+	AddressNodePort = "NodePort"
+	// AddressLoadBalancer exposes pods by LoadBalancer services in the lower cluster, and publishes the ingress
+	// of the services and the container ports
+	AddressLoadBalancer = "LoadBalancer"
+	// AddressGateway publishes the gateway address of the lower cluster and the container ports
+	AddressGateway = "Gateway"
+
+	// AddressTargetAnnotation publishes the address in annotation util.ReachableAddress of the upper pod
+	AddressTargetAnnotation = "Annotation"
+	// AddressTargetStatus replaces the pod ip and the host ip in the status of the upper pod with the address,
+	// besides the annotation
+	AddressTargetStatus = "Status"
+
+	// loadBalancerWaitTimeout is how long the ingress of LoadBalancer services is waited for
+	loadBalancerWaitTimeout = 10 * time.Minute
+)
+
+// reachableAddress is the address a pod is reachable at from the upper cluster, ports map the container ports
+// in "<port>/<protocol>" to the ports reachable
+type reachableAddress struct {
+	IP    string           `json:"ip"`
+	Ports map[string]int32 `json:"ports,omitempty"`
+	// hostIP is the ip of the lower node the address is translated on, it is translated again once changed
+	hostIP string
+}
+
+// validateAddressTranslation validates the translation and the target of addresses
+func validateAddressTranslation(translation, target, gateway string) error {
+	switch translation {
+	case "", AddressHostIP, AddressNodePort, AddressLoadBalancer:
+	case AddressGateway:
+		if len(gateway) == 0 {
+			return fmt.Errorf("gateway address is required by address translation %v", AddressGateway)
+		}
+	default:
+		return fmt.Errorf("unknown address translation %v", translation)
+	}
+	switch target {
+	case "", AddressTargetAnnotation, AddressTargetStatus:
+	default:
+		return fmt.Errorf("unknown address target %v", target)
+	}
+	return nil
+}
+
+// exposed returns whether the pods are exposed by services in the lower cluster
+func (v *VirtualK8S) exposed() bool {
+	return v.addressTranslation == AddressNodePort || v.addressTranslation == AddressLoadBalancer
+}
+
+// labelAddressOf labels the lower pod with the uid of the upper pod, selected by the service exposing it
+func (v *VirtualK8S) labelAddressOf(lower *corev1.Pod, uid types.UID) {
+	if !v.exposed() || len(uid) == 0 {
+		return
+	}
+	if lower.Labels == nil {
+		lower.Labels = map[string]string{}
+	}
+	lower.Labels[util.AddressOf] = string(uid)
+}
+
+// publishAddress translates the address of the lower pod running and publishes it to the upper pod, the
+// address is only translated again once the pod moves to another node
+func (v *VirtualK8S) publishAddress(ctx context.Context, lower *corev1.Pod) {
+	uid := getUpperUID(lower)
+	if len(v.addressTranslation) == 0 || len(uid) == 0 || len(lower.Status.PodIP) == 0 ||
+		lower.DeletionTimestamp != nil || v.isStale(lower) {
+		return
+	}
+	if cached, ok := v.addresses.Load(uid); ok && cached.(*reachableAddress).hostIP == lower.Status.HostIP {
+		return
+	}
+	address, err := v.translateAddress(ctx, lower)
+	if err != nil {
+		klog.Errorf("Translate address of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return
+	}
+	if address == nil {
+		return
+	}
+	data, err := json.Marshal(address)
+	if err != nil {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{util.ReachableAddress: string(data)},
+		},
+	})
+	if err != nil {
+		return
+	}
+	if _, err = v.upperPods(lower).Patch(ctx, lower.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Publish address of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		}
+		return
+	}
+	v.addresses.Store(uid, address)
+	klog.V(4).Infof("Published address %s of pod %v/%v", data, lower.Namespace, lower.Name)
+	if v.addressTarget == AddressTargetStatus {
+		// the latest status is notified again with the address
+		current, err := v.clientCache.podLister.Pods(lower.Namespace).Get(lower.Name)
+		if err != nil {
+			return
+		}
+		podCopy := current.DeepCopy()
+		util.TrimObjectMeta(&podCopy.ObjectMeta)
+		v.updatedPod <- podCopy
+	}
+}
+
+// translateAddress returns the address the lower pod is reachable at by the translation, nil if not reachable
+func (v *VirtualK8S) translateAddress(ctx context.Context, lower *corev1.Pod) (*reachableAddress, error) {
+	address := &reachableAddress{hostIP: lower.Status.HostIP, Ports: map[string]int32{}}
+	switch v.addressTranslation {
+	case AddressHostIP:
+		address.IP = lower.Status.HostIP
+		for _, port := range containerPorts(lower) {
+			if port.HostPort != 0 {
+				address.Ports[portKey(port.ContainerPort, port.Protocol)] = port.HostPort
+			}
+		}
+	case AddressGateway:
+		address.IP = v.gatewayAddress
+		for _, port := range containerPorts(lower) {
+			address.Ports[portKey(port.ContainerPort, port.Protocol)] = port.ContainerPort
+		}
+	case AddressNodePort, AddressLoadBalancer:
+		service, err := v.exposePod(ctx, lower)
+		if err != nil || service == nil {
+			return nil, err
+		}
+		if v.addressTranslation == AddressNodePort {
+			node, err := v.clientCache.nodeLister.Get(lower.Spec.NodeName)
+			if err != nil {
+				return nil, err
+			}
+			address.IP = nodeAddress(node)
+		} else if address.IP, err = v.waitForIngress(ctx, service); err != nil {
+			return nil, err
+		}
+		for _, port := range service.Spec.Ports {
+			reachable := port.Port
+			if v.addressTranslation == AddressNodePort {
+				reachable = port.NodePort
+			}
+			address.Ports[portKey(port.TargetPort.IntVal, port.Protocol)] = reachable
+		}
+	}
+	if len(address.IP) == 0 {
+		return nil, nil
+	}
+	return address, nil
+}
+
+// exposePod creates the service of NodePort or LoadBalancer exposing the container ports of the lower pod if
+// absent, owned by the lower pod so that it is deleted with the pod, nil is returned if no port to expose
+func (v *VirtualK8S) exposePod(ctx context.Context, lower *corev1.Pod) (*corev1.Service, error) {
+	uid := string(getUpperUID(lower))
+	name := "address-" + uid
+	service, err := v.client.CoreV1().Services(lower.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return service, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	ports := containerPorts(lower)
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	service = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: lower.Namespace,
+			Labels:    map[string]string{util.AddressOf: uid},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: lower.Name,
+				UID: lower.UID}},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceType(v.addressTranslation),
+			Selector: map[string]string{util.AddressOf: uid},
+		},
+	}
+	for _, port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       strings.ToLower(fmt.Sprintf("%v-%v", port.Protocol, port.ContainerPort)),
+			Protocol:   port.Protocol,
+			Port:       port.ContainerPort,
+			TargetPort: intstr.FromInt(int(port.ContainerPort)),
+		})
+	}
+	service, err = v.client.CoreV1().Services(lower.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// exposed by another update of the pod in the meantime
+		return v.client.CoreV1().Services(lower.Namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not expose pod: %v", err)
+	}
+	klog.V(3).Infof("Exposed pod %v/%v by service %v", lower.Namespace, lower.Name, name)
+	return service, nil
+}
+
+// waitForIngress returns the ingress address of the LoadBalancer service once assigned
+func (v *VirtualK8S) waitForIngress(ctx context.Context, service *corev1.Service) (string, error) {
+	var address string
+	err := wait.PollImmediate(5*time.Second, loadBalancerWaitTimeout, func() (bool, error) {
+		if ingress := service.Status.LoadBalancer.Ingress; len(ingress) != 0 {
+			address = ingress[0].IP
+			if len(address) == 0 {
+				address = ingress[0].Hostname
+			}
+			return true, nil
+		}
+		var err error
+		service, err = v.client.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return false, nil
+	})
+	return address, err
+}
+
+// translateStatus replaces the pod ip and host ip of the lower pod with the address published if the status is
+// the target
+func (v *VirtualK8S) translateStatus(lower *corev1.Pod) {
+	if v.addressTarget != AddressTargetStatus {
+		return
+	}
+	cached, ok := v.addresses.Load(getUpperUID(lower))
+	if !ok || len(lower.Status.PodIP) == 0 {
+		return
+	}
+	address := cached.(*reachableAddress)
+	if address.hostIP != lower.Status.HostIP {
+		return
+	}
+	lower.Status.PodIP = address.IP
+	lower.Status.PodIPs = []corev1.PodIP{{IP: address.IP}}
+	lower.Status.HostIP = address.IP
+}
+
+// forgetAddress drops the address of the lower pod deleted
+func (v *VirtualK8S) forgetAddress(lower *corev1.Pod) {
+	v.addresses.Delete(getUpperUID(lower))
+}
+
+// containerPorts returns the ports of the containers of the pod
+func containerPorts(pod *corev1.Pod) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if len(port.Protocol) == 0 {
+				port.Protocol = corev1.ProtocolTCP
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// portKey returns the key of the container port in reachableAddress.Ports
+func portKey(port int32, protocol corev1.Protocol) string {
+	return strconv.Itoa(int(port)) + "/" + string(protocol)
+}
+
+// nodeAddress returns the external ip of the node, or the internal ip if there is no external one
+func nodeAddress(node *corev1.Node) string {
+	var internal string
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case corev1.NodeExternalIP:
+			return address.Address
+		case corev1.NodeInternalIP:
+			if len(internal) == 0 {
+				internal = address.Address
+			}
+		}
+	}
+	return internal
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestPublishAddress(t *testing.T) {
+	ctx := context.Background()
+	newLower := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", UID: "lower",
+				Annotations: map[string]string{util.UpperPodUID: "upper"}},
+			Spec: corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{
+				Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 80}},
+			}}},
+			Status: corev1.PodStatus{PodIP: "172.16.0.2", HostIP: "192.168.0.2"},
+		}
+	}
+	cases := []struct {
+		translation string
+		desired     string
+	}{
+		{translation: AddressHostIP, desired: `{"ip":"192.168.0.2","ports":{"8080/TCP":80}}`},
+		{translation: AddressGateway, desired: `{"ip":"10.0.0.1","ports":{"8080/TCP":8080}}`},
+		{translation: AddressNodePort, desired: `{"ip":"1.2.3.4","ports":{"8080/TCP":0}}`},
+	}
+	for _, c := range cases {
+		vk, nodeInformer, podInformer := newFakeVirtualK8SWithNodePod()
+		vk.addressTranslation = c.translation
+		vk.addressTarget = AddressTargetStatus
+		vk.gatewayAddress = "10.0.0.1"
+		vk.updatedPod = make(chan *corev1.Pod, 1)
+		nodeInformer.Informer().GetIndexer().Add(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.2"},
+				{Type: corev1.NodeExternalIP, Address: "1.2.3.4"},
+			}},
+		})
+		lower := newLower()
+		podInformer.Informer().GetIndexer().Add(lower)
+		vk.master.CoreV1().Pods("ns").Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", UID: "upper"},
+		}, metav1.CreateOptions{})
+
+		vk.publishAddress(ctx, lower)
+		upper, err := vk.master.CoreV1().Pods("ns").Get(ctx, "web", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upper.Annotations[util.ReachableAddress] != c.desired {
+			t.Fatalf("Desire address %v by %v, get %v", c.desired, c.translation,
+				upper.Annotations[util.ReachableAddress])
+		}
+		if c.translation == AddressNodePort {
+			service, err := vk.client.CoreV1().Services("ns").Get(ctx, "address-upper", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Desire pod exposed, get %v", err)
+			}
+			if service.Spec.Type != corev1.ServiceTypeNodePort || service.Spec.Selector[util.AddressOf] != "upper" {
+				t.Fatalf("Desire NodePort service selecting the pod, get %+v", service.Spec)
+			}
+		}
+		notified := <-vk.updatedPod
+		vk.translateStatus(notified)
+		if notified.Status.PodIP == lower.Status.PodIP {
+			t.Fatalf("Desire pod ip replaced by %v, get %v", c.translation, notified.Status.PodIP)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// admissionRejectedReason is the reason of upper pods rejected by admission of the lower cluster
+const admissionRejectedReason = "AdmissionRejected"
+
+// isAdmissionRejection returns if the error of creating pod is a rejection of admission in the lower cluster,
+// i.e. denied by webhooks, exceeding quota or invalid fields, which would never succeed by retrying the same pod.
+// Other forbidden errors, e.g. rbac denials of the provider or namespaces being terminated, are retried since
+// they are fixed in the lower cluster without changing the pod
+func isAdmissionRejection(err error) bool {
+	if errors.IsInvalid(err) {
+		return true
+	}
+	if !errors.IsForbidden(err) {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "admission webhook") && strings.Contains(message, "denied the request") ||
+		strings.Contains(message, "exceeded quota")
+}
+
+// rejectPod fails the upper pod like kubelet rejecting pods on admission, so users learn why the pod never
+// started and virtual kubelet stops retrying it
+func (v *VirtualK8S) rejectPod(ctx context.Context, pod *corev1.Pod, rejection error) error {
+	message := fmt.Sprintf("pod is rejected by lower cluster %v: %v", v.clusterName, rejection)
+	if v.eventRecorder != nil {
+		v.eventRecorder.Event(pod, corev1.EventTypeWarning, admissionRejectedReason, message)
+	}
+	podCopy := pod.DeepCopy()
+	podCopy.Status.Phase = corev1.PodFailed
+	podCopy.Status.Reason = admissionRejectedReason
+	podCopy.Status.Message = message
+	if _, err := v.master.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, podCopy,
+		metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not mark pod rejected: %v", err)
+	}
+	klog.Infof("Pod %v/%v is rejected by lower cluster: %v", pod.Namespace, pod.Name, rejection)
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+var podResource = schema.GroupResource{Resource: "pods"}
+
+func TestIsAdmissionRejection(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		err    error
+		reject bool
+	}{
+		{
+			name:   "webhook denied",
+			err:    errors.NewForbidden(podResource, "test", fmt.Errorf(`admission webhook "a" denied the request`)),
+			reject: true,
+		},
+		{
+			name:   "quota exceeded",
+			err:    errors.NewForbidden(podResource, "test", fmt.Errorf("exceeded quota: q, requested: cpu=1")),
+			reject: true,
+		},
+		{
+			name: "invalid",
+			err: errors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "test",
+				field.ErrorList{field.Invalid(field.NewPath("spec"), "", "bad")}),
+			reject: true,
+		},
+		{
+			name: "namespace terminating",
+			err: errors.NewForbidden(podResource, "test",
+				fmt.Errorf("unable to create new content in namespace ns because it is being terminated")),
+		},
+		{
+			name: "rbac denied",
+			err: errors.NewForbidden(podResource, "test", fmt.Errorf(
+				`User "system:serviceaccount:vk:vk" cannot create resource "pods" in API group "" in the namespace "ns"`)),
+		},
+		{
+			name: "server timeout",
+			err:  errors.NewServerTimeout(podResource, "create", 1),
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isAdmissionRejection(c.err); got != c.reject {
+				t.Errorf("desire %v, get %v", c.reject, got)
+			}
+		})
+	}
+}
+
+func TestCreatePodRejected(t *testing.T) {
+	vk, _, _ := newFakeVirtualK8S()
+	ctx := context.Background()
+	pod := fakePod("test")
+	pod.Labels = map[string]string{"virtual-pod": "true"}
+	master := fake.NewSimpleClientset(pod)
+	vk.master = master
+	vk.client.(*fake.Clientset).PrependReactor("create", "pods",
+		func(action core.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewForbidden(podResource, pod.Name,
+				fmt.Errorf(`admission webhook "a" denied the request`))
+		})
+
+	if err := vk.CreatePod(ctx, pod); err != nil {
+		t.Fatalf("desire no retry of rejected pod, get %v", err)
+	}
+	upper, err := master.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper.Status.Phase != corev1.PodFailed || upper.Status.Reason != admissionRejectedReason {
+		t.Errorf("desire pod failed by %v, get %+v", admissionRejectedReason, upper.Status)
+	}
+}
@@ -0,0 +1,342 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+var _ node.PodLifecycleHandler = &Aggregate{}
+var _ node.PodNotifier = &Aggregate{}
+
+// Aggregate is the provider of a virtual node backed by several lower clusters, the capacity of the node is the
+// sum of the clusters, and each pod runs in one of them chosen by the placement. The primary cluster owns the
+// metadata of the node, e.g. labels and the fit summary, the other clusters only contribute their capacity.
+type Aggregate struct {
+	clusters  []*VirtualK8S
+	placement Placement
+
+	lock sync.Mutex
+	// nodes are the latest virtual node of each cluster, merged into the node notified
+	nodes []*corev1.Node
+}
+
+// NewAggregate returns the provider of a virtual node over the primary cluster and the others
+func NewAggregate(placement Placement, primary *VirtualK8S, others ...*VirtualK8S) *Aggregate {
+	for _, other := range others {
+		other.nodeStatusOnly = true
+	}
+	clusters := append([]*VirtualK8S{primary}, others...)
+	return &Aggregate{
+		clusters:  clusters,
+		placement: placement,
+		nodes:     make([]*corev1.Node, len(clusters)),
+	}
+}
+
+// Clusters returns the providers of the lower clusters, the primary one first
+func (a *Aggregate) Clusters() []*VirtualK8S {
+	return a.clusters
+}
+
+// clusterOf returns the cluster running the pod, the primary one if not found, so that the errors of pods
+// not found are the same as a single cluster
+func (a *Aggregate) clusterOf(namespace, name string) (*VirtualK8S, bool) {
+	for _, c := range a.clusters {
+		pod, err := c.clientCache.podLister.Pods(namespace).Get(name)
+		if err == nil && !c.isStale(pod) {
+			return c, true
+		}
+	}
+	return a.clusters[0], false
+}
+
+// CreatePod creates the pod in the cluster chosen by the placement, or in the cluster it exists already
+func (a *Aggregate) CreatePod(ctx context.Context, pod *corev1.Pod) error {
+	c, ok := a.clusterOf(pod.Namespace, pod.Name)
+	if !ok {
+		c = a.placement.Place(pod, a.satisfying(pod))
+		klog.V(3).Infof("Place pod %v/%v to cluster %v", pod.Namespace, pod.Name, c.clusterName)
+	}
+	return c.CreatePod(ctx, pod)
+}
+
+// satisfying returns the clusters having a lower node left for the pod by the required anti-affinity keyed on
+// hostname, or all of the clusters if none has, the lower scheduler keeps the pod pending then
+func (a *Aggregate) satisfying(pod *corev1.Pod) []*VirtualK8S {
+	var clusters []*VirtualK8S
+	for _, c := range a.clusters {
+		if c.satisfiesHostAntiAffinity(pod) {
+			clusters = append(clusters, c)
+		}
+	}
+	if len(clusters) == 0 {
+		return a.clusters
+	}
+	return clusters
+}
+
+// UpdatePod updates the pod in the cluster running it
+func (a *Aggregate) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
+	c, _ := a.clusterOf(pod.Namespace, pod.Name)
+	return c.UpdatePod(ctx, pod)
+}
+
+// DeletePod deletes the pod from the cluster running it
+func (a *Aggregate) DeletePod(ctx context.Context, pod *corev1.Pod) error {
+	c, _ := a.clusterOf(pod.Namespace, pod.Name)
+	return c.DeletePod(ctx, pod)
+}
+
+// GetPod returns the pod from the cluster running it
+func (a *Aggregate) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	c, _ := a.clusterOf(namespace, name)
+	return c.GetPod(ctx, namespace, name)
+}
+
+// GetPodStatus returns the status of the pod from the cluster running it
+func (a *Aggregate) GetPodStatus(ctx context.Context, namespace, name string) (*corev1.PodStatus, error) {
+	c, _ := a.clusterOf(namespace, name)
+	return c.GetPodStatus(ctx, namespace, name)
+}
+
+// GetPods returns the pods of all of the clusters
+func (a *Aggregate) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
+	var all []*corev1.Pod
+	for _, c := range a.clusters {
+		pods, err := c.GetPods(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %v: %v", c.clusterName, err)
+		}
+		all = append(all, pods...)
+	}
+	return all, nil
+}
+
+// GetContainerLogs returns the logs of the container from the cluster running the pod
+func (a *Aggregate) GetContainerLogs(ctx context.Context, namespace, podName, containerName string,
+	opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	c, _ := a.clusterOf(namespace, podName)
+	return c.GetContainerLogs(ctx, namespace, podName, containerName, opts)
+}
+
+// RunInContainer executes the command in the container in the cluster running the pod
+func (a *Aggregate) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string,
+	attach api.AttachIO) error {
+	c, _ := a.clusterOf(namespace, podName)
+	return c.RunInContainer(ctx, namespace, podName, containerName, cmd, attach)
+}
+
+// AttachToContainer attaches to the container in the cluster running the pod
+func (a *Aggregate) AttachToContainer(ctx context.Context, namespace, podName, containerName string,
+	attach api.AttachIO) error {
+	c, _ := a.clusterOf(namespace, podName)
+	return c.AttachToContainer(ctx, namespace, podName, containerName, attach)
+}
+
+// PortForward forwards the stream to the port of the pod in the cluster running it
+func (a *Aggregate) PortForward(ctx context.Context, namespace, podName string, port int32,
+	stream io.ReadWriteCloser) error {
+	c, _ := a.clusterOf(namespace, podName)
+	return c.PortForward(ctx, namespace, podName, port, stream)
+}
+
+// NotifyPods notifies the pod changes of all of the clusters
+func (a *Aggregate) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
+	for _, c := range a.clusters {
+		c.NotifyPods(ctx, f)
+	}
+}
+
+// GetStatsSummary sums the stats of all of the clusters
+func (a *Aggregate) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
+	var cpu, memory uint64
+	summary := &stats.Summary{}
+	for _, c := range a.clusters {
+		s, err := c.GetStatsSummary(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %v: %v", c.clusterName, err)
+		}
+		summary.Pods = append(summary.Pods, s.Pods...)
+		if s.Node.CPU != nil && s.Node.CPU.UsageNanoCores != nil {
+			cpu += *s.Node.CPU.UsageNanoCores
+		}
+		if s.Node.Memory != nil && s.Node.Memory.WorkingSetBytes != nil {
+			memory += *s.Node.Memory.WorkingSetBytes
+		}
+		if summary.Node.CPU == nil {
+			summary.Node = s.Node
+		}
+	}
+	summary.Node.CPU = &stats.CPUStats{Time: summary.Node.StartTime, UsageNanoCores: &cpu}
+	summary.Node.Memory = &stats.MemoryStats{Time: summary.Node.StartTime, WorkingSetBytes: &memory}
+	return summary, nil
+}
+
+// ConfigureNode configures the node by the primary cluster and adds the capacity of the others, each cluster
+// keeps its own copy of the node tracking its capacity
+func (a *Aggregate) ConfigureNode(ctx context.Context, node *corev1.Node) {
+	for i, c := range a.clusters {
+		c.ConfigureNode(ctx, node.DeepCopy())
+		if c.providerNode.Node != nil {
+			a.lock.Lock()
+			a.nodes[i] = c.providerNode.DeepCopy()
+			a.lock.Unlock()
+		}
+	}
+	if merged := a.merge(); merged != nil {
+		*node = *merged
+	}
+}
+
+// Ping succeeds if any of the clusters is reachable
+func (a *Aggregate) Ping(ctx context.Context) error {
+	var errs []string
+	for _, c := range a.clusters {
+		if err := c.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("cluster %v: %v", c.clusterName, err))
+		}
+	}
+	if len(errs) == len(a.clusters) {
+		return fmt.Errorf("no cluster is reachable: %v", errs)
+	}
+	return nil
+}
+
+// NotifyNodeStatus notifies the node merged of all of the clusters once any of them changed
+func (a *Aggregate) NotifyNodeStatus(ctx context.Context, f func(*corev1.Node)) {
+	for i, c := range a.clusters {
+		i := i
+		c.NotifyNodeStatus(ctx, func(node *corev1.Node) {
+			a.lock.Lock()
+			a.nodes[i] = node
+			a.lock.Unlock()
+			if merged := a.merge(); merged != nil {
+				f(merged)
+			}
+		})
+	}
+}
+
+// merge returns the node of the primary cluster with the capacity of all of the clusters, nil if the primary
+// node is not configured yet
+func (a *Aggregate) merge() *corev1.Node {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.nodes[0] == nil {
+		return nil
+	}
+	merged := a.nodes[0].DeepCopy()
+	capacity := common.NewResource()
+	for _, n := range a.nodes {
+		if n != nil {
+			capacity.Add(common.ConvertResource(n.Status.Capacity))
+		}
+	}
+	capacity.SetCapacityToNode(merged)
+	return merged
+}
+
+// Placement chooses the lower cluster running the pod among the clusters of an aggregate
+type Placement interface {
+	Place(pod *corev1.Pod, clusters []*VirtualK8S) *VirtualK8S
+}
+
+const (
+	// FirstFit places pods to the first cluster in order fitting them
+	FirstFit = "FirstFit"
+	// LeastAllocated places pods to the cluster fitting them with the most free cpu
+	LeastAllocated = "LeastAllocated"
+)
+
+// NewPlacement returns the placement of the name
+func NewPlacement(name string) (Placement, error) {
+	switch name {
+	case FirstFit:
+		return firstFit{}, nil
+	case LeastAllocated:
+		return leastAllocated{}, nil
+	}
+	return nil, fmt.Errorf("unknown placement %q, expected %v or %v", name, FirstFit, LeastAllocated)
+}
+
+type firstFit struct{}
+
+// Place returns the first cluster fitting the pod, or the least allocated one if no cluster fits, the lower
+// scheduler keeps the pod pending then, which is descheduled as usual
+func (firstFit) Place(pod *corev1.Pod, clusters []*VirtualK8S) *VirtualK8S {
+	request := util.GetRequestFromPod(pod)
+	for _, c := range clusters {
+		if fits(freeResource(c), request) {
+			return c
+		}
+	}
+	return leastAllocated{}.Place(pod, clusters)
+}
+
+type leastAllocated struct{}
+
+// Place returns the cluster with the most free cpu, the fitting clusters are preferred
+func (leastAllocated) Place(pod *corev1.Pod, clusters []*VirtualK8S) *VirtualK8S {
+	request := util.GetRequestFromPod(pod)
+	var best *VirtualK8S
+	var bestFree *common.Resource
+	bestFits := false
+	for _, c := range clusters {
+		free := freeResource(c)
+		fit := fits(free, request)
+		if best == nil || fit && !bestFits || fit == bestFits && free.CPU.Cmp(bestFree.CPU) > 0 {
+			best, bestFree, bestFits = c, free, fit
+		}
+	}
+	return best
+}
+
+// freeResource returns the resource of the virtual node of the cluster, which is the free resource of the
+// cluster as the requests of pods are subtracted
+func freeResource(c *VirtualK8S) *common.Resource {
+	if c.providerNode == nil || c.providerNode.Node == nil {
+		return common.NewResource()
+	}
+	return common.ConvertResource(c.providerNode.DeepCopy().Status.Allocatable)
+}
+
+// fits returns if the cpu, memory, pods and extended resources of the request fit in the free resource
+func fits(free, request *common.Resource) bool {
+	if free.CPU.Cmp(request.CPU) < 0 || free.Memory.Cmp(request.Memory) < 0 || free.Pods.Value() < 1 {
+		return false
+	}
+	available := free.Extended()
+	for name, quantity := range request.Extended() {
+		if quantity > 0 && available[name] < quantity {
+			return false
+		}
+	}
+	return true
+}
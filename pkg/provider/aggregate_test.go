@@ -0,0 +1,123 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func aggregatedCluster(name, cpu string) *VirtualK8S {
+	vk, _, _ := newFakeVirtualK8S()
+	vk.clusterName = name
+	node := &corev1.Node{}
+	common.ConvertResource(corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+		corev1.ResourcePods:   resource.MustParse("10"),
+	}).SetCapacityToNode(node)
+	vk.providerNode = &common.ProviderNode{Node: node}
+	return vk
+}
+
+func podRequesting(cpu string) *corev1.Pod {
+	pod := fakePod("test")
+	pod.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+	}}}
+	return pod
+}
+
+func TestPlacement(t *testing.T) {
+	if _, err := NewPlacement("Random"); err == nil {
+		t.Fatal("desire error of unknown placement, real nil")
+	}
+	clusters := []*VirtualK8S{aggregatedCluster("a", "2"), aggregatedCluster("b", "8"), aggregatedCluster("c", "4")}
+	cases := []struct {
+		name      string
+		placement string
+		cpu       string
+		expected  string
+	}{
+		{name: "first fit", placement: FirstFit, cpu: "1", expected: "a"},
+		{name: "first fit skips small clusters", placement: FirstFit, cpu: "3", expected: "b"},
+		{name: "least allocated", placement: LeastAllocated, cpu: "1", expected: "b"},
+		{name: "nothing fits", placement: FirstFit, cpu: "16", expected: "b"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			placement, err := NewPlacement(c.placement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cluster := placement.Place(podRequesting(c.cpu), clusters); cluster.clusterName != c.expected {
+				t.Fatalf("desire cluster %v, real %v", c.expected, cluster.clusterName)
+			}
+		})
+	}
+}
+
+func TestPlacementExtendedResources(t *testing.T) {
+	gpu := aggregatedCluster("gpu", "2")
+	gpu.providerNode.Status.Allocatable["nvidia.com/gpu"] = resource.MustParse("2")
+	clusters := []*VirtualK8S{aggregatedCluster("cpu", "8"), gpu}
+	pod := podRequesting("1")
+	pod.Spec.Containers[0].Resources.Requests["nvidia.com/gpu"] = resource.MustParse("1")
+	if cluster := (firstFit{}).Place(pod, clusters); cluster.clusterName != "gpu" {
+		t.Fatalf("desire cluster gpu, real %v", cluster.clusterName)
+	}
+}
+
+func TestAggregateNode(t *testing.T) {
+	primary, other := aggregatedCluster("a", "2"), aggregatedCluster("b", "8")
+	primary.providerNode.Labels = map[string]string{util.NetworkZone: "zone-a"}
+	a := NewAggregate(firstFit{}, primary, other)
+	if !other.nodeStatusOnly || primary.nodeStatusOnly {
+		t.Fatal("desire only the primary cluster publishing node metadata")
+	}
+	a.nodes[0], a.nodes[1] = primary.providerNode.DeepCopy(), other.providerNode.DeepCopy()
+	merged := a.merge()
+	cpu := merged.Status.Allocatable[corev1.ResourceCPU]
+	if cpu.String() != "10" || merged.Labels[util.NetworkZone] != "zone-a" {
+		t.Fatalf("desire 10 cpu and labels of primary node, real %v %v", cpu.String(), merged.Labels)
+	}
+}
+
+func TestAggregateRoutesPods(t *testing.T) {
+	primary, other := aggregatedCluster("a", "2"), aggregatedCluster("b", "8")
+	a := NewAggregate(firstFit{}, primary, other)
+	lower := fakePod("test")
+	lower.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	other.clientCache.podIndexer.Add(lower)
+
+	if c, ok := a.clusterOf("test", "test"); !ok || c != other {
+		t.Fatalf("desire pod found in cluster b, real %v %v", c.clusterName, ok)
+	}
+	if c, ok := a.clusterOf("test", "missing"); ok || c != primary {
+		t.Fatalf("desire primary cluster for missing pod, real %v %v", c.clusterName, ok)
+	}
+	pods, err := a.GetPods(context.Background())
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("desire 1 pod of all clusters, real %v %v", pods, err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// createPodFailedReason is the reason of alerts of pods failing to be created in the lower cluster
+	createPodFailedReason = "CreatePodFailed"
+	// dependencySyncFailedReason is the reason of alerts of pvcs of pods failing to be synced
+	dependencySyncFailedReason = "DependencySyncFailed"
+)
+
+func podAlertKey(pod *corev1.Pod) string {
+	return "pod/" + pod.Namespace + "/" + pod.Name
+}
+
+func dependencyAlertKey(pod *corev1.Pod) string {
+	return "dependency/" + pod.Namespace + "/" + pod.Name
+}
+
+func conflictKey(pod *corev1.Pod) string {
+	return conflictKeyOf(pod.Namespace, pod.Name)
+}
+
+// conflictKeyOf returns the conflict key of the upper pod of the namespace and name
+func conflictKeyOf(namespace, name string) string {
+	return "conflict/" + namespace + "/" + name
+}
+
+func podReference(pod *corev1.Pod) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
+
+// resolveAlerts forgets the failures and the conflicts of the pod once it is deleted
+func (v *VirtualK8S) resolveAlerts(pod *corev1.Pod) {
+	v.alerts.Resolved(podAlertKey(pod))
+	v.alerts.Resolved(dependencyAlertKey(pod))
+	v.conflicts.Forget(conflictKey(pod))
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// publishLowerNode records the node the lower pod is bound to in the annotation of the upper pod, the node is
+// only published again once the pod moves to another node
+func (v *VirtualK8S) publishLowerNode(ctx context.Context, lower *corev1.Pod) {
+	uid := getUpperUID(lower)
+	if len(uid) == 0 || len(lower.Spec.NodeName) == 0 || lower.DeletionTimestamp != nil || v.isStale(lower) {
+		return
+	}
+	if cached, ok := v.lowerNodes.Load(uid); ok && cached.(string) == lower.Spec.NodeName {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{util.LowerNode: lower.Spec.NodeName},
+		},
+	})
+	if err != nil {
+		return
+	}
+	if _, err = v.upperPods(lower).Patch(ctx, lower.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Publish lower node of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		}
+		return
+	}
+	v.lowerNodes.Store(uid, lower.Spec.NodeName)
+	klog.V(4).Infof("Published lower node %v of pod %v/%v", lower.Spec.NodeName, lower.Namespace, lower.Name)
+}
+
+// forgetLowerNode drops the lower node published for the lower pod deleted
+func (v *VirtualK8S) forgetLowerNode(lower *corev1.Pod) {
+	v.lowerNodes.Delete(getUpperUID(lower))
+}
+
+// runSchedulableNodes publishes the number of ready and schedulable lower nodes to the annotation of virtual node
+// periodically, so that schedulers tell whether any lower node is left for a pod by its anti-affinity
+func (v *VirtualK8S) runSchedulableNodes(ctx context.Context) {
+	wait.Until(func() {
+		nodes, err := v.physicalNodes()
+		if err != nil {
+			klog.Errorf("List nodes failed: %v", err)
+			return
+		}
+		v.setNodeAnnotation(util.SchedulableNodes, strconv.Itoa(len(nodes)))
+	}, fitSummaryPeriod, ctx.Done())
+}
+
+// satisfiesHostAntiAffinity tells whether a ready and schedulable lower node runs no pod conflicting with the
+// upper pod by the required anti-affinity keyed on hostname of either one
+func (v *VirtualK8S) satisfiesHostAntiAffinity(pod *corev1.Pod) bool {
+	nodes, err := v.physicalNodes()
+	if err != nil {
+		klog.Errorf("List nodes failed: %v", err)
+		return true
+	}
+	pods, err := v.clientCache.podLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List pods failed: %v", err)
+		return true
+	}
+	occupied := make(map[string]bool)
+	for _, lower := range pods {
+		if len(lower.Spec.NodeName) == 0 || lower.Status.Phase == corev1.PodSucceeded ||
+			lower.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		existing := lower
+		if util.IsVirtualPod(lower) {
+			existing = lower.DeepCopy()
+			v.restoreNamespace(existing)
+		}
+		if common.HostAntiAffinityConflicts(pod, existing) {
+			occupied[lower.Spec.NodeName] = true
+		}
+	}
+	for _, node := range nodes {
+		if !occupied[node.Name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSatisfiesHostAntiAffinity(t *testing.T) {
+	vk, nodeInformer, podInformer := newFakeVirtualK8SWithNodePod()
+	for i := 1; i <= 2; i++ {
+		nodeInformer.Informer().GetStore().Add(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%v", i)},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		})
+	}
+	newPod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "web"}},
+			Spec: corev1.PodSpec{NodeName: node, Affinity: &corev1.Affinity{
+				PodAntiAffinity: &corev1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+						TopologyKey:   corev1.LabelHostname,
+					}},
+				},
+			}},
+		}
+	}
+	pod := newPod("web", "")
+	podInformer.Informer().GetStore().Add(newPod("web-1", "node1"))
+	if !vk.satisfiesHostAntiAffinity(pod) {
+		t.Fatal("Desire node2 left for the pod")
+	}
+	podInformer.Informer().GetStore().Add(newPod("web-2", "node2"))
+	if vk.satisfiesHostAntiAffinity(pod) {
+		t.Fatal("Desire no node left for the pod")
+	}
+	other := pod.DeepCopy()
+	other.Labels = map[string]string{"app": "db"}
+	other.Spec.Affinity = nil
+	if !vk.satisfiesHostAntiAffinity(other) {
+		t.Fatal("Desire nodes left for the pod not selected")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// storageCapacitySyncPeriod is the period to aggregate the CSIStorageCapacity objects of lower cluster
+const storageCapacitySyncPeriod = time.Minute
+
+// storageCapacityVersions are the api versions of CSIStorageCapacity tried in order
+var storageCapacityVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+// csiStorageCapacityList is the part of CSIStorageCapacity list we need, the api we build with
+// has no such type, so it is decoded from the raw response
+type csiStorageCapacityList struct {
+	Items []struct {
+		StorageClassName  string                `json:"storageClassName"`
+		NodeTopology      *metav1.LabelSelector `json:"nodeTopology,omitempty"`
+		Capacity          *resource.Quantity    `json:"capacity,omitempty"`
+		MaximumVolumeSize *resource.Quantity    `json:"maximumVolumeSize,omitempty"`
+	} `json:"items"`
+}
+
+// runStorageCapacity publishes the storage capacity of lower cluster to the annotation of virtual node
+func (v *VirtualK8S) runStorageCapacity(ctx context.Context) {
+	wait.Until(func() {
+		capacity, err := v.getStorageCapacity(ctx)
+		if err != nil {
+			klog.Errorf("Get storage capacity failed: %v", err)
+			return
+		}
+		if capacity == nil {
+			klog.V(4).Info("CSIStorageCapacity is not served by lower cluster")
+			return
+		}
+		data, err := json.Marshal(capacity)
+		if err != nil {
+			return
+		}
+		v.setNodeAnnotation(util.StorageCapacity, string(data))
+	}, storageCapacitySyncPeriod, ctx.Done())
+}
+
+// getStorageCapacity aggregates the CSIStorageCapacity objects in all namespaces of lower cluster,
+// nil would be returned if the api is not served
+func (v *VirtualK8S) getStorageCapacity(ctx context.Context) (common.StorageCapacity, error) {
+	for _, version := range storageCapacityVersions {
+		data, err := v.client.Discovery().RESTClient().Get().
+			AbsPath("/apis/storage.k8s.io", version, "csistoragecapacities").DoRaw(ctx)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		return parseStorageCapacity(data)
+	}
+	return nil, nil
+}
+
+// parseStorageCapacity records the capacity of each storage class in each topology segment from the raw list,
+// segments are identified by the match labels of node topology
+func parseStorageCapacity(data []byte) (common.StorageCapacity, error) {
+	list := csiStorageCapacityList{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	capacity := common.StorageCapacity{}
+	for _, item := range list.Items {
+		segment := common.StorageSegment{}
+		if item.NodeTopology != nil {
+			segment.Topology = item.NodeTopology.MatchLabels
+		}
+		if item.Capacity != nil {
+			segment.Capacity = item.Capacity.Value()
+		}
+		if item.MaximumVolumeSize != nil {
+			segment.MaximumVolumeSize = item.MaximumVolumeSize.Value()
+		}
+		if segment.Capacity == 0 && segment.MaximumVolumeSize == 0 {
+			continue
+		}
+		capacity.Add(item.StorageClassName, segment)
+	}
+	return capacity, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// containerCompletedReason and containerErrorReason are the same as kubelet reports for containers exited
+	// with zero and non-zero codes
+	containerCompletedReason = "Completed"
+	containerErrorReason     = "Error"
+	// podCompletedReason is the reason of the ready conditions of pods terminated
+	podCompletedReason = "PodCompleted"
+)
+
+// completePodStatus propagates the terminal states of the containers of a lower pod to its phase the same as
+// kubelet does, so Jobs of the upper cluster count the pod as soon as its containers are terminated even if the
+// lower cluster has not reported the phase yet, e.g. the status is not updated before the pod is deleted. The
+// exit codes, messages and finish times of the containers are kept as reported, the reasons are filled if
+// missing. It returns if the phase is completed.
+func completePodStatus(pod *corev1.Pod) bool {
+	status := &pod.Status
+	for i := range status.InitContainerStatuses {
+		completeContainer(&status.InitContainerStatuses[i])
+	}
+	for i := range status.ContainerStatuses {
+		completeContainer(&status.ContainerStatuses[i])
+	}
+	if status.Phase != corev1.PodRunning && status.Phase != corev1.PodPending {
+		return false
+	}
+	phase, ok := completedPhase(pod)
+	if !ok {
+		return false
+	}
+	klog.V(4).Infof("Pod %v/%v completed with phase %v before reported", pod.Namespace, pod.Name, phase)
+	status.Phase = phase
+	for i := range status.Conditions {
+		cond := &status.Conditions[i]
+		if cond.Type == corev1.PodReady || cond.Type == corev1.ContainersReady {
+			cond.Status = corev1.ConditionFalse
+			cond.Reason = podCompletedReason
+		}
+	}
+	return true
+}
+
+// completedPhase returns the terminal phase of the pod if all of its containers are terminated and none of them
+// would be restarted by the restart policy
+func completedPhase(pod *corev1.Pod) (corev1.PodPhase, bool) {
+	if pod.Spec.RestartPolicy == corev1.RestartPolicyAlways ||
+		len(pod.Status.InitContainerStatuses) != len(pod.Spec.InitContainers) ||
+		len(pod.Status.ContainerStatuses) != len(pod.Spec.Containers) || len(pod.Spec.Containers) == 0 {
+		return "", false
+	}
+	for _, c := range pod.Status.InitContainerStatuses {
+		if c.State.Terminated == nil || c.State.Terminated.ExitCode != 0 {
+			// kubelet fails the pod or restarts the init container
+			return "", false
+		}
+	}
+	succeeded := true
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.State.Terminated == nil {
+			return "", false
+		}
+		if c.State.Terminated.ExitCode != 0 {
+			succeeded = false
+		}
+	}
+	if succeeded {
+		return corev1.PodSucceeded, true
+	}
+	if pod.Spec.RestartPolicy == corev1.RestartPolicyOnFailure {
+		return "", false
+	}
+	return corev1.PodFailed, true
+}
+
+func completeContainer(status *corev1.ContainerStatus) {
+	terminated := status.State.Terminated
+	if terminated == nil || len(terminated.Reason) != 0 {
+		return
+	}
+	if terminated.ExitCode == 0 {
+		terminated.Reason = containerCompletedReason
+	} else {
+		terminated.Reason = containerErrorReason
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func terminatedContainer(name string, exitCode int32, reason string, finishedAt metav1.Time) corev1.ContainerStatus {
+	return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: reason, FinishedAt: finishedAt},
+	}}
+}
+
+func jobPod(policy corev1.RestartPolicy, phase corev1.PodPhase, statuses ...corev1.ContainerStatus) *corev1.Pod {
+	pod := fakePod("ns")
+	pod.Spec.RestartPolicy = policy
+	for _, status := range statuses {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: status.Name})
+	}
+	pod.Status.Phase = phase
+	pod.Status.ContainerStatuses = statuses
+	pod.Status.Conditions = []corev1.PodCondition{
+		{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		{Type: corev1.ContainersReady, Status: corev1.ConditionTrue},
+	}
+	return pod
+}
+
+func TestCompletePodStatus(t *testing.T) {
+	finishedAt := metav1.NewTime(time.Unix(1600000000, 0))
+	completed := terminatedContainer("a", 0, "", finishedAt)
+	failed := terminatedContainer("b", 2, "", finishedAt)
+	oomKilled := terminatedContainer("c", 137, "OOMKilled", finishedAt)
+	running := corev1.ContainerStatus{Name: "d", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	for _, c := range []struct {
+		name      string
+		pod       *corev1.Pod
+		completed bool
+		phase     corev1.PodPhase
+		reasons   []string
+	}{
+		{
+			name:      "never restarted succeeded",
+			pod:       jobPod(corev1.RestartPolicyNever, corev1.PodRunning, completed),
+			completed: true,
+			phase:     corev1.PodSucceeded,
+			reasons:   []string{containerCompletedReason},
+		},
+		{
+			name:      "never restarted failed",
+			pod:       jobPod(corev1.RestartPolicyNever, corev1.PodRunning, completed, failed, oomKilled),
+			completed: true,
+			phase:     corev1.PodFailed,
+			reasons:   []string{containerCompletedReason, containerErrorReason, "OOMKilled"},
+		},
+		{
+			name:      "restarted on failure succeeded",
+			pod:       jobPod(corev1.RestartPolicyOnFailure, corev1.PodRunning, completed),
+			completed: true,
+			phase:     corev1.PodSucceeded,
+			reasons:   []string{containerCompletedReason},
+		},
+		{
+			name:    "restarted on failure failed",
+			pod:     jobPod(corev1.RestartPolicyOnFailure, corev1.PodRunning, failed),
+			phase:   corev1.PodRunning,
+			reasons: []string{containerErrorReason},
+		},
+		{
+			name:    "always restarted",
+			pod:     jobPod(corev1.RestartPolicyAlways, corev1.PodRunning, completed),
+			phase:   corev1.PodRunning,
+			reasons: []string{containerCompletedReason},
+		},
+		{
+			name:    "container running",
+			pod:     jobPod(corev1.RestartPolicyNever, corev1.PodRunning, completed, running),
+			phase:   corev1.PodRunning,
+			reasons: []string{containerCompletedReason, ""},
+		},
+		{
+			name:    "already reported",
+			pod:     jobPod(corev1.RestartPolicyNever, corev1.PodFailed, oomKilled),
+			phase:   corev1.PodFailed,
+			reasons: []string{"OOMKilled"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if completePodStatus(c.pod) != c.completed {
+				t.Errorf("desire completed %v", c.completed)
+			}
+			if c.pod.Status.Phase != c.phase {
+				t.Errorf("desire phase %v, get %v", c.phase, c.pod.Status.Phase)
+			}
+			var reasons []string
+			for _, status := range c.pod.Status.ContainerStatuses {
+				if status.State.Terminated == nil {
+					reasons = append(reasons, "")
+					continue
+				}
+				reasons = append(reasons, status.State.Terminated.Reason)
+				if !status.State.Terminated.FinishedAt.Equal(&finishedAt) {
+					t.Errorf("desire finished at %v kept, get %v", finishedAt, status.State.Terminated.FinishedAt)
+				}
+			}
+			if !reflect.DeepEqual(reasons, c.reasons) {
+				t.Errorf("desire reasons %v, get %v", c.reasons, reasons)
+			}
+			for _, cond := range c.pod.Status.Conditions {
+				if c.completed && (cond.Status != corev1.ConditionFalse || cond.Reason != podCompletedReason) {
+					t.Errorf("desire condition %v not ready, get %+v", cond.Type, cond)
+				}
+			}
+		})
+	}
+}
+
+func TestCompletePodStatusInitContainers(t *testing.T) {
+	pod := jobPod(corev1.RestartPolicyNever, corev1.PodPending, terminatedContainer("a", 0, "", metav1.Time{}))
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "init", State: corev1.ContainerState{
+		Running: &corev1.ContainerStateRunning{},
+	}}}
+	if completePodStatus(pod) || pod.Status.Phase != corev1.PodPending {
+		t.Errorf("desire pod with init container running pending, get %v", pod.Status.Phase)
+	}
+	pod.Status.InitContainerStatuses[0] = terminatedContainer("init", 0, "", metav1.Time{})
+	if !completePodStatus(pod) || pod.Status.Phase != corev1.PodSucceeded {
+		t.Errorf("desire pod succeeded, get %v", pod.Status.Phase)
+	}
+	if reason := pod.Status.InitContainerStatuses[0].State.Terminated.Reason; reason != containerCompletedReason {
+		t.Errorf("desire init container reason %v, get %v", containerCompletedReason, reason)
+	}
+}
+
+func TestDeleteCompletedJobPod(t *testing.T) {
+	lower := jobPod(corev1.RestartPolicyNever, corev1.PodRunning, terminatedContainer("a", 0, "", metav1.Now()))
+	lower.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	vk := &VirtualK8S{
+		master:       fake.NewSimpleClientset(fakePod("ns")),
+		configured:   true,
+		providerNode: &common.ProviderNode{Node: &corev1.Node{}},
+		updatedPod:   make(chan *corev1.Pod, 1),
+	}
+	vk.deletePod(lower)
+	select {
+	case pod := <-vk.updatedPod:
+		if pod.Status.Phase != corev1.PodSucceeded || len(pod.Status.Reason) != 0 {
+			t.Errorf("desire pod succeeded instead of deleted, get %v %v", pod.Status.Phase, pod.Status.Reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no pod notified")
+	}
+}
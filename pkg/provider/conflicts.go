@@ -0,0 +1,155 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
+)
+
+const (
+	// conflictingWritersCondition is the condition of virtual node telling if objects of the lower cluster
+	// are fought over by another writer
+	conflictingWritersCondition corev1.NodeConditionType = "ConflictingWriters"
+
+	conflictingWritersReason   = "ConflictingWriters"
+	noConflictingWritersReason = "NoConflictingWriters"
+
+	conflictReportPeriod = 30 * time.Second
+	// maxConflictsReported is the max objects listed in the message of the condition
+	maxConflictsReported = 5
+)
+
+// conflictCondition returns the ConflictingWriters condition of the conflicting objects
+func conflictCondition(keys []string) corev1.NodeCondition {
+	condition := corev1.NodeCondition{
+		Type:               conflictingWritersCondition,
+		Status:             corev1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             noConflictingWritersReason,
+		Message:            "no object is rewritten by another writer in the client cluster",
+	}
+	if len(keys) == 0 {
+		return condition
+	}
+	listed := keys
+	if len(listed) > maxConflictsReported {
+		listed = listed[:maxConflictsReported]
+	}
+	condition.Status, condition.Reason = corev1.ConditionTrue, conflictingWritersReason
+	condition.Message = fmt.Sprintf("%d objects are rewritten by another writer in the client cluster: %v",
+		len(keys), strings.Join(listed, ", "))
+	return condition
+}
+
+// desiredPodState returns the hash of the desired state of the lower pod, which is the upper pod
+func desiredPodState(pod *corev1.Pod) string {
+	return conflict.Hash(pod.Labels, pod.Annotations, pod.Spec)
+}
+
+// lowerPodState returns the hash of the fields of the lower pod written by the provider, the immutable fields
+// and the status are left out since they are never written back, so are the fields of the lower cluster
+func (v *VirtualK8S) lowerPodState(pod *corev1.Pod) string {
+	base := getAppliedState(pod)
+	recorded := base != nil
+	var appliedLabels, appliedAnnotations []string
+	if recorded {
+		appliedLabels, appliedAnnotations = base.Labels, base.Annotations
+	}
+	var images []string
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if v.ownedFields.ownsImage(c.Name) {
+			continue
+		}
+		if _, ok := base.images()[c.Name]; recorded && !ok {
+			continue
+		}
+		images = append(images, c.Image)
+	}
+	return conflict.Hash(managedEntries(pod.Labels, appliedLabels, recorded, v.ownedFields.labelPatterns()),
+		managedEntries(pod.Annotations, appliedAnnotations, recorded, v.ownedFields.annotationPatterns()),
+		images, pod.Spec.ActiveDeadlineSeconds)
+}
+
+// upperPodReference returns the reference of the upper pod of the lower pod in the upper namespace
+func upperPodReference(lower *corev1.Pod, namespace string) corev1.ObjectReference {
+	ref := podReference(lower)
+	ref.Namespace = namespace
+	ref.UID = getUpperUID(lower)
+	return ref
+}
+
+// observePod checks the lower pod seen in a watch event, and restores it by the upper pod if it is reverted
+// by another writer, since the upper pod is not changed and virtual-kubelet would not update it again
+func (v *VirtualK8S) observePod(lower *corev1.Pod) {
+	namespace, ok := v.upperNamespace(lower)
+	if !ok || !v.conflicts.Observed(conflictKeyOf(namespace, lower.Name), upperPodReference(lower, namespace),
+		v.lowerPodState(lower)) {
+		return
+	}
+	go func() {
+		ctx := context.TODO()
+		pod, err := v.upperPods(lower).Get(ctx, lower.Name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("Get upper pod %v/%v to restore failed: %v", lower.Namespace, lower.Name, err)
+			return
+		}
+		if pod.DeletionTimestamp != nil || !belongsTo(lower, pod) {
+			return
+		}
+		klog.V(4).Infof("Pod %v/%v reverted by another writer, restore it", lower.Namespace, lower.Name)
+		if err = v.UpdatePod(ctx, pod); err != nil {
+			klog.Errorf("Restore pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		}
+	}()
+}
+
+// reportConflicts updates the ConflictingWriters condition of the virtual node periodically, the node is only
+// notified once the conflicting objects changed
+func (v *VirtualK8S) reportConflicts(ctx context.Context) {
+	last := conflictCondition(nil).Message
+	wait.Until(func() {
+		if v.providerNode.Node == nil {
+			return
+		}
+		condition := conflictCondition(v.conflicts.Conflicting())
+		if condition.Message == last {
+			return
+		}
+		last = condition.Message
+		if condition.Status == corev1.ConditionTrue {
+			klog.Warningf("Conflicting writers in client cluster: %v", condition.Message)
+		}
+		if err := v.providerNode.SetCondition(condition); err != nil {
+			return
+		}
+		select {
+		case v.updatedNode <- v.providerNode.DeepCopy():
+		case <-ctx.Done():
+		}
+	}, conflictReportPeriod, ctx.Done())
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+)
+
+func TestConflictCondition(t *testing.T) {
+	if condition := conflictCondition(nil); condition.Status != corev1.ConditionFalse {
+		t.Fatalf("desire condition false without conflicts, real %+v", condition)
+	}
+	var keys []string
+	for i := 0; i < 7; i++ {
+		keys = append(keys, fmt.Sprintf("conflict/default/pod-%d", i))
+	}
+	condition := conflictCondition(keys)
+	if condition.Status != corev1.ConditionTrue || !strings.HasPrefix(condition.Message, "7 objects") ||
+		strings.Contains(condition.Message, "pod-5") {
+		t.Fatalf("desire condition true listing 5 of 7 objects, real %+v", condition)
+	}
+
+	node := &common.ProviderNode{Node: &corev1.Node{}}
+	node.Status.Conditions = nodeConditions()
+	if err := node.SetCondition(conflictCondition(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.SetCondition(condition); err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, c := range node.Status.Conditions {
+		if c.Type == conflictingWritersCondition {
+			found++
+			if c.Status != corev1.ConditionTrue {
+				t.Fatalf("desire condition replaced, real %+v", c)
+			}
+		}
+	}
+	if found != 1 {
+		t.Fatalf("desire one ConflictingWriters condition, real %v", found)
+	}
+}
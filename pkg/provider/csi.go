@@ -0,0 +1,104 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// csiDriverSyncPeriod is the period to discover the CSI drivers installed in lower cluster
+const csiDriverSyncPeriod = time.Minute
+
+// runCSIDrivers publishes the CSI drivers installed in lower cluster to the annotation of virtual node,
+// drivers are installed rarely, so they are listed periodically instead of watched
+func (v *VirtualK8S) runCSIDrivers(ctx context.Context) {
+	wait.Until(func() {
+		drivers, err := v.listCSIDrivers(ctx)
+		if err != nil {
+			klog.Errorf("List csi drivers failed: %v", err)
+			return
+		}
+		data, err := json.Marshal(drivers)
+		if err != nil {
+			klog.Errorf("Marshal csi drivers failed: %v", err)
+			return
+		}
+		v.setNodeAnnotation(util.CSIDrivers, string(data))
+	}, csiDriverSyncPeriod, ctx.Done())
+}
+
+// listCSIDrivers lists the CSI drivers of lower cluster, storage.k8s.io/v1beta1 is tried if v1 is not
+// served, e.g. clusters before 1.18
+func (v *VirtualK8S) listCSIDrivers(ctx context.Context) (common.CSIDrivers, error) {
+	list, err := v.client.StorageV1().CSIDrivers().List(ctx, metav1.ListOptions{})
+	if err == nil {
+		return toCSIDrivers(list.Items), nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	betaList, err := v.client.StorageV1beta1().CSIDrivers().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	drivers := make([]storagev1.CSIDriver, 0, len(betaList.Items))
+	for _, d := range betaList.Items {
+		driver := storagev1.CSIDriver{ObjectMeta: d.ObjectMeta}
+		driver.Spec.AttachRequired = d.Spec.AttachRequired
+		driver.Spec.PodInfoOnMount = d.Spec.PodInfoOnMount
+		for _, mode := range d.Spec.VolumeLifecycleModes {
+			driver.Spec.VolumeLifecycleModes = append(driver.Spec.VolumeLifecycleModes,
+				storagev1.VolumeLifecycleMode(mode))
+		}
+		drivers = append(drivers, driver)
+	}
+	return toCSIDrivers(drivers), nil
+}
+
+// toCSIDrivers converts the CSIDriver objects to the capabilities sorted by name
+func toCSIDrivers(drivers []storagev1.CSIDriver) common.CSIDrivers {
+	result := make(common.CSIDrivers, 0, len(drivers))
+	for _, d := range drivers {
+		driver := common.CSIDriver{Name: d.Name}
+		if d.Spec.AttachRequired != nil {
+			driver.AttachRequired = *d.Spec.AttachRequired
+		}
+		if d.Spec.PodInfoOnMount != nil {
+			driver.PodInfoOnMount = *d.Spec.PodInfoOnMount
+		}
+		for _, mode := range d.Spec.VolumeLifecycleModes {
+			driver.Modes = append(driver.Modes, string(mode))
+		}
+		result = append(result, driver)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
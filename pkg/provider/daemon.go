@@ -0,0 +1,168 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/tools/remotecommand"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+	kubeportforward "k8s.io/kubernetes/pkg/kubelet/server/portforward"
+	kuberemotecommand "k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+)
+
+var _ DaemonProvider = &VirtualK8S{}
+var _ DaemonProvider = &Aggregate{}
+
+// DaemonProvider is the provider serving the kubelet apis of pods on the virtual node
+type DaemonProvider interface {
+	GetPods(ctx context.Context) ([]*corev1.Pod, error)
+	GetContainerLogs(ctx context.Context, namespace, podName, containerName string,
+		opts api.ContainerLogOpts) (io.ReadCloser, error)
+	RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string,
+		attach api.AttachIO) error
+	AttachToContainer(ctx context.Context, namespace, podName, containerName string, attach api.AttachIO) error
+	PortForward(ctx context.Context, namespace, podName string, port int32, stream io.ReadWriteCloser) error
+	GetStatsSummary(ctx context.Context) (*stats.Summary, error)
+}
+
+// NewDaemonHandler returns the handler of the kubelet apis the apiserver calls for pods on the virtual node, i.e.
+// logs, exec, attach, port forward and the stats summary, all of them are proxied to lower cluster
+func NewDaemonHandler(p DaemonProvider, idleTimeout, creationTimeout time.Duration) http.Handler {
+	pods := api.PodHandler(api.PodHandlerConfig{
+		RunInContainer:        p.RunInContainer,
+		GetContainerLogs:      p.GetContainerLogs,
+		GetPods:               p.GetPods,
+		GetStatsSummary:       p.GetStatsSummary,
+		StreamIdleTimeout:     idleTimeout,
+		StreamCreationTimeout: creationTimeout,
+	}, true)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the uid of pod is optional in paths, it is not checked since lower pods have their own
+		parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		switch {
+		case parts[0] == "attach" && (len(parts) == 4 || len(parts) == 5):
+			streamOpts, err := kuberemotecommand.NewOptions(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			attacher := &containerAttacher{ctx: ctx, p: p, namespace: parts[1]}
+			kuberemotecommand.ServeAttach(w, req, attacher, parts[2], "", parts[len(parts)-1], streamOpts,
+				idleTimeout, creationTimeout, remotecommandconsts.SupportedStreamingProtocols)
+		case parts[0] == "portForward" && (len(parts) == 3 || len(parts) == 4):
+			portForwardOpts, err := kubeportforward.NewV4Options(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			forwarder := &podPortForwarder{ctx: ctx, p: p, namespace: parts[1]}
+			kubeportforward.ServePortForward(w, req, forwarder, parts[2], "", portForwardOpts, idleTimeout,
+				creationTimeout, kubeportforward.SupportedProtocols)
+		default:
+			pods.ServeHTTP(w, req)
+		}
+	})
+}
+
+// containerAttacher attaches the streams served by kubelet apis to the container in lower cluster
+type containerAttacher struct {
+	ctx       context.Context
+	p         DaemonProvider
+	namespace string
+}
+
+// AttachContainer implements kuberemotecommand.Attacher
+func (a *containerAttacher) AttachContainer(name string, uid types.UID, container string, in io.Reader,
+	out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+	attach := &attachIO{stdin: in, stdout: out, stderr: err, tty: tty}
+	if tty {
+		attach.resize = make(chan api.TermSize)
+		go func() {
+			for {
+				select {
+				case size, ok := <-resize:
+					if !ok {
+						return
+					}
+					select {
+					case attach.resize <- api.TermSize{Width: size.Width, Height: size.Height}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return a.p.AttachToContainer(ctx, a.namespace, name, container, attach)
+}
+
+// podPortForwarder forwards the streams served by kubelet apis to the pod in lower cluster
+type podPortForwarder struct {
+	ctx       context.Context
+	p         DaemonProvider
+	namespace string
+}
+
+// PortForward implements kubeportforward.PortForwarder
+func (f *podPortForwarder) PortForward(name string, uid types.UID, port int32, stream io.ReadWriteCloser) error {
+	return f.p.PortForward(f.ctx, f.namespace, name, port, stream)
+}
+
+// attachIO implements api.AttachIO
+type attachIO struct {
+	stdin          io.Reader
+	stdout, stderr io.WriteCloser
+	tty            bool
+	resize         chan api.TermSize
+}
+
+func (a *attachIO) Stdin() io.Reader {
+	return a.stdin
+}
+
+func (a *attachIO) Stdout() io.WriteCloser {
+	return a.stdout
+}
+
+func (a *attachIO) Stderr() io.WriteCloser {
+	return a.stderr
+}
+
+func (a *attachIO) TTY() bool {
+	return a.tty
+}
+
+func (a *attachIO) Resize() <-chan api.TermSize {
+	return a.resize
+}
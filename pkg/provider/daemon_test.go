@@ -0,0 +1,69 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+type fakeDaemonProvider struct {
+	DaemonProvider
+	attached string
+}
+
+func (f *fakeDaemonProvider) AttachToContainer(ctx context.Context, namespace, podName, containerName string,
+	attach api.AttachIO) error {
+	f.attached = namespace + "/" + podName + "/" + containerName
+	_, err := io.WriteString(attach.Stdout(), "hello")
+	attach.Stdout().Close()
+	return err
+}
+
+func TestDaemonHandlerAttach(t *testing.T) {
+	p := &fakeDaemonProvider{}
+	server := httptest.NewServer(NewDaemonHandler(p, time.Minute, 10*time.Second))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL + "/attach/default/test/c?output=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec, err := remotecommand.NewSPDYExecutor(&rest.Config{Host: server.URL}, "POST", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := &bytes.Buffer{}
+	if err = exec.Stream(remotecommand.StreamOptions{Stdout: stdout}); err != nil {
+		t.Fatal(err)
+	}
+	if p.attached != "default/test/c" {
+		t.Fatalf("Desire attached to default/test/c, get %v", p.attached)
+	}
+	if stdout.String() != "hello" {
+		t.Fatalf("Desire hello, get %v", stdout.String())
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// syncDeletionCost propagates the deletion cost set on the lower pod to the upper pod, the cost set on
+// the upper pod is propagated downward by UpdatePod with the other annotations, so ReplicaSets on
+// either side remove the cheapest pods when scaling down
+func (v *VirtualK8S) syncDeletionCost(ctx context.Context, new *corev1.Pod) {
+	if v.isStale(new) {
+		return
+	}
+	var value interface{}
+	if cost, ok := new.Annotations[util.PodDeletionCost]; ok {
+		value = cost
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{util.PodDeletionCost: value},
+		},
+	})
+	if err != nil {
+		return
+	}
+	_, err = v.upperPods(new).Patch(ctx, new.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Sync deletion cost of pod %v/%v failed: %v", new.Namespace, new.Name, err)
+		return
+	}
+	klog.V(4).Infof("Synced deletion cost %v of pod %v/%v", value, new.Namespace, new.Name)
+}
+
+// deletionCostChanged returns if the deletion cost differs between the pods
+func deletionCostChanged(old, new *corev1.Pod) bool {
+	oldCost, oldOK := old.Annotations[util.PodDeletionCost]
+	newCost, newOK := new.Annotations[util.PodDeletionCost]
+	return oldOK != newOK || oldCost != newCost
+}
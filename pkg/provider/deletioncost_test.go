@@ -0,0 +1,58 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestSyncDeletionCost(t *testing.T) {
+	ctx := context.Background()
+	vk, _, _ := newFakeVirtualK8S()
+	upper := fakePod("test")
+	vk.master = fake.NewSimpleClientset(upper)
+
+	old := fakePod("test")
+	lower := old.DeepCopy()
+	lower.Annotations = map[string]string{util.PodDeletionCost: "100"}
+	if !deletionCostChanged(old, lower) {
+		t.Fatal("Desire deletion cost changed")
+	}
+	vk.syncDeletionCost(ctx, lower)
+	pod, err := vk.master.CoreV1().Pods("test").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Annotations[util.PodDeletionCost] != "100" {
+		t.Fatalf("Desire deletion cost 100, get %v", pod.Annotations)
+	}
+
+	vk.syncDeletionCost(ctx, old)
+	pod, err = vk.master.CoreV1().Pods("test").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pod.Annotations[util.PodDeletionCost]; ok {
+		t.Fatalf("Desire deletion cost removed, get %v", pod.Annotations)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// WaitForDrain waits until the virtual node is annotated with util.Drain "true", the node is checked every interval
+func (v *VirtualK8S) WaitForDrain(ctx context.Context, interval time.Duration) error {
+	return wait.PollImmediateUntil(interval, func() (bool, error) {
+		node, err := v.master.CoreV1().Nodes().Get(ctx, v.nodeName, metav1.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				klog.Warningf("Get node %v failed: %v", v.nodeName, err)
+			}
+			return false, nil
+		}
+		return node.Annotations[util.Drain] == "true", nil
+	}, ctx.Done())
+}
+
+// Drain cordons the virtual node, evicts the upper pods on it by the eviction api, so that the PodDisruptionBudgets
+// of the upper cluster are respected, waits until the lower pods are cleaned up and deletes the node. Evictions
+// refused are retried every interval until ctx done. Pods of DaemonSets are not evicted, as they tolerate the
+// node unschedulable, they are deleted with the node.
+func (v *VirtualK8S) Drain(ctx context.Context, interval time.Duration) error {
+	klog.Infof("Draining node %v", v.nodeName)
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	if _, err := v.master.CoreV1().Nodes().Patch(ctx, v.nodeName, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("could not cordon node %v: %v", v.nodeName, err)
+	}
+	err := wait.PollImmediateUntil(interval, func() (bool, error) {
+		left, err := v.evictPods(ctx)
+		if err != nil {
+			klog.Warningf("Drain node %v failed, retry later: %v", v.nodeName, err)
+			return false, nil
+		}
+		klog.Infof("Draining node %v, %v pods left", v.nodeName, left)
+		return left == 0, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("could not drain node %v: %v", v.nodeName, err)
+	}
+	if err = v.master.CoreV1().Nodes().Delete(ctx, v.nodeName, metav1.DeleteOptions{}); err != nil &&
+		!errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete node %v: %v", v.nodeName, err)
+	}
+	klog.Infof("Drained and deleted node %v", v.nodeName)
+	return nil
+}
+
+// evictPods evicts the upper pods on the virtual node not deleting yet, and returns how many of the upper pods
+// and the lower pods created for them are left
+func (v *VirtualK8S) evictPods(ctx context.Context) (int, error) {
+	pods, err := v.master.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", v.nodeName).String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	left := 0
+	kept := sets.NewString()
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if isDaemonSetPod(pod) {
+			kept.Insert(string(pod.UID))
+			continue
+		}
+		left++
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		eviction := &policyv1beta1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		err = v.master.PolicyV1beta1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil:
+			klog.V(4).Infof("Evicted pod %v/%v", pod.Namespace, pod.Name)
+		case errors.IsTooManyRequests(err):
+			klog.V(4).Infof("Eviction of pod %v/%v refused by disruption budget: %v", pod.Namespace, pod.Name, err)
+		case !errors.IsNotFound(err):
+			klog.Warningf("Evict pod %v/%v failed: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	lowers, err := v.clientCache.podLister.List(labels.SelectorFromSet(labels.Set{util.VirtualPodLabel: "true"}))
+	if err != nil {
+		return 0, err
+	}
+	for _, lower := range lowers {
+		if !v.isStale(lower) && !kept.Has(string(getUpperUID(lower))) {
+			left++
+		}
+	}
+	return left, nil
+}
+
+// isDaemonSetPod tells whether the pod is controlled by a DaemonSet
+func isDaemonSetPod(pod *corev1.Pod) bool {
+	ref := metav1.GetControllerOf(pod)
+	return ref != nil && ref.Kind == "DaemonSet"
+}
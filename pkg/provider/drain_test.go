@@ -0,0 +1,82 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestDrain(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "pod"},
+		Spec: corev1.PodSpec{NodeName: "vk"}}
+	controller := true
+	daemon := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "daemon", Namespace: "default", UID: "daemon",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds", Controller: &controller}}},
+		Spec: corev1.PodSpec{NodeName: "vk"}}
+	master := fake.NewSimpleClientset(node, pod, daemon)
+	refused := false
+	master.PrependReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		// the first eviction is refused by the disruption budget
+		if !refused {
+			refused = true
+			return true, nil, errors.NewTooManyRequests("budget", 1)
+		}
+		eviction := action.(core.CreateAction).GetObject().(metav1.Object)
+		return true, nil, master.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"),
+			action.GetNamespace(), eviction.GetName())
+	})
+	vk, _, podInformer := newFakeVirtualK8S()
+	vk.master = master
+	vk.nodeName = "vk"
+	// the lower pod of the daemonSet pod is kept
+	podInformer.Informer().GetIndexer().Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "daemon",
+		Namespace: "default", Labels: map[string]string{util.VirtualPodLabel: "true"},
+		Annotations: map[string]string{util.UpperPodUID: "daemon"}}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vk.Drain(ctx, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if !refused {
+		t.Fatal("Desire eviction retried once refused")
+	}
+	if _, err := master.CoreV1().Pods("default").Get(ctx, "test", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire pod evicted, get %v", err)
+	}
+	if _, err := master.CoreV1().Pods("default").Get(ctx, "daemon", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Desire pod of daemonSet kept, get %v", err)
+	}
+	if _, err := master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire node deleted, get %v", err)
+	}
+}
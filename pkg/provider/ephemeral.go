@@ -0,0 +1,158 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/controllers"
+)
+
+// ephemeralClaimTemplate is the volumeClaimTemplate of a generic ephemeral volume, the api we
+// build with has no such volume source, so it is decoded from the raw upper pod
+type ephemeralClaimTemplate struct {
+	ObjectMeta metav1.ObjectMeta                `json:"metadata,omitempty"`
+	Spec       corev1.PersistentVolumeClaimSpec `json:"spec"`
+}
+
+// rawPodVolumes is the part of raw pod we need to decode the generic ephemeral volumes
+type rawPodVolumes struct {
+	Spec struct {
+		Volumes []struct {
+			Name      string `json:"name"`
+			Ephemeral *struct {
+				VolumeClaimTemplate *ephemeralClaimTemplate `json:"volumeClaimTemplate,omitempty"`
+			} `json:"ephemeral,omitempty"`
+		} `json:"volumes,omitempty"`
+	} `json:"spec"`
+}
+
+// hasUnknownVolumes returns if any volume of the pod has no source recognized, e.g. generic ephemeral volumes
+func hasUnknownVolumes(pod *corev1.Pod) bool {
+	for _, vol := range pod.Spec.Volumes {
+		if reflect.DeepEqual(vol.VolumeSource, corev1.VolumeSource{}) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEphemeralVolumes returns the claim templates of generic ephemeral volumes keyed by volume name
+func parseEphemeralVolumes(data []byte) (map[string]*ephemeralClaimTemplate, error) {
+	raw := rawPodVolumes{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	templates := make(map[string]*ephemeralClaimTemplate)
+	for _, vol := range raw.Spec.Volumes {
+		if vol.Ephemeral == nil || vol.Ephemeral.VolumeClaimTemplate == nil {
+			continue
+		}
+		templates[vol.Name] = vol.Ephemeral.VolumeClaimTemplate
+	}
+	return templates, nil
+}
+
+// ephemeralPVCName returns the name of pvc for the generic ephemeral volume, same as kubernetes
+func ephemeralPVCName(pod *corev1.Pod, volume string) string {
+	return pod.Name + "-" + volume
+}
+
+// prepareEphemeralVolumes replaces the generic ephemeral volumes of the basic pod with the pvcs named as
+// kubernetes does, the claim templates are returned to create the pvcs once the lower pod is created
+func (v *VirtualK8S) prepareEphemeralVolumes(ctx context.Context, pod,
+	basicPod *corev1.Pod) (map[string]*ephemeralClaimTemplate, error) {
+	if !hasUnknownVolumes(pod) {
+		return nil, nil
+	}
+	data, err := v.master.CoreV1().RESTClient().Get().Namespace(pod.Namespace).Resource("pods").
+		Name(pod.Name).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get raw pod: %v", err)
+	}
+	templates, err := parseEphemeralVolumes(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode volumes of pod: %v", err)
+	}
+	for i := range basicPod.Spec.Volumes {
+		vol := &basicPod.Spec.Volumes[i]
+		if _, ok := templates[vol.Name]; !ok {
+			continue
+		}
+		vol.VolumeSource = corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: ephemeralPVCName(pod, vol.Name),
+			},
+		}
+	}
+	return templates, nil
+}
+
+// createEphemeralPVCs creates the pvcs of generic ephemeral volumes controlled by the lower pod, so they are
+// garbage collected with it, the pvcs of other pods or being deleted are never used by the pod
+func createEphemeralPVCs(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod,
+	templates map[string]*ephemeralClaimTemplate) error {
+	controller := true
+	for volume, template := range templates {
+		name := ephemeralPVCName(pod, volume)
+		pvc, err := client.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			if pvc.DeletionTimestamp != nil {
+				return fmt.Errorf("pvc %v/%v of ephemeral volume is being deleted", pod.Namespace, name)
+			}
+			if owner := metav1.GetControllerOf(pvc); owner == nil || owner.UID != pod.UID {
+				return fmt.Errorf("pvc %v/%v was not created for pod %v", pod.Namespace, name, pod.Name)
+			}
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		pvc = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   pod.Namespace,
+				Labels:      template.ObjectMeta.Labels,
+				Annotations: template.ObjectMeta.Annotations,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       pod.Name,
+					UID:        pod.UID,
+					Controller: &controller,
+				}},
+			},
+			Spec: template.Spec,
+		}
+		controllers.SetObjectGlobal(&pvc.ObjectMeta)
+		if _, err = client.CoreV1().PersistentVolumeClaims(pod.Namespace).Create(ctx, pvc,
+			metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create pvc %v of ephemeral volume: %v", name, err)
+		}
+		klog.Infof("Create pvc %v/%v of ephemeral volume success", pod.Namespace, name)
+	}
+	return nil
+}
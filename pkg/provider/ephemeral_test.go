@@ -0,0 +1,130 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseEphemeralVolumes(t *testing.T) {
+	data := []byte(`{"spec":{"volumes":[` +
+		`{"name":"scratch","ephemeral":{"volumeClaimTemplate":{"metadata":{"labels":{"a":"b"}},` +
+		`"spec":{"accessModes":["ReadWriteOnce"],"storageClassName":"fast",` +
+		`"resources":{"requests":{"storage":"1Gi"}}}}}},` +
+		`{"name":"config","configMap":{"name":"cm"}}]}}`)
+	templates, err := parseEphemeralVolumes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 {
+		t.Fatalf("expected 1 template, got %v", len(templates))
+	}
+	template, ok := templates["scratch"]
+	if !ok {
+		t.Fatal("template of scratch not found")
+	}
+	if template.ObjectMeta.Labels["a"] != "b" || *template.Spec.StorageClassName != "fast" {
+		t.Fatalf("unexpected template %+v", template)
+	}
+	if storage := template.Spec.Resources.Requests[corev1.ResourceStorage]; storage.String() != "1Gi" {
+		t.Fatalf("unexpected storage %v", storage.String())
+	}
+
+	// volumes decoded by the typed api lose the ephemeral source
+	pod := &corev1.Pod{}
+	if err = json.Unmarshal(data, pod); err != nil {
+		t.Fatal(err)
+	}
+	if !hasUnknownVolumes(pod) {
+		t.Fatal("ephemeral volume should be unknown")
+	}
+	pod.Spec.Volumes = pod.Spec.Volumes[1:]
+	if hasUnknownVolumes(pod) {
+		t.Fatal("configmap volume should be known")
+	}
+	pod.ObjectMeta = metav1.ObjectMeta{Name: "web-0"}
+	if name := ephemeralPVCName(pod, "scratch"); name != "web-0-scratch" {
+		t.Fatalf("unexpected pvc name %v", name)
+	}
+}
+
+func TestCreateEphemeralPVCs(t *testing.T) {
+	ctx := context.Background()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default", UID: "uid"}}
+	templates := map[string]*ephemeralClaimTemplate{"scratch": {}}
+	now := metav1.Now()
+	cases := []struct {
+		name    string
+		pvc     *corev1.PersistentVolumeClaim
+		success bool
+	}{
+		{
+			name:    "pvc created",
+			success: true,
+		},
+		{
+			name: "pvc of the pod exists",
+			pvc: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Name: "web-0", UID: "uid", Controller: &[]bool{true}[0]}},
+			}},
+			success: true,
+		},
+		{
+			name: "pvc of previous pod of the same name",
+			pvc: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Name: "web-0", UID: "old", Controller: &[]bool{true}[0]}},
+			}},
+		},
+		{
+			name: "pvc being deleted",
+			pvc: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				DeletionTimestamp: &now,
+				OwnerReferences:   []metav1.OwnerReference{{Name: "web-0", UID: "uid", Controller: &[]bool{true}[0]}},
+			}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			if c.pvc != nil {
+				c.pvc.Name, c.pvc.Namespace = "web-0-scratch", "default"
+				client = fake.NewSimpleClientset(c.pvc)
+			}
+			err := createEphemeralPVCs(ctx, client, pod, templates)
+			if (err == nil) != c.success {
+				t.Fatalf("Desired success %v, get error %v", c.success, err)
+			}
+			if !c.success {
+				return
+			}
+			pvc, err := client.CoreV1().PersistentVolumeClaims("default").Get(ctx, "web-0-scratch",
+				metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owner := metav1.GetControllerOf(pvc); owner == nil || owner.UID != pod.UID {
+				t.Fatalf("Desired pvc controlled by the pod, get %v", pvc.OwnerReferences)
+			}
+		})
+	}
+}
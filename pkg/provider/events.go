@@ -0,0 +1,144 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// eventMirror records the events of lower pods on the upper pods, repeated events are aggregated into the
+// count and last timestamp of one upper event, and events of each pod are rate limited by a token bucket,
+// so that a crash looping lower pod does not flood the upper apiserver
+type eventMirror struct {
+	v        *VirtualK8S
+	recorder record.EventRecorder
+	// events happened before the mirror started are not mirrored
+	since time.Time
+}
+
+// newEventRecorder returns a recorder of the upper cluster allowing burst events of each object, refilled one
+// per interval
+func newEventRecorder(master kubernetes.Interface, host string, burst int,
+	interval time.Duration) record.EventRecorder {
+	options := record.CorrelatorOptions{BurstSize: burst}
+	if interval > 0 {
+		options.QPS = float32(1 / interval.Seconds())
+	}
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(options)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: master.CoreV1().Events(corev1.NamespaceAll)})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "virtual-kubelet", Host: host})
+}
+
+// newPodEventInformer returns an event informer of lower cluster caching events of pods only
+func newPodEventInformer(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+	return coreinformers.NewFilteredEventInformer(client, corev1.NamespaceAll, resync,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("involvedObject.kind", "Pod").String()
+		})
+}
+
+// mirrorEvents mirrors the events of lower pods to the upper pods with the recorder
+func (v *VirtualK8S) mirrorEvents(informer informers.SharedInformerFactory, recorder record.EventRecorder) {
+	m := &eventMirror{v: v, recorder: recorder, since: time.Now()}
+	informer.InformerFor(&corev1.Event{}, newPodEventInformer).AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.mirror(obj.(*corev1.Event))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, event := oldObj.(*corev1.Event), newObj.(*corev1.Event)
+			// resync delivers the same event again
+			if old.ResourceVersion == event.ResourceVersion {
+				return
+			}
+			m.mirror(event)
+		},
+	})
+}
+
+// mirror records the event on the upper pod if it is of a virtual pod, the lower scheduling failures are
+// already recorded by the provider
+func (m *eventMirror) mirror(event *corev1.Event) {
+	involved := event.InvolvedObject
+	if involved.Kind != "Pod" || event.Reason == "FailedScheduling" || eventTime(event).Before(m.since) {
+		return
+	}
+	pod, err := m.v.clientCache.podLister.Pods(involved.Namespace).Get(involved.Name)
+	if err != nil || !util.IsVirtualPod(pod) || m.v.isStale(pod) {
+		return
+	}
+	namespace, ok := m.v.upperNamespace(pod)
+	if !ok {
+		return
+	}
+	// the event is of a deleted lower pod with the same name
+	if len(involved.UID) != 0 && involved.UID != pod.UID {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       pod.Name,
+		UID:        getUpperUID(pod),
+		FieldPath:  involved.FieldPath,
+	}
+	klog.V(5).Infof("Mirror event %v/%v of pod %v/%v", event.Namespace, event.Name, namespace, pod.Name)
+	m.recorder.AnnotatedEventf(ref, eventSource(event, m.v.clusterName), event.Type, event.Reason, "%s",
+		event.Message)
+}
+
+// eventSource returns the annotations of the mirrored event telling where the lower event comes from, since the
+// source of the mirrored event is the virtual node
+func eventSource(event *corev1.Event, cluster string) map[string]string {
+	annotations := make(map[string]string, 3)
+	for key, value := range map[string]string{
+		util.EventSourceCluster:   cluster,
+		util.EventSourceComponent: event.Source.Component,
+		util.EventSourceHost:      event.Source.Host,
+	} {
+		if len(value) != 0 {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// eventTime returns the time the event happened last
+func eventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.FirstTimestamp.Time
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestMirrorEvent(t *testing.T) {
+	now := time.Now()
+	virtual := fakePod("ns")
+	virtual.UID = "lower"
+	virtual.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	setUpperUID(virtual, "upper")
+	other := fakePod("ns")
+	other.Name = "other"
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(virtual)
+	indexer.Add(other)
+	v := &VirtualK8S{clientCache: clientCache{podLister: listersv1.NewPodLister(indexer)}}
+
+	event := func(name string, uid string, reason string, last time.Time) *corev1.Event {
+		return &corev1.Event{
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "ns", Name: name, UID: types.UID(uid)},
+			Reason:         reason,
+			Message:        "Back-off restarting failed container",
+			Type:           corev1.EventTypeWarning,
+			LastTimestamp:  metav1.NewTime(last),
+		}
+	}
+	for _, c := range []struct {
+		name   string
+		event  *corev1.Event
+		mirror bool
+	}{
+		{name: "virtual pod", event: event("test", "lower", "BackOff", now), mirror: true},
+		{name: "uid not set", event: event("test", "", "BackOff", now), mirror: true},
+		{name: "deleted pod", event: event("test", "previous", "BackOff", now)},
+		{name: "not virtual pod", event: event("other", "", "BackOff", now)},
+		{name: "pod not found", event: event("none", "", "BackOff", now)},
+		{name: "before started", event: event("test", "lower", "BackOff", now.Add(-time.Minute))},
+		{name: "scheduling failure", event: event("test", "lower", "FailedScheduling", now)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			m := &eventMirror{v: v, recorder: recorder, since: now.Add(-time.Second)}
+			m.mirror(c.event)
+			if mirrored := len(recorder.Events) == 1; mirrored != c.mirror {
+				t.Fatalf("desire mirrored %v, real %v", c.mirror, mirrored)
+			}
+		})
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	event := &corev1.Event{Source: corev1.EventSource{Component: "kubelet", Host: "node-1"}}
+	annotations := eventSource(event, "cluster-a")
+	desired := map[string]string{
+		util.EventSourceCluster:   "cluster-a",
+		util.EventSourceComponent: "kubelet",
+		util.EventSourceHost:      "node-1",
+	}
+	if !reflect.DeepEqual(annotations, desired) {
+		t.Fatalf("Desire annotations %v, get %v", desired, annotations)
+	}
+	if annotations = eventSource(&corev1.Event{}, ""); len(annotations) != 0 {
+		t.Fatalf("Desire no annotations of empty source, get %v", annotations)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// Feature is an optional feature of lower clusters, which is probed when connecting to the cluster, so that
+// behaviors relying on it are skipped for clusters lacking it instead of failing
+type Feature string
+
+const (
+	// FeatureEphemeralContainers means pods could be debugged by ephemeral containers
+	FeatureEphemeralContainers Feature = "EphemeralContainers"
+	// FeatureEndpointSlices means services are served by EndpointSlices
+	FeatureEndpointSlices Feature = "EndpointSlices"
+	// FeatureSeccompDefault means kubelets could run containers with the RuntimeDefault seccomp profile by default
+	FeatureSeccompDefault Feature = "SeccompDefault"
+	// FeatureUserNamespaces means pods could run in user namespaces
+	FeatureUserNamespaces Feature = "UserNamespaces"
+	// FeatureInPlacePodVerticalScaling means pods could be resized without restarts
+	FeatureInPlacePodVerticalScaling Feature = "InPlacePodVerticalScaling"
+	// FeaturePodDeletionCost means ReplicaSets remove pods by the deletion cost in annotation when scaling down
+	FeaturePodDeletionCost Feature = "PodDeletionCost"
+	// FeaturePodDisruptionBudgetV1beta1 means PodDisruptionBudgets of policy/v1beta1 are served, which are
+	// synced by the PDB controllers
+	FeaturePodDisruptionBudgetV1beta1 Feature = "PodDisruptionBudgetV1beta1"
+	// FeatureEndpointSliceV1beta1 means EndpointSlices of discovery.k8s.io/v1beta1 are served, which are
+	// synced by the EndpointSlice controllers
+	FeatureEndpointSliceV1beta1 Feature = "EndpointSliceV1beta1"
+)
+
+// clusterFeatures is the features supported by a lower cluster
+type clusterFeatures map[Feature]bool
+
+// supports returns if the feature is supported, features are assumed supported if not probed
+func (f clusterFeatures) supports(feature Feature) bool {
+	if f == nil {
+		return true
+	}
+	return f[feature]
+}
+
+// names returns the names of features supported sorted
+func (f clusterFeatures) names() []string {
+	var names []string
+	for feature, supported := range f {
+		if supported {
+			names = append(names, string(feature))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectFeatures probes the optional features of the cluster by the api served and the version. Features
+// enabled by the kubelet configuration, e.g. SeccompDefault, are detected by the version they are available
+// since. Any probing failure only disables the feature.
+func detectFeatures(client discovery.DiscoveryInterface, gitVersion string) clusterFeatures {
+	features := clusterFeatures{}
+	serverVersion, err := version.ParseGeneric(gitVersion)
+	if err != nil {
+		klog.Warningf("Could not parse server version %v: %v", gitVersion, err)
+	}
+	atLeast := func(min string) bool {
+		return serverVersion != nil && serverVersion.AtLeast(version.MustParseGeneric(min))
+	}
+	served := func(groupVersion, resource string) bool {
+		resources, err := client.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				klog.Warningf("Could not discover %v: %v", groupVersion, err)
+			}
+			return false
+		}
+		for _, r := range resources.APIResources {
+			if r.Name == resource {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the subresource accepts pods since 1.22, it is skipped for the alpha versions before
+	features[FeatureEphemeralContainers] = atLeast("1.22.0") && served("v1", "pods/ephemeralcontainers")
+	features[FeatureEndpointSlices] = served("discovery.k8s.io/v1", "endpointslices") ||
+		served("discovery.k8s.io/v1beta1", "endpointslices")
+	features[FeatureSeccompDefault] = atLeast("1.27.0")
+	features[FeatureUserNamespaces] = atLeast("1.33.0")
+	// the resize subresource is served since 1.33, clusters from 1.27 may enable the alpha feature gate,
+	// resizing is tried for them and marked Infeasible if rejected
+	features[FeatureInPlacePodVerticalScaling] = served("v1", "pods/resize") ||
+		(atLeast("1.27.0") && !atLeast("1.33.0"))
+	features[FeaturePodDeletionCost] = atLeast("1.22.0")
+	features[FeaturePodDisruptionBudgetV1beta1] = served("policy/v1beta1", "poddisruptionbudgets")
+	features[FeatureEndpointSliceV1beta1] = served("discovery.k8s.io/v1beta1", "endpointslices")
+	klog.Infof("Features supported by cluster of version %v: %v", gitVersion, features.names())
+	return features
+}
+
+// SupportsFeature returns if the lower cluster supports the feature
+func (v *VirtualK8S) SupportsFeature(feature Feature) bool {
+	return v.features.supports(feature)
+}
+
+// setFeatureLabels labels the virtual node with the features supported, so pods requiring a feature could
+// select the clusters supporting it by node affinity
+func (v *VirtualK8S) setFeatureLabels(node *corev1.Node) {
+	for _, name := range v.features.names() {
+		node.Labels[util.FeatureLabelPrefix+name] = "true"
+	}
+}
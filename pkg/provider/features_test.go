@@ -0,0 +1,89 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestDetectFeatures(t *testing.T) {
+	cases := []struct {
+		name      string
+		version   string
+		resources []*metav1.APIResourceList
+		supported []string
+	}{
+		{
+			name:    "old cluster",
+			version: "v1.18.4",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods"}, {Name: "pods/ephemeralcontainers"}}},
+				{GroupVersion: "discovery.k8s.io/v1beta1", APIResources: []metav1.APIResource{{Name: "endpointslices"}}},
+				{GroupVersion: "policy/v1beta1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets"}}},
+			},
+			supported: []string{string(FeatureEndpointSliceV1beta1), string(FeatureEndpointSlices),
+				string(FeaturePodDisruptionBudgetV1beta1)},
+		},
+		{
+			name:    "new cluster",
+			version: "v1.33.1",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods"},
+					{Name: "pods/ephemeralcontainers"}, {Name: "pods/resize"}}},
+				{GroupVersion: "discovery.k8s.io/v1", APIResources: []metav1.APIResource{{Name: "endpointslices"}}},
+				{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets"}}},
+			},
+			supported: []string{string(FeatureEndpointSlices), string(FeatureEphemeralContainers),
+				string(FeatureInPlacePodVerticalScaling), string(FeaturePodDeletionCost), string(FeatureSeccompDefault),
+				string(FeatureUserNamespaces)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.Discovery().(*fakediscovery.FakeDiscovery).Resources = c.resources
+			features := detectFeatures(client.Discovery(), c.version)
+			if !reflect.DeepEqual(features.names(), c.supported) {
+				t.Fatalf("desire %v, real %v", c.supported, features.names())
+			}
+		})
+	}
+}
+
+func TestFeatureLabels(t *testing.T) {
+	v := &VirtualK8S{}
+	if !v.SupportsFeature(FeaturePodDisruptionBudgetV1beta1) {
+		t.Fatal("desire features supported if not probed")
+	}
+	v.features = clusterFeatures{FeatureEndpointSlices: true, FeaturePodDisruptionBudgetV1beta1: false}
+	if v.SupportsFeature(FeaturePodDisruptionBudgetV1beta1) {
+		t.Fatal("desire PDBs of policy/v1beta1 not supported")
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{}}}
+	v.setFeatureLabels(node)
+	if len(node.Labels) != 1 || node.Labels[util.FeatureLabelPrefix+string(FeatureEndpointSlices)] != "true" {
+		t.Fatalf("desire only EndpointSlices labeled, real %v", node.Labels)
+	}
+}
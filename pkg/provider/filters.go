@@ -0,0 +1,69 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+// syncFilters are the labels moved out of lower pods into their node selection and the upper namespaces whose
+// new pods are not created in the lower cluster, they are replaced as a whole when changed live
+type syncFilters struct {
+	ignoreLabels       []string
+	excludedNamespaces sets.String
+}
+
+// SetSyncFilters replaces the labels ignored and the namespaces excluded live, the pods created before are
+// synced by the new labels ignored and still updated and deleted if their namespaces are excluded
+func (v *VirtualK8S) SetSyncFilters(ignoreLabels, excludedNamespaces []string) {
+	filters := &syncFilters{ignoreLabels: ignoreLabels, excludedNamespaces: sets.NewString(excludedNamespaces...)}
+	v.syncFiltersLock.Lock()
+	defer v.syncFiltersLock.Unlock()
+	if !sets.NewString(v.filters.labels()...).Equal(sets.NewString(ignoreLabels...)) ||
+		!v.filters.namespaces().Equal(filters.excludedNamespaces) {
+		klog.Infof("Sync filters of cluster %v changed to ignored labels %v excluded namespaces %v",
+			v.clusterName, ignoreLabels, excludedNamespaces)
+	}
+	v.filters = filters
+}
+
+// syncFilters returns the current filters, nil if never set
+func (v *VirtualK8S) syncFilters() *syncFilters {
+	v.syncFiltersLock.RLock()
+	defer v.syncFiltersLock.RUnlock()
+	return v.filters
+}
+
+func (f *syncFilters) labels() []string {
+	if f == nil {
+		return nil
+	}
+	return f.ignoreLabels
+}
+
+func (f *syncFilters) namespaces() sets.String {
+	if f == nil {
+		return nil
+	}
+	return f.excludedNamespaces
+}
+
+// excludes tells whether the new pods in the upper namespace are not created in the lower cluster
+func (f *syncFilters) excludes(namespace string) bool {
+	return f.namespaces().Has(namespace)
+}
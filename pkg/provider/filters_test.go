@@ -0,0 +1,44 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSetSyncFilters(t *testing.T) {
+	vk, _, _ := newFakeVirtualK8S()
+	vk.filters = &syncFilters{ignoreLabels: []string{"batch"}}
+	vk.SetSyncFilters([]string{"batch", "zone"}, []string{"excluded"})
+	if labels := vk.syncFilters().labels(); !reflect.DeepEqual(labels, []string{"batch", "zone"}) {
+		t.Fatalf("desire labels [batch zone] ignored, get %v", labels)
+	}
+	if err := vk.createPod(context.Background(), fakePod("excluded")); err == nil {
+		t.Fatal("desire pod in excluded namespace not created")
+	}
+
+	vk.SetSyncFilters([]string{"batch"}, nil)
+	if vk.syncFilters().excludes("excluded") {
+		t.Fatal("desire namespace no longer excluded")
+	}
+	var filters *syncFilters
+	if filters.excludes("excluded") || filters.labels() != nil {
+		t.Fatal("desire nothing filtered if never set")
+	}
+}
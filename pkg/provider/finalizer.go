@@ -0,0 +1,105 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// holdUpperPod adds util.PodFinalizer to the upper pod whose lower pod is created, so that the upper pod deleted
+// is kept terminating until the lower pod is gone, even if virtual kubelet removes it once the grace period ends
+func (v *VirtualK8S) holdUpperPod(ctx context.Context, pod *corev1.Pod) {
+	if v.forceDelete || hasPodFinalizer(pod) || pod.DeletionTimestamp != nil {
+		return
+	}
+	metadata := map[string]interface{}{"finalizers": []string{util.PodFinalizer}}
+	if len(pod.UID) != 0 {
+		metadata["uid"] = pod.UID
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return
+	}
+	if _, err = v.master.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch,
+		metav1.PatchOptions{}); err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+		klog.Errorf("Add finalizer to pod %v/%v failed: %v", pod.Namespace, pod.Name, err)
+	}
+}
+
+// releaseUpperPod removes util.PodFinalizer from the upper pod of the uid, the pod of another uid is kept
+func (v *VirtualK8S) releaseUpperPod(ctx context.Context, namespace, name string, uid types.UID) {
+	if len(uid) == 0 {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":                                 uid,
+			"$deleteFromPrimitiveList/finalizers": []string{util.PodFinalizer},
+		},
+	})
+	if err != nil {
+		return
+	}
+	if _, err = v.master.CoreV1().Pods(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch,
+		metav1.PatchOptions{}); err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+		klog.Errorf("Remove finalizer from pod %v/%v failed: %v", namespace, name, err)
+		return
+	}
+	klog.V(4).Infof("Released pod %v/%v", namespace, name)
+}
+
+// releaseUpperPodOf releases the upper pod of the lower pod gone
+func (v *VirtualK8S) releaseUpperPodOf(ctx context.Context, lower *corev1.Pod) {
+	namespace, ok := v.upperNamespace(lower)
+	if !ok {
+		return
+	}
+	v.releaseUpperPod(ctx, namespace, lower.Name, getUpperUID(lower))
+}
+
+// deletionGracePeriodOf returns the grace period to delete the lower pod of the upper pod with, the one overridden
+// by flag, 0 if forced, or the one the upper pod is deleted with, nil if neither set so the default one applies
+func (v *VirtualK8S) deletionGracePeriodOf(pod *corev1.Pod) *int64 {
+	switch {
+	case v.forceDelete:
+		return new(int64)
+	case v.deletionGracePeriod != nil:
+		return v.deletionGracePeriod
+	case pod.DeletionGracePeriodSeconds != nil:
+		return pod.DeletionGracePeriodSeconds
+	}
+	return pod.Spec.TerminationGracePeriodSeconds
+}
+
+// hasPodFinalizer tells whether the pod is held by util.PodFinalizer
+func hasPodFinalizer(pod *corev1.Pod) bool {
+	for _, finalizer := range pod.Finalizers {
+		if finalizer == util.PodFinalizer {
+			return true
+		}
+	}
+	return false
+}
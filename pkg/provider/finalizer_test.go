@@ -0,0 +1,98 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestDeletionGracePeriodOf(t *testing.T) {
+	pod := fakePod("test")
+	termination, deletion, override := int64(30), int64(10), int64(5)
+	pod.Spec.TerminationGracePeriodSeconds = &termination
+	vk := &VirtualK8S{}
+	if grace := vk.deletionGracePeriodOf(pod); grace == nil || *grace != termination {
+		t.Fatalf("Desire grace period %v of the spec, get %v", termination, grace)
+	}
+	pod.DeletionGracePeriodSeconds = &deletion
+	if grace := vk.deletionGracePeriodOf(pod); grace == nil || *grace != deletion {
+		t.Fatalf("Desire grace period %v the pod deleted with, get %v", deletion, grace)
+	}
+	vk.deletionGracePeriod = &override
+	if grace := vk.deletionGracePeriodOf(pod); grace == nil || *grace != override {
+		t.Fatalf("Desire grace period %v overridden, get %v", override, grace)
+	}
+	vk.forceDelete = true
+	if grace := vk.deletionGracePeriodOf(pod); grace == nil || *grace != 0 {
+		t.Fatalf("Desire grace period 0 if forced, get %v", grace)
+	}
+}
+
+func TestTwoPhaseDelete(t *testing.T) {
+	ctx := context.Background()
+	vk, _, podInformer := newFakeVirtualK8S()
+	upper := fakePod("test")
+	upper.UID = "upper"
+	upper.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	if _, err := vk.master.CoreV1().Pods(upper.Namespace).Create(ctx, upper, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	vk.holdUpperPod(ctx, upper)
+	held, err := vk.master.CoreV1().Pods(upper.Namespace).Get(ctx, upper.Name, metav1.GetOptions{})
+	if err != nil || !hasPodFinalizer(held) {
+		t.Fatalf("Desire upper pod held by finalizer, get %v, %v", held, err)
+	}
+
+	lower := upper.DeepCopy()
+	lower.UID = "lower"
+	setUpperUID(lower, upper.UID)
+	now := metav1.Now()
+	lower.DeletionTimestamp = &now
+	if _, err = vk.client.CoreV1().Pods(lower.Namespace).Create(ctx, lower, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	podInformer.Informer().GetStore().Add(lower)
+	// virtual kubelet deletes the upper pod again with grace period 0 once the grace period ends
+	deleting := held.DeepCopy()
+	deleting.DeletionTimestamp = &now
+	deleting.DeletionGracePeriodSeconds = new(int64)
+	if err = vk.DeletePod(ctx, deleting); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vk.client.CoreV1().Pods(lower.Namespace).Get(ctx, lower.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("Desire terminating lower pod not deleted again, get %v", err)
+	}
+
+	vk.releaseUpperPodOf(ctx, lower)
+	released, err := vk.master.CoreV1().Pods(upper.Namespace).Get(ctx, upper.Name, metav1.GetOptions{})
+	if err != nil || hasPodFinalizer(released) {
+		t.Fatalf("Desire upper pod released once lower pod gone, get %v, %v", released, err)
+	}
+
+	vk.forceDelete = true
+	vk.holdUpperPod(ctx, released)
+	held, err = vk.master.CoreV1().Pods(upper.Namespace).Get(ctx, upper.Name, metav1.GetOptions{})
+	if err != nil || hasPodFinalizer(held) {
+		t.Fatalf("Desire no finalizer added if forced, get %v, %v", held, err)
+	}
+}
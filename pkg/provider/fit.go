@@ -0,0 +1,167 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// fitSummaryPeriod is the period to publish the fit summary of lower cluster
+	fitSummaryPeriod = 30 * time.Second
+	// maxFitSummaryEntries limits the size of the fit summary annotation
+	maxFitSummaryEntries = 32
+)
+
+// runFitSummary publishes the fit summary of lower cluster to the annotation of virtual node periodically,
+// so that schedulers can filter the virtual nodes without watching the lower clusters
+func (v *VirtualK8S) runFitSummary(ctx context.Context) {
+	wait.Until(func() {
+		summary, err := v.getFitSummary()
+		if err != nil {
+			klog.Errorf("Get fit summary failed: %v", err)
+			return
+		}
+		data, err := json.Marshal(summary)
+		if err != nil {
+			klog.Errorf("Marshal fit summary failed: %v", err)
+			return
+		}
+		v.setNodeAnnotation(util.FitSummary, string(data))
+	}, fitSummaryPeriod, ctx.Done())
+}
+
+// getFitSummary computes the fit summary from the free allocatable resources of ready and schedulable nodes
+func (v *VirtualK8S) getFitSummary() (common.FitSummary, error) {
+	nodes, err := v.clientCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	pods, err := v.clientCache.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	requests := make(map[string]*common.Resource)
+	podCounts := make(map[string]int64)
+	for _, pod := range pods {
+		if len(pod.Spec.NodeName) == 0 || podStopped(pod) {
+			continue
+		}
+		if _, ok := requests[pod.Spec.NodeName]; !ok {
+			requests[pod.Spec.NodeName] = common.NewResource()
+		}
+		requests[pod.Spec.NodeName].Add(util.GetRequestFromPod(pod))
+		podCounts[pod.Spec.NodeName]++
+	}
+	frees := make([]common.NodeFree, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !checkNodeStatusReady(node) {
+			continue
+		}
+		allocatable := common.ConvertResource(node.Status.Allocatable)
+		allocatable.Overcommit(v.overcommitRatios())
+		free := common.NodeFree{
+			MilliCPU: allocatable.CPU.MilliValue(),
+			Memory:   allocatable.Memory.Value(),
+			Pods:     allocatable.Pods.Value() - podCounts[node.Name],
+			Extended: allocatable.Extended(),
+		}
+		if req, ok := requests[node.Name]; ok {
+			free.MilliCPU -= req.CPU.MilliValue()
+			free.Memory -= req.Memory.Value()
+			for name, quantity := range req.Extended() {
+				if _, ok := free.Extended[name]; ok {
+					free.Extended[name] -= quantity
+				}
+			}
+		}
+		frees = append(frees, free)
+	}
+	return common.NewFitSummary(frees, maxFitSummaryEntries), nil
+}
+
+// runPhysicalCapacity publishes the capacity of lower cluster without the overcommit ratios to the annotation of
+// virtual node periodically, so that schedulers can compute the real headroom of the cluster from the usage. The usage
+// of the cluster reported by its metrics-server is published as well if installed
+func (v *VirtualK8S) runPhysicalCapacity(ctx context.Context) {
+	wait.Until(func() {
+		capacity, err := v.getPhysicalCapacity()
+		if err != nil {
+			klog.Errorf("Get physical capacity failed: %v", err)
+			return
+		}
+		v.publishResourceList(util.PhysicalCapacity, capacity)
+		usage, err := v.getPhysicalUsage(ctx)
+		if err != nil {
+			klog.V(4).Infof("Get physical usage failed: %v", err)
+			return
+		}
+		v.publishResourceList(util.PhysicalUsage, usage)
+	}, fitSummaryPeriod, ctx.Done())
+}
+
+// getPhysicalCapacity sums the cpu and memory capacity of ready and schedulable nodes
+func (v *VirtualK8S) getPhysicalCapacity() (corev1.ResourceList, error) {
+	nodes, err := v.physicalNodes()
+	if err != nil {
+		return nil, err
+	}
+	capacity := common.NewResource()
+	for _, node := range nodes {
+		capacity.Add(common.ConvertResource(node.Status.Capacity))
+	}
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    capacity.CPU,
+		corev1.ResourceMemory: capacity.Memory,
+	}, nil
+}
+
+// physicalNodes returns the ready and schedulable nodes of lower cluster
+func (v *VirtualK8S) physicalNodes() ([]*corev1.Node, error) {
+	nodes, err := v.clientCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	physical := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !checkNodeStatusReady(node) {
+			continue
+		}
+		physical = append(physical, node)
+	}
+	return physical, nil
+}
+
+// publishResourceList publishes the resources to the annotation of virtual node
+func (v *VirtualK8S) publishResourceList(key string, resources corev1.ResourceList) {
+	data, err := json.Marshal(resources)
+	if err != nil {
+		klog.Errorf("Marshal %v failed: %v", key, err)
+		return
+	}
+	v.setNodeAnnotation(key, string(data))
+}
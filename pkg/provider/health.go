@@ -0,0 +1,219 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// lowerClusterUnreachableCondition is the condition of virtual node telling if the lower cluster is
+	// unreachable, i.e. its apiserver keeps failing the probes or the informers of it are stale
+	lowerClusterUnreachableCondition corev1.NodeConditionType = "LowerClusterUnreachable"
+
+	apiserverUnreachableReason  = "ApiserverUnreachable"
+	informerStaleReason         = "InformerStale"
+	lowerClusterReachableReason = "LowerClusterReachable"
+	// lowerClusterNotReadyReason is the reason of the Ready condition once the lower cluster is unreachable
+	lowerClusterNotReadyReason = "LowerClusterUnreachable"
+)
+
+// informerHealth tracks the lists and watches of an informer of the lower cluster, it is stale once they keep
+// failing, e.g. the apiserver is reachable by the probes but the watches are broken by a proxy in between
+type informerHealth struct {
+	sync.Mutex
+	failingSince time.Time
+}
+
+// observe records the result of a list or watch
+func (h *informerHealth) observe(err error, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	if err == nil {
+		h.failingSince = time.Time{}
+		return
+	}
+	if h.failingSince.IsZero() {
+		h.failingSince = now
+	}
+}
+
+// failingFor returns how long the lists and watches have been failing, 0 if the last one succeeded
+func (h *informerHealth) failingFor(now time.Time) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.failingSince.IsZero() {
+		return 0
+	}
+	return now.Sub(h.failingSince)
+}
+
+// newObservedListWatch wraps lw, the results of lists and watches are observed by h
+func newObservedListWatch(lw cache.ListerWatcher, h *informerHealth) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			h.observe(err, time.Now())
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(options)
+			h.observe(err, time.Now())
+			return w, err
+		},
+	}
+}
+
+// healthProbe probes the apiserver of the lower cluster actively, so that the virtual node goes NotReady soon
+// after the lower cluster is unreachable, instead of pods being created against it until Ping times out
+type healthProbe struct {
+	period           time.Duration
+	timeout          time.Duration
+	failureThreshold int
+	// staleThreshold is how long the informer could fail before the lower cluster is unreachable, disabled if 0
+	staleThreshold time.Duration
+	informer       *informerHealth
+	probe          func(ctx context.Context) error
+	// failures is the consecutive failed probes, only accessed by the probe loop
+	failures int
+	lastErr  error
+}
+
+// newHealthProbe returns the probe of the /healthz of the client, nil if period is 0
+func newHealthProbe(client kubernetes.Interface, informer *informerHealth, period, timeout time.Duration,
+	failureThreshold int, staleThreshold time.Duration) *healthProbe {
+	if period <= 0 {
+		return nil
+	}
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &healthProbe{
+		period:           period,
+		timeout:          timeout,
+		failureThreshold: failureThreshold,
+		staleThreshold:   staleThreshold,
+		informer:         informer,
+		probe: func(ctx context.Context) error {
+			_, err := client.Discovery().RESTClient().Get().AbsPath("/healthz").Do(ctx).Raw()
+			return err
+		},
+	}
+}
+
+// check probes the apiserver once, returns if the probe succeeded and the LowerClusterUnreachable condition
+func (h *healthProbe) check(ctx context.Context, now time.Time) (bool, corev1.NodeCondition) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	err := h.probe(ctx)
+	if err != nil {
+		h.failures++
+		h.lastErr = err
+	} else {
+		h.failures = 0
+	}
+	var stale time.Duration
+	if h.informer != nil && h.staleThreshold > 0 {
+		stale = h.informer.failingFor(now)
+	}
+	return err == nil, h.condition(stale)
+}
+
+// condition returns the LowerClusterUnreachable condition of the failures and how long the informer is failing
+func (h *healthProbe) condition(stale time.Duration) corev1.NodeCondition {
+	condition := corev1.NodeCondition{
+		Type:               lowerClusterUnreachableCondition,
+		Status:             corev1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             lowerClusterReachableReason,
+		Message:            "apiserver of the client cluster is reachable",
+	}
+	switch {
+	case h.failures >= h.failureThreshold:
+		condition.Status, condition.Reason = corev1.ConditionTrue, apiserverUnreachableReason
+		condition.Message = fmt.Sprintf("apiserver of the client cluster failed %d probes in a row: %v",
+			h.failures, h.lastErr)
+	case h.staleThreshold > 0 && stale > h.staleThreshold:
+		condition.Status, condition.Reason = corev1.ConditionTrue, informerStaleReason
+		condition.Message = fmt.Sprintf("pods of the client cluster could not be listed or watched for %v",
+			stale.Round(time.Second))
+	}
+	return condition
+}
+
+// readyCondition returns the Ready condition of the virtual node of the LowerClusterUnreachable condition
+func readyCondition(unreachable corev1.NodeCondition) corev1.NodeCondition {
+	ready := nodeConditions()[0]
+	if unreachable.Status == corev1.ConditionTrue {
+		ready.Status, ready.Reason, ready.Message = corev1.ConditionFalse, lowerClusterNotReadyReason,
+			unreachable.Message
+	}
+	return ready
+}
+
+// runHealthProbe probes the lower cluster periodically and reports the conditions of virtual node once the
+// lower cluster turns unreachable or reachable, Ping only checks the upper cluster then, so that the lease is
+// still renewed and the conditions are published
+func (v *VirtualK8S) runHealthProbe(ctx context.Context) {
+	if v.health == nil {
+		return
+	}
+	var last corev1.NodeCondition
+	wait.Until(func() {
+		healthy, condition := v.health.check(ctx, time.Now())
+		if !healthy {
+			v.recovery.markUnhealthy()
+		} else if v.recovery.markHealthy(time.Now()) {
+			go v.reconcileAfterRecovery()
+		}
+		if v.providerNode.Node == nil || condition.Status == last.Status && condition.Reason == last.Reason {
+			return
+		}
+		last = condition
+		if condition.Status == corev1.ConditionTrue {
+			klog.Warningf("Client cluster is unreachable: %v", condition.Message)
+		} else {
+			klog.Info("Client cluster is reachable")
+		}
+		if err := v.providerNode.SetCondition(condition); err != nil {
+			return
+		}
+		if err := v.providerNode.SetCondition(readyCondition(condition)); err != nil {
+			return
+		}
+		select {
+		case v.updatedNode <- v.providerNode.DeepCopy():
+		case <-ctx.Done():
+		}
+	}, v.health.period, ctx.Done())
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestHealthProbeCheck(t *testing.T) {
+	var probeErr error
+	informer := &informerHealth{}
+	h := &healthProbe{
+		failureThreshold: 2,
+		staleThreshold:   time.Minute,
+		informer:         informer,
+		probe: func(ctx context.Context) error {
+			return probeErr
+		},
+	}
+	ctx := context.Background()
+	now := time.Now()
+	healthy, condition := h.check(ctx, now)
+	if !healthy || condition.Status != corev1.ConditionFalse {
+		t.Fatalf("Desire reachable, get %+v", condition)
+	}
+	probeErr = errors.New("connection refused")
+	healthy, condition = h.check(ctx, now)
+	if healthy || condition.Status != corev1.ConditionFalse {
+		t.Fatalf("Desire reachable below the threshold, get %+v", condition)
+	}
+	_, condition = h.check(ctx, now)
+	if condition.Status != corev1.ConditionTrue || condition.Reason != apiserverUnreachableReason {
+		t.Fatalf("Desire %v, get %+v", apiserverUnreachableReason, condition)
+	}
+	if ready := readyCondition(condition); ready.Status != corev1.ConditionFalse ||
+		ready.Reason != lowerClusterNotReadyReason {
+		t.Fatalf("Desire not ready, get %+v", ready)
+	}
+
+	probeErr = nil
+	informer.observe(errors.New("watch failed"), now.Add(-2*time.Minute))
+	_, condition = h.check(ctx, now)
+	if condition.Status != corev1.ConditionTrue || condition.Reason != informerStaleReason {
+		t.Fatalf("Desire %v, get %+v", informerStaleReason, condition)
+	}
+	informer.observe(nil, now)
+	_, condition = h.check(ctx, now)
+	if condition.Status != corev1.ConditionFalse {
+		t.Fatalf("Desire reachable, get %+v", condition)
+	}
+	if ready := readyCondition(condition); ready.Status != corev1.ConditionTrue {
+		t.Fatalf("Desire ready, get %+v", ready)
+	}
+}
+
+func TestObservedListWatch(t *testing.T) {
+	var listErr error
+	h := &informerHealth{}
+	lw := newObservedListWatch(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &corev1.PodList{}, listErr
+		},
+	}, h)
+	listErr = errors.New("list failed")
+	lw.List(metav1.ListOptions{})
+	if h.failingFor(time.Now().Add(time.Minute)) < time.Minute {
+		t.Fatal("Desire informer failing")
+	}
+	listErr = nil
+	lw.List(metav1.ListOptions{})
+	if failing := h.failingFor(time.Now()); failing != 0 {
+		t.Fatalf("Desire informer not failing, get %v", failing)
+	}
+}
@@ -17,58 +17,25 @@
 package provider
 
 import (
-	"strings"
-
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog"
-)
 
-// getSecrets filters the volumes of a pod to get only the secret volumes,
-// excluding the serviceaccount token secret which is automatically added by kuberentes.
-func getSecrets(pod *corev1.Pod) []string {
-	secretNames := []string{}
-	for _, v := range pod.Spec.Volumes {
-		switch {
-		case v.Secret != nil:
-			if strings.HasPrefix(v.Name, "default-token") {
-				continue
-			}
-			klog.Infof("pod %s depends on secret %s", pod.Name, v.Secret.SecretName)
-			secretNames = append(secretNames, v.Secret.SecretName)
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
 
-		case v.CephFS != nil:
-			klog.Infof("pod %s depends on secret %s", pod.Name, v.CephFS.SecretRef.Name)
-			secretNames = append(secretNames, v.CephFS.SecretRef.Name)
-		case v.Cinder != nil:
-			klog.Infof("pod %s depends on secret %s", pod.Name, v.Cinder.SecretRef.Name)
-			secretNames = append(secretNames, v.Cinder.SecretRef.Name)
-		case v.RBD != nil:
-			klog.Infof("pod %s depends on secret %s", pod.Name, v.RBD.SecretRef.Name)
-			secretNames = append(secretNames, v.RBD.SecretRef.Name)
-		default:
-			klog.Warning("Skip other type volumes")
-		}
-	}
-	if pod.Spec.ImagePullSecrets != nil {
-		for _, s := range pod.Spec.ImagePullSecrets {
-			secretNames = append(secretNames, s.Name)
-		}
-	}
-	klog.Infof("pod %s depends on secrets %s", pod.Name, secretNames)
-	return secretNames
+// getSecrets returns the secrets referenced by a pod and if they are optional, excluding the serviceaccount
+// token secret which is automatically added by kuberentes.
+func getSecrets(pod *corev1.Pod) map[string]bool {
+	secrets := util.GetPodReferences(pod).Secrets
+	klog.Infof("pod %s depends on secrets %v", pod.Name, secrets)
+	return secrets
 }
 
-// getConfigmaps filters the volumes of a pod to get only the configmap volumes,
-func getConfigmaps(pod *corev1.Pod) []string {
-	cmNames := []string{}
-	for _, v := range pod.Spec.Volumes {
-		if v.ConfigMap == nil {
-			continue
-		}
-		cmNames = append(cmNames, v.ConfigMap.Name)
-	}
-	klog.Infof("pod %s depends on configMap %s", pod.Name, cmNames)
-	return cmNames
+// getConfigmaps returns the configMaps referenced by a pod and if they are optional
+func getConfigmaps(pod *corev1.Pod) map[string]bool {
+	configMaps := util.GetPodReferences(pod).ConfigMaps
+	klog.Infof("pod %s depends on configMap %v", pod.Name, configMaps)
+	return configMaps
 }
 
 // getPVCs filters the volumes of a pod to get only the pvc,
@@ -104,3 +71,43 @@ func podStopped(pod *corev1.Pod) bool {
 	return (pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed) && pod.Spec.
 		RestartPolicy == corev1.RestartPolicyNever
 }
+
+// setClusterIdentity records the name and region of the lower cluster on the pod, so the envs injected by
+// webhook are populated in the lower cluster
+func (v *VirtualK8S) setClusterIdentity(pod *corev1.Pod) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[util.ClusterName] = v.clusterName
+	if len(v.clusterRegion) != 0 {
+		pod.Annotations[util.ClusterRegion] = v.clusterRegion
+	}
+}
+
+// setOriginLabels labels the lower pod with the upper cluster, namespace and uid of the upper pod, so admins
+// of the lower cluster can trace pods back to their origin by a selector
+func (v *VirtualK8S) setOriginLabels(lower, upper *corev1.Pod) {
+	if lower.Labels == nil {
+		lower.Labels = map[string]string{}
+	}
+	if len(v.upperClusterName) != 0 {
+		lower.Labels[util.OriginCluster] = v.upperClusterName
+	}
+	lower.Labels[util.OriginNamespace] = upper.Namespace
+	if len(upper.UID) != 0 {
+		lower.Labels[util.OriginPodUID] = string(upper.UID)
+	}
+}
+
+// hideClusterIdentity removes the cluster identity and origin labels from the pod reported upward
+func hideClusterIdentity(pod *corev1.Pod) {
+	if pod.Annotations != nil {
+		delete(pod.Annotations, util.ClusterName)
+		delete(pod.Annotations, util.ClusterRegion)
+	}
+	if pod.Labels != nil {
+		delete(pod.Labels, util.OriginCluster)
+		delete(pod.Labels, util.OriginNamespace)
+		delete(pod.Labels, util.OriginPodUID)
+	}
+}
@@ -21,13 +21,15 @@ import (
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/testbase"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
 func TestGetSecret(t *testing.T) {
 	pod := testbase.PodForTestWithSecret()
-	secrets := []string{"test1", "testbase"}
+	secrets := map[string]bool{"test1": false, "testbase": false}
 	real := getSecrets(pod)
 	if !reflect.DeepEqual(secrets, real) {
 		t.Fatalf("desire %v real %v", secrets, real)
@@ -36,7 +38,7 @@ func TestGetSecret(t *testing.T) {
 
 func TestGetConfimap(t *testing.T) {
 	pod := testbase.PodForTestWithConfigmap()
-	secrets := []string{"testbase"}
+	secrets := map[string]bool{"testbase": false}
 	real := getConfigmaps(pod)
 	if !reflect.DeepEqual(secrets, real) {
 		t.Fatalf("desire %v real %v", secrets, real)
@@ -137,3 +139,49 @@ func TestPodStopped(t *testing.T) {
 		}
 	}
 }
+
+func TestOriginLabels(t *testing.T) {
+	upper := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "test",
+		Namespace: "ns",
+		UID:       "upper-uid",
+		Labels:    map[string]string{"app": "test"},
+	}}
+	for _, c := range []struct {
+		name         string
+		upperCluster string
+		desire       map[string]string
+	}{
+		{
+			name: "upper cluster not set",
+			desire: map[string]string{
+				"app":                "test",
+				util.OriginNamespace: "ns",
+				util.OriginPodUID:    "upper-uid",
+			},
+		},
+		{
+			name:         "upper cluster set",
+			upperCluster: "host",
+			desire: map[string]string{
+				"app":                "test",
+				util.OriginCluster:   "host",
+				util.OriginNamespace: "ns",
+				util.OriginPodUID:    "upper-uid",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			v := &VirtualK8S{upperClusterName: c.upperCluster}
+			lower := upper.DeepCopy()
+			v.setOriginLabels(lower, upper)
+			if !reflect.DeepEqual(lower.Labels, c.desire) {
+				t.Fatalf("desire %v real %v", c.desire, lower.Labels)
+			}
+			hideClusterIdentity(lower)
+			if !reflect.DeepEqual(lower.Labels, upper.Labels) {
+				t.Fatalf("desire origin labels hidden, real %v", lower.Labels)
+			}
+		})
+	}
+}
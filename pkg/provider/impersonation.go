@@ -0,0 +1,131 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// impersonator builds clients of lower cluster impersonating the tenants of upper namespaces,
+// only users and groups allowed by the operator could be impersonated
+type impersonator struct {
+	config        *rest.Config
+	nsLister      v1.NamespaceLister
+	allowedUsers  sets.String
+	allowedGroups sets.String
+	clients       sync.Map
+}
+
+// newImpersonator returns an impersonator allowed to impersonate the users and groups given
+func newImpersonator(config *rest.Config, nsLister v1.NamespaceLister, users, groups []string) (*impersonator,
+	error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("users allowed to impersonate can not be empty")
+	}
+	for _, name := range append(append([]string{}, users...), groups...) {
+		if isSystemIdentity(name) {
+			return nil, fmt.Errorf("system identity %v can not be impersonated", name)
+		}
+	}
+	return &impersonator{
+		config:        config,
+		nsLister:      nsLister,
+		allowedUsers:  sets.NewString(users...),
+		allowedGroups: sets.NewString(groups...),
+	}, nil
+}
+
+// isSystemIdentity returns if the user or group is reserved by kubernetes
+func isSystemIdentity(name string) bool {
+	return strings.HasPrefix(name, "system:")
+}
+
+// validate checks the user and groups recorded on the namespace are allowed
+func (i *impersonator) validate(user string, groups []string) error {
+	if isSystemIdentity(user) || !i.allowedUsers.Has(user) {
+		return fmt.Errorf("user %v is not allowed to impersonate", user)
+	}
+	for _, group := range groups {
+		if isSystemIdentity(group) || !i.allowedGroups.Has(group) {
+			return fmt.Errorf("group %v is not allowed to impersonate", group)
+		}
+	}
+	return nil
+}
+
+// clientFor returns the client impersonating the tenant of the namespace in upper cluster,
+// defaultClient would be returned if no tenant recorded on the namespace
+func (i *impersonator) clientFor(namespace string, defaultClient kubernetes.Interface) (kubernetes.Interface,
+	error) {
+	ns, err := i.nsLister.Get(namespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return defaultClient, nil
+		}
+		return nil, err
+	}
+	user := ns.Annotations[util.ImpersonateUser]
+	if len(user) == 0 {
+		return defaultClient, nil
+	}
+	var groups []string
+	for _, group := range strings.Split(ns.Annotations[util.ImpersonateGroups], ",") {
+		if group = strings.TrimSpace(group); len(group) != 0 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	if err = i.validate(user, groups); err != nil {
+		return nil, fmt.Errorf("namespace %v: %v", namespace, err)
+	}
+	key := user + "/" + strings.Join(groups, ",")
+	if client, ok := i.clients.Load(key); ok {
+		return client.(kubernetes.Interface), nil
+	}
+
+	config := rest.CopyConfig(i.config)
+	config.Impersonate = rest.ImpersonationConfig{UserName: user, Groups: groups}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not build client impersonating %v: %v", user, err)
+	}
+	klog.V(4).Infof("Build client impersonating user %v groups %v", user, groups)
+	actual, _ := i.clients.LoadOrStore(key, client)
+	return actual.(kubernetes.Interface), nil
+}
+
+// podClient returns the client to operate pods of the namespace in lower cluster.
+// Only pods are written impersonating the tenant, the objects pods depend on, e.g. configmaps,
+// secrets, pvcs and services, are still synced with the client of the virtual node.
+func (v *VirtualK8S) podClient(namespace string) (kubernetes.Interface, error) {
+	if v.impersonator == nil {
+		return v.client, nil
+	}
+	return v.impersonator.clientFor(namespace, v.client)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestImpersonatorClientFor(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Annotations: map[string]string{
+		util.ImpersonateUser:   "alice",
+		util.ImpersonateGroups: "dev, ops",
+	}}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "admin", Annotations: map[string]string{
+		util.ImpersonateUser:   "alice",
+		util.ImpersonateGroups: "system:masters",
+	}}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unknown", Annotations: map[string]string{
+		util.ImpersonateUser: "bob",
+	}}})
+	i, err := newImpersonator(&rest.Config{Host: "https://127.0.0.1:6443"}, v1.NewNamespaceLister(indexer),
+		[]string{"alice"}, []string{"dev", "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultClient := fake.NewSimpleClientset()
+
+	for _, ns := range []string{"plain", "notfound"} {
+		client, err := i.clientFor(ns, defaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client != defaultClient {
+			t.Errorf("Desire default client for namespace %v", ns)
+		}
+	}
+
+	for _, ns := range []string{"admin", "unknown"} {
+		if _, err := i.clientFor(ns, defaultClient); err == nil {
+			t.Errorf("Desire error for namespace %v", ns)
+		}
+	}
+
+	client, err := i.clientFor("tenant", defaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == defaultClient {
+		t.Fatal("Desire impersonated client")
+	}
+	again, err := i.clientFor("tenant", defaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != client {
+		t.Error("Desire cached client")
+	}
+}
+
+func TestNewImpersonator(t *testing.T) {
+	cases := []struct {
+		name   string
+		users  []string
+		groups []string
+		valid  bool
+	}{
+		{name: "valid", users: []string{"alice"}, groups: []string{"dev"}, valid: true},
+		{name: "no users", groups: []string{"dev"}},
+		{name: "system user", users: []string{"system:admin"}},
+		{name: "system group", users: []string{"alice"}, groups: []string{"system:masters"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := newImpersonator(&rest.Config{}, nil, c.users, c.groups)
+			if (err == nil) != c.valid {
+				t.Errorf("Desire valid %v, got error %v", c.valid, err)
+			}
+		})
+	}
+}
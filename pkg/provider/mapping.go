@@ -0,0 +1,312 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/alert"
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// podMappingShards is the number of configMaps the pod mapping of a cluster is spread over, each holds the
+	// mapping of about ten thousand pods
+	podMappingShards = 32
+	// podMappingFlushFailedReason is the reason of alerts of shards of the pod mapping failing to be persisted
+	podMappingFlushFailedReason = "PodMappingFlushFailed"
+)
+
+// podMappingFlushPeriod is the period to persist the changed pod mapping to the upper cluster
+var podMappingFlushPeriod = 10 * time.Second
+
+// mappedPod is the upper pod of a name and the lower pod created for it
+type mappedPod struct {
+	upperUID types.UID
+	lowerUID types.UID
+}
+
+// podMapping persists the upper pod of each name and the lower pod created for it in configMaps of the upper
+// cluster per lower cluster, keyed by <namespace>_<name> of upper pods with <upper uid>/<lower uid>, the
+// underscore is never in names of namespaces and pods. The keys are spread over podMappingShards configMaps by
+// hash, only the shards changed are written and the empty ones are deleted. It is rebuilt on startup before pods
+// are synced, so the lower pods of previous upper pods are known stale without waiting for the upper pods to be
+// synced again
+type podMapping struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	cluster   string
+	alerts    *alert.Tracker
+
+	sync.Mutex
+	pods map[string]mappedPod
+	// dirty are the shards changed since persisted, legacy is set if the mapping is loaded from the single
+	// configMap of previous versions, deleted once all shards persisted
+	dirty  map[int]bool
+	legacy bool
+}
+
+// newPodMapping returns the pod mapping of the cluster persisted in the namespace, nil if the namespace is empty,
+// shards failing to be persisted are alerted to alerts
+func newPodMapping(client kubernetes.Interface, namespace, cluster string, alerts *alert.Tracker) *podMapping {
+	if len(namespace) == 0 {
+		return nil
+	}
+	return &podMapping{client: client, namespace: namespace, name: "tensile-kube-pod-mapping-" + cluster,
+		cluster: cluster, alerts: alerts, pods: make(map[string]mappedPod), dirty: make(map[int]bool)}
+}
+
+// mappingKey returns the key of the upper pod in the configMaps
+func mappingKey(namespace, name string) string {
+	return namespace + "_" + name
+}
+
+// shardOf returns the shard of the key
+func shardOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % podMappingShards)
+}
+
+// shardName returns the name of the configMap of the shard
+func (m *podMapping) shardName(shard int) string {
+	return fmt.Sprintf("%v-%d", m.name, shard)
+}
+
+// shardLabels are the labels of the configMaps of the shards of the cluster
+func (m *podMapping) shardLabels() labels.Set {
+	return labels.Set{util.PodMapping: "true", util.ClusterName: m.cluster}
+}
+
+// load reads the mapping persisted, the entries failing to be decoded are skipped. The mapping of the single
+// configMap of previous versions is migrated to the shards
+func (m *podMapping) load(ctx context.Context) error {
+	shards, err := m.client.CoreV1().ConfigMaps(m.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: m.shardLabels().String(),
+	})
+	if err != nil {
+		return err
+	}
+	legacy, err := m.client.CoreV1().ConfigMaps(m.namespace).Get(ctx, m.name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if err == nil {
+		m.parse(legacy)
+		m.legacy = true
+		for key := range m.pods {
+			m.dirty[shardOf(key)] = true
+		}
+	}
+	// the shards win over the legacy configMap, they are written since
+	for i := range shards.Items {
+		m.parse(&shards.Items[i])
+	}
+	return nil
+}
+
+func (m *podMapping) parse(cm *corev1.ConfigMap) {
+	for key, value := range cm.Data {
+		uids := strings.SplitN(value, "/", 2)
+		if len(uids) != 2 || len(uids[0]) == 0 {
+			klog.Warningf("Skip malformed pod mapping %v=%v of configmap %v/%v", key, value, cm.Namespace, cm.Name)
+			continue
+		}
+		m.pods[key] = mappedPod{upperUID: types.UID(uids[0]), lowerUID: types.UID(uids[1])}
+	}
+}
+
+// forEach calls fn with the namespace, name and uid of upper pods mapped
+func (m *podMapping) forEach(fn func(namespace, name string, uid types.UID)) {
+	m.Lock()
+	defer m.Unlock()
+	for key, pod := range m.pods {
+		if parts := strings.SplitN(key, "_", 2); len(parts) == 2 {
+			fn(parts[0], parts[1], pod.upperUID)
+		}
+	}
+}
+
+// get returns the pods mapped of the upper pod name
+func (m *podMapping) get(namespace, name string) (mappedPod, bool) {
+	m.Lock()
+	defer m.Unlock()
+	pod, ok := m.pods[mappingKey(namespace, name)]
+	return pod, ok
+}
+
+// record maps the upper pod of the name to the uids, the lower uid is kept if the upper uid is unchanged and
+// lowerUID is empty. It is a no-op if the mapping is disabled
+func (m *podMapping) record(namespace, name string, upperUID, lowerUID types.UID) {
+	if m == nil || len(upperUID) == 0 {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	key := mappingKey(namespace, name)
+	if current, ok := m.pods[key]; ok && current.upperUID == upperUID &&
+		(len(lowerUID) == 0 || current.lowerUID == lowerUID) {
+		return
+	}
+	m.pods[key] = mappedPod{upperUID: upperUID, lowerUID: lowerUID}
+	m.dirty[shardOf(key)] = true
+}
+
+// forget removes the upper pod of the name if it is still the one of the uid, it is a no-op if the mapping is
+// disabled
+func (m *podMapping) forget(namespace, name string, upperUID types.UID) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	key := mappingKey(namespace, name)
+	if current, ok := m.pods[key]; ok && current.upperUID == upperUID {
+		delete(m.pods, key)
+		m.dirty[shardOf(key)] = true
+	}
+}
+
+// run persists the mapping changed every period until stopped
+func (m *podMapping) run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := m.flush(context.TODO()); err != nil {
+			klog.Errorf("Persist pod mapping of cluster %v failed: %v", m.cluster, err)
+		}
+	}, podMappingFlushPeriod, stopCh)
+}
+
+// flush writes the shards changed, the configMaps are created if absent and deleted once empty. Shards failing
+// to be written are retried on next flush and alerted, they never block the other shards. The provider is the
+// only writer of the configMaps of its cluster, so the mapping in memory wins on conflicts
+func (m *podMapping) flush(ctx context.Context) error {
+	m.Lock()
+	shards := make(map[int]map[string]string, len(m.dirty))
+	for shard := range m.dirty {
+		shards[shard] = map[string]string{}
+	}
+	for key, pod := range m.pods {
+		if data, ok := shards[shardOf(key)]; ok {
+			data[key] = fmt.Sprintf("%v/%v", pod.upperUID, pod.lowerUID)
+		}
+	}
+	m.dirty = make(map[int]bool)
+	legacy := m.legacy
+	m.Unlock()
+
+	var errs []error
+	for shard, data := range shards {
+		name := m.shardName(shard)
+		ref := corev1.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Namespace: m.namespace, Name: name}
+		if err := m.writeShard(ctx, name, data); err != nil {
+			m.Lock()
+			m.dirty[shard] = true
+			m.Unlock()
+			metrics.ObservePodMappingFlushError(m.cluster, err)
+			m.alerts.Failed("pod-mapping/"+name, ref, podMappingFlushFailedReason, err)
+			errs = append(errs, fmt.Errorf("shard %v: %v", name, err))
+			continue
+		}
+		m.alerts.Resolved("pod-mapping/" + name)
+	}
+	if len(errs) != 0 || !legacy {
+		return utilerrors.NewAggregate(errs)
+	}
+	err := m.client.CoreV1().ConfigMaps(m.namespace).Delete(ctx, m.name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete legacy pod mapping %v: %v", m.name, err)
+	}
+	klog.Infof("Pod mapping of cluster %v migrated from configmap %v/%v", m.cluster, m.namespace, m.name)
+	m.Lock()
+	m.legacy = false
+	m.Unlock()
+	return nil
+}
+
+// writeShard writes the data to the configMap of the shard, the configMap is deleted if data is empty
+func (m *podMapping) writeShard(ctx context.Context, name string, data map[string]string) error {
+	configMaps := m.client.CoreV1().ConfigMaps(m.namespace)
+	if len(data) == 0 {
+		err := configMaps.Delete(ctx, name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: m.namespace, Labels: m.shardLabels()},
+				Data:       data,
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		cm.Data = data
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// loadPodMapping rebuilds the pod mapping persisted and seeds the uids of the latest upper pods with it, the uids
+// recorded by the pods synced since win
+func (v *VirtualK8S) loadPodMapping(ctx context.Context) error {
+	if v.podMapping == nil {
+		return nil
+	}
+	if err := v.podMapping.load(ctx); err != nil {
+		return err
+	}
+	v.podMapping.forEach(func(namespace, name string, uid types.UID) {
+		v.upperUIDs.LoadOrStore(namespace+"/"+name, uid)
+	})
+	return nil
+}
+
+// mappedToAnother returns if the lower pod is not the one mapped to its upper pod, e.g. it is re-created in the
+// lower cluster by others with the identity of the upper pod, false if no lower pod is mapped
+func (v *VirtualK8S) mappedToAnother(lower *corev1.Pod) bool {
+	if v.podMapping == nil || len(lower.UID) == 0 {
+		return false
+	}
+	namespace, _ := v.upperNamespace(lower)
+	mapped, ok := v.podMapping.get(namespace, lower.Name)
+	return ok && len(mapped.lowerUID) != 0 && mapped.upperUID == getUpperUID(lower) && mapped.lowerUID != lower.UID
+}
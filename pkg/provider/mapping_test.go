@@ -0,0 +1,153 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestPodMapping(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	mapping := newPodMapping(client, "kube-system", "c1", nil)
+	mapping.record("default", "web-0", "upper-1", "lower-1")
+	mapping.record("default", "web-0", "upper-1", "")
+	mapping.record("default", "gone", "upper-2", "lower-2")
+	mapping.forget("default", "gone", "upper-2")
+	if err := mapping.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	shard := mapping.shardName(shardOf(mappingKey("default", "web-0")))
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get(ctx, shard, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.Data) != 1 || cm.Data["default_web-0"] != "upper-1/lower-1" {
+		t.Fatalf("Unexpected mapping persisted %v", cm.Data)
+	}
+	if gone := mapping.shardName(shardOf(mappingKey("default", "gone"))); gone != shard {
+		if _, err = client.CoreV1().ConfigMaps("kube-system").Get(ctx, gone, metav1.GetOptions{}); !apierrs.IsNotFound(err) {
+			t.Fatalf("Desire empty shard %v deleted, get %v", gone, err)
+		}
+	}
+
+	// the mapping is rebuilt by the restarted provider before the upper pods are synced
+	v := &VirtualK8S{podMapping: newPodMapping(client, "kube-system", "c1", nil)}
+	if err = v.loadPodMapping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newLower := func(upperUID, lowerUID types.UID) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default", UID: lowerUID,
+			Annotations: map[string]string{util.UpperPodUID: string(upperUID)}}}
+	}
+	cases := []struct {
+		name  string
+		lower *corev1.Pod
+		stale bool
+	}{
+		{name: "mapped", lower: newLower("upper-1", "lower-1")},
+		{name: "previous upper pod", lower: newLower("upper-0", "lower-0"), stale: true},
+		{name: "re-created by others", lower: newLower("upper-1", "lower-3"), stale: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if stale := v.isStale(c.lower); stale != c.stale {
+				t.Fatalf("Desire stale %v, get %v", c.stale, stale)
+			}
+		})
+	}
+
+	// the pod synced since wins over the mapping persisted
+	v.recordUpperUID(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "default", UID: "upper-4"}})
+	if !v.isStale(newLower("upper-1", "lower-1")) {
+		t.Fatal("Desire the lower pod of the previous upper pod stale")
+	}
+	if err = v.podMapping.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cm, _ = client.CoreV1().ConfigMaps("kube-system").Get(ctx, shard, metav1.GetOptions{})
+	if cm.Data["default_web-0"] != "upper-4/" {
+		t.Fatalf("Unexpected mapping persisted %v", cm.Data)
+	}
+}
+
+func TestPodMappingMigration(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tensile-kube-pod-mapping-c1", Namespace: "kube-system"},
+		Data:       map[string]string{"default_web-0": "upper-1/lower-1", "default_web-1": "upper-2/lower-2"},
+	})
+	mapping := newPodMapping(client, "kube-system", "c1", nil)
+	if err := mapping.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapping.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().ConfigMaps("kube-system").Get(ctx, "tensile-kube-pod-mapping-c1",
+		metav1.GetOptions{}); !apierrs.IsNotFound(err) {
+		t.Fatalf("Desire legacy configmap deleted, get %v", err)
+	}
+	reloaded := newPodMapping(client, "kube-system", "c1", nil)
+	if err := reloaded.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, uid := range map[string]types.UID{"web-0": "lower-1", "web-1": "lower-2"} {
+		if pod, ok := reloaded.get("default", name); !ok || pod.lowerUID != uid {
+			t.Fatalf("Desire %v mapped to %v, get %+v", name, uid, pod)
+		}
+	}
+}
+
+func TestPodMappingFlushFailure(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	failing := true
+	client.PrependReactor("create", "configmaps", func(action core.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, fmt.Errorf("etcd unavailable")
+		}
+		return false, nil, nil
+	})
+	mapping := newPodMapping(client, "kube-system", "c1", nil)
+	mapping.record("default", "web-0", "upper-1", "lower-1")
+	if err := mapping.flush(ctx); err == nil {
+		t.Fatal("Desire flush failed")
+	}
+	if !mapping.dirty[shardOf(mappingKey("default", "web-0"))] {
+		t.Fatal("Desire the shard failed kept dirty")
+	}
+	failing = false
+	if err := mapping.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(mapping.dirty) != 0 {
+		t.Fatalf("Desire all shards persisted, get dirty %v", mapping.dirty)
+	}
+}
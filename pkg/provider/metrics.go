@@ -18,71 +18,165 @@ package provider
 
 import (
 	"context"
-	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
 
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
-// GetStatsSummary summaries the cluster metrics which represented by the provider
+// GetStatsSummary summaries the usage of pods on the virtual node reported by metrics-server of lower cluster, so
+// that metrics-server of upper cluster scraping the summary serves `kubectl top` of the upper pods
 func (v *VirtualK8S) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
 	var summary stats.Summary
-	selector := labels.SelectorFromSet(map[string]string{
-		util.VirtualPodLabel: "true"},
-	)
-	metrics, err := v.metricClient.MetricsV1beta1().PodMetricses(corev1.NamespaceAll).List(ctx,
-		v1.ListOptions{
-			LabelSelector: selector.String(),
-		})
-	if err != nil {
-		return nil, err
-	}
 	var cpuAll, memoryAll uint64
-	var time time.Time
-	for _, metric := range metrics.Items {
-		podStats := convert2PodStats(&metric)
+	var latest v1.Time
+	err := v.forEachUpperPodMetrics(ctx, func(metric *v1beta1.PodMetrics, lower *corev1.Pod, namespace string) {
+		podStats := convert2PodStats(metric, lower)
+		podStats.PodRef.Namespace = namespace
 		summary.Pods = append(summary.Pods, *podStats)
 		cpuAll += *podStats.CPU.UsageNanoCores
 		memoryAll += *podStats.Memory.WorkingSetBytes
-		if time.IsZero() {
-			time = podStats.StartTime.Time
+		if metric.Timestamp.After(latest.Time) {
+			latest = metric.Timestamp
 		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if latest.IsZero() {
+		latest = v1.Now()
 	}
 	summary.Node = stats.NodeStats{
-		NodeName:  v.providerNode.Name,
-		StartTime: v1.Time{Time: time},
+		StartTime: v.startTime,
 		CPU: &stats.CPUStats{
-			Time:           v1.Time{Time: time},
+			Time:           latest,
 			UsageNanoCores: &cpuAll,
 		},
 		Memory: &stats.MemoryStats{
-			Time:            v1.Time{Time: time},
+			Time:            latest,
 			WorkingSetBytes: &memoryAll,
 		},
 	}
+	if v.providerNode.Node != nil {
+		summary.Node.NodeName = v.providerNode.Name
+	}
 	return &summary, nil
 }
 
-func convert2PodStats(metric *v1beta1.PodMetrics) *stats.PodStats {
+// forEachUpperPodMetrics calls fn with the metrics of virtual pods reported by metrics-server of lower cluster, the
+// lower pods and the namespaces of their upper pods. The usage of pods gone or replaced is not of the upper pods and
+// skipped
+func (v *VirtualK8S) forEachUpperPodMetrics(ctx context.Context,
+	fn func(metric *v1beta1.PodMetrics, lower *corev1.Pod, namespace string)) error {
+	selector := labels.SelectorFromSet(map[string]string{
+		util.VirtualPodLabel: "true"},
+	)
+	podMetrics, err := v.metricClient.MetricsV1beta1().PodMetricses(corev1.NamespaceAll).List(ctx,
+		v1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+	if err != nil {
+		return err
+	}
+	for i := range podMetrics.Items {
+		metric := &podMetrics.Items[i]
+		lower, err := v.clientCache.podLister.Pods(metric.Namespace).Get(metric.Name)
+		if err != nil || v.isStale(lower) {
+			continue
+		}
+		namespace, ok := v.upperNamespace(lower)
+		if !ok {
+			continue
+		}
+		fn(metric, lower, namespace)
+	}
+	return nil
+}
+
+// runOffloadedPodMetrics records the usage of upper pods running in lower cluster as prometheus metrics every
+// period, which prometheus-adapter serves by the custom metrics API to HPAs of upper cluster. The usage of pods
+// no longer reported is dropped
+func (v *VirtualK8S) runOffloadedPodMetrics(ctx context.Context) {
+	recorded := make(map[types.NamespacedName]bool)
+	wait.Until(func() {
+		if v.providerNode.Node == nil {
+			return
+		}
+		current, err := v.recordOffloadedPodUsage(ctx, v.providerNode.Name, recorded)
+		if err != nil {
+			klog.Errorf("List metrics of pods in cluster %v failed: %v", v.clusterName, err)
+			return
+		}
+		recorded = current
+	}, v.offloadedPodMetricsPeriod, ctx.Done())
+}
+
+// recordOffloadedPodUsage records the usage of upper pods on the node and drops the recorded ones not reported
+// anymore, the pods recorded are returned
+func (v *VirtualK8S) recordOffloadedPodUsage(ctx context.Context, node string,
+	recorded map[types.NamespacedName]bool) (map[types.NamespacedName]bool, error) {
+	current := make(map[types.NamespacedName]bool, len(recorded))
+	err := v.forEachUpperPodMetrics(ctx, func(metric *v1beta1.PodMetrics, lower *corev1.Pod, namespace string) {
+		cpu, memory := resource.Quantity{}, resource.Quantity{}
+		for _, c := range metric.Containers {
+			cpu.Add(*c.Usage.Cpu())
+			memory.Add(*c.Usage.Memory())
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: lower.Name}
+		current[key] = true
+		metrics.SetOffloadedPodUsage(v.clusterName, node, key.Namespace, key.Name, float64(cpu.MilliValue())/1000,
+			float64(memory.Value()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key := range recorded {
+		if !current[key] {
+			metrics.DeleteOffloadedPodUsage(v.clusterName, node, key.Namespace, key.Name)
+		}
+	}
+	return current, nil
+}
+
+// convert2PodStats converts the metrics of the lower pod to the stats of the upper pod, the start times are the
+// ones of the lower pod and its containers
+func convert2PodStats(metric *v1beta1.PodMetrics, lower *corev1.Pod) *stats.PodStats {
 	stat := &stats.PodStats{}
 	if metric == nil {
 		return nil
 	}
 	stat.PodRef.Namespace = metric.Namespace
 	stat.PodRef.Name = metric.Name
+	stat.PodRef.UID = string(getUpperUID(lower))
 	stat.StartTime = metric.Timestamp
+	if lower.Status.StartTime != nil {
+		stat.StartTime = *lower.Status.StartTime
+	}
+	startedAt := make(map[string]v1.Time, len(lower.Status.ContainerStatuses))
+	for _, status := range lower.Status.ContainerStatuses {
+		if status.State.Running != nil {
+			startedAt[status.Name] = status.State.Running.StartedAt
+		}
+	}
 
-	containerStats := stats.ContainerStats{}
 	var cpuAll, memoryAll uint64
 	for _, c := range metric.Containers {
-		containerStats.StartTime = metric.Timestamp
-		containerStats.Name = c.Name
+		containerStats := stats.ContainerStats{
+			Name:      c.Name,
+			StartTime: metric.Timestamp,
+		}
+		if started, ok := startedAt[c.Name]; ok {
+			containerStats.StartTime = started
+		}
 		nanoCore := uint64(c.Usage.Cpu().ScaledValue(resource.Nano))
 		memory := uint64(c.Usage.Memory().Value())
 		containerStats.CPU = &stats.CPUStats{
@@ -107,3 +201,32 @@ func convert2PodStats(metric *v1beta1.PodMetrics) *stats.PodStats {
 	}
 	return stat
 }
+
+// getPhysicalUsage sums the cpu and memory usage of ready and schedulable nodes reported by metrics-server of
+// lower cluster
+func (v *VirtualK8S) getPhysicalUsage(ctx context.Context) (corev1.ResourceList, error) {
+	nodes, err := v.physicalNodes()
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := v.metricClient.MetricsV1beta1().NodeMetricses().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	counted := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		counted[node.Name] = true
+	}
+	cpu, memory := resource.Quantity{}, resource.Quantity{}
+	for _, metric := range metrics.Items {
+		if !counted[metric.Name] {
+			continue
+		}
+		cpu.Add(*metric.Usage.Cpu())
+		memory.Add(*metric.Usage.Memory())
+	}
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    cpu,
+		corev1.ResourceMemory: memory,
+	}, nil
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestGetStatsSummary(t *testing.T) {
+	timestamp := metav1.NewTime(time.Now().Truncate(time.Second))
+	started := metav1.NewTime(timestamp.Add(-time.Hour))
+	newMetrics := func(name string) v1beta1.PodMetrics {
+		return v1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Timestamp:  timestamp,
+			Containers: []v1beta1.ContainerMetrics{{Name: "c", Usage: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("1Mi"),
+			}}},
+		}
+	}
+	metricClient := metricsfake.NewSimpleClientset()
+	metricClient.PrependReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		// the pod without lower pod is gone
+		return true, &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{newMetrics("test"),
+			newMetrics("gone")}}, nil
+	})
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+			Annotations: map[string]string{util.UpperPodUID: "upper-uid"}},
+		Status: corev1.PodStatus{
+			StartTime: &started,
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "c", State: corev1.ContainerState{
+				Running: &corev1.ContainerStateRunning{StartedAt: started}}}},
+		},
+	})
+	v := &VirtualK8S{metricClient: metricClient, providerNode: &common.ProviderNode{},
+		clientCache: clientCache{podLister: listersv1.NewPodLister(indexer)}}
+
+	summary, err := v.GetStatsSummary(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Pods) != 1 {
+		t.Fatalf("Desire 1 pod, get %v", len(summary.Pods))
+	}
+	pod := summary.Pods[0]
+	if pod.PodRef.UID != "upper-uid" || !pod.StartTime.Equal(&started) ||
+		!pod.Containers[0].StartTime.Equal(&started) {
+		t.Fatalf("Unexpected pod stats %+v", pod)
+	}
+	if *pod.CPU.UsageNanoCores != 100000000 || *summary.Node.Memory.WorkingSetBytes != 1024*1024 {
+		t.Fatalf("Desire 100m cpu and 1Mi memory, get %v, %v", *pod.CPU.UsageNanoCores,
+			*summary.Node.Memory.WorkingSetBytes)
+	}
+	if !summary.Node.CPU.Time.Equal(&timestamp) {
+		t.Fatalf("Desire node stats at %v, get %v", timestamp, summary.Node.CPU.Time)
+	}
+}
+
+func TestRecordOffloadedPodUsage(t *testing.T) {
+	metrics.Register()
+	metricClient := metricsfake.NewSimpleClientset()
+	metricClient.PrependReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Containers: []v1beta1.ContainerMetrics{
+				{Name: "a", Usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"),
+					corev1.ResourceMemory: resource.MustParse("1Ki")}},
+				{Name: "b", Usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"),
+					corev1.ResourceMemory: resource.MustParse("1Ki")}},
+			},
+		}}}, nil
+	})
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}})
+	v := &VirtualK8S{metricClient: metricClient, clusterName: "c1",
+		clientCache: clientCache{podLister: listersv1.NewPodLister(indexer)}}
+
+	gone := types.NamespacedName{Namespace: "default", Name: "gone"}
+	metrics.SetOffloadedPodUsage("c1", "vk", gone.Namespace, gone.Name, 1, 1)
+	recorded, err := v.recordOffloadedPodUsage(context.Background(), "vk", map[types.NamespacedName]bool{gone: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || !recorded[types.NamespacedName{Namespace: "default", Name: "test"}] {
+		t.Fatalf("Desire pod test recorded, get %v", recorded)
+	}
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`tensile_kube_offloaded_pod_cpu_usage{cluster="c1",namespace="default",node="vk",pod="test"} 0.5`,
+		`tensile_kube_offloaded_pod_memory_usage_bytes{cluster="c1",namespace="default",node="vk",pod="test"} 2048`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Desire %v in metrics", line)
+		}
+	}
+	if strings.Contains(body, `pod="gone"`) {
+		t.Error("Desire usage of pod gone dropped")
+	}
+}
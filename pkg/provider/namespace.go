@@ -0,0 +1,55 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// lowerNamespace returns the namespace in the lower cluster of the upper namespace
+func (v *VirtualK8S) lowerNamespace(namespace string) string {
+	return v.namespaces.ToLower(namespace)
+}
+
+// upperNamespace returns the namespace of the upper pod of the lower pod, recorded by label util.OriginNamespace
+// or translated back from the namespace of the lower pod, false if the lower pod is not created from the upper
+// cluster, e.g. by another upper cluster sharing the lower cluster
+func (v *VirtualK8S) upperNamespace(lower *corev1.Pod) (string, bool) {
+	if namespace := lower.Labels[util.OriginNamespace]; len(namespace) != 0 {
+		return namespace, v.lowerNamespace(namespace) == lower.Namespace
+	}
+	return v.namespaces.ToUpper(lower.Namespace)
+}
+
+// restoreNamespace sets the namespace of the lower pod reported upward back to the upper one, false if the pod is
+// not created from the upper cluster
+func (v *VirtualK8S) restoreNamespace(pod *corev1.Pod) bool {
+	namespace, ok := v.upperNamespace(pod)
+	if ok {
+		pod.Namespace = namespace
+	}
+	return ok
+}
+
+// upperPods returns the client of the pods in the upper namespace of the lower pod
+func (v *VirtualK8S) upperPods(lower *corev1.Pod) typedcorev1.PodInterface {
+	namespace, _ := v.upperNamespace(lower)
+	return v.master.CoreV1().Pods(namespace)
+}
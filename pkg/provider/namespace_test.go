@@ -0,0 +1,68 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestUpperNamespace(t *testing.T) {
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	namespaces, err := util.NewNamespaceMapping("a-", map[string]string{"prod": "team-prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vk.namespaces = namespaces
+	newPod := func(namespace, origin string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: namespace}}
+		if len(origin) != 0 {
+			pod.Labels = map[string]string{util.OriginNamespace: origin}
+		}
+		return pod
+	}
+
+	cases := []struct {
+		name      string
+		pod       *corev1.Pod
+		namespace string
+		belongs   bool
+	}{
+		{name: "prefixed", pod: newPod("a-default", ""), namespace: "default", belongs: true},
+		{name: "mapped", pod: newPod("team-prod", ""), namespace: "prod", belongs: true},
+		{name: "labeled", pod: newPod("team-prod", "prod"), namespace: "prod", belongs: true},
+		{name: "other cluster", pod: newPod("b-default", ""), belongs: false},
+		{name: "label of other cluster", pod: newPod("b-default", "default"), belongs: false},
+	}
+	for _, c := range cases {
+		namespace, ok := vk.upperNamespace(c.pod)
+		if ok != c.belongs || ok && namespace != c.namespace {
+			t.Fatalf("%v: desire namespace %q belonging %v, get %q, %v", c.name, c.namespace, c.belongs,
+				namespace, ok)
+		}
+		if restored := vk.restoreNamespace(c.pod); restored && c.pod.Namespace != c.namespace {
+			t.Fatalf("%v: desire pod restored to %q, get %q", c.name, c.namespace, c.pod.Namespace)
+		}
+	}
+	if namespace := vk.lowerNamespace("prod"); namespace != "team-prod" {
+		t.Fatalf("Desire namespace prod mapped to team-prod, get %v", namespace)
+	}
+}
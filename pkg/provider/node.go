@@ -18,52 +18,67 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
+var (
+	// nodeStatusCoalescePeriod is the period node changes are merged in before notified
+	nodeStatusCoalescePeriod = time.Second
+	// nodeStatusJitterFactor spreads the notifications of virtual nodes started at the same time
+	nodeStatusJitterFactor = 1.0
+	// nodeMetadataSyncPeriod is the period to retry patching the node metadata
+	nodeMetadataSyncPeriod = 5 * time.Second
+)
+
 // ConfigureNode enables a provider to configure the node object that
 // will be used for Kubernetes.
 func (v *VirtualK8S) ConfigureNode(ctx context.Context, node *corev1.Node) {
-	nodes, err := v.clientCache.nodeLister.List(labels.Everything())
+	nodeResource, err := v.getNodeResource()
 	if err != nil {
 		return
 	}
-
-	nodeResource := common.NewResource()
-
-	for _, n := range nodes {
-		if n.Spec.Unschedulable {
-			continue
-		}
-		if !checkNodeStatusReady(n) {
-			klog.Infof("Node %v not ready", node.Name)
-			continue
-		}
-		nc := common.ConvertResource(n.Status.Capacity)
-		nodeResource.Add(nc)
-	}
-	podResource := v.getResourceFromPods()
-	nodeResource.Sub(podResource)
 	nodeResource.SetCapacityToNode(node)
+	v.setOvercommitAnnotations(node)
 	node.Status.NodeInfo.KubeletVersion = v.version
 	node.Status.NodeInfo.OperatingSystem = "linux"
 	node.Status.NodeInfo.Architecture = "amd64"
 	node.ObjectMeta.Labels[corev1.LabelArchStable] = "amd64"
 	node.ObjectMeta.Labels[corev1.LabelOSStable] = "linux"
 	node.ObjectMeta.Labels[util.LabelOSBeta] = "linux"
+	v.setFeatureLabels(node)
+	if len(v.networkZone) != 0 {
+		node.ObjectMeta.Labels[util.NetworkZone] = v.networkZone
+	}
+	// topology spread constraints across clusters are keyed on it
+	if len(validation.IsValidLabelValue(v.clusterName)) == 0 {
+		node.ObjectMeta.Labels[util.ClusterName] = v.clusterName
+	}
 	node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: os.Getenv("VKUBELET_POD_IP")}}
 	node.Status.Conditions = nodeConditions()
+	if v.health != nil {
+		node.Status.Conditions = append(node.Status.Conditions, v.health.condition(0))
+	}
+	v.reportVersionSkew(node)
+	node.Status.Conditions = append(node.Status.Conditions, conflictCondition(v.conflicts.Conflicting()))
 	node.Status.DaemonEndpoints = v.nodeDaemonEndpoints()
 	v.providerNode.Node = node
 	v.configured = true
@@ -79,11 +94,19 @@ func (v *VirtualK8S) Ping(ctx context.Context) error {
 		klog.Error("Failed ping")
 		return fmt.Errorf("could not list master apiserver statuses: %v", err)
 	}
+	if v.health != nil {
+		// the client cluster is probed by runHealthProbe and reported by node conditions
+		return nil
+	}
 	_, err = v.client.Discovery().ServerVersion()
 	if err != nil {
 		klog.Error("Failed ping")
+		v.recovery.markUnhealthy()
 		return fmt.Errorf("could not list client apiserver statuses: %v", err)
 	}
+	if v.recovery.markHealthy(time.Now()) {
+		go v.reconcileAfterRecovery()
+	}
 	return nil
 }
 
@@ -96,19 +119,228 @@ func (v *VirtualK8S) Ping(ctx context.Context) error {
 // NotifyNodeStatus should not block callers.
 func (v *VirtualK8S) NotifyNodeStatus(ctx context.Context, f func(*corev1.Node)) {
 	klog.Info("Called NotifyNodeStatus")
-	go func() {
-		for {
-			select {
-			case node := <-v.updatedNode:
-				klog.Infof("Enqueue updated node %v", node.Name)
-				f(node)
-			case <-v.stopCh:
-				return
-			case <-ctx.Done():
-				return
+	go v.coalesceNodeStatus(ctx, f)
+	go v.runCapacitySync(ctx)
+	go v.runHealthProbe(ctx)
+	if v.upperServiceAccountTokens {
+		go v.runUpperTokenRotation(ctx)
+	}
+	if v.rescheduleAfter > 0 {
+		go v.runRescheduling(ctx)
+	}
+	if v.nodeStatusOnly {
+		return
+	}
+	go v.syncNodeMetadata(ctx)
+	go v.runFitSummary(ctx)
+	go v.runPhysicalCapacity(ctx)
+	go v.runReservedResources(ctx)
+	go v.runCSIDrivers(ctx)
+	go v.runStorageClasses(ctx)
+	go v.runStorageCapacity(ctx)
+	go v.runQuotaHeadroom(ctx)
+	go v.runNodeTaints(ctx)
+	go v.runSchedulableNodes(ctx)
+	go v.runNodePropagation(ctx)
+	if v.offloadedPodMetricsPeriod > 0 {
+		go v.runOffloadedPodMetrics(ctx)
+	}
+	go v.reportConflicts(ctx)
+}
+
+// coalesceNodeStatus merges the node changes within a jittered period and only notifies
+// the latest one, so that bursts of pod and node events in lower cluster do not
+// result in bursts of writes to the node object of upper cluster
+func (v *VirtualK8S) coalesceNodeStatus(ctx context.Context, f func(*corev1.Node)) {
+	var (
+		pending *corev1.Node
+		timer   *time.Timer
+		fire    <-chan time.Time
+	)
+	for {
+		select {
+		case node := <-v.updatedNode:
+			if pending == nil {
+				timer = time.NewTimer(wait.Jitter(nodeStatusCoalescePeriod, nodeStatusJitterFactor))
+				fire = timer.C
 			}
+			pending = node
+		case <-fire:
+			klog.Infof("Enqueue updated node %v", pending.Name)
+			f(pending)
+			pending, fire = nil, nil
+		case <-v.stopCh:
+			stopTimer(timer)
+			return
+		case <-ctx.Done():
+			stopTimer(timer)
+			return
+		}
+	}
+}
+
+// syncNodeMetadata patches the labels and annotations configured by provider to the node of upper cluster,
+// the node controller only sets them when creating the node, so they would be lost once the node exists.
+// It is the only writer of the node metadata, the annotations published by other loops are merged in and
+// patched together, and the drift made by other writers is fixed in the next period
+func (v *VirtualK8S) syncNodeMetadata(ctx context.Context) {
+	wait.Until(func() {
+		desired := v.desiredNodeMetadata()
+		if desired == nil {
+			return
 		}
-	}()
+		if err := v.patchNodeMetadata(ctx, desired); err != nil {
+			klog.Errorf("Patch metadata of node %v failed: %v", desired.Name, err)
+		}
+	}, nodeMetadataSyncPeriod, ctx.Done())
+}
+
+// setNodeAnnotation publishes the annotation to be patched to the node of upper cluster
+func (v *VirtualK8S) setNodeAnnotation(key, value string) {
+	v.nodeAnnotationsLock.Lock()
+	defer v.nodeAnnotationsLock.Unlock()
+	if v.nodeAnnotations == nil {
+		v.nodeAnnotations = make(map[string]string)
+	}
+	v.nodeAnnotations[key] = value
+}
+
+// setNodeLabels replaces the published labels of the prefix by labels, the ones absent from labels are removed
+// from the node of upper cluster by patchNodeMetadata
+func (v *VirtualK8S) setNodeLabels(prefix string, labels map[string]string) {
+	v.nodeAnnotationsLock.Lock()
+	defer v.nodeAnnotationsLock.Unlock()
+	if v.nodeLabels == nil {
+		v.nodeLabels = make(map[string]string)
+	}
+	for k := range v.nodeLabels {
+		if strings.HasPrefix(k, prefix) {
+			delete(v.nodeLabels, k)
+		}
+	}
+	for k, value := range labels {
+		v.nodeLabels[k] = value
+	}
+}
+
+// desiredNodeMetadata returns the provider node with the published annotations and labels merged,
+// nil would be returned if the node is not configured yet
+func (v *VirtualK8S) desiredNodeMetadata() *corev1.Node {
+	desired := v.providerNode.DeepCopy()
+	if desired == nil {
+		return nil
+	}
+	if desired.Annotations == nil {
+		desired.Annotations = make(map[string]string)
+	}
+	if desired.Labels == nil {
+		desired.Labels = make(map[string]string)
+	}
+	v.nodeAnnotationsLock.Lock()
+	for k, value := range v.nodeAnnotations {
+		desired.Annotations[k] = value
+	}
+	for k, value := range v.nodeLabels {
+		desired.Labels[k] = value
+	}
+	v.nodeAnnotationsLock.Unlock()
+	// the labels and annotations set by provider win over the propagated ones
+	v.propagationLock.Lock()
+	if v.propagated != nil {
+		mergeAbsent(desired.Labels, v.propagated.Labels)
+		mergeAbsent(desired.Annotations, v.propagated.Annotations)
+	}
+	v.propagationLock.Unlock()
+	return desired
+}
+
+// patchNodeMetadata merges the labels and annotations of desired into the node of upper cluster, as well as the
+// cluster taints recorded in the annotation, the resourceVersion is carried in the patch, so it is retried on
+// conflicts with other writers
+func (v *VirtualK8S) patchNodeMetadata(ctx context.Context, desired *corev1.Node) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		node, err := v.master.CoreV1().Nodes().Get(ctx, desired.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		var previous propagatedMetadata
+		if data, ok := node.Annotations[util.PropagatedMetadata]; ok {
+			if err = json.Unmarshal([]byte(data), &previous); err != nil {
+				klog.Errorf("Unmarshal propagated metadata of node %v failed: %v", node.Name, err)
+			}
+		}
+		nodeLabels := diffStringMap(node.Labels, desired.Labels, publishedLabels(node.Labels, previous.Labels))
+		annotations := diffStringMap(node.Annotations, desired.Annotations, previous.Annotations)
+		var (
+			taints        []corev1.Taint
+			taintsChanged bool
+		)
+		if clusterTaints, ok := desired.Annotations[util.ClusterTaints]; ok {
+			taints, taintsChanged = mergeClusterTaints(node.Spec.Taints, node.Annotations[util.ClusterTaints],
+				clusterTaints)
+		}
+		if len(nodeLabels) == 0 && len(annotations) == 0 && !taintsChanged {
+			return nil
+		}
+		body := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels":          nodeLabels,
+				"annotations":     annotations,
+				"resourceVersion": node.ResourceVersion,
+			},
+		}
+		if taintsChanged {
+			body["spec"] = map[string]interface{}{"taints": taints}
+		}
+		patch, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		klog.V(4).Infof("Patch metadata of node %v: %v", node.Name, string(patch))
+		_, err = v.master.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+}
+
+// publishedLabels returns the labels propagated before and the ones of current published by the provider loops,
+// which are removed once no longer desired
+func publishedLabels(current, propagated map[string]string) map[string]string {
+	published := make(map[string]string, len(propagated))
+	for k, value := range propagated {
+		published[k] = value
+	}
+	for k, value := range current {
+		if strings.HasPrefix(k, util.StorageClassLabelPrefix) {
+			published[k] = value
+		}
+	}
+	return published
+}
+
+// diffStringMap returns the entries of desired which are missing or different in current, and the keys of
+// removable no longer in desired but still in current with nil values, which are removed by the merge patch
+func diffStringMap(current, desired, removable map[string]string) map[string]interface{} {
+	diff := make(map[string]interface{})
+	for k, v := range desired {
+		if value, ok := current[k]; !ok || value != v {
+			diff[k] = v
+		}
+	}
+	for k := range removable {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if _, ok := current[k]; ok {
+			diff[k] = nil
+		}
+	}
+	return diff
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
 }
 
 // nodeDaemonEndpoints returns NodeDaemonEndpoints for the node status
@@ -121,34 +353,162 @@ func (v *VirtualK8S) nodeDaemonEndpoints() corev1.NodeDaemonEndpoints {
 	}
 }
 
-// getResourceFromPods summary the resource already used by pods.
-func (v *VirtualK8S) getResourceFromPods() *common.Resource {
-	podResource := common.NewResource()
+// getNodeResource computes the resource of virtual node from the caches of lower cluster by the capacity calculator
+func (v *VirtualK8S) getNodeResource() (*common.Resource, error) {
+	defer func(start time.Time) {
+		metrics.ResourceAggregationDuration.WithLabelValues(v.clusterName).Observe(time.Since(start).Seconds())
+	}(time.Now())
+	nodes, err := v.clientCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	requested := v.getResourceFromPods()
+	capacities := make([]common.NodeCapacity, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		if !checkNodeStatusReady(n) {
+			klog.Infof("Node %v not ready", n.Name)
+			continue
+		}
+		podResource, ok := requested[n.Name]
+		if !ok {
+			podResource = common.NewResource()
+		}
+		capacities = append(capacities, common.NodeCapacity{Capacity: v.getNodeCapacity(n), Requested: podResource})
+	}
+	return v.capacityCalculator.Calculate(capacities), nil
+}
+
+// getNodeCapacity returns the capacity of a lower cluster node with the overcommit ratios applied
+func (v *VirtualK8S) getNodeCapacity(node *corev1.Node) *common.Resource {
+	nc := common.ConvertResource(node.Status.Capacity)
+	nc.Overcommit(v.overcommitRatios())
+	return nc
+}
+
+// overcommitRatios returns the cpu and memory overcommit ratios, which may be changed live
+func (v *VirtualK8S) overcommitRatios() (float64, float64) {
+	v.overcommitLock.RLock()
+	defer v.overcommitLock.RUnlock()
+	return v.cpuOvercommitRatio, v.memOvercommitRatio
+}
+
+// SetOvercommitRatios changes the overcommit ratios live, the capacity of the virtual node is recomputed and
+// the annotations of the ratios are patched to the node
+func (v *VirtualK8S) SetOvercommitRatios(cpu, memory float64) {
+	v.overcommitLock.Lock()
+	changed := v.cpuOvercommitRatio != cpu || v.memOvercommitRatio != memory
+	v.cpuOvercommitRatio, v.memOvercommitRatio = cpu, memory
+	v.overcommitLock.Unlock()
+	if !changed || !v.configured {
+		return
+	}
+	klog.Infof("Overcommit ratios changed to cpu %v memory %v", cpu, memory)
+	v.setNodeAnnotation(util.CPUOvercommitRatio, formatRatio(cpu))
+	v.setNodeAnnotation(util.MemoryOvercommitRatio, formatRatio(memory))
+	nodeResource, err := v.getNodeResource()
+	if err != nil {
+		klog.Errorf("Compute node resource failed: %v", err)
+		return
+	}
+	if err = v.providerNode.SetResource(nodeResource); err != nil {
+		return
+	}
+	select {
+	case v.updatedNode <- v.providerNode.DeepCopy():
+	case <-v.stopCh:
+	}
+}
+
+// queueCapacitySync returns false if the capacity calculator is additive, the caller then maintains the resource
+// of virtual node incrementally, otherwise the resource is recalculated by runCapacitySync
+func (v *VirtualK8S) queueCapacitySync() bool {
+	if v.capacityCalculator.Additive() {
+		return false
+	}
+	select {
+	case v.capacitySync <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// runCapacitySync recalculates the resource of virtual node queued by the events of lower cluster, the events
+// within a coalesce period result in one recalculation
+func (v *VirtualK8S) runCapacitySync(ctx context.Context) {
+	for {
+		select {
+		case <-v.capacitySync:
+		case <-v.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+		nodeResource, err := v.getNodeResource()
+		if err != nil {
+			klog.Errorf("Compute node resource failed: %v", err)
+		} else if err = v.providerNode.SetResource(nodeResource); err == nil {
+			select {
+			case v.updatedNode <- v.providerNode.DeepCopy():
+			case <-v.stopCh:
+				return
+			}
+		}
+		select {
+		case <-time.After(nodeStatusCoalescePeriod):
+		case <-v.stopCh:
+			return
+		}
+	}
+}
+
+// formatRatio formats the ratio for the annotations, ratio not larger than 0 means no overcommit
+func formatRatio(ratio float64) string {
+	if ratio <= 0 {
+		ratio = 1
+	}
+	return strconv.FormatFloat(ratio, 'f', -1, 64)
+}
+
+// setOvercommitAnnotations records the overcommit ratios on the node, so that schedulers can
+// compute the effective capacity of the cluster
+func (v *VirtualK8S) setOvercommitAnnotations(node *corev1.Node) {
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	cpu, memory := v.overcommitRatios()
+	if cpu > 0 {
+		node.Annotations[util.CPUOvercommitRatio] = strconv.FormatFloat(cpu, 'f', -1, 64)
+	}
+	if memory > 0 {
+		node.Annotations[util.MemoryOvercommitRatio] = strconv.FormatFloat(memory, 'f', -1, 64)
+	}
+}
+
+// getResourceFromPods summary the resource reserved by pods of each node.
+func (v *VirtualK8S) getResourceFromPods() map[string]*common.Resource {
+	podResources := make(map[string]*common.Resource)
 	pods, err := v.clientCache.podLister.List(labels.Everything())
 	if err != nil {
-		return podResource
+		return podResources
 	}
 	for _, pod := range pods {
-		if pod.Status.Phase == corev1.PodPending && pod.Spec.NodeName != "" ||
-			pod.Status.Phase == corev1.PodRunning {
-			nodeName := pod.Spec.NodeName
-			node, err := v.clientCache.nodeLister.Get(nodeName)
-			if err != nil {
-				klog.Infof("get node %v failed err: %v", nodeName, err)
-				continue
-			}
-			if node.Spec.Unschedulable || !checkNodeStatusReady(node) {
-				continue
-			}
-			res := util.GetRequestFromPod(pod)
-			res.Pods = resource.MustParse("1")
-			podResource.Add(res)
+		if !v.reserves(pod) {
+			continue
 		}
+		nodeName := pod.Spec.NodeName
+		if _, ok := podResources[nodeName]; !ok {
+			podResources[nodeName] = common.NewResource()
+		}
+		podResources[nodeName].Add(reservedRequest(pod))
 	}
-	return podResource
+	return podResources
 }
 
-// getResourceFromPodsByNodeName summary the resource already used by pods according to nodeName
+// getResourceFromPodsByNodeName summary the resource reserved by pods according to nodeName
 func (v *VirtualK8S) getResourceFromPodsByNodeName(nodeName string) *common.Resource {
 	podResource := common.NewResource()
 	fieldSelector, err := fields.ParseSelector("spec.nodeName=" + nodeName)
@@ -159,15 +519,9 @@ func (v *VirtualK8S) getResourceFromPodsByNodeName(nodeName string) *common.Reso
 	if err != nil {
 		return podResource
 	}
-	for _, pod := range pods.Items {
-		if util.IsVirtualPod(&pod) {
-			continue
-		}
-		if pod.Status.Phase == corev1.PodPending ||
-			pod.Status.Phase == corev1.PodRunning {
-			res := util.GetRequestFromPod(&pod)
-			res.Pods = resource.MustParse("1")
-			podResource.Add(res)
+	for i := range pods.Items {
+		if v.reserves(&pods.Items[i]) {
+			podResource.Add(reservedRequest(&pods.Items[i]))
 		}
 	}
 	return podResource
@@ -18,17 +18,21 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	v1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/kubernetes/pkg/controller"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
 func TestConfigureNode(t *testing.T) {
@@ -127,6 +131,168 @@ func TestConfigureNode(t *testing.T) {
 	}
 }
 
+func TestCapacityCalculator(t *testing.T) {
+	vk, nodeInformer, podInformer := newFakeVirtualK8SWithNodePod()
+	for i, cpu := range []string{"10", "20"} {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%v", i+1)},
+			Status: corev1.NodeStatus{
+				Capacity:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		nodeInformer.Informer().GetStore().Add(node)
+	}
+	pod := fakeNodeWithReq()
+	pod.Status.Phase = corev1.PodRunning
+	podInformer.Informer().GetStore().Add(pod)
+	if vk.queueCapacitySync() {
+		t.Fatal("desire resource of Sum maintained incrementally, real queued")
+	}
+	nodeResource, err := vk.getNodeResource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nodeResource.CPU.Equal(resource.MustParse("27")) {
+		t.Fatalf("desire cpu 27 of Sum, real %v", nodeResource.CPU.String())
+	}
+	vk.capacityCalculator, _ = common.NewCapacityCalculator(common.MaxSinglePod, nil, 0)
+	if nodeResource, err = vk.getNodeResource(); err != nil {
+		t.Fatal(err)
+	}
+	if !nodeResource.CPU.Equal(resource.MustParse("20")) {
+		t.Fatalf("desire cpu 20 of MaxSinglePod, real %v", nodeResource.CPU.String())
+	}
+	if !vk.queueCapacitySync() || !vk.queueCapacitySync() {
+		t.Fatal("desire resource of MaxSinglePod recalculated, real maintained incrementally")
+	}
+	if len(vk.capacitySync) != 1 {
+		t.Fatalf("desire recalculations queued once, real %v", len(vk.capacitySync))
+	}
+}
+
+func TestPhysicalCapacity(t *testing.T) {
+	vk, nodeInformer, _ := newFakeVirtualK8SWithNodePod()
+	vk.cpuOvercommitRatio = 2
+	for i, cpu := range []string{"10", "20"} {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%v", i+1)},
+			Status: corev1.NodeStatus{
+				Capacity:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		nodeInformer.Informer().GetStore().Add(node)
+	}
+	capacity, err := vk.getPhysicalCapacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !capacity.Cpu().Equal(resource.MustParse("30")) {
+		t.Fatalf("desire physical cpu 30 without overcommit, real %v", capacity.Cpu().String())
+	}
+}
+
+func TestCoalesceNodeStatus(t *testing.T) {
+	period := nodeStatusCoalescePeriod
+	nodeStatusCoalescePeriod = 10 * time.Millisecond
+	defer func() {
+		nodeStatusCoalescePeriod = period
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	vk.updatedNode = make(chan *corev1.Node, 10)
+	for i := 0; i < 3; i++ {
+		vk.updatedNode <- &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%v", i)}}
+	}
+	notified := make(chan *corev1.Node, 10)
+	go vk.coalesceNodeStatus(ctx, func(node *corev1.Node) {
+		notified <- node
+	})
+	select {
+	case node := <-notified:
+		if node.Name != "node2" {
+			t.Fatalf("Desired the latest node node2, get %v", node.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Node status not notified")
+	}
+	select {
+	case node := <-notified:
+		t.Fatalf("Desired one notification, get another %v", node.Name)
+	case <-time.After(5 * nodeStatusCoalescePeriod):
+	}
+}
+
+func TestPatchNodeMetadata(t *testing.T) {
+	ctx := context.Background()
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	existing := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "vk",
+		Labels: map[string]string{"a": "1", "b": "1"},
+	}}
+	vk.master = fake.NewSimpleClientset(existing)
+	desired := existing.DeepCopy()
+	desired.Labels = map[string]string{"b": "2"}
+	desired.Annotations = map[string]string{util.CPUOvercommitRatio: "2"}
+	if err := vk.patchNodeMetadata(ctx, desired); err != nil {
+		t.Fatal(err)
+	}
+	node, err := vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Labels["a"] != "1" || node.Labels["b"] != "2" || node.Annotations[util.CPUOvercommitRatio] != "2" {
+		t.Fatalf("Unexpected metadata after patch, labels %v, annotations %v", node.Labels, node.Annotations)
+	}
+}
+
+func TestSyncNodeMetadata(t *testing.T) {
+	period := nodeMetadataSyncPeriod
+	nodeMetadataSyncPeriod = 10 * time.Millisecond
+	defer func() {
+		nodeMetadataSyncPeriod = period
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	vk.master = fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk"}})
+	vk.providerNode.Node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "vk",
+		Labels: map[string]string{"a": "1"},
+	}}
+	vk.setNodeAnnotation(util.FitSummary, "[]")
+	go vk.syncNodeMetadata(ctx)
+
+	synced := func() bool {
+		err := wait.Poll(10*time.Millisecond, time.Second, func() (bool, error) {
+			node, err := vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return node.Labels["a"] == "1" && node.Annotations[util.FitSummary] == "[]", nil
+		})
+		return err == nil
+	}
+	if !synced() {
+		t.Fatal("Node metadata not synced")
+	}
+	// drift made by other writers should be fixed
+	node, err := vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Labels = nil
+	node.Annotations = nil
+	if _, err = vk.master.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !synced() {
+		t.Fatal("Node metadata drift not fixed")
+	}
+}
+
 func newFakeVirtualK8SWithNodePod() (*VirtualK8S, v1.NodeInformer, v1.PodInformer) {
 	client := fake.NewSimpleClientset()
 	master := fake.NewSimpleClientset()
@@ -135,6 +301,7 @@ func newFakeVirtualK8SWithNodePod() (*VirtualK8S, v1.NodeInformer, v1.PodInforme
 
 	nodeInformer := clientInformer.Core().V1().Nodes()
 	podInformer := clientInformer.Core().V1().Pods()
+	calculator, _ := common.NewCapacityCalculator(common.Sum, nil, 0)
 	return &VirtualK8S{
 		master: master,
 		client: client,
@@ -145,6 +312,8 @@ func newFakeVirtualK8SWithNodePod() (*VirtualK8S, v1.NodeInformer, v1.PodInforme
 		providerNode: &common.ProviderNode{
 			Node: &corev1.Node{},
 		},
+		capacityCalculator: calculator,
+		capacitySync:       make(chan struct{}, 1),
 	}, nodeInformer, podInformer
 }
 
@@ -0,0 +1,270 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	ownedLabels      = "labels"
+	ownedAnnotations = "annotations"
+	ownedImages      = "images"
+	ownedTolerations = "tolerations"
+)
+
+// lowerOwnedFields are the fields of lower pods owned by the lower cluster, e.g. the ones set by its admission
+// webhooks, which are kept as the lower cluster has them instead of rewritten by the upper pods. Patterns of
+// label and annotation keys and container names ending with "*" match the prefix.
+type lowerOwnedFields struct {
+	labels      []string
+	annotations []string
+	images      []string
+	tolerations bool
+}
+
+// parseLowerOwnedFields parses the fields in labels:<key>, annotations:<key>, images:<container> or tolerations,
+// nil is returned if no field is owned
+func parseLowerOwnedFields(fields []string) (*lowerOwnedFields, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	owned := &lowerOwnedFields{}
+	for _, field := range fields {
+		kind, pattern := field, ""
+		if i := strings.Index(field, ":"); i >= 0 {
+			kind, pattern = field[:i], field[i+1:]
+		}
+		if kind == ownedTolerations && len(pattern) == 0 {
+			owned.tolerations = true
+			continue
+		}
+		if len(pattern) == 0 {
+			return nil, fmt.Errorf("invalid lower owned field %q, expected labels:<key>, annotations:<key>, "+
+				"images:<container> or tolerations", field)
+		}
+		switch kind {
+		case ownedLabels:
+			owned.labels = append(owned.labels, pattern)
+		case ownedAnnotations:
+			owned.annotations = append(owned.annotations, pattern)
+		case ownedImages:
+			owned.images = append(owned.images, pattern)
+		default:
+			return nil, fmt.Errorf("unknown kind %q of lower owned field %q", kind, field)
+		}
+	}
+	return owned, nil
+}
+
+func (o *lowerOwnedFields) labelPatterns() []string {
+	if o == nil {
+		return nil
+	}
+	return o.labels
+}
+
+func (o *lowerOwnedFields) annotationPatterns() []string {
+	if o == nil {
+		return nil
+	}
+	return o.annotations
+}
+
+func (o *lowerOwnedFields) ownsImage(container string) bool {
+	return o != nil && matchesPattern(o.images, container)
+}
+
+func (o *lowerOwnedFields) ownsTolerations() bool {
+	return o != nil && o.tolerations
+}
+
+// matchesPattern tells whether the key is one of patterns, or has the prefix of a pattern ending with "*"
+func matchesPattern(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if pattern == key ||
+			strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliedState is the labels and annotations the provider set on the lower pod and the images of the upper pod
+// last applied, the other keys are added by the lower cluster and an image is only rewritten once the upper
+// pod changes it, so the provider does not fight with the lower cluster over them
+type appliedState struct {
+	Labels      []string          `json:"labels,omitempty"`
+	Annotations []string          `json:"annotations,omitempty"`
+	Images      map[string]string `json:"images,omitempty"`
+}
+
+func (s *appliedState) images() map[string]string {
+	if s == nil {
+		return nil
+	}
+	return s.Images
+}
+
+// setAppliedState records the labels and annotations of the lower pod and the images of the upper pod as the
+// state last applied
+func setAppliedState(lower, upper *corev1.Pod) {
+	state := appliedState{Images: make(map[string]string)}
+	for k := range lower.Labels {
+		state.Labels = append(state.Labels, k)
+	}
+	for k := range lower.Annotations {
+		if k != util.LastAppliedState {
+			state.Annotations = append(state.Annotations, k)
+		}
+	}
+	sort.Strings(state.Labels)
+	sort.Strings(state.Annotations)
+	for _, c := range append(append([]corev1.Container{}, upper.Spec.InitContainers...), upper.Spec.Containers...) {
+		state.Images[c.Name] = c.Image
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		klog.Errorf("Marshal applied state of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return
+	}
+	if lower.Annotations == nil {
+		lower.Annotations = make(map[string]string)
+	}
+	lower.Annotations[util.LastAppliedState] = string(data)
+}
+
+// getAppliedState returns the state last applied to the lower pod, nil if the pod is created before it is
+// recorded
+func getAppliedState(lower *corev1.Pod) *appliedState {
+	data, ok := lower.Annotations[util.LastAppliedState]
+	if !ok {
+		return nil
+	}
+	state := &appliedState{}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		klog.Errorf("Unmarshal applied state of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return nil
+	}
+	return state
+}
+
+// hideAppliedState removes the applied state from the pod reported upward
+func hideAppliedState(pod *corev1.Pod) {
+	if pod.Annotations != nil {
+		delete(pod.Annotations, util.LastAppliedState)
+	}
+}
+
+// mergeLowerFields merges the fields of the lower pod not managed by the provider into desired, which is the
+// lower pod rewritten by the upper one. The fields owned by the lower cluster are always kept, the labels and
+// annotations never applied are kept, and the images are only rewritten if the upper pod changed them since last
+// applied. Without an applied state, the lower pod is merged as owned by the provider except the owned fields.
+func (v *VirtualK8S) mergeLowerFields(desired, lower, upper *corev1.Pod) {
+	base := getAppliedState(lower)
+	var appliedLabels, appliedAnnotations sets.String
+	if base != nil {
+		appliedLabels, appliedAnnotations = sets.NewString(base.Labels...), sets.NewString(base.Annotations...)
+	}
+	desired.Labels = mergeLowerEntries(desired.Labels, lower.Labels, appliedLabels, v.ownedFields.labelPatterns())
+	desired.Annotations = mergeLowerEntries(desired.Annotations, lower.Annotations, appliedAnnotations,
+		v.ownedFields.annotationPatterns())
+
+	lowerImages := make(map[string]string)
+	for _, c := range append(append([]corev1.Container{}, lower.Spec.InitContainers...), lower.Spec.Containers...) {
+		lowerImages[c.Name] = c.Image
+	}
+	upperImages := make(map[string]string)
+	for _, c := range append(append([]corev1.Container{}, upper.Spec.InitContainers...), upper.Spec.Containers...) {
+		upperImages[c.Name] = c.Image
+	}
+	keepImages := func(containers []corev1.Container) {
+		for i := range containers {
+			name := containers[i].Name
+			image, ok := lowerImages[name]
+			if !ok {
+				continue
+			}
+			if v.ownedFields.ownsImage(name) {
+				containers[i].Image = image
+				continue
+			}
+			if base == nil {
+				continue
+			}
+			if applied, ok := base.Images[name]; ok && applied == upperImages[name] {
+				containers[i].Image = image
+			}
+		}
+	}
+	keepImages(desired.Spec.InitContainers)
+	keepImages(desired.Spec.Containers)
+	if v.ownedFields.ownsTolerations() {
+		desired.Spec.Tolerations = lower.Spec.Tolerations
+	}
+}
+
+// mergeLowerEntries keeps the entries of current owned by the lower cluster, and the ones not in desired nor
+// applied if applied is recorded, the owned entries current does not have are removed
+func mergeLowerEntries(desired, current map[string]string, applied sets.String, owned []string) map[string]string {
+	merged := desired
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	for k, value := range current {
+		if matchesPattern(owned, k) {
+			merged[k] = value
+			continue
+		}
+		if _, ok := merged[k]; !ok && applied != nil && !applied.Has(k) {
+			merged[k] = value
+		}
+	}
+	for k := range merged {
+		if _, ok := current[k]; !ok && matchesPattern(owned, k) {
+			delete(merged, k)
+		}
+	}
+	if desired == nil && len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// managedEntries returns the entries of the lower pod the provider manages, the ones applied if recorded, except
+// the ones owned by the lower cluster
+func managedEntries(entries map[string]string, applied []string, recorded bool,
+	owned []string) map[string]string {
+	appliedKeys := sets.NewString(applied...)
+	managed := make(map[string]string, len(entries))
+	for k, value := range entries {
+		if k == util.LastAppliedState || matchesPattern(owned, k) || recorded && !appliedKeys.Has(k) {
+			continue
+		}
+		managed[k] = value
+	}
+	return managed
+}
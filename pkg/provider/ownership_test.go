@@ -0,0 +1,109 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseLowerOwnedFields(t *testing.T) {
+	owned, err := parseLowerOwnedFields([]string{"labels:istio.io/*", "annotations:sidecar.istio.io/status",
+		"images:istio-proxy", "tolerations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(owned.labelPatterns(), []string{"istio.io/*"}) ||
+		!reflect.DeepEqual(owned.annotationPatterns(), []string{"sidecar.istio.io/status"}) ||
+		!owned.ownsImage("istio-proxy") || owned.ownsImage("app") || !owned.ownsTolerations() {
+		t.Errorf("Unexpected owned fields %+v", owned)
+	}
+	for _, fields := range [][]string{{"labels"}, {"labels:"}, {"spec:nodeName"}} {
+		if _, err := parseLowerOwnedFields(fields); err == nil {
+			t.Errorf("Desire error parsing %v", fields)
+		}
+	}
+	owned, err = parseLowerOwnedFields(nil)
+	if err != nil || owned != nil || owned.ownsImage("app") || owned.labelPatterns() != nil {
+		t.Errorf("Desire no owned fields, get %+v, %v", owned, err)
+	}
+}
+
+func TestMergeLowerFields(t *testing.T) {
+	upper := fakePod("test")
+	upper.Labels = map[string]string{"app": "web"}
+	upper.Spec.Containers = []corev1.Container{{Name: "app", Image: "app:v1"}}
+	lower := upper.DeepCopy()
+	lower.Labels = map[string]string{"app": "web", "tier": "front"}
+	setAppliedState(lower, upper)
+
+	// mutations of the lower cluster
+	lower.Labels["istio.io/rev"] = "default"
+	lower.Annotations["sidecar.istio.io/status"] = "injected"
+	lower.Annotations["owner"] = "ops"
+	lower.Spec.Containers[0].Image = "mirror/app:v1"
+	lower.Spec.Containers = append(lower.Spec.Containers, corev1.Container{Name: "istio-proxy", Image: "proxy:v1"})
+
+	owned, err := parseLowerOwnedFields([]string{"annotations:sidecar.istio.io/*", "images:istio-proxy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &VirtualK8S{ownedFields: owned}
+	for _, c := range []struct {
+		name        string
+		labels      map[string]string
+		image       string
+		desireLabel map[string]string
+		desireImage string
+	}{
+		{
+			name:        "upper unchanged",
+			labels:      map[string]string{"app": "web", "tier": "front"},
+			image:       "app:v1",
+			desireLabel: map[string]string{"app": "web", "tier": "front", "istio.io/rev": "default"},
+			desireImage: "mirror/app:v1",
+		},
+		{
+			name:        "upper changes image and removes label",
+			labels:      map[string]string{"app": "web"},
+			image:       "app:v2",
+			desireLabel: map[string]string{"app": "web", "istio.io/rev": "default"},
+			desireImage: "app:v2",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			pod := upper.DeepCopy()
+			pod.Spec.Containers[0].Image = c.image
+			desired := lower.DeepCopy()
+			desired.Labels = c.labels
+			desired.Annotations = map[string]string{}
+			desired.Spec.Containers = pod.Spec.Containers
+			v.mergeLowerFields(desired, lower, pod)
+			if !reflect.DeepEqual(desired.Labels, c.desireLabel) {
+				t.Errorf("Desire labels %v, get %v", c.desireLabel, desired.Labels)
+			}
+			if desired.Annotations["sidecar.istio.io/status"] != "injected" || desired.Annotations["owner"] != "ops" {
+				t.Errorf("Desire annotations of lower cluster kept, get %v", desired.Annotations)
+			}
+			if desired.Spec.Containers[0].Image != c.desireImage {
+				t.Errorf("Desire image %v, get %v", c.desireImage, desired.Spec.Containers[0].Image)
+			}
+		})
+	}
+}
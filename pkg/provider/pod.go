@@ -20,6 +20,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"reflect"
 	"time"
 
@@ -30,12 +32,17 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	transportspdy "k8s.io/client-go/transport/spdy"
 	"k8s.io/klog"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/controllers"
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
 )
 
@@ -45,99 +52,267 @@ var _ node.NodeProvider = &VirtualK8S{}
 
 // CreatePod takes a Kubernetes Pod and deploys it within the provider.
 func (v *VirtualK8S) CreatePod(ctx context.Context, pod *corev1.Pod) error {
+	release, err := v.podThrottle.acquire(ctx, metrics.OperationCreate)
+	if err != nil {
+		return err
+	}
+	defer release()
+	start := time.Now()
+	err = v.createPod(ctx, pod)
+	metrics.ObservePodSync(v.clusterName, metrics.OperationCreate, start, err)
+	if err != nil {
+		v.alerts.Failed(podAlertKey(pod), podReference(pod), createPodFailedReason, err)
+	} else {
+		v.alerts.Resolved(podAlertKey(pod))
+	}
+	return err
+}
+
+func (v *VirtualK8S) createPod(ctx context.Context, pod *corev1.Pod) error {
 	if pod.Namespace == "kube-system" {
 		return nil
 	}
-	basicPod := util.TrimPod(pod, v.ignoreLabels)
+	filters := v.syncFilters()
+	// retried until the namespace is no longer excluded, so the pod is created once the filters change
+	if filters.excludes(pod.Namespace) {
+		return fmt.Errorf("namespace %v is excluded from cluster %v", pod.Namespace, v.clusterName)
+	}
+	namespace := v.lowerNamespace(pod.Namespace)
+	basicPod := util.TrimPod(pod, filters.labels())
+	basicPod.Namespace = namespace
+	stripAnnotations(basicPod)
 	klog.V(3).Infof("Creating pod %v/%+v", pod.Namespace, pod.Name)
-	if _, err := v.clientCache.nsLister.Get(pod.Namespace); err != nil {
+	if _, err := v.clientCache.nsLister.Get(namespace); err != nil {
 		if !errors.IsNotFound(err) {
 			return err
 		}
-		klog.Infof("Namespace %s does not exist for pod %s, creating it", pod.Namespace, pod.Name)
+		klog.Infof("Namespace %s does not exist for pod %s, creating it", namespace, pod.Name)
 		ns := &corev1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: pod.Namespace,
+				Name: namespace,
 			},
 		}
 		if _, createErr := v.client.CoreV1().Namespaces().Create(ctx, ns,
 			metav1.CreateOptions{}); createErr != nil && errors.IsAlreadyExists(createErr) {
-			klog.Infof("Namespace %s create failed error: %v", pod.Namespace, createErr)
+			klog.Infof("Namespace %s create failed error: %v", namespace, createErr)
 			return err
 		}
 	}
-	secretNames := getSecrets(pod)
 	configMaps := getConfigmaps(pod)
+	secrets := getSecrets(pod)
 	pvcs := getPVCs(pod)
 	go wait.PollImmediate(500*time.Millisecond, 10*time.Minute, func() (bool, error) {
 
 		klog.V(4).Info("Trying to creating base dependent")
-		if err := v.createConfigMaps(ctx, configMaps, pod.Namespace); err != nil {
-			klog.Error(err)
-			return false, nil
-		}
-		klog.Infof("Create configmaps %v of %v/%v success", configMaps, pod.Namespace, pod.Name)
 		if err := v.createPVCs(ctx, pvcs, pod.Namespace); err != nil {
 			klog.Error(err)
+			v.alerts.Failed(dependencyAlertKey(pod), podReference(pod), dependencySyncFailedReason, err)
 			return false, nil
 		}
 		klog.Infof("Create pvc %v of %v/%v success", pvcs, pod.Namespace, pod.Name)
+		v.alerts.Resolved(dependencyAlertKey(pod))
 		return true, nil
 	})
+	// the configMaps and secrets are synced before the pod created, otherwise the pod may fail to start in
+	// client cluster, the ones just created in master cluster may be missed by the cache for a while
 	var err error
 	wait.PollImmediate(100*time.Millisecond, 1*time.Second, func() (bool, error) {
 
-		klog.V(4).Info("Trying to creating secret and service account")
-		if err = v.createSecrets(ctx, secretNames, pod.Namespace); err != nil {
+		klog.V(4).Info("Trying to creating configmaps, secrets and service account")
+		if err = v.createConfigMaps(ctx, configMaps, pod.Namespace); err != nil {
+			klog.Error(err)
+			return false, nil
+		}
+		if err = v.createSecrets(ctx, secrets, pod.Namespace); err != nil {
 			klog.Error(err)
 			return false, nil
 		}
 		return true, nil
 	})
 	if err != nil {
-		return fmt.Errorf("create secrets failed: %v", err)
+		return fmt.Errorf("create configmaps and secrets failed: %v", err)
+	}
+	if err = v.dependencies.Sync(ctx, pod); err != nil {
+		return err
+	}
+	if err = v.createGoverningService(ctx, pod); err != nil {
+		return err
 	}
 	klog.V(6).Infof("Creating pod %+v", pod)
-	_, err = v.client.CoreV1().Pods(pod.Namespace).Create(ctx, basicPod, metav1.CreateOptions{})
+	client, err := v.podClient(pod.Namespace)
+	if err != nil {
+		return err
+	}
+	// hooks run before the identity of the upper pod is set, so that they could not break it
+	if basicPod, err = v.translationHooks.Translate(ctx, pod, basicPod); err != nil {
+		return fmt.Errorf("could not translate pod: %v", err)
+	}
+	transformed := &corev1.Pod{}
+	if err = v.transform(podKind, basicPod, transformed); err != nil {
+		return fmt.Errorf("could not transform pod: %v", err)
+	}
+	basicPod = transformed
+	v.rewriteResources(basicPod.Spec.InitContainers)
+	v.rewriteResources(basicPod.Spec.Containers)
+	if err = v.translatePriority(ctx, basicPod); err != nil {
+		return err
+	}
+	v.recordUpperUID(pod)
+	setUpperUID(basicPod, pod.UID)
+	v.labelAddressOf(basicPod, pod.UID)
+	setUpperResources(basicPod, pod)
+	v.setClusterIdentity(basicPod)
+	v.setOriginLabels(basicPod, pod)
+	setAppliedState(basicPod, pod)
+	if current, err := v.clientCache.podLister.Pods(namespace).Get(pod.Name); err == nil &&
+		!belongsTo(current, pod) {
+		// e.g. a StatefulSet pod is re-created before the lower pod of its previous instance deleted
+		return v.deleteStalePod(ctx, client, current)
+	}
+	ephemeralTemplates, err := v.prepareEphemeralVolumes(ctx, pod, basicPod)
+	if err != nil {
+		return err
+	}
+	var tokens *upperTokens
+	if v.upperServiceAccountTokens {
+		tokens = prepareUpperTokens(pod, basicPod)
+	}
+	v.conflicts.Writing(conflictKey(pod), podReference(pod), desiredPodState(pod), v.lowerPodState(basicPod))
+	created, err := client.CoreV1().Pods(namespace).Create(ctx, basicPod, metav1.CreateOptions{})
+	if err != nil {
+		if isAdmissionRejection(err) {
+			return v.rejectPod(ctx, pod, err)
+		}
+		return fmt.Errorf("could not create pod: %w", err)
+	}
+	if err = createEphemeralPVCs(ctx, client, created, ephemeralTemplates); err == nil && tokens != nil {
+		err = v.createUpperTokenSecret(ctx, created, tokens)
+	}
 	if err != nil {
-		return fmt.Errorf("could not create pod: %v", err)
+		// the pod is deleted, so that it is created with the pvcs and tokens again on retry
+		if delErr := client.CoreV1().Pods(namespace).Delete(ctx, created.Name, metav1.DeleteOptions{
+			Preconditions: metav1.NewUIDPreconditions(string(created.UID)),
+		}); delErr != nil && !errors.IsNotFound(delErr) {
+			klog.Errorf("Delete pod %v/%v failed: %v", pod.Namespace, pod.Name, delErr)
+		}
+		return err
 	}
+	v.conflicts.Written(conflictKey(pod), v.lowerPodState(created))
+	v.podMapping.record(pod.Namespace, pod.Name, pod.UID, created.UID)
+	v.holdUpperPod(ctx, pod)
 	klog.V(3).Infof("Create pod %v/%+v success", pod.Namespace, pod.Name)
 	return nil
 }
 
 // UpdatePod takes a Kubernetes Pod and updates it within the provider.
 func (v *VirtualK8S) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
+	release, err := v.podThrottle.acquire(ctx, metrics.OperationUpdate)
+	if err != nil {
+		return err
+	}
+	defer release()
+	start := time.Now()
+	err = v.updatePod(ctx, pod)
+	metrics.ObservePodSync(v.clusterName, metrics.OperationUpdate, start, err)
+	return err
+}
+
+func (v *VirtualK8S) updatePod(ctx context.Context, pod *corev1.Pod) error {
 	if pod.Namespace == "kube-system" {
 		return nil
 	}
 	klog.V(3).Infof("Updating pod %v/%+v", pod.Namespace, pod.Name)
-	currentPod, err := v.GetPod(ctx, pod.Namespace, pod.Name)
+	lower, err := v.clientCache.podLister.Pods(v.lowerNamespace(pod.Namespace)).Get(pod.Name)
 	if err != nil {
-		return fmt.Errorf("could not get current pod")
+		return fmt.Errorf("could not get current pod %v/%v: %v", pod.Namespace, pod.Name, err)
 	}
 	if !util.IsVirtualPod(pod) {
 		klog.Info("Pod is not created by vk, ignore")
 		return nil
 	}
+	// the uid is recorded before reading the pod, so the lower pod of a previous upper pod is treated as stale
+	v.recordUpperUID(pod)
+	client, err := v.podClient(pod.Namespace)
+	if err != nil {
+		return err
+	}
+	if !belongsTo(lower, pod) {
+		return v.deleteStalePod(ctx, client, lower)
+	}
+	currentPod, err := v.GetPod(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return fmt.Errorf("could not get current pod %v/%v: %v", pod.Namespace, pod.Name, err)
+	}
 
 	podCopy := currentPod.DeepCopy()
-	util.GetUpdatedPod(podCopy, pod, v.ignoreLabels)
-	if reflect.DeepEqual(currentPod.Spec, podCopy.Spec) &&
-		reflect.DeepEqual(currentPod.Annotations, podCopy.Annotations) &&
-		reflect.DeepEqual(currentPod.Labels, podCopy.Labels) {
+	podCopy.Namespace = lower.Namespace
+	util.GetUpdatedPod(podCopy, pod, v.syncFilters().labels())
+	stripAnnotations(podCopy)
+	// the fields hidden by GetPod are set back before comparing with the lower pod
+	setUpperUID(podCopy, getUpperUID(lower))
+	setUpperResources(podCopy, pod)
+	v.setClusterIdentity(podCopy)
+	v.setOriginLabels(podCopy, pod)
+	v.mergeLowerFields(podCopy, lower, pod)
+	resized := resizedContainers(lower, pod)
+	if len(resized) == 0 &&
+		reflect.DeepEqual(lower.Spec, podCopy.Spec) &&
+		reflect.DeepEqual(lower.Annotations, podCopy.Annotations) &&
+		reflect.DeepEqual(lower.Labels, podCopy.Labels) {
+		return nil
+	}
+	desired := desiredPodState(pod)
+	if !v.conflicts.Allow(conflictKey(pod), desired) {
+		klog.V(4).Infof("Skip rewriting pod %v/%v conflicting with another writer", pod.Namespace, pod.Name)
 		return nil
 	}
-	_, err = v.client.CoreV1().Pods(pod.Namespace).Update(ctx, podCopy, metav1.UpdateOptions{})
+	if len(resized) != 0 {
+		if err = v.resizeContainers(ctx, client, podCopy, resized); err != nil {
+			return err
+		}
+	}
+	setAppliedState(podCopy, pod)
+	v.conflicts.Writing(conflictKey(pod), podReference(pod), desired, v.lowerPodState(podCopy))
+	updated, err := client.CoreV1().Pods(podCopy.Namespace).Update(ctx, podCopy, metav1.UpdateOptions{})
 	if err != nil {
-		return fmt.Errorf("could not update pod: %v", err)
+		return fmt.Errorf("could not update pod: %w", err)
 	}
+	v.conflicts.Written(conflictKey(pod), v.lowerPodState(updated))
 	klog.V(3).Infof("Update pod %v/%+v success ", pod.Namespace, pod.Name)
 	return nil
 }
 
+// deleteStalePod deletes the lower pod created for a previous upper pod of the same name,
+// an error is always returned so that the caller retries once the stale pod is gone
+func (v *VirtualK8S) deleteStalePod(ctx context.Context, client kubernetes.Interface, stale *corev1.Pod) error {
+	klog.Infof("Pod %v/%v in lower cluster belongs to previous upper pod %v, delete it",
+		stale.Namespace, stale.Name, getUpperUID(stale))
+	err := client.CoreV1().Pods(stale.Namespace).Delete(ctx, stale.Name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(stale.UID)),
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("could not delete stale pod: %v", err)
+	}
+	if err == nil {
+		metrics.OrphanPodsCleaned.WithLabelValues(v.clusterName).Inc()
+	}
+	return fmt.Errorf("stale pod %v/%v of previous upper pod is being deleted", stale.Namespace, stale.Name)
+}
+
 // DeletePod takes a Kubernetes Pod and deletes it from the provider.
 func (v *VirtualK8S) DeletePod(ctx context.Context, pod *corev1.Pod) error {
+	release, err := v.podThrottle.acquire(ctx, metrics.OperationDelete)
+	if err != nil {
+		return err
+	}
+	defer release()
+	start := time.Now()
+	err = v.deletePod(ctx, pod)
+	metrics.ObservePodSync(v.clusterName, metrics.OperationDelete, start, err)
+	return err
+}
+
+func (v *VirtualK8S) deletePod(ctx context.Context, pod *corev1.Pod) error {
 	if pod.Namespace == "kube-system" {
 		return nil
 	}
@@ -149,19 +324,44 @@ func (v *VirtualK8S) DeletePod(ctx context.Context, pod *corev1.Pod) error {
 	}
 
 	opts := &metav1.DeleteOptions{
-		GracePeriodSeconds: new(int64), // 0
-	}
-	if pod.DeletionGracePeriodSeconds != nil {
-		opts.GracePeriodSeconds = pod.DeletionGracePeriodSeconds
+		GracePeriodSeconds: v.deletionGracePeriodOf(pod),
+	}
+	namespace := v.lowerNamespace(pod.Namespace)
+	if lower, err := v.clientCache.podLister.Pods(namespace).Get(pod.Name); err == nil {
+		if !belongsTo(lower, pod) {
+			klog.Infof("Pod %v/%v in lower cluster belongs to another upper pod %v, ignore", pod.Namespace,
+				pod.Name, getUpperUID(lower))
+			v.releaseUpperPod(ctx, pod.Namespace, pod.Name, pod.UID)
+			return nil
+		}
+		if lower.DeletionTimestamp != nil && !v.forceDelete {
+			// the lower pod terminates with the grace period it is deleted with first, which is not shortened
+			// when virtual kubelet deletes the upper pod again once the grace period ends
+			klog.V(4).Infof("Pod %v/%v in lower cluster is terminating", pod.Namespace, pod.Name)
+			return nil
+		}
+		opts.Preconditions = metav1.NewUIDPreconditions(string(lower.UID))
 	}
 
-	err := v.client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, *opts)
+	client, err := v.podClient(pod.Namespace)
+	if err != nil {
+		return err
+	}
+	err = client.CoreV1().Pods(namespace).Delete(ctx, pod.Name, *opts)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			klog.Infof("Tried to delete pod %s/%s, but it did not exist in the cluster", pod.Namespace, pod.Name)
+			v.forgetUpperUID(pod)
+			v.resolveAlerts(pod)
+			v.releaseUpperPod(ctx, pod.Namespace, pod.Name, pod.UID)
 			return nil
 		}
-		return fmt.Errorf("could not delete pod: %v", err)
+		return fmt.Errorf("could not delete pod: %w", err)
+	}
+	v.forgetUpperUID(pod)
+	v.resolveAlerts(pod)
+	if v.forceDelete {
+		v.releaseUpperPod(ctx, pod.Namespace, pod.Name, pod.UID)
 	}
 	klog.V(3).Infof("Delete pod %v/%+v success", pod.Namespace, pod.Name)
 	return nil
@@ -172,7 +372,7 @@ func (v *VirtualK8S) DeletePod(ctx context.Context, pod *corev1.Pod) error {
 // concurrently outside of the calling goroutine. Therefore it is recommended
 // to return a version after DeepCopy.
 func (v *VirtualK8S) GetPod(ctx context.Context, namespace string, name string) (*corev1.Pod, error) {
-	pod, err := v.clientCache.podLister.Pods(namespace).Get(name)
+	pod, err := v.clientCache.podLister.Pods(v.lowerNamespace(namespace)).Get(name)
 	if err != nil {
 		klog.Error(err)
 		if errors.IsNotFound(err) {
@@ -180,8 +380,18 @@ func (v *VirtualK8S) GetPod(ctx context.Context, namespace string, name string)
 		}
 		return nil, fmt.Errorf("could not get pod %s/%s: %v", namespace, name, err)
 	}
+	if v.isStale(pod) {
+		return nil, errdefs.NotFoundf("pod %s/%s in lower cluster belongs to previous upper pod", namespace, name)
+	}
 	podCopy := pod.DeepCopy()
+	podCopy.Namespace = namespace
+	completePodStatus(podCopy)
 	util.RecoverLabels(podCopy.Labels, podCopy.Annotations)
+	hideUpperUID(podCopy)
+	hideAppliedState(podCopy)
+	hideUpperResources(podCopy)
+	hideClusterIdentity(podCopy)
+	v.describeSchedulingFailure(&podCopy.Status)
 	return podCopy, nil
 }
 
@@ -190,11 +400,18 @@ func (v *VirtualK8S) GetPod(ctx context.Context, namespace string, name string)
 // concurrently outside of the calling goroutine. Therefore it is recommended
 // to return a version after DeepCopy.
 func (v *VirtualK8S) GetPodStatus(ctx context.Context, namespace string, name string) (*corev1.PodStatus, error) {
-	pod, err := v.clientCache.podLister.Pods(namespace).Get(name)
+	pod, err := v.clientCache.podLister.Pods(v.lowerNamespace(namespace)).Get(name)
 	if err != nil {
 		return nil, fmt.Errorf("could not get pod %s/%s: %v", namespace, name, err)
 	}
-	return pod.Status.DeepCopy(), nil
+	if v.isStale(pod) {
+		return nil, errdefs.NotFoundf("pod %s/%s in lower cluster belongs to previous upper pod", namespace, name)
+	}
+	podCopy := pod.DeepCopy()
+	completePodStatus(podCopy)
+	status := &podCopy.Status
+	v.describeSchedulingFailure(status)
+	return status, nil
 }
 
 // GetPods retrieves a list of all pods running on the provider (can be cached).
@@ -210,11 +427,20 @@ func (v *VirtualK8S) GetPods(_ context.Context) ([]*corev1.Pod, error) {
 
 	podRefs := []*corev1.Pod{}
 	for _, p := range pods {
-		if !util.IsVirtualPod(p) {
+		if !util.IsVirtualPod(p) || v.isStale(p) {
 			continue
 		}
 		podCopy := p.DeepCopy()
+		if !v.restoreNamespace(podCopy) {
+			continue
+		}
+		completePodStatus(podCopy)
 		util.RecoverLabels(podCopy.Labels, podCopy.Annotations)
+		hideUpperUID(podCopy)
+		hideAppliedState(podCopy)
+		hideUpperResources(podCopy)
+		hideClusterIdentity(podCopy)
+		v.describeSchedulingFailure(&podCopy.Status)
 		podRefs = append(podRefs, podCopy)
 	}
 
@@ -239,10 +465,11 @@ func (v *VirtualK8S) GetContainerLogs(ctx context.Context, namespace string,
 		options.LimitBytes = &limitBytes
 	}
 	if !opts.SinceTime.IsZero() {
-		*options.SinceTime = metav1.Time{Time: opts.SinceTime}
+		options.SinceTime = &metav1.Time{Time: opts.SinceTime}
 	}
 	if sinceSeconds != 0 {
-		*options.SinceSeconds = int64(sinceSeconds)
+		seconds := int64(sinceSeconds)
+		options.SinceSeconds = &seconds
 	}
 	if opts.Previous {
 		options.Previous = opts.Previous
@@ -250,7 +477,7 @@ func (v *VirtualK8S) GetContainerLogs(ctx context.Context, namespace string,
 	if opts.Follow {
 		options.Follow = opts.Follow
 	}
-	logs := v.client.CoreV1().Pods(namespace).GetLogs(podName, options)
+	logs := v.client.CoreV1().Pods(v.lowerNamespace(namespace)).GetLogs(podName, options)
 	stream, err := logs.Stream(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not get stream from logs request: %v", err)
@@ -271,7 +498,7 @@ func (v *VirtualK8S) RunInContainer(ctx context.Context, namespace string, podNa
 	}()
 	req := v.client.CoreV1().RESTClient().
 		Post().
-		Namespace(namespace).
+		Namespace(v.lowerNamespace(namespace)).
 		Resource("pods").
 		Name(podName).
 		SubResource("exec").
@@ -285,7 +512,7 @@ func (v *VirtualK8S) RunInContainer(ctx context.Context, namespace string, podNa
 			TTY:       attach.TTY(),
 		}, scheme.ParameterCodec)
 
-	exec, err := remotecommand.NewSPDYExecutor(v.config, "POST", req.URL())
+	exec, err := v.newExecutor(req.URL())
 	if err != nil {
 		return fmt.Errorf("could not make remote command: %v", err)
 	}
@@ -306,6 +533,33 @@ func (v *VirtualK8S) RunInContainer(ctx context.Context, namespace string, podNa
 	return nil
 }
 
+// newExecutor returns an executor of the url, streams are sent through the tunnel proxy if set
+func (v *VirtualK8S) newExecutor(u *url.URL) (remotecommand.Executor, error) {
+	wrapper, upgrader, err := v.spdyTransports()
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewSPDYExecutorForTransports(wrapper, upgrader, "POST", u)
+}
+
+// spdyTransports returns the transports upgrading requests of lower cluster to spdy streams, through the tunnel
+// proxy if set
+func (v *VirtualK8S) spdyTransports() (http.RoundTripper, transportspdy.Upgrader, error) {
+	if v.tunnelProxy == nil {
+		return transportspdy.RoundTripperFor(v.config)
+	}
+	tlsConfig, err := rest.TLSConfigFor(v.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	upgrader := spdy.NewRoundTripperWithProxy(tlsConfig, true, false, http.ProxyURL(v.tunnelProxy))
+	wrapper, err := rest.HTTPWrappersForConfig(v.config, upgrader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrapper, upgrader, nil
+}
+
 // NotifyPods instructs the notifier to call the passed in function when
 // the pod status changes. It should be called when a pod's status changes.
 //
@@ -322,6 +576,21 @@ func (v *VirtualK8S) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
 		for {
 			select {
 			case pod := <-v.updatedPod:
+				if v.isStale(pod) {
+					klog.V(4).Infof("Skip pod %v of previous upper pod %v", pod.Name, getUpperUID(pod))
+					continue
+				}
+				if !v.restoreNamespace(pod) {
+					klog.V(4).Infof("Skip pod %v/%v not created from the upper cluster", pod.Namespace, pod.Name)
+					continue
+				}
+				completePodStatus(pod)
+				v.translateStatus(pod)
+				hideUpperUID(pod)
+				hideAppliedState(pod)
+				hideUpperResources(pod)
+				hideClusterIdentity(pod)
+				v.describeSchedulingFailure(&pod.Status)
 				klog.V(4).Infof("Enqueue updated pod %v", pod.Name)
 				// need trim pod, e.g. UID
 				util.RecoverLabels(pod.Labels, pod.Annotations)
@@ -335,10 +604,12 @@ func (v *VirtualK8S) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
 	}()
 }
 
-// createSecrets takes a Kubernetes Pod and deploys it within the provider.
-func (v *VirtualK8S) createSecrets(ctx context.Context, secrets []string, ns string) error {
-	for _, secretName := range secrets {
-		_, err := v.clientCache.secretLister.Secrets(ns).Get(secretName)
+// createSecrets creates the secrets of master cluster referenced in client cluster if absent, the optional
+// ones missed in master cluster are skipped, secrets maps the names to if they are optional
+func (v *VirtualK8S) createSecrets(ctx context.Context, secrets map[string]bool, ns string) error {
+	lowerNamespace := v.lowerNamespace(ns)
+	for _, secretName := range util.ReferenceNames(secrets, false) {
+		_, err := v.clientCache.secretLister.Secrets(lowerNamespace).Get(secretName)
 		if err == nil {
 			continue
 		}
@@ -348,9 +619,18 @@ func (v *VirtualK8S) createSecrets(ctx context.Context, secrets []string, ns str
 		secret, err := v.rm.GetSecret(secretName, ns)
 
 		if err != nil {
+			if errors.IsNotFound(err) && secrets[secretName] {
+				continue
+			}
 			return err
 		}
 		util.TrimObjectMeta(&secret.ObjectMeta)
+		transformed := &corev1.Secret{}
+		if err = v.transform(secretKind, secret, transformed); err != nil {
+			return err
+		}
+		secret = transformed
+		secret.Namespace = lowerNamespace
 		// skip service account secret
 		if secret.Type == corev1.SecretTypeServiceAccountToken {
 			if err := v.createServiceAccount(ctx, secret); err != nil {
@@ -359,7 +639,7 @@ func (v *VirtualK8S) createSecrets(ctx context.Context, secrets []string, ns str
 			}
 		}
 		controllers.SetObjectGlobal(&secret.ObjectMeta)
-		_, err = v.client.CoreV1().Secrets(ns).Create(ctx, secret, metav1.CreateOptions{})
+		created, err := v.client.CoreV1().Secrets(lowerNamespace).Create(ctx, secret, metav1.CreateOptions{})
 		if err != nil {
 			if errors.IsAlreadyExists(err) {
 				continue
@@ -367,6 +647,7 @@ func (v *VirtualK8S) createSecrets(ctx context.Context, secrets []string, ns str
 			klog.Errorf("Failed to create secret %v err: %v", secretName, err)
 			return fmt.Errorf("could not create secret %s in external cluster: %v", secretName, err)
 		}
+		controllers.RecordCreatedSecret(v.conflicts, secret, created)
 	}
 	return nil
 }
@@ -428,22 +709,34 @@ func (v *VirtualK8S) createServiceAccount(ctx context.Context, secret *corev1.Se
 	return nil
 }
 
-// createConfigMaps a Kubernetes Pod and deploys it within the provider.
-func (v *VirtualK8S) createConfigMaps(ctx context.Context, configmaps []string, ns string) error {
-	for _, cm := range configmaps {
-		_, err := v.clientCache.cmLister.ConfigMaps(ns).Get(cm)
+// createConfigMaps creates the configMaps of master cluster referenced in client cluster if absent, the
+// optional ones missed in master cluster are skipped, configmaps maps the names to if they are optional
+func (v *VirtualK8S) createConfigMaps(ctx context.Context, configmaps map[string]bool, ns string) error {
+	lowerNamespace := v.lowerNamespace(ns)
+	for _, cm := range util.ReferenceNames(configmaps, false) {
+		_, err := v.clientCache.cmLister.ConfigMaps(lowerNamespace).Get(cm)
 		if err == nil {
 			continue
 		}
 		if errors.IsNotFound(err) {
 			configMap, err := v.rm.GetConfigMap(cm, ns)
 			if err != nil {
+				if errors.IsNotFound(err) && configmaps[cm] {
+					continue
+				}
 				return fmt.Errorf("find comfigmap %v error %v", cm, err)
 			}
 			util.TrimObjectMeta(&configMap.ObjectMeta)
+			transformed := &corev1.ConfigMap{}
+			if err = v.transform(configMapKind, configMap, transformed); err != nil {
+				return err
+			}
+			configMap = transformed
+			configMap.Namespace = lowerNamespace
 			controllers.SetObjectGlobal(&configMap.ObjectMeta)
 
-			_, err = v.client.CoreV1().ConfigMaps(ns).Create(ctx, configMap, metav1.CreateOptions{})
+			created, err := v.client.CoreV1().ConfigMaps(lowerNamespace).Create(ctx, configMap,
+				metav1.CreateOptions{})
 			if err != nil {
 				if errors.IsAlreadyExists(err) {
 					continue
@@ -451,7 +744,8 @@ func (v *VirtualK8S) createConfigMaps(ctx context.Context, configmaps []string,
 				klog.Errorf("Failed to create configmap %v err: %v", cm, err)
 				return err
 			}
-			klog.Infof("Create %v in %v success", cm, ns)
+			controllers.RecordCreatedConfigMap(v.conflicts, configMap, created)
+			klog.Infof("Create %v in %v success", cm, lowerNamespace)
 			continue
 		}
 		return fmt.Errorf("could not check configmap %s in external cluster: %v", cm, err)
@@ -475,21 +769,39 @@ func (v *VirtualK8S) deleteConfigMaps(ctx context.Context, configmaps []string,
 	return nil
 }
 
-// createPVCs a Kubernetes Pod and deploys it within the provider.
+// createPVCs creates the pvcs of master cluster the pod uses in client cluster if absent, the status of them is
+// synced back by the pv controller once bound in client cluster
 func (v *VirtualK8S) createPVCs(ctx context.Context, pvcs []string, ns string) error {
+	lowerNamespace := v.lowerNamespace(ns)
 	for _, cm := range pvcs {
-		_, err := v.client.CoreV1().PersistentVolumeClaims(ns).Get(ctx, cm, metav1.GetOptions{})
+		_, err := v.client.CoreV1().PersistentVolumeClaims(lowerNamespace).Get(ctx, cm, metav1.GetOptions{})
 		if err == nil {
 			continue
 		}
 		if errors.IsNotFound(err) {
 			pvc, err := v.master.CoreV1().PersistentVolumeClaims(ns).Get(ctx, cm, metav1.GetOptions{})
 			if err != nil {
-				continue
+				// the pod could not run in client cluster without the claim
+				return fmt.Errorf("could not get pvc %s in master cluster: %v", cm, err)
+			}
+			keepVolume := false
+			if len(pvc.Spec.VolumeName) != 0 {
+				_, err = v.client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+				if err != nil && !errors.IsNotFound(err) {
+					return fmt.Errorf("could not check pv %s in external cluster: %v", pvc.Spec.VolumeName, err)
+				}
+				keepVolume = err == nil
+			}
+			controllers.PrepareLowerPVC(pvc, keepVolume)
+			transformed := &corev1.PersistentVolumeClaim{}
+			if err = v.transform(pvcKind, pvc, transformed); err != nil {
+				return err
 			}
-			util.TrimObjectMeta(&pvc.ObjectMeta)
+			pvc = transformed
+			pvc.Namespace = lowerNamespace
 			controllers.SetObjectGlobal(&pvc.ObjectMeta)
-			_, err = v.client.CoreV1().PersistentVolumeClaims(ns).Create(ctx, pvc, metav1.CreateOptions{})
+			_, err = v.client.CoreV1().PersistentVolumeClaims(lowerNamespace).Create(ctx, pvc,
+				metav1.CreateOptions{})
 			if err != nil {
 				if errors.IsAlreadyExists(err) {
 					continue
@@ -135,6 +135,33 @@ func TestUpdatePod(t *testing.T) {
 	}
 }
 
+func TestUpdatePodUnchanged(t *testing.T) {
+	vk, _, podInformer := newFakeVirtualK8S()
+	ctx := context.Background()
+	upper := fakePod("test")
+	upper.UID = "uid"
+	upper.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	upper.Annotations = map[string]string{"a": "b"}
+	lower := upper.DeepCopy()
+	lower.UID = ""
+	setUpperUID(lower, upper.UID)
+	setUpperResources(lower, upper)
+	vk.setClusterIdentity(lower)
+	vk.setOriginLabels(lower, upper)
+	podInformer.Informer().GetStore().Add(lower)
+	client := vk.client.(*fake.Clientset)
+
+	if err := vk.UpdatePod(ctx, upper); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("Desire no update of unchanged pod, get %v", client.Actions())
+	}
+	if len(upper.Annotations) != 1 || len(upper.Labels) != 1 {
+		t.Errorf("Desire upper pod unchanged, get labels %v annotations %v", upper.Labels, upper.Annotations)
+	}
+}
+
 func TestDeletePod(t *testing.T) {
 	vk, _, podInformer := newFakeVirtualK8S()
 	ctx := context.Background()
@@ -211,6 +238,44 @@ func TestGetPod(t *testing.T) {
 	}
 }
 
+func TestStalePod(t *testing.T) {
+	vk, _, podInformer := newFakeVirtualK8S()
+	ctx := context.Background()
+	lower := fakePod("test")
+	lower.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	setUpperUID(lower, "old")
+	podInformer.Informer().GetStore().Add(lower)
+
+	current := fakePod("test")
+	current.UID = "new"
+	vk.recordUpperUID(current)
+	if _, err := vk.GetPod(ctx, lower.Namespace, lower.Name); err == nil {
+		t.Error("Desire stale pod not found")
+	}
+	if pods, _ := vk.GetPods(ctx); len(pods) != 0 {
+		t.Errorf("Desire stale pod filtered, get %v", pods)
+	}
+	if err := vk.CreatePod(ctx, current); err == nil {
+		t.Error("Desire error when stale pod exists")
+	}
+	updated := current.DeepCopy()
+	updated.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	if err := vk.UpdatePod(ctx, updated); err == nil {
+		t.Error("Desire error when updating with stale pod exists")
+	}
+
+	previous := fakePod("test")
+	previous.UID = "old"
+	vk.recordUpperUID(previous)
+	pod, err := vk.GetPod(ctx, lower.Namespace, lower.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pod.Annotations[util.UpperPodUID]; ok {
+		t.Errorf("Desire uid annotation hidden, get %v", pod.Annotations)
+	}
+}
+
 func newFakeVirtualK8S() (*VirtualK8S, v1.NamespaceInformer, v1.PodInformer) {
 	client := fake.NewSimpleClientset()
 	master := fake.NewSimpleClientset()
@@ -223,8 +288,9 @@ func newFakeVirtualK8S() (*VirtualK8S, v1.NamespaceInformer, v1.PodInformer) {
 		master: master,
 		client: client,
 		clientCache: clientCache{
-			nsLister:  nsInformer.Lister(),
-			podLister: podInformer.Lister(),
+			nsLister:   nsInformer.Lister(),
+			podLister:  podInformer.Lister(),
+			podIndexer: podInformer.Informer().GetIndexer(),
 		},
 	}, nsInformer, podInformer
 }
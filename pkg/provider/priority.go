@@ -0,0 +1,139 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/controllers"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// systemPriorityClassPrefix is the prefix of the priority classes built in every cluster
+	systemPriorityClassPrefix = "system-"
+	// preemptionByScheduler and preemptionByKubeScheduler are the reasons of the DisruptionTarget condition
+	// of pods preempted by the scheduler, the latter is used by k8s 1.26
+	preemptionByScheduler     = "PreemptionByScheduler"
+	preemptionByKubeScheduler = "PreemptionByKubeScheduler"
+)
+
+// translatePriority translates the priority class of the pod created in the lower cluster. The classes in
+// priorityClasses are renamed, an empty name drops the class, otherwise the class is synced from the upper
+// cluster if syncPriorityClasses. The priority and preemption policy resolved by the upper cluster are cleared,
+// so they are resolved from the class of lower cluster instead of being rejected by its admission for a
+// different value
+func (v *VirtualK8S) translatePriority(ctx context.Context, pod *corev1.Pod) error {
+	name := pod.Spec.PriorityClassName
+	if len(name) == 0 {
+		return nil
+	}
+	pod.Spec.Priority = nil
+	pod.Spec.PreemptionPolicy = nil
+	if mapped, ok := v.priorityClasses[name]; ok {
+		pod.Spec.PriorityClassName = mapped
+		return nil
+	}
+	if !v.syncPriorityClasses || strings.HasPrefix(name, systemPriorityClassPrefix) {
+		return nil
+	}
+	return v.createPriorityClass(ctx, name)
+}
+
+// createPriorityClass creates the priority class of upper cluster in the lower cluster if absent, the existing
+// one is kept even if its value differs. It is never the global default of the lower cluster
+func (v *VirtualK8S) createPriorityClass(ctx context.Context, name string) error {
+	_, err := v.client.SchedulingV1().PriorityClasses().Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return fmt.Errorf("could not check priority class %v in client cluster: %v", name, err)
+	}
+	pc, err := v.master.SchedulingV1().PriorityClasses().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get priority class %v: %v", name, err)
+	}
+	util.TrimObjectMeta(&pc.ObjectMeta)
+	pc.GlobalDefault = false
+	controllers.SetObjectGlobal(&pc.ObjectMeta)
+	if _, err = v.client.SchedulingV1().PriorityClasses().Create(ctx, pc,
+		metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("could not create priority class %v: %v", name, err)
+	}
+	klog.Infof("Create priority class %v success", name)
+	return nil
+}
+
+// preemption returns the DisruptionTarget condition of the pod preempted by the scheduler, nil is returned if
+// the pod is not preempted
+func preemption(status *corev1.PodStatus) *corev1.PodCondition {
+	for i, cond := range status.Conditions {
+		if cond.Type == disruptionTargetCondition && cond.Status == corev1.ConditionTrue &&
+			(cond.Reason == preemptionByScheduler || cond.Reason == preemptionByKubeScheduler) {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// deletePreempted reflects the preemption of the deleted lower pod to its upper pod, the condition is added to
+// the upper pod which is then deleted, like the victims of the scheduler in upper cluster, so their
+// controllers create replacements instead of seeing failed pods. false is returned if the lower pod is not
+// preempted or the upper pod is not deleted
+func (v *VirtualK8S) deletePreempted(ctx context.Context, lower *corev1.Pod) bool {
+	cond := preemption(&lower.Status)
+	if cond == nil {
+		return false
+	}
+	condition := cond.DeepCopy()
+	condition.Message = fmt.Sprintf("pod is preempted in lower cluster %v: %v", v.clusterName, cond.Message)
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []*corev1.PodCondition{condition},
+		},
+	})
+	if err != nil {
+		klog.Errorf("Marshal condition of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return false
+	}
+	pods := v.upperPods(lower)
+	if _, err = pods.Patch(ctx, lower.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{},
+		"status"); err != nil {
+		klog.Errorf("Patch condition of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return false
+	}
+	opts := metav1.DeleteOptions{GracePeriodSeconds: new(int64)}
+	if uid := getUpperUID(lower); len(uid) != 0 {
+		opts.Preconditions = metav1.NewUIDPreconditions(string(uid))
+	}
+	if err = pods.Delete(ctx, lower.Name, opts); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Delete preempted pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return false
+	}
+	klog.Infof("Pod %v/%v preempted in lower cluster is deleted", lower.Namespace, lower.Name)
+	return true
+}
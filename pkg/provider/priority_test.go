@@ -0,0 +1,99 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestTranslatePriority(t *testing.T) {
+	ctx := context.Background()
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	vk.priorityClasses = map[string]string{"mapped": "lower", "dropped": ""}
+	vk.syncPriorityClasses = true
+	vk.master.SchedulingV1().PriorityClasses().Create(ctx, &schedulingv1.PriorityClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "high", UID: "uid"}, Value: 1000, GlobalDefault: true,
+	}, metav1.CreateOptions{})
+	priority := int32(1000)
+	newPod := func(class string) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{PriorityClassName: class, Priority: &priority}}
+	}
+
+	cases := []struct {
+		class   string
+		desired string
+	}{
+		{class: "mapped", desired: "lower"},
+		{class: "dropped", desired: ""},
+		{class: "high", desired: "high"},
+		{class: "system-node-critical", desired: "system-node-critical"},
+	}
+	for _, c := range cases {
+		pod := newPod(c.class)
+		if err := vk.translatePriority(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+		if pod.Spec.PriorityClassName != c.desired || pod.Spec.Priority != nil {
+			t.Fatalf("Desire class %v without priority, get %v, %v", c.desired, pod.Spec.PriorityClassName,
+				pod.Spec.Priority)
+		}
+	}
+	synced, err := vk.client.SchedulingV1().PriorityClasses().Get(ctx, "high", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.Value != 1000 || synced.GlobalDefault || len(synced.UID) != 0 {
+		t.Fatalf("Desire class of value 1000 synced not as default, get %+v", synced)
+	}
+	if _, err = vk.client.SchedulingV1().PriorityClasses().Get(ctx, "system-node-critical",
+		metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire system class not synced, get %v", err)
+	}
+	if err = vk.translatePriority(ctx, newPod("missing")); err == nil {
+		t.Fatal("Desire class missing in upper cluster rejected")
+	}
+}
+
+func TestDeletePreempted(t *testing.T) {
+	ctx := context.Background()
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	upper := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "upper-uid"}}
+	vk.master.CoreV1().Pods("default").Create(ctx, upper, metav1.CreateOptions{})
+	lower := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+		Annotations: map[string]string{util.UpperPodUID: "upper-uid"}}}
+
+	if vk.deletePreempted(ctx, lower) {
+		t.Fatal("Desire pod not preempted kept")
+	}
+	lower.Status.Conditions = []corev1.PodCondition{{Type: disruptionTargetCondition,
+		Status: corev1.ConditionTrue, Reason: preemptionByScheduler, Message: "preempting"}}
+	if !vk.deletePreempted(ctx, lower) {
+		t.Fatal("Desire preempted pod deleted")
+	}
+	if _, err := vk.master.CoreV1().Pods("default").Get(ctx, "test",
+		metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire upper pod deleted, get %v", err)
+	}
+}
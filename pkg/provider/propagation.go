@@ -0,0 +1,177 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// propagatedMetadata is the labels and annotations of lower nodes propagated to the virtual node
+type propagatedMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SetNodePropagation replaces the propagation of the metadata of lower nodes live by the one of cfg
+func (v *VirtualK8S) SetNodePropagation(cfg *config.ProviderConfig) {
+	v.propagationLock.Lock()
+	v.propagation = cfg.PropagationOf()
+	v.propagationLock.Unlock()
+	v.propagateNodeMetadata()
+}
+
+// runNodePropagation propagates the metadata of lower nodes to the virtual node periodically
+func (v *VirtualK8S) runNodePropagation(ctx context.Context) {
+	wait.Until(v.propagateNodeMetadata, fitSummaryPeriod, ctx.Done())
+}
+
+// propagateNodeMetadata aggregates the labels, annotations and taints of the ready and schedulable lower nodes
+// selected by the propagation, the labels and annotations are recorded in the annotation of virtual node so
+// they are removed once no longer propagated, the taints are published with the cluster taints
+func (v *VirtualK8S) propagateNodeMetadata() {
+	v.propagationLock.Lock()
+	defer v.propagationLock.Unlock()
+	policy := v.propagation
+	if policy == nil && v.propagated == nil {
+		return
+	}
+	nodes, err := v.physicalNodes()
+	if err != nil {
+		klog.Errorf("List nodes failed: %v", err)
+		return
+	}
+	propagated := &propagatedMetadata{}
+	var taints []corev1.Taint
+	if policy != nil {
+		propagated.Labels = aggregateEntries(policy.Labels, nodes, func(node *corev1.Node) map[string]string {
+			return node.Labels
+		}, false)
+		propagated.Annotations = aggregateEntries(policy.Annotations, nodes,
+			func(node *corev1.Node) map[string]string {
+				return node.Annotations
+			}, true)
+		taints = aggregateTaints(policy.Taints, nodes)
+	}
+	data, err := json.Marshal(propagated)
+	if err != nil {
+		klog.Errorf("Marshal propagated metadata failed: %v", err)
+		return
+	}
+	v.propagated = propagated
+	v.setNodeAnnotation(util.PropagatedMetadata, string(data))
+
+	v.clusterTaintsLock.Lock()
+	v.propagatedTaints = taints
+	v.clusterTaintsLock.Unlock()
+	v.publishClusterTaints()
+}
+
+// aggregateEntries aggregates the entries of nodes selected by the rule, the common ones are those all nodes
+// have with the same value. With union, a key of differing values is dropped, or its sorted values are joined
+// by "," if join
+func aggregateEntries(rule config.PropagationRule, nodes []*corev1.Node,
+	entriesOf func(*corev1.Node) map[string]string, join bool) map[string]string {
+	values := make(map[string]map[string]int)
+	for _, node := range nodes {
+		for k, value := range entriesOf(node) {
+			if !rule.Selects(k) {
+				continue
+			}
+			if values[k] == nil {
+				values[k] = make(map[string]int)
+			}
+			values[k][value]++
+		}
+	}
+	aggregated := make(map[string]string)
+	for k, counts := range values {
+		distinct := make([]string, 0, len(counts))
+		for value, count := range counts {
+			if !rule.Union() && count != len(nodes) {
+				continue
+			}
+			distinct = append(distinct, value)
+		}
+		switch {
+		case len(distinct) == 1:
+			aggregated[k] = distinct[0]
+		case len(distinct) > 1 && join:
+			sort.Strings(distinct)
+			aggregated[k] = strings.Join(distinct, ",")
+		}
+	}
+	return aggregated
+}
+
+// aggregateTaints aggregates the taints of nodes selected by the rule, the common ones are those all nodes have.
+// With union, the taints of a key and effect with differing values are put once with an empty value, since the
+// taints of a node are unique by key and effect
+func aggregateTaints(rule config.PropagationRule, nodes []*corev1.Node) []corev1.Taint {
+	var taints []corev1.Taint
+	counts := make(map[corev1.Taint]int)
+	for _, node := range nodes {
+		for _, taint := range node.Spec.Taints {
+			if !rule.Selects(taint.Key) {
+				continue
+			}
+			taint.TimeAdded = nil
+			if counts[taint] == 0 {
+				taints = append(taints, taint)
+			}
+			counts[taint]++
+		}
+	}
+	aggregated := make([]corev1.Taint, 0, len(taints))
+	for _, taint := range taints {
+		if !rule.Union() {
+			if counts[taint] == len(nodes) {
+				aggregated = append(aggregated, taint)
+			}
+			continue
+		}
+		merged := false
+		for i := range aggregated {
+			if aggregated[i].MatchTaint(&taint) {
+				aggregated[i].Value = ""
+				merged = true
+			}
+		}
+		if !merged {
+			aggregated = append(aggregated, taint)
+		}
+	}
+	return aggregated
+}
+
+// mergeAbsent puts the entries of from absent in to
+func mergeAbsent(to, from map[string]string) {
+	for k, value := range from {
+		if _, ok := to[k]; !ok {
+			to[k] = value
+		}
+	}
+}
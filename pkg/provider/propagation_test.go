@@ -0,0 +1,132 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+func TestPropagateNodeMetadata(t *testing.T) {
+	ctx := context.Background()
+	vk, nodeInformer, _ := newFakeVirtualK8SWithNodePod()
+	newNode := func(name, zone, gpu string, taints ...corev1.Taint) *corev1.Node {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+				"topology.kubernetes.io/zone": zone, "kubernetes.io/hostname": name}},
+			Spec: corev1.NodeSpec{Taints: taints},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		if len(gpu) != 0 {
+			node.Labels["nvidia.com/gpu.product"] = gpu
+		}
+		return node
+	}
+	dedicated := corev1.Taint{Key: "dedicated", Value: "ai", Effect: corev1.TaintEffectNoSchedule}
+	nodeInformer.Informer().GetStore().Add(newNode("node1", "zone-a", "A100", dedicated))
+	nodeInformer.Informer().GetStore().Add(newNode("node2", "zone-a", "", dedicated))
+
+	vk.SetNodePropagation(&config.ProviderConfig{Propagation: &config.NodePropagation{
+		Labels: config.PropagationRule{Include: []string{"topology.kubernetes.io/*", "nvidia.com/*"},
+			Aggregation: config.AggregateUnion},
+		Taints: config.PropagationRule{Include: []string{"dedicated"}},
+	}})
+	if vk.propagated.Labels["topology.kubernetes.io/zone"] != "zone-a" ||
+		vk.propagated.Labels["nvidia.com/gpu.product"] != "A100" || len(vk.propagated.Labels) != 2 {
+		t.Fatalf("Desire zone and gpu labels propagated, get %v", vk.propagated.Labels)
+	}
+	if len(vk.propagatedTaints) != 1 || vk.propagatedTaints[0] != dedicated {
+		t.Fatalf("Desire taint dedicated propagated, get %v", vk.propagatedTaints)
+	}
+
+	vk.master = fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk"}})
+	vk.providerNode.Node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk",
+		Labels: map[string]string{"topology.kubernetes.io/zone": "virtual"}}}
+	if err := vk.patchNodeMetadata(ctx, vk.desiredNodeMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	node, err := vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Labels["topology.kubernetes.io/zone"] != "virtual" || node.Labels["nvidia.com/gpu.product"] != "A100" ||
+		len(node.Spec.Taints) != 1 {
+		t.Fatalf("Desire propagated metadata patched without overriding the provider, get %v, %v", node.Labels,
+			node.Spec.Taints)
+	}
+
+	// the metadata no longer propagated is removed
+	vk.SetNodePropagation(&config.ProviderConfig{})
+	if err = vk.patchNodeMetadata(ctx, vk.desiredNodeMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	if node, err = vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.Labels["nvidia.com/gpu.product"]; ok || len(node.Spec.Taints) != 0 ||
+		node.Labels["topology.kubernetes.io/zone"] != "virtual" {
+		t.Fatalf("Desire propagated metadata removed, get %v, %v", node.Labels, node.Spec.Taints)
+	}
+}
+
+func TestAggregateEntries(t *testing.T) {
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"a": "1", "b": "x"}}},
+		{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"a": "1", "b": "y", "c": "z"}}},
+	}
+	annotationsOf := func(node *corev1.Node) map[string]string {
+		return node.Annotations
+	}
+	cases := []struct {
+		rule    config.PropagationRule
+		join    bool
+		desired map[string]string
+	}{
+		{
+			rule:    config.PropagationRule{Include: []string{"*"}},
+			desired: map[string]string{"a": "1"},
+		},
+		{
+			rule:    config.PropagationRule{Include: []string{"*"}, Exclude: []string{"a"}, Aggregation: "Union"},
+			desired: map[string]string{"c": "z"},
+		},
+		{
+			rule:    config.PropagationRule{Include: []string{"*"}, Aggregation: "Union"},
+			join:    true,
+			desired: map[string]string{"a": "1", "b": "x,y", "c": "z"},
+		},
+	}
+	for _, c := range cases {
+		aggregated := aggregateEntries(c.rule, nodes, annotationsOf, c.join)
+		if len(aggregated) != len(c.desired) {
+			t.Fatalf("Desire %v, get %v", c.desired, aggregated)
+		}
+		for k, value := range c.desired {
+			if aggregated[k] != value {
+				t.Fatalf("Desire %v, get %v", c.desired, aggregated)
+			}
+		}
+	}
+}
@@ -19,25 +19,51 @@ package provider
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/virtual-kubelet/node-cli/manager"
 	"github.com/virtual-kubelet/node-cli/opts"
 	"github.com/virtual-kubelet/node-cli/provider"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/discovery/cached/memory"
 	kubeinformers "k8s.io/client-go/informers"
 	informerv1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	v1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
 
+	"github.com/virtual-kubelet/tensile-kube/pkg/alert"
 	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+	"github.com/virtual-kubelet/tensile-kube/pkg/conflict"
+	"github.com/virtual-kubelet/tensile-kube/pkg/dependency"
+	"github.com/virtual-kubelet/tensile-kube/pkg/translation"
+	"github.com/virtual-kubelet/tensile-kube/pkg/tunnel"
 	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/work"
+)
+
+const (
+	// failoverCheckPeriod is the period to check health of the active apiserver endpoint of lower cluster
+	failoverCheckPeriod = 10 * time.Second
+	// clientResyncPeriod is the default resync period of informers of lower cluster shared with controllers,
+	// handlers of provider never resync
+	clientResyncPeriod = time.Minute
 )
 
 // ClientConfig defines the configuration of a lower cluster
@@ -46,13 +72,128 @@ type ClientConfig struct {
 	KubeClientQPS int
 	// allowed burst of the kube client
 	KubeClientBurst int
-	// config path of the kube client
+	// config path of the kube client, in-cluster config is used if empty in pull mode
 	ClientKubeConfigPath string
+	// the virtual node runs in the lower cluster and pulls pods from the upper cluster
+	PullMode bool
+	// path to persist the snapshot of pods in lower cluster, snapshot is disabled if empty
+	SnapshotPath string
+	// address the tunnel server listens on for agents of lower cluster, reverse tunnel is disabled if empty
+	TunnelListenAddress string
+	// token to authenticate agents of the tunnel
+	TunnelToken string
+	// cert and key of the tunnel server, required with the token if the tunnel is enabled
+	TunnelCertFile string
+	TunnelKeyFile  string
+	// operate pods in lower cluster impersonating the tenant recorded on the upper namespace
+	EnableImpersonation bool
+	// users and groups allowed to impersonate, required if impersonation is enabled
+	ImpersonationUsers  []string
+	ImpersonationGroups []string
+	// apiserver endpoints of the lower cluster to fail over between, the server in kubeconfig is used if empty
+	ClientEndpoints []string
+	// ratio applied to cpu capacity of the lower cluster nodes, 1 means no overcommit
+	CPUOvercommitRatio float64
+	// ratio applied to memory capacity of the lower cluster nodes, 1 means no overcommit
+	MemoryOvercommitRatio float64
+	// name and region of the lower cluster exposed to pods, the name defaults to the virtual node name
+	ClusterName   string
+	ClusterRegion string
+	// network zone of the lower cluster labeled on the virtual node, omitted if empty
+	NetworkZone string
+	// name of the upper cluster labeled on the lower pods, omitted if empty
+	UpperClusterName string
+	// namespaces of the upper cluster are translated into the lower cluster by the explicit mappings first and
+	// else by the prefix, kept if neither set
+	NamespacePrefix  string
+	NamespaceMapping map[string]string
+	// url to post alerts of sync failures to, disabled if empty
+	AlertWebhookURL string
+	// record alerts of sync failures as events on upper objects
+	AlertEvents bool
+	// sync failures lasting longer than the threshold are alerted
+	AlertThreshold time.Duration
+	// hooks rewriting pods before they are created in the lower cluster
+	TranslationHooks translation.Chain
+	// max minor versions the lower cluster and the upper cluster could differ, skews beyond it are reported
+	MaxVersionSkew int
+	// mirror events of lower pods to the upper pods, repeats are aggregated and each pod allows burst events
+	// refilled one per interval
+	MirrorEvents        bool
+	MirrorEventBurst    int
+	MirrorEventInterval time.Duration
+	// lower objects rewritten threshold times within the window by another writer are conflicting, and are
+	// resolved by the resolution, one of Force, BackOff and Alert
+	ConflictResolution string
+	ConflictThreshold  int
+	ConflictWindow     time.Duration
+	// calculator of the resources advertised by the virtual node, one of Sum, SumMinusReserved, MaxSinglePod and
+	// PercentileOfFree, the reserved resources and the percentile are only used by the latter two respectively
+	CapacityCalculator string
+	CapacityReserved   map[string]string
+	CapacityPercentile float64
+	// label selector of pods created in the lower cluster directly whose requests are not kept back from the
+	// virtual node, all of them are kept back if empty
+	ReservedPodsExclude string
+	// pods unschedulable in the lower cluster longer than it are failed to be rescheduled, and the virtual node is
+	// unfit for the pods of the same controller within UnfitDuration, disabled if 0
+	RescheduleUnschedulableAfter time.Duration
+	UnfitDuration                time.Duration
+	// priority classes of upper pods renamed in the lower cluster, an empty name drops the class
+	PriorityClassMapping map[string]string
+	// create the priority classes of upper pods not in PriorityClassMapping in the lower cluster if absent
+	SyncPriorityClasses bool
+	// inject the service account tokens requested from the upper cluster into lower pods instead of the ones
+	// minted by the lower cluster, the tokens are rotated before they expire
+	UpperServiceAccountTokens bool
+	// pod operations in flight in the lower cluster and the rate they start at, unlimited if 0
+	PodOperationConcurrency int
+	PodOperationQPS         float32
+	PodOperationBurst       int
+	// grace period in seconds of lower pods deleted, the one of the upper pod is propagated if negative, upper pods
+	// are finalized once their lower pods are gone unless ForceDeletePods, which deletes lower pods immediately
+	PodDeletionGracePeriod int64
+	ForceDeletePods        bool
+	// translation of the addresses of pods reachable from the upper cluster, one of HostIP, NodePort,
+	// LoadBalancer and Gateway, disabled if empty, published in the target, Annotation or Status, the gateway
+	// address is required by Gateway
+	AddressTranslation string
+	AddressTarget      string
+	GatewayAddress     string
+	// custom resources whose objects pods depend on are mirrored into the lower cluster before the pods, in
+	// <resource>.<version>.<group>[=<field path>;...], and the resolvers of the dependencies besides the
+	// annotation of pods, disabled if no resource declared
+	CustomDependencies  []string
+	DependencyResolvers []dependency.Resolver
+	// the apiserver of the lower cluster is probed every period within the timeout, it is unreachable once
+	// failing threshold probes in a row or its pods failing to be listed and watched longer than the stale
+	// threshold, Ping checks the lower cluster instead if the period is 0
+	HealthProbePeriod      time.Duration
+	HealthProbeTimeout     time.Duration
+	HealthFailureThreshold int
+	InformerStaleThreshold time.Duration
+	// the upper pod of each name and the lower pod created for it are persisted in a configMap of the namespace
+	// in the upper cluster and rebuilt on startup, disabled if empty
+	PodMappingNamespace string
+	// the Works in the namespace of the upper cluster are applied to the lower cluster, see pkg/work, disabled
+	// if empty
+	WorkNamespace string
+	// the usage of upper pods reported by metrics-server of the lower cluster is recorded as prometheus metrics
+	// every period for the custom metrics of HPAs, disabled if 0
+	OffloadedPodMetricsPeriod time.Duration
+	// fields of lower pods owned by the lower cluster, e.g. set by its admission webhooks, which are never
+	// rewritten by upper pods, each in labels:<key>, annotations:<key>, images:<container> or tolerations
+	LowerOwnedFields []string
+	// client and informer factory of the upper cluster shared by providers in the same process,
+	// built from the kubeconfig of the virtual node if nil
+	MasterClient   kubernetes.Interface
+	MasterInformer kubeinformers.SharedInformerFactory
 }
 
 // clientCache wraps the lister of client cluster
 type clientCache struct {
 	podLister    v1.PodLister
+	podIndexer   cache.Indexer
 	nsLister     v1.NamespaceLister
 	cmLister     v1.ConfigMapLister
 	secretLister v1.SecretLister
@@ -66,9 +207,14 @@ type VirtualK8S struct {
 	metricClient         versioned.Interface
 	config               *rest.Config
 	nodeName             string
+	clusterName          string
+	clusterRegion        string
+	networkZone          string
+	upperClusterName     string
+	namespaces           *util.NamespaceMapping
 	version              string
 	daemonPort           int32
-	ignoreLabels         []string
+	filters              *syncFilters
 	clientCache          clientCache
 	rm                   *manager.ResourceManager
 	updatedNode          chan *corev1.Node
@@ -76,17 +222,211 @@ type VirtualK8S struct {
 	enableServiceAccount bool
 	stopCh               <-chan struct{}
 	providerNode         *common.ProviderNode
+	startTime            metav1.Time
 	configured           bool
+	cpuOvercommitRatio   float64
+	memOvercommitRatio   float64
+	tunnelProxy          *url.URL
+	impersonator         *impersonator
+	masterInformer       kubeinformers.SharedInformerFactory
+	clientInformer       kubeinformers.SharedInformerFactory
+	recovery             *recovery
+	eventRecorder        record.EventRecorder
+	alerts               *alert.Tracker
+	conflicts            *conflict.Detector
+	capacityCalculator   common.CapacityCalculator
+	// reservedExclude selects the lower pods not reserving resources of the virtual node
+	reservedExclude labels.Selector
+	// capacitySync queues the recalculation of the resource of virtual node if the calculator is not additive
+	capacitySync chan struct{}
+	// nodeStatusOnly skips publishing the node metadata, the node is shared with the primary cluster of an
+	// aggregate which publishes it
+	nodeStatusOnly bool
+	// upperUIDs records the uid of the latest upper pod of each name
+	upperUIDs sync.Map
+	// podMapping persists the upper pods and the lower pods created for them, nil if disabled
+	podMapping *podMapping
+	// nodeAnnotations and nodeLabels are published by the provider loops and patched to the upper node by
+	// syncNodeMetadata
+	nodeAnnotations     map[string]string
+	nodeLabels          map[string]string
+	nodeAnnotationsLock sync.Mutex
+	// reclaimingPods records the uids of lower pods marked as reclaiming of each lower node
+	reclaimingPods sync.Map
+	// translationHooks rewrite pods before they are created in the lower cluster
+	translationHooks translation.Chain
+	// transformations patch objects before they are created in the lower cluster, changed live
+	transformations     []config.Transformation
+	transformationsLock sync.RWMutex
+	// resourceRules rewrite the resources of containers of lower pods, changed live
+	resourceRules     []config.ResourceRule
+	resourceRulesLock sync.RWMutex
+	// ownedFields are the fields of lower pods owned by the lower cluster, nil if none
+	ownedFields *lowerOwnedFields
+	// syncFiltersLock guards filters changed live by SetSyncFilters
+	syncFiltersLock sync.RWMutex
+	// overcommitLock guards the overcommit ratios changed live by SetOvercommitRatios
+	overcommitLock sync.RWMutex
+	// features is the optional features supported by the lower cluster, probed when connecting to it
+	features clusterFeatures
+	// upperFeatures is the optional features supported by the upper cluster, translations relying on a
+	// feature are disabled if either side lacks it
+	upperFeatures  clusterFeatures
+	versionSkew    *versionSkew
+	maxVersionSkew int
+	// upperServiceAccountTokens replaces the serviceAccountToken projections of lower pods with the tokens of
+	// upper cluster
+	upperServiceAccountTokens bool
+	// rescheduleAfter is how long pods could be unschedulable in the lower cluster before rescheduled, and
+	// unfitDuration is how long the virtual node is unfit for the pods of the same controller
+	rescheduleAfter time.Duration
+	unfitDuration   time.Duration
+	// unfitPods records until when the virtual node is unfit for the pods of each scheduling key
+	unfitPods map[string]time.Time
+	unfitLock sync.Mutex
+	// rescheduled records the failures of the lower pods deleted to be rescheduled by uid
+	rescheduled sync.Map
+	// priorityClasses renames the priority classes of lower pods, the others are synced from the upper cluster
+	// if syncPriorityClasses
+	priorityClasses     map[string]string
+	syncPriorityClasses bool
+	// configTaints are declared for the cluster and propagatedTaints are propagated from lower nodes, both
+	// published as the cluster taints
+	configTaints      []corev1.Taint
+	propagatedTaints  []corev1.Taint
+	clusterTaintsLock sync.Mutex
+	// propagation selects the metadata of lower nodes propagated to the virtual node, changed live, and
+	// propagated is the labels and annotations propagated last
+	propagation     *config.NodePropagation
+	propagated      *propagatedMetadata
+	propagationLock sync.Mutex
+	// podThrottle bounds the pod operations in the lower cluster, nil if unlimited
+	podThrottle *podThrottle
+	// addressTranslation translates the addresses of pods reachable from the upper cluster, published in
+	// addressTarget, disabled if empty, and addresses records the ones published by the uid of upper pods
+	addressTranslation string
+	addressTarget      string
+	gatewayAddress     string
+	addresses          sync.Map
+	// lowerNodes records the lower nodes published to upper pods by the uid of upper pods
+	lowerNodes sync.Map
+	// deletionGracePeriod overrides the grace period of lower pods deleted if not nil, and forceDelete deletes
+	// lower pods immediately without holding upper pods by util.PodFinalizer
+	deletionGracePeriod *int64
+	forceDelete         bool
+	// offloadedPodMetricsPeriod is the period the usage of upper pods is recorded as metrics, disabled if 0
+	offloadedPodMetricsPeriod time.Duration
+	// dependencies mirrors the custom resource objects pods depend on, nil if disabled
+	dependencies *dependency.Syncer
+	// health probes the lower cluster and reports it unreachable by node conditions, nil if disabled
+	health *healthProbe
+	// works applies the Works of the upper cluster to the lower cluster, nil if disabled
+	works *work.Agent
 }
 
 // NewVirtualK8S reads a kubeconfig file and sets up a client to interact
-// with lower cluster
-func NewVirtualK8S(cfg provider.InitConfig, cc *ClientConfig,
+// with lower cluster, the informers, tunnel and background workers of the provider are stopped once ctx done
+func NewVirtualK8S(ctx context.Context, cfg provider.InitConfig, cc *ClientConfig,
 	ignoreLabelsStr string, enableServiceAccount bool, opts *opts.Opts) (*VirtualK8S, error) {
 	ignoreLabels := strings.Split(ignoreLabelsStr, ",")
 	if len(cc.ClientKubeConfigPath) == 0 {
-		panic("client kubeconfig path can not be empty")
+		if !cc.PullMode {
+			return nil, fmt.Errorf("client kubeconfig path can not be empty unless running in pull mode")
+		}
+		// pull mode, the virtual node runs in the lower cluster and pulls pods from the upper one
+		klog.Info("Running in pull mode, use in-cluster config for client cluster")
+	}
+	if err := config.ValidateOvercommitRatios(cc.CPUOvercommitRatio, cc.MemoryOvercommitRatio); err != nil {
+		return nil, err
+	}
+	if errs := validation.IsValidLabelValue(cc.UpperClusterName); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid upper cluster name %q: %v", cc.UpperClusterName, strings.Join(errs, "; "))
+	}
+	namespaces, err := util.NewNamespaceMapping(cc.NamespacePrefix, cc.NamespaceMapping)
+	if err != nil {
+		return nil, err
+	}
+	conflictResolution, err := conflict.ParseResolution(cc.ConflictResolution)
+	if err != nil {
+		return nil, err
+	}
+	ownedFields, err := parseLowerOwnedFields(cc.LowerOwnedFields)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := common.ParseResourceList(cc.CapacityReserved)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reserved capacity: %v", err)
+	}
+	capacityCalculator, err := common.NewCapacityCalculator(cc.CapacityCalculator, reserved, cc.CapacityPercentile)
+	if err != nil {
+		return nil, err
+	}
+	reservedExclude, err := parseReservedExclude(cc.ReservedPodsExclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of pods excluded from reserved resources: %v", err)
 	}
+	if err = validateAddressTranslation(cc.AddressTranslation, cc.AddressTarget, cc.GatewayAddress); err != nil {
+		return nil, err
+	}
+	var dependencyRules []dependency.Rule
+	for _, rule := range cc.CustomDependencies {
+		r, err := dependency.ParseRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid custom dependency: %v", err)
+		}
+		dependencyRules = append(dependencyRules, r)
+	}
+
+	var failoverOpts util.Opts
+	if len(cc.ClientEndpoints) > 0 {
+		// the server in kubeconfig is kept as the last candidate
+		endpoints := append([]string{}, cc.ClientEndpoints...)
+		if host, err := util.GetConfigHost(cc.ClientKubeConfigPath); err == nil {
+			endpoints = append(endpoints, host)
+		}
+		failover, err := util.NewEndpointFailover(endpoints)
+		if err != nil {
+			return nil, fmt.Errorf("could not build failover for cluster: %v", err)
+		}
+		failoverOpts = util.FailoverOpts(failover)
+		go failover.Run(failoverCheckPeriod, ctx.Done())
+	}
+	var (
+		tunnelOpts  util.Opts
+		tunnelProxy *url.URL
+	)
+	if len(cc.TunnelListenAddress) != 0 {
+		if err := tunnel.ValidateServerConfig(cc.TunnelToken, cc.TunnelCertFile, cc.TunnelKeyFile); err != nil {
+			return nil, err
+		}
+		targets, err := getTunnelTargets(cc)
+		if err != nil {
+			return nil, fmt.Errorf("could not get apiserver of client cluster: %v", err)
+		}
+		server := tunnel.NewServer(cc.TunnelToken)
+		go func() {
+			if err := server.ListenAndServe(cc.TunnelListenAddress, cc.TunnelCertFile, cc.TunnelKeyFile,
+				ctx.Done()); err != nil && ctx.Err() == nil {
+				klog.Fatalf("Tunnel server exited: %v", err)
+			}
+		}()
+		tunnelOpts = func(config *rest.Config) {
+			config.Dial = server.DialContext
+		}
+		// exec streams can not set the dialer, proxy them through the tunnel
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("could not start tunnel proxy: %v", err)
+		}
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+		go server.ServeProxy(listener, targets...)
+		tunnelProxy = &url.URL{Scheme: "http", Host: listener.Addr().String()}
+	}
+
 	// client config
 	var clientConfig *rest.Config
 	client, err := util.NewClient(cc.ClientKubeConfigPath, func(config *rest.Config) {
@@ -94,21 +434,34 @@ func NewVirtualK8S(cfg provider.InitConfig, cc *ClientConfig,
 		config.Burst = cc.KubeClientBurst
 		// Set config for clientConfig
 		clientConfig = config
-	})
+	}, failoverOpts, tunnelOpts)
 	if err != nil {
 		return nil, fmt.Errorf("could not build clientset for cluster: %v", err)
 	}
 
 	// master config, maybe a real node or a pod
-	master, err := util.NewClient(cfg.ConfigPath, func(config *rest.Config) {
-		config.QPS = float32(opts.KubeAPIQPS)
-		config.Burst = int(opts.KubeAPIBurst)
-	})
-	if err != nil {
-		return nil, fmt.Errorf("could not build clientset for cluster: %v", err)
+	master := cc.MasterClient
+	if master == nil {
+		master, err = util.NewClient(cfg.ConfigPath, func(config *rest.Config) {
+			config.QPS = float32(opts.KubeAPIQPS)
+			config.Burst = int(opts.KubeAPIBurst)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not build clientset for cluster: %v", err)
+		}
 	}
 
-	metricClient, err := util.NewMetricClient(cc.ClientKubeConfigPath)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: master.CoreV1().Events(corev1.NamespaceAll)})
+	go func() {
+		<-ctx.Done()
+		broadcaster.Shutdown()
+	}()
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "virtual-kubelet",
+		Host: cfg.NodeName})
+
+	metricClient, err := util.NewMetricClient(cc.ClientKubeConfigPath, failoverOpts, tunnelOpts)
 	if err != nil {
 		return nil, fmt.Errorf("could not build clientset for cluster: %v", err)
 	}
@@ -118,27 +471,57 @@ func NewVirtualK8S(cfg provider.InitConfig, cc *ClientConfig,
 		return nil, fmt.Errorf("could not get target cluster server version: %v", err)
 	}
 
-	informer := kubeinformers.NewSharedInformerFactory(client, 0)
+	var upperVersion string
+	if info, err := master.Discovery().ServerVersion(); err != nil {
+		klog.Warningf("Could not get upper cluster server version: %v", err)
+	} else {
+		upperVersion = info.GitVersion
+	}
+
+	// informer factories are shared by provider and controllers, so that objects of each cluster are only
+	// listed and watched once
+	masterInformer := cc.MasterInformer
+	if masterInformer == nil {
+		masterInformer = kubeinformers.NewSharedInformerFactory(master, 0)
+	}
+	informer := kubeinformers.NewSharedInformerFactory(client, clientResyncPeriod)
+	if len(cc.SnapshotPath) != 0 {
+		if err = checkSnapshotPath(cc.SnapshotPath); err != nil {
+			return nil, fmt.Errorf("invalid snapshot path: %v", err)
+		}
+	}
+	// pods and nodes of lower cluster are cached without the heavy fields
+	// re-listing pods means some events may be missed, the state of provider is reconciled on next ping
+	lowerRecovery := &recovery{}
+	lowerInformerHealth := &informerHealth{}
+	informer.InformerFor(&corev1.Pod{}, newPodInformer(client, cc.SnapshotPath, lowerRecovery.markUnhealthy,
+		lowerInformerHealth))
+	informer.InformerFor(&corev1.Node{}, newNodeInformer)
 	podInformer := informer.Core().V1().Pods()
 	nsInformer := informer.Core().V1().Namespaces()
 	nodeInformer := informer.Core().V1().Nodes()
 	cmInformer := informer.Core().V1().ConfigMaps()
 	secretInformer := informer.Core().V1().Secrets()
 
-	ctx := context.TODO()
-
 	virtualK8S := &VirtualK8S{
 		master:               master,
 		client:               client,
 		metricClient:         metricClient,
 		nodeName:             cfg.NodeName,
-		ignoreLabels:         ignoreLabels,
+		clusterName:          cc.ClusterName,
+		clusterRegion:        cc.ClusterRegion,
+		networkZone:          cc.NetworkZone,
+		upperClusterName:     cc.UpperClusterName,
+		namespaces:           namespaces,
+		ownedFields:          ownedFields,
+		filters:              &syncFilters{ignoreLabels: ignoreLabels},
 		version:              serverVersion.GitVersion,
 		daemonPort:           cfg.DaemonPort,
 		config:               clientConfig,
 		enableServiceAccount: enableServiceAccount,
 		clientCache: clientCache{
 			podLister:    podInformer.Lister(),
+			podIndexer:   podInformer.Informer().GetIndexer(),
 			nsLister:     nsInformer.Lister(),
 			cmLister:     cmInformer.Lister(),
 			secretLister: secretInformer.Lister(),
@@ -148,18 +531,122 @@ func NewVirtualK8S(cfg provider.InitConfig, cc *ClientConfig,
 		updatedNode:  make(chan *corev1.Node, 100),
 		updatedPod:   make(chan *corev1.Pod, 100000),
 		providerNode: &common.ProviderNode{},
+		startTime:    metav1.Now(),
 		stopCh:       ctx.Done(),
+
+		cpuOvercommitRatio: cc.CPUOvercommitRatio,
+		memOvercommitRatio: cc.MemoryOvercommitRatio,
+		tunnelProxy:        tunnelProxy,
+		masterInformer:     masterInformer,
+		clientInformer:     informer,
+		recovery:           lowerRecovery,
+		eventRecorder:      eventRecorder,
+		translationHooks:   cc.TranslationHooks,
+		features:           detectFeatures(client.Discovery(), serverVersion.GitVersion),
+		upperFeatures:      detectFeatures(master.Discovery(), upperVersion),
+		versionSkew:        newVersionSkew(upperVersion, serverVersion.GitVersion),
+		maxVersionSkew:     cc.MaxVersionSkew,
+		capacityCalculator: capacityCalculator,
+		capacitySync:       make(chan struct{}, 1),
+		reservedExclude:    reservedExclude,
+
+		upperServiceAccountTokens: cc.UpperServiceAccountTokens,
+		rescheduleAfter:           cc.RescheduleUnschedulableAfter,
+		unfitDuration:             cc.UnfitDuration,
+		priorityClasses:           cc.PriorityClassMapping,
+		syncPriorityClasses:       cc.SyncPriorityClasses,
+		addressTranslation:        cc.AddressTranslation,
+		addressTarget:             cc.AddressTarget,
+		gatewayAddress:            cc.GatewayAddress,
+		forceDelete:               cc.ForceDeletePods,
+		offloadedPodMetricsPeriod: cc.OffloadedPodMetricsPeriod,
+	}
+	if cc.PodDeletionGracePeriod >= 0 {
+		gracePeriod := cc.PodDeletionGracePeriod
+		virtualK8S.deletionGracePeriod = &gracePeriod
+	}
+
+	if len(virtualK8S.clusterName) == 0 {
+		virtualK8S.clusterName = cfg.NodeName
+	}
+	if len(dependencyRules) != 0 || len(cc.WorkNamespace) != 0 {
+		upperDynamic, err := util.NewDynamicClient(cfg.ConfigPath, func(config *rest.Config) {
+			config.QPS = float32(opts.KubeAPIQPS)
+			config.Burst = int(opts.KubeAPIBurst)
+		})
+		if err != nil {
+			return nil, err
+		}
+		lowerDynamic, err := util.NewDynamicClient(cc.ClientKubeConfigPath, func(config *rest.Config) {
+			config.QPS = float32(cc.KubeClientQPS)
+			config.Burst = cc.KubeClientBurst
+		}, failoverOpts, tunnelOpts)
+		if err != nil {
+			return nil, err
+		}
+		if len(dependencyRules) != 0 {
+			virtualK8S.dependencies = dependency.NewSyncer(upperDynamic, lowerDynamic, dependencyRules,
+				cc.DependencyResolvers...).WithNamespaces(namespaces)
+		}
+		if len(cc.WorkNamespace) != 0 {
+			mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery()))
+			virtualK8S.works = work.NewAgent(upperDynamic, lowerDynamic, mapper, cc.WorkNamespace)
+		}
+	}
+	virtualK8S.health = newHealthProbe(client, lowerInformerHealth, cc.HealthProbePeriod, cc.HealthProbeTimeout,
+		cc.HealthFailureThreshold, cc.InformerStaleThreshold)
+	virtualK8S.podThrottle = newPodThrottle(virtualK8S.clusterName, cc.PodOperationConcurrency, cc.PodOperationQPS,
+		cc.PodOperationBurst)
+	var sinks []alert.Sink
+	if len(cc.AlertWebhookURL) != 0 {
+		sinks = append(sinks, alert.NewWebhookSink(cc.AlertWebhookURL))
+	}
+	if cc.AlertEvents {
+		sinks = append(sinks, alert.NewEventSink(eventRecorder))
+	}
+	virtualK8S.alerts = alert.NewTracker(virtualK8S.clusterName, cc.AlertThreshold, sinks...)
+	go virtualK8S.alerts.Run(ctx.Done())
+	virtualK8S.conflicts = conflict.NewDetector(virtualK8S.clusterName, cc.ConflictThreshold, cc.ConflictWindow,
+		conflictResolution, virtualK8S.alerts)
+
+	virtualK8S.podMapping = newPodMapping(master, cc.PodMappingNamespace, virtualK8S.clusterName,
+		virtualK8S.alerts)
+	if err = virtualK8S.loadPodMapping(ctx); err != nil {
+		return nil, fmt.Errorf("could not load pod mapping: %v", err)
+	}
+	if virtualK8S.podMapping != nil {
+		go virtualK8S.podMapping.run(ctx.Done())
 	}
 
 	virtualK8S.buildNodeInformer(nodeInformer)
 	virtualK8S.buildPodInformer(podInformer)
+	if cc.MirrorEvents {
+		virtualK8S.mirrorEvents(informer, newEventRecorder(master, cfg.NodeName, cc.MirrorEventBurst,
+			cc.MirrorEventInterval))
+	}
+
+	if cc.EnableImpersonation {
+		masterNsInformer := masterInformer.Core().V1().Namespaces()
+		virtualK8S.impersonator, err = newImpersonator(clientConfig, masterNsInformer.Lister(),
+			cc.ImpersonationUsers, cc.ImpersonationGroups)
+		if err != nil {
+			return nil, err
+		}
+		masterInformer.Start(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), masterNsInformer.Informer().HasSynced) {
+			return nil, fmt.Errorf("could not sync caches of master namespaces")
+		}
+	}
 
 	informer.Start(ctx.Done())
 	klog.Info("Informer started")
 	if !cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced,
 		nsInformer.Informer().HasSynced, nodeInformer.Informer().HasSynced, cmInformer.Informer().HasSynced,
 		secretInformer.Informer().HasSynced) {
-		klog.Fatal("WaitForCacheSync failed")
+		return nil, fmt.Errorf("could not sync caches of client cluster")
+	}
+	if len(cc.SnapshotPath) != 0 {
+		go runPodSnapshot(cc.SnapshotPath, podInformer.Informer(), podInformer.Lister(), ctx.Done())
 	}
 	return virtualK8S, nil
 }
@@ -174,21 +661,62 @@ func (v *VirtualK8S) GetMaster() kubernetes.Interface {
 	return v.master
 }
 
+// GetClusterName returns the name of lower cluster, the node name if not set
+func (v *VirtualK8S) GetClusterName() string {
+	return v.clusterName
+}
+
+// GetUpperClusterName returns the name of upper cluster labeled on lower pods, empty if not set
+func (v *VirtualK8S) GetUpperClusterName() string {
+	return v.upperClusterName
+}
+
+// GetNamespaceMapping returns the translation of namespaces between the clusters, nil if namespaces are kept
+func (v *VirtualK8S) GetNamespaceMapping() *util.NamespaceMapping {
+	return v.namespaces
+}
+
+// GetConflictDetector returns the detector of writers fighting over the objects of lower cluster
+func (v *VirtualK8S) GetConflictDetector() *conflict.Detector {
+	return v.conflicts
+}
+
+// GetMasterInformer returns the informer factory of upper cluster shared with provider
+func (v *VirtualK8S) GetMasterInformer() kubeinformers.SharedInformerFactory {
+	return v.masterInformer
+}
+
+// GetClientInformer returns the informer factory of lower cluster shared with provider
+func (v *VirtualK8S) GetClientInformer() kubeinformers.SharedInformerFactory {
+	return v.clientInformer
+}
+
+// StartClientInformer starts the informers of lower cluster requested since the provider started, e.g. by
+// controllers, they are stopped with the provider
+func (v *VirtualK8S) StartClientInformer() {
+	v.clientInformer.Start(v.stopCh)
+}
+
+// GetWorkAgent returns the agent applying the Works of upper cluster to lower cluster, nil if disabled
+func (v *VirtualK8S) GetWorkAgent() *work.Agent {
+	return v.works
+}
+
 // GetNameSpaceLister returns the namespace cache
 func (v *VirtualK8S) GetNameSpaceLister() v1.NamespaceLister {
 	return v.clientCache.nsLister
 }
 
 func (v *VirtualK8S) buildNodeInformer(nodeInformer informerv1.NodeInformer) {
-	nodeInformer.Informer().AddEventHandler(
+	nodeInformer.Informer().AddEventHandlerWithResyncPeriod(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				if !v.configured {
+				if !v.configured || v.queueCapacitySync() {
 					return
 				}
 				nodeCopy := v.providerNode.DeepCopy()
 				addNode := obj.(*corev1.Node).DeepCopy()
-				toAdd := common.ConvertResource(addNode.Status.Capacity)
+				toAdd := v.getNodeCapacity(addNode)
 				if err := v.providerNode.AddResource(toAdd); err != nil {
 					return
 				}
@@ -214,12 +742,12 @@ func (v *VirtualK8S) buildNodeInformer(nodeInformer informerv1.NodeInformer) {
 				v.updateVKCapacityFromNode(oldCopy, newCopy)
 			},
 			DeleteFunc: func(obj interface{}) {
-				if !v.configured {
+				if !v.configured || v.queueCapacitySync() {
 					return
 				}
 				nodeCopy := v.providerNode.DeepCopy()
 				deleteNode := obj.(*corev1.Node).DeepCopy()
-				toRemove := common.ConvertResource(deleteNode.Status.Capacity)
+				toRemove := v.getNodeCapacity(deleteNode)
 				if err := v.providerNode.SubResource(toRemove); err != nil {
 					return
 				}
@@ -230,17 +758,41 @@ func (v *VirtualK8S) buildNodeInformer(nodeInformer informerv1.NodeInformer) {
 					v.updatedNode <- copy
 				}
 			},
-		},
+		}, 0,
+	)
+	// nodes going to be reclaimed are checked on resyncs too, so nodes tainted before the provider
+	// started and pods missed are handled eventually
+	nodeInformer.Informer().AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if node, ok := obj.(*corev1.Node); ok {
+					go v.markReclaimingPods(context.TODO(), node)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if node, ok := newObj.(*corev1.Node); ok {
+					go v.markReclaimingPods(context.TODO(), node)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if node, ok := obj.(*corev1.Node); ok {
+					v.reclaimingPods.Delete(node.Name)
+				}
+			},
+		}, clientResyncPeriod,
 	)
 }
 
 func (v *VirtualK8S) buildPodInformer(podInformer informerv1.PodInformer) {
-	podInformer.Informer().AddEventHandler(
+	podInformer.Informer().AddEventHandlerWithResyncPeriod(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    v.addPod,
 			UpdateFunc: v.updatePod,
 			DeleteFunc: v.deletePod,
-		},
+		}, 0,
 	)
 }
 
@@ -260,9 +812,11 @@ func (v *VirtualK8S) addPod(obj interface{}) {
 		}
 		// Pod created only by lower cluster
 		// we should change the node resource
-		if len(podCopy.Spec.NodeName) != 0 {
-			podResource := util.GetRequestFromPod(podCopy)
-			podResource.Pods = resource.MustParse("1")
+		if v.reserves(podCopy) {
+			if v.queueCapacitySync() {
+				return
+			}
+			podResource := reservedRequest(podCopy)
 			v.providerNode.SubResource(podResource)
 			klog.Infof("Lower cluster add pod %s, resource: %v, node: %v",
 				podCopy.Name, podResource, v.providerNode.Status.Capacity)
@@ -274,6 +828,12 @@ func (v *VirtualK8S) addPod(obj interface{}) {
 		}
 		return
 	}
+	if len(v.addressTranslation) != 0 {
+		go v.publishAddress(context.TODO(), pod.DeepCopy())
+	}
+	if len(podCopy.Spec.NodeName) != 0 {
+		go v.publishLowerNode(context.TODO(), pod.DeepCopy())
+	}
 	v.updatedPod <- podCopy
 }
 
@@ -297,6 +857,22 @@ func (v *VirtualK8S) updatePod(oldObj, newObj interface{}) {
 		v.updateVKCapacityFromPod(oldCopy, newCopy)
 		return
 	}
+	if !v.isStale(newCopy) {
+		v.recordSchedulingFailure(oldCopy, newCopy)
+		v.observePod(newCopy)
+	}
+	if v.translates(FeaturePodDeletionCost) && deletionCostChanged(oldCopy, newCopy) {
+		go v.syncDeletionCost(context.TODO(), newCopy)
+	}
+	if resizing(newCopy) {
+		go v.syncResizeStatus(context.TODO(), newCopy)
+	}
+	if len(v.addressTranslation) != 0 && !v.isStale(newCopy) {
+		go v.publishAddress(context.TODO(), new.DeepCopy())
+	}
+	if oldCopy.Spec.NodeName != newCopy.Spec.NodeName {
+		go v.publishLowerNode(context.TODO(), new.DeepCopy())
+	}
 	if !reflect.DeepEqual(oldCopy.Status, newCopy.Status) || newCopy.DeletionTimestamp != nil {
 		util.TrimObjectMeta(&newCopy.ObjectMeta)
 		v.updatedPod <- newCopy
@@ -307,26 +883,30 @@ func (v *VirtualK8S) deletePod(obj interface{}) {
 	if !v.configured {
 		return
 	}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
 	pod, ok := obj.(*corev1.Pod)
 	if !ok {
 		return
 	}
 	podCopy := pod.DeepCopy()
 	util.TrimObjectMeta(&podCopy.ObjectMeta)
+	v.forgetAddress(podCopy)
+	v.forgetLowerNode(podCopy)
 	if !util.IsVirtualPod(podCopy) {
 		if v.providerNode.Node == nil {
 			return
 		}
-		if podStopped(pod) {
-			return
-		}
 		// Pod created only by lower cluster
 		// we should change the node resource
-		if len(podCopy.Spec.NodeName) != 0 {
-			podResource := util.GetRequestFromPod(podCopy)
-			podResource.Pods = resource.MustParse("1")
+		if v.reserves(podCopy) {
+			if v.queueCapacitySync() {
+				return
+			}
+			podResource := reservedRequest(podCopy)
 			v.providerNode.AddResource(podResource)
-			klog.Infof("Lower cluster add pod %s, resource: %v, node: %v",
+			klog.Infof("Lower cluster delete pod %s, resource: %v, node: %v",
 				podCopy.Name, podResource, v.providerNode.Status.Capacity)
 			if v.providerNode.Node == nil {
 				return
@@ -336,7 +916,19 @@ func (v *VirtualK8S) deletePod(obj interface{}) {
 		}
 		return
 	}
-	v.updatedPod <- podCopy
+	go func() {
+		// the upper pod held since the lower pod created is released once the lower pod is gone
+		defer v.releaseUpperPodOf(context.TODO(), pod)
+		if !v.upperPodGone(context.TODO(), pod) {
+			if v.deletePreempted(context.TODO(), pod) {
+				return
+			}
+			completePodStatus(podCopy)
+			v.failRescheduled(pod, &podCopy.Status)
+			terminateDeletedPod(&podCopy.Status)
+		}
+		v.updatedPod <- podCopy
+	}()
 }
 
 func (v *VirtualK8S) updateVKCapacityFromNode(old, new *corev1.Node) {
@@ -344,8 +936,11 @@ func (v *VirtualK8S) updateVKCapacityFromNode(old, new *corev1.Node) {
 	if !oldStatus && !newStatus {
 		return
 	}
-	toRemove := common.ConvertResource(old.Status.Capacity)
-	toAdd := common.ConvertResource(new.Status.Capacity)
+	if v.queueCapacitySync() {
+		return
+	}
+	toRemove := v.getNodeCapacity(old)
+	toAdd := v.getNodeCapacity(new)
 	nodeCopy := v.providerNode.DeepCopy()
 	if old.Spec.Unschedulable && !new.Spec.Unschedulable || newStatus && !oldStatus {
 		v.providerNode.AddResource(toAdd)
@@ -375,24 +970,27 @@ func (v *VirtualK8S) updateVKCapacityFromNode(old, new *corev1.Node) {
 }
 
 func (v *VirtualK8S) updateVKCapacityFromPod(old, new *corev1.Pod) {
-	newResource := util.GetRequestFromPod(new)
-	oldResource := util.GetRequestFromPod(old)
-	// create pod
-	if old.Spec.NodeName == "" && new.Spec.NodeName != "" {
-		newResource.Pods = resource.MustParse("1")
+	oldReserves, newReserves := v.reserves(old), v.reserves(new)
+	if !oldReserves && !newReserves {
+		return
+	}
+	if v.queueCapacitySync() {
+		return
+	}
+	newResource := reservedRequest(new)
+	oldResource := reservedRequest(old)
+	switch {
+	// create pod, or its labels no longer excluded
+	case !oldReserves:
 		v.providerNode.SubResource(newResource)
 		klog.Infof("Lower cluster add pod %s, resource: %v, node: %v",
 			new.Name, newResource, v.providerNode.Status.Capacity)
-	}
-	// delete pod
-	if old.Status.Phase == corev1.PodRunning && podStopped(new) {
-		klog.Infof("Lower cluster delete pod %s, resource: %v", new.Name, newResource)
-		newResource.Pods = resource.MustParse("1")
-		v.providerNode.AddResource(newResource)
-	}
+	// delete pod, or its labels excluded
+	case !newReserves:
+		v.providerNode.AddResource(oldResource)
+		klog.Infof("Lower cluster delete pod %s, resource: %v", new.Name, oldResource)
 	// update pod
-	if new.Status.Phase == corev1.PodRunning && !reflect.DeepEqual(old.Spec.Containers,
-		new.Spec.Containers) {
+	default:
 		if oldResource.Equal(newResource) {
 			return
 		}
@@ -407,5 +1005,31 @@ func (v *VirtualK8S) updateVKCapacityFromPod(old, new *corev1.Pod) {
 	}
 	copy := v.providerNode.DeepCopy()
 	v.updatedNode <- copy
-	return
+}
+
+// getTunnelTargets returns the addresses of lower apiserver could be connected through the tunnel proxy
+func getTunnelTargets(cc *ClientConfig) ([]string, error) {
+	host, err := util.GetConfigHost(cc.ClientKubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, endpoint := range append([]string{host}, cc.ClientEndpoints...) {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		target := u.Host
+		if len(u.Port()) == 0 {
+			target = net.JoinHostPort(u.Hostname(), "443")
+			if u.Scheme == "http" {
+				target = net.JoinHostPort(u.Hostname(), "80")
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
 }
@@ -0,0 +1,52 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// quotaHeadroomPeriod is the period to publish the quotas left in lower cluster
+const quotaHeadroomPeriod = 30 * time.Second
+
+// runQuotaHeadroom publishes the resource quotas left in each namespace of lower cluster to the annotation of
+// virtual node, so that schedulers do not bind pods the lower cluster would reject for exceeding quotas
+func (v *VirtualK8S) runQuotaHeadroom(ctx context.Context) {
+	wait.Until(func() {
+		quotas, err := v.client.CoreV1().ResourceQuotas(corev1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			klog.Errorf("List resource quotas failed: %v", err)
+			return
+		}
+		data, err := json.Marshal(common.NewQuotaHeadroom(quotas.Items))
+		if err != nil {
+			klog.Errorf("Marshal quota headroom failed: %v", err)
+			return
+		}
+		v.setNodeAnnotation(util.QuotaHeadroom, string(data))
+	}, quotaHeadroomPeriod, ctx.Done())
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util/clustercache"
+)
+
+// reclamationTaints are the taints put on nodes going to be reclaimed by the cloud, e.g. spot
+// instances receiving interruption notices
+var reclamationTaints = []string{
+	util.ReclamationTaint,
+	"aws-node-termination-handler/spot-itn",
+	"aws-node-termination-handler/rebalance-recommendation",
+	"cloud.google.com/impending-node-termination",
+}
+
+// getReclamationTaint returns the key of reclamation taint on the node, empty string would be returned
+// if the node is not going to be reclaimed
+func getReclamationTaint(node *corev1.Node) string {
+	for _, taint := range node.Spec.Taints {
+		for _, key := range reclamationTaints {
+			if taint.Key == key {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// markReclaimingPods marks the upper pods whose lower pods run on the node going to be reclaimed, so
+// descheduler evicts and reschedules them to other clusters before the capacity disappears. It is called
+// on every add, update and resync of the node, each lower pod is only marked once
+func (v *VirtualK8S) markReclaimingPods(ctx context.Context, node *corev1.Node) {
+	taint := getReclamationTaint(node)
+	if len(taint) == 0 {
+		v.reclaimingPods.Delete(node.Name)
+		return
+	}
+	pods, err := clustercache.Pods(v.clientCache.podIndexer, clustercache.ClusterIndex, node.Name)
+	if err != nil {
+		klog.Errorf("List pods on node %v failed: %v", node.Name, err)
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{util.Reclaiming: taint},
+		},
+	})
+	if err != nil {
+		return
+	}
+	value, _ := v.reclaimingPods.LoadOrStore(node.Name, &sync.Map{})
+	marked := value.(*sync.Map)
+	for _, pod := range pods {
+		if !util.IsVirtualPod(pod) || podStopped(pod) || v.isStale(pod) {
+			continue
+		}
+		if _, ok := marked.Load(pod.UID); ok {
+			continue
+		}
+		_, err = v.upperPods(pod).Patch(ctx, pod.Name, types.MergePatchType, patch,
+			metav1.PatchOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("Mark reclaiming pod %v/%v failed: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		marked.Store(pod.UID, struct{}{})
+		klog.Infof("Pod %v/%v is on node %v going to be reclaimed by %v", pod.Namespace, pod.Name,
+			node.Name, taint)
+	}
+}
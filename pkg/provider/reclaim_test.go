@@ -0,0 +1,111 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestGetReclamationTaint(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		taints []corev1.Taint
+		want   string
+	}{
+		{
+			name: "no taints",
+		},
+		{
+			name:   "other taints",
+			taints: []corev1.Taint{{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute}},
+		},
+		{
+			name:   "tensile-kube taint",
+			taints: []corev1.Taint{{Key: util.ReclamationTaint, Effect: corev1.TaintEffectNoSchedule}},
+			want:   util.ReclamationTaint,
+		},
+		{
+			name: "aws spot interruption",
+			taints: []corev1.Taint{
+				{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute},
+				{Key: "aws-node-termination-handler/spot-itn", Effect: corev1.TaintEffectNoSchedule},
+			},
+			want: "aws-node-termination-handler/spot-itn",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			node := &corev1.Node{Spec: corev1.NodeSpec{Taints: c.taints}}
+			if got := getReclamationTaint(node); got != c.want {
+				t.Errorf("getReclamationTaint() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMarkReclaimingPods(t *testing.T) {
+	vk, _, podInformer := newFakeVirtualK8S()
+	ctx := context.Background()
+	upper := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	master := fake.NewSimpleClientset(upper)
+	vk.master = master
+
+	lower := upper.DeepCopy()
+	lower.UID = "lower-uid"
+	lower.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	lower.Spec.NodeName = "node1"
+	other := lower.DeepCopy()
+	other.Name = "other"
+	other.Spec.NodeName = "node2"
+	podInformer.Informer().GetStore().Add(lower)
+	podInformer.Informer().GetStore().Add(other)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: util.ReclamationTaint, Effect: corev1.TaintEffectNoSchedule},
+		}},
+	}
+	vk.markReclaimingPods(ctx, node)
+	pod, err := master.CoreV1().Pods("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Annotations[util.Reclaiming] != util.ReclamationTaint {
+		t.Errorf("pod should be marked reclaiming by %v, got annotations %v", util.ReclamationTaint,
+			pod.Annotations)
+	}
+	actions := len(master.Actions())
+
+	// resyncs of the tainted node should not patch the marked pods again
+	vk.markReclaimingPods(ctx, node)
+	if len(master.Actions()) != actions {
+		t.Errorf("marked pods should not be patched again, actions: %v", master.Actions()[actions:])
+	}
+
+	node.Spec.Taints = nil
+	vk.markReclaimingPods(ctx, node)
+	if _, ok := vk.reclaimingPods.Load(node.Name); ok {
+		t.Error("marked pods should be forgotten after the taint removed")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+var (
+	// recoveryDelay is the time waiting for informers to re-list after the lower apiserver is back
+	recoveryDelay = 10 * time.Second
+	// minRecoveryInterval bounds the frequency of reconciling after the lower apiserver flaps
+	minRecoveryInterval = time.Minute
+)
+
+// recovery tracks the availability of the lower apiserver, once it is back after being unreachable,
+// e.g. restarted, or the watch of pods is invalidated and re-listed, the incrementally maintained
+// state of provider is rebuilt from the re-listed caches
+type recovery struct {
+	sync.Mutex
+	unhealthy    bool
+	running      bool
+	lastRecovery time.Time
+}
+
+// markUnhealthy records the lower apiserver is unreachable
+func (r *recovery) markUnhealthy() {
+	r.Lock()
+	defer r.Unlock()
+	r.unhealthy = true
+}
+
+// markHealthy records the lower apiserver is reachable, returns if a recovery should be triggered,
+// at most one recovery runs at the same time
+func (r *recovery) markHealthy(now time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+	if !r.unhealthy || r.running {
+		return false
+	}
+	if now.Sub(r.lastRecovery) < minRecoveryInterval {
+		return false
+	}
+	r.unhealthy = false
+	r.running = true
+	r.lastRecovery = now
+	return true
+}
+
+// finish records the running recovery is finished
+func (r *recovery) finish() {
+	r.Lock()
+	defer r.Unlock()
+	r.running = false
+}
+
+// newRelistListWatch wraps lw, onRelist is called once lw is listed again after the first list,
+// which means the watch was invalidated, e.g. the resource version is too old after the lower
+// apiserver restarted, and the events in between may be missing
+func newRelistListWatch(lw cache.ListerWatcher, onRelist func()) *cache.ListWatch {
+	var listed int32
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			if err != nil {
+				return nil, err
+			}
+			if !atomic.CompareAndSwapInt32(&listed, 0, 1) {
+				klog.Info("Pods of lower cluster re-listed")
+				onRelist()
+			}
+			return list, nil
+		},
+		WatchFunc: lw.Watch,
+	}
+}
+
+// reconcileAfterRecovery waits for informers to re-list, then recomputes the capacity of virtual node
+// and re-notifies the status of all virtual pods, it gives up once the provider is stopped
+func (v *VirtualK8S) reconcileAfterRecovery() {
+	defer v.recovery.finish()
+	select {
+	case <-time.After(recoveryDelay):
+	case <-v.stopCh:
+		return
+	}
+	klog.Info("Lower apiserver is back, reconcile virtual node and pods")
+	if node := v.providerNode.DeepCopy(); node != nil {
+		nodeResource, err := v.getNodeResource()
+		if err != nil {
+			klog.Errorf("Compute node resource failed: %v", err)
+		} else if err = v.providerNode.SetResource(nodeResource); err == nil {
+			select {
+			case v.updatedNode <- v.providerNode.DeepCopy():
+			case <-v.stopCh:
+				return
+			}
+		}
+	}
+	pods, err := v.clientCache.podLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List pods failed: %v", err)
+		return
+	}
+	count := 0
+	for _, pod := range pods {
+		if !util.IsVirtualPod(pod) {
+			continue
+		}
+		podCopy := pod.DeepCopy()
+		util.TrimObjectMeta(&podCopy.ObjectMeta)
+		select {
+		case v.updatedPod <- podCopy:
+		case <-v.stopCh:
+			return
+		}
+		count++
+	}
+	klog.Infof("Re-notified status of %v virtual pods", count)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestRecovery(t *testing.T) {
+	r := &recovery{}
+	now := time.Now()
+	if r.markHealthy(now) {
+		t.Fatal("Desired no recovery without failures")
+	}
+	r.markUnhealthy()
+	if !r.markHealthy(now) {
+		t.Fatal("Desired recovery after failures")
+	}
+	if r.markHealthy(now) {
+		t.Fatal("Desired no recovery twice")
+	}
+	r.markUnhealthy()
+	if r.markHealthy(now.Add(minRecoveryInterval / 2)) {
+		t.Fatal("Desired recovery bounded by interval")
+	}
+	if r.markHealthy(now.Add(minRecoveryInterval)) {
+		t.Fatal("Desired no recovery while another is running")
+	}
+	r.finish()
+	if !r.markHealthy(now.Add(minRecoveryInterval)) {
+		t.Fatal("Desired recovery after interval")
+	}
+}
+
+func TestRelistListWatch(t *testing.T) {
+	relisted := 0
+	lw := newRelistListWatch(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &corev1.PodList{}, nil
+		},
+	}, func() {
+		relisted++
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := lw.List(metav1.ListOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if relisted != 2 {
+		t.Fatalf("Desired 2 re-lists, get %v", relisted)
+	}
+}
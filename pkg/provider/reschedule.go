@@ -0,0 +1,161 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// reschedulePeriod is the period to check the pods unschedulable in lower cluster
+	reschedulePeriod = 30 * time.Second
+	// unschedulableReason is the reason of upper pods failed for being unschedulable in lower cluster too long
+	unschedulableReason = "UnschedulableInLowerCluster"
+)
+
+// runRescheduling fails the upper pods whose lower pods are unschedulable longer than rescheduleAfter, so their
+// controllers create replacements, and marks the virtual node unfit for the pods of the same controller, so the
+// replacements are scheduled elsewhere
+func (v *VirtualK8S) runRescheduling(ctx context.Context) {
+	wait.Until(func() {
+		v.rescheduleUnschedulable(ctx, time.Now())
+	}, reschedulePeriod, ctx.Done())
+}
+
+// rescheduleUnschedulable reschedules the pods unschedulable longer than rescheduleAfter at now, and publishes
+// the pods the virtual node is unfit for
+func (v *VirtualK8S) rescheduleUnschedulable(ctx context.Context, now time.Time) {
+	pods, err := v.clientCache.podLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List pods failed: %v", err)
+		return
+	}
+	for _, pod := range pods {
+		if !util.IsVirtualPod(pod) || pod.DeletionTimestamp != nil || v.isStale(pod) {
+			continue
+		}
+		since, message := unschedulableSince(&pod.Status)
+		if len(message) == 0 || now.Sub(since) < v.rescheduleAfter {
+			continue
+		}
+		if err = v.reschedule(ctx, pod, message, now); err != nil {
+			klog.Errorf("Reschedule pod %v/%v failed: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	v.publishUnfitPods(now)
+}
+
+// unschedulableSince returns since when the pod is unschedulable and the message of the scheduler in the lower
+// cluster, empty message is returned if the pod is not unschedulable
+func unschedulableSince(status *corev1.PodStatus) (time.Time, string) {
+	for _, cond := range status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse &&
+			cond.Reason == corev1.PodReasonUnschedulable {
+			return cond.LastTransitionTime.Time, cond.Message
+		}
+	}
+	return time.Time{}, ""
+}
+
+// reschedule annotates the upper pod with the failure, marks the virtual node unfit for the pods of its
+// controller and deletes the lower pod, the upper pod is then failed with unschedulableReason by deletePod
+func (v *VirtualK8S) reschedule(ctx context.Context, lower *corev1.Pod, message string, now time.Time) error {
+	upper, err := v.upperPods(lower).Get(ctx, lower.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if upper.DeletionTimestamp != nil || !belongsTo(lower, upper) {
+		return nil
+	}
+	failure := fmt.Sprintf("pod is unschedulable in lower cluster %v: %v", v.clusterName, message)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{util.SchedulingFailure: failure},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = v.master.CoreV1().Pods(upper.Namespace).Patch(ctx, upper.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	v.unfitLock.Lock()
+	if v.unfitPods == nil {
+		v.unfitPods = map[string]time.Time{}
+	}
+	v.unfitPods[util.SchedulingKey(upper)] = now.Add(v.unfitDuration)
+	v.unfitLock.Unlock()
+
+	v.rescheduled.Store(lower.UID, failure)
+	err = v.client.CoreV1().Pods(lower.Namespace).Delete(ctx, lower.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: new(int64),
+		Preconditions:      metav1.NewUIDPreconditions(string(lower.UID)),
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		v.rescheduled.Delete(lower.UID)
+		return err
+	}
+	klog.Infof("Pod %v/%v unschedulable in lower cluster is rescheduled: %v", lower.Namespace, lower.Name,
+		message)
+	return nil
+}
+
+// failRescheduled fails the status of the lower pod deleted by reschedule with the failure
+func (v *VirtualK8S) failRescheduled(lower *corev1.Pod, status *corev1.PodStatus) {
+	failure, ok := v.rescheduled.Load(lower.UID)
+	if !ok {
+		return
+	}
+	v.rescheduled.Delete(lower.UID)
+	status.Reason = unschedulableReason
+	status.Message = failure.(string)
+}
+
+// publishUnfitPods publishes the pods the virtual node is unfit for until their expiry to the annotation of
+// virtual node, the expired ones are removed
+func (v *VirtualK8S) publishUnfitPods(now time.Time) {
+	v.unfitLock.Lock()
+	defer v.unfitLock.Unlock()
+	for key, until := range v.unfitPods {
+		if !now.Before(until) {
+			delete(v.unfitPods, key)
+		}
+	}
+	data, err := json.Marshal(v.unfitPods)
+	if err != nil {
+		klog.Errorf("Marshal unfit pods failed: %v", err)
+		return
+	}
+	v.setNodeAnnotation(util.UnfitPods, string(data))
+}
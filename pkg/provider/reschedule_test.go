@@ -0,0 +1,94 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestRescheduleUnschedulable(t *testing.T) {
+	ctx := context.Background()
+	vk, _, podInformer := newFakeVirtualK8SWithNodePod()
+	vk.rescheduleAfter = time.Minute
+	vk.unfitDuration = 10 * time.Minute
+	now := time.Now()
+
+	owner := metav1.NewControllerRef(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "rs", UID: "rs-uid"}},
+		corev1.SchemeGroupVersion.WithKind("ReplicaSet"))
+	upper := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "upper-uid",
+		OwnerReferences: []metav1.OwnerReference{*owner}}}
+	vk.master.CoreV1().Pods("default").Create(ctx, upper, metav1.CreateOptions{})
+	newLower := func(name string, since time.Time) *corev1.Pod {
+		lower := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: "lower-" + name,
+				Labels:      map[string]string{util.VirtualPodLabel: "true"},
+				Annotations: map[string]string{util.UpperPodUID: "upper-uid"}},
+			Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available", LastTransitionTime: metav1.NewTime(since)}}},
+		}
+		vk.client.CoreV1().Pods("default").Create(ctx, lower, metav1.CreateOptions{})
+		podInformer.Informer().GetStore().Add(lower)
+		return lower
+	}
+	lower := newLower("test", now.Add(-2*time.Minute))
+	recent := newLower("recent", now.Add(-time.Second))
+
+	vk.rescheduleUnschedulable(ctx, now)
+	if _, err := vk.client.CoreV1().Pods("default").Get(ctx, lower.Name,
+		metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire lower pod deleted, get %v", err)
+	}
+	if _, err := vk.client.CoreV1().Pods("default").Get(ctx, recent.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("Desire pod unschedulable recently kept, get %v", err)
+	}
+	patched, err := vk.master.CoreV1().Pods("default").Get(ctx, upper.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patched.Annotations[util.SchedulingFailure]) == 0 {
+		t.Fatal("Desire scheduling failure annotated to upper pod")
+	}
+	unfit := map[string]time.Time{}
+	if err = json.Unmarshal([]byte(vk.nodeAnnotations[util.UnfitPods]), &unfit); err != nil {
+		t.Fatal(err)
+	}
+	if until, ok := unfit["rs-uid"]; !ok || !until.Equal(now.Add(vk.unfitDuration)) {
+		t.Fatalf("Desire virtual node unfit for rs-uid, get %v", unfit)
+	}
+
+	status := lower.Status.DeepCopy()
+	vk.failRescheduled(lower, status)
+	if status.Reason != unschedulableReason {
+		t.Fatalf("Desire reason %v, get %v", unschedulableReason, status.Reason)
+	}
+
+	// the unfit mark expires
+	vk.publishUnfitPods(now.Add(vk.unfitDuration))
+	if vk.nodeAnnotations[util.UnfitPods] != "{}" {
+		t.Fatalf("Desire no unfit pods after expiry, get %v", vk.nodeAnnotations[util.UnfitPods])
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// parseReservedExclude parses the selector of lower pods not reserving resources, none is excluded if empty
+func parseReservedExclude(selector string) (labels.Selector, error) {
+	if len(selector) == 0 {
+		return labels.Nothing(), nil
+	}
+	return labels.Parse(selector)
+}
+
+// reserves returns if the pod is created in lower cluster directly, e.g. by daemonsets or system components, and
+// its requests are kept back from the virtual node. Virtual pods are not, the upper scheduler accounts them
+func (v *VirtualK8S) reserves(pod *corev1.Pod) bool {
+	if util.IsVirtualPod(pod) || len(pod.Spec.NodeName) == 0 || podStopped(pod) {
+		return false
+	}
+	return v.reservedExclude == nil || !v.reservedExclude.Matches(labels.Set(pod.Labels))
+}
+
+// reservedRequest returns the requests of a reserving pod, including the pod itself
+func reservedRequest(pod *corev1.Pod) *common.Resource {
+	res := util.GetRequestFromPod(pod)
+	res.Pods = resource.MustParse("1")
+	return res
+}
+
+// runReservedResources publishes the resources reserved by lower pods to the annotation of virtual node
+// periodically, so that the gap between the lower nodes and the virtual node is explained
+func (v *VirtualK8S) runReservedResources(ctx context.Context) {
+	wait.Until(func() {
+		reserved, err := v.getReservedResources()
+		if err != nil {
+			klog.Errorf("Get reserved resources failed: %v", err)
+			return
+		}
+		v.publishResourceList(util.ReservedResources, reserved)
+	}, fitSummaryPeriod, ctx.Done())
+}
+
+// getReservedResources sums the requests of reserving pods on ready and schedulable nodes
+func (v *VirtualK8S) getReservedResources() (corev1.ResourceList, error) {
+	nodes, err := v.physicalNodes()
+	if err != nil {
+		return nil, err
+	}
+	requested := v.getResourceFromPods()
+	reserved := common.NewResource()
+	for _, node := range nodes {
+		if res, ok := requested[node.Name]; ok {
+			reserved.Add(res)
+		}
+	}
+	list := corev1.ResourceList{
+		corev1.ResourceCPU:    reserved.CPU,
+		corev1.ResourceMemory: reserved.Memory,
+		corev1.ResourcePods:   reserved.Pods,
+	}
+	for name, quantity := range reserved.Custom {
+		list[name] = quantity
+	}
+	return list, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestReservedResources(t *testing.T) {
+	vk, nodeInformer, podInformer := newFakeVirtualK8SWithNodePod()
+	vk.reservedExclude, _ = parseReservedExclude("tier=best-effort")
+	vk.updatedNode = make(chan *corev1.Node, 10)
+	nodeInformer.Informer().GetStore().Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Capacity:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10")},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	})
+	newPod := func(name string, labels map[string]string) *corev1.Pod {
+		pod := fakeNodeWithReq()
+		pod.Name = name
+		pod.Labels = labels
+		pod.Status.Phase = corev1.PodRunning
+		return pod
+	}
+	daemon := newPod("daemon", nil)
+	excluded := newPod("excluded", map[string]string{"tier": "best-effort"})
+	stopped := newPod("stopped", nil)
+	stopped.Spec.RestartPolicy = corev1.RestartPolicyNever
+	stopped.Status.Phase = corev1.PodSucceeded
+	for _, pod := range []*corev1.Pod{daemon, excluded, stopped,
+		newPod("virtual", map[string]string{util.VirtualPodLabel: "true"})} {
+		podInformer.Informer().GetStore().Add(pod)
+	}
+
+	nodeResource, err := vk.getNodeResource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nodeResource.CPU.Equal(resource.MustParse("7")) {
+		t.Fatalf("Desire cpu 7 with the daemon pod kept back, get %v", nodeResource.CPU.String())
+	}
+	reserved, err := vk.getReservedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reserved.Cpu().Equal(resource.MustParse("3")) || !reserved.Pods().Equal(resource.MustParse("1")) {
+		t.Fatalf("Desire 3 cpu reserved by 1 pod, get %v", reserved)
+	}
+
+	// the daemon pod relabeled as excluded gives its requests back
+	vk.providerNode.SetResource(nodeResource)
+	relabeled := daemon.DeepCopy()
+	relabeled.Labels = excluded.Labels
+	vk.updateVKCapacityFromPod(daemon, relabeled)
+	if cpu := vk.providerNode.Status.Capacity.Cpu(); !cpu.Equal(resource.MustParse("10")) {
+		t.Fatalf("Desire cpu 10 after the pod excluded, get %v", cpu.String())
+	}
+	// neither the excluded nor the stopped pods change the capacity
+	vk.updateVKCapacityFromPod(excluded, excluded.DeepCopy())
+	vk.updateVKCapacityFromPod(stopped, stopped.DeepCopy())
+	if len(vk.updatedNode) != 1 {
+		t.Fatalf("Desire node updated once, get %v", len(vk.updatedNode))
+	}
+	if _, err = parseReservedExclude("tier in (,"); err == nil {
+		t.Fatal("Desire invalid selector rejected")
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// resizeSubresource is required to resize pods in place since 1.33, pods are patched directly before it
+	resizeSubresource = "resize"
+
+	resizeProposed   = "Proposed"
+	resizeInProgress = "InProgress"
+	resizeInfeasible = "Infeasible"
+
+	// podResizePending and podResizeInProgress replace status.resize since 1.33
+	podResizePending    corev1.PodConditionType = "PodResizePending"
+	podResizeInProgress corev1.PodConditionType = "PodResizeInProgress"
+)
+
+// resizeStatus is the in-place resize status of the lower pod reflected to the upper pod, the fields are
+// unknown to the api of this version, so they could not be put in the status of the upper pod
+type resizeStatus struct {
+	// Resize is Proposed, InProgress, Deferred or Infeasible, empty once the pod resized
+	Resize     string                  `json:"resize,omitempty"`
+	Message    string                  `json:"message,omitempty"`
+	Containers []containerResizeStatus `json:"containers,omitempty"`
+}
+
+// containerResizeStatus is the resources allocated to and applied on a container of the lower pod
+type containerResizeStatus struct {
+	Name               string                       `json:"name"`
+	AllocatedResources corev1.ResourceList          `json:"allocatedResources,omitempty"`
+	Resources          *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// resizingPod is the part of the lower pod about resizing, decoded from the raw pod
+type resizingPod struct {
+	Spec struct {
+		Containers []struct {
+			Name      string                      `json:"name"`
+			Resources corev1.ResourceRequirements `json:"resources"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Resize            string                  `json:"resize"`
+		Conditions        []corev1.PodCondition   `json:"conditions"`
+		ContainerStatuses []containerResizeStatus `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// resizeStatus returns the resize status of the pod, the resize is Proposed if the resources applied
+// differ from the spec while the kubelet has not reported anything
+func (p *resizingPod) resizeStatus() *resizeStatus {
+	status := &resizeStatus{Resize: p.Status.Resize, Containers: p.Status.ContainerStatuses}
+	for _, condition := range p.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case podResizePending:
+			status.Resize, status.Message = condition.Reason, condition.Message
+		case podResizeInProgress:
+			status.Resize, status.Message = resizeInProgress, condition.Message
+		}
+	}
+	if len(status.Resize) != 0 {
+		return status
+	}
+	specs := make(map[string]corev1.ResourceRequirements, len(p.Spec.Containers))
+	for _, c := range p.Spec.Containers {
+		specs[c.Name] = c.Resources
+	}
+	for _, c := range p.Status.ContainerStatuses {
+		if c.Resources != nil && !apiequality.Semantic.DeepEqual(*c.Resources, specs[c.Name]) {
+			status.Resize = resizeProposed
+			break
+		}
+	}
+	return status
+}
+
+// upperResources returns the resources of containers of the upper pod recorded on the lower pod
+func upperResources(pod *corev1.Pod) string {
+	resources := make(map[string]corev1.ResourceRequirements, len(pod.Spec.Containers))
+	for _, c := range pod.Spec.Containers {
+		resources[c.Name] = c.Resources
+	}
+	data, err := json.Marshal(resources)
+	if err != nil {
+		klog.Errorf("Marshal resources of pod %v/%v failed: %v", pod.Namespace, pod.Name, err)
+		return ""
+	}
+	return string(data)
+}
+
+// setUpperResources records the resources of containers of the upper pod on the lower pod
+func setUpperResources(lower, upper *corev1.Pod) {
+	if lower.Annotations == nil {
+		lower.Annotations = map[string]string{}
+	}
+	lower.Annotations[util.UpperResources] = upperResources(upper)
+}
+
+// hideUpperResources removes the resources recorded before the lower pod returned to the pod controller
+func hideUpperResources(pod *corev1.Pod) {
+	if pod.Annotations != nil {
+		delete(pod.Annotations, util.UpperResources)
+	}
+}
+
+// resizedContainers returns the containers of the lower pod to be resized to the resources of the upper pod.
+// The resources are compared with those of the upper pod last resized to, so the resources rewritten by
+// translation hooks or transformations are kept until the upper pod resized, the resources of the lower pod
+// are compared for pods created before they are recorded.
+func resizedContainers(lower, upper *corev1.Pod) []corev1.Container {
+	recorded := make(map[string]corev1.ResourceRequirements, len(lower.Spec.Containers))
+	if data, ok := lower.Annotations[util.UpperResources]; ok {
+		if err := json.Unmarshal([]byte(data), &recorded); err != nil {
+			klog.Warningf("Invalid resources recorded on pod %v/%v: %v", lower.Namespace, lower.Name, err)
+			return nil
+		}
+	} else {
+		for _, c := range lower.Spec.Containers {
+			recorded[c.Name] = c.Resources
+		}
+	}
+	var containers []corev1.Container
+	for _, c := range upper.Spec.Containers {
+		last, ok := recorded[c.Name]
+		if !ok || apiequality.Semantic.DeepEqual(last, c.Resources) {
+			continue
+		}
+		containers = append(containers, corev1.Container{Name: c.Name, Resources: c.Resources})
+	}
+	return containers
+}
+
+// resizePod resizes the containers of the lower pod in place by the resize subresource, the pod is patched
+// directly if the subresource not supported by the lower cluster
+func resizePod(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod,
+	containers []corev1.Container) (*corev1.Pod, error) {
+	patchContainers := make([]map[string]interface{}, 0, len(containers))
+	for _, c := range containers {
+		patchContainers = append(patchContainers, map[string]interface{}{"name": c.Name, "resources": c.Resources})
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"containers": patchContainers},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resized, err := client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch,
+		metav1.PatchOptions{}, resizeSubresource)
+	if errors.IsNotFound(err) {
+		resized, err = client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch,
+			metav1.PatchOptions{})
+	}
+	return resized, err
+}
+
+// resizing returns if the resize of the lower pod is not finished, the status is reflected to the upper pod
+// and then propagated downward by UpdatePod with the other annotations
+func resizing(pod *corev1.Pod) bool {
+	data, ok := pod.Annotations[util.ResizeStatus]
+	if !ok {
+		return false
+	}
+	status := &resizeStatus{}
+	if err := json.Unmarshal([]byte(data), status); err != nil {
+		return false
+	}
+	return len(status.Resize) != 0 && status.Resize != resizeInfeasible
+}
+
+// syncResizeStatus reflects the resize status of the lower pod to the upper pod, the pod is read raw since
+// the fields are dropped by the informer
+func (v *VirtualK8S) syncResizeStatus(ctx context.Context, lower *corev1.Pod) {
+	if v.isStale(lower) {
+		return
+	}
+	namespace, _ := v.upperNamespace(lower)
+	client, err := v.podClient(namespace)
+	if err != nil {
+		klog.Errorf("Get client of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return
+	}
+	data, err := client.CoreV1().RESTClient().Get().Namespace(lower.Namespace).Resource("pods").
+		Name(lower.Name).Do(ctx).Raw()
+	if err != nil {
+		klog.Errorf("Get pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return
+	}
+	pod := &resizingPod{}
+	if err = json.Unmarshal(data, pod); err != nil {
+		klog.Errorf("Decode pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return
+	}
+	v.setResizeStatus(ctx, lower, pod.resizeStatus())
+}
+
+// setResizeStatus patches the resize status to the upper pod if it changed
+func (v *VirtualK8S) setResizeStatus(ctx context.Context, lower *corev1.Pod, status *resizeStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if lower.Annotations[util.ResizeStatus] == string(data) {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{util.ResizeStatus: string(data)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = v.upperPods(lower).Patch(ctx, lower.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Sync resize status of pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return err
+	}
+	klog.V(4).Infof("Synced resize status %v of pod %v/%v", status.Resize, lower.Namespace, lower.Name)
+	return nil
+}
+
+// resizeContainers resizes the containers of the lower pod to be updated, the resources and resourceVersion of
+// it are refreshed, so the update following does not revert the resize. The upper pod is marked Proposed, or
+// Infeasible if either cluster does not support resizing pods in place.
+func (v *VirtualK8S) resizeContainers(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod,
+	containers []corev1.Container) error {
+	v.rewriteResources(containers)
+	status := &resizeStatus{Resize: resizeProposed}
+	if v.translates(FeatureInPlacePodVerticalScaling) {
+		resized, err := resizePod(ctx, client, pod, containers)
+		switch {
+		case errors.IsInvalid(err) || errors.IsForbidden(err):
+			klog.Warningf("Resize pod %v/%v failed: %v", pod.Namespace, pod.Name, err)
+			status = &resizeStatus{Resize: resizeInfeasible, Message: err.Error()}
+		case err != nil:
+			return fmt.Errorf("could not resize pod: %v", err)
+		default:
+			pod.ResourceVersion = resized.ResourceVersion
+			for i := range pod.Spec.Containers {
+				for _, c := range containers {
+					if c.Name == pod.Spec.Containers[i].Name {
+						pod.Spec.Containers[i].Resources = c.Resources
+					}
+				}
+			}
+		}
+	} else {
+		status = &resizeStatus{Resize: resizeInfeasible,
+			Message: "lower or upper cluster does not support resizing pods in place"}
+	}
+	if err := v.setResizeStatus(ctx, pod, status); err != nil {
+		return fmt.Errorf("could not set resize status: %v", err)
+	}
+	// the status is propagated to the lower pod with the update
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	pod.Annotations[util.ResizeStatus] = string(data)
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestResizedContainers(t *testing.T) {
+	resources := func(cpu string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}}
+	}
+	upper := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", Resources: resources("1")},
+		{Name: "sidecar", Resources: resources("100m")},
+	}}}
+	// the cpu of app is rewritten by a transformation
+	lower := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", Resources: resources("2")},
+		{Name: "sidecar", Resources: resources("100m")},
+	}}}
+	if containers := resizedContainers(lower, upper); len(containers) != 1 || containers[0].Name != "app" {
+		t.Fatalf("desire app resized for pods not recorded, real %+v", containers)
+	}
+	setUpperResources(lower, upper)
+	if containers := resizedContainers(lower, upper); len(containers) != 0 {
+		t.Fatalf("desire resources rewritten kept, real %+v", containers)
+	}
+	resized := upper.DeepCopy()
+	resized.Spec.Containers[1].Resources = resources("200m")
+	containers := resizedContainers(lower, resized)
+	if len(containers) != 1 || containers[0].Name != "sidecar" ||
+		!containers[0].Resources.Requests.Cpu().Equal(resource.MustParse("200m")) {
+		t.Fatalf("desire sidecar resized to 200m, real %+v", containers)
+	}
+	hideUpperResources(lower)
+	if _, ok := lower.Annotations[util.UpperResources]; ok {
+		t.Fatalf("desire resources recorded hidden, real %v", lower.Annotations)
+	}
+}
+
+func TestResizeStatus(t *testing.T) {
+	cases := []struct {
+		name   string
+		raw    string
+		resize string
+	}{
+		{
+			name: "resized",
+			raw: `{"spec":{"containers":[{"name":"app","resources":{"requests":{"cpu":"1"}}}]},
+"status":{"containerStatuses":[{"name":"app","allocatedResources":{"cpu":"1"},"resources":{"requests":{"cpu":"1"}}}]}}`,
+		},
+		{
+			name: "proposed",
+			raw: `{"spec":{"containers":[{"name":"app","resources":{"requests":{"cpu":"2"}}}]},
+"status":{"containerStatuses":[{"name":"app","allocatedResources":{"cpu":"1"},"resources":{"requests":{"cpu":"1"}}}]}}`,
+			resize: resizeProposed,
+		},
+		{
+			name:   "in progress before 1.33",
+			raw:    `{"status":{"resize":"InProgress"}}`,
+			resize: resizeInProgress,
+		},
+		{
+			name:   "deferred since 1.33",
+			raw:    `{"status":{"conditions":[{"type":"PodResizePending","status":"True","reason":"Deferred"}]}}`,
+			resize: "Deferred",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pod := &resizingPod{}
+			if err := json.Unmarshal([]byte(c.raw), pod); err != nil {
+				t.Fatal(err)
+			}
+			if status := pod.resizeStatus(); status.Resize != c.resize {
+				t.Fatalf("desire %q, real %q", c.resize, status.Resize)
+			}
+		})
+	}
+}
+
+func TestResizing(t *testing.T) {
+	pod := &corev1.Pod{}
+	if resizing(pod) {
+		t.Fatal("desire not resizing without status")
+	}
+	pod.Annotations = map[string]string{util.ResizeStatus: `{"resize":"InProgress"}`}
+	if !resizing(pod) {
+		t.Fatal("desire resizing in progress")
+	}
+	pod.Annotations[util.ResizeStatus] = `{"resize":"Infeasible"}`
+	if resizing(pod) {
+		t.Fatal("desire not resizing if infeasible")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+// SetResourceRules replaces the rules rewriting the resources of lower pods live by those of cfg selecting the
+// cluster of the provider, the current ones are kept if any of them is invalid
+func (v *VirtualK8S) SetResourceRules(cfg *config.ProviderConfig) {
+	rules := cfg.ResourceRulesOf(v.clusterName)
+	for _, r := range rules {
+		if err := validateResourceRule(r); err != nil {
+			klog.Errorf("Skip invalid resource rules: %v", err)
+			return
+		}
+	}
+	v.resourceRulesLock.Lock()
+	defer v.resourceRulesLock.Unlock()
+	v.resourceRules = rules
+}
+
+func validateResourceRule(r config.ResourceRule) error {
+	if len(r.Resource) == 0 {
+		return fmt.Errorf("resource of rule is required")
+	}
+	if r.Scale != nil && *r.Scale <= 0 {
+		return fmt.Errorf("scale %v of %v must be larger than 0", *r.Scale, r.Resource)
+	}
+	if r.Max != nil && r.Max.Sign() < 0 {
+		return fmt.Errorf("max %v of %v must not be negative", r.Max.String(), r.Resource)
+	}
+	if v1helper.IsHugePageResourceName(r.RenameTo) {
+		if _, err := v1helper.HugePageSizeFromResourceName(r.RenameTo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteResources rewrites the requests and limits of the containers by the resource rules in order, the
+// resources of the upper pod are recorded on the lower pod before, so the status is reflected by them
+func (v *VirtualK8S) rewriteResources(containers []corev1.Container) {
+	v.resourceRulesLock.RLock()
+	rules := v.resourceRules
+	v.resourceRulesLock.RUnlock()
+	if len(rules) == 0 {
+		return
+	}
+	var nodeSize corev1.ResourceList
+	for _, r := range rules {
+		if r.CapAtNodeSize {
+			nodeSize = v.largestAllocatable()
+			break
+		}
+	}
+	for i := range containers {
+		// the lists may be shared with the upper pod in cache
+		requests := containers[i].Resources.Requests.DeepCopy()
+		limits := containers[i].Resources.Limits.DeepCopy()
+		for _, r := range rules {
+			applyResourceRule(requests, r, nodeSize)
+			applyResourceRule(limits, r, nodeSize)
+		}
+		containers[i].Resources.Requests = requests
+		containers[i].Resources.Limits = limits
+	}
+}
+
+// largestAllocatable returns the largest allocatable of each resource among the ready lower nodes
+func (v *VirtualK8S) largestAllocatable() corev1.ResourceList {
+	nodes, err := v.clientCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List nodes of lower cluster failed: %v", err)
+		return nil
+	}
+	largest := corev1.ResourceList{}
+	for _, node := range nodes {
+		if !checkNodeStatusReady(node) || node.Spec.Unschedulable {
+			continue
+		}
+		for name, quantity := range node.Status.Allocatable {
+			if current, ok := largest[name]; !ok || quantity.Cmp(current) > 0 {
+				largest[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	return largest
+}
+
+// applyResourceRule rewrites the resource of the rule in list, the quantity is scaled, renamed and then capped
+// at the max of the rule or at nodeSize, a resource absent from nodeSize is not capped
+func applyResourceRule(list corev1.ResourceList, rule config.ResourceRule, nodeSize corev1.ResourceList) {
+	quantity, ok := list[rule.Resource]
+	if !ok {
+		return
+	}
+	name := rule.Resource
+	if rule.Scale != nil {
+		quantity = scaleQuantity(name, quantity, *rule.Scale)
+	}
+	if len(rule.RenameTo) != 0 && rule.RenameTo != name {
+		delete(list, name)
+		name = rule.RenameTo
+		if existing, ok := list[name]; ok {
+			quantity.Add(existing)
+		}
+		if pageSize, err := v1helper.HugePageSizeFromResourceName(name); err == nil && pageSize.Value() > 0 {
+			pages := (quantity.Value() + pageSize.Value() - 1) / pageSize.Value()
+			quantity = *resource.NewQuantity(pages*pageSize.Value(), resource.BinarySI)
+		}
+	}
+	if rule.Max != nil && quantity.Cmp(*rule.Max) > 0 {
+		quantity = rule.Max.DeepCopy()
+	}
+	if size, ok := nodeSize[name]; ok && rule.CapAtNodeSize && quantity.Cmp(size) > 0 {
+		quantity = size.DeepCopy()
+	}
+	list[name] = quantity
+}
+
+// scaleQuantity multiplies the quantity by scale, rounded up to a milli cpu or an integer of other resources
+func scaleQuantity(name corev1.ResourceName, quantity resource.Quantity, scale float64) resource.Quantity {
+	if name == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(int64(math.Ceil(float64(quantity.MilliValue())*scale)), quantity.Format)
+	}
+	return *resource.NewQuantity(int64(math.Ceil(float64(quantity.Value())*scale)), quantity.Format)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+func TestRewriteResources(t *testing.T) {
+	v, nodeInformer, _ := newFakeVirtualK8SWithNodePod()
+	v.clusterName = "cluster-a"
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	if err := nodeInformer.Informer().GetStore().Add(node); err != nil {
+		t.Fatal(err)
+	}
+	scale := 1.2
+	max := resource.MustParse("3Gi")
+	v.SetResourceRules(&config.ProviderConfig{ResourceRules: []config.ResourceRule{
+		{Resource: corev1.ResourceMemory, Scale: &scale, Max: &max},
+		{Resource: corev1.ResourceCPU, CapAtNodeSize: true},
+		{Resource: "hugepages-2Mi", RenameTo: "hugepages-1Gi"},
+		{Clusters: []string{"cluster-b"}, Resource: corev1.ResourceCPU, Scale: &scale},
+	}})
+
+	upper := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("8"),
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+		"hugepages-2Mi":       resource.MustParse("100Mi"),
+	}
+	containers := []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+		Requests: upper,
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+	}}}
+	v.rewriteResources(containers)
+	requests := containers[0].Resources.Requests
+	if cpu := requests[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("4")) != 0 {
+		t.Fatalf("Desire cpu capped at node size 4, get %v", cpu.String())
+	}
+	if memory := requests[corev1.ResourceMemory]; memory.Value() != 1288490189 {
+		t.Fatalf("Desire memory scaled to 1288490189, get %v", memory.Value())
+	}
+	if _, ok := requests["hugepages-2Mi"]; ok {
+		t.Fatal("Desire hugepages-2Mi renamed")
+	}
+	if pages := requests["hugepages-1Gi"]; pages.Cmp(resource.MustParse("1Gi")) != 0 {
+		t.Fatalf("Desire hugepages-1Gi rounded up to 1Gi, get %v", pages.String())
+	}
+	if memory := containers[0].Resources.Limits[corev1.ResourceMemory]; memory.Cmp(max) != 0 {
+		t.Fatalf("Desire memory limit capped at 3Gi, get %v", memory.String())
+	}
+	if cpu := upper[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("8")) != 0 {
+		t.Fatalf("Desire resources of upper pod unchanged, get cpu %v", cpu.String())
+	}
+
+	v.SetResourceRules(&config.ProviderConfig{ResourceRules: []config.ResourceRule{
+		{Resource: corev1.ResourceMemory, RenameTo: "hugepages-3x"},
+	}})
+	if len(v.resourceRules) != 3 {
+		t.Fatalf("Desire invalid rules skipped and current ones kept, get %+v", v.resourceRules)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// failedSchedulingReason is the reason of events telling the pod is unschedulable in the lower cluster
+const failedSchedulingReason = "FailedScheduling"
+
+// unschedulableMessage returns the message of the scheduler in the lower cluster if the pod is
+// unschedulable there, e.g. which predicates failed on the nodes, empty string is returned otherwise
+func unschedulableMessage(status *corev1.PodStatus) string {
+	for _, cond := range status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse &&
+			cond.Reason == corev1.PodReasonUnschedulable {
+			return cond.Message
+		}
+	}
+	return ""
+}
+
+// describeSchedulingFailure tells the lower cluster in the message of the PodScheduled condition,
+// so the upper pod explains where and why it is pending
+func (v *VirtualK8S) describeSchedulingFailure(status *corev1.PodStatus) {
+	prefix := fmt.Sprintf("lower cluster %v: ", v.clusterName)
+	for i, cond := range status.Conditions {
+		if cond.Type != corev1.PodScheduled || cond.Status != corev1.ConditionFalse ||
+			strings.HasPrefix(cond.Message, prefix) {
+			continue
+		}
+		status.Conditions[i].Message = prefix + cond.Message
+	}
+}
+
+// recordSchedulingFailure records the FailedScheduling event of the lower pod on the upper pod once the
+// scheduler in the lower cluster reports a new reason
+func (v *VirtualK8S) recordSchedulingFailure(old, new *corev1.Pod) {
+	if v.eventRecorder == nil {
+		return
+	}
+	message := unschedulableMessage(&new.Status)
+	if len(message) == 0 || message == unschedulableMessage(&old.Status) {
+		return
+	}
+	namespace, ok := v.upperNamespace(new)
+	if !ok {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       new.Name,
+		UID:        getUpperUID(new),
+	}
+	v.eventRecorder.Eventf(ref, corev1.EventTypeWarning, failedSchedulingReason,
+		"pod is unschedulable in lower cluster %v: %v", v.clusterName, message)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func unschedulablePod(message string) *corev1.Pod {
+	pod := fakePod("test")
+	if len(message) == 0 {
+		return pod
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type:    corev1.PodScheduled,
+		Status:  corev1.ConditionFalse,
+		Reason:  corev1.PodReasonUnschedulable,
+		Message: message,
+	}}
+	return pod
+}
+
+func TestDescribeSchedulingFailure(t *testing.T) {
+	v := &VirtualK8S{clusterName: "c1"}
+	pod := unschedulablePod("0/3 nodes are available: 3 Insufficient cpu.")
+	v.describeSchedulingFailure(&pod.Status)
+	desire := "lower cluster c1: 0/3 nodes are available: 3 Insufficient cpu."
+	if pod.Status.Conditions[0].Message != desire {
+		t.Fatalf("desire %v, get %v", desire, pod.Status.Conditions[0].Message)
+	}
+	v.describeSchedulingFailure(&pod.Status)
+	if pod.Status.Conditions[0].Message != desire {
+		t.Fatalf("desire prefixed once, get %v", pod.Status.Conditions[0].Message)
+	}
+}
+
+func TestRecordSchedulingFailure(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		old    *corev1.Pod
+		new    *corev1.Pod
+		events int
+	}{
+		{
+			name: "scheduled",
+			old:  unschedulablePod(""),
+			new:  unschedulablePod(""),
+		},
+		{
+			name:   "become unschedulable",
+			old:    unschedulablePod(""),
+			new:    unschedulablePod("0/3 nodes are available: 3 Insufficient cpu."),
+			events: 1,
+		},
+		{
+			name: "same reason",
+			old:  unschedulablePod("0/3 nodes are available: 3 Insufficient cpu."),
+			new:  unschedulablePod("0/3 nodes are available: 3 Insufficient cpu."),
+		},
+		{
+			name:   "reason changed",
+			old:    unschedulablePod("0/3 nodes are available: 3 Insufficient cpu."),
+			new:    unschedulablePod("0/3 nodes are available: 3 node(s) didn't match node selector."),
+			events: 1,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			v := &VirtualK8S{clusterName: "c1", eventRecorder: recorder}
+			v.recordSchedulingFailure(c.old, c.new)
+			if len(recorder.Events) != c.events {
+				t.Fatalf("desire %v events, get %v", c.events, len(recorder.Events))
+			}
+		})
+	}
+}
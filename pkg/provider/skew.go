@@ -0,0 +1,133 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/klog"
+)
+
+const (
+	// versionSkewCondition is the condition of virtual node telling if the versions of the lower cluster
+	// and the upper cluster are too far apart
+	versionSkewCondition corev1.NodeConditionType = "VersionSkew"
+
+	unsupportedVersionSkewReason = "UnsupportedVersionSkew"
+	supportedVersionSkewReason   = "SupportedVersionSkew"
+	unknownVersionSkewReason     = "UnknownVersionSkew"
+)
+
+// versionSkew is the minor versions the lower cluster is newer than the upper cluster, negative if older
+type versionSkew struct {
+	upper string
+	lower string
+	skew  int
+	err   error
+}
+
+// newVersionSkew compares the versions of the clusters, only the minor versions are compared
+func newVersionSkew(upper, lower string) *versionSkew {
+	s := &versionSkew{upper: upper, lower: lower}
+	upperVersion, err := version.ParseGeneric(upper)
+	if err != nil {
+		s.err = fmt.Errorf("could not parse version %v of upper cluster: %v", upper, err)
+		return s
+	}
+	lowerVersion, err := version.ParseGeneric(lower)
+	if err != nil {
+		s.err = fmt.Errorf("could not parse version %v of lower cluster: %v", lower, err)
+		return s
+	}
+	if upperVersion.Major() != lowerVersion.Major() {
+		s.err = fmt.Errorf("major versions of upper cluster %v and lower cluster %v differ", upper, lower)
+		return s
+	}
+	s.skew = int(lowerVersion.Minor()) - int(upperVersion.Minor())
+	return s
+}
+
+// supported returns if the skew is within max minor versions, unknown skews are treated as unsupported
+func (s *versionSkew) supported(max int) bool {
+	if s.err != nil {
+		return false
+	}
+	return s.skew <= max && s.skew >= -max
+}
+
+// String describes the skew
+func (s *versionSkew) String() string {
+	switch {
+	case s.err != nil:
+		return s.err.Error()
+	case s.skew > 0:
+		return fmt.Sprintf("lower cluster %v is %d minor versions newer than upper cluster %v", s.lower, s.skew, s.upper)
+	case s.skew < 0:
+		return fmt.Sprintf("lower cluster %v is %d minor versions older than upper cluster %v", s.lower, -s.skew, s.upper)
+	default:
+		return fmt.Sprintf("lower cluster %v and upper cluster %v are of the same minor version", s.lower, s.upper)
+	}
+}
+
+// condition returns the VersionSkew condition of the virtual node, true if the skew is not supported
+func (s *versionSkew) condition(max int) corev1.NodeCondition {
+	condition := corev1.NodeCondition{
+		Type:               versionSkewCondition,
+		Status:             corev1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             supportedVersionSkewReason,
+		Message:            s.String(),
+	}
+	switch {
+	case s.err != nil:
+		condition.Status, condition.Reason = corev1.ConditionUnknown, unknownVersionSkewReason
+	case !s.supported(max):
+		condition.Status, condition.Reason = corev1.ConditionTrue, unsupportedVersionSkewReason
+		condition.Message = fmt.Sprintf("%v, at most %d supported", condition.Message, max)
+	}
+	return condition
+}
+
+// reportVersionSkew adds the VersionSkew condition to the virtual node, a warning is recorded on the node if
+// the skew is not supported
+func (v *VirtualK8S) reportVersionSkew(node *corev1.Node) {
+	if v.versionSkew == nil {
+		return
+	}
+	condition := v.versionSkew.condition(v.maxVersionSkew)
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+	if condition.Status == corev1.ConditionFalse {
+		klog.Infof("Version skew of node %v: %v", node.Name, condition.Message)
+		return
+	}
+	klog.Warningf("Version skew of node %v: %v", node.Name, condition.Message)
+	if v.eventRecorder != nil {
+		ref := &corev1.ObjectReference{Kind: "Node", Name: node.Name, UID: types.UID(node.Name)}
+		v.eventRecorder.Event(ref, corev1.EventTypeWarning, condition.Reason, condition.Message)
+	}
+}
+
+// translates returns if the translation relying on the feature is enabled, it is disabled once either of
+// the lower cluster and the upper cluster could not handle it
+func (v *VirtualK8S) translates(feature Feature) bool {
+	return v.features.supports(feature) && v.upperFeatures.supports(feature)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVersionSkew(t *testing.T) {
+	cases := []struct {
+		name   string
+		upper  string
+		lower  string
+		status corev1.ConditionStatus
+	}{
+		{
+			name:   "same minor version",
+			upper:  "v1.18.4",
+			lower:  "v1.18.20",
+			status: corev1.ConditionFalse,
+		},
+		{
+			name:   "lower older within skew",
+			upper:  "v1.21.0",
+			lower:  "v1.18.4",
+			status: corev1.ConditionFalse,
+		},
+		{
+			name:   "lower newer beyond skew",
+			upper:  "v1.18.4",
+			lower:  "v1.22.1-tke.1",
+			status: corev1.ConditionTrue,
+		},
+		{
+			name:   "unknown upper version",
+			lower:  "v1.18.4",
+			status: corev1.ConditionUnknown,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			condition := newVersionSkew(c.upper, c.lower).condition(3)
+			if condition.Status != c.status {
+				t.Fatalf("desire %v, real %v: %v", c.status, condition.Status, condition.Message)
+			}
+		})
+	}
+}
+
+func TestReportVersionSkew(t *testing.T) {
+	v := &VirtualK8S{versionSkew: newVersionSkew("v1.24.0", "v1.18.4"), maxVersionSkew: 3}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk"}}
+	v.reportVersionSkew(node)
+	if len(node.Status.Conditions) != 1 || node.Status.Conditions[0].Type != versionSkewCondition ||
+		node.Status.Conditions[0].Reason != unsupportedVersionSkewReason {
+		t.Fatalf("desire unsupported skew reported, real %+v", node.Status.Conditions)
+	}
+
+	v.features = clusterFeatures{FeaturePodDeletionCost: true}
+	v.upperFeatures = clusterFeatures{FeaturePodDeletionCost: false}
+	if v.translates(FeaturePodDeletionCost) {
+		t.Fatal("desire translation disabled if upper cluster lacks the feature")
+	}
+}
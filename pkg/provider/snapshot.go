@@ -0,0 +1,234 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// snapshotPeriod is the period to persist the pod snapshot
+	snapshotPeriod = time.Minute
+	// snapshotMaxAge is the max age of a snapshot could be loaded, older snapshot is
+	// likely to be compacted by apiserver and cause a relist anyway
+	snapshotMaxAge = 10 * time.Minute
+)
+
+// podSnapshot is the compact snapshot of pods in lower cluster persisted to disk
+type podSnapshot struct {
+	ResourceVersion string       `json:"resourceVersion"`
+	Timestamp       metav1.Time  `json:"timestamp"`
+	Pods            []corev1.Pod `json:"pods"`
+}
+
+// loadPodSnapshot loads the snapshot from path, nil would be returned if the
+// snapshot not exists or is too old
+func loadPodSnapshot(path string) (*podSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	snapshot := &podSnapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	if !snapshot.usable(time.Now()) {
+		klog.Infof("Snapshot %v taken at %v is out of date, ignore it", path, snapshot.Timestamp)
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+// usable returns if the snapshot could be resumed from at now
+func (s *podSnapshot) usable(now time.Time) bool {
+	return s != nil && len(s.ResourceVersion) != 0 && now.Sub(s.Timestamp.Time) <= snapshotMaxAge
+}
+
+// savePodSnapshot persists pods with the resource version to path atomically
+func savePodSnapshot(path, resourceVersion string, pods []*corev1.Pod) error {
+	snapshot := &podSnapshot{
+		ResourceVersion: resourceVersion,
+		Timestamp:       metav1.Now(),
+		Pods:            make([]corev1.Pod, 0, len(pods)),
+	}
+	for _, pod := range pods {
+		podCopy := pod.DeepCopy()
+		podCopy.ManagedFields = nil
+		snapshot.Pods = append(snapshot.Pods, *podCopy)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newSnapshotListWatch returns a ListWatch of pods, the first list is served from the
+// snapshot, so that the informer only watches changes since the snapshot was taken
+func newSnapshotListWatch(client kubernetes.Interface, snapshot *podSnapshot) *cache.ListWatch {
+	var used int32
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			// the snapshot is only served once, and it is checked again since the informer
+			// may be started long after the snapshot loaded
+			if atomic.CompareAndSwapInt32(&used, 0, 1) && snapshot.usable(time.Now()) {
+				klog.Infof("List %v pods from snapshot of resource version %v", len(snapshot.Pods),
+					snapshot.ResourceVersion)
+				return &corev1.PodList{
+					ListMeta: metav1.ListMeta{ResourceVersion: snapshot.ResourceVersion},
+					Items:    snapshot.Pods,
+				}, nil
+			}
+			return client.CoreV1().Pods(corev1.NamespaceAll).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Pods(corev1.NamespaceAll).Watch(context.TODO(), options)
+		},
+	}
+}
+
+// runPodSnapshot persists the pods in cache to path periodically
+func runPodSnapshot(path string, informer cache.SharedIndexInformer, lister v1.PodLister,
+	stopCh <-chan struct{}) {
+	applied := &appliedResourceVersion{}
+	informer.AddEventHandler(applied.handler())
+	wait.Until(func() {
+		resourceVersion, pods, err := takePodSnapshot(applied.get, lister)
+		if err != nil {
+			klog.V(4).Infof("Skip snapshot: %v", err)
+			return
+		}
+		if err = savePodSnapshot(path, resourceVersion, pods); err != nil {
+			klog.Errorf("Save snapshot %v failed: %v", path, err)
+			return
+		}
+		klog.V(4).Infof("Saved snapshot of %v pods to %v", len(pods), path)
+	}, snapshotPeriod, stopCh)
+}
+
+// appliedResourceVersion records the max resource version of pods delivered to the event handlers.
+// Handlers are notified after the cache applied the event, while the last sync resource version of the
+// reflector is advanced once the event is queued, so only the former is covered by the pods in cache
+type appliedResourceVersion struct {
+	sync.Mutex
+	version uint64
+}
+
+// handler returns the event handler observing the pods applied
+func (a *appliedResourceVersion) handler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: a.observe,
+		UpdateFunc: func(old, new interface{}) {
+			a.observe(new)
+		},
+		DeleteFunc: a.observe,
+	}
+}
+
+// observe records the resource version of obj if it is larger
+func (a *appliedResourceVersion) observe(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	version, err := strconv.ParseUint(accessor.GetResourceVersion(), 10, 64)
+	if err != nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if version > a.version {
+		a.version = version
+	}
+}
+
+// get returns the max resource version applied, empty if no pod applied yet
+func (a *appliedResourceVersion) get() string {
+	a.Lock()
+	defer a.Unlock()
+	if a.version == 0 {
+		return ""
+	}
+	return strconv.FormatUint(a.version, 10)
+}
+
+// takePodSnapshot lists the pods in cache with the resource version covering them. The resource version
+// applied is read before listing, events applied in between only make the pods newer than the version,
+// which are delivered again as updates when the watch resumes from it
+func takePodSnapshot(appliedResourceVersion func() string, lister v1.PodLister) (string, []*corev1.Pod,
+	error) {
+	resourceVersion := appliedResourceVersion()
+	if len(resourceVersion) == 0 {
+		return "", nil, fmt.Errorf("no pod applied to cache yet")
+	}
+	pods, err := lister.List(labels.Everything())
+	if err != nil {
+		return "", nil, err
+	}
+	return resourceVersion, pods, nil
+}
+
+// checkSnapshotPath checks if the snapshot path is writable
+func checkSnapshotPath(path string) error {
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", filepath.Dir(path))
+	}
+	return nil
+}
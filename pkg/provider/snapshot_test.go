@@ -0,0 +1,132 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPodSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pods.json")
+
+	snapshot, err := loadPodSnapshot(path)
+	if err != nil || snapshot != nil {
+		t.Fatalf("Desire no snapshot, get %v, err: %v", snapshot, err)
+	}
+
+	pod := fakePod("ns")
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "test"}}
+	if err = savePodSnapshot(path, "100", []*corev1.Pod{pod}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err = loadPodSnapshot(path)
+	if err != nil || snapshot == nil {
+		t.Fatalf("Desire snapshot, err: %v", err)
+	}
+	if snapshot.ResourceVersion != "100" || len(snapshot.Pods) != 1 || snapshot.Pods[0].Name != pod.Name ||
+		snapshot.Pods[0].ManagedFields != nil {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+
+	client := fake.NewSimpleClientset()
+	lw := newSnapshotListWatch(client, snapshot)
+	obj, err := lw.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := obj.(*corev1.PodList); list.ResourceVersion != "100" || len(list.Items) != 1 {
+		t.Errorf("Desire list from snapshot, get %+v", list)
+	}
+	obj, err = lw.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := obj.(*corev1.PodList); len(list.Items) != 0 {
+		t.Errorf("Desire list from client, get %+v", list)
+	}
+
+	if err = savePodSnapshot(path, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err = loadPodSnapshot(path); err != nil || snapshot != nil {
+		t.Errorf("Desire no snapshot without resource version, get %v, err: %v", snapshot, err)
+	}
+
+	// snapshot expired before the informer started must not be served
+	expired := &podSnapshot{ResourceVersion: "100", Pods: []corev1.Pod{*pod},
+		Timestamp: metav1.NewTime(time.Now().Add(-2 * snapshotMaxAge))}
+	obj, err = newSnapshotListWatch(client, expired).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := obj.(*corev1.PodList); len(list.Items) != 0 {
+		t.Errorf("Desire full relist for expired snapshot, get %+v", list)
+	}
+}
+
+func TestTakePodSnapshot(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	lister := listersv1.NewPodLister(indexer)
+	applied := &appliedResourceVersion{}
+	handler := applied.handler()
+
+	if _, _, err := takePodSnapshot(applied.get, lister); err == nil {
+		t.Error("Desire no snapshot before any pod applied")
+	}
+
+	pod := fakePod("ns")
+	pod.ResourceVersion = "100"
+	indexer.Add(pod)
+	handler.OnAdd(pod)
+	older := pod.DeepCopy()
+	older.Name, older.ResourceVersion = "older", "90"
+	indexer.Add(older)
+	handler.OnAdd(older)
+	// an update of version 110 has been queued by the reflector, advancing its last sync resource
+	// version, but the cache lags behind and the handlers are not notified
+	lastSyncResourceVersion := "110"
+
+	resourceVersion, pods, err := takePodSnapshot(applied.get, lister)
+	if err != nil || resourceVersion != "100" || len(pods) != 2 {
+		t.Errorf("Desire snapshot of version 100 instead of %v, get %v %v, err: %v",
+			lastSyncResourceVersion, resourceVersion, pods, err)
+	}
+
+	deleted := pod.DeepCopy()
+	deleted.ResourceVersion = "105"
+	indexer.Delete(deleted)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "ns/test", Obj: deleted})
+	resourceVersion, pods, err = takePodSnapshot(applied.get, lister)
+	if err != nil || resourceVersion != "105" || len(pods) != 1 {
+		t.Errorf("Desire snapshot of version 105 after deletion, get %v %v, err: %v", resourceVersion, pods, err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/controllers"
+)
+
+// createGoverningService creates the headless service governing the pod in the lower cluster if absent, i.e. the
+// service of its subdomain, with the endpoints of the upper cluster, so that the stable hostnames of the replicas
+// of a StatefulSet, e.g. the peers of etcd or Kafka, resolve in the lower cluster once the pod starts, even if the
+// service controller has not synced them yet. The lower service has no selector, the endpoints are kept up with
+// the upper cluster by the service controller, which holds the hostnames of the replicas in all clusters.
+func (v *VirtualK8S) createGoverningService(ctx context.Context, pod *corev1.Pod) error {
+	if len(pod.Spec.Subdomain) == 0 || len(pod.Spec.Hostname) == 0 {
+		return nil
+	}
+	name := pod.Spec.Subdomain
+	namespace := v.lowerNamespace(pod.Namespace)
+	if _, err := v.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("could not check service %v in client cluster: %v", name, err)
+	}
+	service, err := v.master.CoreV1().Services(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// the subdomain is not resolvable in the upper cluster either
+			klog.V(4).Infof("Governing service %v of pod %v/%v not found", name, pod.Namespace, pod.Name)
+			return nil
+		}
+		return fmt.Errorf("could not get service %v in master cluster: %v", name, err)
+	}
+	if service.Spec.ClusterIP != corev1.ClusterIPNone {
+		return nil
+	}
+	if endpoints, err := v.master.CoreV1().Endpoints(pod.Namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		controllers.PrepareLowerEndpoints(endpoints)
+		endpoints.Namespace = namespace
+		if _, err = v.client.CoreV1().Endpoints(namespace).Create(ctx, endpoints,
+			metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("could not create endpoints %v in client cluster: %v", name, err)
+		}
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("could not get endpoints %v in master cluster: %v", name, err)
+	}
+	if err = controllers.PrepareLowerService(service); err != nil {
+		return err
+	}
+	service.Namespace = namespace
+	if _, err = v.client.CoreV1().Services(namespace).Create(ctx, service,
+		metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("could not create service %v in client cluster: %v", name, err)
+	}
+	klog.V(3).Infof("Create governing service %v of pod %v/%v in client cluster success", name, pod.Namespace,
+		pod.Name)
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreateGoverningService(t *testing.T) {
+	ctx := context.Background()
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	vk.master.CoreV1().Services("ns").Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "ns", UID: "uid"},
+		Spec: corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{"app": "etcd"}},
+	}, metav1.CreateOptions{})
+	vk.master.CoreV1().Endpoints("ns").Create(ctx, &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "ns"},
+		Subsets: []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{
+			{IP: "10.0.0.1", Hostname: "etcd-0"},
+		}}},
+	}, metav1.CreateOptions{})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-1", Namespace: "ns"},
+		Spec:       corev1.PodSpec{Hostname: "etcd-1", Subdomain: "etcd"},
+	}
+	if err := vk.createGoverningService(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	service, err := vk.client.CoreV1().Services("ns").Get(ctx, "etcd", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Desire governing service created, get %v", err)
+	}
+	if service.Spec.Selector != nil || service.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Fatalf("Desire headless service without selector, get %+v", service.Spec)
+	}
+	endpoints, err := vk.client.CoreV1().Endpoints("ns").Get(ctx, "etcd", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Desire endpoints created, get %v", err)
+	}
+	if endpoints.Subsets[0].Addresses[0].Hostname != "etcd-0" {
+		t.Fatalf("Desire hostnames of replicas kept, get %+v", endpoints.Subsets)
+	}
+
+	pod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"},
+		Spec:       corev1.PodSpec{Hostname: "other", Subdomain: "missing"},
+	}
+	if err = vk.createGoverningService(ctx, pod); err != nil {
+		t.Fatalf("Desire missing governing service skipped, get %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	storageutil "k8s.io/kubernetes/pkg/apis/storage/v1/util"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// storageClassSyncPeriod is the period to discover the storage classes of lower cluster
+const storageClassSyncPeriod = time.Minute
+
+// runStorageClasses publishes the storage classes of lower cluster to the annotation and labels of virtual node,
+// classes are created rarely, so they are listed periodically instead of watched
+func (v *VirtualK8S) runStorageClasses(ctx context.Context) {
+	wait.Until(func() {
+		list, err := v.client.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			klog.Errorf("List storage classes failed: %v", err)
+			return
+		}
+		classes := toStorageClasses(list.Items)
+		data, err := json.Marshal(classes)
+		if err != nil {
+			klog.Errorf("Marshal storage classes failed: %v", err)
+			return
+		}
+		v.setNodeAnnotation(util.StorageClasses, string(data))
+		v.setNodeLabels(util.StorageClassLabelPrefix, storageClassLabels(classes))
+	}, storageClassSyncPeriod, ctx.Done())
+}
+
+// toStorageClasses converts the storage classes sorted by name, so the annotation only changes with them
+func toStorageClasses(items []storagev1.StorageClass) common.StorageClasses {
+	classes := make(common.StorageClasses, 0, len(items))
+	for _, sc := range items {
+		class := common.StorageClass{
+			Name:              sc.Name,
+			Provisioner:       sc.Provisioner,
+			VolumeBindingMode: storagev1.VolumeBindingImmediate,
+			Default:           storageutil.IsDefaultAnnotation(sc.ObjectMeta),
+			AllowedTopologies: sc.AllowedTopologies,
+		}
+		if sc.VolumeBindingMode != nil {
+			class.VolumeBindingMode = *sc.VolumeBindingMode
+		}
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Name < classes[j].Name
+	})
+	return classes
+}
+
+// storageClassLabels returns the labels advertising the storage classes by their binding modes, so pods could
+// select the clusters having a class, classes of names too long for a label are only in the annotation
+func storageClassLabels(classes common.StorageClasses) map[string]string {
+	labels := make(map[string]string, len(classes))
+	for _, class := range classes {
+		key := util.StorageClassLabelPrefix + class.Name
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			klog.V(4).Infof("Skip label of storage class %v: %v", class.Name, errs)
+			continue
+		}
+		labels[key] = string(class.VolumeBindingMode)
+	}
+	return labels
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestStorageClassLabels(t *testing.T) {
+	ctx := context.Background()
+	wait := storagev1.VolumeBindingWaitForFirstConsumer
+	classes := toStorageClasses([]storagev1.StorageClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ssd"}, VolumeBindingMode: &wait},
+		{ObjectMeta: metav1.ObjectMeta{Name: "nas", Annotations: map[string]string{
+			"storageclass.kubernetes.io/is-default-class": "true"}}},
+	})
+	if len(classes) != 2 || classes[0].Name != "nas" || !classes[0].Default ||
+		classes[0].VolumeBindingMode != storagev1.VolumeBindingImmediate {
+		t.Fatalf("Desire classes sorted with the default nas, get %+v", classes)
+	}
+
+	vk, _, _ := newFakeVirtualK8SWithNodePod()
+	vk.master = fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk"}})
+	vk.providerNode.Node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk"}}
+	vk.setNodeLabels(util.StorageClassLabelPrefix, storageClassLabels(classes))
+	if err := vk.patchNodeMetadata(ctx, vk.desiredNodeMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	node, err := vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Labels[util.StorageClassLabelPrefix+"ssd"] != string(wait) ||
+		node.Labels[util.StorageClassLabelPrefix+"nas"] != string(storagev1.VolumeBindingImmediate) {
+		t.Fatalf("Desire labels of storage classes, get %v", node.Labels)
+	}
+
+	// the labels of classes deleted are removed
+	vk.setNodeLabels(util.StorageClassLabelPrefix, storageClassLabels(classes[:1]))
+	if err = vk.patchNodeMetadata(ctx, vk.desiredNodeMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	if node, err = vk.master.CoreV1().Nodes().Get(ctx, "vk", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.Labels[util.StorageClassLabelPrefix+"ssd"]; ok || len(node.Labels) != 1 {
+		t.Fatalf("Desire label of ssd removed, get %v", node.Labels)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	transportspdy "k8s.io/client-go/transport/spdy"
+	"k8s.io/klog"
+)
+
+// AttachToContainer attaches to the running container of the pod in lower cluster, copying data between in/out/err
+// and the container's stdin/stdout/stderr
+func (v *VirtualK8S) AttachToContainer(ctx context.Context, namespace, podName, containerName string,
+	attach api.AttachIO) error {
+	defer func() {
+		if attach.Stdout() != nil {
+			attach.Stdout().Close()
+		}
+		if attach.Stderr() != nil {
+			attach.Stderr().Close()
+		}
+	}()
+	req := v.client.CoreV1().RESTClient().
+		Post().
+		Namespace(v.lowerNamespace(namespace)).
+		Resource("pods").
+		Name(podName).
+		SubResource("attach").
+		Timeout(0).
+		VersionedParams(&corev1.PodAttachOptions{
+			Container: containerName,
+			Stdin:     attach.Stdin() != nil,
+			Stdout:    attach.Stdout() != nil,
+			Stderr:    attach.Stderr() != nil,
+			TTY:       attach.TTY(),
+		}, scheme.ParameterCodec)
+
+	exec, err := v.newExecutor(req.URL())
+	if err != nil {
+		return fmt.Errorf("could not make remote attach: %v", err)
+	}
+	return exec.Stream(remotecommand.StreamOptions{
+		Stdin:             attach.Stdin(),
+		Stdout:            attach.Stdout(),
+		Stderr:            attach.Stderr(),
+		Tty:               attach.TTY(),
+		TerminalSizeQueue: &termSize{attach: attach},
+	})
+}
+
+// PortForward copies data between the stream and the port of the pod in lower cluster, until either side closes
+// or ctx done
+func (v *VirtualK8S) PortForward(ctx context.Context, namespace, podName string, port int32,
+	stream io.ReadWriteCloser) error {
+	defer stream.Close()
+	req := v.client.CoreV1().RESTClient().
+		Post().
+		Namespace(v.lowerNamespace(namespace)).
+		Resource("pods").
+		Name(podName).
+		SubResource("portforward")
+	wrapper, upgrader, err := v.spdyTransports()
+	if err != nil {
+		return fmt.Errorf("could not make remote port forward: %v", err)
+	}
+	dialer := transportspdy.NewDialer(upgrader, &http.Client{Transport: wrapper}, "POST", req.URL())
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return fmt.Errorf("could not dial port forward of pod %v/%v: %v", namespace, podName, err)
+	}
+	defer conn.Close()
+
+	// each stream is forwarded by its own connection, so the request id is never shared
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(int(port)))
+	headers.Set(corev1.PortForwardRequestIDHeader, "0")
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return fmt.Errorf("could not create error stream of port %v: %v", port, err)
+	}
+	// we're not writing to this stream
+	errorStream.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		message, err := ioutil.ReadAll(errorStream)
+		if err == nil && len(message) > 0 {
+			err = fmt.Errorf("forward port %v of pod %v/%v failed: %s", port, namespace, podName, message)
+		}
+		errCh <- err
+	}()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return fmt.Errorf("could not create data stream of port %v: %v", port, err)
+	}
+	remoteDone := make(chan struct{})
+	go func() {
+		if _, err := io.Copy(stream, dataStream); err != nil {
+			klog.V(4).Infof("Copy from port %v of pod %v/%v stopped: %v", port, namespace, podName, err)
+		}
+		close(remoteDone)
+	}()
+	go func() {
+		// inform the lower cluster no more data is sent once the stream closed
+		defer dataStream.Close()
+		if _, err := io.Copy(dataStream, stream); err != nil {
+			klog.V(4).Infof("Copy to port %v of pod %v/%v stopped: %v", port, namespace, podName, err)
+		}
+	}()
+
+	select {
+	case <-remoteDone:
+		return <-errCh
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// maxNodeTaintSets limits the size of the node taints annotation
+const maxNodeTaintSets = 32
+
+// SetClusterTaints replaces the taints of the cluster by those of cfg selecting it, they are published as an
+// annotation and patched to the virtual node together by syncNodeMetadata
+func (v *VirtualK8S) SetClusterTaints(cfg *config.ProviderConfig) {
+	v.clusterTaintsLock.Lock()
+	v.configTaints = cfg.TaintsOf(v.clusterName)
+	v.clusterTaintsLock.Unlock()
+	v.publishClusterTaints()
+}
+
+// publishClusterTaints publishes the taints declared for the cluster together with the ones propagated from
+// lower nodes, a propagated taint is skipped if one of the same key and effect is declared
+func (v *VirtualK8S) publishClusterTaints() {
+	v.clusterTaintsLock.Lock()
+	taints := append([]corev1.Taint{}, v.configTaints...)
+	for _, taint := range v.propagatedTaints {
+		if !containsTaint(taints, taint) {
+			taints = append(taints, taint)
+		}
+	}
+	v.clusterTaintsLock.Unlock()
+	data, err := json.Marshal(taints)
+	if err != nil {
+		klog.Errorf("Marshal cluster taints failed: %v", err)
+		return
+	}
+	v.setNodeAnnotation(util.ClusterTaints, string(data))
+}
+
+// mergeClusterTaints replaces the cluster taints recorded in previous by those in desired, the taints put by
+// others are kept, the merged taints are returned if they differ from current
+func mergeClusterTaints(current []corev1.Taint, previous, desired string) ([]corev1.Taint, bool) {
+	var previousTaints, desiredTaints []corev1.Taint
+	if len(previous) != 0 {
+		if err := json.Unmarshal([]byte(previous), &previousTaints); err != nil {
+			klog.Errorf("Unmarshal previous cluster taints failed: %v", err)
+		}
+	}
+	if err := json.Unmarshal([]byte(desired), &desiredTaints); err != nil {
+		klog.Errorf("Unmarshal cluster taints failed: %v", err)
+		return nil, false
+	}
+	merged := make([]corev1.Taint, 0, len(current)+len(desiredTaints))
+	for _, taint := range current {
+		if containsTaint(previousTaints, taint) || containsTaint(desiredTaints, taint) {
+			continue
+		}
+		merged = append(merged, taint)
+	}
+	merged = append(merged, desiredTaints...)
+	if reflect.DeepEqual(stripTaintTime(current), stripTaintTime(merged)) {
+		return nil, false
+	}
+	return merged, true
+}
+
+// containsTaint tells whether a taint of the same key and effect is in taints
+func containsTaint(taints []corev1.Taint, taint corev1.Taint) bool {
+	for _, t := range taints {
+		if t.MatchTaint(&taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTaintTime drops the time taints added, which is set by apiserver for NoExecute ones
+func stripTaintTime(taints []corev1.Taint) []corev1.Taint {
+	stripped := make([]corev1.Taint, 0, len(taints))
+	for _, taint := range taints {
+		taint.TimeAdded = nil
+		stripped = append(stripped, taint)
+	}
+	return stripped
+}
+
+// runNodeTaints publishes the distinct taint sets of ready and schedulable lower nodes to the annotation of virtual
+// node periodically, so that schedulers filter out the clusters where no node is tolerated by the pod. The
+// annotation is emptied if there are too many sets to publish, which constrains no pod
+func (v *VirtualK8S) runNodeTaints(ctx context.Context) {
+	wait.Until(func() {
+		nodes, err := v.physicalNodes()
+		if err != nil {
+			klog.Errorf("List nodes failed: %v", err)
+			return
+		}
+		taints := common.NewNodeTaints(nodes, maxNodeTaintSets)
+		if taints == nil {
+			v.setNodeAnnotation(util.NodeTaints, "")
+			return
+		}
+		data, err := json.Marshal(taints)
+		if err != nil {
+			klog.Errorf("Marshal node taints failed: %v", err)
+			return
+		}
+		v.setNodeAnnotation(util.NodeTaints, string(data))
+	}, fitSummaryPeriod, ctx.Done())
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeClusterTaints(t *testing.T) {
+	virtual := corev1.Taint{Key: "virtual-kubelet.io/provider", Value: "tensile-kube", Effect: corev1.TaintEffectNoSchedule}
+	maintenance := corev1.Taint{Key: "maintenance", Value: "true", Effect: corev1.TaintEffectNoSchedule}
+	evicting := corev1.Taint{Key: "evicting", Effect: corev1.TaintEffectNoExecute, TimeAdded: &metav1.Time{}}
+	cases := []struct {
+		name     string
+		current  []corev1.Taint
+		previous string
+		desired  string
+		merged   []corev1.Taint
+		changed  bool
+	}{
+		{
+			name:    "add",
+			current: []corev1.Taint{virtual},
+			desired: `[{"key":"maintenance","value":"true","effect":"NoSchedule"}]`,
+			merged:  []corev1.Taint{virtual, maintenance},
+			changed: true,
+		},
+		{
+			name:     "unchanged",
+			current:  []corev1.Taint{virtual, evicting},
+			previous: `[{"key":"evicting","effect":"NoExecute"}]`,
+			desired:  `[{"key":"evicting","effect":"NoExecute"}]`,
+		},
+		{
+			name:     "remove",
+			current:  []corev1.Taint{virtual, maintenance},
+			previous: `[{"key":"maintenance","value":"true","effect":"NoSchedule"}]`,
+			desired:  `[]`,
+			merged:   []corev1.Taint{virtual},
+			changed:  true,
+		},
+		{
+			name:     "update value",
+			current:  []corev1.Taint{maintenance, virtual},
+			previous: `[{"key":"maintenance","value":"true","effect":"NoSchedule"}]`,
+			desired:  `[{"key":"maintenance","value":"false","effect":"NoSchedule"}]`,
+			merged: []corev1.Taint{virtual,
+				{Key: "maintenance", Value: "false", Effect: corev1.TaintEffectNoSchedule}},
+			changed: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged, changed := mergeClusterTaints(c.current, c.previous, c.desired)
+			if changed != c.changed || !reflect.DeepEqual(merged, c.merged) {
+				t.Fatalf("desire %v %+v, real %v %+v", c.changed, c.merged, changed, merged)
+			}
+		})
+	}
+}
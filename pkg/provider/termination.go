@@ -0,0 +1,93 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// podDeletedReason is the reason of upper pods whose lower pods are deleted before terminated
+	podDeletedReason = "DeletedInLowerCluster"
+	// disruptionTargetCondition is the condition telling why the pod is deleted, e.g. evicted by taint
+	// manager or preempted, added by k8s 1.26+
+	disruptionTargetCondition corev1.PodConditionType = "DisruptionTarget"
+	// containerStatusUnknownReason and containerStatusUnknownExitCode are the same as kubelet reports for
+	// containers which could not be located when their pods are deleted
+	containerStatusUnknownReason   = "ContainerStatusUnknown"
+	containerStatusUnknownExitCode = 137
+)
+
+// terminateDeletedPod completes the last status of a lower pod deleted before it terminated, e.g. by taint
+// manager, pod gc or an admin of the lower cluster, so the upper pod fails with the reason instead of
+// running forever. Reasons, messages and exit codes already reported by the lower cluster, e.g. OOMKilled,
+// Evicted, DeadlineExceeded or node shutdown, are kept as they are.
+func terminateDeletedPod(status *corev1.PodStatus) {
+	if status.Phase == corev1.PodSucceeded || status.Phase == corev1.PodFailed {
+		return
+	}
+	status.Phase = corev1.PodFailed
+	if len(status.Reason) == 0 {
+		status.Reason = podDeletedReason
+		status.Message = "pod is deleted in lower cluster before it terminated"
+		for _, cond := range status.Conditions {
+			if cond.Type == disruptionTargetCondition && cond.Status == corev1.ConditionTrue {
+				status.Reason = cond.Reason
+				status.Message = cond.Message
+			}
+		}
+	}
+	for i := range status.InitContainerStatuses {
+		terminateContainer(&status.InitContainerStatuses[i])
+	}
+	for i := range status.ContainerStatuses {
+		terminateContainer(&status.ContainerStatuses[i])
+	}
+}
+
+func terminateContainer(status *corev1.ContainerStatus) {
+	if status.State.Terminated != nil {
+		return
+	}
+	status.Ready = false
+	status.State = corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{
+			ExitCode: containerStatusUnknownExitCode,
+			Reason:   containerStatusUnknownReason,
+			Message:  "The container could not be located when the pod was deleted in lower cluster",
+		},
+	}
+}
+
+// upperPodGone returns if the upper pod of the deleted lower pod is being deleted or no longer exists, the lower
+// pod is deleted for the upper pod then and it must not be failed. The upper pod is assumed to exist on errors
+func (v *VirtualK8S) upperPodGone(ctx context.Context, lower *corev1.Pod) bool {
+	upper, err := v.upperPods(lower).Get(ctx, lower.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		klog.Warningf("Get upper pod %v/%v failed: %v", lower.Namespace, lower.Name, err)
+		return false
+	}
+	return upper.DeletionTimestamp != nil || !belongsTo(lower, upper)
+}
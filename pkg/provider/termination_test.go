@@ -0,0 +1,131 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestTerminateDeletedPod(t *testing.T) {
+	oomKilled := corev1.ContainerStatus{Name: "a", State: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+	}}
+	running := corev1.ContainerStatus{Name: "b", Ready: true, State: corev1.ContainerState{
+		Running: &corev1.ContainerStateRunning{},
+	}}
+	unknown := corev1.ContainerStatus{Name: "b", State: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{
+			ExitCode: containerStatusUnknownExitCode,
+			Reason:   containerStatusUnknownReason,
+			Message:  "The container could not be located when the pod was deleted in lower cluster",
+		},
+	}}
+	disruption := corev1.PodCondition{Type: disruptionTargetCondition, Status: corev1.ConditionTrue,
+		Reason: "DeletionByTaintManager", Message: "Taint manager: deleting due to NoExecute taint"}
+	for _, c := range []struct {
+		name   string
+		status corev1.PodStatus
+		desire corev1.PodStatus
+	}{
+		{
+			name: "evicted",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted",
+				Message: "The node was low on resource: memory.", ContainerStatuses: []corev1.ContainerStatus{oomKilled}},
+			desire: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted",
+				Message: "The node was low on resource: memory.", ContainerStatuses: []corev1.ContainerStatus{oomKilled}},
+		},
+		{
+			name:   "running pod deleted",
+			status: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{oomKilled, running}},
+			desire: corev1.PodStatus{Phase: corev1.PodFailed, Reason: podDeletedReason,
+				Message:           "pod is deleted in lower cluster before it terminated",
+				ContainerStatuses: []corev1.ContainerStatus{oomKilled, unknown}},
+		},
+		{
+			name: "disrupted",
+			status: corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{disruption},
+				ContainerStatuses: []corev1.ContainerStatus{running}},
+			desire: corev1.PodStatus{Phase: corev1.PodFailed, Reason: disruption.Reason, Message: disruption.Message,
+				Conditions: []corev1.PodCondition{disruption}, ContainerStatuses: []corev1.ContainerStatus{unknown}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			terminateDeletedPod(&c.status)
+			if !reflect.DeepEqual(c.status, c.desire) {
+				t.Errorf("desire %+v, get %+v", c.desire, c.status)
+			}
+		})
+	}
+}
+
+func TestDeletePodTerminatesOnlyLivePods(t *testing.T) {
+	lower := fakePod("ns")
+	lower.Labels = map[string]string{util.VirtualPodLabel: "true"}
+	lower.Status.Phase = corev1.PodRunning
+	now := metav1.Now()
+	for _, c := range []struct {
+		name   string
+		upper  *corev1.Pod
+		desire corev1.PodPhase
+	}{
+		{
+			name:   "upper pod running",
+			upper:  fakePod("ns"),
+			desire: corev1.PodFailed,
+		},
+		{
+			name:   "upper pod deleting",
+			upper:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns", DeletionTimestamp: &now}},
+			desire: corev1.PodRunning,
+		},
+		{
+			name:   "upper pod deleted",
+			desire: corev1.PodRunning,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			master := fake.NewSimpleClientset()
+			if c.upper != nil {
+				master = fake.NewSimpleClientset(c.upper)
+			}
+			vk := &VirtualK8S{
+				master:       master,
+				configured:   true,
+				providerNode: &common.ProviderNode{Node: &corev1.Node{}},
+				updatedPod:   make(chan *corev1.Pod, 1),
+			}
+			vk.deletePod(lower.DeepCopy())
+			select {
+			case pod := <-vk.updatedPod:
+				if pod.Status.Phase != c.desire {
+					t.Errorf("desire phase %v, get %v", c.desire, pod.Status.Phase)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no pod notified")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+)
+
+// podThrottle bounds the pod operations in flight in the lower cluster and their rate, so that bursts of pods,
+// e.g. created by a large Job, queue up in the provider instead of overwhelming the lower apiserver. Operations
+// waiting are reported as backpressure. A nil throttle never waits.
+type podThrottle struct {
+	cluster string
+	// slots are taken by operations in flight, unbounded if nil
+	slots chan struct{}
+	// limiter limits the rate operations start at, unlimited if nil
+	limiter flowcontrol.RateLimiter
+}
+
+// newPodThrottle returns the throttle of at most concurrency operations in flight started at qps with burst, the
+// concurrency and the rate are unlimited if 0
+func newPodThrottle(cluster string, concurrency int, qps float32, burst int) *podThrottle {
+	if concurrency <= 0 && qps <= 0 {
+		return nil
+	}
+	t := &podThrottle{cluster: cluster}
+	if concurrency > 0 {
+		t.slots = make(chan struct{}, concurrency)
+	}
+	if qps > 0 {
+		if burst <= 0 {
+			burst = 1
+		}
+		t.limiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+	return t
+}
+
+// acquire waits for a slot and a token for the operation until ctx done, the function returned releases the slot
+// and should be called once the operation finished
+func (t *podThrottle) acquire(ctx context.Context, operation string) (func(), error) {
+	if t == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	metrics.PodOperationsWaiting.WithLabelValues(t.cluster).Inc()
+	defer metrics.PodOperationsWaiting.WithLabelValues(t.cluster).Dec()
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			t.release()
+			return nil, err
+		}
+	}
+	metrics.PodOperationWaitDuration.WithLabelValues(t.cluster, operation).Observe(time.Since(start).Seconds())
+	metrics.PodOperationsInFlight.WithLabelValues(t.cluster).Inc()
+	return func() {
+		metrics.PodOperationsInFlight.WithLabelValues(t.cluster).Dec()
+		t.release()
+	}, nil
+}
+
+// release frees the slot taken
+func (t *podThrottle) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
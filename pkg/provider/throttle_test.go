@@ -0,0 +1,59 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPodThrottle(t *testing.T) {
+	if newPodThrottle("test", 0, 0, 0) != nil {
+		t.Fatal("Desire no throttle if unlimited")
+	}
+	var unlimited *podThrottle
+	if _, err := unlimited.acquire(context.TODO(), "create"); err != nil {
+		t.Fatalf("Desire nil throttle never waits, get %v", err)
+	}
+
+	throttle := newPodThrottle("test", 1, 0, 0)
+	release, err := throttle.acquire(context.TODO(), "create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = throttle.acquire(ctx, "create"); err == nil {
+		t.Fatal("Desire operation beyond the concurrency waits until ctx done")
+	}
+	release()
+	if release, err = throttle.acquire(context.TODO(), "delete"); err != nil {
+		t.Fatalf("Desire slot freed once released, get %v", err)
+	}
+	release()
+
+	throttle = newPodThrottle("test", 0, 1, 1)
+	if _, err = throttle.acquire(context.TODO(), "create"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = throttle.acquire(ctx, "create"); err == nil {
+		t.Fatal("Desire operation beyond the burst waits for a token")
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// upperTokenRotationPeriod is the period checking the tokens of upper cluster to be rotated
+const upperTokenRotationPeriod = 30 * time.Second
+
+// upperTokens is recorded on the lower secret holding the tokens requested from the upper cluster for a pod,
+// so that the tokens could be requested again when rotated
+type upperTokens struct {
+	ServiceAccount string       `json:"serviceAccount"`
+	Pod            string       `json:"pod"`
+	PodUID         types.UID    `json:"podUID"`
+	Tokens         []upperToken `json:"tokens"`
+	// RefreshTime is the time the tokens are requested again, before any of them expires
+	RefreshTime metav1.Time `json:"refreshTime,omitempty"`
+}
+
+// upperToken is a serviceAccountToken projection of the pod, stored by the key in the secret
+type upperToken struct {
+	Key               string `json:"key"`
+	Audience          string `json:"audience,omitempty"`
+	ExpirationSeconds *int64 `json:"expirationSeconds,omitempty"`
+}
+
+// upperTokenSecretName returns the name of the lower secret holding the tokens of upper cluster for the pod
+func upperTokenSecretName(pod *corev1.Pod) string {
+	return pod.Name + "-upper-token"
+}
+
+// prepareUpperTokens replaces the serviceAccountToken projections of the basic pod with the projections of the
+// secret holding the tokens of upper cluster, the tokens are returned to be requested once the lower pod is
+// created. Nil is returned if the pod projects no token
+func prepareUpperTokens(pod, basicPod *corev1.Pod) *upperTokens {
+	tokens := &upperTokens{
+		ServiceAccount: pod.Spec.ServiceAccountName,
+		Pod:            pod.Name,
+		PodUID:         pod.UID,
+	}
+	if len(tokens.ServiceAccount) == 0 {
+		tokens.ServiceAccount = "default"
+	}
+	for i := range basicPod.Spec.Volumes {
+		vol := &basicPod.Spec.Volumes[i]
+		if vol.Projected == nil {
+			continue
+		}
+		for j := range vol.Projected.Sources {
+			source := &vol.Projected.Sources[j]
+			projection := source.ServiceAccountToken
+			if projection == nil {
+				continue
+			}
+			token := upperToken{
+				Key:               fmt.Sprintf("%s-%d", vol.Name, j),
+				Audience:          projection.Audience,
+				ExpirationSeconds: projection.ExpirationSeconds,
+			}
+			tokens.Tokens = append(tokens.Tokens, token)
+			*source = corev1.VolumeProjection{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: upperTokenSecretName(pod)},
+					Items:                []corev1.KeyToPath{{Key: token.Key, Path: projection.Path}},
+				},
+			}
+		}
+	}
+	if len(tokens.Tokens) == 0 {
+		return nil
+	}
+	return tokens
+}
+
+// requestUpperTokens requests the tokens bound to the upper pod from the TokenRequest api of upper cluster, the
+// refresh time is set when 80% of the shortest ttl passed, same as kubelet
+func (v *VirtualK8S) requestUpperTokens(ctx context.Context, namespace string,
+	tokens *upperTokens) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(tokens.Tokens))
+	now := time.Now()
+	var refresh time.Time
+	for _, token := range tokens.Tokens {
+		request := &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				ExpirationSeconds: token.ExpirationSeconds,
+				BoundObjectRef: &authenticationv1.BoundObjectReference{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       tokens.Pod,
+					UID:        tokens.PodUID,
+				},
+			},
+		}
+		if len(token.Audience) != 0 {
+			request.Spec.Audiences = []string{token.Audience}
+		}
+		resp, err := v.master.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, tokens.ServiceAccount,
+			request, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not request token of service account %v/%v: %v", namespace,
+				tokens.ServiceAccount, err)
+		}
+		data[token.Key] = []byte(resp.Status.Token)
+		ttl := resp.Status.ExpirationTimestamp.Sub(now)
+		if at := now.Add(ttl * 8 / 10); refresh.IsZero() || at.Before(refresh) {
+			refresh = at
+		}
+	}
+	tokens.RefreshTime = metav1.NewTime(refresh)
+	return data, nil
+}
+
+// createUpperTokenSecret creates the secret holding the tokens of upper cluster owned by the lower pod, so it is
+// garbage collected with it. It is not global, so never synced or deleted as dependencies of pods
+func (v *VirtualK8S) createUpperTokenSecret(ctx context.Context, pod *corev1.Pod, tokens *upperTokens) error {
+	namespace, _ := v.upperNamespace(pod)
+	data, err := v.requestUpperTokens(ctx, namespace, tokens)
+	if err != nil {
+		return err
+	}
+	spec, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        upperTokenSecretName(pod),
+			Namespace:   pod.Namespace,
+			Labels:      map[string]string{util.UpperTokenLabel: "true"},
+			Annotations: map[string]string{util.UpperTokens: string(spec)},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			}},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+	_, err = v.client.CoreV1().Secrets(pod.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// left by a previous lower pod of the same name not garbage collected yet
+		var current *corev1.Secret
+		if current, err = v.client.CoreV1().Secrets(pod.Namespace).Get(ctx, secret.Name,
+			metav1.GetOptions{}); err != nil {
+			return err
+		}
+		secret.ResourceVersion = current.ResourceVersion
+		_, err = v.client.CoreV1().Secrets(pod.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not create secret %v of upper tokens: %v", secret.Name, err)
+	}
+	klog.Infof("Create secret %v/%v of upper tokens success", pod.Namespace, secret.Name)
+	return nil
+}
+
+// runUpperTokenRotation requests the tokens of upper cluster again before they expire
+func (v *VirtualK8S) runUpperTokenRotation(ctx context.Context) {
+	wait.Until(func() {
+		v.rotateUpperTokens(ctx, time.Now())
+	}, upperTokenRotationPeriod, ctx.Done())
+}
+
+// rotateUpperTokens refreshes the secrets of upper tokens whose refresh time passed
+func (v *VirtualK8S) rotateUpperTokens(ctx context.Context, now time.Time) {
+	secrets, err := v.clientCache.secretLister.List(labels.SelectorFromSet(map[string]string{
+		util.UpperTokenLabel: "true",
+	}))
+	if err != nil {
+		klog.Errorf("List secrets of upper tokens failed: %v", err)
+		return
+	}
+	for _, secret := range secrets {
+		tokens := &upperTokens{}
+		if err = json.Unmarshal([]byte(secret.Annotations[util.UpperTokens]), tokens); err != nil {
+			klog.Errorf("Decode upper tokens of secret %v/%v failed: %v", secret.Namespace, secret.Name, err)
+			continue
+		}
+		if now.Before(tokens.RefreshTime.Time) {
+			continue
+		}
+		namespace, ok := v.namespaces.ToUpper(secret.Namespace)
+		if !ok {
+			continue
+		}
+		data, err := v.requestUpperTokens(ctx, namespace, tokens)
+		if err != nil {
+			// the upper pod is gone, the secret is deleted with the lower pod
+			klog.Errorf("Rotate upper tokens of secret %v/%v failed: %v", secret.Namespace, secret.Name, err)
+			continue
+		}
+		spec, err := json.Marshal(tokens)
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+		secretCopy := secret.DeepCopy()
+		secretCopy.Data = data
+		secretCopy.Annotations[util.UpperTokens] = string(spec)
+		if _, err = v.client.CoreV1().Secrets(secret.Namespace).Update(ctx, secretCopy,
+			metav1.UpdateOptions{}); err != nil {
+			klog.Errorf("Update secret %v/%v of upper tokens failed: %v", secret.Namespace, secret.Name, err)
+			continue
+		}
+		klog.V(4).Infof("Rotate upper tokens of secret %v/%v success", secret.Namespace, secret.Name)
+	}
+}
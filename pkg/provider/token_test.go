@@ -0,0 +1,131 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestPrepareUpperTokens(t *testing.T) {
+	expiration := int64(3600)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "upper-uid"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "cm", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}},
+				{Name: "kube-api-access", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience: "vault", ExpirationSeconds: &expiration, Path: "token"}},
+						{ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"}}},
+					}}}},
+			},
+		},
+	}
+	basicPod := pod.DeepCopy()
+	tokens := prepareUpperTokens(pod, basicPod)
+	if tokens == nil || len(tokens.Tokens) != 1 {
+		t.Fatalf("Desire 1 token, get %+v", tokens)
+	}
+	if tokens.ServiceAccount != "default" || tokens.PodUID != "upper-uid" {
+		t.Fatalf("Unexpected tokens %+v", tokens)
+	}
+	token := tokens.Tokens[0]
+	if token.Key != "kube-api-access-0" || token.Audience != "vault" || *token.ExpirationSeconds != expiration {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+	source := basicPod.Spec.Volumes[1].Projected.Sources[0]
+	if source.ServiceAccountToken != nil || source.Secret == nil || source.Secret.Name != "test-upper-token" ||
+		source.Secret.Items[0].Key != token.Key || source.Secret.Items[0].Path != "token" {
+		t.Fatalf("Unexpected projection %+v", source)
+	}
+	if pod.Spec.Volumes[1].Projected.Sources[0].ServiceAccountToken == nil {
+		t.Fatal("Upper pod changed")
+	}
+	if prepareUpperTokens(pod, &corev1.Pod{}) != nil {
+		t.Fatal("Desire no tokens for pod without projections")
+	}
+}
+
+func TestRotateUpperTokens(t *testing.T) {
+	ctx := context.Background()
+	master := fake.NewSimpleClientset()
+	requested := 0
+	master.PrependReactor("create", "serviceaccounts", func(action core.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		requested++
+		request := action.(core.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		request.Status = authenticationv1.TokenRequestStatus{
+			Token:               fmt.Sprintf("token-%d", requested),
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+		}
+		return true, request, nil
+	})
+	client := fake.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	v := &VirtualK8S{master: master, client: client,
+		clientCache: clientCache{secretLister: listersv1.NewSecretLister(indexer)}}
+
+	lowerPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "lower-uid"}}
+	tokens := &upperTokens{ServiceAccount: "default", Pod: "test", PodUID: "upper-uid",
+		Tokens: []upperToken{{Key: "kube-api-access-0"}}}
+	if err := v.createUpperTokenSecret(ctx, lowerPod, tokens); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.CoreV1().Secrets("default").Get(ctx, "test-upper-token", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["kube-api-access-0"]) != "token-1" || secret.Labels[util.UpperTokenLabel] != "true" {
+		t.Fatalf("Unexpected secret %+v", secret)
+	}
+	if owner := secret.OwnerReferences; len(owner) != 1 || owner[0].UID != "lower-uid" {
+		t.Fatalf("Desire secret owned by lower pod, get %v", owner)
+	}
+
+	// not rotated until the refresh time
+	indexer.Add(secret)
+	v.rotateUpperTokens(ctx, time.Now())
+	if requested != 1 {
+		t.Fatalf("Desire tokens not rotated, requested %v times", requested)
+	}
+	v.rotateUpperTokens(ctx, time.Now().Add(50*time.Minute))
+	if secret, err = client.CoreV1().Secrets("default").Get(ctx, "test-upper-token",
+		metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["kube-api-access-0"]) != "token-2" {
+		t.Fatalf("Desire token-2, get %s", secret.Data["kube-api-access-0"])
+	}
+}
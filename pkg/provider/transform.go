@@ -0,0 +1,113 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util/clustercache"
+)
+
+// strippedAnnotations are the annotations never used in lower clusters but could be large, they are stripped
+// from pods written to lower clusters rather than from the cache, so pods cached compare equal to pods written
+var strippedAnnotations = []string{corev1.LastAppliedConfigAnnotation}
+
+// stripObject drops the heavy fields of an object before it is cached
+func stripObject(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	accessor.SetManagedFields(nil)
+}
+
+// stripAnnotations drops strippedAnnotations from the pod written to lower cluster
+func stripAnnotations(pod *corev1.Pod) {
+	for _, key := range strippedAnnotations {
+		delete(pod.Annotations, key)
+	}
+}
+
+// newStripListWatch wraps lw, objects listed and watched are stripped by stripObject
+func newStripListWatch(lw cache.ListerWatcher) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			if err != nil {
+				return nil, err
+			}
+			err = meta.EachListItem(list, func(obj runtime.Object) error {
+				stripObject(obj)
+				return nil
+			})
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+				stripObject(in.Object)
+				return in, true
+			}), nil
+		},
+	}
+}
+
+// newPodInformer returns a pod informer of lower cluster caching stripped pods,
+// it is started from the snapshot in path if path is not empty, onRelist is called on re-lists, and the
+// results of lists and watches are observed by health
+func newPodInformer(client kubernetes.Interface, path string, onRelist func(),
+	health *informerHealth) func(kubernetes.Interface, time.Duration) cache.SharedIndexInformer {
+	return func(_ kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		var snapshot *podSnapshot
+		if len(path) != 0 {
+			var err error
+			snapshot, err = loadPodSnapshot(path)
+			if err != nil {
+				klog.Errorf("Load snapshot %v failed: %v", path, err)
+			}
+		}
+		lw := newObservedListWatch(newRelistListWatch(newSnapshotListWatch(client, snapshot), onRelist), health)
+		return cache.NewSharedIndexInformer(newStripListWatch(lw), &corev1.Pod{}, resync, clustercache.Indexers())
+	}
+}
+
+// newNodeInformer returns a node informer of lower cluster caching stripped nodes
+func newNodeInformer(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Nodes().List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Nodes().Watch(context.TODO(), options)
+		},
+	}
+	return cache.NewSharedIndexInformer(newStripListWatch(lw), &corev1.Node{}, resync,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
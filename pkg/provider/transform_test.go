@@ -0,0 +1,81 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStripListWatch(t *testing.T) {
+	pod := fakePod("ns")
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "test"}}
+	pod.Annotations = map[string]string{corev1.LastAppliedConfigAnnotation: "{}", "a": "b"}
+	client := fake.NewSimpleClientset(pod)
+
+	lw := newStripListWatch(newSnapshotListWatch(client, nil))
+	obj, err := lw.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := obj.(*corev1.PodList)
+	if len(list.Items) != 1 {
+		t.Fatalf("Desire 1 pod, get %v", len(list.Items))
+	}
+	checkStripped(t, &list.Items[0])
+
+	w, err := lw.Watch(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	updated := pod.DeepCopy()
+	updated.Labels = map[string]string{"updated": "true"}
+	if _, err = client.CoreV1().Pods(pod.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-w.ResultChan():
+		checkStripped(t, event.Object.(*corev1.Pod))
+	case <-time.After(time.Second):
+		t.Fatal("No watch event received")
+	}
+}
+
+func checkStripped(t *testing.T, pod *corev1.Pod) {
+	if pod.ManagedFields != nil {
+		t.Errorf("Desire managedFields stripped, get %v", pod.ManagedFields)
+	}
+	// annotations are compared with those of pods written, so they are kept in the cache
+	if len(pod.Annotations) != 2 {
+		t.Errorf("Desire annotations kept, get %v", pod.Annotations)
+	}
+}
+
+func TestStripAnnotations(t *testing.T) {
+	pod := fakePod("ns")
+	pod.Annotations = map[string]string{corev1.LastAppliedConfigAnnotation: "{}", "a": "b"}
+	stripAnnotations(pod)
+	if _, ok := pod.Annotations[corev1.LastAppliedConfigAnnotation]; ok || pod.Annotations["a"] != "b" {
+		t.Errorf("Desire only last applied annotation stripped, get %v", pod.Annotations)
+	}
+}
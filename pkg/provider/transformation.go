@@ -0,0 +1,104 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+const (
+	podKind       = "Pod"
+	configMapKind = "ConfigMap"
+	secretKind    = "Secret"
+	pvcKind       = "PersistentVolumeClaim"
+)
+
+// SetTransformations replaces the transformations of objects created in the lower cluster live by those of
+// cfg selecting the cluster of the provider, the current ones are kept if any of them is invalid
+func (v *VirtualK8S) SetTransformations(cfg *config.ProviderConfig) {
+	transformations := cfg.TransformationsOf(v.clusterName)
+	for _, t := range transformations {
+		if err := validateTransformation(t); err != nil {
+			klog.Errorf("Skip invalid transformations: %v", err)
+			return
+		}
+	}
+	v.transformationsLock.Lock()
+	defer v.transformationsLock.Unlock()
+	v.transformations = transformations
+}
+
+func validateTransformation(t config.Transformation) error {
+	switch t.Kind {
+	case podKind, configMapKind, secretKind, pvcKind:
+	default:
+		return fmt.Errorf("unsupported kind %q", t.Kind)
+	}
+	if len(t.JSONPatch) != 0 {
+		if _, err := jsonpatch.DecodePatch(t.JSONPatch); err != nil {
+			return fmt.Errorf("invalid json patch of %v: %v", t.Kind, err)
+		}
+	}
+	return nil
+}
+
+// transform applies the transformations of the kind to obj and decodes the result into out,
+// out should be a new object of the same type as obj, the name and namespace are not allowed to be changed
+func (v *VirtualK8S) transform(kind string, obj, out metav1.Object) error {
+	v.transformationsLock.RLock()
+	transformations := v.transformations
+	v.transformationsLock.RUnlock()
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	for _, t := range transformations {
+		if t.Kind != kind {
+			continue
+		}
+		if len(t.JSONPatch) != 0 {
+			patch, err := jsonpatch.DecodePatch(t.JSONPatch)
+			if err != nil {
+				return err
+			}
+			if data, err = patch.Apply(data); err != nil {
+				return fmt.Errorf("could not apply json patch to %v: %v", kind, err)
+			}
+		}
+		if len(t.StrategicMergePatch) != 0 {
+			if data, err = strategicpatch.StrategicMergePatch(data, t.StrategicMergePatch, out); err != nil {
+				return fmt.Errorf("could not apply strategic merge patch to %v: %v", kind, err)
+			}
+		}
+	}
+	if err = json.Unmarshal(data, out); err != nil {
+		return err
+	}
+	if out.GetName() != obj.GetName() || out.GetNamespace() != obj.GetNamespace() {
+		return fmt.Errorf("transformations changed %v %v/%v to %v/%v", kind, obj.GetNamespace(), obj.GetName(),
+			out.GetNamespace(), out.GetName())
+	}
+	return nil
+}
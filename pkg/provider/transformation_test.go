@@ -0,0 +1,83 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/config"
+)
+
+func TestTransform(t *testing.T) {
+	v := &VirtualK8S{clusterName: "cluster-a"}
+	v.SetTransformations(&config.ProviderConfig{Transformations: []config.Transformation{
+		{
+			Kind:                podKind,
+			StrategicMergePatch: []byte(`{"spec":{"nodeSelector":{"pool":"burst"}}}`),
+		},
+		{
+			Clusters:  []string{"cluster-b"},
+			Kind:      podKind,
+			JSONPatch: []byte(`[{"op":"add","path":"/spec/priorityClassName","value":"low"}]`),
+		},
+		{
+			Clusters:  []string{"cluster-a"},
+			Kind:      pvcKind,
+			JSONPatch: []byte(`[{"op":"add","path":"/spec/storageClassName","value":"cbs"}]`),
+		},
+	}})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeSelector: map[string]string{"zone": "a"}},
+	}
+	transformed := &corev1.Pod{}
+	if err := v.transform(podKind, pod, transformed); err != nil {
+		t.Fatal(err)
+	}
+	if transformed.Spec.NodeSelector["pool"] != "burst" || transformed.Spec.NodeSelector["zone"] != "a" ||
+		len(transformed.Spec.PriorityClassName) != 0 {
+		t.Fatalf("desire node selector merged and priority class of other cluster skipped, real %+v",
+			transformed.Spec)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}}
+	transformedPVC := &corev1.PersistentVolumeClaim{}
+	if err := v.transform(pvcKind, pvc, transformedPVC); err != nil {
+		t.Fatal(err)
+	}
+	if transformedPVC.Spec.StorageClassName == nil || *transformedPVC.Spec.StorageClassName != "cbs" {
+		t.Fatalf("desire storage class cbs, real %v", transformedPVC.Spec.StorageClassName)
+	}
+
+	// invalid transformations are skipped and the current ones are kept
+	v.SetTransformations(&config.ProviderConfig{Transformations: []config.Transformation{{Kind: "Service"}}})
+	if len(v.transformations) != 2 {
+		t.Fatalf("desire current transformations kept, real %+v", v.transformations)
+	}
+
+	v.SetTransformations(&config.ProviderConfig{Transformations: []config.Transformation{{
+		Kind:      podKind,
+		JSONPatch: []byte(`[{"op":"replace","path":"/metadata/name","value":"other"}]`),
+	}}})
+	if err := v.transform(podKind, pod, &corev1.Pod{}); err == nil {
+		t.Fatalf("desire renaming rejected")
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// getUpperUID returns the uid of the upper pod the lower pod is created for,
+// empty would be returned for pods created before the uid recorded
+func getUpperUID(pod *corev1.Pod) types.UID {
+	if pod.Annotations == nil {
+		return ""
+	}
+	return types.UID(pod.Annotations[util.UpperPodUID])
+}
+
+// setUpperUID records the uid of upper pod on the lower pod
+func setUpperUID(lower *corev1.Pod, uid types.UID) {
+	if len(uid) == 0 {
+		return
+	}
+	if lower.Annotations == nil {
+		lower.Annotations = map[string]string{}
+	}
+	lower.Annotations[util.UpperPodUID] = string(uid)
+}
+
+// recordUpperUID remembers the uid of the latest upper pod of the name
+func (v *VirtualK8S) recordUpperUID(pod *corev1.Pod) {
+	if len(pod.UID) == 0 {
+		return
+	}
+	v.upperUIDs.Store(pod.Namespace+"/"+pod.Name, pod.UID)
+	v.podMapping.record(pod.Namespace, pod.Name, pod.UID, "")
+}
+
+// forgetUpperUID forgets the uid of the upper pod if it is still the latest one of the name
+func (v *VirtualK8S) forgetUpperUID(pod *corev1.Pod) {
+	key := pod.Namespace + "/" + pod.Name
+	if uid, ok := v.upperUIDs.Load(key); ok && uid.(types.UID) == pod.UID {
+		v.upperUIDs.Delete(key)
+	}
+	v.podMapping.forget(pod.Namespace, pod.Name, pod.UID)
+}
+
+// isStale returns if the lower pod is created for another upper pod of the same name than the latest
+// one, e.g. the previous instance of a StatefulSet pod, or is not the lower pod mapped to its upper pod,
+// its status should never be reported upward
+func (v *VirtualK8S) isStale(lower *corev1.Pod) bool {
+	uid := getUpperUID(lower)
+	if len(uid) == 0 {
+		return false
+	}
+	namespace, _ := v.upperNamespace(lower)
+	latest, ok := v.upperUIDs.Load(namespace + "/" + lower.Name)
+	return ok && latest.(types.UID) != uid || v.mappedToAnother(lower)
+}
+
+// belongsTo returns if the lower pod is created for the upper pod
+func belongsTo(lower, upper *corev1.Pod) bool {
+	uid := getUpperUID(lower)
+	return len(uid) == 0 || len(upper.UID) == 0 || uid == upper.UID
+}
+
+// hideUpperUID removes the uid annotation from the pod reported upward
+func hideUpperUID(pod *corev1.Pod) {
+	if pod.Annotations != nil {
+		delete(pod.Annotations, util.UpperPodUID)
+	}
+}
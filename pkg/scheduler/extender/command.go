@@ -0,0 +1,185 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extender
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/offloadpolicy"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// Options defines the options of the extender server
+type Options struct {
+	// Address is the address the extender listens on
+	Address string
+	// Port is the port the extender listens on
+	Port int
+	// TLSCert is the path of the tls certificate, the extender serves http if not set
+	TLSCert string
+	// TLSKey is the path of the tls key
+	TLSKey string
+	// Kubeconfig is the kubeconfig of the upper cluster, the in-cluster config is used if not set
+	Kubeconfig string
+	// Plugins are the names of the plugins of tensile-kube enabled
+	Plugins []string
+	// PluginConfig is the path of the json file of plugin args, a list of name and args like the pluginConfig
+	// of the scheduler config
+	PluginConfig string
+	// Shadow runs the plugins against the pods of ShadowSchedulers and records the nodes they would be bound to,
+	// instead of serving the verbs
+	Shadow bool
+	// ShadowSchedulers are the schedulers whose pods are evaluated in shadow mode
+	ShadowSchedulers []string
+}
+
+// AddFlags registers the flags of options in the flag set
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	var plugins []string
+	for name := range scheduler.Registry() {
+		// the policies are opt-in, since pods are not scheduled until the CRD is installed
+		if name != offloadpolicy.Name {
+			plugins = append(plugins, name)
+		}
+	}
+	sort.Strings(plugins)
+	fs.StringVar(&o.Address, "address", "0.0.0.0", "The address the extender listens on.")
+	fs.IntVar(&o.Port, "port", 8888, "The port the extender listens on.")
+	fs.StringVar(&o.TLSCert, "tlscert", "", "Path to TLS certificate file, serve http if not set.")
+	fs.StringVar(&o.TLSKey, "tlskey", "", "Path to TLS key file.")
+	fs.StringVar(&o.Kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig file of the upper cluster.")
+	fs.StringSliceVar(&o.Plugins, "plugins", plugins, "Plugins of tensile-kube enabled, separated by comma, "+
+		"all but "+offloadpolicy.Name+" by default.")
+	fs.StringVar(&o.PluginConfig, "plugin-config", "",
+		"Path to the json file of plugin args, a list of name and args like the pluginConfig of the scheduler config.")
+	fs.BoolVar(&o.Shadow, "shadow", false, "Record the nodes pods of --shadow-schedulers would be bound to as "+
+		"events and metrics without binding, instead of serving the verbs.")
+	fs.StringSliceVar(&o.ShadowSchedulers, "shadow-schedulers", []string{v1.DefaultSchedulerName},
+		"Schedulers whose pods are evaluated in shadow mode, separated by comma.")
+}
+
+// Validate validates the options
+func (o *Options) Validate() error {
+	registry := scheduler.Registry()
+	for _, name := range o.Plugins {
+		if _, ok := registry[name]; !ok {
+			return fmt.Errorf("unknown plugin %v", name)
+		}
+	}
+	if (len(o.TLSCert) == 0) != (len(o.TLSKey) == 0) {
+		return fmt.Errorf("--tlscert and --tlskey should be set together")
+	}
+	return nil
+}
+
+// NewExtenderCommand returns the command running the scheduler extender, an alternative to the multi-cluster
+// scheduler for clusters keeping the default scheduler
+func NewExtenderCommand() *cobra.Command {
+	o := &Options{}
+	cmd := &cobra.Command{
+		Use:   "scheduler-extender",
+		Short: "Run the scheduler extender filtering and scoring virtual nodes by the plugins of tensile-kube",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return Run(o, util.SetupSignalHandler())
+		},
+	}
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	cmd.Flags().AddGoFlagSet(klogFlags)
+	o.AddFlags(cmd.Flags())
+	return cmd
+}
+
+// Run runs the extender server until stopCh closed
+func Run(o *Options, stopCh <-chan struct{}) error {
+	var pluginConfig []config.PluginConfig
+	if len(o.PluginConfig) != 0 {
+		data, err := ioutil.ReadFile(o.PluginConfig)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(data, &pluginConfig); err != nil {
+			return fmt.Errorf("could not decode plugin config %v: %v", o.PluginConfig, err)
+		}
+	}
+	client, err := util.NewClient(o.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	e, err := NewExtender(client, informerFactory, Plugins(o.Plugins), pluginConfig)
+	if err != nil {
+		return err
+	}
+	handler := e.Handler()
+	if o.Shadow {
+		shadow := NewShadow(client, informerFactory, e, o.ShadowSchedulers)
+		go shadow.Run(1, stopCh)
+		metrics.Register()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		handler = mux
+	}
+	informerFactory.Start(stopCh)
+	for informer, synced := range informerFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("wait for cache sync of %v failed", informer)
+		}
+	}
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(o.Address, strconv.Itoa(o.Port)),
+		Handler: handler,
+	}
+	go func() {
+		klog.Infof("Serving scheduler extender at %v", server.Addr)
+		var err error
+		if len(o.TLSCert) != 0 {
+			err = server.ListenAndServeTLS(o.TLSCert, o.TLSKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			klog.Fatalf("Extender server exited: %v", err)
+		}
+	}()
+	<-stopCh
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
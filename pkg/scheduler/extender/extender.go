@@ -0,0 +1,355 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterfit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterquota"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterspread"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/csidriver"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/networkzone"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/nodetaints"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/offloadpolicy"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/overcommit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/schedulinggates"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/storagecapacity"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/storageclass"
+)
+
+const (
+	// FilterPath is the path of the filter verb
+	FilterPath = "/filter"
+	// PrioritizePath is the path of the prioritize verb
+	PrioritizePath = "/prioritize"
+	// BindPath is the path of the bind verb
+	BindPath = "/bind"
+)
+
+// Extender serves the filter, prioritize and bind verbs of kube-scheduler extenders by the plugins of tensile-kube,
+// so that the default scheduler takes the lower clusters into account without being replaced by the
+// multi-cluster scheduler. The plugins run in a scheduling framework of their own, which reads the nodes and pods
+// of the upper cluster from a snapshot rebuilt for every request.
+type Extender struct {
+	client     kubernetes.Interface
+	framework  framework.Framework
+	nodeLister corelisters.NodeLister
+	podLister  corelisters.PodLister
+	snapshot   *snapshot
+	// totalWeight is the sum of weights of the score plugins, scores are scaled by it to the extender priority
+	totalWeight int64
+	// lock serializes the requests sharing the snapshot
+	lock sync.Mutex
+}
+
+// Plugins returns the plugins of tensile-kube in names enabled at the extension points they implement, together
+// with the queue sort and bind plugins the framework requires, which never run in the extender
+func Plugins(names []string) *config.Plugins {
+	enabled := sets.NewString(names...)
+	pluginSet := func(candidates ...string) *config.PluginSet {
+		set := &config.PluginSet{}
+		for _, name := range candidates {
+			if enabled.Has(name) {
+				set.Enabled = append(set.Enabled, config.Plugin{Name: name, Weight: 1})
+			}
+		}
+		return set
+	}
+	return &config.Plugins{
+		QueueSort: &config.PluginSet{Enabled: []config.Plugin{{Name: queuesort.Name}}},
+		PreFilter: pluginSet(clusterfit.Name, csidriver.Name, storagecapacity.Name, storageclass.Name,
+			schedulinggates.Name, offloadpolicy.Name, clusterspread.Name, clusterquota.Name),
+		Filter: pluginSet(clusterfit.Name, csidriver.Name, storagecapacity.Name, storageclass.Name,
+			offloadpolicy.Name, clusterspread.Name, clusterquota.Name, nodetaints.Name),
+		PreScore: pluginSet(networkzone.Name, clusterspread.Name),
+		Score:    pluginSet(clusterfit.Name, networkzone.Name, overcommit.Name, clusterspread.Name),
+		Bind:     &config.PluginSet{Enabled: []config.Plugin{{Name: defaultbinder.Name}}},
+	}
+}
+
+// NewExtender returns the extender running plugins, whose args are set by pluginConfig. informerFactory should
+// be started after the extender is created, since plugins get their listers from it when initialized.
+func NewExtender(client kubernetes.Interface, informerFactory informers.SharedInformerFactory,
+	plugins *config.Plugins, pluginConfig []config.PluginConfig) (*Extender, error) {
+	registry := scheduler.Registry()
+	if err := registry.Merge(framework.Registry{
+		queuesort.Name:     queuesort.New,
+		defaultbinder.Name: defaultbinder.New,
+	}); err != nil {
+		return nil, err
+	}
+	s := newSnapshot()
+	fw, err := framework.NewFramework(registry, plugins, pluginConfig, framework.WithClientSet(client),
+		framework.WithInformerFactory(informerFactory), framework.WithSnapshotSharedLister(s))
+	if err != nil {
+		return nil, fmt.Errorf("could not build framework: %v", err)
+	}
+	e := &Extender{
+		client:     client,
+		framework:  fw,
+		nodeLister: informerFactory.Core().V1().Nodes().Lister(),
+		podLister:  informerFactory.Core().V1().Pods().Lister(),
+		snapshot:   s,
+	}
+	if plugins.Score != nil {
+		for _, plugin := range plugins.Score.Enabled {
+			weight := int64(plugin.Weight)
+			if weight == 0 {
+				weight = 1
+			}
+			e.totalWeight += weight
+		}
+	}
+	return e, nil
+}
+
+// Filter returns the nodes passing the filter plugins, the others are returned as failed nodes with the reasons
+func (e *Extender) Filter(args *extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.filter(args)
+}
+
+// filter runs the filter plugins, the caller should hold the lock
+func (e *Extender) filter(args *extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	nodes, err := e.prepare(args)
+	if err != nil {
+		return &extenderv1.ExtenderFilterResult{Error: err.Error()}
+	}
+	ctx := context.Background()
+	state := framework.NewCycleState()
+	failed := extenderv1.FailedNodesMap{}
+	var fit []*v1.Node
+	status := e.framework.RunPreFilterPlugins(ctx, state, args.Pod)
+	switch {
+	case status.IsUnschedulable():
+		for _, node := range nodes {
+			failed[node.Name] = status.Message()
+		}
+	case !status.IsSuccess():
+		return &extenderv1.ExtenderFilterResult{Error: status.Message()}
+	default:
+		for _, node := range nodes {
+			nodeInfo, err := e.snapshot.Get(node.Name)
+			if err != nil {
+				failed[node.Name] = err.Error()
+				continue
+			}
+			if status := e.framework.RunFilterPlugins(ctx, state, args.Pod, nodeInfo).Merge(); !status.IsSuccess() {
+				failed[node.Name] = status.Message()
+				continue
+			}
+			fit = append(fit, node)
+		}
+	}
+	klog.V(4).Infof("Pod %v/%v fits %v nodes, failed on %v", args.Pod.Namespace, args.Pod.Name, len(fit),
+		len(failed))
+
+	result := &extenderv1.ExtenderFilterResult{FailedNodes: failed}
+	if args.NodeNames != nil {
+		names := make([]string, 0, len(fit))
+		for _, node := range fit {
+			names = append(names, node.Name)
+		}
+		result.NodeNames = &names
+		return result
+	}
+	result.Nodes = &v1.NodeList{Items: make([]v1.Node, 0, len(fit))}
+	for _, node := range fit {
+		result.Nodes.Items = append(result.Nodes.Items, *node)
+	}
+	return result
+}
+
+// Prioritize returns the scores of the nodes by the score plugins, scaled to the range of extender priorities
+func (e *Extender) Prioritize(args *extenderv1.ExtenderArgs) (extenderv1.HostPriorityList, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	nodes, err := e.prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	priorities, err := e.score(args.Pod, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if e.totalWeight == 0 {
+		return priorities, nil
+	}
+	for i := range priorities {
+		priorities[i].Score = priorities[i].Score * extenderv1.MaxExtenderPriority /
+			(framework.MaxNodeScore * e.totalWeight)
+	}
+	return priorities, nil
+}
+
+// score returns the sum of weighted scores of the nodes by the score plugins, the caller should hold the lock
+func (e *Extender) score(pod *v1.Pod, nodes []*v1.Node) (extenderv1.HostPriorityList, error) {
+	priorities := make(extenderv1.HostPriorityList, len(nodes))
+	for i, node := range nodes {
+		priorities[i].Host = node.Name
+	}
+	if len(nodes) == 0 || e.totalWeight == 0 {
+		return priorities, nil
+	}
+	ctx := context.Background()
+	state := framework.NewCycleState()
+	// the state of prefilter plugins are read by score plugins if present, a pod rejected scores nothing
+	if status := e.framework.RunPreFilterPlugins(ctx, state, pod); !status.IsSuccess() {
+		return priorities, nil
+	}
+	if status := e.framework.RunPreScorePlugins(ctx, state, pod, nodes); !status.IsSuccess() {
+		return nil, status.AsError()
+	}
+	scores, status := e.framework.RunScorePlugins(ctx, state, pod, nodes)
+	if !status.IsSuccess() {
+		return nil, status.AsError()
+	}
+	for _, nodeScores := range scores {
+		for i := range nodeScores {
+			priorities[i].Score += nodeScores[i].Score
+		}
+	}
+	return priorities, nil
+}
+
+// Bind binds the pod to the node
+func (e *Extender) Bind(args *extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult {
+	binding := &v1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: args.PodNamespace, Name: args.PodName, UID: args.PodUID},
+		Target:     v1.ObjectReference{Kind: "Node", Name: args.Node},
+	}
+	if err := e.client.CoreV1().Pods(args.PodNamespace).Bind(context.Background(), binding,
+		metav1.CreateOptions{}); err != nil {
+		return &extenderv1.ExtenderBindingResult{Error: err.Error()}
+	}
+	klog.V(4).Infof("Bound pod %v/%v to node %v", args.PodNamespace, args.PodName, args.Node)
+	return &extenderv1.ExtenderBindingResult{}
+}
+
+// prepare rebuilds the snapshot and returns the nodes of args, which are either the nodes or the names of nodes
+// if kube-scheduler is configured with nodeCacheCapable
+func (e *Extender) prepare(args *extenderv1.ExtenderArgs) ([]*v1.Node, error) {
+	if args.Pod == nil {
+		return nil, fmt.Errorf("pod is missing in args")
+	}
+	nodes, err := e.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	pods, err := e.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	e.snapshot.update(nodes, pods)
+
+	if args.Nodes != nil {
+		candidates := make([]*v1.Node, 0, len(args.Nodes.Items))
+		for i := range args.Nodes.Items {
+			candidates = append(candidates, &args.Nodes.Items[i])
+		}
+		return candidates, nil
+	}
+	if args.NodeNames == nil {
+		return nil, fmt.Errorf("neither nodes nor node names are in args")
+	}
+	candidates := make([]*v1.Node, 0, len(*args.NodeNames))
+	for _, name := range *args.NodeNames {
+		nodeInfo, err := e.snapshot.Get(name)
+		if err != nil {
+			// the node not cached yet is failed by filter plugins, and scores nothing
+			candidates = append(candidates, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+			continue
+		}
+		candidates = append(candidates, nodeInfo.Node())
+	}
+	return candidates, nil
+}
+
+// Handler returns the handler serving the verbs in json at FilterPath, PrioritizePath and BindPath
+func (e *Extender) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(FilterPath, func(w http.ResponseWriter, r *http.Request) {
+		args := &extenderv1.ExtenderArgs{}
+		if !decode(w, r, args) {
+			return
+		}
+		encode(w, e.Filter(args))
+	})
+	mux.HandleFunc(PrioritizePath, func(w http.ResponseWriter, r *http.Request) {
+		args := &extenderv1.ExtenderArgs{}
+		if !decode(w, r, args) {
+			return
+		}
+		priorities, err := e.Prioritize(args)
+		if err != nil {
+			klog.Errorf("Prioritize pod failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encode(w, priorities)
+	})
+	mux.HandleFunc(BindPath, func(w http.ResponseWriter, r *http.Request) {
+		args := &extenderv1.ExtenderBindingArgs{}
+		if !decode(w, r, args) {
+			return
+		}
+		encode(w, e.Bind(args))
+	})
+	return mux
+}
+
+// decode decodes the body of the request into args, it responds the error and returns false if failed
+func decode(w http.ResponseWriter, r *http.Request, args interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(args); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode args: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// encode responds the result in json
+func encode(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.Errorf("Could not encode result: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extender
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterfit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestExtender(t *testing.T) {
+	virtualNode := func(name, summary string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: map[string]string{util.FitSummary: summary},
+		}}
+	}
+	small := virtualNode("small", `[{"cpu":2000,"memory":4294967296,"pods":10}]`)
+	large := virtualNode("large", `[{"cpu":8000,"memory":4294967296,"pods":10}]`)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse("3"),
+			}},
+		}}},
+	}
+	client := fake.NewSimpleClientset(small, large, pod)
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	e, err := NewExtender(client, informerFactory, Plugins([]string{clusterfit.Name}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	names := []string{"small", "large"}
+	result := e.Filter(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: &names})
+	if len(result.Error) != 0 {
+		t.Fatal(result.Error)
+	}
+	if len(*result.NodeNames) != 1 || (*result.NodeNames)[0] != "large" {
+		t.Fatalf("Desire large fits, get %v", *result.NodeNames)
+	}
+	if _, ok := result.FailedNodes["small"]; !ok {
+		t.Fatalf("Desire small failed, get %v", result.FailedNodes)
+	}
+
+	priorities, err := e.Prioritize(&extenderv1.ExtenderArgs{Pod: pod,
+		Nodes: &v1.NodeList{Items: []v1.Node{*small, *large}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priorities[1].Host != "large" || priorities[1].Score <= priorities[0].Score ||
+		priorities[1].Score > extenderv1.MaxExtenderPriority {
+		t.Fatalf("Desire large scores higher within max priority, get %v", priorities)
+	}
+
+	binding := e.Bind(&extenderv1.ExtenderBindingArgs{PodName: "test", PodNamespace: "default", PodUID: "uid",
+		Node: "large"})
+	if len(binding.Error) != 0 {
+		t.Fatal(binding.Error)
+	}
+	bound := false
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "binding" {
+			bound = true
+		}
+	}
+	if !bound {
+		t.Fatal("Desire pod bound")
+	}
+}
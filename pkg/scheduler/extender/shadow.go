@@ -0,0 +1,270 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extender
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/metrics"
+)
+
+const (
+	// ReasonWouldSchedule is the reason of events of pods the shadow would bind to a node
+	ReasonWouldSchedule = "WouldSchedule"
+	// ReasonWouldNotSchedule is the reason of events of pods no node fits in shadow mode
+	ReasonWouldNotSchedule = "WouldNotSchedule"
+)
+
+// Shadow runs the plugins against the pods left to other schedulers, and records the nodes it would bind them to
+// as events and metrics without binding, so that the behavior can be validated before cutting over. The node
+// decided is compared to the node the pod is bound to at last.
+type Shadow struct {
+	extender   *Extender
+	recorder   record.EventRecorder
+	queue      workqueue.RateLimitingInterface
+	schedulers sets.String
+
+	podLister       corelisters.PodLister
+	podListerSynced cache.InformerSynced
+
+	// decisions are the nodes decided by uid of pods not bound yet, empty if no node fits
+	decisions map[types.UID]string
+	lock      sync.Mutex
+}
+
+// NewShadow returns the shadow evaluating the pending pods of schedulers by e. informerFactory should be started
+// after the shadow is created.
+func NewShadow(client kubernetes.Interface, informerFactory informers.SharedInformerFactory, e *Extender,
+	schedulers []string) *Shadow {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	podInformer := informerFactory.Core().V1().Pods()
+	s := &Shadow{
+		extender: e,
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "tensile-scheduler-shadow"}),
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(time.Second, 30*time.Second), "scheduler shadow"),
+		schedulers:      sets.NewString(schedulers...),
+		podLister:       podInformer.Lister(),
+		podListerSynced: podInformer.Informer().HasSynced,
+		decisions:       map[types.UID]string{},
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: s.podUpdated,
+		UpdateFunc: func(old, new interface{}) {
+			s.podUpdated(new)
+		},
+		DeleteFunc: s.podDeleted,
+	})
+	return s
+}
+
+// Run evaluates the pending pods by workers until stopCh closed
+func (s *Shadow) Run(workers int, stopCh <-chan struct{}) {
+	defer s.queue.ShutDown()
+	klog.Infof("Starting scheduler shadow")
+	defer klog.Infof("Shutting scheduler shadow")
+	if !cache.WaitForCacheSync(stopCh, s.podListerSynced) {
+		klog.Errorf("Cannot sync caches of pods")
+		return
+	}
+	for i := 0; i < workers; i++ {
+		go wait.Until(s.syncPod, 0, stopCh)
+	}
+	<-stopCh
+}
+
+// podUpdated enqueues the pod pending if not decided yet, or compares the decision to the node it is bound to
+func (s *Shadow) podUpdated(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	if len(pod.Spec.NodeName) != 0 {
+		s.compare(pod)
+		return
+	}
+	if !s.pending(pod) || s.decided(pod.UID) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	s.queue.Add(key)
+}
+
+// podDeleted drops the decision of the pod deleted before bound
+func (s *Shadow) podDeleted(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if pod, ok = tombstone.Obj.(*v1.Pod); !ok {
+			return
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.decisions, pod.UID)
+}
+
+// syncPod evaluates one pod off the queue
+func (s *Shadow) syncPod() {
+	keyObj, quit := s.queue.Get()
+	if quit {
+		return
+	}
+	defer s.queue.Done(keyObj)
+	key := keyObj.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		s.queue.Forget(key)
+		return
+	}
+	pod, err := s.podLister.Pods(namespace).Get(name)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			klog.Error(err)
+			s.queue.AddRateLimited(key)
+			return
+		}
+		s.queue.Forget(key)
+		return
+	}
+	if !s.pending(pod) || s.decided(pod.UID) {
+		s.queue.Forget(key)
+		return
+	}
+	node, err := s.extender.Decide(pod)
+	if err != nil {
+		klog.Errorf("Evaluate pod %v in shadow mode failed: %v", key, err)
+		metrics.ShadowDecisions.WithLabelValues(metrics.DecisionError).Inc()
+		s.queue.AddRateLimited(key)
+		return
+	}
+	s.queue.Forget(key)
+	s.lock.Lock()
+	s.decisions[pod.UID] = node
+	s.lock.Unlock()
+	if len(node) == 0 {
+		klog.V(4).Infof("Pod %v would not be scheduled", key)
+		metrics.ShadowDecisions.WithLabelValues(metrics.DecisionUnschedulable).Inc()
+		s.recorder.Event(pod, v1.EventTypeNormal, ReasonWouldNotSchedule, "No node fits in shadow mode")
+		return
+	}
+	klog.V(4).Infof("Pod %v would be bound to node %v", key, node)
+	metrics.ShadowDecisions.WithLabelValues(metrics.DecisionScheduled).Inc()
+	s.recorder.Eventf(pod, v1.EventTypeNormal, ReasonWouldSchedule, "Would bind to node %v in shadow mode", node)
+}
+
+// compare records whether the node the pod is bound to matches the decision, the decision is dropped then
+func (s *Shadow) compare(pod *v1.Pod) {
+	s.lock.Lock()
+	node, ok := s.decisions[pod.UID]
+	delete(s.decisions, pod.UID)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	match := node == pod.Spec.NodeName
+	if !match {
+		klog.V(3).Infof("Pod %v/%v is bound to node %v, while %q is decided in shadow mode", pod.Namespace,
+			pod.Name, pod.Spec.NodeName, node)
+	}
+	metrics.ShadowBindings.WithLabelValues(strconv.FormatBool(match)).Inc()
+}
+
+// pending returns whether the pod waits for a scheduler shadowed
+func (s *Shadow) pending(pod *v1.Pod) bool {
+	return len(pod.Spec.NodeName) == 0 && pod.DeletionTimestamp == nil && s.schedulers.Has(pod.Spec.SchedulerName)
+}
+
+// decided returns whether the pod has been evaluated
+func (s *Shadow) decided(uid types.UID) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.decisions[uid]
+	return ok
+}
+
+// Decide runs the filter and score plugins against all nodes, and returns the node of the highest score the pod
+// would be bound to, empty if no node fits. Ties are broken by the names of nodes instead of randomly.
+func (e *Extender) Decide(pod *v1.Pod) (string, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	nodes, err := e.nodeLister.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	result := e.filter(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: &names})
+	if len(result.Error) != 0 {
+		return "", errors.New(result.Error)
+	}
+	if len(*result.NodeNames) == 0 {
+		return "", nil
+	}
+	fit := make([]*v1.Node, 0, len(*result.NodeNames))
+	for _, name := range *result.NodeNames {
+		nodeInfo, err := e.snapshot.Get(name)
+		if err != nil {
+			return "", err
+		}
+		fit = append(fit, nodeInfo.Node())
+	}
+	priorities, err := e.score(pod, fit)
+	if err != nil {
+		return "", err
+	}
+	best := 0
+	for i := range priorities {
+		if priorities[i].Score > priorities[best].Score {
+			best = i
+		}
+	}
+	return priorities[best].Host, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extender
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterfit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestShadow(t *testing.T) {
+	virtualNode := func(name, summary string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: map[string]string{util.FitSummary: summary},
+		}}
+	}
+	newPod := func(name, cpu string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+			Spec: v1.PodSpec{
+				SchedulerName: v1.DefaultSchedulerName,
+				Containers: []v1.Container{{
+					Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+						v1.ResourceCPU: resource.MustParse(cpu),
+					}},
+				}},
+			},
+		}
+	}
+	small := virtualNode("small", `[{"cpu":2000,"memory":4294967296,"pods":10}]`)
+	large := virtualNode("large", `[{"cpu":8000,"memory":4294967296,"pods":10}]`)
+	fit := newPod("fit", "3")
+	unfit := newPod("unfit", "16")
+	client := fake.NewSimpleClientset(small, large, fit, unfit)
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	e, err := NewExtender(client, informerFactory, Plugins([]string{clusterfit.Name}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewShadow(client, informerFactory, e, []string{v1.DefaultSchedulerName})
+	recorder := record.NewFakeRecorder(10)
+	s.recorder = recorder
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	s.queue.Add("default/fit")
+	s.queue.Add("default/unfit")
+	s.syncPod()
+	s.syncPod()
+	events := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		events[<-recorder.Events] = true
+	}
+	if !events["Normal "+ReasonWouldSchedule+" Would bind to node large in shadow mode"] {
+		t.Fatalf("Desire pod fit would be bound to large, get %v", events)
+	}
+	found := false
+	for event := range events {
+		found = found || strings.HasPrefix(event, "Normal "+ReasonWouldNotSchedule)
+	}
+	if !found {
+		t.Fatalf("Desire pod unfit would not be scheduled, get %v", events)
+	}
+	for _, pod := range []*v1.Pod{fit, unfit} {
+		if node, ok := s.decisions[pod.UID]; !ok || (pod == fit) != (node == "large") {
+			t.Fatalf("Desire decision of %v recorded, get %q", pod.Name, node)
+		}
+	}
+
+	bound := fit.DeepCopy()
+	bound.Spec.NodeName = "small"
+	s.podUpdated(bound)
+	if s.decided(fit.UID) {
+		t.Fatal("Desire decision dropped once the pod bound")
+	}
+	if s.pending(bound) {
+		t.Fatal("Desire pod bound not pending")
+	}
+}
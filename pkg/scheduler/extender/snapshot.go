@@ -0,0 +1,119 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extender
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+	schedulerlisters "k8s.io/kubernetes/pkg/scheduler/listers"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// snapshot is the shared lister of nodes and the pods on them the plugins read, it is rebuilt from the informers
+// before each request is served, since the extender has no scheduler cache to take a snapshot of
+type snapshot struct {
+	nodeInfoMap  map[string]*schedulernodeinfo.NodeInfo
+	nodeInfoList []*schedulernodeinfo.NodeInfo
+}
+
+var _ schedulerlisters.SharedLister = &snapshot{}
+
+func newSnapshot() *snapshot {
+	return &snapshot{nodeInfoMap: map[string]*schedulernodeinfo.NodeInfo{}}
+}
+
+// update rebuilds the snapshot with the nodes and the pods assigned, terminated pods take no resources and
+// are skipped like the scheduler does
+func (s *snapshot) update(nodes []*v1.Node, pods []*v1.Pod) {
+	podsOfNode := make(map[string][]*v1.Pod, len(nodes))
+	for _, pod := range pods {
+		if len(pod.Spec.NodeName) == 0 || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		podsOfNode[pod.Spec.NodeName] = append(podsOfNode[pod.Spec.NodeName], pod)
+	}
+	s.nodeInfoMap = make(map[string]*schedulernodeinfo.NodeInfo, len(nodes))
+	s.nodeInfoList = make([]*schedulernodeinfo.NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		nodeInfo := schedulernodeinfo.NewNodeInfo(podsOfNode[node.Name]...)
+		if err := nodeInfo.SetNode(node); err != nil {
+			klog.Warningf("Skip node %v in snapshot: %v", node.Name, err)
+			continue
+		}
+		s.nodeInfoMap[node.Name] = nodeInfo
+		s.nodeInfoList = append(s.nodeInfoList, nodeInfo)
+	}
+}
+
+// Pods returns the lister of pods on the nodes in snapshot
+func (s *snapshot) Pods() schedulerlisters.PodLister {
+	return podLister(s.nodeInfoList)
+}
+
+// NodeInfos returns the lister of nodes in snapshot
+func (s *snapshot) NodeInfos() schedulerlisters.NodeInfoLister {
+	return s
+}
+
+// List returns the nodes in snapshot
+func (s *snapshot) List() ([]*schedulernodeinfo.NodeInfo, error) {
+	return s.nodeInfoList, nil
+}
+
+// HavePodsWithAffinityList returns the nodes with pods having affinity terms
+func (s *snapshot) HavePodsWithAffinityList() ([]*schedulernodeinfo.NodeInfo, error) {
+	var nodeInfos []*schedulernodeinfo.NodeInfo
+	for _, nodeInfo := range s.nodeInfoList {
+		if len(nodeInfo.PodsWithAffinity()) > 0 {
+			nodeInfos = append(nodeInfos, nodeInfo)
+		}
+	}
+	return nodeInfos, nil
+}
+
+// Get returns the node of the name in snapshot
+func (s *snapshot) Get(nodeName string) (*schedulernodeinfo.NodeInfo, error) {
+	nodeInfo, ok := s.nodeInfoMap[nodeName]
+	if !ok || nodeInfo.Node() == nil {
+		return nil, fmt.Errorf("nodeinfo not found for node name %q", nodeName)
+	}
+	return nodeInfo, nil
+}
+
+// podLister lists the pods on the nodes
+type podLister []*schedulernodeinfo.NodeInfo
+
+// List returns the pods matching the selector
+func (p podLister) List(selector labels.Selector) ([]*v1.Pod, error) {
+	return p.FilteredList(func(*v1.Pod) bool { return true }, selector)
+}
+
+// FilteredList returns the pods matching the selector and passing the filter
+func (p podLister) FilteredList(filter schedulerlisters.PodFilter, selector labels.Selector) ([]*v1.Pod, error) {
+	var pods []*v1.Pod
+	for _, nodeInfo := range p {
+		for _, pod := range nodeInfo.Pods() {
+			if filter(pod) && selector.Matches(labels.Set(pod.Labels)) {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	return pods, nil
+}
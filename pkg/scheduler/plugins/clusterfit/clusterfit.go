@@ -0,0 +1,236 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterfit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "ClusterFit"
+
+	preFilterStateKey = "PreFilter" + Name
+)
+
+// ClusterFit is a filter plugin that rejects the virtual nodes whose clusters have no node fitting
+// the pod, or are marked unfit for the pods of the same controller by the virtual node. It evaluates
+// the fit summary published by the virtual node instead of watching the lower clusters, the parsed
+// summaries are cached until the annotation changes, so the filters of many virtual nodes can be
+// evaluated in parallel by the framework cheaply. As a score plugin, it penalizes
+// fragmented clusters, whose aggregate capacity may be large while their nodes can barely host the pod.
+type ClusterFit struct {
+	handle framework.FrameworkHandle
+	// summaries caches the parsed fit summary of each virtual node
+	summaries sync.Map
+}
+
+// cachedSummary is the fit summary parsed from the annotation
+type cachedSummary struct {
+	annotation string
+	summary    common.FitSummary
+}
+
+// preFilterState is computed at PreFilter and used at Filter.
+type preFilterState struct {
+	request common.NodeFree
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+var _ framework.PreFilterPlugin = &ClusterFit{}
+var _ framework.FilterPlugin = &ClusterFit{}
+var _ framework.ScorePlugin = &ClusterFit{}
+
+// unknownScore is the score of nodes without fit summary, they are given the middle score after normalized
+const unknownScore = -1
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	return &ClusterFit{handle: handle}, nil
+}
+
+// Name returns name of the plugin.
+func (c *ClusterFit) Name() string {
+	return Name
+}
+
+// PreFilter computes the request of the pod once for all of the nodes.
+func (c *ClusterFit) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	state.Write(preFilterStateKey, &preFilterState{request: PodRequest(pod)})
+	return nil
+}
+
+// PodRequest returns the request of the pod to fit the nodes in summary
+func PodRequest(pod *v1.Pod) common.NodeFree {
+	req := util.GetRequestFromPod(pod)
+	return common.NodeFree{MilliCPU: req.CPU.MilliValue(), Memory: req.Memory.Value(), Pods: 1,
+		Extended: req.Extended()}
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (c *ClusterFit) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point.
+func (c *ClusterFit) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	if until, ok := unfitUntil(node, pod); ok && time.Now().Before(until) {
+		return framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("pods like it were unschedulable in cluster of %v, unfit until %v", node.Name,
+				until.Format(time.RFC3339)))
+	}
+	summary, err := c.getSummary(node)
+	if err != nil {
+		klog.Warningf("Invalid fit summary of node %v: %v", node.Name, err)
+		return nil
+	}
+	if summary == nil {
+		return nil
+	}
+	s, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	request := s.(*preFilterState).request
+	if !summary.Fits(request) {
+		return framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("no node in cluster of %v fits cpu %vm, memory %v, extended %v", node.Name,
+				request.MilliCPU, request.Memory, request.Extended))
+	}
+	return nil
+}
+
+// Score invoked at the score extension point, the score is the most copies of the pod a single node of the
+// cluster could host, which is normalized by NormalizeScore. Clusters with many small nodes left get lower
+// scores than the ones with roomy nodes, though their aggregate capacity may be the same.
+func (c *ClusterFit) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeName string) (int64, *framework.Status) {
+	nodeInfo, err := c.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v",
+			nodeName, err))
+	}
+	return c.score(state, pod, nodeInfo.Node()), nil
+}
+
+// score returns the most copies of the pod a single node in the cluster of node could host
+func (c *ClusterFit) score(state *framework.CycleState, pod *v1.Pod, node *v1.Node) int64 {
+	if node == nil || !util.IsVirtualNode(node) {
+		return unknownScore
+	}
+	summary, err := c.getSummary(node)
+	if err != nil {
+		klog.Warningf("Invalid fit summary of node %v: %v", node.Name, err)
+		return unknownScore
+	}
+	if summary == nil {
+		return unknownScore
+	}
+	// the state is missing if the filter is not enabled
+	request := PodRequest(pod)
+	if s, err := state.Read(preFilterStateKey); err == nil {
+		request = s.(*preFilterState).request
+	}
+	return summary.MaxCopies(request)
+}
+
+// ScoreExtensions of the Score plugin.
+func (c *ClusterFit) ScoreExtensions() framework.ScoreExtensions {
+	return c
+}
+
+// NormalizeScore scales the copies to [0, MaxNodeScore] by the largest one, nodes without fit summary get the
+// middle score, so that they are neither preferred nor avoided.
+func (c *ClusterFit) NormalizeScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	scores framework.NodeScoreList) *framework.Status {
+	var max int64
+	for _, score := range scores {
+		if score.Score > max {
+			max = score.Score
+		}
+	}
+	for i := range scores {
+		switch {
+		case scores[i].Score == unknownScore:
+			scores[i].Score = framework.MaxNodeScore / 2
+		case max == 0:
+			scores[i].Score = 0
+		default:
+			scores[i].Score = scores[i].Score * framework.MaxNodeScore / max
+		}
+	}
+	return nil
+}
+
+// unfitUntil returns until when the cluster of the virtual node is unfit for the pods like the pod, which were
+// unschedulable in the cluster and rescheduled by the virtual node
+func unfitUntil(node *v1.Node, pod *v1.Pod) (time.Time, bool) {
+	annotation, ok := node.Annotations[util.UnfitPods]
+	if !ok {
+		return time.Time{}, false
+	}
+	unfit := map[string]time.Time{}
+	if err := json.Unmarshal([]byte(annotation), &unfit); err != nil {
+		klog.Warningf("Invalid unfit pods of node %v: %v", node.Name, err)
+		return time.Time{}, false
+	}
+	until, ok := unfit[util.SchedulingKey(pod)]
+	return until, ok
+}
+
+// getSummary returns the fit summary of the virtual node, nil would be returned if not published
+func (c *ClusterFit) getSummary(node *v1.Node) (common.FitSummary, error) {
+	annotation, ok := node.Annotations[util.FitSummary]
+	if !ok {
+		c.summaries.Delete(node.Name)
+		return nil, nil
+	}
+	if cached, ok := c.summaries.Load(node.Name); ok && cached.(*cachedSummary).annotation == annotation {
+		return cached.(*cachedSummary).summary, nil
+	}
+	summary := common.FitSummary{}
+	if err := json.Unmarshal([]byte(annotation), &summary); err != nil {
+		return nil, err
+	}
+	c.summaries.Store(node.Name, &cachedSummary{annotation: annotation, summary: summary})
+	return summary, nil
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterfit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	summary := `[{"cpu":2000,"memory":4294967296,"pods":10}]`
+	gpuSummary := `[{"cpu":2000,"memory":4294967296,"pods":10,"extended":{"nvidia.com/gpu":2}}]`
+	virtualNode := func(annotations map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "vk",
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: annotations,
+		}}
+	}
+	pod := func(cpu string) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse(cpu),
+			}},
+		}}}}
+	}
+	gpuPod := pod("1")
+	gpuPod.Spec.Containers[0].Resources.Requests["nvidia.com/gpu"] = resource.MustParse("1")
+	unfitPod := pod("1")
+	unfitPod.UID = "unfit"
+	unfit := func(until time.Time) string {
+		data, _ := json.Marshal(map[string]time.Time{"unfit": until})
+		return string(data)
+	}
+	cases := []struct {
+		name string
+		node *v1.Node
+		pod  *v1.Pod
+		code framework.Code
+	}{
+		{
+			name: "fits",
+			node: virtualNode(map[string]string{util.FitSummary: summary}),
+			pod:  pod("1"),
+			code: framework.Success,
+		},
+		{
+			name: "not fits",
+			node: virtualNode(map[string]string{util.FitSummary: summary}),
+			pod:  pod("3"),
+			code: framework.Unschedulable,
+		},
+		{
+			name: "extended resources fit",
+			node: virtualNode(map[string]string{util.FitSummary: gpuSummary}),
+			pod:  gpuPod,
+			code: framework.Success,
+		},
+		{
+			name: "no extended resources",
+			node: virtualNode(map[string]string{util.FitSummary: summary}),
+			pod:  gpuPod,
+			code: framework.Unschedulable,
+		},
+		{
+			name: "unfit",
+			node: virtualNode(map[string]string{util.FitSummary: summary,
+				util.UnfitPods: unfit(time.Now().Add(time.Hour))}),
+			pod:  unfitPod,
+			code: framework.Unschedulable,
+		},
+		{
+			name: "unfit expired",
+			node: virtualNode(map[string]string{util.FitSummary: summary,
+				util.UnfitPods: unfit(time.Now().Add(-time.Hour))}),
+			pod:  unfitPod,
+			code: framework.Success,
+		},
+		{
+			name: "no summary",
+			node: virtualNode(nil),
+			pod:  pod("3"),
+			code: framework.Success,
+		},
+		{
+			name: "not virtual node",
+			node: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node",
+				Annotations: map[string]string{util.FitSummary: summary}}},
+			pod:  pod("3"),
+			code: framework.Success,
+		},
+	}
+	plugin := &ClusterFit{}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			if status := plugin.PreFilter(context.TODO(), state, c.pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter failed: %v", status)
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			if status := plugin.Filter(context.TODO(), state, c.pod, nodeInfo); status.Code() != c.code {
+				t.Errorf("Desired %v, get %v", c.code, status)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	virtualNode := func(name, summary string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: map[string]string{util.FitSummary: summary},
+		}}
+	}
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{
+		Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceCPU: resource.MustParse("1"),
+		}},
+	}}}}
+	plugin := &ClusterFit{}
+	state := framework.NewCycleState()
+	nodes := []*v1.Node{
+		// roomy nodes
+		virtualNode("roomy", `[{"cpu":8000,"memory":4294967296,"pods":10}]`),
+		// the same aggregate capacity in fragments
+		virtualNode("fragmented", `[{"cpu":2000,"memory":4294967296,"pods":10}]`),
+		{ObjectMeta: metav1.ObjectMeta{Name: "real"}},
+	}
+	scores := framework.NodeScoreList{}
+	for _, node := range nodes {
+		scores = append(scores, framework.NodeScore{Name: node.Name, Score: plugin.score(state, pod, node)})
+	}
+	if status := plugin.NormalizeScore(context.TODO(), state, pod, scores); !status.IsSuccess() {
+		t.Fatalf("NormalizeScore failed: %v", status)
+	}
+	if scores[0].Score != framework.MaxNodeScore || scores[1].Score != 25 ||
+		scores[2].Score != framework.MaxNodeScore/2 {
+		t.Fatalf("desire scores [100 25 50], real %v", scores)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterquota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/klog"
+	quota "k8s.io/kubernetes/pkg/quota/v1"
+	"k8s.io/kubernetes/pkg/quota/v1/evaluator/core"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "ClusterQuota"
+
+	preFilterStateKey = "PreFilter" + Name
+)
+
+// ClusterQuota is a filter plugin that rejects the virtual nodes whose clusters have not enough resource quota
+// left in the namespace of the pod, based on the quotas left published by the virtual node, so pods are not
+// bound to clusters which would reject creating them. Quotas are matched by their scopes as the quota admission
+// of the lower cluster does.
+type ClusterQuota struct {
+	evaluator quota.Evaluator
+	// headrooms caches the parsed quota headroom of each virtual node
+	headrooms sync.Map
+}
+
+// cachedHeadroom is the quota headroom parsed from the annotation
+type cachedHeadroom struct {
+	annotation string
+	headroom   common.QuotaHeadroom
+}
+
+// preFilterState is computed at PreFilter and used at Filter.
+type preFilterState struct {
+	usage v1.ResourceList
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+var _ framework.PreFilterPlugin = &ClusterQuota{}
+var _ framework.FilterPlugin = &ClusterQuota{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, _ framework.FrameworkHandle) (framework.Plugin, error) {
+	return &ClusterQuota{evaluator: core.NewPodEvaluator(nil, clock.RealClock{})}, nil
+}
+
+// Name returns name of the plugin.
+func (c *ClusterQuota) Name() string {
+	return Name
+}
+
+// PreFilter computes the quota usage of the pod once for all of the nodes.
+func (c *ClusterQuota) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	usage, err := c.evaluator.Usage(pod)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	state.Write(preFilterStateKey, &preFilterState{usage: usage})
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (c *ClusterQuota) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point.
+func (c *ClusterQuota) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	headroom, err := c.getHeadroom(node)
+	if err != nil {
+		klog.Warningf("Invalid quota headroom of node %v: %v", node.Name, err)
+		return nil
+	}
+	if len(headroom[pod.Namespace]) == 0 {
+		return nil
+	}
+	s, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	usage := s.(*preFilterState).usage
+	for _, left := range headroom[pod.Namespace] {
+		exceeded, err := c.exceeds(pod, usage, left)
+		if err != nil {
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		if len(exceeded) != 0 {
+			return framework.NewStatus(framework.Unschedulable,
+				fmt.Sprintf("exceeded quota of %v in namespace %v of cluster of %v", exceeded, pod.Namespace,
+					node.Name))
+		}
+	}
+	return nil
+}
+
+// exceeds returns the resources of the quota left the usage of pod exceeds, none if the quota does not match
+// the pod by its scopes
+func (c *ClusterQuota) exceeds(pod *v1.Pod, usage v1.ResourceList, left common.QuotaLeft) ([]v1.ResourceName,
+	error) {
+	resourceQuota := &v1.ResourceQuota{
+		Spec:   v1.ResourceQuotaSpec{Scopes: left.Scopes, ScopeSelector: left.ScopeSelector},
+		Status: v1.ResourceQuotaStatus{Hard: left.Left},
+	}
+	matches, err := c.evaluator.Matches(resourceQuota, pod)
+	if err != nil || !matches {
+		return nil, err
+	}
+	requested := quota.Mask(usage, quota.ResourceNames(left.Left))
+	_, exceeded := quota.LessThanOrEqual(requested, left.Left)
+	return exceeded, nil
+}
+
+// getHeadroom returns the quota headroom of the virtual node, nil would be returned if not published
+func (c *ClusterQuota) getHeadroom(node *v1.Node) (common.QuotaHeadroom, error) {
+	annotation, ok := node.Annotations[util.QuotaHeadroom]
+	if !ok {
+		c.headrooms.Delete(node.Name)
+		return nil, nil
+	}
+	if cached, ok := c.headrooms.Load(node.Name); ok && cached.(*cachedHeadroom).annotation == annotation {
+		return cached.(*cachedHeadroom).headroom, nil
+	}
+	headroom := common.QuotaHeadroom{}
+	if err := json.Unmarshal([]byte(annotation), &headroom); err != nil {
+		return nil, err
+	}
+	c.headrooms.Store(node.Name, &cachedHeadroom{annotation: annotation, headroom: headroom})
+	return headroom, nil
+}
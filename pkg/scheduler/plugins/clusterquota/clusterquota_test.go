@@ -0,0 +1,85 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterquota
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	headroom := common.QuotaHeadroom{
+		"default": {
+			{Left: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("500m")}},
+			{Left: v1.ResourceList{v1.ResourcePods: resource.MustParse("0")},
+				Scopes: []v1.ResourceQuotaScope{v1.ResourceQuotaScopeBestEffort}},
+		},
+	}
+	data, _ := json.Marshal(headroom)
+	virtualNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "virtual",
+		Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+		Annotations: map[string]string{util.QuotaHeadroom: string(data)}}}
+	realNode := virtualNode.DeepCopy()
+	realNode.Name = "real"
+	realNode.Labels = nil
+
+	newPod := func(namespace, cpu string) *v1.Pod {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: namespace},
+			Spec: v1.PodSpec{Containers: []v1.Container{{}}}}
+		if len(cpu) != 0 {
+			pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}
+		}
+		return pod
+	}
+	cases := []struct {
+		name    string
+		pod     *v1.Pod
+		node    *v1.Node
+		success bool
+	}{
+		{name: "fits quota", pod: newPod("default", "200m"), node: virtualNode, success: true},
+		{name: "exceeds quota", pod: newPod("default", "1"), node: virtualNode, success: false},
+		{name: "exceeds quota of scope", pod: newPod("default", ""), node: virtualNode, success: false},
+		{name: "namespace without quota", pod: newPod("other", "1"), node: virtualNode, success: true},
+		{name: "not virtual node", pod: newPod("default", "1"), node: realNode, success: true},
+	}
+	plugin, _ := New(nil, nil)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			p := plugin.(*ClusterQuota)
+			if status := p.PreFilter(context.TODO(), state, c.pod); !status.IsSuccess() {
+				t.Fatal(status.Message())
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			if status := p.Filter(context.TODO(), state, c.pod, nodeInfo); status.IsSuccess() != c.success {
+				t.Fatalf("Desire success %v, get %v", c.success, status)
+			}
+		})
+	}
+}
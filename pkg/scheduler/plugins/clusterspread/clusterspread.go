@@ -0,0 +1,315 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterspread
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	pluginhelper "k8s.io/kubernetes/pkg/scheduler/framework/plugins/helper"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterfit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "ClusterSpread"
+
+	preFilterStateKey = "PreFilter" + Name
+	preScoreStateKey  = "PreScore" + Name
+
+	// unknownScore is the score of nodes outside the clusters, they are given the middle score after normalized
+	unknownScore = -1
+)
+
+// Args holds the args that are used to configure the plugin.
+type Args struct {
+	// ClusterLabel is the label of nodes naming their clusters, topology spread constraints of pods keyed on it
+	// are evaluated by the plugin, util.ClusterName labeled on virtual nodes if not set
+	ClusterLabel string `json:"clusterLabel,omitempty"`
+}
+
+// ClusterSpread is a filter and score plugin evaluating the topology spread constraints keyed on the cluster
+// label across virtual nodes, so replicas are spread evenly among lower clusters instead of landing on the
+// biggest one. Unlike PodTopologySpread, clusters having no node to fit the pod by their fit summaries do not
+// hold the least count of the constraints, so a full cluster never blocks the others, and nodes without the
+// label are not constrained. Constraints with whenUnsatisfiable DoNotSchedule are filtered and the ones with
+// ScheduleAnyway are scored.
+type ClusterSpread struct {
+	handle       framework.FrameworkHandle
+	clusterLabel string
+}
+
+var _ framework.PreFilterPlugin = &ClusterSpread{}
+var _ framework.FilterPlugin = &ClusterSpread{}
+var _ framework.PreScorePlugin = &ClusterSpread{}
+var _ framework.ScorePlugin = &ClusterSpread{}
+
+// constraint is a topology spread constraint keyed on the cluster label
+type constraint struct {
+	maxSkew  int32
+	selector labels.Selector
+	// counts is the number of pods matching the selector in each cluster
+	counts map[string]int32
+	// min is the least count among the clusters able to host the pod, 0 if none is
+	min int32
+}
+
+// spreadState is the constraints with the counts of pods computed at PreFilter or PreScore
+type spreadState struct {
+	constraints []*constraint
+}
+
+// Clone the state.
+func (s *spreadState) Clone() framework.StateData {
+	return s
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	args := &Args{}
+	if err := framework.DecodeInto(configuration, args); err != nil {
+		return nil, err
+	}
+	if len(args.ClusterLabel) == 0 {
+		args.ClusterLabel = util.ClusterName
+	}
+	return &ClusterSpread{handle: handle, clusterLabel: args.ClusterLabel}, nil
+}
+
+// Name returns name of the plugin.
+func (c *ClusterSpread) Name() string {
+	return Name
+}
+
+// PreFilter counts the pods matching the constraints of DoNotSchedule in each cluster once for all of the nodes.
+func (c *ClusterSpread) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	s, err := c.newState(pod, v1.DoNotSchedule)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	state.Write(preFilterStateKey, s)
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (c *ClusterSpread) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point, nodes of the clusters whose skew would exceed the max skew of any
+// constraint if the pod were placed there are rejected.
+func (c *ClusterSpread) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	data, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	s, ok := data.(*spreadState)
+	if !ok {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("invalid state %+v", data))
+	}
+	cluster, ok := node.Labels[c.clusterLabel]
+	if !ok {
+		return nil
+	}
+	for _, con := range s.constraints {
+		if skew := con.counts[cluster] + selfMatch(con, pod) - con.min; skew > con.maxSkew {
+			return framework.NewStatus(framework.Unschedulable,
+				fmt.Sprintf("spreading to cluster %v makes skew %v beyond max skew %v", cluster, skew, con.maxSkew))
+		}
+	}
+	return nil
+}
+
+// PreScore counts the pods matching the constraints of ScheduleAnyway in each cluster once for all of the nodes.
+func (c *ClusterSpread) PreScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodes []*v1.Node) *framework.Status {
+	s, err := c.newState(pod, v1.ScheduleAnyway)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	state.Write(preScoreStateKey, s)
+	return nil
+}
+
+// Score invoked at the score extension point, the score is the sum of the counts of pods matching the
+// constraints in the cluster of the node, which is inverted by NormalizeScore.
+func (c *ClusterSpread) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeName string) (int64, *framework.Status) {
+	data, err := state.Read(preScoreStateKey)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	s, ok := data.(*spreadState)
+	if !ok {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("invalid state %+v", data))
+	}
+	if len(s.constraints) == 0 {
+		return 0, nil
+	}
+	nodeInfo, err := c.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil || nodeInfo.Node() == nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v",
+			nodeName, err))
+	}
+	cluster, ok := nodeInfo.Node().Labels[c.clusterLabel]
+	if !ok {
+		return unknownScore, nil
+	}
+	var score int64
+	for _, con := range s.constraints {
+		score += int64(con.counts[cluster] + selfMatch(con, pod))
+	}
+	return score, nil
+}
+
+// ScoreExtensions of the Score plugin.
+func (c *ClusterSpread) ScoreExtensions() framework.ScoreExtensions {
+	return c
+}
+
+// NormalizeScore inverts the counts, the nodes of the clusters with the least pods get the max score, nodes
+// outside the clusters get the middle score, so that they are neither preferred nor avoided.
+func (c *ClusterSpread) NormalizeScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	scores framework.NodeScoreList) *framework.Status {
+	var lowest, highest int64 = -1, 0
+	for _, score := range scores {
+		if score.Score == unknownScore {
+			continue
+		}
+		if lowest < 0 || score.Score < lowest {
+			lowest = score.Score
+		}
+		if score.Score > highest {
+			highest = score.Score
+		}
+	}
+	for i := range scores {
+		switch {
+		case scores[i].Score == unknownScore:
+			scores[i].Score = framework.MaxNodeScore / 2
+		case highest == lowest:
+			scores[i].Score = framework.MaxNodeScore
+		default:
+			scores[i].Score = (highest - scores[i].Score) * framework.MaxNodeScore / (highest - lowest)
+		}
+	}
+	return nil
+}
+
+// newState counts the pods matching the constraints of the pod keyed on the cluster label and unsatisfiable by
+// the action in each cluster
+func (c *ClusterSpread) newState(pod *v1.Pod, action v1.UnsatisfiableConstraintAction) (*spreadState, error) {
+	s := &spreadState{}
+	for _, tsc := range pod.Spec.TopologySpreadConstraints {
+		if tsc.TopologyKey != c.clusterLabel || tsc.WhenUnsatisfiable != action {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(tsc.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector of constraint: %v", err)
+		}
+		s.constraints = append(s.constraints, &constraint{maxSkew: tsc.MaxSkew, selector: selector,
+			counts: map[string]int32{}})
+	}
+	if len(s.constraints) == 0 {
+		return s, nil
+	}
+	nodeInfos, err := c.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes: %v", err)
+	}
+	count(s, pod, nodeInfos, c.clusterLabel)
+	return s, nil
+}
+
+// count counts the pods matching the constraints in each cluster of the nodes the pod could be placed to by its
+// node selector and affinity, and the least count among the clusters able to host the pod
+func count(s *spreadState, pod *v1.Pod, nodeInfos []*schedulernodeinfo.NodeInfo, clusterLabel string) {
+	request := clusterfit.PodRequest(pod)
+	hosts := map[string]bool{}
+	for _, nodeInfo := range nodeInfos {
+		node := nodeInfo.Node()
+		if node == nil {
+			continue
+		}
+		cluster, ok := node.Labels[clusterLabel]
+		if !ok || !pluginhelper.PodMatchesNodeSelectorAndAffinityTerms(pod, node) {
+			continue
+		}
+		if !node.Spec.Unschedulable && fits(node, request) {
+			hosts[cluster] = true
+		}
+		for _, existing := range nodeInfo.Pods() {
+			if existing.Namespace != pod.Namespace || existing.DeletionTimestamp != nil {
+				continue
+			}
+			for _, con := range s.constraints {
+				if con.selector.Matches(labels.Set(existing.Labels)) {
+					con.counts[cluster]++
+				}
+			}
+		}
+	}
+	for _, con := range s.constraints {
+		first := true
+		for cluster := range hosts {
+			if first || con.counts[cluster] < con.min {
+				con.min = con.counts[cluster]
+				first = false
+			}
+		}
+	}
+}
+
+// fits returns if a node of the cluster of the virtual node fits the request by its fit summary, true if the
+// summary is not published
+func fits(node *v1.Node, request common.NodeFree) bool {
+	annotation, ok := node.Annotations[util.FitSummary]
+	if !ok || !util.IsVirtualNode(node) {
+		return true
+	}
+	summary := common.FitSummary{}
+	if err := json.Unmarshal([]byte(annotation), &summary); err != nil {
+		klog.Warningf("Invalid fit summary of node %v: %v", node.Name, err)
+		return true
+	}
+	return summary.Fits(request)
+}
+
+// selfMatch returns 1 if the pod itself matches the constraint, it is counted in the cluster it is placed to
+func selfMatch(con *constraint, pod *v1.Pod) int32 {
+	if con.selector.Matches(labels.Set(pod.Labels)) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterspread
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	newNode := func(name, cluster string) *v1.Node {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+		if len(cluster) != 0 {
+			node.Labels[util.ClusterName] = cluster
+			node.Labels[util.NodeType] = util.VirtualKubeletLabel
+		}
+		return node
+	}
+	newPod := func(name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "test"}},
+			Spec: v1.PodSpec{Containers: []v1.Container{{Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}}}},
+		}
+	}
+	full := newNode("full", "b")
+	full.Annotations = map[string]string{util.FitSummary: `[{"cpu":100,"memory":100,"pods":10}]`}
+	nodeInfos := map[string]*schedulernodeinfo.NodeInfo{
+		"a":    schedulernodeinfo.NewNodeInfo(newPod("a1"), newPod("a2")),
+		"full": schedulernodeinfo.NewNodeInfo(),
+		"c":    schedulernodeinfo.NewNodeInfo(newPod("c1")),
+		"real": schedulernodeinfo.NewNodeInfo(newPod("r1")),
+	}
+	nodeInfos["a"].SetNode(newNode("a", "a"))
+	nodeInfos["full"].SetNode(full)
+	nodeInfos["c"].SetNode(newNode("c", "c"))
+	nodeInfos["real"].SetNode(newNode("real", ""))
+
+	pod := newPod("test")
+	pod.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       util.ClusterName,
+		WhenUnsatisfiable: v1.DoNotSchedule,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+	}}
+	plugin := &ClusterSpread{clusterLabel: util.ClusterName}
+	s, err := plugin.newState(pod, v1.ScheduleAnyway)
+	if err != nil || len(s.constraints) != 0 {
+		t.Fatalf("Desire no constraint of ScheduleAnyway, get %v, %v", s, err)
+	}
+	s = &spreadState{}
+	for _, tsc := range pod.Spec.TopologySpreadConstraints {
+		selector, _ := metav1.LabelSelectorAsSelector(tsc.LabelSelector)
+		s.constraints = append(s.constraints, &constraint{maxSkew: tsc.MaxSkew, selector: selector,
+			counts: map[string]int32{}})
+	}
+	count(s, pod, []*schedulernodeinfo.NodeInfo{nodeInfos["a"], nodeInfos["full"], nodeInfos["c"],
+		nodeInfos["real"]}, util.ClusterName)
+	// the full cluster b holds no pod but is not able to host the pod
+	if s.constraints[0].min != 1 {
+		t.Fatalf("Desire min 1 among clusters able to host the pod, get %v", s.constraints[0].min)
+	}
+	state := framework.NewCycleState()
+	state.Write(preFilterStateKey, s)
+	for name, success := range map[string]bool{"a": false, "c": true, "real": true} {
+		status := plugin.Filter(context.TODO(), state, pod, nodeInfos[name])
+		if status.IsSuccess() != success {
+			t.Errorf("Desire success %v on node %v, get %v", success, name, status)
+		}
+	}
+}
+
+func TestNormalizeScore(t *testing.T) {
+	plugin := &ClusterSpread{}
+	scores := framework.NodeScoreList{{Name: "a", Score: 3}, {Name: "b", Score: 1}, {Name: "c", Score: unknownScore}}
+	plugin.NormalizeScore(context.TODO(), nil, nil, scores)
+	if scores[0].Score != 0 || scores[1].Score != framework.MaxNodeScore ||
+		scores[2].Score != framework.MaxNodeScore/2 {
+		t.Fatalf("Desire scores [0 100 50], get %v", scores)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csidriver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "CSIDriver"
+
+	preFilterStateKey = "PreFilter" + Name
+
+	// inTreeProvisionerPrefix is the prefix of in-tree provisioners, which need no CSI driver
+	inTreeProvisionerPrefix = "kubernetes.io/"
+	modePersistent          = "Persistent"
+	modeEphemeral           = "Ephemeral"
+)
+
+// CSIDriver is a filter plugin that rejects the virtual nodes whose clusters have not installed
+// the CSI drivers required by the volumes of the pod. The drivers are resolved from inline CSI
+// volumes, bound PVs and the provisioners of storage classes of unbound PVCs.
+type CSIDriver struct {
+	pvcLister corelisters.PersistentVolumeClaimLister
+	pvLister  corelisters.PersistentVolumeLister
+	scLister  storagelisters.StorageClassLister
+	// drivers caches the parsed drivers of each virtual node
+	drivers sync.Map
+}
+
+// cachedDrivers is the drivers parsed from the annotation
+type cachedDrivers struct {
+	annotation string
+	drivers    common.CSIDrivers
+}
+
+// preFilterState is computed at PreFilter and used at Filter.
+type preFilterState struct {
+	// required is the drivers required by the pod and the lifecycle modes
+	required map[string]string
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+var _ framework.PreFilterPlugin = &CSIDriver{}
+var _ framework.FilterPlugin = &CSIDriver{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	informerFactory := handle.SharedInformerFactory()
+	return &CSIDriver{
+		pvcLister: informerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+		pvLister:  informerFactory.Core().V1().PersistentVolumes().Lister(),
+		scLister:  informerFactory.Storage().V1().StorageClasses().Lister(),
+	}, nil
+}
+
+// Name returns name of the plugin.
+func (c *CSIDriver) Name() string {
+	return Name
+}
+
+// PreFilter resolves the drivers required by the pod once for all of the nodes.
+func (c *CSIDriver) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	required, err := c.getRequiredDrivers(pod)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	state.Write(preFilterStateKey, &preFilterState{required: required})
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (c *CSIDriver) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point.
+func (c *CSIDriver) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	s, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	required := s.(*preFilterState).required
+	if len(required) == 0 {
+		return nil
+	}
+	drivers, err := c.getDrivers(node)
+	if err != nil {
+		klog.Warningf("Invalid csi drivers of node %v: %v", node.Name, err)
+		return nil
+	}
+	if drivers == nil {
+		return nil
+	}
+	for name, mode := range required {
+		driver := drivers.Get(name)
+		if driver == nil {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("csi driver %v not installed in cluster of %v", name, node.Name))
+		}
+		if !driver.SupportsMode(mode) {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("csi driver %v in cluster of %v does not support %v volumes", name, node.Name, mode))
+		}
+	}
+	return nil
+}
+
+// getRequiredDrivers returns the CSI drivers required by the volumes of pod
+func (c *CSIDriver) getRequiredDrivers(pod *v1.Pod) (map[string]string, error) {
+	required := make(map[string]string)
+	for _, vol := range pod.Spec.Volumes {
+		if vol.CSI != nil {
+			required[vol.CSI.Driver] = modeEphemeral
+			continue
+		}
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		driver, err := c.getPVCDriver(pod.Namespace, vol.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return nil, err
+		}
+		if len(driver) > 0 {
+			required[driver] = modePersistent
+		}
+	}
+	return required, nil
+}
+
+// getPVCDriver returns the CSI driver of the pvc, empty string would be returned if not provisioned by CSI
+func (c *CSIDriver) getPVCDriver(namespace, name string) (string, error) {
+	pvc, err := c.pvcLister.PersistentVolumeClaims(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(pvc.Spec.VolumeName) > 0 {
+		pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		if pv.Spec.CSI != nil {
+			return pv.Spec.CSI.Driver, nil
+		}
+		return "", nil
+	}
+	if pvc.Spec.StorageClassName == nil || len(*pvc.Spec.StorageClassName) == 0 {
+		return "", nil
+	}
+	sc, err := c.scLister.Get(*pvc.Spec.StorageClassName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if strings.HasPrefix(sc.Provisioner, inTreeProvisionerPrefix) {
+		return "", nil
+	}
+	return sc.Provisioner, nil
+}
+
+// getDrivers returns the CSI drivers of the virtual node, nil would be returned if not published
+func (c *CSIDriver) getDrivers(node *v1.Node) (common.CSIDrivers, error) {
+	annotation, ok := node.Annotations[util.CSIDrivers]
+	if !ok {
+		c.drivers.Delete(node.Name)
+		return nil, nil
+	}
+	if cached, ok := c.drivers.Load(node.Name); ok && cached.(*cachedDrivers).annotation == annotation {
+		return cached.(*cachedDrivers).drivers, nil
+	}
+	drivers := common.CSIDrivers{}
+	if err := json.Unmarshal([]byte(annotation), &drivers); err != nil {
+		return nil, err
+	}
+	c.drivers.Store(node.Name, &cachedDrivers{annotation: annotation, drivers: drivers})
+	return drivers, nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csidriver
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	drivers := `[{"name":"disk.csi.io"},{"name":"inline.csi.io","modes":["Ephemeral"]}]`
+	virtualNode := func(annotations map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "vk",
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: annotations,
+		}}
+	}
+	pvcPod := func(claim string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}, Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			}}},
+		}}
+	}
+	inlinePod := func(driver string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}, Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+				CSI: &v1.CSIVolumeSource{Driver: driver},
+			}}},
+		}}
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	for _, sc := range []*storagev1.StorageClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "disk"}, Provisioner: "disk.csi.io"},
+		{ObjectMeta: metav1.ObjectMeta{Name: "nas"}, Provisioner: "nas.csi.io"},
+		{ObjectMeta: metav1.ObjectMeta{Name: "intree"}, Provisioner: "kubernetes.io/aws-ebs"},
+	} {
+		informerFactory.Storage().V1().StorageClasses().Informer().GetIndexer().Add(sc)
+	}
+	for _, name := range []string{"disk", "nas", "intree"} {
+		sc := name
+		informerFactory.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(
+			&v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &sc},
+			})
+	}
+	plugin := &CSIDriver{
+		pvcLister: informerFactory.Core().V1().PersistentVolumeClaims().Lister(),
+		pvLister:  informerFactory.Core().V1().PersistentVolumes().Lister(),
+		scLister:  informerFactory.Storage().V1().StorageClasses().Lister(),
+	}
+
+	cases := []struct {
+		name string
+		node *v1.Node
+		pod  *v1.Pod
+		code framework.Code
+	}{
+		{
+			name: "driver installed",
+			node: virtualNode(map[string]string{util.CSIDrivers: drivers}),
+			pod:  pvcPod("disk"),
+			code: framework.Success,
+		},
+		{
+			name: "driver not installed",
+			node: virtualNode(map[string]string{util.CSIDrivers: drivers}),
+			pod:  pvcPod("nas"),
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "in-tree provisioner",
+			node: virtualNode(map[string]string{util.CSIDrivers: drivers}),
+			pod:  pvcPod("intree"),
+			code: framework.Success,
+		},
+		{
+			name: "inline volume supported",
+			node: virtualNode(map[string]string{util.CSIDrivers: drivers}),
+			pod:  inlinePod("inline.csi.io"),
+			code: framework.Success,
+		},
+		{
+			name: "inline volume not supported",
+			node: virtualNode(map[string]string{util.CSIDrivers: drivers}),
+			pod:  inlinePod("disk.csi.io"),
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "drivers not published",
+			node: virtualNode(nil),
+			pod:  pvcPod("nas"),
+			code: framework.Success,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			if status := plugin.PreFilter(context.TODO(), state, c.pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter failed: %v", status)
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			if status := plugin.Filter(context.TODO(), state, c.pod, nodeInfo); status.Code() != c.code {
+				t.Errorf("Desired %v, get %v", c.code, status)
+			}
+		})
+	}
+}
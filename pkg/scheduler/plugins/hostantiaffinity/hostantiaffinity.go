@@ -0,0 +1,334 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostantiaffinity
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/interpodaffinity"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "HostAntiAffinity"
+
+	preFilterStateKey = "PreFilter" + Name
+
+	// unboundPrefix prefixes the keys of pods not bound to lower nodes yet, each of them is taken as a node
+	unboundPrefix = "pod/"
+)
+
+// HostAntiAffinity replaces InterPodAffinity in the profile, evaluating the required pod affinity terms keyed on
+// hostname of virtual nodes against the lower nodes instead of the virtual node as a single host, so that a
+// cluster is not rejected by an anti-affinity term once a pod selected runs there. A virtual node is rejected
+// only if every ready and schedulable lower node, published in annotation util.SchedulableNodes, runs a pod
+// conflicting with the pod, by util.LowerNode of the upper pods, and a pod with required affinity keyed on
+// hostname is still placed with the virtual node running the pods selected. The other terms, the preferred ones
+// and the nodes other than virtual nodes are evaluated by InterPodAffinity as before.
+type HostAntiAffinity struct {
+	affinity *interpodaffinity.InterPodAffinity
+	handle   framework.FrameworkHandle
+}
+
+var _ framework.PreFilterPlugin = &HostAntiAffinity{}
+var _ framework.PreFilterExtensions = &HostAntiAffinity{}
+var _ framework.FilterPlugin = &HostAntiAffinity{}
+var _ framework.PreScorePlugin = &HostAntiAffinity{}
+var _ framework.ScorePlugin = &HostAntiAffinity{}
+
+// hostState is the pods affecting the pod by the terms keyed on hostname on each virtual node
+type hostState struct {
+	// occupied counts the pods conflicting with the pod on each lower node of each virtual node
+	occupied map[string]map[string]int
+	// matched counts the pods matching each required affinity term keyed on hostname on each virtual node
+	matched map[string][]int
+	// anyMatched counts the pods matching any required affinity term keyed on hostname on all nodes
+	anyMatched int
+}
+
+// Clone the state.
+func (s *hostState) Clone() framework.StateData {
+	clone := &hostState{
+		occupied:   make(map[string]map[string]int, len(s.occupied)),
+		matched:    make(map[string][]int, len(s.matched)),
+		anyMatched: s.anyMatched,
+	}
+	for node, counts := range s.occupied {
+		clone.occupied[node] = make(map[string]int, len(counts))
+		for lower, count := range counts {
+			clone.occupied[node][lower] = count
+		}
+	}
+	for node, counts := range s.matched {
+		clone.matched[node] = append([]int{}, counts...)
+	}
+	return clone
+}
+
+// New initializes a new plugin and returns it, the args are the ones of InterPodAffinity.
+func New(configuration *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	affinity, err := interpodaffinity.New(configuration, handle)
+	if err != nil {
+		return nil, err
+	}
+	return &HostAntiAffinity{affinity: affinity.(*interpodaffinity.InterPodAffinity), handle: handle}, nil
+}
+
+// Name returns name of the plugin.
+func (h *HostAntiAffinity) Name() string {
+	return Name
+}
+
+// PreFilter collects the pods affecting the pod by the terms keyed on hostname once for all of the nodes,
+// together with the state of InterPodAffinity.
+func (h *HostAntiAffinity) PreFilter(ctx context.Context, state *framework.CycleState,
+	pod *v1.Pod) *framework.Status {
+	if status := h.affinity.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		return status
+	}
+	nodeInfos, err := h.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("listing nodes: %v", err))
+	}
+	s := newState()
+	for _, nodeInfo := range nodeInfos {
+		if nodeInfo.Node() == nil {
+			continue
+		}
+		for _, existing := range nodeInfo.Pods() {
+			s.update(pod, existing, nodeInfo.Node(), 1)
+		}
+	}
+	state.Write(preFilterStateKey, s)
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (h *HostAntiAffinity) PreFilterExtensions() framework.PreFilterExtensions {
+	return h
+}
+
+// AddPod from pre-computed data in cycleState.
+func (h *HostAntiAffinity) AddPod(ctx context.Context, state *framework.CycleState, podToSchedule *v1.Pod,
+	podToAdd *v1.Pod, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	return h.updateWithPod(ctx, state, podToSchedule, podToAdd, nodeInfo, 1)
+}
+
+// RemovePod from pre-computed data in cycleState.
+func (h *HostAntiAffinity) RemovePod(ctx context.Context, state *framework.CycleState, podToSchedule *v1.Pod,
+	podToRemove *v1.Pod, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	return h.updateWithPod(ctx, state, podToSchedule, podToRemove, nodeInfo, -1)
+}
+
+// updateWithPod updates the states of both InterPodAffinity and the plugin with the pod added or removed
+func (h *HostAntiAffinity) updateWithPod(ctx context.Context, state *framework.CycleState, podToSchedule *v1.Pod,
+	pod *v1.Pod, nodeInfo *schedulernodeinfo.NodeInfo, delta int) *framework.Status {
+	var status *framework.Status
+	if delta > 0 {
+		status = h.affinity.AddPod(ctx, state, podToSchedule, pod, nodeInfo)
+	} else {
+		status = h.affinity.RemovePod(ctx, state, podToSchedule, pod, nodeInfo)
+	}
+	if !status.IsSuccess() {
+		return status
+	}
+	s, err := getState(state)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if nodeInfo.Node() != nil {
+		s.update(podToSchedule, pod, nodeInfo.Node(), delta)
+	}
+	return nil
+}
+
+// Filter invoked at the filter extension point. The terms keyed on hostname are evaluated against the lower
+// nodes for virtual nodes and the others by InterPodAffinity.
+func (h *HostAntiAffinity) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return h.affinity.Filter(ctx, state, pod, nodeInfo)
+	}
+	stripped := nodeInfo.Clone()
+	strippedNode := node.DeepCopy()
+	delete(strippedNode.Labels, v1.LabelHostname)
+	if err := stripped.SetNode(strippedNode); err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if status := h.affinity.Filter(ctx, state, withoutHostTerms(pod), stripped); !status.IsSuccess() {
+		return status
+	}
+	s, err := getState(state)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if !s.satisfiesAffinity(pod, node.Name) {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, interpodaffinity.ErrReasonAffinityNotMatch,
+			interpodaffinity.ErrReasonAffinityRulesNotMatch)
+	}
+	occupied := len(s.occupied[node.Name])
+	if occupied == 0 {
+		return nil
+	}
+	// the virtual node is taken as a single host if the lower nodes are not published
+	nodes := 1
+	if annotation, ok := node.Annotations[util.SchedulableNodes]; ok {
+		if nodes, err = strconv.Atoi(annotation); err != nil {
+			klog.Warningf("Invalid schedulable nodes of node %v: %v", node.Name, err)
+			return nil
+		}
+	}
+	if occupied >= nodes {
+		return framework.NewStatus(framework.Unschedulable, interpodaffinity.ErrReasonAffinityNotMatch,
+			fmt.Sprintf("all of %v nodes in cluster of %v run pods conflicting by anti-affinity", nodes, node.Name))
+	}
+	return nil
+}
+
+// PreScore invoked at the pre score extension point, the preferred terms are scored by InterPodAffinity.
+func (h *HostAntiAffinity) PreScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodes []*v1.Node) *framework.Status {
+	return h.affinity.PreScore(ctx, state, pod, nodes)
+}
+
+// Score invoked at the score extension point.
+func (h *HostAntiAffinity) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeName string) (int64, *framework.Status) {
+	return h.affinity.Score(ctx, state, pod, nodeName)
+}
+
+// ScoreExtensions of the Score plugin.
+func (h *HostAntiAffinity) ScoreExtensions() framework.ScoreExtensions {
+	return h.affinity.ScoreExtensions()
+}
+
+// newState returns an empty state
+func newState() *hostState {
+	return &hostState{occupied: map[string]map[string]int{}, matched: map[string][]int{}}
+}
+
+// update counts the existing pod on the node by delta
+func (s *hostState) update(pod, existing *v1.Pod, node *v1.Node, delta int) {
+	virtual := util.IsVirtualNode(node)
+	terms := common.HostAffinityTerms(pod)
+	for i := range terms {
+		if !common.MatchesAffinityTerm(pod, &terms[i], existing) {
+			continue
+		}
+		s.anyMatched += delta
+		if !virtual {
+			continue
+		}
+		if s.matched[node.Name] == nil {
+			s.matched[node.Name] = make([]int, len(terms))
+		}
+		s.matched[node.Name][i] += delta
+	}
+	if !virtual || !common.HostAntiAffinityConflicts(pod, existing) {
+		return
+	}
+	lower := existing.Annotations[util.LowerNode]
+	if len(lower) == 0 {
+		lower = unboundPrefix + existing.Namespace + "/" + existing.Name
+	}
+	if s.occupied[node.Name] == nil {
+		s.occupied[node.Name] = map[string]int{}
+	}
+	s.occupied[node.Name][lower] += delta
+	if s.occupied[node.Name][lower] <= 0 {
+		delete(s.occupied[node.Name], lower)
+	}
+}
+
+// satisfiesAffinity tells whether the virtual node runs pods matching every required affinity term keyed on
+// hostname of the pod, the first pod of a series matching its own terms is let through as InterPodAffinity does
+func (s *hostState) satisfiesAffinity(pod *v1.Pod, node string) bool {
+	terms := common.HostAffinityTerms(pod)
+	if len(terms) == 0 {
+		return true
+	}
+	matched := s.matched[node]
+	satisfied := matched != nil
+	for i := range terms {
+		satisfied = satisfied && matched[i] > 0
+	}
+	if satisfied || s.anyMatched > 0 {
+		return satisfied
+	}
+	for i := range terms {
+		if !common.MatchesAffinityTerm(pod, &terms[i], pod) {
+			return false
+		}
+	}
+	return true
+}
+
+// getState reads the state written at PreFilter
+func getState(state *framework.CycleState) (*hostState, error) {
+	data, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := data.(*hostState)
+	if !ok {
+		return nil, fmt.Errorf("invalid state %+v", data)
+	}
+	return s, nil
+}
+
+// withoutHostTerms returns a copy of the pod without the required terms keyed on hostname, which are evaluated
+// by the plugin for virtual nodes
+func withoutHostTerms(pod *v1.Pod) *v1.Pod {
+	if len(common.HostAffinityTerms(pod)) == 0 && len(common.HostAntiAffinityTerms(pod)) == 0 {
+		return pod
+	}
+	stripped := pod.DeepCopy()
+	if affinity := stripped.Spec.Affinity.PodAffinity; affinity != nil {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution =
+			otherTerms(affinity.RequiredDuringSchedulingIgnoredDuringExecution)
+	}
+	if antiAffinity := stripped.Spec.Affinity.PodAntiAffinity; antiAffinity != nil {
+		antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution =
+			otherTerms(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+	}
+	return stripped
+}
+
+// otherTerms returns the terms not keyed on hostname
+func otherTerms(terms []v1.PodAffinityTerm) []v1.PodAffinityTerm {
+	var others []v1.PodAffinityTerm
+	for _, term := range terms {
+		if term.TopologyKey != v1.LabelHostname {
+			others = append(others, term)
+		}
+	}
+	return others
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostantiaffinity
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestUpdate(t *testing.T) {
+	virtual := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk",
+		Labels: map[string]string{util.NodeType: util.VirtualKubeletLabel, v1.LabelHostname: "vk"}}}
+	physical := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "real"}}
+	newPod := func(name, app, lower string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+			Labels: map[string]string{"app": app}, Annotations: map[string]string{util.LowerNode: lower}}}
+	}
+	term := v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		TopologyKey:   v1.LabelHostname,
+	}
+	pod := newPod("web", "web", "")
+	pod.Spec.Affinity = &v1.Affinity{
+		PodAffinity: &v1.PodAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}},
+			TopologyKey:   v1.LabelHostname,
+		}}},
+		PodAntiAffinity: &v1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+			term, {TopologyKey: v1.LabelZoneFailureDomain}}},
+	}
+
+	s := newState()
+	s.update(pod, newPod("web-1", "web", "node1"), virtual, 1)
+	s.update(pod, newPod("web-2", "web", "node1"), virtual, 1)
+	s.update(pod, newPod("web-3", "web", ""), virtual, 1)
+	s.update(pod, newPod("web-4", "web", ""), physical, 1)
+	if len(s.occupied["vk"]) != 2 || len(s.occupied["real"]) != 0 {
+		t.Fatalf("Desire 2 lower nodes occupied on vk only, get %v", s.occupied)
+	}
+	if !s.satisfiesAffinity(newPod("cache", "cache", ""), "vk") {
+		t.Fatal("Desire pod without affinity satisfied")
+	}
+	if s.satisfiesAffinity(pod, "vk") {
+		t.Fatal("Desire affinity unsatisfied without cache pods")
+	}
+	s.update(pod, newPod("cache-1", "cache", "node2"), virtual, 1)
+	if !s.satisfiesAffinity(pod, "vk") {
+		t.Fatal("Desire affinity satisfied with a cache pod")
+	}
+	clone := s.Clone().(*hostState)
+	clone.update(pod, newPod("web-1", "web", "node1"), virtual, -1)
+	clone.update(pod, newPod("web-2", "web", "node1"), virtual, -1)
+	if len(clone.occupied["vk"]) != 1 || len(s.occupied["vk"]) != 2 {
+		t.Fatalf("Desire node1 released in the clone only, get %v and %v", clone.occupied, s.occupied)
+	}
+
+	stripped := withoutHostTerms(pod)
+	if stripped.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil ||
+		len(stripped.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 ||
+		len(pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 2 {
+		t.Fatalf("Desire terms keyed on hostname stripped from a copy, get %+v", stripped.Spec.Affinity)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package networkzone
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "NetworkZone"
+
+	preScoreStateKey = "PreScore" + Name
+
+	defaultLatencyMilliseconds = 100
+)
+
+// Args holds the args that are used to configure the plugin.
+type Args struct {
+	// Latencies are the latencies between zones, they are symmetric and 0 within a zone
+	Latencies []ZoneLatency `json:"latencies,omitempty"`
+	// DefaultLatencyMilliseconds is the latency between zones not listed, or from clusters without zone,
+	// 100 if not set
+	DefaultLatencyMilliseconds int64 `json:"defaultLatencyMilliseconds,omitempty"`
+}
+
+// ZoneLatency is the latency between two network zones
+type ZoneLatency struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Milliseconds int64  `json:"milliseconds"`
+}
+
+// NetworkZone is a score plugin that favors the clusters close to the pods the pod depends on. Virtual nodes
+// are labeled with the network zones of their clusters, and the dependencies are the pods in the same
+// namespace selected by the annotation util.NetworkDependencies of the pod, so that chatty services are not
+// split across high latency links.
+type NetworkZone struct {
+	handle    framework.FrameworkHandle
+	latencies latencies
+}
+
+var _ framework.PreScorePlugin = &NetworkZone{}
+var _ framework.ScorePlugin = &NetworkZone{}
+
+// latencies are the latencies in milliseconds between zones
+type latencies struct {
+	between  map[[2]string]int64
+	fallback int64
+}
+
+// preScoreState is the count of dependencies in each zone computed at PreScore and used at Score,
+// dependencies on nodes without zone are counted by empty zone
+type preScoreState struct {
+	zones map[string]int64
+}
+
+// Clone the prescore state.
+func (s *preScoreState) Clone() framework.StateData {
+	return s
+}
+
+// New initializes a new plugin and returns it.
+func New(configuration *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	args := &Args{}
+	if err := framework.DecodeInto(configuration, args); err != nil {
+		return nil, err
+	}
+	l, err := newLatencies(args)
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkZone{handle: handle, latencies: l}, nil
+}
+
+// newLatencies validates the args and returns the latencies between zones
+func newLatencies(args *Args) (latencies, error) {
+	l := latencies{between: map[[2]string]int64{}, fallback: args.DefaultLatencyMilliseconds}
+	if l.fallback <= 0 {
+		l.fallback = defaultLatencyMilliseconds
+	}
+	for _, latency := range args.Latencies {
+		if len(latency.From) == 0 || len(latency.To) == 0 || latency.Milliseconds < 0 {
+			return l, fmt.Errorf("invalid latency %+v", latency)
+		}
+		l.between[[2]string{latency.From, latency.To}] = latency.Milliseconds
+		l.between[[2]string{latency.To, latency.From}] = latency.Milliseconds
+	}
+	return l, nil
+}
+
+// get returns the latency between zones
+func (l latencies) get(from, to string) int64 {
+	if len(from) == 0 || len(to) == 0 {
+		return l.fallback
+	}
+	if from == to {
+		return 0
+	}
+	if latency, ok := l.between[[2]string{from, to}]; ok {
+		return latency
+	}
+	return l.fallback
+}
+
+// Name returns name of the plugin.
+func (n *NetworkZone) Name() string {
+	return Name
+}
+
+// PreScore counts the dependencies of the pod in each zone once for all of the nodes.
+func (n *NetworkZone) PreScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodes []*v1.Node) *framework.Status {
+	selector, err := util.GetNetworkDependencies(pod)
+	if err != nil {
+		klog.V(4).Infof("Ignore network dependencies of pod %v/%v: %v", pod.Namespace, pod.Name, err)
+	}
+	s := &preScoreState{zones: map[string]int64{}}
+	state.Write(preScoreStateKey, s)
+	if selector == nil {
+		return nil
+	}
+	dependencies, err := n.handle.SnapshotSharedLister().Pods().List(selector)
+	if err != nil {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("listing dependencies: %v", err))
+	}
+	for _, dependency := range dependencies {
+		if dependency.Namespace != pod.Namespace || dependency.UID == pod.UID || len(dependency.Spec.NodeName) == 0 {
+			continue
+		}
+		nodeInfo, err := n.handle.SnapshotSharedLister().NodeInfos().Get(dependency.Spec.NodeName)
+		if err != nil || nodeInfo.Node() == nil {
+			continue
+		}
+		s.zones[nodeInfo.Node().Labels[util.NetworkZone]]++
+	}
+	return nil
+}
+
+// Score invoked at the score extension point, the score is the average latency to the dependencies,
+// which is inverted by NormalizeScore.
+func (n *NetworkZone) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeName string) (int64, *framework.Status) {
+	data, err := state.Read(preScoreStateKey)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	s, ok := data.(*preScoreState)
+	if !ok {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("invalid state %+v", data))
+	}
+	if len(s.zones) == 0 {
+		return 0, nil
+	}
+	nodeInfo, err := n.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil || nodeInfo.Node() == nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v",
+			nodeName, err))
+	}
+	return n.latencies.average(nodeInfo.Node().Labels[util.NetworkZone], s.zones), nil
+}
+
+// average returns the average latency from the zone to the dependencies counted by zone
+func (l latencies) average(zone string, dependencies map[string]int64) int64 {
+	var sum, count int64
+	for dependencyZone, n := range dependencies {
+		sum += l.get(zone, dependencyZone) * n
+		count += n
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+// ScoreExtensions of the Score plugin.
+func (n *NetworkZone) ScoreExtensions() framework.ScoreExtensions {
+	return n
+}
+
+// NormalizeScore inverts the latencies, the node of the lowest latency gets the max score.
+func (n *NetworkZone) NormalizeScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	scores framework.NodeScoreList) *framework.Status {
+	var highest int64
+	for _, score := range scores {
+		if score.Score > highest {
+			highest = score.Score
+		}
+	}
+	for i := range scores {
+		if highest == 0 {
+			scores[i].Score = framework.MaxNodeScore
+			continue
+		}
+		scores[i].Score = (highest - scores[i].Score) * framework.MaxNodeScore / highest
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package networkzone
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func TestLatencies(t *testing.T) {
+	if _, err := newLatencies(&Args{Latencies: []ZoneLatency{{From: "a", Milliseconds: 1}}}); err == nil {
+		t.Fatal("desire error of latency without zone, real nil")
+	}
+	l, err := newLatencies(&Args{Latencies: []ZoneLatency{{From: "a", To: "b", Milliseconds: 5}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		from     string
+		to       string
+		expected int64
+	}{
+		{name: "same zone", from: "a", to: "a", expected: 0},
+		{name: "listed", from: "a", to: "b", expected: 5},
+		{name: "symmetric", from: "b", to: "a", expected: 5},
+		{name: "not listed", from: "a", to: "c", expected: defaultLatencyMilliseconds},
+		{name: "no zone", from: "", to: "", expected: defaultLatencyMilliseconds},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if latency := l.get(c.from, c.to); latency != c.expected {
+				t.Fatalf("desire %v, real %v", c.expected, latency)
+			}
+		})
+	}
+	if average := l.average("a", map[string]int64{"a": 3, "b": 1}); average != 1 {
+		t.Fatalf("desire average 1, real %v", average)
+	}
+}
+
+func TestPreScoreWithoutDependencies(t *testing.T) {
+	plugin := &NetworkZone{}
+	state := framework.NewCycleState()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	if status := plugin.PreScore(context.TODO(), state, pod, nil); !status.IsSuccess() {
+		t.Fatalf("desire success, real %v", status)
+	}
+	score, status := plugin.Score(context.TODO(), state, pod, "node")
+	if !status.IsSuccess() || score != 0 {
+		t.Fatalf("desire score 0, real %v, %v", score, status)
+	}
+}
+
+func TestNormalizeScore(t *testing.T) {
+	plugin := &NetworkZone{}
+	scores := framework.NodeScoreList{{Name: "a", Score: 0}, {Name: "b", Score: 5}, {Name: "c", Score: 100}}
+	plugin.NormalizeScore(context.TODO(), nil, nil, scores)
+	if scores[0].Score != framework.MaxNodeScore || scores[1].Score != 95 || scores[2].Score != 0 {
+		t.Fatalf("desire scores [100 95 0], real %v", scores)
+	}
+	scores = framework.NodeScoreList{{Name: "a", Score: 0}, {Name: "b", Score: 0}}
+	plugin.NormalizeScore(context.TODO(), nil, nil, scores)
+	if scores[0].Score != framework.MaxNodeScore || scores[1].Score != framework.MaxNodeScore {
+		t.Fatalf("desire all max scores, real %v", scores)
+	}
+}
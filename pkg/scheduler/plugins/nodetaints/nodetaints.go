@@ -0,0 +1,102 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nodetaints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "NodeTaints"
+
+// NodeTaints is a filter plugin that rejects the virtual nodes whose clusters have no ready and schedulable node
+// tolerated by the pod, based on the taint sets of the lower nodes published by the virtual node, so a pod
+// tolerating only the taint of gpu nodes is not bound to a cluster whose nodes all carry other taints.
+type NodeTaints struct {
+	// taints caches the parsed taint sets of each virtual node
+	taints sync.Map
+}
+
+// cachedTaints is the taint sets parsed from the annotation
+type cachedTaints struct {
+	annotation string
+	taints     common.NodeTaints
+}
+
+var _ framework.FilterPlugin = &NodeTaints{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, _ framework.FrameworkHandle) (framework.Plugin, error) {
+	return &NodeTaints{}, nil
+}
+
+// Name returns name of the plugin.
+func (n *NodeTaints) Name() string {
+	return Name
+}
+
+// Filter invoked at the filter extension point.
+func (n *NodeTaints) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	taints, err := n.getTaints(node)
+	if err != nil {
+		klog.Warningf("Invalid node taints of node %v: %v", node.Name, err)
+		return nil
+	}
+	if !taints.ToleratedBy(pod.Spec.Tolerations) {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			fmt.Sprintf("no node in cluster of %v tolerated by the pod", node.Name))
+	}
+	return nil
+}
+
+// getTaints returns the taint sets of the virtual node, nil would be returned if not published
+func (n *NodeTaints) getTaints(node *v1.Node) (common.NodeTaints, error) {
+	annotation := node.Annotations[util.NodeTaints]
+	if len(annotation) == 0 {
+		n.taints.Delete(node.Name)
+		return nil, nil
+	}
+	if cached, ok := n.taints.Load(node.Name); ok && cached.(*cachedTaints).annotation == annotation {
+		return cached.(*cachedTaints).taints, nil
+	}
+	taints := common.NodeTaints{}
+	if err := json.Unmarshal([]byte(annotation), &taints); err != nil {
+		return nil, err
+	}
+	n.taints.Store(node.Name, &cachedTaints{annotation: annotation, taints: taints})
+	return taints, nil
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nodetaints
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	gpu := v1.Taint{Key: "gpu", Effect: v1.TaintEffectNoSchedule}
+	spot := v1.Taint{Key: "spot", Value: "true", Effect: v1.TaintEffectNoExecute}
+	virtualNode := func(taints ...[]v1.Taint) *v1.Node {
+		var nodes []*v1.Node
+		for _, t := range taints {
+			nodes = append(nodes, &v1.Node{Spec: v1.NodeSpec{Taints: t}})
+		}
+		data, _ := json.Marshal(common.NewNodeTaints(nodes, 32))
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "vk",
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: map[string]string{util.NodeTaints: string(data)},
+		}}
+	}
+	tolerating := func(tolerations ...v1.Toleration) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{Tolerations: tolerations}}
+	}
+	tolerateGPU := v1.Toleration{Key: "gpu", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}
+	plugin := &NodeTaints{}
+
+	cases := []struct {
+		name string
+		node *v1.Node
+		pod  *v1.Pod
+		code framework.Code
+	}{
+		{
+			name: "untainted node",
+			node: virtualNode([]v1.Taint{spot}, nil),
+			pod:  tolerating(),
+			code: framework.Success,
+		},
+		{
+			name: "tainted node tolerated",
+			node: virtualNode([]v1.Taint{gpu}, []v1.Taint{spot}),
+			pod:  tolerating(tolerateGPU),
+			code: framework.Success,
+		},
+		{
+			name: "all nodes tainted by others",
+			node: virtualNode([]v1.Taint{spot}, []v1.Taint{gpu, spot}),
+			pod:  tolerating(tolerateGPU),
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "prefer no schedule ignored",
+			node: virtualNode([]v1.Taint{{Key: "busy", Effect: v1.TaintEffectPreferNoSchedule}}),
+			pod:  tolerating(),
+			code: framework.Success,
+		},
+		{
+			name: "taints not published",
+			node: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk",
+				Labels: map[string]string{util.NodeType: util.VirtualKubeletLabel}}},
+			pod:  tolerating(),
+			code: framework.Success,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			if status := plugin.Filter(context.TODO(), framework.NewCycleState(), c.pod, nodeInfo); status.Code() !=
+				c.code {
+				t.Errorf("Desired %v, get %v", c.code, status)
+			}
+		})
+	}
+}
+
+func TestTooManyTaintSets(t *testing.T) {
+	var nodes []*v1.Node
+	for _, key := range []string{"a", "b", "c"} {
+		nodes = append(nodes, &v1.Node{Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{Key: key, Effect: v1.TaintEffectNoSchedule}}}})
+	}
+	if taints := common.NewNodeTaints(nodes, 3); len(taints) != 3 {
+		t.Fatalf("Desire 3 taint sets, get %v", taints)
+	}
+	if taints := common.NewNodeTaints(append(nodes, nodes...), 3); len(taints) != 3 {
+		t.Fatalf("Desire duplicated taint sets merged, get %v", taints)
+	}
+	if taints := common.NewNodeTaints(nodes, 2); taints != nil {
+		t.Fatalf("Desire no taint sets published if exceeding max, get %v", taints)
+	}
+}
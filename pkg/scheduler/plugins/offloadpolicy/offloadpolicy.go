@@ -0,0 +1,121 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package offloadpolicy
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/offload"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "OffloadPolicy"
+
+	preFilterStateKey = "PreFilter" + Name
+)
+
+// Args holds the args that are used to configure the plugin.
+type Args struct {
+	// KubeConfig is the kubeconfig of the cluster to watch ClusterOffloadPolicies, the in-cluster config is used
+	// if not set.
+	KubeConfig string `json:"kubeConfig,omitempty"`
+}
+
+// OffloadPolicy is a filter plugin that keeps the pods eligible by no ClusterOffloadPolicy off the virtual
+// nodes, other nodes are not filtered. All pods are eligible if there is no policy.
+type OffloadPolicy struct {
+	policies *offload.Controller
+}
+
+// preFilterState is computed at PreFilter and used at Filter.
+type preFilterState struct {
+	eligible bool
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+var _ framework.PreFilterPlugin = &OffloadPolicy{}
+var _ framework.FilterPlugin = &OffloadPolicy{}
+
+// New initializes a new plugin and returns it.
+func New(configuration *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	args := &Args{}
+	if err := framework.DecodeInto(configuration, args); err != nil {
+		return nil, err
+	}
+	client, err := util.NewDynamicClient(args.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not build dynamic client: %v", err)
+	}
+	policies := offload.NewController(client, handle.SharedInformerFactory().Core().V1().Namespaces().Lister())
+	go policies.Run(wait.NeverStop)
+	return &OffloadPolicy{policies: policies}, nil
+}
+
+// Name returns name of the plugin.
+func (o *OffloadPolicy) Name() string {
+	return Name
+}
+
+// PreFilter tells whether the pod is eligible once for all of the nodes, pods are retried until the policies
+// are synced.
+func (o *OffloadPolicy) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	eligible, err := o.policies.Eligible(pod)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	state.Write(preFilterStateKey, &preFilterState{eligible: eligible})
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (o *OffloadPolicy) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point.
+func (o *OffloadPolicy) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	s, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if !s.(*preFilterState).eligible {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+			"pod is not eligible to run on virtual nodes by any ClusterOffloadPolicy")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package offloadpolicy
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	virtualNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk",
+		Labels: map[string]string{util.NodeType: util.VirtualKubeletLabel}}}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}
+	cases := []struct {
+		name     string
+		node     *v1.Node
+		eligible bool
+		code     framework.Code
+	}{
+		{
+			name:     "eligible",
+			node:     virtualNode,
+			eligible: true,
+			code:     framework.Success,
+		},
+		{
+			name: "not eligible",
+			node: virtualNode,
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "not virtual node",
+			node: node,
+			code: framework.Success,
+		},
+	}
+	plugin := &OffloadPolicy{}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			state.Write(preFilterStateKey, &preFilterState{eligible: c.eligible})
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			status := plugin.Filter(context.Background(), state, &v1.Pod{}, nodeInfo)
+			if status.Code() != c.code {
+				t.Fatalf("desire code %v, real %v", c.code, status.Code())
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package overcommit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "Overcommit"
+
+	defaultMetricsSyncPeriod = 30 * time.Second
+)
+
+// Args holds the args that are used to configure the plugin.
+type Args struct {
+	// KubeConfig is the kubeconfig of the cluster to get node metrics from metrics-server,
+	// if not set, only requests of pods are considered.
+	KubeConfig string `json:"kubeConfig,omitempty"`
+	// MetricsSyncPeriodSeconds is the period to sync node metrics.
+	MetricsSyncPeriodSeconds int64 `json:"metricsSyncPeriodSeconds,omitempty"`
+}
+
+// Overcommit is a score plugin that favors nodes with the largest effective headroom. The
+// effective headroom of a virtual node is the smaller one of the nominal headroom computed
+// from the overcommitted allocatable and the real headroom computed from the physical capacity
+// published by the virtual node and the actual usage reported by metrics-server.
+type Overcommit struct {
+	handle        framework.FrameworkHandle
+	metricsClient versioned.Interface
+
+	sync.RWMutex
+	usage map[string]v1.ResourceList
+}
+
+var _ framework.ScorePlugin = &Overcommit{}
+
+// New initializes a new plugin and returns it.
+func New(configuration *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	args := &Args{}
+	if err := framework.DecodeInto(configuration, args); err != nil {
+		return nil, err
+	}
+	plugin := &Overcommit{
+		handle: handle,
+		usage:  map[string]v1.ResourceList{},
+	}
+	if len(args.KubeConfig) == 0 {
+		return plugin, nil
+	}
+	metricsClient, err := util.NewMetricClient(args.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not build metrics client: %v", err)
+	}
+	plugin.metricsClient = metricsClient
+	period := defaultMetricsSyncPeriod
+	if args.MetricsSyncPeriodSeconds > 0 {
+		period = time.Duration(args.MetricsSyncPeriodSeconds) * time.Second
+	}
+	go wait.Forever(plugin.syncNodeMetrics, period)
+	return plugin, nil
+}
+
+// Name returns name of the plugin.
+func (o *Overcommit) Name() string {
+	return Name
+}
+
+// Score invoked at the score extension point.
+func (o *Overcommit) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeName string) (int64, *framework.Status) {
+	nodeInfo, err := o.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v",
+			nodeName, err))
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("node %q not found", nodeName))
+	}
+
+	podRequest := util.GetRequestFromPod(pod)
+	requested := nodeInfo.RequestedResource()
+	allocatable := nodeInfo.AllocatableResource()
+	o.RLock()
+	usage := o.usage[nodeName]
+	o.RUnlock()
+
+	var cpuUsage, memUsage *int64
+	if usage != nil {
+		if q, ok := usage[v1.ResourceCPU]; ok {
+			value := q.MilliValue()
+			cpuUsage = &value
+		}
+		if q, ok := usage[v1.ResourceMemory]; ok {
+			value := q.Value()
+			memUsage = &value
+		}
+	}
+	physicalCPU, physicalMemory := getPhysicalCapacity(node)
+	cpuScore := effectiveHeadroomScore(allocatable.MilliCPU, requested.MilliCPU+podRequest.CPU.MilliValue(),
+		cpuUsage, podRequest.CPU.MilliValue(), physicalCPU)
+	memScore := effectiveHeadroomScore(allocatable.Memory, requested.Memory+podRequest.Memory.Value(),
+		memUsage, podRequest.Memory.Value(), physicalMemory)
+	score := (cpuScore + memScore) / 2
+	klog.V(5).Infof("Node %v score %v, cpu %v, memory %v", nodeName, score, cpuScore, memScore)
+	return score, nil
+}
+
+// ScoreExtensions of the Score plugin.
+func (o *Overcommit) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
+
+// syncNodeMetrics lists the node metrics from metrics-server
+func (o *Overcommit) syncNodeMetrics() {
+	metrics, err := o.metricsClient.MetricsV1beta1().NodeMetricses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("List node metrics failed: %v", err)
+		return
+	}
+	usage := make(map[string]v1.ResourceList, len(metrics.Items))
+	for _, m := range metrics.Items {
+		usage[m.Name] = m.Usage
+	}
+	o.Lock()
+	o.usage = usage
+	o.Unlock()
+}
+
+// effectiveHeadroomScore computes the score of a resource from the smaller one of nominal headroom
+// and real headroom in proportion to the physical capacity of the cluster.
+// If usage is nil or the physical capacity is unknown, the real headroom is ignored and the nominal
+// headroom is in proportion to the allocatable.
+func effectiveHeadroomScore(allocatable, requested int64, usage *int64, podRequest int64, physical int64) int64 {
+	capacity := allocatable
+	headroom := allocatable - requested
+	if physical > 0 {
+		capacity = physical
+		if usage != nil {
+			if realHeadroom := physical - *usage - podRequest; realHeadroom < headroom {
+				headroom = realHeadroom
+			}
+		}
+	}
+	if capacity <= 0 || headroom <= 0 {
+		return 0
+	}
+	if headroom >= capacity {
+		return framework.MaxNodeScore
+	}
+	return headroom * framework.MaxNodeScore / capacity
+}
+
+// getPhysicalCapacity returns the milli cpu and memory capacity of the cluster without overcommit recorded in the
+// annotation of the node, 0 would be returned if not found or invalid
+func getPhysicalCapacity(node *v1.Node) (int64, int64) {
+	data, ok := node.Annotations[util.PhysicalCapacity]
+	if !ok {
+		return 0, 0
+	}
+	capacity := v1.ResourceList{}
+	if err := json.Unmarshal([]byte(data), &capacity); err != nil {
+		klog.V(4).Infof("Invalid physical capacity of node %v: %v", node.Name, err)
+		return 0, 0
+	}
+	return capacity.Cpu().MilliValue(), capacity.Memory().Value()
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package overcommit
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestEffectiveHeadroomScore(t *testing.T) {
+	usage := func(v int64) *int64 { return &v }
+	cases := []struct {
+		name        string
+		allocatable int64
+		requested   int64
+		usage       *int64
+		podRequest  int64
+		physical    int64
+		score       int64
+	}{
+		{
+			name:        "no overcommit no usage",
+			allocatable: 1000,
+			requested:   400,
+			physical:    1000,
+			score:       60,
+		},
+		{
+			name:        "overcommit limited by nominal headroom",
+			allocatable: 2000,
+			requested:   1800,
+			usage:       usage(100),
+			physical:    1000,
+			score:       20,
+		},
+		{
+			name:        "overcommit limited by real usage",
+			allocatable: 2000,
+			requested:   1000,
+			usage:       usage(800),
+			podRequest:  100,
+			physical:    1000,
+			score:       10,
+		},
+		{
+			name:        "overloaded",
+			allocatable: 1000,
+			requested:   200,
+			usage:       usage(1200),
+			physical:    1000,
+			score:       0,
+		},
+		{
+			name:        "physical capacity unknown",
+			allocatable: 2000,
+			requested:   1000,
+			usage:       usage(1900),
+			score:       50,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if get := effectiveHeadroomScore(c.allocatable, c.requested, c.usage, c.podRequest,
+				c.physical); get != c.score {
+				t.Errorf("Desire score %v, get %v", c.score, get)
+			}
+		})
+	}
+}
+
+func TestGetPhysicalCapacity(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		util.PhysicalCapacity: `{"cpu":"2","memory":"1Ki"}`,
+	}}}
+	if cpu, memory := getPhysicalCapacity(node); cpu != 2000 || memory != 1024 {
+		t.Errorf("Desire cpu 2000 memory 1024, get %v %v", cpu, memory)
+	}
+	if cpu, memory := getPhysicalCapacity(&v1.Node{}); cpu != 0 || memory != 0 {
+		t.Errorf("Desire unknown capacity, get %v %v", cpu, memory)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedulinggates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// Name is the name of the plugin used in the plugin registry and configurations.
+const Name = "SchedulingGates"
+
+// SchedulingGates is a prefilter plugin that holds the pods with scheduling gates in annotation
+// `tensile-kube.io/scheduling-gates`, so that external controllers, e.g. quota brokers or approval workflows,
+// could decide whether the pods are placed to any member cluster. The pods are kept unschedulable with the
+// gates in events and conditions, and are scheduled again once the annotation updated without any gate.
+type SchedulingGates struct{}
+
+var _ framework.PreFilterPlugin = &SchedulingGates{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, _ framework.FrameworkHandle) (framework.Plugin, error) {
+	return &SchedulingGates{}, nil
+}
+
+// Name returns name of the plugin.
+func (g *SchedulingGates) Name() string {
+	return Name
+}
+
+// PreFilter rejects the pod if any scheduling gate left, no node could make it schedulable.
+func (g *SchedulingGates) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	gates := util.GetSchedulingGates(pod)
+	if len(gates) == 0 {
+		return nil
+	}
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+		fmt.Sprintf("pod is waiting for scheduling gates %v", strings.Join(gates, ",")))
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (g *SchedulingGates) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedulinggates
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestPreFilter(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		code        framework.Code
+	}{
+		{
+			name: "no gates",
+			code: framework.Success,
+		},
+		{
+			name:        "empty gates",
+			annotations: map[string]string{util.SchedulingGates: " , "},
+			code:        framework.Success,
+		},
+		{
+			name:        "gated",
+			annotations: map[string]string{util.SchedulingGates: "quota,approval"},
+			code:        framework.UnschedulableAndUnresolvable,
+		},
+	}
+	plugin := &SchedulingGates{}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: c.annotations}}
+			status := plugin.PreFilter(context.TODO(), framework.NewCycleState(), pod)
+			if status.Code() != c.code {
+				t.Fatalf("desire %v, real %v", c.code, status.Code())
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storagecapacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "StorageCapacity"
+
+	preFilterStateKey = "PreFilter" + Name
+)
+
+// StorageCapacity is a filter plugin that rejects the virtual nodes whose clusters can not provision
+// the unbound PVCs of the pod, based on the storage capacity of topology segments collected from
+// CSIStorageCapacity objects and published by the virtual node. Only the segments the pod may be
+// scheduled to in the lower cluster are considered, and all PVCs of a storage class should fit in
+// one segment together.
+type StorageCapacity struct {
+	pvcLister corelisters.PersistentVolumeClaimLister
+	// capacities caches the parsed storage capacity of each virtual node
+	capacities sync.Map
+}
+
+// cachedCapacity is the storage capacity parsed from the annotation
+type cachedCapacity struct {
+	annotation string
+	capacity   common.StorageCapacity
+}
+
+// preFilterState is computed at PreFilter and used at Filter.
+type preFilterState struct {
+	// requests is the sizes of unbound pvcs of each storage class
+	requests map[string][]int64
+	// selection is the node selection of the pod in lower clusters
+	selection *util.ClustersNodeSelection
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+var _ framework.PreFilterPlugin = &StorageCapacity{}
+var _ framework.FilterPlugin = &StorageCapacity{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	return &StorageCapacity{
+		pvcLister: handle.SharedInformerFactory().Core().V1().PersistentVolumeClaims().Lister(),
+	}, nil
+}
+
+// Name returns name of the plugin.
+func (s *StorageCapacity) Name() string {
+	return Name
+}
+
+// PreFilter computes the sizes of unbound pvcs once for all of the nodes.
+func (s *StorageCapacity) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	requests := make(map[string][]int64)
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := s.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(vol.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		if len(pvc.Spec.VolumeName) > 0 || pvc.Spec.StorageClassName == nil {
+			continue
+		}
+		size, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			continue
+		}
+		class := *pvc.Spec.StorageClassName
+		requests[class] = append(requests[class], size.Value())
+	}
+	state.Write(preFilterStateKey, &preFilterState{
+		requests:  requests,
+		selection: util.ConvertAnnotations(pod.Annotations),
+	})
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (s *StorageCapacity) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point.
+func (s *StorageCapacity) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	st, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	pfs := st.(*preFilterState)
+	if len(pfs.requests) == 0 {
+		return nil
+	}
+	capacity, err := s.getCapacity(node)
+	if err != nil {
+		klog.Warningf("Invalid storage capacity of node %v: %v", node.Name, err)
+		return nil
+	}
+	matches := func(topology map[string]string) bool {
+		if !topologyMatches(pod.Spec.NodeSelector, pod.Spec.Affinity, topology) {
+			return false
+		}
+		return pfs.selection == nil ||
+			topologyMatches(pfs.selection.NodeSelector, pfs.selection.Affinity, topology)
+	}
+	for class, sizes := range pfs.requests {
+		if !capacity.Fits(class, sizes, matches) {
+			return framework.NewStatus(framework.Unschedulable,
+				fmt.Sprintf("insufficient storage of class %v in cluster of %v", class, node.Name))
+		}
+	}
+	return nil
+}
+
+// getCapacity returns the storage capacity of the virtual node, nil would be returned if not published
+func (s *StorageCapacity) getCapacity(node *v1.Node) (common.StorageCapacity, error) {
+	annotation, ok := node.Annotations[util.StorageCapacity]
+	if !ok {
+		s.capacities.Delete(node.Name)
+		return nil, nil
+	}
+	if cached, ok := s.capacities.Load(node.Name); ok && cached.(*cachedCapacity).annotation == annotation {
+		return cached.(*cachedCapacity).capacity, nil
+	}
+	capacity := common.StorageCapacity{}
+	if err := json.Unmarshal([]byte(annotation), &capacity); err != nil {
+		return nil, err
+	}
+	s.capacities.Store(node.Name, &cachedCapacity{annotation: annotation, capacity: capacity})
+	return capacity, nil
+}
+
+// topologyMatches returns if the nodes in the topology segment may be selected by the node selector and the
+// required node affinity, requirements on the labels absent from the topology can not be decided and are ignored
+func topologyMatches(nodeSelector map[string]string, affinity *v1.Affinity, topology map[string]string) bool {
+	for k, v := range nodeSelector {
+		if value, ok := topology[k]; ok && value != v {
+			return false
+		}
+	}
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return true
+	}
+	for _, term := range terms {
+		if termMatches(term, topology) {
+			return true
+		}
+	}
+	return false
+}
+
+// termMatches returns if the match expressions of the term may be satisfied by the topology
+func termMatches(term v1.NodeSelectorTerm, topology map[string]string) bool {
+	for _, req := range term.MatchExpressions {
+		value, ok := topology[req.Key]
+		if !ok {
+			continue
+		}
+		switch req.Operator {
+		case v1.NodeSelectorOpIn:
+			if !sets.NewString(req.Values...).Has(value) {
+				return false
+			}
+		case v1.NodeSelectorOpNotIn:
+			if sets.NewString(req.Values...).Has(value) {
+				return false
+			}
+		case v1.NodeSelectorOpDoesNotExist:
+			return false
+		}
+	}
+	return true
+}
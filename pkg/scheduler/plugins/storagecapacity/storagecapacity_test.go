@@ -0,0 +1,155 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storagecapacity
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	// 10Gi in zone a and 4Gi in zone b
+	capacity := `{"fast":[{"topology":{"zone":"a"},"capacity":10737418240},` +
+		`{"topology":{"zone":"b"},"capacity":4294967296}]}`
+	virtualNode := func(annotations map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "vk",
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: annotations,
+		}}
+	}
+	pod := func(claims ...string) *v1.Pod {
+		p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}
+		for _, claim := range claims {
+			p.Spec.Volumes = append(p.Spec.Volumes, v1.Volume{Name: claim, VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			}})
+		}
+		return p
+	}
+	inZoneB := pod("small")
+	inZoneB.Spec.NodeSelector = map[string]string{"zone": "b"}
+	selectedZoneB := pod("small")
+	selectedZoneB.Annotations = map[string]string{util.SelectorKey: `{"nodeSelector":{"zone":"b"}}`}
+	pvc := func(name, class, size, volume string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &class,
+				VolumeName:       volume,
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceStorage: resource.MustParse(size),
+				}},
+			},
+		}
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	indexer := informerFactory.Core().V1().PersistentVolumeClaims().Informer().GetIndexer()
+	indexer.Add(pvc("small", "fast", "5Gi", ""))
+	indexer.Add(pvc("small2", "fast", "5Gi", ""))
+	indexer.Add(pvc("medium", "fast", "6Gi", ""))
+	indexer.Add(pvc("large", "fast", "20Gi", ""))
+	indexer.Add(pvc("bound", "fast", "20Gi", "pv"))
+	indexer.Add(pvc("other", "slow", "20Gi", ""))
+	plugin := &StorageCapacity{pvcLister: informerFactory.Core().V1().PersistentVolumeClaims().Lister()}
+
+	cases := []struct {
+		name string
+		node *v1.Node
+		pod  *v1.Pod
+		code framework.Code
+	}{
+		{
+			name: "sufficient",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  pod("small"),
+			code: framework.Success,
+		},
+		{
+			name: "insufficient",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  pod("large"),
+			code: framework.Unschedulable,
+		},
+		{
+			name: "pvcs fit together",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  pod("small", "small2"),
+			code: framework.Success,
+		},
+		{
+			name: "pvcs not fit together",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  pod("small", "medium"),
+			code: framework.Unschedulable,
+		},
+		{
+			name: "insufficient in the zone selected by node selector",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  inZoneB,
+			code: framework.Unschedulable,
+		},
+		{
+			name: "insufficient in the zone selected in lower cluster",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  selectedZoneB,
+			code: framework.Unschedulable,
+		},
+		{
+			name: "bound",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  pod("bound"),
+			code: framework.Success,
+		},
+		{
+			name: "class without capacity",
+			node: virtualNode(map[string]string{util.StorageCapacity: capacity}),
+			pod:  pod("other"),
+			code: framework.Success,
+		},
+		{
+			name: "capacity not published",
+			node: virtualNode(nil),
+			pod:  pod("large"),
+			code: framework.Success,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			if status := plugin.PreFilter(context.TODO(), state, c.pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter failed: %v", status)
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			if status := plugin.Filter(context.TODO(), state, c.pod, nodeInfo); status.Code() != c.code {
+				t.Errorf("Desired %v, get %v", c.code, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storageclass
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// Name is the name of the plugin used in the plugin registry and configurations.
+	Name = "StorageClass"
+
+	preFilterStateKey = "PreFilter" + Name
+)
+
+// StorageClass is a filter plugin that rejects the virtual nodes whose clusters can not provision the unbound
+// PVCs of the pod, because the storage classes of the PVCs, or a default class for PVCs without one, do not
+// exist in the clusters, or the allowed topologies of WaitForFirstConsumer classes exclude the nodes the pod
+// may be scheduled to in the lower cluster. The classes are published by the virtual node.
+type StorageClass struct {
+	pvcLister corelisters.PersistentVolumeClaimLister
+	// classes caches the parsed storage classes of each virtual node
+	classes sync.Map
+}
+
+// cachedClasses is the storage classes parsed from the annotation
+type cachedClasses struct {
+	annotation string
+	classes    common.StorageClasses
+}
+
+// preFilterState is computed at PreFilter and used at Filter.
+type preFilterState struct {
+	// classes is the storage classes of unbound pvcs, and defaultClass is whether a pvc requires the default one
+	classes      sets.String
+	defaultClass bool
+	// selection is the node selection of the pod in lower clusters
+	selection *util.ClustersNodeSelection
+}
+
+// Clone the prefilter state.
+func (s *preFilterState) Clone() framework.StateData {
+	return s
+}
+
+var _ framework.PreFilterPlugin = &StorageClass{}
+var _ framework.FilterPlugin = &StorageClass{}
+
+// New initializes a new plugin and returns it.
+func New(_ *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+	return &StorageClass{
+		pvcLister: handle.SharedInformerFactory().Core().V1().PersistentVolumeClaims().Lister(),
+	}, nil
+}
+
+// Name returns name of the plugin.
+func (s *StorageClass) Name() string {
+	return Name
+}
+
+// PreFilter resolves the storage classes of unbound pvcs once for all of the nodes, a pvc with an empty class
+// is bound to existing volumes and needs no class.
+func (s *StorageClass) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	pfs := &preFilterState{classes: sets.NewString(), selection: util.ConvertAnnotations(pod.Annotations)}
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := s.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(vol.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		if len(pvc.Spec.VolumeName) > 0 {
+			continue
+		}
+		if pvc.Spec.StorageClassName == nil {
+			pfs.defaultClass = true
+			continue
+		}
+		if len(*pvc.Spec.StorageClassName) > 0 {
+			pfs.classes.Insert(*pvc.Spec.StorageClassName)
+		}
+	}
+	state.Write(preFilterStateKey, pfs)
+	return nil
+}
+
+// PreFilterExtensions returns prefilter extensions, pod add and remove.
+func (s *StorageClass) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter invoked at the filter extension point.
+func (s *StorageClass) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod,
+	nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if !util.IsVirtualNode(node) {
+		return nil
+	}
+	st, err := state.Read(preFilterStateKey)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	pfs := st.(*preFilterState)
+	if pfs.classes.Len() == 0 && !pfs.defaultClass {
+		return nil
+	}
+	classes, err := s.getClasses(node)
+	if err != nil {
+		klog.Warningf("Invalid storage classes of node %v: %v", node.Name, err)
+		return nil
+	}
+	if classes == nil {
+		return nil
+	}
+	required := make([]*common.StorageClass, 0, pfs.classes.Len()+1)
+	if pfs.defaultClass {
+		class := classes.Default()
+		if class == nil {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("no default storage class in cluster of %v", node.Name))
+		}
+		required = append(required, class)
+	}
+	for _, name := range pfs.classes.List() {
+		class := classes.Get(name)
+		if class == nil {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("storage class %v not in cluster of %v", name, node.Name))
+		}
+		required = append(required, class)
+	}
+	for _, class := range required {
+		if !class.WaitForFirstConsumer() || len(class.AllowedTopologies) == 0 {
+			continue
+		}
+		if !topologyAllowed(class.AllowedTopologies, pod.Spec.NodeSelector, pod.Spec.Affinity) ||
+			pfs.selection != nil &&
+				!topologyAllowed(class.AllowedTopologies, pfs.selection.NodeSelector, pfs.selection.Affinity) {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("topologies of storage class %v in cluster of %v exclude the pod", class.Name,
+					node.Name))
+		}
+	}
+	return nil
+}
+
+// getClasses returns the storage classes of the virtual node, nil would be returned if not published
+func (s *StorageClass) getClasses(node *v1.Node) (common.StorageClasses, error) {
+	annotation, ok := node.Annotations[util.StorageClasses]
+	if !ok {
+		s.classes.Delete(node.Name)
+		return nil, nil
+	}
+	if cached, ok := s.classes.Load(node.Name); ok && cached.(*cachedClasses).annotation == annotation {
+		return cached.(*cachedClasses).classes, nil
+	}
+	classes := common.StorageClasses{}
+	if err := json.Unmarshal([]byte(annotation), &classes); err != nil {
+		return nil, err
+	}
+	s.classes.Store(node.Name, &cachedClasses{annotation: annotation, classes: classes})
+	return classes, nil
+}
+
+// topologyAllowed returns if any of the allowed topologies may have nodes selected by the node selector and a
+// term of the required node affinity, requirements on the labels absent from a topology can not be decided and
+// are ignored
+func topologyAllowed(allowed []v1.TopologySelectorTerm, nodeSelector map[string]string,
+	affinity *v1.Affinity) bool {
+	terms := [][]v1.NodeSelectorRequirement{nil}
+	if affinity != nil && affinity.NodeAffinity != nil &&
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		if len(required) != 0 {
+			terms = terms[:0]
+		}
+		for _, term := range required {
+			terms = append(terms, term.MatchExpressions)
+		}
+	}
+	for _, topology := range allowed {
+		for _, requirements := range terms {
+			if compatible(topology, nodeSelector, requirements) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compatible returns if the values of each key of the topology intersect with the values the node selector
+// and the requirements allow
+func compatible(topology v1.TopologySelectorTerm, nodeSelector map[string]string,
+	requirements []v1.NodeSelectorRequirement) bool {
+	for _, expression := range topology.MatchLabelExpressions {
+		values := sets.NewString(expression.Values...)
+		if value, ok := nodeSelector[expression.Key]; ok {
+			if !values.Has(value) {
+				return false
+			}
+			values = sets.NewString(value)
+		}
+		for _, r := range requirements {
+			if r.Key != expression.Key {
+				continue
+			}
+			switch r.Operator {
+			case v1.NodeSelectorOpIn:
+				values = values.Intersection(sets.NewString(r.Values...))
+			case v1.NodeSelectorOpNotIn:
+				values = values.Difference(sets.NewString(r.Values...))
+			case v1.NodeSelectorOpDoesNotExist:
+				values = sets.NewString()
+			}
+		}
+		if values.Len() == 0 {
+			return false
+		}
+	}
+	return true
+}
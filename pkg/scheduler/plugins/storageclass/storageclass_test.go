@@ -0,0 +1,177 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storageclass
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFilter(t *testing.T) {
+	zoneA := []v1.TopologySelectorTerm{{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+		{Key: "topology.kubernetes.io/zone", Values: []string{"zone-a"}}}}}
+	data, err := json.Marshal(common.StorageClasses{
+		{Name: "disk", VolumeBindingMode: storagev1.VolumeBindingWaitForFirstConsumer, AllowedTopologies: zoneA},
+		{Name: "nas", VolumeBindingMode: storagev1.VolumeBindingImmediate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes := string(data)
+	withDefault := `[{"name":"nas","default":true}]`
+	virtualNode := func(annotations map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "vk",
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: annotations,
+		}}
+	}
+	pvcPod := func(claim string, nodeSelector map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}, Spec: v1.PodSpec{
+			NodeSelector: nodeSelector,
+			Volumes: []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			}}},
+		}}
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	pvcIndexer := informerFactory.Core().V1().PersistentVolumeClaims().Informer().GetIndexer()
+	for _, name := range []string{"disk", "nas", "ssd"} {
+		sc := name
+		pvcIndexer.Add(&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &sc},
+		})
+	}
+	ssd := "ssd"
+	pvcIndexer.Add(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "bound", Namespace: "default"},
+		Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &ssd, VolumeName: "pv"},
+	})
+	pvcIndexer.Add(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}})
+	plugin := &StorageClass{pvcLister: informerFactory.Core().V1().PersistentVolumeClaims().Lister()}
+
+	cases := []struct {
+		name string
+		node *v1.Node
+		pod  *v1.Pod
+		code framework.Code
+	}{
+		{
+			name: "class exists",
+			node: virtualNode(map[string]string{util.StorageClasses: classes}),
+			pod:  pvcPod("nas", nil),
+			code: framework.Success,
+		},
+		{
+			name: "class not exists",
+			node: virtualNode(map[string]string{util.StorageClasses: classes}),
+			pod:  pvcPod("ssd", nil),
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "bound pvc",
+			node: virtualNode(map[string]string{util.StorageClasses: classes}),
+			pod:  pvcPod("bound", nil),
+			code: framework.Success,
+		},
+		{
+			name: "topology allowed",
+			node: virtualNode(map[string]string{util.StorageClasses: classes}),
+			pod:  pvcPod("disk", map[string]string{"topology.kubernetes.io/zone": "zone-a"}),
+			code: framework.Success,
+		},
+		{
+			name: "topology not allowed",
+			node: virtualNode(map[string]string{util.StorageClasses: classes}),
+			pod:  pvcPod("disk", map[string]string{"topology.kubernetes.io/zone": "zone-b"}),
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "no default class",
+			node: virtualNode(map[string]string{util.StorageClasses: classes}),
+			pod:  pvcPod("default", nil),
+			code: framework.UnschedulableAndUnresolvable,
+		},
+		{
+			name: "default class",
+			node: virtualNode(map[string]string{util.StorageClasses: withDefault}),
+			pod:  pvcPod("default", nil),
+			code: framework.Success,
+		},
+		{
+			name: "classes not published",
+			node: virtualNode(nil),
+			pod:  pvcPod("ssd", nil),
+			code: framework.Success,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			if status := plugin.PreFilter(context.TODO(), state, c.pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter failed: %v", status)
+			}
+			nodeInfo := schedulernodeinfo.NewNodeInfo()
+			nodeInfo.SetNode(c.node)
+			if status := plugin.Filter(context.TODO(), state, c.pod, nodeInfo); status.Code() != c.code {
+				t.Errorf("Desired %v, get %v", c.code, status)
+			}
+		})
+	}
+}
+
+func TestTopologyAllowed(t *testing.T) {
+	allowed := []v1.TopologySelectorTerm{{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+		{Key: "zone", Values: []string{"a", "b"}}}}}
+	affinity := func(operator v1.NodeSelectorOperator, values ...string) *v1.Affinity {
+		return &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: operator, Values: values}}},
+			}},
+		}}
+	}
+	cases := []struct {
+		name     string
+		affinity *v1.Affinity
+		expected bool
+	}{
+		{name: "no affinity", expected: true},
+		{name: "in", affinity: affinity(v1.NodeSelectorOpIn, "b", "c"), expected: true},
+		{name: "not in", affinity: affinity(v1.NodeSelectorOpIn, "c")},
+		{name: "excluded", affinity: affinity(v1.NodeSelectorOpNotIn, "a", "b")},
+		{name: "does not exist", affinity: affinity(v1.NodeSelectorOpDoesNotExist)},
+	}
+	for _, c := range cases {
+		if allowed := topologyAllowed(allowed, nil, c.affinity); allowed != c.expected {
+			t.Errorf("%v: desired %v, get %v", c.name, c.expected, allowed)
+		}
+	}
+}
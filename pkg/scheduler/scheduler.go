@@ -0,0 +1,71 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"github.com/spf13/cobra"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterfit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterquota"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterspread"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/csidriver"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/hostantiaffinity"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/networkzone"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/nodetaints"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/offloadpolicy"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/overcommit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/schedulinggates"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/storagecapacity"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/storageclass"
+)
+
+// Registry returns the plugins of tensile-kube by name, shared by the multi-cluster scheduler and the extender
+func Registry() framework.Registry {
+	return framework.Registry{
+		overcommit.Name:       overcommit.New,
+		clusterfit.Name:       clusterfit.New,
+		csidriver.Name:        csidriver.New,
+		storagecapacity.Name:  storagecapacity.New,
+		storageclass.Name:     storageclass.New,
+		schedulinggates.Name:  schedulinggates.New,
+		networkzone.Name:      networkzone.New,
+		offloadpolicy.Name:    offloadpolicy.New,
+		clusterspread.Name:    clusterspread.New,
+		clusterquota.Name:     clusterquota.New,
+		nodetaints.Name:       nodetaints.New,
+		hostantiaffinity.Name: hostantiaffinity.New,
+	}
+}
+
+// Plugins returns the options registering the plugins of tensile-kube in the out-of-tree registry of
+// kube-scheduler, the plugins take effect once enabled in a profile of the scheduler config
+func Plugins() []app.Option {
+	var options []app.Option
+	for name, factory := range Registry() {
+		options = append(options, app.WithPlugin(name, factory))
+	}
+	return options
+}
+
+// NewSchedulerCommand returns the multi-cluster scheduler command with the plugins of tensile-kube and the
+// plugins registered by registryOptions, so that downstream users could build a scheduler with their own
+// plugins by app.WithPlugin, args of plugins are passed by pluginConfig of the scheduler config
+func NewSchedulerCommand(registryOptions ...app.Option) *cobra.Command {
+	return app.NewSchedulerCommand(append(Plugins(), registryOptions...)...)
+}
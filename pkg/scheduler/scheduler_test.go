@@ -0,0 +1,48 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"testing"
+
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/clusterfit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/csidriver"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/overcommit"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/schedulinggates"
+	"github.com/virtual-kubelet/tensile-kube/pkg/scheduler/plugins/storagecapacity"
+)
+
+func TestPlugins(t *testing.T) {
+	registry := framework.Registry{}
+	for _, option := range Plugins() {
+		if err := option(registry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{overcommit.Name, clusterfit.Name, csidriver.Name, storagecapacity.Name,
+		schedulinggates.Name} {
+		if _, ok := registry[name]; !ok {
+			t.Fatalf("desire plugin %v registered, real %v", name, registry)
+		}
+	}
+	// plugins of downstream users could not take the names of tensile-kube
+	if err := registry.Register(overcommit.Name, overcommit.New); err == nil {
+		t.Fatal("desire error registering a plugin of the same name")
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package translation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Request is written to the stdin of exec hooks in json, the rewritten pod is read from the stdout
+type Request struct {
+	// UpperPod is the pod in the upper cluster
+	UpperPod *corev1.Pod `json:"upperPod"`
+	// Pod is the pod converted to be created in the lower cluster
+	Pod *corev1.Pod `json:"pod"`
+}
+
+// execHook runs an executable to rewrite pods
+type execHook struct {
+	path    string
+	timeout time.Duration
+}
+
+// NewExecHook returns a hook running the executable of path with a Request in json as stdin, the executable
+// should print the rewritten pod in json and exit 0, otherwise the creation of the pod fails with its stderr
+func NewExecHook(path string, timeout time.Duration) Hook {
+	return &execHook{path: path, timeout: timeout}
+}
+
+// Translate runs the executable
+func (h *execHook) Translate(ctx context.Context, upper, pod *corev1.Pod) (*corev1.Pod, error) {
+	input, err := json.Marshal(Request{UpperPod: upper, Pod: pod})
+	if err != nil {
+		return nil, err
+	}
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("translation hook %v failed: %v: %v", h.path, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	translated := &corev1.Pod{}
+	if err = json.Unmarshal(stdout.Bytes(), translated); err != nil {
+		return nil, fmt.Errorf("translation hook %v returned invalid pod: %v", h.path, err)
+	}
+	return translated, nil
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package translation
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Hook rewrites the pod converted from the upper pod before it is created in the lower cluster,
+// e.g. adding organization specific volumes or envs
+type Hook interface {
+	// Translate returns the rewritten pod, the upper pod and the converted pod should not be modified
+	Translate(ctx context.Context, upper, pod *corev1.Pod) (*corev1.Pod, error)
+}
+
+// Chain runs the hooks in order, each one gets the pod returned by the previous one
+type Chain []Hook
+
+// Translate runs the hooks, the name and namespace of the pod are not allowed to be changed
+func (c Chain) Translate(ctx context.Context, upper, pod *corev1.Pod) (*corev1.Pod, error) {
+	for _, hook := range c {
+		translated, err := hook.Translate(ctx, upper, pod.DeepCopy())
+		if err != nil {
+			return nil, err
+		}
+		if translated == nil {
+			return nil, fmt.Errorf("translation hook returned no pod")
+		}
+		if translated.Name != pod.Name || translated.Namespace != pod.Namespace {
+			return nil, fmt.Errorf("translation hook changed pod %v/%v to %v/%v", pod.Namespace, pod.Name,
+				translated.Namespace, translated.Name)
+		}
+		pod = translated
+	}
+	return pod, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package translation
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeHook(t *testing.T, dir, name, script string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "translation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	upper := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"}}
+	pod := upper.DeepCopy()
+	labelHook := writeHook(t, dir, "label",
+		`cat > /dev/null; echo '{"metadata":{"name":"test","namespace":"default","labels":{"org":"a"}}}'`)
+	renameHook := writeHook(t, dir, "rename",
+		`cat > /dev/null; echo '{"metadata":{"name":"other","namespace":"default"}}'`)
+	failHook := writeHook(t, dir, "fail", `echo "no quota" >&2; exit 1`)
+
+	translated, err := Chain{NewExecHook(labelHook, time.Second)}.Translate(context.TODO(), upper, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translated.Labels["org"] != "a" {
+		t.Fatalf("desire label org=a, real %v", translated.Labels)
+	}
+
+	_, err = Chain{NewExecHook(labelHook, time.Second), NewExecHook(renameHook, time.Second)}.Translate(
+		context.TODO(), upper, pod)
+	if err == nil || !strings.Contains(err.Error(), "changed pod default/test to default/other") {
+		t.Fatalf("desire rename rejected, real %v", err)
+	}
+
+	_, err = Chain{NewExecHook(failHook, time.Second)}.Translate(context.TODO(), upper, pod)
+	if err == nil || !strings.Contains(err.Error(), "no quota") {
+		t.Fatalf("desire stderr of failed hook, real %v", err)
+	}
+}
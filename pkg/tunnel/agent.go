@@ -0,0 +1,147 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tunnel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// AgentConfig is the configuration of the agent
+type AgentConfig struct {
+	// ServerAddress is the address of the tunnel server
+	ServerAddress string
+	// Token is used to authenticate with the tunnel server
+	Token string
+	// Target is the address of the lower apiserver
+	Target string
+	// PoolSize is the number of idle connections kept to the server
+	PoolSize int
+	// TLSConfig is used to connect the server, the system roots are used to verify the server if nil
+	TLSConfig *tls.Config
+}
+
+// Agent keeps idle connections to the tunnel server and connects them to the target once required
+type Agent struct {
+	config AgentConfig
+	dialer *net.Dialer
+}
+
+// NewAgent returns a new agent
+func NewAgent(config AgentConfig) *Agent {
+	if config.PoolSize <= 0 {
+		config.PoolSize = 1
+	}
+	return &Agent{
+		config: config,
+		dialer: &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second},
+	}
+}
+
+// Run keeps the connections to server until stopCh closed
+func (a *Agent) Run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	for i := 0; i < a.config.PoolSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+				if a.serveOne() {
+					continue
+				}
+				select {
+				case <-stopCh:
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// serveOne connects to the server and waits until it is required to open a stream,
+// returns if the stream opened
+func (a *Agent) serveOne() bool {
+	conn, err := a.dial()
+	if err != nil {
+		klog.Errorf("Connect tunnel server %v failed: %v", a.config.ServerAddress, err)
+		return false
+	}
+	if _, err = fmt.Fprintf(conn, "%s%s\n", handshakePrefix, a.config.Token); err != nil {
+		klog.Errorf("Handshake with tunnel server failed: %v", err)
+		conn.Close()
+		return false
+	}
+	b := make([]byte, 1)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		klog.V(4).Infof("Tunnel connection closed: %v", err)
+		conn.Close()
+		return false
+	}
+	if b[0] != openStream {
+		klog.Errorf("Unknown command %v from tunnel server", b[0])
+		conn.Close()
+		return false
+	}
+	go a.pipe(conn)
+	return true
+}
+
+// pipe connects the target and copies data between the tunnel connection and the target
+func (a *Agent) pipe(conn net.Conn) {
+	defer conn.Close()
+	target, err := a.dialer.Dial("tcp", a.config.Target)
+	if err != nil {
+		klog.Errorf("Connect target %v failed: %v", a.config.Target, err)
+		return
+	}
+	defer target.Close()
+	Pipe(conn, target)
+}
+
+// dial connects the server with tls, the token is never sent in plaintext
+func (a *Agent) dial() (net.Conn, error) {
+	config := a.config.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return tls.DialWithDialer(a.dialer, "tcp", a.config.ServerAddress, config)
+}
+
+// Pipe copies data between two connections until either of them is closed
+func Pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+}
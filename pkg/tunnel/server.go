@@ -0,0 +1,225 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tunnel provides a reverse tunnel for lower clusters which can not be
+// connected directly, the agent in the lower cluster dials out to the server, then
+// the connections are used to carry the requests to the lower apiserver.
+package tunnel
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// handshakePrefix is sent by the agent with the token once connected
+	handshakePrefix = "TUNNEL "
+	// openStream is sent by the server to ask the agent to connect the target
+	openStream byte = 1
+	// maxHandshakeLength limits the length of the handshake line
+	maxHandshakeLength = 1024
+	// handshakeTimeout is the timeout of reading the handshake
+	handshakeTimeout = 10 * time.Second
+	// defaultDialTimeout is the timeout waiting for an idle connection of the agent
+	defaultDialTimeout = 30 * time.Second
+)
+
+// Server accepts connections from the agent and hands them out to dial the lower apiserver
+type Server struct {
+	token       string
+	idle        chan net.Conn
+	dialTimeout time.Duration
+}
+
+// NewServer returns a new tunnel server, agents should carry the same token
+func NewServer(token string) *Server {
+	return &Server{
+		token:       token,
+		idle:        make(chan net.Conn, 100),
+		dialTimeout: defaultDialTimeout,
+	}
+}
+
+// ListenAndServe listens on addr with TLS and serves agents until stopCh closed, the token,
+// certFile and keyFile are all required, since the agents carry the credentials of lower cluster
+func (s *Server) ListenAndServe(addr, certFile, keyFile string, stopCh <-chan struct{}) error {
+	if err := ValidateServerConfig(s.token, certFile, keyFile); err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	listener, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stopCh
+		listener.Close()
+	}()
+	return s.Serve(listener)
+}
+
+// ValidateServerConfig checks the token and tls files required to serve agents
+func ValidateServerConfig(token, certFile, keyFile string) error {
+	if len(token) == 0 {
+		return fmt.Errorf("token of tunnel server is required")
+	}
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return fmt.Errorf("cert and key of tunnel server are required")
+	}
+	return nil
+}
+
+// Serve accepts agent connections on listener
+func (s *Server) Serve(listener net.Listener) error {
+	klog.Infof("Tunnel server listening on %v", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handshake(conn)
+	}
+}
+
+// handshake verifies the token of the agent and puts the connection into the idle pool
+func (s *Server) handshake(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	line, err := readLine(conn)
+	if err != nil {
+		klog.Errorf("Read handshake from %v failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	token := strings.TrimPrefix(line, handshakePrefix)
+	if !strings.HasPrefix(line, handshakePrefix) ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		klog.Errorf("Invalid handshake from %v", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	select {
+	case s.idle <- conn:
+		klog.V(5).Infof("Agent connection from %v established", conn.RemoteAddr())
+	default:
+		klog.V(4).Infof("Too many idle agent connections, close %v", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// DialContext returns a connection to the lower apiserver through an idle agent connection,
+// network and address are ignored as the agent always connects its target.
+func (s *Server) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	timer := time.NewTimer(s.dialTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case conn := <-s.idle:
+			if _, err := conn.Write([]byte{openStream}); err != nil {
+				klog.V(4).Infof("Agent connection %v broken: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				continue
+			}
+			return conn, nil
+		case <-timer.C:
+			return nil, fmt.Errorf("no agent connection available for %v", address)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// readLine reads a line byte by byte, so that no data after the line is consumed
+func readLine(conn net.Conn) (string, error) {
+	buf := make([]byte, 0, 64)
+	b := make([]byte, 1)
+	for len(buf) < maxHandshakeLength {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(buf), nil
+		}
+		buf = append(buf, b[0])
+	}
+	return "", fmt.Errorf("handshake too long")
+}
+
+// ServeProxy serves a http CONNECT proxy on listener, connections are dialed through the tunnel,
+// so that the clients which can not set the dialer, e.g. spdy round trippers, can use the tunnel too.
+// Only CONNECT to targets, the addresses of lower apiserver, is relayed.
+func (s *Server) ServeProxy(listener net.Listener, targets ...string) error {
+	klog.Infof("Tunnel proxy listening on %v for %v", listener.Addr(), targets)
+	allowed := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		allowed[target] = true
+	}
+	return http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		if !allowed[r.Host] {
+			http.Error(w, fmt.Sprintf("CONNECT to %v is not allowed", r.Host), http.StatusForbidden)
+			return
+		}
+		s.handleConnect(w, r)
+	}))
+}
+
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, err := s.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		conn.Close()
+		klog.Errorf("Hijack connection for %v failed: %v", r.Host, err)
+		return
+	}
+	go func() {
+		defer conn.Close()
+		defer client.Close()
+		if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+			return
+		}
+		if n := buf.Reader.Buffered(); n > 0 {
+			data, _ := buf.Peek(n)
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+		Pipe(client, conn)
+	}()
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTunnel(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	listener, clientConfig := newTLSListener(t)
+	defer listener.Close()
+	server := NewServer("token")
+	server.dialTimeout = 3 * time.Second
+	go server.Serve(listener)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	badAgent := NewAgent(AgentConfig{ServerAddress: listener.Addr().String(), Token: "bad",
+		Target: target.Addr().String(), TLSConfig: clientConfig})
+	go badAgent.Run(stopCh)
+	agent := NewAgent(AgentConfig{ServerAddress: listener.Addr().String(), Token: "token",
+		Target: target.Addr().String(), PoolSize: 2, TLSConfig: clientConfig})
+	go agent.Run(stopCh)
+
+	for i := 0; i < 3; i++ {
+		conn, err := server.DialContext(context.TODO(), "tcp", "kubernetes:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "hello" {
+			t.Errorf("Desire hello, get %v", string(buf))
+		}
+		conn.Close()
+	}
+}
+
+func TestProxy(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	listener, clientConfig := newTLSListener(t)
+	defer listener.Close()
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyListener.Close()
+	server := NewServer("token")
+	server.dialTimeout = 3 * time.Second
+	go server.Serve(listener)
+	go server.ServeProxy(proxyListener, "kubernetes:443")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go NewAgent(AgentConfig{ServerAddress: listener.Addr().String(), Token: "token",
+		Target: target.Addr().String(), TLSConfig: clientConfig}).Run(stopCh)
+
+	conn, err := net.Dial("tcp", proxyListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write([]byte("CONNECT kubernetes:443 HTTP/1.1\r\nHost: kubernetes:443\r\n\r\nhello")); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Desire status 200, get %v", resp.StatusCode)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(reader, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("Desire hello, get %v", string(buf))
+	}
+
+	other, err := net.Dial("tcp", proxyListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = other.Write([]byte("CONNECT 10.0.0.1:22 HTTP/1.1\r\nHost: 10.0.0.1:22\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.ReadResponse(bufio.NewReader(other), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Desire status 403 for other targets, get %v", resp.StatusCode)
+	}
+}
+
+func TestValidateServerConfig(t *testing.T) {
+	if err := ValidateServerConfig("", "cert", "key"); err == nil {
+		t.Error("Desire error without token")
+	}
+	if err := ValidateServerConfig("token", "", "key"); err == nil {
+		t.Error("Desire error without cert")
+	}
+	if err := ValidateServerConfig("token", "cert", "key"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	server := NewServer("token")
+	server.dialTimeout = 10 * time.Millisecond
+	if _, err := server.DialContext(context.TODO(), "tcp", "kubernetes:443"); err == nil {
+		t.Error("Desire error without agent")
+	}
+}
+
+func TestAgentRequiresTLS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go NewAgent(AgentConfig{ServerAddress: listener.Addr().String(), Token: "token",
+		Target: "127.0.0.1:443"}).Run(stopCh)
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(handshakePrefix))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	// 0x16 is the content type of tls handshake records
+	if buf[0] != 0x16 || string(buf) == handshakePrefix {
+		t.Errorf("Desire tls client hello, get %q", buf)
+	}
+}
+
+// newTLSListener returns a tls listener with a self-signed certificate and the config trusting it
+func newTLSListener(t *testing.T) (net.Listener, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tunnel"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return listener, &tls.Config{RootCAs: pool}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clustercache provides the indexers of informers shared by provider, descheduler and scheduler
+// plugins, so that objects of a member cluster, an upper namespace, an owner or a kind are looked up by
+// index instead of scanning the whole store
+package clustercache
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// ClusterIndex indexes pods by the node they are bound to, in the upper cluster the node is the virtual
+	// node of the member cluster the pod runs in, unbound pods are indexed by empty string
+	ClusterIndex = "cluster"
+	// UpperNamespaceIndex indexes objects by the namespace of the upper object, lower objects are indexed
+	// by label util.OriginNamespace if set
+	UpperNamespaceIndex = "upperNamespace"
+	// OwnerUIDIndex indexes objects by the uids of their owners
+	OwnerUIDIndex = "ownerUID"
+	// KindIndex indexes objects by their kinds, for stores caching objects of several kinds
+	KindIndex = "kind"
+)
+
+var indexFuncs = cache.Indexers{
+	cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+	ClusterIndex:         ClusterIndexFunc,
+	UpperNamespaceIndex:  UpperNamespaceIndexFunc,
+	OwnerUIDIndex:        OwnerUIDIndexFunc,
+	KindIndex:            KindIndexFunc,
+}
+
+// Indexers returns all the indexers of clustercache besides the namespace index
+func Indexers() cache.Indexers {
+	indexers := make(cache.Indexers, len(indexFuncs))
+	for name, f := range indexFuncs {
+		indexers[name] = f
+	}
+	return indexers
+}
+
+// ClusterIndexFunc returns the node name of pods, other objects are not indexed
+func ClusterIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// UpperNamespaceIndexFunc returns the namespace of the upper object
+func UpperNamespaceIndexFunc(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	if ns := accessor.GetLabels()[util.OriginNamespace]; len(ns) != 0 {
+		return []string{ns}, nil
+	}
+	return []string{accessor.GetNamespace()}, nil
+}
+
+// OwnerUIDIndexFunc returns the uids of the owners of object
+func OwnerUIDIndexFunc(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	owners := accessor.GetOwnerReferences()
+	uids := make([]string, 0, len(owners))
+	for _, owner := range owners {
+		uids = append(uids, string(owner.UID))
+	}
+	return uids, nil
+}
+
+// KindIndexFunc returns the kind of object, objects of informers have no type meta so the kind is looked
+// up from the scheme
+func KindIndexFunc(obj interface{}) ([]string, error) {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("object %T is not a runtime object", obj)
+	}
+	if kind := object.GetObjectKind().GroupVersionKind().Kind; len(kind) != 0 {
+		return []string{kind}, nil
+	}
+	gvks, _, err := scheme.Scheme.ObjectKinds(object)
+	if err != nil {
+		return nil, err
+	}
+	return []string{gvks[0].Kind}, nil
+}
+
+// ByIndex returns the objects whose index matches value. Informers shared by factories may be built
+// without the indexers of clustercache, the store is scanned with the index func then
+func ByIndex(indexer cache.Indexer, index, value string) ([]interface{}, error) {
+	if _, ok := indexer.GetIndexers()[index]; ok {
+		return indexer.ByIndex(index, value)
+	}
+	f, ok := indexFuncs[index]
+	if !ok {
+		return nil, fmt.Errorf("index %v does not exist", index)
+	}
+	var objs []interface{}
+	for _, obj := range indexer.List() {
+		values, err := f(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if v == value {
+				objs = append(objs, obj)
+				break
+			}
+		}
+	}
+	return objs, nil
+}
+
+// Pods returns the pods whose index matches value
+func Pods(indexer cache.Indexer, index, value string) ([]*corev1.Pod, error) {
+	objs, err := ByIndex(indexer, index, value)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*corev1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		if pod, ok := obj.(*corev1.Pod); ok {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clustercache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func testPods() []*corev1.Pod {
+	owner := metav1.OwnerReference{Kind: "ReplicaSet", Name: "rs", UID: "rs-uid"}
+	return []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "ns", OwnerReferences: []metav1.OwnerReference{owner}},
+			Spec:       corev1.PodSpec{NodeName: "cluster1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "p2", Namespace: "lower-ns",
+				Labels: map[string]string{util.OriginNamespace: "ns"}},
+			Spec: corev1.PodSpec{NodeName: "cluster2"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "p3", Namespace: "other", OwnerReferences: []metav1.OwnerReference{owner}},
+		},
+	}
+}
+
+func names(pods []*corev1.Pod) []string {
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPods(t *testing.T) {
+	indexed := cache.NewIndexer(cache.MetaNamespaceKeyFunc, Indexers())
+	// stores of informers built without the indexers are scanned
+	scanned := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pod := range testPods() {
+		indexed.Add(pod)
+		scanned.Add(pod)
+	}
+	for _, c := range []struct {
+		index  string
+		value  string
+		desire []string
+	}{
+		{index: ClusterIndex, value: "cluster1", desire: []string{"p1"}},
+		{index: ClusterIndex, value: "", desire: []string{"p3"}},
+		{index: UpperNamespaceIndex, value: "ns", desire: []string{"p1", "p2"}},
+		{index: OwnerUIDIndex, value: "rs-uid", desire: []string{"p1", "p3"}},
+		{index: KindIndex, value: "Pod", desire: []string{"p1", "p2", "p3"}},
+		{index: KindIndex, value: "Node"},
+	} {
+		for name, indexer := range map[string]cache.Indexer{"indexed": indexed, "scanned": scanned} {
+			pods, err := Pods(indexer, c.index, c.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if real := names(pods); !reflect.DeepEqual(real, c.desire) {
+				t.Fatalf("%v %v=%v: desire %v, real %v", name, c.index, c.value, c.desire, real)
+			}
+		}
+	}
+	if _, err := Pods(scanned, "unknown", ""); err == nil {
+		t.Fatal("desire error for unknown index")
+	}
+}
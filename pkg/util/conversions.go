@@ -87,23 +87,21 @@ func trimContainers(containers []corev1.Container) []corev1.Container {
 // GetUpdatedPod allows user to update image, label, annotations
 // for tolerations, we can only add some more.
 func GetUpdatedPod(orig, update *corev1.Pod, ignoreLabels []string) {
-	for i := range orig.Spec.InitContainers {
-		orig.Spec.InitContainers[i].Image = update.Spec.InitContainers[i].Image
-	}
-	for i := range orig.Spec.Containers {
-		orig.Spec.Containers[i].Image = update.Spec.Containers[i].Image
-	}
-	if update.Annotations == nil {
-		update.Annotations = make(map[string]string)
-	}
+	// containers are matched by name, the ones only in orig, e.g. sidecars injected into the lower pod, are kept
+	updateImages(orig.Spec.InitContainers, update.Spec.InitContainers)
+	updateImages(orig.Spec.Containers, update.Spec.Containers)
 	if orig.Annotations[SelectorKey] != update.Annotations[SelectorKey] {
 		if cns := ConvertAnnotations(update.Annotations); cns != nil {
-			// we assume tolerations would only add not remove
-			orig.Spec.Tolerations = cns.Tolerations
+			// tolerations could not be removed, the ones of orig are kept
+			orig.Spec.Tolerations = addTolerations(orig.Spec.Tolerations, cns.Tolerations)
 		}
 	}
-	orig.Labels = update.Labels
-	orig.Annotations = update.Annotations
+	// the maps are copied, the update is usually the pod in the informer cache of the upper cluster
+	orig.Labels = copyMap(update.Labels)
+	orig.Annotations = copyMap(update.Annotations)
+	if orig.Annotations == nil {
+		orig.Annotations = make(map[string]string)
+	}
 	orig.Spec.ActiveDeadlineSeconds = update.Spec.ActiveDeadlineSeconds
 	if orig.Labels != nil {
 		trimLabels(orig.ObjectMeta.Labels, ignoreLabels)
@@ -111,6 +109,35 @@ func GetUpdatedPod(orig, update *corev1.Pod, ignoreLabels []string) {
 	return
 }
 
+func updateImages(containers, update []corev1.Container) {
+	images := make(map[string]string, len(update))
+	for _, c := range update {
+		images[c.Name] = c.Image
+	}
+	for i := range containers {
+		if image, ok := images[containers[i].Name]; ok {
+			containers[i].Image = image
+		}
+	}
+}
+
+// addTolerations appends the tolerations not matched by any of current
+func addTolerations(current, tolerations []corev1.Toleration) []corev1.Toleration {
+	for i := range tolerations {
+		found := false
+		for j := range current {
+			if current[j].MatchToleration(&tolerations[i]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			current = append(current, tolerations[i])
+		}
+	}
+	return current
+}
+
 // TrimObjectMeta removes some fields of ObjectMeta
 func TrimObjectMeta(meta *metav1.ObjectMeta) {
 	meta.UID = ""
@@ -207,3 +234,14 @@ func ConvertAnnotations(annotation map[string]string) *ClustersNodeSelection {
 	}
 	return &cns
 }
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
@@ -17,6 +17,7 @@
 package util
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
@@ -151,3 +152,58 @@ func TestRecoverLabels(t *testing.T) {
 	t.Logf("%v %v", oldLabels, labels)
 
 }
+
+func TestGetUpdatedPodCopiesMaps(t *testing.T) {
+	update := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Labels:      map[string]string{"app": "test"},
+			Annotations: map[string]string{"a": "b"},
+		},
+	}
+	orig := &corev1.Pod{}
+	GetUpdatedPod(orig, update, nil)
+	orig.Labels["lower"] = "true"
+	orig.Annotations["lower"] = "true"
+	if len(update.Labels) != 1 || len(update.Annotations) != 1 {
+		t.Fatalf("Desire upper pod unchanged, get labels %v annotations %v", update.Labels, update.Annotations)
+	}
+
+	update = &corev1.Pod{}
+	GetUpdatedPod(orig, update, nil)
+	if update.Annotations != nil || orig.Annotations == nil {
+		t.Fatalf("Desire annotations only initialized on the original pod, get %v %v",
+			update.Annotations, orig.Annotations)
+	}
+}
+
+func TestGetUpdatedPodKeepsLowerFields(t *testing.T) {
+	tolerate := func(key string) corev1.Toleration {
+		return corev1.Toleration{Key: key, Operator: corev1.TolerationOpExists}
+	}
+	cns, err := json.Marshal(ClustersNodeSelection{Tolerations: []corev1.Toleration{tolerate("a"), tolerate("b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "istio-init", Image: "proxy:1"}, {Name: "init", Image: "init:1"}},
+		Containers:     []corev1.Container{{Name: "app", Image: "app:1"}, {Name: "istio-proxy", Image: "proxy:1"}},
+		Tolerations:    []corev1.Toleration{tolerate("a"), tolerate("lower")},
+	}}
+	update := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{SelectorKey: string(cns)}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: "init:2"}},
+			Containers:     []corev1.Container{{Name: "app", Image: "app:2"}},
+		},
+	}
+	GetUpdatedPod(orig, update, nil)
+	if orig.Spec.InitContainers[0].Image != "proxy:1" || orig.Spec.InitContainers[1].Image != "init:2" ||
+		orig.Spec.Containers[0].Image != "app:2" || orig.Spec.Containers[1].Image != "proxy:1" {
+		t.Fatalf("Desire images updated by name with sidecars kept, get %+v %+v", orig.Spec.InitContainers,
+			orig.Spec.Containers)
+	}
+	expected := []corev1.Toleration{tolerate("a"), tolerate("lower"), tolerate("b")}
+	if !reflect.DeepEqual(orig.Spec.Tolerations, expected) {
+		t.Fatalf("Desire tolerations %v, get %v", expected, orig.Spec.Tolerations)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+// EndpointFailover sends the requests of kube clients to a healthy endpoint of
+// the apiservers of a cluster, endpoints are switched when the active one fails
+type EndpointFailover struct {
+	sync.RWMutex
+	endpoints []*url.URL
+	active    int
+	// transport is the transport without endpoint rewriting, used by health checking
+	transport http.RoundTripper
+}
+
+// NewEndpointFailover returns a new EndpointFailover, the first endpoint is active by default
+func NewEndpointFailover(endpoints []string) (*EndpointFailover, error) {
+	f := &EndpointFailover{}
+	for _, endpoint := range endpoints {
+		if len(endpoint) == 0 {
+			continue
+		}
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %v: %v", endpoint, err)
+		}
+		if f.contains(u) {
+			continue
+		}
+		f.endpoints = append(f.endpoints, u)
+	}
+	if len(f.endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoint found")
+	}
+	return f, nil
+}
+
+// contains returns if the endpoint has been added
+func (f *EndpointFailover) contains(endpoint *url.URL) bool {
+	for _, u := range f.endpoints {
+		if u.Scheme == endpoint.Scheme && u.Host == endpoint.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfigHost returns the apiserver of the kubeconfig, in-cluster config is used if configPath is empty
+func GetConfigHost(configPath string) (string, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", configPath)
+	if err != nil {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return "", err
+		}
+	}
+	return config.Host, nil
+}
+
+// FailoverOpts returns an Opts wraps the transport of a rest config with the failover
+func FailoverOpts(f *EndpointFailover) Opts {
+	return func(config *rest.Config) {
+		config.Wrap(f.WrapTransport)
+	}
+}
+
+// WrapTransport wraps the round tripper to rewrite the host of requests to the active endpoint
+func (f *EndpointFailover) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	f.Lock()
+	if f.transport == nil {
+		f.transport = rt
+	}
+	f.Unlock()
+	return &failoverRoundTripper{failover: f, delegate: rt}
+}
+
+// Active returns the active endpoint
+func (f *EndpointFailover) Active() *url.URL {
+	f.RLock()
+	defer f.RUnlock()
+	return f.endpoints[f.active]
+}
+
+// Run checks the health of the active endpoint periodically and switches to
+// another healthy one if it fails
+func (f *EndpointFailover) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		active := f.Active()
+		if f.healthy(active) {
+			return
+		}
+		f.switchFrom(active)
+	}, period, stopCh)
+}
+
+// switchFrom switches the active endpoint to the next healthy one if endpoint is still active
+func (f *EndpointFailover) switchFrom(endpoint *url.URL) {
+	f.RLock()
+	endpoints := f.endpoints
+	f.RUnlock()
+	for i := range endpoints {
+		candidate := endpoints[i]
+		if candidate == endpoint || !f.healthy(candidate) {
+			continue
+		}
+		f.Lock()
+		if f.endpoints[f.active] == endpoint {
+			klog.Warningf("Endpoint %v unhealthy, switch to %v", endpoint.Host, candidate.Host)
+			f.active = i
+		}
+		f.Unlock()
+		return
+	}
+	klog.Errorf("No healthy endpoint found, keep %v active", endpoint.Host)
+}
+
+// healthy checks if the apiserver of the endpoint is reachable, any response
+// except server errors means the apiserver is serving
+func (f *EndpointFailover) healthy(endpoint *url.URL) bool {
+	f.RLock()
+	transport := f.transport
+	f.RUnlock()
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	resp, err := client.Get(endpoint.Scheme + "://" + endpoint.Host + "/healthz")
+	if err != nil {
+		klog.V(4).Infof("Check endpoint %v failed: %v", endpoint.Host, err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+type failoverRoundTripper struct {
+	failover *EndpointFailover
+	delegate http.RoundTripper
+}
+
+// RoundTrip sends the request to the active endpoint, the endpoint would be switched
+// if it can not be connected
+func (rt *failoverRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := rt.failover.Active()
+	r := utilnet.CloneRequest(req)
+	r.URL.Scheme = endpoint.Scheme
+	r.URL.Host = endpoint.Host
+	r.Host = endpoint.Host
+	resp, err := rt.delegate.RoundTrip(r)
+	if err != nil && (utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err)) {
+		go rt.failover.switchFrom(endpoint)
+	}
+	return resp, err
+}
+
+// WrappedRoundTripper returns the delegate round tripper
+func (rt *failoverRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.delegate
+}
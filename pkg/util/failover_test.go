@@ -0,0 +1,81 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	broken.Close()
+
+	f, err := NewEndpointFailover([]string{broken.URL, healthy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: f.WrapTransport(http.DefaultTransport)}
+	if _, err := client.Get(broken.URL + "/api"); err == nil {
+		t.Fatal("Desire error from broken endpoint")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go f.Run(10*time.Millisecond, stopCh)
+	deadline := time.Now().Add(3 * time.Second)
+	for f.Active().Host != healthy.Listener.Addr().String() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Desire active endpoint %v, get %v", healthy.URL, f.Active())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err := client.Get(broken.URL + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Desire status 200, get %v", resp.StatusCode)
+	}
+}
+
+func TestNewEndpointFailover(t *testing.T) {
+	if _, err := NewEndpointFailover([]string{""}); err == nil {
+		t.Error("Desire error for empty endpoints")
+	}
+	f, err := NewEndpointFailover([]string{"10.0.0.1:6443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active := f.Active(); active.Scheme != "https" || active.Host != "10.0.0.1:6443" {
+		t.Errorf("Unexpected endpoint %v", active)
+	}
+	f, err = NewEndpointFailover([]string{"10.0.0.1:6443", "https://10.0.0.1:6443", "10.0.0.2:6443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.endpoints) != 2 {
+		t.Errorf("Desire duplicated endpoints removed, get %v", f.endpoints)
+	}
+}
@@ -19,14 +19,23 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	jsonpatch1 "github.com/mattbaird/jsonpatch"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	// load auth providers, e.g. oidc, gcp, azure, so that kubeconfigs of clusters could use them,
+	// exec credential plugins are supported by client-go without any registration
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
@@ -39,6 +48,12 @@ const (
 	SelectorKey = "clusterSelector"
 	// SelectedNodeKey is the node selected by a scheduler
 	SelectedNodeKey = "volume.kubernetes.io/selected-node"
+	// BindCompletedKey marks a pvc bound by the pv controller
+	BindCompletedKey = "pv.kubernetes.io/bind-completed"
+	// BoundByControllerKey marks a pvc or pv bound by the pv controller rather than the user
+	BoundByControllerKey = "pv.kubernetes.io/bound-by-controller"
+	// StorageProvisionerKey is the provisioner chosen for a pvc by the pv controller
+	StorageProvisionerKey = "volume.beta.kubernetes.io/storage-provisioner"
 	// HostNameKey is the label of HostNameKey
 	HostNameKey = "kubernetes.io/hostname"
 	// BetaHostNameKey is the label of HostNameKey
@@ -71,6 +86,167 @@ const (
 	CreatedbyDescheduler = "create-by-descheduler"
 	// DescheduleCount is used for recording deschedule count
 	DescheduleCount = "sigs.k8s.io/deschedule-count"
+	// CPUOvercommitRatio is the annotation of virtual node recording the cpu overcommit ratio of the cluster
+	CPUOvercommitRatio = "tensile-kube.io/cpu-overcommit-ratio"
+	// FitSummary is the annotation of virtual node recording the free resources summary of nodes in the cluster
+	FitSummary = "tensile-kube.io/fit-summary"
+	// CSIDrivers is the annotation of virtual node recording the CSI drivers installed in the cluster
+	CSIDrivers = "tensile-kube.io/csi-drivers"
+	// StorageCapacity is the annotation of virtual node recording the storage capacity of each storage class
+	// in the topology segments of the cluster
+	StorageCapacity = "tensile-kube.io/storage-capacity"
+	// StorageClasses is the annotation of virtual node recording the storage classes of the cluster, their
+	// binding modes and allowed topologies
+	StorageClasses = "tensile-kube.io/storage-classes"
+	// StorageClassLabelPrefix prefixes the labels of virtual node advertising the storage classes of the cluster,
+	// the value is the volume binding mode
+	StorageClassLabelPrefix = "storageclass.tensile-kube.io/"
+	// QuotaHeadroom is the annotation of virtual node recording the resource quotas left in each namespace of the
+	// cluster
+	QuotaHeadroom = "tensile-kube.io/quota-headroom"
+	// PodDeletionCost is the annotation of pod telling ReplicaSet controller the cost of deleting it
+	PodDeletionCost = "controller.kubernetes.io/pod-deletion-cost"
+	// VirtualNodeTaintKey is the default key of the taint of virtual nodes
+	VirtualNodeTaintKey = "virtual-kubelet.io/provider"
+	// ReclamationTaint is the taint of lower node telling it is going to be reclaimed, besides the taints
+	// put by cloud termination handlers
+	ReclamationTaint = "tensile-kube.io/reclaiming"
+	// Reclaiming is the annotation of upper pod recording the reclamation taint of the lower node it runs on
+	Reclaiming = "tensile-kube.io/node-reclaiming"
+	// ImpersonateUser is the annotation of upper namespace recording the user to impersonate in lower cluster
+	ImpersonateUser = "tensile-kube.io/impersonate-user"
+	// ImpersonateGroups is the annotation of upper namespace recording the groups to impersonate, seperated by comma
+	ImpersonateGroups = "tensile-kube.io/impersonate-groups"
+	// UpperPodUID is the annotation of lower pod recording the uid of the upper pod it is created for
+	UpperPodUID = "tensile-kube.io/upper-pod-uid"
+	// LastAppliedState is the annotation of lower pod recording the labels, annotations and images last applied
+	// from the upper pod, the base of the three-way merge of updates
+	LastAppliedState = "tensile-kube.io/last-applied-state"
+	// MemoryOvercommitRatio is the annotation of virtual node recording the memory overcommit ratio of the cluster
+	MemoryOvercommitRatio = "tensile-kube.io/memory-overcommit-ratio"
+	// PhysicalCapacity is the annotation of virtual node recording the cpu and memory capacity of the cluster
+	// without the overcommit ratios applied
+	PhysicalCapacity = "tensile-kube.io/physical-capacity"
+	// PhysicalUsage is the annotation of virtual node recording the cpu and memory usage of the nodes counted in
+	// PhysicalCapacity, reported by metrics-server of the cluster
+	PhysicalUsage = "tensile-kube.io/physical-usage"
+	// ReservedResources is the annotation of virtual node recording the requests of pods created in the lower
+	// cluster directly, which are kept back from the capacity of the virtual node
+	ReservedResources = "tensile-kube.io/reserved-resources"
+	// DelegateHPA is the annotation of upper hpa telling the workload is entirely delegated to lower clusters,
+	// so the hpa would be mirrored into lower clusters by HPAControllers
+	DelegateHPA = "tensile-kube.io/delegate"
+	// HPAStatusPrefix is the prefix of annotations of upper hpa recording the hpa status in each lower cluster
+	HPAStatusPrefix = "tensile-kube.io/hpa-status-"
+	// HPAAggregatedStatus is the annotation of upper hpa recording the hpa status aggregated from all lower clusters
+	HPAAggregatedStatus = "tensile-kube.io/hpa-aggregated-status"
+	// PDBStatusPrefix is the prefix of annotations of upper pdb recording the pdb status in each lower cluster
+	PDBStatusPrefix = "tensile-kube.io/pdb-status-"
+	// DisruptionsAllowed is the annotation of upper pdb recording the disruptions allowed in all lower clusters
+	DisruptionsAllowed = "tensile-kube.io/disruptions-allowed"
+	// ClusterName is the annotation of lower pod recording the name of the lower cluster it runs in, and the
+	// label of virtual node naming its lower cluster
+	ClusterName = "tensile-kube.io/cluster-name"
+	// PodMapping is the label of the configMaps of upper cluster persisting the pods mapped to a lower cluster,
+	// with the cluster in ClusterName
+	PodMapping = "tensile-kube.io/pod-mapping"
+	// ClusterRegion is the annotation of lower pod recording the region of the lower cluster it runs in
+	ClusterRegion = "tensile-kube.io/cluster-region"
+	// ClusterNameEnv is the env injected by webhook exposing ClusterName to containers
+	ClusterNameEnv = "TENSILE_CLUSTER_NAME"
+	// ClusterRegionEnv is the env injected by webhook exposing ClusterRegion to containers
+	ClusterRegionEnv = "TENSILE_CLUSTER_REGION"
+	// OriginCluster is the label of lower pod recording the name of the upper cluster it is created from
+	OriginCluster = "tensile-kube.io/origin-cluster"
+	// OriginNamespace is the label of lower pod recording the namespace of the upper pod
+	OriginNamespace = "tensile-kube.io/origin-namespace"
+	// OriginPodUID is the label of lower pod recording the uid of the upper pod
+	OriginPodUID = "tensile-kube.io/origin-pod-uid"
+	// ClusterTaints is the annotation of virtual node recording the taints declared for the cluster, so that
+	// the taints no longer declared could be told from the ones put by others
+	ClusterTaints = "tensile-kube.io/cluster-taints"
+	// PropagatedMetadata is the annotation of virtual node recording the labels and annotations propagated from
+	// lower nodes, so that the ones no longer propagated could be removed
+	PropagatedMetadata = "tensile-kube.io/propagated-metadata"
+	// SchedulingGates is the annotation of pod listing the gates separated by comma, the pod would not be
+	// scheduled until all of the gates are removed by the controllers owning them
+	SchedulingGates = "tensile-kube.io/scheduling-gates"
+	// UpperResources is the annotation of lower pod recording the resources of containers of the upper pod
+	// last resized to
+	UpperResources = "tensile-kube.io/upper-resources"
+	// ResizeStatus is the annotation of upper pod reflecting the in-place resize status of the lower pod
+	ResizeStatus = "tensile-kube.io/resize-status"
+	// FeatureLabelPrefix is the prefix of labels of virtual node telling the features supported by the cluster
+	FeatureLabelPrefix = "feature.tensile-kube.io/"
+	// ManagedBy is the label of virtual nodes of members recording the virtual node of the process hosting them
+	ManagedBy = "tensile-kube.io/managed-by"
+	// NetworkZone is the label of virtual node telling the network zone of the cluster
+	NetworkZone = "tensile-kube.io/network-zone"
+	// NetworkDependencies is the annotation of pod, a label selector of the pods in the same namespace the pod
+	// talks to, the scheduler prefers the clusters in the network zones close to them
+	NetworkDependencies = "tensile-kube.io/network-dependencies"
+	// EndpointSliceManager is the value of the managed-by label of EndpointSlices synced between clusters
+	EndpointSliceManager = "tensile-kube.io"
+	// ImportEndpoints is the annotation of upper service telling the EndpointSlices of the service in lower
+	// clusters should be imported into the upper cluster
+	ImportEndpoints = "tensile-kube.io/import-endpoints"
+	// ImportedFrom is the annotation of upper EndpointSlice recording the virtual node it is imported by
+	ImportedFrom = "tensile-kube.io/imported-from"
+	// UpperTokenLabel is the label of lower secrets holding the service account tokens requested from the upper
+	// cluster for pods
+	UpperTokenLabel = "tensile-kube.io/upper-token"
+	// UpperTokens is the annotation of the lower secret of upper tokens recording how they are requested
+	UpperTokens = "tensile-kube.io/upper-tokens"
+	// SchedulingFailure is the annotation of upper pod telling why it is failed and rescheduled, e.g. it is
+	// unschedulable in the lower cluster too long
+	SchedulingFailure = "tensile-kube.io/scheduling-failure"
+	// UnfitPods is the annotation of virtual node recording until when the cluster is unfit for the pods of each
+	// scheduling key, see SchedulingKey
+	UnfitPods = "tensile-kube.io/unfit-pods"
+	// Drain is the annotation of virtual node requesting the virtual node to be drained and deleted if "true"
+	Drain = "tensile-kube.io/drain"
+	// ReachableAddress is the annotation of upper pod recording the address it is reachable at from the upper
+	// cluster, translated by the virtual node, in json
+	ReachableAddress = "tensile-kube.io/reachable-address"
+	// CustomDependencies is the annotation of pods listing the custom resource objects they depend on, separated
+	// by comma, each in <resource>.<version>.<group>/<name>, mirrored into lower clusters before the pods
+	CustomDependencies = "tensile-kube.io/custom-dependencies"
+	// PrePullImages is the annotation of pods whose images are pre-pulled in lower clusters if "true"
+	PrePullImages = "tensile-kube.io/pre-pull-images"
+	// ImagePrePull is the label of the DaemonSets pulling images in lower clusters and their pods, the name of
+	// the DaemonSet
+	ImagePrePull = "tensile-kube.io/image-pre-pull"
+	// EvictPending is the annotation of pods opting out of the descheduler strategy PendingTimeout if "false",
+	// e.g. set in the pod template of a workload pulling huge images
+	EvictPending = "tensile-kube.io/evict-pending"
+	// NodeTaints is the annotation of virtual node recording the distinct sets of taints of the ready and
+	// schedulable nodes in the cluster, see common.NodeTaints
+	NodeTaints = "tensile-kube.io/node-taints"
+	// AddressOf is the label of lower pods and the services exposing them recording the uid of the upper pod
+	AddressOf = "tensile-kube.io/address-of"
+	// EventSourceCluster is the annotation of events mirrored from lower pods recording the lower cluster
+	EventSourceCluster = "tensile-kube.io/event-source-cluster"
+	// EventSourceComponent is the annotation of events mirrored from lower pods recording the component
+	// reporting the lower event, e.g. kubelet
+	EventSourceComponent = "tensile-kube.io/event-source-component"
+	// EventSourceHost is the annotation of events mirrored from lower pods recording the lower node the lower
+	// event is reported from
+	EventSourceHost = "tensile-kube.io/event-source-host"
+	// LowerNode is the annotation of upper pod recording the node of the lower cluster it runs on, so that
+	// schedulers evaluate the pod anti-affinity keyed on hostname against lower nodes instead of virtual nodes
+	LowerNode = "tensile-kube.io/lower-node"
+	// SchedulableNodes is the annotation of virtual node recording the number of ready and schedulable nodes
+	// in the cluster
+	SchedulableNodes = "tensile-kube.io/schedulable-nodes"
+	// PodFinalizer is the finalizer of upper pods held by the virtual node until their lower pods are gone, so
+	// that upper pods are not removed while the lower pods still terminate
+	PodFinalizer = "tensile-kube.io/lower-pod-termination"
+	// PreferredNodes is the annotation of pods listing the virtual nodes preferred, separated by comma, set by the
+	// webhook by the cluster selection policy or by users, preferred by node affinity of the pods
+	PreferredNodes = "tensile-kube.io/preferred-nodes"
+	// ResyncRequested is the annotation of upper pod set by tensilectl resync, the change of it makes virtual
+	// kubelet sync the pod to the lower cluster again
+	ResyncRequested = "tensile-kube.io/resync-requested"
 )
 
 // ClustersNodeSelection is a struct including some scheduling parameters
@@ -142,6 +318,33 @@ func SetupSignalHandler() <-chan struct{} {
 // Opts define the ops parameter functions
 type Opts func(*rest.Config)
 
+// ListConfigFiles returns the kubeconfig files of the paths, the files in a directory are listed in order
+// and the hidden ones are skipped, e.g. "..data" of mounted secrets
+func ListConfigFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
 // NewClient returns a new client for k8s
 func NewClient(configPath string, opts ...Opts) (kubernetes.Interface, error) {
 	// master config, maybe a real node or a pod
@@ -171,6 +374,30 @@ func NewClient(configPath string, opts ...Opts) (kubernetes.Interface, error) {
 	return client, nil
 }
 
+// NewDynamicClient returns a dynamic client of the cluster, in-cluster config is used if configPath is invalid
+func NewDynamicClient(configPath string, opts ...Opts) (dynamic.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", configPath)
+	if err != nil {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file for cluster: %v", err)
+		}
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		opt(config)
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client for cluster: %v", err)
+	}
+	return client, nil
+}
+
 // NewMetricClient returns a new client for k8s
 func NewMetricClient(configPath string, opts ...Opts) (versioned.Interface, error) {
 	// master config, maybe a real node or a pod
@@ -220,6 +447,41 @@ func IsVirtualPod(pod *corev1.Pod) bool {
 	return false
 }
 
+// SchedulingKey returns the key of the pods sharing a spec, the uid of their controller, or the uid of the pod
+// if it has no controller
+func SchedulingKey(pod *corev1.Pod) string {
+	if ref := metav1.GetControllerOf(pod); ref != nil {
+		return string(ref.UID)
+	}
+	return string(pod.UID)
+}
+
+// GetSchedulingGates returns the scheduling gates of pod, empty names are ignored
+func GetSchedulingGates(pod *corev1.Pod) []string {
+	if pod == nil || len(pod.Annotations[SchedulingGates]) == 0 {
+		return nil
+	}
+	var gates []string
+	for _, gate := range strings.Split(pod.Annotations[SchedulingGates], ",") {
+		if gate = strings.TrimSpace(gate); len(gate) != 0 {
+			gates = append(gates, gate)
+		}
+	}
+	return gates
+}
+
+// GetNetworkDependencies returns the selector of the pods the pod depends on, nil if not declared
+func GetNetworkDependencies(pod *corev1.Pod) (labels.Selector, error) {
+	if pod == nil || len(strings.TrimSpace(pod.Annotations[NetworkDependencies])) == 0 {
+		return nil, nil
+	}
+	selector, err := labels.Parse(pod.Annotations[NetworkDependencies])
+	if err != nil {
+		return nil, fmt.Errorf("parse %v: %v", NetworkDependencies, err)
+	}
+	return selector, nil
+}
+
 // GetClusterID return the cluster in node label
 func GetClusterID(node *corev1.Node) string {
 	if node == nil {
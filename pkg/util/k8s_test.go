@@ -17,10 +17,14 @@
 package util
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
 	"github.com/virtual-kubelet/tensile-kube/pkg/testbase"
 )
@@ -79,6 +83,36 @@ func TestIsVirtualPod(t *testing.T) {
 	}
 }
 
+func TestGetSchedulingGates(t *testing.T) {
+	pod := testbase.PodForTest()
+	if gates := GetSchedulingGates(pod); gates != nil {
+		t.Fatalf("desire no gates, real %v", gates)
+	}
+	pod.Annotations = map[string]string{SchedulingGates: "quota, ,approval,"}
+	if gates := GetSchedulingGates(pod); !reflect.DeepEqual(gates, []string{"quota", "approval"}) {
+		t.Fatalf("desire gates [quota approval], real %v", gates)
+	}
+}
+
+func TestGetNetworkDependencies(t *testing.T) {
+	pod := testbase.PodForTest()
+	if selector, err := GetNetworkDependencies(pod); selector != nil || err != nil {
+		t.Fatalf("desire no dependencies, real %v, %v", selector, err)
+	}
+	pod.Annotations = map[string]string{NetworkDependencies: "app in (redis, mysql)"}
+	selector, err := GetNetworkDependencies(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !selector.Matches(labels.Set{"app": "redis"}) || selector.Matches(labels.Set{"app": "web"}) {
+		t.Fatalf("desire selecting app redis and mysql, real %v", selector)
+	}
+	pod.Annotations[NetworkDependencies] = "app in ("
+	if _, err := GetNetworkDependencies(pod); err == nil {
+		t.Fatal("desire error of invalid selector, real nil")
+	}
+}
+
 func TestGetClusterID(t *testing.T) {
 	node := testbase.NodeForTest()
 	node1 := node.DeepCopy()
@@ -106,6 +140,31 @@ func TestGetClusterID(t *testing.T) {
 	}
 }
 
+func TestListConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfigs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.config", "a.config", "..data"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ListConfigFiles([]string{filepath.Join(dir, "missing.config")})
+	if err == nil {
+		t.Fatalf("desire error of missing file, real %v", files)
+	}
+	files, err = ListConfigFiles([]string{filepath.Join(dir, "b.config"), dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "b.config"), filepath.Join(dir, "a.config"), filepath.Join(dir, "b.config")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("desire %v, real %v", expected, files)
+	}
+}
+
 func TestNewClient(t *testing.T) {
 	path := "/tmp/test.config"
 	f, err := os.Create(path)
@@ -204,3 +263,60 @@ func TestCreateJSONPatch(t *testing.T) {
 		t.Fatalf("desired path: \n%v\n, get: \n%v\n", desiredPatch, string(patch))
 	}
 }
+
+func TestNewClientWithAuthPlugins(t *testing.T) {
+	cases := []struct {
+		name     string
+		authInfo string
+	}{
+		{
+			name: "oidc",
+			authInfo: `
+    auth-provider:
+      name: oidc
+      config:
+        client-id: tensile-kube
+        id-token: token
+        idp-issuer-url: https://issuer.example.com`,
+		},
+		{
+			name: "exec",
+			authInfo: `
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: get-token
+      args: ["--cluster", "lower"]`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "kubeconfig")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			_, err = f.WriteString(`apiVersion: v1
+kind: Config
+clusters:
+- name: lower
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: lower
+  context:
+    cluster: lower
+    user: lower
+current-context: lower
+users:
+- name: lower
+  user:` + c.authInfo + "\n")
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = NewClient(f.Name()); err != nil {
+				t.Errorf("Build client with %v failed: %v", c.name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultLeaderElectionNamespace is the namespace of the leases locked by leader election
+	DefaultLeaderElectionNamespace = "kube-system"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// LeaderElection is the config of leader election among the replicas of a component
+type LeaderElection struct {
+	// LeaderElect enables the leader election, the loops run without it if false
+	LeaderElect bool
+	// Namespace is the namespace of the lease
+	Namespace string
+	// Name is the name of the lease, replicas of the same loops must share it
+	Name string
+}
+
+// RunOrElect runs the function directly if the leader election is disabled, otherwise once the lease is acquired.
+// The lease is released once the function returns, and the process exits if the lease is lost before, so that
+// the function never runs in two replicas at the same time
+func RunOrElect(ctx context.Context, client kubernetes.Interface, le LeaderElection,
+	run func(ctx context.Context) error) error {
+	if !le.LeaderElect {
+		return run(ctx)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, le.Namespace, le.Name, client.CoreV1(),
+		client.CoordinationV1(), resourcelock.ResourceLockConfig{
+			// replicas may share the hostname, e.g. the ones of host network
+			Identity: hostname + "_" + string(uuid.NewUUID()),
+		})
+	if err != nil {
+		return fmt.Errorf("could not create lock %v/%v: %v", le.Namespace, le.Name, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	finished := make(chan struct{})
+	var runErr error
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            le.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("Acquired lease %v/%v", le.Namespace, le.Name)
+				runErr = run(ctx)
+				close(finished)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-finished:
+				default:
+					if ctx.Err() == nil {
+						klog.Fatalf("Lost lease %v/%v", le.Namespace, le.Name)
+					}
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	klog.Infof("Waiting for lease %v/%v", le.Namespace, le.Name)
+	elector.Run(ctx)
+	select {
+	case <-finished:
+		return runErr
+	default:
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunOrElect(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	desired := fmt.Errorf("test")
+	le := LeaderElection{LeaderElect: true, Namespace: "kube-system", Name: "test"}
+	holder := ""
+	err := RunOrElect(ctx, client, le, func(ctx context.Context) error {
+		lease, err := client.CoordinationV1().Leases("kube-system").Get(ctx, "test", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		holder = *lease.Spec.HolderIdentity
+		return desired
+	})
+	if err != desired {
+		t.Fatalf("Desire %v, get %v", desired, err)
+	}
+	if len(holder) == 0 {
+		t.Fatal("Desire lease held while running")
+	}
+
+	// run directly without a lease
+	le = LeaderElection{Namespace: "kube-system", Name: "disabled"}
+	if err = RunOrElect(ctx, client, le, func(ctx context.Context) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.CoordinationV1().Leases("kube-system").Get(ctx, "disabled",
+		metav1.GetOptions{}); err == nil {
+		t.Fatal("Desire no lease without leader election")
+	}
+}
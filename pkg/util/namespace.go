@@ -0,0 +1,94 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceMapping translates the namespaces of the upper cluster into those of a lower cluster shared with
+// other upper clusters, by the explicit mappings first and else by the prefix, namespaces are kept if neither
+// set. A nil mapping keeps all namespaces
+type NamespaceMapping struct {
+	prefix  string
+	toLower map[string]string
+	toUpper map[string]string
+}
+
+// NewNamespaceMapping returns the mapping of the prefix and the explicit mappings from upper namespaces to lower
+// ones, nil if neither set. Two upper namespaces mapped to the same lower one are rejected
+func NewNamespaceMapping(prefix string, mappings map[string]string) (*NamespaceMapping, error) {
+	if len(prefix) == 0 && len(mappings) == 0 {
+		return nil, nil
+	}
+	if len(prefix) != 0 {
+		if errs := validation.IsDNS1123Label(strings.TrimSuffix(prefix, "-")); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid namespace prefix %q: %v", prefix, strings.Join(errs, "; "))
+		}
+	}
+	m := &NamespaceMapping{
+		prefix:  prefix,
+		toLower: make(map[string]string, len(mappings)),
+		toUpper: make(map[string]string, len(mappings)),
+	}
+	for upper, lower := range mappings {
+		if errs := validation.IsDNS1123Label(lower); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid namespace %q mapped from %q: %v", lower, upper, strings.Join(errs, "; "))
+		}
+		if other, ok := m.toUpper[lower]; ok {
+			return nil, fmt.Errorf("namespaces %q and %q are both mapped to %q", other, upper, lower)
+		}
+		m.toLower[upper] = lower
+		m.toUpper[lower] = upper
+	}
+	return m, nil
+}
+
+// ToLower returns the lower namespace of the upper namespace, the empty namespace of cluster scoped objects and
+// all namespaces is kept
+func (m *NamespaceMapping) ToLower(namespace string) string {
+	if m == nil || len(namespace) == 0 {
+		return namespace
+	}
+	if lower, ok := m.toLower[namespace]; ok {
+		return lower
+	}
+	return m.prefix + namespace
+}
+
+// ToUpper returns the upper namespace the lower namespace is translated from, false if the lower namespace does
+// not belong to the upper cluster, e.g. lacking the prefix
+func (m *NamespaceMapping) ToUpper(namespace string) (string, bool) {
+	if m == nil || len(namespace) == 0 {
+		return namespace, true
+	}
+	if upper, ok := m.toUpper[namespace]; ok {
+		return upper, true
+	}
+	if !strings.HasPrefix(namespace, m.prefix) {
+		return "", false
+	}
+	upper := strings.TrimPrefix(namespace, m.prefix)
+	if _, ok := m.toLower[upper]; ok {
+		// the upper namespace is mapped elsewhere explicitly
+		return "", false
+	}
+	return upper, true
+}
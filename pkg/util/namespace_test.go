@@ -0,0 +1,57 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import "testing"
+
+func TestNamespaceMapping(t *testing.T) {
+	var identity *NamespaceMapping
+	if ns := identity.ToLower("default"); ns != "default" {
+		t.Fatalf("Desire namespace kept by nil mapping, get %v", ns)
+	}
+	m, err := NewNamespaceMapping("tenant-a-", map[string]string{"prod": "a-prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		upper string
+		lower string
+	}{
+		{upper: "default", lower: "tenant-a-default"},
+		{upper: "prod", lower: "a-prod"},
+		{upper: "", lower: ""},
+	}
+	for _, c := range cases {
+		if lower := m.ToLower(c.upper); lower != c.lower {
+			t.Fatalf("Desire %q mapped to %q, get %q", c.upper, c.lower, lower)
+		}
+		if upper, ok := m.ToUpper(c.lower); !ok || upper != c.upper {
+			t.Fatalf("Desire %q mapped back to %q, get %q, %v", c.lower, c.upper, upper, ok)
+		}
+	}
+	for _, lower := range []string{"default", "tenant-a-prod"} {
+		if upper, ok := m.ToUpper(lower); ok {
+			t.Fatalf("Desire %q not belonging to the upper cluster, get %q", lower, upper)
+		}
+	}
+	if _, err = NewNamespaceMapping("", map[string]string{"a": "shared", "b": "shared"}); err == nil {
+		t.Fatal("Desire namespaces mapped to the same one rejected")
+	}
+	if m, err = NewNamespaceMapping("", nil); err != nil || m != nil {
+		t.Fatalf("Desire nil mapping if nothing set, get %v, %v", m, err)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodReferences records the configMaps and secrets referenced by a pod, the values tell if all of the
+// references to the object are optional, i.e. the pod could start without it
+type PodReferences struct {
+	ConfigMaps map[string]bool
+	Secrets    map[string]bool
+}
+
+// GetPodReferences returns the configMaps and secrets referenced by the volumes, projected volumes, envs of
+// all containers and image pull secrets of the pod. The service account token secrets are excluded, since
+// they are managed by the clusters
+func GetPodReferences(pod *corev1.Pod) *PodReferences {
+	refs := &PodReferences{
+		ConfigMaps: make(map[string]bool),
+		Secrets:    make(map[string]bool),
+	}
+	for _, v := range pod.Spec.Volumes {
+		switch {
+		case v.ConfigMap != nil:
+			addReference(refs.ConfigMaps, v.ConfigMap.Name, v.ConfigMap.Optional)
+		case v.Secret != nil:
+			if strings.HasPrefix(v.Name, "default-token") {
+				continue
+			}
+			addReference(refs.Secrets, v.Secret.SecretName, v.Secret.Optional)
+		case v.Projected != nil:
+			for _, source := range v.Projected.Sources {
+				if source.ConfigMap != nil {
+					addReference(refs.ConfigMaps, source.ConfigMap.Name, source.ConfigMap.Optional)
+				}
+				if source.Secret != nil {
+					addReference(refs.Secrets, source.Secret.Name, source.Secret.Optional)
+				}
+			}
+		case v.CephFS != nil && v.CephFS.SecretRef != nil:
+			addReference(refs.Secrets, v.CephFS.SecretRef.Name, nil)
+		case v.Cinder != nil && v.Cinder.SecretRef != nil:
+			addReference(refs.Secrets, v.Cinder.SecretRef.Name, nil)
+		case v.RBD != nil && v.RBD.SecretRef != nil:
+			addReference(refs.Secrets, v.RBD.SecretRef.Name, nil)
+		}
+	}
+	containers := append([]corev1.Container{}, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, envFrom := range c.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				addReference(refs.ConfigMaps, envFrom.ConfigMapRef.Name, envFrom.ConfigMapRef.Optional)
+			}
+			if envFrom.SecretRef != nil {
+				addReference(refs.Secrets, envFrom.SecretRef.Name, envFrom.SecretRef.Optional)
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				addReference(refs.ConfigMaps, ref.Name, ref.Optional)
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				addReference(refs.Secrets, ref.Name, ref.Optional)
+			}
+		}
+	}
+	for _, s := range pod.Spec.ImagePullSecrets {
+		addReference(refs.Secrets, s.Name, nil)
+	}
+	return refs
+}
+
+// ReferenceNames returns the sorted names of the references, the optional ones are excluded if requiredOnly
+func ReferenceNames(refs map[string]bool, requiredOnly bool) []string {
+	names := make([]string, 0, len(refs))
+	for name, optional := range refs {
+		if requiredOnly && optional {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addReference records the reference, the object is optional only if all of the references are optional
+func addReference(refs map[string]bool, name string, optional *bool) {
+	if len(name) == 0 {
+		return
+	}
+	isOptional := optional != nil && *optional
+	if previous, ok := refs[name]; ok {
+		isOptional = isOptional && previous
+	}
+	refs[name] = isOptional
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetPodReferences(t *testing.T) {
+	optional := true
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "cm", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "cm-volume"}}}},
+				{Name: "default-token-abc", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+					SecretName: "default-token-abc"}}},
+				{Name: "projected", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "cm-projected"},
+							Optional:             &optional}},
+						{Secret: &corev1.SecretProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "secret-projected"}}},
+					}}}},
+				{Name: "rbd", VolumeSource: corev1.VolumeSource{RBD: &corev1.RBDVolumeSource{}}},
+			},
+			InitContainers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "secret-env"}, Optional: &optional}}},
+			}},
+			Containers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "cm-env"}}}},
+				Env: []corev1.EnvVar{{Name: "key", ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "secret-projected"},
+						Optional:             &optional}}}},
+			}},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+		},
+	}
+	refs := GetPodReferences(pod)
+	configMaps := map[string]bool{"cm-volume": false, "cm-projected": true, "cm-env": false}
+	if !reflect.DeepEqual(refs.ConfigMaps, configMaps) {
+		t.Fatalf("Desire %v, get %v", configMaps, refs.ConfigMaps)
+	}
+	// the secret is required if any of the references is required
+	secrets := map[string]bool{"secret-projected": false, "secret-env": true, "registry": false}
+	if !reflect.DeepEqual(refs.Secrets, secrets) {
+		t.Fatalf("Desire %v, get %v", secrets, refs.Secrets)
+	}
+	required := []string{"cm-env", "cm-volume"}
+	if names := ReferenceNames(refs.ConfigMaps, true); !reflect.DeepEqual(names, required) {
+		t.Fatalf("Desire %v, get %v", required, names)
+	}
+	all := []string{"registry", "secret-env", "secret-projected"}
+	if names := ReferenceNames(refs.Secrets, false); !reflect.DeepEqual(names, all) {
+		t.Fatalf("Desire %v, get %v", all, names)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const (
+	// auditMutatedFields is the audit annotation listing the fields of pod changed, e.g. spec.tolerations,
+	// the apiserver prefixes it with the name of the webhook
+	auditMutatedFields = "mutated-fields"
+	// auditPatch is the audit annotation listing the operations of the json patch, e.g. add /spec/nodeName
+	auditPatch = "patch"
+)
+
+// patchOperation is the part of a json patch operation summarized in audit annotations, values are left
+// out so that secrets never leak into audit logs
+type patchOperation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// auditAnnotations summarizes the json patch of the mutation, so that the audit logs of the apiserver capture
+// exactly what the webhook changed, nil is returned if nothing changed
+func auditAnnotations(patch []byte) map[string]string {
+	var operations []patchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil || len(operations) == 0 {
+		return nil
+	}
+	fields := map[string]bool{}
+	summaries := make([]string, 0, len(operations))
+	for _, operation := range operations {
+		fields[mutatedField(operation.Path)] = true
+		summaries = append(summaries, operation.Op+" "+operation.Path)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return map[string]string{
+		auditMutatedFields: strings.Join(names, ","),
+		auditPatch:         strings.Join(summaries, ","),
+	}
+}
+
+// mutatedField returns the field of pod the json pointer points into, e.g. spec.affinity of
+// /spec/affinity/nodeAffinity
+func mutatedField(path string) string {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(segments) > 2 {
+		segments = segments[:2]
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditAnnotations(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		patch  string
+		desire map[string]string
+	}{
+		{
+			name:  "mutated",
+			patch: `[{"op":"add","path":"/spec/nodeName","value":"vk"},{"op":"replace","path":"/spec/tolerations/0","value":{}},{"op":"add","path":"/metadata/annotations","value":{"clusterSelector":"{}"}}]`,
+			desire: map[string]string{
+				auditMutatedFields: "metadata.annotations,spec.nodeName,spec.tolerations",
+				auditPatch:         "add /spec/nodeName,replace /spec/tolerations/0,add /metadata/annotations",
+			},
+		},
+		{
+			name:  "not mutated",
+			patch: `[]`,
+		},
+		{
+			name:  "invalid",
+			patch: `{}`,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if real := auditAnnotations([]byte(c.patch)); !reflect.DeepEqual(real, c.desire) {
+				t.Fatalf("desire %v, real %v", c.desire, real)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/common"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// csiEphemeralMode is the lifecycle mode of inline CSI volumes
+const csiEphemeralMode = "Ephemeral"
+
+// csiDriverChecker checks if the CSI drivers of inline volumes are installed in any lower cluster,
+// otherwise pods would never be scheduled to the virtual nodes
+type csiDriverChecker struct {
+	nodeLister v1.NodeLister
+}
+
+// WithCSIDriverCheck makes the webhook server also reject the pods with inline CSI volumes whose drivers
+// are installed in none of the lower clusters
+func WithCSIDriverCheck(hook HookServer, nodeLister v1.NodeLister) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.csiChecker = &csiDriverChecker{nodeLister: nodeLister}
+	}
+	return hook
+}
+
+// check returns an error describing the drivers of inline CSI volumes not installed in any lower cluster,
+// nothing is checked until any virtual node publishes its CSI drivers
+func (c *csiDriverChecker) check(pod *corev1.Pod) error {
+	required := make(map[string]struct{})
+	for _, vol := range pod.Spec.Volumes {
+		if vol.CSI != nil {
+			required[vol.CSI.Driver] = struct{}{}
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	var clusters []common.CSIDrivers
+	for _, node := range nodes {
+		if !util.IsVirtualNode(node) || len(node.Annotations[util.CSIDrivers]) == 0 {
+			continue
+		}
+		drivers := common.CSIDrivers{}
+		if err = json.Unmarshal([]byte(node.Annotations[util.CSIDrivers]), &drivers); err != nil {
+			klog.Warningf("Invalid csi drivers of node %v: %v", node.Name, err)
+			continue
+		}
+		clusters = append(clusters, drivers)
+	}
+	if len(clusters) == 0 {
+		return nil
+	}
+	var missing []string
+	for name := range required {
+		if !supportedByAny(clusters, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("csi drivers %v of inline volumes are not installed in any lower cluster",
+		strings.Join(missing, ", "))
+}
+
+// supportedByAny returns if the driver supports inline volumes in any of the clusters
+func supportedByAny(clusters []common.CSIDrivers, name string) bool {
+	for _, drivers := range clusters {
+		if driver := drivers.Get(name); driver != nil && driver.SupportsMode(csiEphemeralMode) {
+			return true
+		}
+	}
+	return false
+}
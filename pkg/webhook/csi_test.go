@@ -0,0 +1,89 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestCSIDriverCheck(t *testing.T) {
+	newNode := func(name, drivers string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{util.NodeType: util.VirtualKubeletLabel},
+			Annotations: map[string]string{util.CSIDrivers: drivers},
+		}}
+	}
+	inlinePod := func(driver string) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{Volumes: []v1.Volume{{Name: "vol", VolumeSource: v1.VolumeSource{
+			CSI: &v1.CSIVolumeSource{Driver: driver},
+		}}}}}
+	}
+	cases := []struct {
+		name   string
+		nodes  []*v1.Node
+		pod    *v1.Pod
+		reject bool
+	}{
+		{
+			name:  "no inline volumes",
+			nodes: []*v1.Node{newNode("vk-1", `[]`)},
+			pod:   &v1.Pod{},
+		},
+		{
+			name:  "no drivers published",
+			nodes: []*v1.Node{newNode("vk-1", "")},
+			pod:   inlinePod("a"),
+		},
+		{
+			name:  "driver installed in one cluster",
+			nodes: []*v1.Node{newNode("vk-1", `[]`), newNode("vk-2", `[{"name":"a","modes":["Ephemeral"]}]`)},
+			pod:   inlinePod("a"),
+		},
+		{
+			name:   "driver not supporting inline volumes",
+			nodes:  []*v1.Node{newNode("vk-1", `[{"name":"a"}]`)},
+			pod:    inlinePod("a"),
+			reject: true,
+		},
+		{
+			name:   "driver not installed",
+			nodes:  []*v1.Node{newNode("vk-1", `[{"name":"b","modes":["Ephemeral"]}]`)},
+			pod:    inlinePod("a"),
+			reject: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, node := range c.nodes {
+				indexer.Add(node)
+			}
+			checker := &csiDriverChecker{nodeLister: corelisters.NewNodeLister(indexer)}
+			if err := checker.check(c.pod); (err != nil) != c.reject {
+				t.Errorf("Desired reject %v, get %v", c.reject, err)
+			}
+		})
+	}
+}
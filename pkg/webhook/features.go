@@ -0,0 +1,203 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// FeatureHostNetwork is the feature of pods using the network of the node
+	FeatureHostNetwork = "hostNetwork"
+	// FeatureHostPID is the feature of pods using the pid namespace of the node
+	FeatureHostPID = "hostPID"
+	// FeatureHostIPC is the feature of pods using the ipc namespace of the node
+	FeatureHostIPC = "hostIPC"
+	// FeatureHostPath is the feature of pods mounting hostPath volumes
+	FeatureHostPath = "hostPath"
+	// FeatureLocalVolume is the feature of pods claiming local persistent volumes of nodes in the upper cluster
+	FeatureLocalVolume = "localVolume"
+
+	// FeatureCheckReject rejects the pods using unsupported features
+	FeatureCheckReject = "Reject"
+	// FeatureCheckWarn admits the pods using unsupported features, which are logged and audited
+	FeatureCheckWarn = "Warn"
+
+	// auditUnsupportedFeatures is the audit annotation listing the unsupported features of pods admitted in Warn
+	auditUnsupportedFeatures = "unsupported-features"
+)
+
+// unsupportedFeatures are the features of pods bound to the nodes they run on, which are not the nodes of the
+// upper cluster once pods are offloaded to lower clusters
+var unsupportedFeatures = []string{FeatureHostNetwork, FeatureHostPID, FeatureHostIPC, FeatureHostPath,
+	FeatureLocalVolume}
+
+// featureChecker checks if virtual pods use the features not supported by virtual nodes
+type featureChecker struct {
+	warn      bool
+	allowed   map[string]bool
+	pvcLister v1.PersistentVolumeClaimLister
+	pvLister  v1.PersistentVolumeLister
+}
+
+// ValidateFeatureCheck validates the mode and the allowed features of the feature check
+func ValidateFeatureCheck(mode string, allowed []string) error {
+	if mode != FeatureCheckReject && mode != FeatureCheckWarn {
+		return fmt.Errorf("unknown feature check mode %v", mode)
+	}
+	for _, feature := range allowed {
+		if !isUnsupportedFeature(feature) {
+			return fmt.Errorf("unknown feature %v, should be one of %v", feature,
+				strings.Join(unsupportedFeatures, ", "))
+		}
+	}
+	return nil
+}
+
+func isUnsupportedFeature(feature string) bool {
+	for _, f := range unsupportedFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFeatureCheck makes the validation of the webhook server check if virtual pods use the features not
+// supported by virtual nodes, hostNetwork, hostPID, hostIPC, hostPath volumes and local persistent volumes,
+// except the allowed ones. In Reject mode the pods are rejected, in Warn mode they are admitted and the
+// features are logged and audited
+func WithFeatureCheck(hook HookServer, mode string, allowed []string,
+	pvLister v1.PersistentVolumeLister) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		checker := &featureChecker{
+			warn:      mode == FeatureCheckWarn,
+			allowed:   map[string]bool{},
+			pvcLister: server.pvcLister,
+			pvLister:  pvLister,
+		}
+		for _, feature := range allowed {
+			checker.allowed[feature] = true
+		}
+		server.featureChecker = checker
+	}
+	return hook
+}
+
+// check returns the unsupported features used by the pod and not allowed, sorted
+func (c *featureChecker) check(pod *corev1.Pod) []string {
+	used := map[string]bool{
+		FeatureHostNetwork: pod.Spec.HostNetwork,
+		FeatureHostPID:     pod.Spec.HostPID,
+		FeatureHostIPC:     pod.Spec.HostIPC,
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.HostPath != nil {
+			used[FeatureHostPath] = true
+		}
+		if volume.PersistentVolumeClaim != nil && c.isLocalVolume(pod.Namespace,
+			volume.PersistentVolumeClaim.ClaimName) {
+			used[FeatureLocalVolume] = true
+		}
+	}
+	var features []string
+	for feature, ok := range used {
+		if ok && !c.allowed[feature] {
+			features = append(features, feature)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// isLocalVolume returns if the claim is bound to a local persistent volume, the claims not bound yet are
+// unknown and pass
+func (c *featureChecker) isLocalVolume(namespace, claim string) bool {
+	if c.pvcLister == nil || c.pvLister == nil {
+		return false
+	}
+	pvc, err := c.pvcLister.PersistentVolumeClaims(namespace).Get(claim)
+	if err != nil || len(pvc.Spec.VolumeName) == 0 {
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Warningf("Get pvc %v/%v failed: %v", namespace, claim, err)
+		}
+		return false
+	}
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return false
+	}
+	return pv.Spec.Local != nil
+}
+
+// validate admits the pods not using unsupported features, only the virtual pods are checked
+func (whsvr *webhookServer) validate(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+	req := ar.Request
+	if req.Kind.Kind != "Pod" || req.Operation != v1beta1.Create || whsvr.featureChecker == nil {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		klog.Errorf("Could not unmarshal raw object %v err: %v", req, err)
+		return &v1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}}
+	}
+	if pod.Namespace == "kube-system" || !util.IsVirtualPod(pod) {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	features := whsvr.featureChecker.check(pod)
+	if len(features) == 0 {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	message := fmt.Sprintf("features %v are not supported by virtual nodes", strings.Join(features, ", "))
+	if whsvr.featureChecker.warn {
+		klog.Warningf("Admit pod %v/%v: %v", req.Namespace, podName(pod), message)
+		return &v1beta1.AdmissionResponse{
+			Allowed:          true,
+			AuditAnnotations: map[string]string{auditUnsupportedFeatures: strings.Join(features, ",")},
+		}
+	}
+	klog.Infof("Reject pod %v/%v: %v", req.Namespace, podName(pod), message)
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: message,
+		},
+	}
+}
+
+// podName returns the name of the pod, the generate name if the name is not generated yet
+func podName(pod *corev1.Pod) string {
+	if len(pod.Name) != 0 {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
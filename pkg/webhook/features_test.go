@@ -0,0 +1,126 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestFeatureCheck(t *testing.T) {
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvcIndexer.Add(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "default"},
+		Spec: v1.PersistentVolumeClaimSpec{VolumeName: "local-pv"}})
+	pvIndexer.Add(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "local-pv"},
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+			Local: &v1.LocalVolumeSource{Path: "/data"}}}})
+	pvcIndexer.Add(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"}})
+
+	checker := &featureChecker{
+		allowed:   map[string]bool{FeatureHostIPC: true},
+		pvcLister: corelisters.NewPersistentVolumeClaimLister(pvcIndexer),
+		pvLister:  corelisters.NewPersistentVolumeLister(pvIndexer),
+	}
+	claim := func(name string) v1.Volume {
+		return v1.Volume{Name: name, VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: name}}}
+	}
+	cases := []struct {
+		name    string
+		spec    v1.PodSpec
+		desired []string
+	}{
+		{
+			name: "no unsupported features",
+			spec: v1.PodSpec{Volumes: []v1.Volume{claim("pending")}},
+		},
+		{
+			name: "allowed feature",
+			spec: v1.PodSpec{HostIPC: true},
+		},
+		{
+			name: "host namespaces and volumes",
+			spec: v1.PodSpec{HostNetwork: true, HostPID: true, Volumes: []v1.Volume{
+				{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/var"}}},
+				claim("local"),
+			}},
+			desired: []string{FeatureHostNetwork, FeatureHostPID, FeatureHostPath, FeatureLocalVolume},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			features := checker.check(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}, Spec: c.spec})
+			if !reflect.DeepEqual(features, c.desired) {
+				t.Errorf("Desire %v, get %v", c.desired, features)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+			Labels: map[string]string{util.VirtualPodLabel: "true"}},
+		Spec: v1.PodSpec{HostNetwork: true},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Kind: "Pod"},
+		Operation: v1beta1.Create,
+		Namespace: "default",
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	server := &webhookServer{}
+	if resp := server.validate(review); !resp.Allowed {
+		t.Fatal("Desire pods admitted without feature check")
+	}
+	WithFeatureCheck(server, FeatureCheckReject, nil, nil)
+	if resp := server.validate(review); resp.Allowed {
+		t.Fatal("Desire pod using hostNetwork rejected")
+	}
+	WithFeatureCheck(server, FeatureCheckWarn, nil, nil)
+	resp := server.validate(review)
+	if !resp.Allowed || resp.AuditAnnotations[auditUnsupportedFeatures] != FeatureHostNetwork {
+		t.Fatalf("Desire pod admitted with audit annotation, get %+v", resp)
+	}
+}
+
+func TestValidateFeatureCheck(t *testing.T) {
+	if err := ValidateFeatureCheck(FeatureCheckReject, []string{FeatureHostPath}); err != nil {
+		t.Errorf("Desire valid, get %v", err)
+	}
+	if err := ValidateFeatureCheck("Ignore", nil); err == nil {
+		t.Error("Desire unknown mode invalid")
+	}
+	if err := ValidateFeatureCheck(FeatureCheckWarn, []string{"privileged"}); err == nil {
+		t.Error("Desire unknown feature invalid")
+	}
+}
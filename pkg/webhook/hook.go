@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 
 	"k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
@@ -28,6 +29,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes"
 	v1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
 
@@ -59,13 +61,28 @@ var (
 type HookServer interface {
 	// Serve starts a server
 	Serve(http.ResponseWriter, *http.Request)
+	// Validate serves the validation of pods, which only admits or rejects them
+	Validate(http.ResponseWriter, *http.Request)
 }
 
 // webhookServer is a sever for webhook
 type webhookServer struct {
 	ignoreSelectorKeys []string
 	pvcLister          v1.PersistentVolumeClaimLister
+	refChecker         *referenceChecker
+	csiChecker         *csiDriverChecker
+	offloadChecker     *offloadChecker
+	featureChecker     *featureChecker
+	injectIdentity     bool
+	translator         *selectorTranslator
+	selector           *clusterSelector
+	schedulerName      string
+	mutateWorkloads    bool
+	injection          *injectionRules
 	Server             *http.Server
+	// rulesLock guards the mutation rules updated live, ignoreSelectorKeys, injectIdentity, translator, selector
+	// and injection
+	rulesLock sync.RWMutex
 }
 
 func init() {
@@ -81,6 +98,37 @@ func NewWebhookServer(pvcLister v1.PersistentVolumeClaimLister, ignoreKeys []str
 	}
 }
 
+// NewWebhookServerWithReferenceCheck start a new webhook server, which also rejects the virtual pods
+// referencing configMaps or secrets not existing in the upper cluster, client is used on cache misses
+func NewWebhookServerWithReferenceCheck(client kubernetes.Interface, pvcLister v1.PersistentVolumeClaimLister,
+	cmLister v1.ConfigMapLister, secretLister v1.SecretLister, ignoreKeys []string) HookServer {
+	return &webhookServer{
+		ignoreSelectorKeys: ignoreKeys,
+		pvcLister:          pvcLister,
+		refChecker:         &referenceChecker{client: client, cmLister: cmLister, secretLister: secretLister},
+	}
+}
+
+// UpdateMutationRules replaces the nodeSelector keys ignored and whether to inject the cluster identity envs,
+// it is safe to be called while the webhook server is serving
+func UpdateMutationRules(hook HookServer, ignoreKeys []string, injectIdentity bool) {
+	server, ok := hook.(*webhookServer)
+	if !ok {
+		return
+	}
+	server.rulesLock.Lock()
+	defer server.rulesLock.Unlock()
+	server.ignoreSelectorKeys = ignoreKeys
+	server.injectIdentity = injectIdentity
+}
+
+// mutationRules returns the nodeSelector keys ignored and whether to inject the cluster identity envs
+func (whsvr *webhookServer) mutationRules() ([]string, bool) {
+	whsvr.rulesLock.RLock()
+	defer whsvr.rulesLock.RUnlock()
+	return whsvr.ignoreSelectorKeys, whsvr.injectIdentity
+}
+
 // mutate k8s pod annotations, Affinity, nodeSelector and etc.
 func (whsvr *webhookServer) mutate(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
 	req := ar.Request
@@ -101,24 +149,81 @@ func (whsvr *webhookServer) mutate(ar *v1beta1.AdmissionReview) *v1beta1.Admissi
 			}
 		}
 	default:
+		if workloadKinds[req.Kind.Kind] {
+			return whsvr.mutateWorkload(req)
+		}
 		return &v1beta1.AdmissionResponse{
 			Allowed: false,
 		}
 	}
-	if shouldSkip(&pod) {
+	injection := whsvr.injectionRules()
+	if shouldSkip(&pod) || injection.skips(req.Namespace) {
 		return &v1beta1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
+	ignoreKeys, injectIdentity := whsvr.mutationRules()
 	ref := getOwnerRef(&pod)
 	clone := pod.DeepCopy()
 	switch req.Operation {
 	case v1beta1.Update:
-		setUnschedulableNodes(ref, clone)
+		// the webhook declares no side effects, nodes of dry-run requests are not cached
+		if req.DryRun == nil || !*req.DryRun {
+			setUnschedulableNodes(ref, clone)
+		}
 		return &v1beta1.AdmissionResponse{
 			Allowed: true,
 		}
 	case v1beta1.Create:
+		// pods without labels are not skipped, only the pods to run in lower clusters are checked
+		if whsvr.refChecker != nil && util.IsVirtualPod(clone) {
+			if err = whsvr.refChecker.check(req.Namespace, clone); err != nil {
+				klog.Infof("Reject pod %v: %v", clone.Name, err)
+				return &v1beta1.AdmissionResponse{
+					Allowed: false,
+					Result: &metav1.Status{
+						Code:    http.StatusForbidden,
+						Reason:  metav1.StatusReasonForbidden,
+						Message: err.Error(),
+					},
+				}
+			}
+		}
+		if whsvr.csiChecker != nil {
+			if err = whsvr.csiChecker.check(clone); err != nil {
+				klog.Infof("Reject pod %v: %v", clone.Name, err)
+				return &v1beta1.AdmissionResponse{
+					Allowed: false,
+					Result: &metav1.Status{
+						Code:    http.StatusForbidden,
+						Reason:  metav1.StatusReasonForbidden,
+						Message: err.Error(),
+					},
+				}
+			}
+		}
+		if whsvr.offloadChecker != nil {
+			if err = whsvr.offloadChecker.check(clone); err != nil {
+				klog.Infof("Reject pod %v: %v", clone.Name, err)
+				return &v1beta1.AdmissionResponse{
+					Allowed: false,
+					Result: &metav1.Status{
+						Code:    http.StatusForbidden,
+						Reason:  metav1.StatusReasonForbidden,
+						Message: err.Error(),
+					},
+				}
+			}
+		}
+		if injectIdentity {
+			injectClusterIdentity(clone)
+		}
+		whsvr.setSchedulerName(clone)
+		if selector := whsvr.clusterSelection(); selector != nil && util.IsVirtualPod(clone) {
+			if err = selector.prefer(clone); err != nil {
+				klog.Warningf("Select clusters for pod %v failed: %v", clone.Name, err)
+			}
+		}
 		nodes := getUnschedulableNodes(ref, clone)
 		if len(nodes) > 0 {
 			klog.Infof("Create pod %v Not nodes %+v", clone.Name, nodes)
@@ -129,7 +234,11 @@ func (whsvr *webhookServer) mutate(ar *v1beta1.AdmissionReview) *v1beta1.Admissi
 	}
 
 	whsvr.trySetNodeName(clone)
-	inject(clone, whsvr.ignoreSelectorKeys)
+	inject(clone, ignoreKeys, whsvr.translation())
+	// the tolerations are for virtual nodes, they are added after the ones for lower clusters are recorded
+	if req.Operation == v1beta1.Create {
+		injection.tolerate(clone)
+	}
 	patch, err := util.CreateJSONPatch(pod, clone)
 	klog.Infof("Final patch %+v", string(patch))
 	var result metav1.Status
@@ -139,22 +248,34 @@ func (whsvr *webhookServer) mutate(ar *v1beta1.AdmissionReview) *v1beta1.Admissi
 	}
 	jsonPatch := v1beta1.PatchTypeJSONPatch
 	return &v1beta1.AdmissionResponse{
-		Allowed:   true,
-		Result:    &result,
-		Patch:     patch,
-		PatchType: &jsonPatch,
+		Allowed:          true,
+		Result:           &result,
+		Patch:            patch,
+		PatchType:        &jsonPatch,
+		AuditAnnotations: auditAnnotations(patch),
 	}
 }
 
 // Serve method for webhook server
 func (whsvr *webhookServer) Serve(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, whsvr.mutate)
+}
+
+// Validate serves the validation of pods, the unsupported features are checked if WithFeatureCheck
+func (whsvr *webhookServer) Validate(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, whsvr.validate)
+}
+
+// serve responds the admission review of the request by admit
+func serve(w http.ResponseWriter, r *http.Request,
+	admit func(*v1beta1.AdmissionReview) *v1beta1.AdmissionResponse) {
 	admissionReview, err := getRequestReview(r)
 	if err != nil {
 		klog.Error(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	admissionResponse := whsvr.mutate(admissionReview)
+	admissionResponse := admit(admissionReview)
 	if admissionResponse != nil {
 		admissionReview.Response = admissionResponse
 		admissionReview.Response.UID = admissionReview.Request.UID
@@ -203,7 +324,9 @@ func (whsvr *webhookServer) getNodeNameFromPVC(ns, pvcName string) string {
 	return pvc.Annotations[util.SelectedNodeKey]
 }
 
-func inject(pod *corev1.Pod, ignoreKeys []string) {
+// inject moves the node selection and tolerations of the pod to the annotation restored in lower clusters, the
+// selection is translated if the translator is not nil
+func inject(pod *corev1.Pod, ignoreKeys []string, translator *selectorTranslator) {
 	nodeSelector := make(map[string]string)
 	var affinity *corev1.Affinity
 
@@ -211,12 +334,16 @@ func inject(pod *corev1.Pod, ignoreKeys []string) {
 		return
 	}
 
-	if pod.Spec.Affinity != nil {
-		affinity = injectAffinity(pod.Spec.Affinity, ignoreKeys)
-	}
+	if translator != nil {
+		nodeSelector, affinity = translator.split(pod, ignoreKeys)
+	} else {
+		if pod.Spec.Affinity != nil {
+			affinity = injectAffinity(pod.Spec.Affinity, ignoreKeys)
+		}
 
-	if pod.Spec.NodeSelector != nil {
-		nodeSelector = injectNodeSelector(pod.Spec.NodeSelector, ignoreKeys)
+		if pod.Spec.NodeSelector != nil {
+			nodeSelector = injectNodeSelector(pod.Spec.NodeSelector, ignoreKeys)
+		}
 	}
 
 	cns := util.ClustersNodeSelection{
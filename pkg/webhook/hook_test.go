@@ -242,7 +242,7 @@ func TestInject(t *testing.T) {
 	}
 	for _, c := range cases {
 		t.Logf("Running %v", c.name)
-		inject(c.pod, c.keys)
+		inject(c.pod, c.keys, nil)
 		str := c.pod.Annotations[util.SelectorKey]
 		cns := util.ClustersNodeSelection{}
 		err := json.Unmarshal([]byte(str), &cns)
@@ -0,0 +1,79 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// identityEnvs are the envs exposing the lower cluster of pods, the annotations they refer to are set by
+// the provider when the pods are created in the lower cluster
+var identityEnvs = []corev1.EnvVar{
+	annotationEnv(util.ClusterNameEnv, util.ClusterName),
+	annotationEnv(util.ClusterRegionEnv, util.ClusterRegion),
+}
+
+// WithClusterIdentityInjection makes the webhook server also inject the envs of the lower cluster name and
+// region into the containers of pods
+func WithClusterIdentityInjection(hook HookServer) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.injectIdentity = true
+	}
+	return hook
+}
+
+func annotationEnv(name, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				APIVersion: "v1",
+				FieldPath:  fmt.Sprintf("metadata.annotations['%s']", key),
+			},
+		},
+	}
+}
+
+// injectClusterIdentity adds the identity envs to all containers of the pod, envs defined by users are kept
+func injectClusterIdentity(pod *corev1.Pod) {
+	for i := range pod.Spec.InitContainers {
+		pod.Spec.InitContainers[i].Env = appendIdentityEnvs(pod.Spec.InitContainers[i].Env)
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].Env = appendIdentityEnvs(pod.Spec.Containers[i].Env)
+	}
+}
+
+func appendIdentityEnvs(envs []corev1.EnvVar) []corev1.EnvVar {
+	for _, identity := range identityEnvs {
+		defined := false
+		for _, env := range envs {
+			if env.Name == identity.Name {
+				defined = true
+				break
+			}
+		}
+		if !defined {
+			envs = append(envs, identity)
+		}
+	}
+	return envs
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestInjectClusterIdentity(t *testing.T) {
+	userEnv := v1.EnvVar{Name: util.ClusterNameEnv, Value: "mine"}
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{{Name: "init"}},
+		Containers: []v1.Container{
+			{Name: "app", Env: []v1.EnvVar{{Name: "FOO", Value: "bar"}}},
+			{Name: "sidecar", Env: []v1.EnvVar{userEnv}},
+		},
+	}}
+	injectClusterIdentity(pod)
+
+	nameEnv := annotationEnv(util.ClusterNameEnv, util.ClusterName)
+	regionEnv := annotationEnv(util.ClusterRegionEnv, util.ClusterRegion)
+	if nameEnv.ValueFrom.FieldRef.FieldPath != "metadata.annotations['tensile-kube.io/cluster-name']" {
+		t.Errorf("unexpected field path %v", nameEnv.ValueFrom.FieldRef.FieldPath)
+	}
+	cases := []struct {
+		name string
+		got  []v1.EnvVar
+		want []v1.EnvVar
+	}{
+		{
+			name: "init container",
+			got:  pod.Spec.InitContainers[0].Env,
+			want: []v1.EnvVar{nameEnv, regionEnv},
+		},
+		{
+			name: "container with envs",
+			got:  pod.Spec.Containers[0].Env,
+			want: []v1.EnvVar{{Name: "FOO", Value: "bar"}, nameEnv, regionEnv},
+		},
+		{
+			name: "env defined by user",
+			got:  pod.Spec.Containers[1].Env,
+			want: []v1.EnvVar{userEnv, regionEnv},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if !reflect.DeepEqual(c.got, c.want) {
+				t.Errorf("desire %+v, get %+v", c.want, c.got)
+			}
+		})
+	}
+
+	// injecting again should change nothing
+	clone := pod.DeepCopy()
+	injectClusterIdentity(clone)
+	if !reflect.DeepEqual(clone, pod) {
+		t.Errorf("desire envs injected once, get %+v", clone.Spec.Containers)
+	}
+}
+
+func TestUpdateMutationRules(t *testing.T) {
+	hook := WithClusterIdentityInjection(NewWebhookServer(nil, []string{util.ClusterID}))
+	UpdateMutationRules(hook, []string{"zone"}, false)
+	keys, injectIdentity := hook.(*webhookServer).mutationRules()
+	if !reflect.DeepEqual(keys, []string{"zone"}) || injectIdentity {
+		t.Errorf("desire keys [zone] without injecting identity, get %v %v", keys, injectIdentity)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// injectionRules are the tolerations added to virtual pods and the namespaces whose pods are not mutated, they
+// are replaced as a whole when changed live
+type injectionRules struct {
+	tolerations       []corev1.Toleration
+	skippedNamespaces sets.String
+}
+
+// UpdateInjectionRules replaces the tolerations added to virtual pods and the namespaces skipped, it is safe to
+// be called while the webhook server is serving
+func UpdateInjectionRules(hook HookServer, tolerations []corev1.Toleration, skippedNamespaces []string) {
+	server, ok := hook.(*webhookServer)
+	if !ok {
+		return
+	}
+	rules := &injectionRules{tolerations: tolerations, skippedNamespaces: sets.NewString(skippedNamespaces...)}
+	server.rulesLock.Lock()
+	defer server.rulesLock.Unlock()
+	server.injection = rules
+}
+
+// injectionRules returns the injection rules, nil if never set
+func (whsvr *webhookServer) injectionRules() *injectionRules {
+	whsvr.rulesLock.RLock()
+	defer whsvr.rulesLock.RUnlock()
+	return whsvr.injection
+}
+
+// skips tells whether the pods in the namespace are not mutated
+func (r *injectionRules) skips(namespace string) bool {
+	return r != nil && r.skippedNamespaces.Has(namespace)
+}
+
+// tolerate adds the tolerations to the pod, the ones it already has are not duplicated
+func (r *injectionRules) tolerate(pod *corev1.Pod) {
+	if r == nil {
+		return
+	}
+	for i := range r.tolerations {
+		if !hasToleration(pod.Spec.Tolerations, &r.tolerations[i]) {
+			pod.Spec.Tolerations = append(pod.Spec.Tolerations, r.tolerations[i])
+		}
+	}
+}
+
+func hasToleration(tolerations []corev1.Toleration, toleration *corev1.Toleration) bool {
+	for i := range tolerations {
+		if tolerations[i].MatchToleration(toleration) {
+			return true
+		}
+	}
+	return false
+}
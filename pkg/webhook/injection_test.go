@@ -0,0 +1,102 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestUpdateInjectionRules(t *testing.T) {
+	hook := NewWebhookServer(nil, nil)
+	virtualNode := corev1.Toleration{Key: "virtual-kubelet.io/provider", Operator: corev1.TolerationOpExists}
+	gpu := corev1.Toleration{Key: "gpu", Operator: corev1.TolerationOpEqual, Value: "true",
+		Effect: corev1.TaintEffectNoSchedule}
+	UpdateInjectionRules(hook, []corev1.Toleration{virtualNode, gpu}, []string{"batch"})
+	server := hook.(*webhookServer)
+
+	cases := []struct {
+		name        string
+		namespace   string
+		tolerations []corev1.Toleration
+		expected    []corev1.Toleration
+	}{
+		{
+			name:      "injected",
+			namespace: "default",
+			expected:  []corev1.Toleration{virtualNode, gpu},
+		},
+		{
+			name:        "not duplicated",
+			namespace:   "default",
+			tolerations: []corev1.Toleration{gpu},
+			expected: []corev1.Toleration{gpu, desiredMap[util.TaintNodeNotReady],
+				desiredMap[util.TaintNodeUnreachable], virtualNode},
+		},
+		{
+			name:        "skipped namespace",
+			namespace:   "batch",
+			tolerations: []corev1.Toleration{gpu},
+			expected:    []corev1.Toleration{gpu},
+		},
+	}
+	for _, c := range cases {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: c.namespace,
+				Labels: map[string]string{util.VirtualPodLabel: "true"}},
+			Spec: corev1.PodSpec{Tolerations: c.tolerations},
+		}
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := server.mutate(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Kind: "Pod"},
+			Namespace: c.namespace,
+			Operation: v1beta1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		if !resp.Allowed {
+			t.Fatalf("%v: desire pod allowed, get %+v", c.name, resp.Result)
+		}
+		if len(resp.Patch) != 0 {
+			patch, err := jsonpatch.DecodePatch(resp.Patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw, err = patch.Apply(raw); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mutated := &corev1.Pod{}
+		if err = json.Unmarshal(raw, mutated); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(mutated.Spec.Tolerations, c.expected) {
+			t.Errorf("%v: desire tolerations %+v, get %+v", c.name, c.expected, mutated.Spec.Tolerations)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/offload"
+)
+
+// offloadChecker checks if pods are eligible to run on virtual nodes by ClusterOffloadPolicies
+type offloadChecker struct {
+	policies *offload.Controller
+	taintKey string
+}
+
+// WithOffloadPolicy makes the webhook server also reject the virtual pods not eligible by any ClusterOffloadPolicy,
+// and add the toleration of taintKey, the taint of virtual nodes, to the eligible ones, so users need not add
+// it by hand
+func WithOffloadPolicy(hook HookServer, policies *offload.Controller, taintKey string) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.offloadChecker = &offloadChecker{policies: policies, taintKey: taintKey}
+	}
+	return hook
+}
+
+// check returns an error if the pod is not eligible, otherwise the toleration of virtual nodes is added
+func (c *offloadChecker) check(pod *corev1.Pod) error {
+	eligible, err := c.policies.Eligible(pod)
+	if err != nil {
+		return err
+	}
+	if !eligible {
+		return fmt.Errorf("pod is not eligible to run on virtual nodes by any ClusterOffloadPolicy")
+	}
+	c.tolerate(pod)
+	return nil
+}
+
+// tolerate adds the toleration of virtual nodes to the pod if absent
+func (c *offloadChecker) tolerate(pod *corev1.Pod) {
+	for _, toleration := range pod.Spec.Tolerations {
+		if toleration.Key == c.taintKey && toleration.Operator == corev1.TolerationOpExists &&
+			len(toleration.Effect) == 0 {
+			return
+		}
+	}
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, corev1.Toleration{
+		Key:      c.taintKey,
+		Operator: corev1.TolerationOpExists,
+	})
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/offload"
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestOffloadCheck(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	client.PrependReactor("list", "clusteroffloadpolicies", func(action core.Action) (bool, runtime.Object,
+		error) {
+		return true, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: map[string]interface{}{
+			"apiVersion": "tensile-kube.io/v1alpha1",
+			"kind":       "ClusterOffloadPolicy",
+			"metadata":   map[string]interface{}{"name": "batch"},
+			"spec":       map[string]interface{}{"namespaces": []interface{}{"batch"}},
+		}}}}, nil
+	})
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	policies := offload.NewController(client, corelisters.NewNamespaceLister(indexer))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go policies.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, policies.HasSynced) {
+		t.Fatal("Wait for cache sync failed")
+	}
+	checker := &offloadChecker{policies: policies, taintKey: util.VirtualNodeTaintKey}
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	if err := checker.check(pod); err == nil {
+		t.Fatal("Desire pod not eligible rejected")
+	}
+	pod.Namespace = "batch"
+	if err := checker.check(pod); err != nil {
+		t.Fatal(err)
+	}
+	// the toleration is added only once
+	if err := checker.check(pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.Tolerations) != 1 || pod.Spec.Tolerations[0].Key != util.VirtualNodeTaintKey {
+		t.Fatalf("Desire toleration of %v, get %+v", util.VirtualNodeTaintKey, pod.Spec.Tolerations)
+	}
+}
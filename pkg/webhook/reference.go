@@ -0,0 +1,103 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// referenceChecker checks if the configMaps and secrets referenced by pods exist in the upper cluster,
+// otherwise pods would hang in CreateContainerConfigError in the lower cluster.
+// The objects missed by the listers are got from the apiserver, they may be created just before the pod
+type referenceChecker struct {
+	client       kubernetes.Interface
+	cmLister     v1.ConfigMapLister
+	secretLister v1.SecretLister
+}
+
+// check returns an error describing all of the missing configMaps and secrets of the pod,
+// ns is used if the namespace of pod is not set
+func (c *referenceChecker) check(ns string, pod *corev1.Pod) error {
+	if len(pod.Namespace) != 0 {
+		ns = pod.Namespace
+	}
+	// optional references are ignored
+	refs := util.GetPodReferences(pod)
+	var missing []string
+	for _, name := range util.ReferenceNames(refs.ConfigMaps, true) {
+		exist, err := c.configMapExists(ns, name)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			missing = append(missing, "configmap/"+name)
+		}
+	}
+	for _, name := range util.ReferenceNames(refs.Secrets, true) {
+		exist, err := c.secretExists(ns, name)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			missing = append(missing, "secret/"+name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("referenced %v not found in namespace %v, create them before the pod",
+		strings.Join(missing, ", "), ns)
+}
+
+func (c *referenceChecker) configMapExists(ns, name string) (bool, error) {
+	_, err := c.cmLister.ConfigMaps(ns).Get(name)
+	if errors.IsNotFound(err) && c.client != nil {
+		_, err = c.client.CoreV1().ConfigMaps(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	}
+	return exists(err)
+}
+
+func (c *referenceChecker) secretExists(ns, name string) (bool, error) {
+	_, err := c.secretLister.Secrets(ns).Get(name)
+	if errors.IsNotFound(err) && c.client != nil {
+		_, err = c.client.CoreV1().Secrets(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	}
+	return exists(err)
+}
+
+// exists returns if the object exists by the error of getting it
+func exists(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestReferenceCheck(t *testing.T) {
+	optional := true
+	cmIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	cmIndexer.Add(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}})
+	secretIndexer.Add(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"}})
+	// the configMap created just before the pod is not cached yet
+	client := fake.NewSimpleClientset(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "new-cm", Namespace: "default"}})
+	checker := &referenceChecker{
+		client:       client,
+		cmLister:     corelisters.NewConfigMapLister(cmIndexer),
+		secretLister: corelisters.NewSecretLister(secretIndexer),
+	}
+
+	cases := []struct {
+		name    string
+		pod     *v1.Pod
+		missing []string
+	}{
+		{
+			name: "all references exist",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Volumes: []v1.Volume{{Name: "cm", VolumeSource: v1.VolumeSource{
+					ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "cm"}},
+				}}},
+				Containers: []v1.Container{{EnvFrom: []v1.EnvFromSource{{
+					SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "secret"}},
+				}}}},
+			}},
+		},
+		{
+			name: "references not cached yet",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers: []v1.Container{{EnvFrom: []v1.EnvFromSource{{
+					ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "new-cm"}},
+				}}}},
+			}},
+		},
+		{
+			name: "missing references",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Volumes: []v1.Volume{{Name: "secret", VolumeSource: v1.VolumeSource{
+					Secret: &v1.SecretVolumeSource{SecretName: "no-secret"},
+				}}},
+				InitContainers: []v1.Container{{Env: []v1.EnvVar{{Name: "a", ValueFrom: &v1.EnvVarSource{
+					ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "no-cm"}, Key: "a"},
+				}}}}},
+			}},
+			missing: []string{"configmap/no-cm", "secret/no-secret"},
+		},
+		{
+			name: "optional references ignored",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers: []v1.Container{{EnvFrom: []v1.EnvFromSource{{
+					ConfigMapRef: &v1.ConfigMapEnvSource{
+						LocalObjectReference: v1.LocalObjectReference{Name: "no-cm"}, Optional: &optional},
+				}}}},
+			}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checker.check("default", c.pod)
+			if len(c.missing) == 0 {
+				if err != nil {
+					t.Fatalf("Desire no error, get %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Desire missing %v, get no error", c.missing)
+			}
+			if !strings.Contains(err.Error(), strings.Join(c.missing, ", ")) {
+				t.Fatalf("Desire missing %v, get %v", c.missing, err)
+			}
+		})
+	}
+}
+
+func TestReferenceCheckVirtualPods(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	hook := NewWebhookServerWithReferenceCheck(fake.NewSimpleClientset(), nil,
+		corelisters.NewConfigMapLister(indexer), corelisters.NewSecretLister(indexer), nil).(*webhookServer)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{{Name: "cm", VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "no-cm"}},
+		}}}},
+	}
+	for _, c := range []struct {
+		name    string
+		labels  map[string]string
+		allowed bool
+	}{
+		{
+			name:    "pod not to run in lower clusters",
+			allowed: true,
+		},
+		{
+			name:   "virtual pod",
+			labels: map[string]string{util.VirtualPodLabel: "true"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			podCopy := pod.DeepCopy()
+			podCopy.Labels = c.labels
+			raw, err := json.Marshal(podCopy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := hook.mutate(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Kind: "Pod"},
+				Namespace: "default",
+				Operation: v1beta1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != c.allowed {
+				t.Errorf("Desire allowed %v, get %v", c.allowed, resp.Allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+const (
+	// ClusterSelectionKey is the key of the cluster selection policy in the configMap
+	ClusterSelectionKey = "policy"
+
+	defaultPreferredNodes   = 3
+	defaultPreferenceWeight = 100
+)
+
+// ClusterSelectionPolicy scores the virtual nodes for each virtual pod at admission, the ones with the highest
+// scores are preferred by the pod, so the scheduler places it by data locality, cost or region before the others
+type ClusterSelectionPolicy struct {
+	// MaxNodes is the number of virtual nodes preferred, 3 if 0
+	MaxNodes int `json:"maxNodes,omitempty"`
+	// Weight is the weight of the preferred node affinity term added to pods, in [1, 100], 100 if 0
+	Weight int32 `json:"weight,omitempty"`
+	// Criteria are the criteria summed into the scores of virtual nodes
+	Criteria []SelectionCriterion `json:"criteria"`
+}
+
+// SelectionCriterion scores virtual nodes by one of their labels
+type SelectionCriterion struct {
+	// NodeLabel is the label of virtual nodes evaluated, e.g. tensile-kube.io/cluster-name or
+	// topology.kubernetes.io/region
+	NodeLabel string `json:"nodeLabel"`
+	// PodLabel scores Weight to the virtual nodes whose NodeLabel equals the label of the pod, e.g. the region
+	// of the data a pod reads
+	PodLabel string `json:"podLabel,omitempty"`
+	// Values scores the virtual nodes by the value of NodeLabel times Weight, e.g. negative costs of clusters
+	Values map[string]int64 `json:"values,omitempty"`
+	// Weight is the weight of the criterion, 1 if 0
+	Weight int64 `json:"weight,omitempty"`
+}
+
+// clusterSelector prefers virtual nodes for pods by the policy
+type clusterSelector struct {
+	nodeLister v1.NodeLister
+	policy     *ClusterSelectionPolicy
+}
+
+// WithClusterSelection makes the webhook server prefer virtual nodes for virtual pods by the policy set by
+// UpdateClusterSelection, no node is preferred until a policy is set
+func WithClusterSelection(hook HookServer, nodeLister v1.NodeLister) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.selector = &clusterSelector{nodeLister: nodeLister}
+	}
+	return hook
+}
+
+// UpdateClusterSelection replaces the cluster selection policy, it is safe to be called while the webhook server
+// is serving
+func UpdateClusterSelection(hook HookServer, policy *ClusterSelectionPolicy) {
+	server, ok := hook.(*webhookServer)
+	if !ok {
+		return
+	}
+	server.rulesLock.Lock()
+	defer server.rulesLock.Unlock()
+	if server.selector != nil {
+		server.selector = &clusterSelector{nodeLister: server.selector.nodeLister, policy: policy}
+	}
+}
+
+// clusterSelection returns the cluster selector, nil if the selection is disabled
+func (whsvr *webhookServer) clusterSelection() *clusterSelector {
+	whsvr.rulesLock.RLock()
+	defer whsvr.rulesLock.RUnlock()
+	return whsvr.selector
+}
+
+// ParseClusterSelectionPolicy decodes the cluster selection policy in json from the configMap, nil if absent
+func ParseClusterSelectionPolicy(cm *corev1.ConfigMap) (*ClusterSelectionPolicy, error) {
+	data, ok := cm.Data[ClusterSelectionKey]
+	if !ok {
+		return nil, nil
+	}
+	policy := &ClusterSelectionPolicy{}
+	if err := json.Unmarshal([]byte(data), policy); err != nil {
+		return nil, fmt.Errorf("could not decode cluster selection policy of configmap %v/%v: %v", cm.Namespace,
+			cm.Name, err)
+	}
+	if policy.MaxNodes < 0 || policy.Weight < 0 || policy.Weight > 100 {
+		return nil, fmt.Errorf("maxNodes %v or weight %v of cluster selection policy in configmap %v/%v is out of "+
+			"range", policy.MaxNodes, policy.Weight, cm.Namespace, cm.Name)
+	}
+	for _, criterion := range policy.Criteria {
+		if len(criterion.NodeLabel) == 0 {
+			return nil, fmt.Errorf("node label of cluster selection criterion in configmap %v/%v is empty",
+				cm.Namespace, cm.Name)
+		}
+	}
+	return policy, nil
+}
+
+// prefer annotates the pod with the virtual nodes preferred and adds the preferred node affinity term of them,
+// the nodes annotated by users are kept
+func (s *clusterSelector) prefer(pod *corev1.Pod) error {
+	var nodes []string
+	if annotation := pod.Annotations[util.PreferredNodes]; len(annotation) != 0 {
+		nodes = strings.Split(annotation, ",")
+	} else {
+		if s.policy == nil {
+			return nil
+		}
+		var err error
+		if nodes, err = s.selectNodes(pod); err != nil || len(nodes) == 0 {
+			return err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[util.PreferredNodes] = strings.Join(nodes, ",")
+	}
+	weight := int32(defaultPreferenceWeight)
+	if s.policy != nil && s.policy.Weight != 0 {
+		weight = s.policy.Weight
+	}
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	affinity := pod.Spec.Affinity.NodeAffinity
+	affinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+		affinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.PreferredSchedulingTerm{
+			Weight: weight,
+			Preference: corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{{
+				Key:      "metadata.name",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   nodes,
+			}}},
+		})
+	return nil
+}
+
+// selectNodes returns the schedulable virtual nodes with the highest scores, nil if the scores are all the same
+func (s *clusterSelector) selectNodes(pod *corev1.Pod) ([]string, error) {
+	nodes, err := s.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	type scored struct {
+		name  string
+		score int64
+	}
+	var candidates []scored
+	for _, node := range nodes {
+		if !util.IsVirtualNode(node) || node.Spec.Unschedulable {
+			continue
+		}
+		candidates = append(candidates, scored{name: node.Name, score: s.policy.score(pod, node)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if len(candidates) == 0 || candidates[0].score == candidates[len(candidates)-1].score {
+		return nil, nil
+	}
+	max := s.policy.MaxNodes
+	if max == 0 {
+		max = defaultPreferredNodes
+	}
+	var selected []string
+	for i := 0; i < len(candidates) && i < max; i++ {
+		selected = append(selected, candidates[i].name)
+	}
+	klog.V(4).Infof("Prefer nodes %v for pod %v/%v", selected, pod.Namespace, pod.Name)
+	return selected, nil
+}
+
+// score sums the criteria on the virtual node for the pod
+func (p *ClusterSelectionPolicy) score(pod *corev1.Pod, node *corev1.Node) int64 {
+	var score int64
+	for _, criterion := range p.Criteria {
+		value, ok := node.Labels[criterion.NodeLabel]
+		if !ok {
+			continue
+		}
+		weight := criterion.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if len(criterion.PodLabel) != 0 {
+			if podValue, ok := pod.Labels[criterion.PodLabel]; ok && podValue == value {
+				score += weight
+			}
+		}
+		score += criterion.Values[value] * weight
+	}
+	return score
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestClusterSelection(t *testing.T) {
+	virtualNode := func(name, region, cluster string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			util.NodeType: util.VirtualKubeletLabel, "region": region, "cluster": cluster}}}
+	}
+	policy := &ClusterSelectionPolicy{MaxNodes: 2, Criteria: []SelectionCriterion{
+		{NodeLabel: "region", PodLabel: "data-region", Weight: 10},
+		{NodeLabel: "cluster", Values: map[string]int64{"cheap": 5, "expensive": -5}},
+	}}
+	cases := []struct {
+		name        string
+		policy      *ClusterSelectionPolicy
+		labels      map[string]string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:     "data locality before cost",
+			policy:   policy,
+			labels:   map[string]string{"data-region": "east"},
+			expected: []string{"vk-east-expensive", "vk-west-cheap"},
+		},
+		{
+			name:     "cost only",
+			policy:   policy,
+			expected: []string{"vk-west-cheap", "vk-east-expensive"},
+		},
+		{
+			name:   "same scores",
+			policy: &ClusterSelectionPolicy{Criteria: []SelectionCriterion{{NodeLabel: "region", PodLabel: "x"}}},
+		},
+		{
+			name: "no policy",
+		},
+		{
+			name:        "annotated by users",
+			policy:      policy,
+			annotations: map[string]string{util.PreferredNodes: "vk-west-expensive"},
+			expected:    []string{"vk-west-expensive"},
+		},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodes := []*v1.Node{virtualNode("vk-east-expensive", "east", "expensive"),
+		virtualNode("vk-west-cheap", "west", "cheap"), virtualNode("vk-west-expensive", "west", "expensive"),
+		{ObjectMeta: metav1.ObjectMeta{Name: "physical", Labels: map[string]string{"cluster": "cheap"}}}}
+	cordoned := virtualNode("vk-cordoned", "east", "cheap")
+	cordoned.Spec.Unschedulable = true
+	nodes = append(nodes, cordoned)
+	for _, node := range nodes {
+		indexer.Add(node)
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			selector := &clusterSelector{nodeLister: corelisters.NewNodeLister(indexer), policy: c.policy}
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Labels: c.labels,
+				Annotations: c.annotations}}
+			if err := selector.prefer(pod); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.expected) == 0 {
+				if pod.Spec.Affinity != nil || len(pod.Annotations[util.PreferredNodes]) != 0 {
+					t.Fatalf("no node should be preferred: %v", pod.Spec.Affinity)
+				}
+				return
+			}
+			terms := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			if len(terms) != 1 || terms[0].Weight != defaultPreferenceWeight {
+				t.Fatalf("unexpected preferred terms %v", terms)
+			}
+			if values := terms[0].Preference.MatchFields[0].Values; !reflect.DeepEqual(values, c.expected) {
+				t.Fatalf("expected nodes %v, got %v", c.expected, values)
+			}
+		})
+	}
+}
+
+func TestParseClusterSelectionPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     map[string]string
+		expected *ClusterSelectionPolicy
+		invalid  bool
+	}{
+		{
+			name: "absent",
+		},
+		{
+			name:     "valid",
+			data:     map[string]string{ClusterSelectionKey: `{"weight":50,"criteria":[{"nodeLabel":"region"}]}`},
+			expected: &ClusterSelectionPolicy{Weight: 50, Criteria: []SelectionCriterion{{NodeLabel: "region"}}},
+		},
+		{
+			name:    "weight out of range",
+			data:    map[string]string{ClusterSelectionKey: `{"weight":101}`},
+			invalid: true,
+		},
+		{
+			name:    "no node label",
+			data:    map[string]string{ClusterSelectionKey: `{"criteria":[{"podLabel":"region"}]}`},
+			invalid: true,
+		},
+		{
+			name:    "malformed",
+			data:    map[string]string{ClusterSelectionKey: `{`},
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := ParseClusterSelectionPolicy(&v1.ConfigMap{Data: c.data})
+			if (err != nil) != c.invalid {
+				t.Fatalf("expected invalid %v, got %v", c.invalid, err)
+			}
+			if !reflect.DeepEqual(policy, c.expected) {
+				t.Fatalf("expected %v, got %v", c.expected, policy)
+			}
+		})
+	}
+}
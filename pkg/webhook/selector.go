@@ -0,0 +1,207 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// SelectorStrip moves the nodeSelector and required node affinity not ignored to the lower pods as they are
+	SelectorStrip = "Strip"
+	// SelectorTranslate moves them to the lower pods translated by the selector rules, the ones of the keys
+	// kept are also kept in the upper pods
+	SelectorTranslate = "Translate"
+	// SelectorRulesKey is the key of the selector rules in the configMap
+	SelectorRulesKey = "rules"
+)
+
+// SelectorRule translates a node label key of the upper cluster to the equivalent of lower clusters
+type SelectorRule struct {
+	// Key is the label key used by the upper pods
+	Key string `json:"key"`
+	// LowerKey is the label key of lower cluster nodes, Key is used if empty
+	LowerKey string `json:"lowerKey,omitempty"`
+	// Values maps the values of the upper cluster to the ones of lower clusters, values not mapped are kept
+	Values map[string]string `json:"values,omitempty"`
+	// Keep keeps the requirements of the key in the upper pods as well, e.g. the topology labels the virtual
+	// nodes carry, so that the upper scheduler still spreads or co-locates the pods by them
+	Keep bool `json:"keep,omitempty"`
+}
+
+// selectorTranslator splits the node selection of pods into the upper part and the translated lower part
+type selectorTranslator struct {
+	rules map[string]SelectorRule
+}
+
+// WithSelectorTranslation makes the webhook server translate the nodeSelector and required node affinity
+// moved to the lower pods by the rules set by UpdateSelectorRules, instead of moving them as they are
+func WithSelectorTranslation(hook HookServer) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.translator = &selectorTranslator{}
+	}
+	return hook
+}
+
+// UpdateSelectorRules replaces the selector rules, it is safe to be called while the webhook server is serving
+func UpdateSelectorRules(hook HookServer, rules []SelectorRule) {
+	server, ok := hook.(*webhookServer)
+	if !ok {
+		return
+	}
+	translator := &selectorTranslator{rules: make(map[string]SelectorRule, len(rules))}
+	for _, rule := range rules {
+		translator.rules[rule.Key] = rule
+	}
+	server.rulesLock.Lock()
+	defer server.rulesLock.Unlock()
+	if server.translator != nil {
+		server.translator = translator
+	}
+}
+
+// translation returns the translator of selectors, nil if the translation is disabled
+func (whsvr *webhookServer) translation() *selectorTranslator {
+	whsvr.rulesLock.RLock()
+	defer whsvr.rulesLock.RUnlock()
+	return whsvr.translator
+}
+
+// ParseSelectorRules decodes the selector rules in json from the configMap
+func ParseSelectorRules(cm *corev1.ConfigMap) ([]SelectorRule, error) {
+	var rules []SelectorRule
+	data, ok := cm.Data[SelectorRulesKey]
+	if !ok {
+		return nil, nil
+	}
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("could not decode selector rules of configmap %v/%v: %v", cm.Namespace, cm.Name,
+			err)
+	}
+	for _, rule := range rules {
+		if len(rule.Key) == 0 {
+			return nil, fmt.Errorf("key of selector rule in configmap %v/%v is empty", cm.Namespace, cm.Name)
+		}
+	}
+	return rules, nil
+}
+
+// split removes the node selection from the pod except the keys ignored or kept, and returns the translated
+// selection of lower clusters. The terms of required node affinity are ORed, a side with any term left empty
+// is not constrained by the affinity at all
+func (t *selectorTranslator) split(pod *corev1.Pod, ignoreKeys []string) (map[string]string, *corev1.Affinity) {
+	ignored := make(map[string]bool, len(ignoreKeys))
+	for _, key := range ignoreKeys {
+		ignored[key] = true
+	}
+	nodeSelector := make(map[string]string)
+	for k, v := range pod.Spec.NodeSelector {
+		if ignored[k] {
+			continue
+		}
+		rule, ok := t.rules[k]
+		if !ok || !rule.Keep {
+			delete(pod.Spec.NodeSelector, k)
+		}
+		if ok {
+			k, v = rule.lowerKey(), rule.lowerValue(v)
+		}
+		nodeSelector[k] = v
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nodeSelector, nil
+	}
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	var upperTerms, lowerTerms []corev1.NodeSelectorTerm
+	upperUnconstrained, lowerUnconstrained := false, false
+	for _, term := range required.NodeSelectorTerms {
+		var upper, lower corev1.NodeSelectorTerm
+		for _, me := range term.MatchExpressions {
+			if ignored[me.Key] {
+				upper.MatchExpressions = append(upper.MatchExpressions, me)
+				continue
+			}
+			rule, ok := t.rules[me.Key]
+			if !ok {
+				lower.MatchExpressions = append(lower.MatchExpressions, me)
+				continue
+			}
+			if rule.Keep {
+				upper.MatchExpressions = append(upper.MatchExpressions, me)
+			}
+			lower.MatchExpressions = append(lower.MatchExpressions, rule.lowerRequirement(me))
+		}
+		for _, mf := range term.MatchFields {
+			// fields, i.e. the node name, are never equivalent between clusters
+			if ignored[mf.Key] {
+				upper.MatchFields = append(upper.MatchFields, mf)
+				continue
+			}
+			lower.MatchFields = append(lower.MatchFields, mf)
+		}
+		upperUnconstrained = upperUnconstrained || isEmptyTerm(upper)
+		lowerUnconstrained = lowerUnconstrained || isEmptyTerm(lower)
+		upperTerms = append(upperTerms, upper)
+		lowerTerms = append(lowerTerms, lower)
+	}
+	if upperUnconstrained {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = nil
+	} else {
+		required.NodeSelectorTerms = upperTerms
+	}
+	if lowerUnconstrained {
+		return nodeSelector, nil
+	}
+	return nodeSelector, &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: lowerTerms},
+	}}
+}
+
+func (r *SelectorRule) lowerKey() string {
+	if len(r.LowerKey) == 0 {
+		return r.Key
+	}
+	return r.LowerKey
+}
+
+func (r *SelectorRule) lowerValue(value string) string {
+	if lower, ok := r.Values[value]; ok {
+		return lower
+	}
+	return value
+}
+
+// lowerRequirement returns the requirement translated to the lower key and values
+func (r *SelectorRule) lowerRequirement(me corev1.NodeSelectorRequirement) corev1.NodeSelectorRequirement {
+	lower := corev1.NodeSelectorRequirement{Key: r.lowerKey(), Operator: me.Operator}
+	for _, value := range me.Values {
+		// values of Gt and Lt are numbers compared, which are never mapped
+		if me.Operator == corev1.NodeSelectorOpIn || me.Operator == corev1.NodeSelectorOpNotIn {
+			value = r.lowerValue(value)
+		}
+		lower.Values = append(lower.Values, value)
+	}
+	return lower
+}
+
+func isEmptyTerm(term corev1.NodeSelectorTerm) bool {
+	return len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0
+}
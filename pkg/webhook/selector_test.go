@@ -0,0 +1,103 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestParseSelectorRules(t *testing.T) {
+	cm := &corev1.ConfigMap{Data: map[string]string{
+		SelectorRulesKey: `[{"key":"zone","lowerKey":"topology.kubernetes.io/zone","values":{"a":"zone-a"},"keep":true}]`,
+	}}
+	rules, err := ParseSelectorRules(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := []SelectorRule{{Key: "zone", LowerKey: "topology.kubernetes.io/zone",
+		Values: map[string]string{"a": "zone-a"}, Keep: true}}
+	if !reflect.DeepEqual(rules, desired) {
+		t.Fatalf("Desire %v, get %v", desired, rules)
+	}
+	cm.Data[SelectorRulesKey] = `[{"lowerKey":"zone"}]`
+	if _, err = ParseSelectorRules(cm); err == nil {
+		t.Fatal("Desire error of rule without key")
+	}
+}
+
+func TestSelectorTranslatorSplit(t *testing.T) {
+	hook := WithSelectorTranslation(NewWebhookServer(nil, nil))
+	UpdateSelectorRules(hook, []SelectorRule{
+		{Key: "zone", LowerKey: "topology.kubernetes.io/zone", Values: map[string]string{"a": "zone-a"}, Keep: true},
+		{Key: "gpu", LowerKey: "nvidia.com/gpu.product", Values: map[string]string{"v100": "Tesla-V100"}},
+	})
+	translator := hook.(*webhookServer).translation()
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"zone": "a", "gpu": "v100", "disk": "ssd", util.ClusterID: "c1"},
+			Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+						{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+						{Key: "gpu", Operator: corev1.NodeSelectorOpExists},
+					}}},
+				},
+			}},
+		},
+	}
+	nodeSelector, affinity := translator.split(pod, []string{util.ClusterID})
+
+	upperSelector := map[string]string{"zone": "a", util.ClusterID: "c1"}
+	if !reflect.DeepEqual(pod.Spec.NodeSelector, upperSelector) {
+		t.Fatalf("Desire upper selector %v, get %v", upperSelector, pod.Spec.NodeSelector)
+	}
+	lowerSelector := map[string]string{"topology.kubernetes.io/zone": "zone-a", "nvidia.com/gpu.product": "Tesla-V100",
+		"disk": "ssd"}
+	if !reflect.DeepEqual(nodeSelector, lowerSelector) {
+		t.Fatalf("Desire lower selector %v, get %v", lowerSelector, nodeSelector)
+	}
+	upperTerms := []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+		{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+	}}}
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if !reflect.DeepEqual(required.NodeSelectorTerms, upperTerms) {
+		t.Fatalf("Desire upper terms %v, get %v", upperTerms, required.NodeSelectorTerms)
+	}
+	lowerTerms := []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+		{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-a", "b"}},
+		{Key: "nvidia.com/gpu.product", Operator: corev1.NodeSelectorOpExists},
+	}}}
+	required = affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if !reflect.DeepEqual(required.NodeSelectorTerms, lowerTerms) {
+		t.Fatalf("Desire lower terms %v, get %v", lowerTerms, required.NodeSelectorTerms)
+	}
+
+	// a term left empty in the upper pod leaves the upper pod unconstrained by the affinity
+	pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = append(lowerTerms,
+		corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: "gpu", Operator: corev1.NodeSelectorOpExists},
+		}})
+	if _, affinity = translator.split(pod, nil); affinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		t.Fatalf("Desire upper pod unconstrained, get %+v", pod.Spec.Affinity.NodeAffinity)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+// workloadKinds are the kinds of workloads whose pod templates are mutated, CronJobs of batch/v1 and
+// batch/v1beta1 share the same layout
+var workloadKinds = map[string]bool{"Deployment": true, "StatefulSet": true, "Job": true, "CronJob": true}
+
+// WithSchedulerName makes the webhook server also set the scheduler of virtual pods to name, the pods of other
+// schedulers than the default one are kept
+func WithSchedulerName(hook HookServer, name string) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.schedulerName = name
+	}
+	return hook
+}
+
+// WithWorkloadMutation makes the webhook server also mutate the pod templates of Deployments, StatefulSets, Jobs
+// and CronJobs of virtual pods, so the scheduler name, the toleration of virtual nodes and the identity envs are
+// set once on the workloads instead of on each of their pods. The pods are still mutated for the rest
+func WithWorkloadMutation(hook HookServer) HookServer {
+	if server, ok := hook.(*webhookServer); ok {
+		server.mutateWorkloads = true
+	}
+	return hook
+}
+
+// mutateWorkload mutates the pod template of the workload created or updated, the workload is never rejected,
+// the pods not eligible are rejected when created. Updates are only mutated if they change the template, so
+// changes of the injection rules never roll out workloads on their own, and the immutable templates of Jobs
+// are only mutated on create
+func (whsvr *webhookServer) mutateWorkload(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if !whsvr.mutateWorkloads || req.Operation != v1beta1.Create && req.Operation != v1beta1.Update ||
+		req.Operation == v1beta1.Update && req.Kind.Kind == "Job" {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	original, _ := newWorkload(req.Kind.Kind)
+	obj, template := newWorkload(req.Kind.Kind)
+	old, oldTemplate := newWorkload(req.Kind.Kind)
+	objects, raws := []interface{}{original, obj}, [][]byte{req.Object.Raw, req.Object.Raw}
+	if req.Operation == v1beta1.Update {
+		objects, raws = append(objects, old), append(raws, req.OldObject.Raw)
+	}
+	for i, o := range objects {
+		if err := json.Unmarshal(raws[i], o); err != nil {
+			klog.Errorf("Could not unmarshal raw object %v err: %v", req, err)
+			return &v1beta1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: err.Error(),
+				},
+			}
+		}
+	}
+	if req.Operation == v1beta1.Update && equality.Semantic.DeepEqual(template, oldTemplate) {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	whsvr.mutateTemplate(req.Namespace, template)
+	patch, err := util.CreateJSONPatch(original, obj)
+	var result metav1.Status
+	if err != nil {
+		result.Code = 403
+		result.Message = err.Error()
+	}
+	klog.V(4).Infof("Final patch of %v %v/%v: %v", req.Kind.Kind, req.Namespace, req.Name, string(patch))
+	jsonPatch := v1beta1.PatchTypeJSONPatch
+	return &v1beta1.AdmissionResponse{
+		Allowed:          true,
+		Result:           &result,
+		Patch:            patch,
+		PatchType:        &jsonPatch,
+		AuditAnnotations: auditAnnotations(patch),
+	}
+}
+
+// mutateTemplate sets the scheduler name, the toleration of virtual nodes if eligible, the identity envs and the
+// tolerations injected of the template of virtual pods in namespace, the same as each of the pods would be mutated
+func (whsvr *webhookServer) mutateTemplate(namespace string, template *corev1.PodTemplateSpec) {
+	pod := &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+	pod.Namespace = namespace
+	injection := whsvr.injectionRules()
+	if shouldSkip(pod) || injection.skips(namespace) {
+		return
+	}
+	whsvr.setSchedulerName(pod)
+	if whsvr.offloadChecker != nil {
+		eligible, err := whsvr.offloadChecker.policies.Eligible(pod)
+		if err != nil {
+			klog.Errorf("Check eligibility of template in %v failed: %v", namespace, err)
+		} else if eligible {
+			whsvr.offloadChecker.tolerate(pod)
+		}
+	}
+	if _, injectIdentity := whsvr.mutationRules(); injectIdentity {
+		injectClusterIdentity(pod)
+	}
+	injection.tolerate(pod)
+	template.Spec = pod.Spec
+}
+
+// setSchedulerName sets the scheduler of the pod if configured, unless another scheduler than the default one
+// is chosen
+func (whsvr *webhookServer) setSchedulerName(pod *corev1.Pod) {
+	if len(whsvr.schedulerName) == 0 {
+		return
+	}
+	if len(pod.Spec.SchedulerName) == 0 || pod.Spec.SchedulerName == corev1.DefaultSchedulerName {
+		pod.Spec.SchedulerName = whsvr.schedulerName
+	}
+}
+
+// newWorkload returns an empty workload of the kind in workloadKinds and its pod template
+func newWorkload(kind string) (interface{}, *corev1.PodTemplateSpec) {
+	switch kind {
+	case "Deployment":
+		deployment := &appsv1.Deployment{}
+		return deployment, &deployment.Spec.Template
+	case "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		return statefulSet, &statefulSet.Spec.Template
+	case "Job":
+		job := &batchv1.Job{}
+		return job, &job.Spec.Template
+	default:
+		cronJob := &batchv1beta1.CronJob{}
+		return cronJob, &cronJob.Spec.JobTemplate.Spec.Template
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/virtual-kubelet/tensile-kube/pkg/util"
+)
+
+func TestMutateWorkload(t *testing.T) {
+	server := WithWorkloadMutation(WithSchedulerName(WithClusterIdentityInjection(&webhookServer{}),
+		"multi-scheduler")).(*webhookServer)
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{util.VirtualPodLabel: "true"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	deployment := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: template}}
+	cronJob := &batchv1beta1.CronJob{}
+	cronJob.Spec.JobTemplate.Spec.Template = template
+	system := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: template}}
+
+	cases := []struct {
+		name      string
+		kind      string
+		namespace string
+		obj       interface{}
+		mutated   bool
+	}{
+		{name: "deployment", kind: "Deployment", namespace: "default", obj: deployment, mutated: true},
+		{name: "cronjob", kind: "CronJob", namespace: "default", obj: cronJob, mutated: true},
+		{name: "kube-system", kind: "Deployment", namespace: "kube-system", obj: system},
+	}
+	for _, c := range cases {
+		raw, err := json.Marshal(c.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := server.mutate(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Kind: c.kind},
+			Namespace: c.namespace,
+			Operation: v1beta1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+		if !resp.Allowed {
+			t.Fatalf("%v: desire workload allowed, get %+v", c.name, resp.Result)
+		}
+		patch, err := jsonpatch.DecodePatch(resp.Patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patched, err := patch.Apply(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, mutated := newWorkload(c.kind)
+		if err = json.Unmarshal(patched, obj); err != nil {
+			t.Fatal(err)
+		}
+		if c.mutated != (mutated.Spec.SchedulerName == "multi-scheduler") ||
+			c.mutated != (len(mutated.Spec.Containers[0].Env) == 2) {
+			t.Fatalf("%v: desire mutated %v, get %+v", c.name, c.mutated, mutated.Spec)
+		}
+	}
+
+	// workloads are not mutated without the option
+	server.mutateWorkloads = false
+	raw, _ := json.Marshal(deployment)
+	resp := server.mutate(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		Kind: metav1.GroupVersionKind{Kind: "Deployment"}, Operation: v1beta1.Create,
+		Object: runtime.RawExtension{Raw: raw},
+	}})
+	if !resp.Allowed || len(resp.Patch) != 0 {
+		t.Fatalf("Desire deployment allowed as it is, get %+v", resp)
+	}
+}
+
+func TestMutateWorkloadUpdate(t *testing.T) {
+	server := WithWorkloadMutation(WithSchedulerName(&webhookServer{}, "multi-scheduler")).(*webhookServer)
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{util.VirtualPodLabel: "true"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:v1"}}},
+	}
+	old := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: template}}
+	scaled := old.DeepCopy()
+	replicas := int32(3)
+	scaled.Spec.Replicas = &replicas
+	upgraded := old.DeepCopy()
+	upgraded.Spec.Template.Spec.Containers[0].Image = "app:v2"
+	oldJob := &batchv1.Job{Spec: batchv1.JobSpec{Template: template}}
+	job := oldJob.DeepCopy()
+	job.Labels = map[string]string{"app": "job"}
+
+	cases := []struct {
+		name    string
+		kind    string
+		old     interface{}
+		obj     interface{}
+		mutated bool
+	}{
+		{name: "template unchanged", kind: "Deployment", old: old, obj: scaled},
+		{name: "template changed", kind: "Deployment", old: old, obj: upgraded, mutated: true},
+		{name: "job", kind: "Job", old: oldJob, obj: job},
+	}
+	for _, c := range cases {
+		oldRaw, err := json.Marshal(c.old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := json.Marshal(c.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := server.mutate(&v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Kind: c.kind},
+			Namespace: "default",
+			Operation: v1beta1.Update,
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: runtime.RawExtension{Raw: oldRaw},
+		}})
+		if !resp.Allowed {
+			t.Fatalf("%v: desire workload allowed, get %+v", c.name, resp.Result)
+		}
+		if c.mutated != (len(resp.Patch) != 0) {
+			t.Fatalf("%v: desire mutated %v, get patch %s", c.name, c.mutated, resp.Patch)
+		}
+	}
+}
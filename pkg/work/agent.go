@@ -0,0 +1,462 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package work
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+// resyncPeriod is the period the Works are applied again, so that the objects changed or deleted in the lower
+// cluster are restored
+const resyncPeriod = 5 * time.Minute
+
+// resetter is implemented by the RESTMappers caching the discovery, e.g. DeferredDiscoveryRESTMapper
+type resetter interface {
+	Reset()
+}
+
+// Agent applies the Works in a namespace of the upper cluster to the lower cluster and reports their status back,
+// the agent runs in the lower cluster, the upper cluster only needs to grant it the Works of the namespace
+type Agent struct {
+	upper     dynamic.Interface
+	lower     dynamic.Interface
+	mapper    meta.RESTMapper
+	namespace string
+	informer  cache.SharedIndexInformer
+	queue     workqueue.RateLimitingInterface
+}
+
+// NewAgent returns the agent applying the Works in the namespace of the upper cluster, the kinds of the manifests
+// are mapped to the resources of the lower cluster by mapper
+func NewAgent(upper, lower dynamic.Interface, mapper meta.RESTMapper, namespace string) *Agent {
+	a := &Agent{
+		upper:     upper,
+		lower:     lower,
+		mapper:    mapper,
+		namespace: namespace,
+		informer: dynamicinformer.NewFilteredDynamicSharedInformerFactory(upper, resyncPeriod, namespace,
+			nil).ForResource(Resource).Informer(),
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second,
+			30*time.Second), "vk work agent"),
+	}
+	a.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: a.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, okOld := oldObj.(*unstructured.Unstructured)
+			cur, okCur := newObj.(*unstructured.Unstructured)
+			// the status reported by the agent itself does not change the generation, resyncs keep the
+			// resource version
+			if okOld && okCur && old.GetResourceVersion() != cur.GetResourceVersion() &&
+				old.GetGeneration() == cur.GetGeneration() && cur.GetDeletionTimestamp() == nil {
+				return
+			}
+			a.enqueue(newObj)
+		},
+	})
+	return a
+}
+
+// Run applies the Works until stopCh closed
+func (a *Agent) Run(workers int, stopCh <-chan struct{}) {
+	defer a.queue.ShutDown()
+	klog.Infof("Starting work agent of namespace %v", a.namespace)
+	defer klog.Infof("Shutting work agent of namespace %v", a.namespace)
+	go a.informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, a.informer.HasSynced) {
+		klog.Errorf("Cannot sync Works of namespace %v", a.namespace)
+		return
+	}
+	for i := 0; i < workers; i++ {
+		go wait.Until(a.worker, 0, stopCh)
+	}
+	<-stopCh
+}
+
+func (a *Agent) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	a.queue.Add(key)
+}
+
+func (a *Agent) worker() {
+	for a.processNextWork() {
+	}
+}
+
+func (a *Agent) processNextWork() bool {
+	key, quit := a.queue.Get()
+	if quit {
+		return false
+	}
+	defer a.queue.Done(key)
+	if err := a.sync(context.TODO(), key.(string)); err != nil {
+		klog.Errorf("Apply Work %v failed: %v", key, err)
+		a.queue.AddRateLimited(key)
+		return true
+	}
+	a.queue.Forget(key)
+	return true
+}
+
+// sync applies the manifests of the Work, prunes the objects removed from them and reports the status, the objects
+// applied are deleted once the Work deleted
+func (a *Agent) sync(ctx context.Context, key string) error {
+	obj, exists, err := a.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	u := obj.(*unstructured.Unstructured).DeepCopy()
+	work, err := decode(u)
+	if err != nil {
+		klog.Errorf("Skip Work %v: %v", key, err)
+		return nil
+	}
+	if work.DeletionTimestamp != nil {
+		if !hasFinalizer(u) {
+			return nil
+		}
+		if err = a.deleteAll(ctx, key, work); err != nil {
+			return err
+		}
+		return a.setFinalizer(ctx, u, false)
+	}
+	if !hasFinalizer(u) {
+		if err = a.setFinalizer(ctx, u, true); err != nil {
+			return err
+		}
+	}
+
+	var applied []AppliedResource
+	var errs []error
+	resolved := true
+	for i, raw := range work.Spec.Manifests {
+		resource, err := a.resolve(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("manifest %d: %v", i, err))
+			resolved = false
+			continue
+		}
+		applied = append(applied, resource.AppliedResource)
+		if err = a.apply(ctx, key, resource); err != nil {
+			errs = append(errs, fmt.Errorf("manifest %d %v: %v", i, resource.AppliedResource, err))
+		}
+	}
+	current := make(map[AppliedResource]struct{}, len(applied))
+	for _, resource := range applied {
+		current[resource] = struct{}{}
+	}
+	for _, resource := range work.Status.AppliedResources {
+		if _, ok := current[resource]; ok {
+			continue
+		}
+		// the manifests failed to resolve may be the objects applied before
+		if !resolved {
+			applied = append(applied, resource)
+			continue
+		}
+		if err = a.delete(ctx, key, resource); err != nil {
+			errs = append(errs, fmt.Errorf("prune %v: %v", resource, err))
+			applied = append(applied, resource)
+		}
+	}
+
+	status := WorkStatus{
+		ObservedGeneration: work.Generation,
+		Conditions:         work.Status.Conditions,
+		AppliedResources:   applied,
+	}
+	condition := WorkCondition{Type: ConditionApplied, Status: corev1.ConditionTrue, Reason: "Applied"}
+	if err = utilerrors.NewAggregate(errs); err != nil {
+		condition = WorkCondition{Type: ConditionApplied, Status: corev1.ConditionFalse, Reason: "ApplyFailed",
+			Message: err.Error()}
+	}
+	status.Conditions = setCondition(status.Conditions, condition)
+	if statusErr := a.updateStatus(ctx, u, &work.Status, &status); statusErr != nil {
+		return statusErr
+	}
+	return err
+}
+
+// manifest is a manifest of a Work with its resource in the lower cluster
+type manifest struct {
+	AppliedResource
+	object *unstructured.Unstructured
+}
+
+// resolve decodes the manifest and maps its kind to the resource of the lower cluster
+func (a *Agent) resolve(raw runtime.RawExtension) (*manifest, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+		return nil, fmt.Errorf("invalid object: %v", err)
+	}
+	if len(obj.GetName()) == 0 {
+		return nil, fmt.Errorf("object of %v without name", obj.GroupVersionKind())
+	}
+	gvk := obj.GroupVersionKind()
+	mapping, err := a.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		// the kinds served by custom resources created later are discovered again
+		if r, ok := a.mapper.(resetter); ok && meta.IsNoMatchError(err) {
+			r.Reset()
+		}
+		return nil, err
+	}
+	m := &manifest{
+		AppliedResource: AppliedResource{Group: mapping.Resource.Group, Version: mapping.Resource.Version,
+			Resource: mapping.Resource.Resource, Name: obj.GetName()},
+		object: obj,
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if len(obj.GetNamespace()) == 0 {
+			obj.SetNamespace(metav1.NamespaceDefault)
+		}
+		m.Namespace = obj.GetNamespace()
+	} else {
+		obj.SetNamespace("")
+	}
+	return m, nil
+}
+
+// apply creates the object of the manifest in the lower cluster, or merges the manifest into the object applied
+// by the Work before, the fields set in the lower cluster, e.g. clusterIP of services, are kept
+func (a *Agent) apply(ctx context.Context, owner string, m *manifest) error {
+	client := a.lower.Resource(m.GroupVersionResource()).Namespace(m.Namespace)
+	current, err := client.Get(ctx, m.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		desired := m.object.DeepCopy()
+		for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "generation", "selfLink",
+			"managedFields", "ownerReferences"} {
+			unstructured.RemoveNestedField(desired.Object, "metadata", field)
+		}
+		unstructured.RemoveNestedField(desired.Object, "status")
+		desired.SetAnnotations(merge(desired.GetAnnotations(), map[string]string{Annotation: owner}))
+		if _, err = client.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		klog.V(4).Infof("Create %v of Work %v success", m.AppliedResource, owner)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.GetAnnotations()[Annotation] != owner {
+		return fmt.Errorf("object exists and is not applied by the Work")
+	}
+	updated := current.DeepCopy()
+	updated.SetLabels(merge(current.GetLabels(), m.object.GetLabels()))
+	updated.SetAnnotations(merge(current.GetAnnotations(), m.object.GetAnnotations()))
+	for field, value := range m.object.Object {
+		switch field {
+		case "apiVersion", "kind", "metadata", "status":
+			continue
+		}
+		updated.Object[field] = mergeValue(updated.Object[field], value)
+	}
+	if equality.Semantic.DeepEqual(current, updated) {
+		return nil
+	}
+	if _, err = client.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.V(4).Infof("Update %v of Work %v success", m.AppliedResource, owner)
+	return nil
+}
+
+// delete deletes the object applied by the Work from the lower cluster, the objects not applied by it are left
+func (a *Agent) delete(ctx context.Context, owner string, resource AppliedResource) error {
+	client := a.lower.Resource(resource.GroupVersionResource()).Namespace(resource.Namespace)
+	current, err := client.Get(ctx, resource.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.GetAnnotations()[Annotation] != owner {
+		klog.Warningf("Skip deleting %v, it is not applied by Work %v", resource, owner)
+		return nil
+	}
+	// the object re-created since got is left alone
+	uid := current.GetUID()
+	propagation := metav1.DeletePropagationBackground
+	err = client.Delete(ctx, resource.Name, metav1.DeleteOptions{
+		Preconditions:     &metav1.Preconditions{UID: &uid},
+		PropagationPolicy: &propagation,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	klog.V(4).Infof("Delete %v of Work %v success", resource, owner)
+	return nil
+}
+
+// deleteAll deletes the objects applied by the deleted Work, including the ones of its manifests, whose status may
+// have failed to be reported
+func (a *Agent) deleteAll(ctx context.Context, owner string, work *Work) error {
+	resources := append([]AppliedResource{}, work.Status.AppliedResources...)
+	for _, raw := range work.Spec.Manifests {
+		if m, err := a.resolve(raw); err == nil {
+			resources = append(resources, m.AppliedResource)
+		}
+	}
+	var errs []error
+	deleted := map[AppliedResource]struct{}{}
+	for _, resource := range resources {
+		if _, ok := deleted[resource]; ok {
+			continue
+		}
+		deleted[resource] = struct{}{}
+		if err := a.delete(ctx, owner, resource); err != nil {
+			errs = append(errs, fmt.Errorf("delete %v: %v", resource, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// setFinalizer adds or removes the finalizer of the Work, u is updated with the Work returned
+func (a *Agent) setFinalizer(ctx context.Context, u *unstructured.Unstructured, add bool) error {
+	updated := u.DeepCopy()
+	var finalizers []string
+	for _, finalizer := range u.GetFinalizers() {
+		if finalizer != Finalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	if add {
+		finalizers = append(finalizers, Finalizer)
+	}
+	updated.SetFinalizers(finalizers)
+	result, err := a.upper.Resource(Resource).Namespace(u.GetNamespace()).Update(ctx, updated,
+		metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	*u = *result
+	return nil
+}
+
+// updateStatus reports the status of the Work if changed
+func (a *Agent) updateStatus(ctx context.Context, u *unstructured.Unstructured, old, status *WorkStatus) error {
+	if equality.Semantic.DeepEqual(old, status) {
+		return nil
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	value := map[string]interface{}{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	updated := u.DeepCopy()
+	updated.Object["status"] = value
+	_, err = a.upper.Resource(Resource).Namespace(u.GetNamespace()).UpdateStatus(ctx, updated,
+		metav1.UpdateOptions{})
+	return err
+}
+
+// setCondition replaces the condition of the same type, the transition time is kept if the status is not changed
+func setCondition(conditions []WorkCondition, condition WorkCondition) []WorkCondition {
+	var result []WorkCondition
+	condition.LastTransitionTime = metav1.Now()
+	for _, c := range conditions {
+		if c.Type != condition.Type {
+			result = append(result, c)
+			continue
+		}
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+	return append(result, condition)
+}
+
+// merge returns the labels or annotations of current overridden by desired
+func merge(current, desired map[string]string) map[string]string {
+	if len(desired) == 0 {
+		return current
+	}
+	result := make(map[string]string, len(current)+len(desired))
+	for k, v := range current {
+		result[k] = v
+	}
+	for k, v := range desired {
+		result[k] = v
+	}
+	return result
+}
+
+// mergeValue merges the desired value into the current one, the fields of objects only set in current are kept,
+// the other values, including lists, are replaced by desired
+func mergeValue(current, desired interface{}) interface{} {
+	desiredMap, ok := desired.(map[string]interface{})
+	if !ok {
+		return runtime.DeepCopyJSONValue(desired)
+	}
+	currentMap, ok := current.(map[string]interface{})
+	if !ok {
+		return runtime.DeepCopyJSONValue(desired)
+	}
+	for k, v := range desiredMap {
+		currentMap[k] = mergeValue(currentMap[k], v)
+	}
+	return currentMap
+}
+
+func hasFinalizer(u *unstructured.Unstructured) bool {
+	for _, finalizer := range u.GetFinalizers() {
+		if finalizer == Finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// decode converts the unstructured object to Work by json
+func decode(u *unstructured.Unstructured) (*Work, error) {
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, err
+	}
+	work := &Work{}
+	if err = json.Unmarshal(data, work); err != nil {
+		return nil, err
+	}
+	return work, nil
+}
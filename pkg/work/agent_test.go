@@ -0,0 +1,223 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package work
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var (
+	configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	namespaces = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+)
+
+func TestAgentSync(t *testing.T) {
+	ctx := context.TODO()
+	work := newWork(configMap("app", "a", "1"), map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "team"},
+	})
+	upper := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), work)
+	lower := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	a := newTestAgent(upper, lower)
+
+	if err := a.informer.GetIndexer().Add(work); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.sync(ctx, "cluster-a/apps"); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := lower.Resource(configMaps).Namespace(metav1.NamespaceDefault).Get(ctx, "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.GetAnnotations()[Annotation] != "cluster-a/apps" {
+		t.Fatalf("Desire annotation %v of the Work, get %v", Annotation, cm.GetAnnotations())
+	}
+	if _, err = lower.Resource(namespaces).Get(ctx, "team", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	current := getWork(t, upper)
+	if !hasFinalizer(current) {
+		t.Fatalf("Desire finalizer %v, get %v", Finalizer, current.GetFinalizers())
+	}
+	checkStatus(t, current, corev1.ConditionTrue, 2)
+
+	// the fields set in the lower cluster are kept, the namespace removed from the manifests is pruned
+	if err = unstructured.SetNestedField(cm.Object, "2", "data", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lower.Resource(configMaps).Namespace(metav1.NamespaceDefault).Update(ctx, cm,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	current.Object["spec"] = map[string]interface{}{"manifests": []interface{}{configMap("app", "a", "2")}}
+	current.SetGeneration(2)
+	updateWork(t, a, upper, current)
+	if err = a.sync(ctx, "cluster-a/apps"); err != nil {
+		t.Fatal(err)
+	}
+	cm, err = lower.Resource(configMaps).Namespace(metav1.NamespaceDefault).Get(ctx, "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, _ := unstructured.NestedStringMap(cm.Object, "data")
+	if data["a"] != "2" || data["b"] != "2" {
+		t.Fatalf("Desire data a=2 and b=2, get %v", data)
+	}
+	if _, err = lower.Resource(namespaces).Get(ctx, "team", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("Desire namespace team pruned, get %v", err)
+	}
+	current = getWork(t, upper)
+	checkStatus(t, current, corev1.ConditionTrue, 1)
+
+	// the objects applied are deleted before the finalizer removed
+	now := metav1.Now()
+	current.SetDeletionTimestamp(&now)
+	updateWork(t, a, upper, current)
+	if err = a.sync(ctx, "cluster-a/apps"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = lower.Resource(configMaps).Namespace(metav1.NamespaceDefault).Get(ctx, "app", metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Desire configMap app deleted, get %v", err)
+	}
+	if current = getWork(t, upper); hasFinalizer(current) {
+		t.Fatalf("Desire finalizer %v removed, get %v", Finalizer, current.GetFinalizers())
+	}
+}
+
+func TestAgentSyncNotOwned(t *testing.T) {
+	ctx := context.TODO()
+	work := newWork(configMap("app", "a", "1"))
+	existing := &unstructured.Unstructured{Object: configMap("app", "a", "0")}
+	existing.SetNamespace(metav1.NamespaceDefault)
+	upper := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), work)
+	lower := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), existing)
+	a := newTestAgent(upper, lower)
+
+	if err := a.informer.GetIndexer().Add(work); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.sync(ctx, "cluster-a/apps"); err == nil {
+		t.Fatal("Desire error applying configMap not applied by the Work")
+	}
+	cm, err := lower.Resource(configMaps).Namespace(metav1.NamespaceDefault).Get(ctx, "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _, _ := unstructured.NestedStringMap(cm.Object, "data"); data["a"] != "0" {
+		t.Fatalf("Desire configMap not owned left alone, get data %v", data)
+	}
+	checkStatus(t, getWork(t, upper), corev1.ConditionFalse, 1)
+}
+
+func TestMergeValue(t *testing.T) {
+	current := map[string]interface{}{
+		"clusterIP": "10.0.0.1",
+		"ports":     []interface{}{map[string]interface{}{"port": int64(80)}},
+		"selector":  map[string]interface{}{"app": "a"},
+	}
+	desired := map[string]interface{}{
+		"ports":    []interface{}{map[string]interface{}{"port": int64(8080)}},
+		"selector": map[string]interface{}{"app": "b"},
+	}
+	merged := mergeValue(current, desired).(map[string]interface{})
+	if merged["clusterIP"] != "10.0.0.1" {
+		t.Fatalf("Desire clusterIP kept, get %v", merged)
+	}
+	if port, _, _ := unstructured.NestedSlice(merged, "ports"); port[0].(map[string]interface{})["port"] != int64(8080) {
+		t.Fatalf("Desire ports replaced, get %v", merged)
+	}
+	if app, _, _ := unstructured.NestedString(merged, "selector", "app"); app != "b" {
+		t.Fatalf("Desire selector merged, get %v", merged)
+	}
+}
+
+func newTestAgent(upper, lower *dynamicfake.FakeDynamicClient) *Agent {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return NewAgent(upper, lower, mapper, "cluster-a")
+}
+
+func newWork(manifests ...map[string]interface{}) *unstructured.Unstructured {
+	var items []interface{}
+	for _, manifest := range manifests {
+		items = append(items, manifest)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tensile-kube.io/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "apps", "namespace": "cluster-a", "generation": int64(1)},
+		"spec":       map[string]interface{}{"manifests": items},
+	}}
+}
+
+func configMap(name, key, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name},
+		"data":       map[string]interface{}{key: value},
+	}
+}
+
+func getWork(t *testing.T, upper *dynamicfake.FakeDynamicClient) *unstructured.Unstructured {
+	u, err := upper.Resource(Resource).Namespace("cluster-a").Get(context.TODO(), "apps", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func updateWork(t *testing.T, a *Agent, upper *dynamicfake.FakeDynamicClient, u *unstructured.Unstructured) {
+	if _, err := upper.Resource(Resource).Namespace("cluster-a").Update(context.TODO(), u,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.informer.GetIndexer().Update(u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkStatus(t *testing.T, u *unstructured.Unstructured, status corev1.ConditionStatus, applied int) {
+	work, err := decode(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.Status.ObservedGeneration != work.Generation {
+		t.Fatalf("Desire observed generation %v, get %v", work.Generation, work.Status.ObservedGeneration)
+	}
+	if len(work.Status.Conditions) != 1 || work.Status.Conditions[0].Status != status {
+		t.Fatalf("Desire condition %v of status %v, get %+v", ConditionApplied, status, work.Status.Conditions)
+	}
+	if len(work.Status.AppliedResources) != applied {
+		t.Fatalf("Desire %v applied resources, get %+v", applied, work.Status.AppliedResources)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright ©2020. The virtual-kubelet authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package work applies the Works queued in the upper cluster to the lower cluster, the agent runs in the lower
+// cluster and only pulls from the upper one, so the lower cluster never needs to be reachable from the upper one
+package work
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resource is the resource of the namespaced Work, see manifeasts/work-crd.yaml
+var Resource = schema.GroupVersionResource{Group: "tensile-kube.io", Version: "v1alpha1", Resource: "works"}
+
+const (
+	// Finalizer is added to Works applied, the objects applied are deleted from the lower cluster before the
+	// Work is removed
+	Finalizer = "tensile-kube.io/work"
+	// Annotation is added to the objects applied in the lower cluster with the <namespace>/<name> of their Work,
+	// objects not annotated by the Work are never updated or deleted by it
+	Annotation = "tensile-kube.io/work"
+	// ConditionApplied tells whether all the manifests of the Work are applied
+	ConditionApplied = "Applied"
+)
+
+// Work queues the objects to apply in a lower cluster, the Works of a lower cluster are in its own namespace of the
+// upper cluster, so that its agent is granted only the namespace
+type Work struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WorkSpec   `json:"spec"`
+	Status            WorkStatus `json:"status,omitempty"`
+}
+
+// WorkSpec is the desired state of the lower cluster
+type WorkSpec struct {
+	// Manifests are the objects to create or update in the lower cluster, the objects removed from them are
+	// deleted. Namespaced objects without namespace are applied in namespace default
+	Manifests []runtime.RawExtension `json:"manifests,omitempty"`
+}
+
+// WorkStatus is reported by the agent of the lower cluster
+type WorkStatus struct {
+	// ObservedGeneration is the generation of the spec last applied
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the conditions of the Work, i.e. Applied
+	Conditions []WorkCondition `json:"conditions,omitempty"`
+	// AppliedResources are the objects applied in the lower cluster, they are deleted once removed from the
+	// manifests or the Work deleted
+	AppliedResources []AppliedResource `json:"appliedResources,omitempty"`
+}
+
+// WorkCondition is a condition of the Work
+type WorkCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// AppliedResource is an object applied in the lower cluster
+type AppliedResource struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// GroupVersionResource returns the resource of the object
+func (r AppliedResource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+func (r AppliedResource) String() string {
+	resource := r.Resource + "." + r.Version
+	if len(r.Group) != 0 {
+		resource += "." + r.Group
+	}
+	if len(r.Namespace) == 0 {
+		return resource + "/" + r.Name
+	}
+	return resource + "/" + r.Namespace + "/" + r.Name
+}